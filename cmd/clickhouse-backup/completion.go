@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/mxalis/clickhouse-backup/pkg/backup"
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/urfave/cli"
+)
+
+// completion scripts call clickhouse-backup with all typed words and hidden --generate-bash-completion flag, the same contract as github.com/urfave/cli/autocomplete
+var completionScripts = map[string]string{
+	"bash": `# bash completion for clickhouse-backup, add to ~/.bashrc: source <(clickhouse-backup completion bash)
+_clickhouse_backup_completion() {
+  local cur opts
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  if [[ "$cur" == "-"* ]]; then
+    opts=$( "${COMP_WORDS[@]:0:$COMP_CWORD}" "${cur}" --generate-bash-completion 2>/dev/null )
+  else
+    opts=$( "${COMP_WORDS[@]:0:$COMP_CWORD}" --generate-bash-completion 2>/dev/null )
+  fi
+  COMPREPLY=( $(compgen -W "${opts}" -- "${cur}") )
+  return 0
+}
+complete -o bashdefault -o default -F _clickhouse_backup_completion clickhouse-backup
+`,
+	"zsh": `#compdef clickhouse-backup
+# zsh completion for clickhouse-backup, add to ~/.zshrc: source <(clickhouse-backup completion zsh)
+_clickhouse_backup_completion() {
+  local -a opts
+  local cur
+  cur=${words[-1]}
+  if [[ "$cur" == "-"* ]]; then
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} "${cur}" --generate-bash-completion 2>/dev/null)}")
+  else
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} --generate-bash-completion 2>/dev/null)}")
+  fi
+  if [[ "${opts[1]}" != "" ]]; then
+    compadd -a opts
+  else
+    _files
+  fi
+}
+compdef _clickhouse_backup_completion clickhouse-backup
+`,
+	"fish": `# fish completion for clickhouse-backup, save to ~/.config/fish/completions/clickhouse-backup.fish
+function __clickhouse_backup_completion
+    set -l args (commandline -opc)
+    set -l cur (commandline -ct)
+    if string match -q -- '-*' $cur
+        $args $cur --generate-bash-completion 2>/dev/null
+    else
+        $args --generate-bash-completion 2>/dev/null
+    end
+end
+complete -c clickhouse-backup -f -a '(__clickhouse_backup_completion)'
+`,
+}
+
+var completionCommand = cli.Command{
+	Name:      "completion",
+	Usage:     "Print shell completion script",
+	UsageText: "clickhouse-backup completion <bash|zsh|fish>",
+	Description: "Completion complete commands, flags, local and remote backup names and table names for --tables, " +
+		"backup and table names are read with current config",
+	Action: func(c *cli.Context) error {
+		script, exists := completionScripts[c.Args().First()]
+		if !exists {
+			return fmt.Errorf("unknown shell '%s', allowed values: bash, zsh, fish", c.Args().First())
+		}
+		_, err := fmt.Fprint(c.App.Writer, script)
+		return err
+	},
+	BashComplete: completeWords("bash", "zsh", "fish"),
+}
+
+// setupCompletion - enable --generate-bash-completion and define positional arguments completion for each command
+func setupCompletion(app *cli.App) {
+	app.EnableBashCompletion = true
+	app.Commands = append(app.Commands, completionCommand)
+	localBackup := completeBackupNames(func(c *cli.Context) string { return "local" }, 1)
+	remoteBackup := completeBackupNames(func(c *cli.Context) string { return "remote" }, 1)
+	byRemoteFlag := func(c *cli.Context) string {
+		if c.Bool("remote") {
+			return "remote"
+		}
+		return "local"
+	}
+	completions := map[string]cli.BashCompleteFunc{
+		"upload":         localBackup,
+		"restore":        localBackup,
+		"download":       remoteBackup,
+		"restore_remote": remoteBackup,
+		"copy":           remoteBackup,
+		"verify":         completeBackupNames(byRemoteFlag, 1),
+		"diff":           completeBackupNames(byRemoteFlag, 2),
+		"list": func(c *cli.Context) {
+			if c.NArg() == 0 {
+				completeWords("all", "local", "remote")(c)
+			} else if c.NArg() == 1 {
+				completeWords("latest", "penult")(c)
+			}
+		},
+		"delete": func(c *cli.Context) {
+			if c.NArg() == 0 {
+				completeWords("local", "remote")(c)
+				return
+			}
+			completeBackupNames(func(c *cli.Context) string { return c.Args().First() }, 2)(c)
+		},
+	}
+	for i := range app.Commands {
+		cmd := &app.Commands[i]
+		if complete, exists := completions[cmd.Name]; exists {
+			cmd.BashComplete = complete
+		} else if cmd.BashComplete == nil {
+			cmd.BashComplete = completeWords()
+		}
+	}
+}
+
+// completeWords - print flags when current word starts with "-", table names after --tables, words otherwise
+func completeWords(words ...string) cli.BashCompleteFunc {
+	return func(c *cli.Context) {
+		if isTablesFlag(completionPrevArg()) {
+			printCompletionTables(c)
+			return
+		}
+		if strings.HasPrefix(completionPrevArg(), "-") {
+			cli.DefaultCompleteWithFlags(&c.Command)(c)
+			return
+		}
+		for _, word := range words {
+			fmt.Fprintln(c.App.Writer, word)
+		}
+	}
+}
+
+// completeBackupNames - print local or remote backup names until maxArgs positional arguments typed
+func completeBackupNames(location func(c *cli.Context) string, maxArgs int) cli.BashCompleteFunc {
+	return func(c *cli.Context) {
+		if isTablesFlag(completionPrevArg()) || strings.HasPrefix(completionPrevArg(), "-") || c.NArg() >= maxArgs {
+			completeWords()(c)
+			return
+		}
+		cfg := loadCompletionConfig(c)
+		if cfg == nil {
+			return
+		}
+		switch location(c) {
+		case "local":
+			backups, _, err := backup.GetLocalBackups(cfg, nil)
+			if err != nil {
+				return
+			}
+			for _, b := range backups {
+				fmt.Fprintln(c.App.Writer, b.BackupName)
+			}
+		case "remote":
+			backups, err := backup.GetRemoteBackups(context.Background(), cfg, false)
+			if err != nil {
+				return
+			}
+			for _, b := range backups {
+				fmt.Fprintln(c.App.Writer, b.BackupName)
+			}
+		}
+	}
+}
+
+func printCompletionTables(c *cli.Context) {
+	cfg := loadCompletionConfig(c)
+	if cfg == nil {
+		return
+	}
+	ch := &clickhouse.ClickHouse{
+		Config: &cfg.ClickHouse,
+	}
+	if err := ch.Connect(); err != nil {
+		return
+	}
+	defer ch.Close()
+	tables, err := ch.GetTables("")
+	if err != nil {
+		return
+	}
+	for _, table := range tables {
+		if !table.Skip {
+			fmt.Fprintf(c.App.Writer, "%s.%s\n", table.Database, table.Name)
+		}
+	}
+}
+
+// loadCompletionConfig - completion output is parsed by shell, so config errors and logs are discarded
+func loadCompletionConfig(c *cli.Context) *config.Config {
+	cfg, err := config.LoadConfigWithOverrides(config.GetConfigPath(c), config.GetConfigOverrides(c), config.GetLoadOptions(c))
+	log.SetHandler(discard.Default)
+	if err != nil {
+		return nil
+	}
+	return cfg
+}
+
+// completionPrevArg - word before current word, --generate-bash-completion is always last
+func completionPrevArg() string {
+	if len(os.Args) < 3 {
+		return ""
+	}
+	return os.Args[len(os.Args)-2]
+}
+
+func isTablesFlag(arg string) bool {
+	return arg == "-t" || arg == "--table" || arg == "--tables"
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/errtracker"
 	"github.com/mxalis/clickhouse-backup/pkg/logcli"
+	"github.com/mxalis/clickhouse-backup/pkg/metrics"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/mxalis/clickhouse-backup/pkg/backup"
 	"github.com/mxalis/clickhouse-backup/pkg/server"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
 
 	"github.com/apex/log"
 	"github.com/urfave/cli"
@@ -21,6 +28,9 @@ var (
 
 func main() {
 	log.SetHandler(logcli.New(os.Stdout))
+	// SIGTERM and Ctrl+C abort running command, unfinished multipart uploads are aborted on remote storage
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	cliapp := cli.NewApp()
 	cliapp.Name = "clickhouse-backup"
 	cliapp.Usage = "Tool for easy backup of ClickHouse with cloud support"
@@ -35,6 +45,20 @@ func main() {
 			Usage:  "Config `FILE` name.",
 			EnvVar: "CLICKHOUSE_BACKUP_CONFIG",
 		},
+		cli.BoolFlag{
+			Name:   "allow-unknown",
+			Usage:  "Ignore unknown keys of config file instead of error",
+			EnvVar: "CLICKHOUSE_BACKUP_ALLOW_UNKNOWN",
+		},
+		cli.StringFlag{
+			Name:   "env-prefix",
+			Usage:  "Apply only environment variables with `PREFIX`_ prefix to config, for example CHB1_S3_BUCKET, to run several instances on one host",
+			EnvVar: "CLICKHOUSE_BACKUP_ENV_PREFIX",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "Override config value in `section.key=value` format, for example --set s3.bucket=other-bucket, repeat flag for several values",
+		},
 	}
 	cliapp.CommandNotFound = func(c *cli.Context, command string) {
 		fmt.Printf("Error. Unknown command: '%s'\n\n", command)
@@ -51,26 +75,74 @@ func main() {
 		{
 			Name:      "tables",
 			Usage:     "Print list of tables",
-			UsageText: "clickhouse-backup tables",
+			UsageText: "clickhouse-backup tables [-a, --all] [--backup=<backup_name> [--remote]] [--format=text|json|yaml|tsv]",
+			Description: "Print engine, total size, total rows, disks and skip_tables match for each table, " +
+				"with --backup tables are read from local or remote backup metadata, rows count is not stored in backup",
 			Action: func(c *cli.Context) error {
-				return backup.PrintTables(config.GetConfig(c), c.Bool("a"))
+				if c.String("backup") != "" {
+					return backup.PrintBackupTables(ctx, getOutputConfig(c), c.String("backup"), c.Bool("remote"), c.Bool("a"), c.String("format"))
+				}
+				return backup.PrintTables(getOutputConfig(c), c.Bool("a"), c.String("format"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.BoolFlag{
 					Name:   "all, a",
 					Hidden: false,
+					Usage:  "Print tables which match skip_tables too",
+				},
+				cli.StringFlag{
+					Name:   "backup",
+					Hidden: false,
+					Usage:  "Print tables stored in backup instead of tables from ClickHouse",
+				},
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Read --backup from remote storage",
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "text",
+					Hidden: false,
+					Usage:  "Output format: text, json, yaml, tsv",
 				},
 			),
 		},
 		{
-			Name:        "create",
-			Usage:       "Create new backup",
-			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] <backup_name>",
-			Description: "Create new backup",
+			Name:      "estimate",
+			Usage:     "Estimate backup size and duration",
+			UsageText: "clickhouse-backup estimate [-t, --tables=<db>.<table>] [--partitions=<partition_names>]",
+			Description: "Print size, uncompressed size, expected compressed size and archives count for each table from system.parts, " +
+				"duration is estimated by median throughput of latest remote backups",
 			Action: func(c *cli.Context) error {
-				return backup.CreateBackup(config.GetConfig(c), c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), version)
+				return backup.Estimate(ctx, config.GetConfig(c), c.String("t"), c.StringSlice("partitions"))
 			},
 			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
+				},
+			),
+		},
+		{
+			Name:        "create",
+			Usage:       "Create new backup",
+			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] [--tag=<key>=<value>] [--description=<text>] <backup_name>",
+			Description: "Create new backup",
+			Action: instrument("create", func(c *cli.Context) error {
+				cfg, err := getCreateConfig(c)
+				if err != nil {
+					return err
+				}
+				return backup.CreateBackup(ctx, cfg, c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), version)
+			}),
+			Flags: append(append(cliapp.Flags, backupTagFlags...),
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Hidden: false,
@@ -79,7 +151,7 @@ func main() {
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
-					Usage:  "partition names, separated by comma",
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
 				},
 				cli.BoolFlag{
 					Name:   "schema, s",
@@ -101,13 +173,17 @@ func main() {
 		{
 			Name:        "create_remote",
 			Usage:       "Create and upload",
-			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] <backup_name>",
+			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--tag=<key>=<value>] [--description=<text>] <backup_name>",
 			Description: "Create and upload",
-			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfig(c))
-				return b.CreateToRemote(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), version)
-			},
-			Flags: append(cliapp.Flags,
+			Action: instrument("create_remote", func(c *cli.Context) error {
+				cfg, err := getCreateConfig(c)
+				if err != nil {
+					return err
+				}
+				b := backup.NewBackuper(cfg)
+				return b.CreateToRemote(ctx, c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), version)
+			}),
+			Flags: append(append(cliapp.Flags, backupTagFlags...),
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
@@ -116,7 +192,7 @@ func main() {
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
-					Usage:  "partition names, separated by comma",
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
 				},
 				cli.StringFlag{
 					Name:   "diff-from",
@@ -148,11 +224,11 @@ func main() {
 		{
 			Name:      "upload",
 			Usage:     "Upload backup to remote storage",
-			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] <backup_name>",
-			Action: func(c *cli.Context) error {
+			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resume] <backup_name>",
+			Action: instrument("upload", func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfig(c))
-				return b.Upload(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"))
-			},
+				return b.Upload(ctx, c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"))
+			}),
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
 					Name:   "diff-from",
@@ -172,70 +248,120 @@ func main() {
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
-					Usage:  "partition names, separated by comma",
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
 				},
 				cli.BoolFlag{
 					Name:   "schema, s",
 					Hidden: false,
 					Usage:  "Upload schemas only",
 				},
+				cli.BoolFlag{
+					Name:   "resume, resumable",
+					Hidden: false,
+					Usage:  "Continue interrupted upload, skip files which already uploaded and continue unfinished S3 multipart uploads",
+				},
 			),
 		},
 		{
 			Name:      "list",
 			Usage:     "Print list of backups",
-			UsageText: "clickhouse-backup list [all|local|remote] [latest|penult]",
+			UsageText: "clickhouse-backup list [--format=text|json|yaml|tsv] [--tag=<key>=<value>] [all|local|remote] [latest|penult|<backup_name> --tables]",
+			Description: "With --tables print databases, tables, sizes, rows and partitions of one backup, " +
+				"only metadata is read, so remote backup contents can be checked before download",
 			Action: func(c *cli.Context) error {
-				cfg := config.GetConfig(c)
+				cfg := getOutputConfig(c)
+				if c.Bool("tables") {
+					switch c.Args().Get(0) {
+					case "local", "remote":
+						return backup.PrintBackupContents(ctx, cfg, c.Args().Get(1), c.Args().Get(0) == "remote", c.String("format"))
+					default:
+						log.Errorf("--tables requires 'local' or 'remote' and backup name")
+						cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+					}
+				}
+				tags, err := parseTagFlags(c)
+				if err != nil {
+					return err
+				}
 				switch c.Args().Get(0) {
 				case "local":
-					return backup.PrintLocalBackups(cfg, c.Args().Get(1))
+					return backup.PrintLocalBackups(cfg, c.Args().Get(1), c.String("format"), tags)
 				case "remote":
-					return backup.PrintRemoteBackups(cfg, c.Args().Get(1))
+					return backup.PrintRemoteBackups(ctx, cfg, c.Args().Get(1), c.String("format"), tags)
 				case "all", "":
-					return backup.PrintAllBackups(cfg, c.Args().Get(1))
+					return backup.PrintAllBackups(ctx, cfg, c.Args().Get(1), c.String("format"), tags)
 				default:
 					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
 				return nil
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "text",
+					Hidden: false,
+					Usage:  "Output format: text, json, yaml, tsv",
+				},
+				cli.StringSliceFlag{
+					Name:   "tag",
+					Hidden: false,
+					Usage:  "Print only backups with tag in `key=value` format, repeat flag to require several tags",
+				},
+				cli.BoolFlag{
+					Name:   "tables",
+					Hidden: false,
+					Usage:  "Print databases, tables and partitions of backup from its metadata without downloading data",
+				},
+			),
 		},
 		{
 			Name:      "download",
 			Usage:     "Download backup from remote storage",
-			UsageText: "clickhouse-backup download [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] <backup_name>",
-			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfig(c))
-				return b.Download(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"))
-			},
-			Flags: append(cliapp.Flags,
+			UsageText: "clickhouse-backup download [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--resume] [--target=<name>] [--target-host=<host>] [--target-port=<port>] [--target-user=<user>] <backup_name>",
+			Action: instrument("download", func(c *cli.Context) error {
+				cfg, err := getTargetConfig(c)
+				if err != nil {
+					return err
+				}
+				b := backup.NewBackuper(cfg)
+				return b.Download(ctx, c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"))
+			}),
+			Flags: append(append(cliapp.Flags,
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
 					Hidden: false,
 				},
-				cli.StringFlag{
+				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
-					Usage:  "partition names, separated by comma",
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
 				},
 				cli.BoolFlag{
 					Name:   "schema, s",
 					Hidden: false,
 					Usage:  "Download schema only",
 				},
-			),
+				cli.BoolFlag{
+					Name:   "resume, resumable",
+					Hidden: false,
+					Usage:  "Continue interrupted download, skip files which already downloaded and continue partially downloaded files after the last verified chunk",
+				},
+			), clickhouseTargetFlags...),
 		},
 		{
 			Name:      "restore",
 			Usage:     "Create schema and restore data from backup",
-			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [--rbac] [--configs] <backup_name>",
-			Action: func(c *cli.Context) error {
-				return backup.Restore(config.GetConfig(c), c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("rbac"), c.Bool("configs"))
-			},
-			Flags: append(cliapp.Flags,
+			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [--rbac] [--configs] [--dry-run] [--validate] [--restore-database-mapping=<src_db>:<target_db>] [--restore-table-mapping=<src_db>.<src_table>:<target_db>.<target_table>] [--restore-replicated-engine=merge_tree|replicated] [--on-cluster=<cluster>] [--target=<name>] [--target-host=<host>] [--target-port=<port>] [--target-user=<user>] <backup_name>",
+			Action: instrument("restore", func(c *cli.Context) error {
+				cfg, err := getRestoreConfig(c)
+				if err != nil {
+					return err
+				}
+				return backup.Restore(ctx, cfg, c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("rbac"), c.Bool("configs"), c.Bool("dry-run"))
+			}),
+			Flags: append(append(cliapp.Flags,
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
@@ -244,7 +370,7 @@ func main() {
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
-					Usage:  "partition names, separated by comma",
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
 				},
 				cli.BoolFlag{
 					Name:   "schema, s",
@@ -271,17 +397,253 @@ func main() {
 					Hidden: false,
 					Usage:  "Restore CONFIG related files only",
 				},
-			),
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print actions which restore will do and check prerequisites, nothing will be changed",
+				},
+				cli.BoolFlag{
+					Name:   "validate",
+					Hidden: false,
+					Usage:  "Compare count() of restored tables with rows of restored parts recorded during create, overrides general->restore_validate",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-database-mapping",
+					Hidden: false,
+					Usage:  "Restore tables of source database into target database, format `src_db:target_db`, comma separated or repeated, overrides general->restore_database_mapping",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Hidden: false,
+					Usage:  "Restore source table with another name, format `src_db.src_table:target_db.target_table` or `src_db.src_table:target_table`, comma separated or repeated, overrides general->restore_table_mapping",
+				},
+				cli.StringFlag{
+					Name:   "on-cluster",
+					Hidden: false,
+					Usage:  "Execute schema related queries with `ON CLUSTER` clause, schema is created on all hosts of cluster from system.clusters, per-host results are printed, overrides general->restore_schema_on_cluster",
+				},
+				cli.StringFlag{
+					Name:   "restore-replicated-engine",
+					Hidden: false,
+					Usage:  "Convert engines during schema restore, `merge_tree` strip Replicated from Replicated*MergeTree, `replicated` convert *MergeTree to Replicated*MergeTree with general->restore_replicated_zookeeper_path, overrides general->restore_replicated_engine",
+				},
+			), clickhouseTargetFlags...),
 		},
 		{
 			Name:      "restore_remote",
 			Usage:     "Download and restore",
-			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--rm, --drop] [--rbac] [--configs] [--skip-rbac] [--skip-configs] <backup_name>",
+			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--rm, --drop] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--validate] [--restore-database-mapping=<src_db>:<target_db>] [--restore-table-mapping=<src_db>.<src_table>:<target_db>.<target_table>] [--restore-replicated-engine=merge_tree|replicated] [--on-cluster=<cluster>] [--target=<name>] [--target-host=<host>] [--target-port=<port>] [--target-user=<user>] [--stream] <backup_name>",
+			Action: instrument("restore_remote", func(c *cli.Context) error {
+				cfg, err := getRestoreConfig(c)
+				if err != nil {
+					return err
+				}
+				b := backup.NewBackuper(cfg)
+				return b.RestoreFromRemote(ctx, c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("rbac"), c.Bool("configs"), c.Bool("stream"))
+			}),
+			Flags: append(append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
+				},
+				cli.BoolFlag{
+					Name:   "schema, s",
+					Hidden: false,
+					Usage:  "Restore schema only",
+				},
+				cli.BoolFlag{
+					Name:   "data, d",
+					Hidden: false,
+					Usage:  "Restore data only",
+				},
+				cli.BoolFlag{
+					Name:   "rm, drop",
+					Hidden: false,
+					Usage:  "Drop table before restore",
+				},
+				cli.BoolFlag{
+					Name:   "rbac, restore-rbac, do-restore-rbac",
+					Hidden: false,
+					Usage:  "Restore RBAC related objects only",
+				},
+				cli.BoolFlag{
+					Name:   "configs, restore-configs, do-restore-configs",
+					Hidden: false,
+					Usage:  "Restore CONFIG related files only",
+				},
+				cli.BoolFlag{
+					Name:   "validate",
+					Hidden: false,
+					Usage:  "Compare count() of restored tables with rows of restored parts recorded during create, overrides general->restore_validate",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-database-mapping",
+					Hidden: false,
+					Usage:  "Restore tables of source database into target database, format `src_db:target_db`, comma separated or repeated, overrides general->restore_database_mapping",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Hidden: false,
+					Usage:  "Restore source table with another name, format `src_db.src_table:target_db.target_table` or `src_db.src_table:target_table`, comma separated or repeated, overrides general->restore_table_mapping",
+				},
+				cli.StringFlag{
+					Name:   "on-cluster",
+					Hidden: false,
+					Usage:  "Execute schema related queries with `ON CLUSTER` clause, schema is created on all hosts of cluster from system.clusters, per-host results are printed, overrides general->restore_schema_on_cluster",
+				},
+				cli.StringFlag{
+					Name:   "restore-replicated-engine",
+					Hidden: false,
+					Usage:  "Convert engines during schema restore, `merge_tree` strip Replicated from Replicated*MergeTree, `replicated` convert *MergeTree to Replicated*MergeTree with general->restore_replicated_zookeeper_path, overrides general->restore_replicated_engine",
+				},
+				cli.BoolFlag{
+					Name:   "stream",
+					Hidden: false,
+					Usage:  "Extract data archives from remote storage directly into `detached` folder of tables, without full local copy of backup, incremental backups are not supported",
+				},
+			), clickhouseTargetFlags...),
+		},
+		{
+			Name:      "verify",
+			Usage:     "Check backup integrity without restore",
+			UsageText: "clickhouse-backup verify [--remote] [--format=text|json|yaml|tsv] <backup_name>",
+			Description: "Check metadata consistency, archives readability, size and hash of each data part file from part checksums.txt, logical parts and BACKUP statement result are checked with recorded checksums. " +
+				"Local backup is checked in backup folder, with --remote backup is read from remote storage without writing on local disk",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(getOutputConfig(c))
+				return b.Verify(ctx, c.Args().First(), c.Bool("remote"), c.String("format"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Verify backup stored on remote storage",
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "text",
+					Hidden: false,
+					Usage:  "Output format: text, json, yaml, tsv",
+				},
+			),
+		},
+		{
+			Name:      "remote-check",
+			Usage:     "Check connectivity and permissions of remote storage",
+			UsageText: "clickhouse-backup remote-check [--probe-size=<bytes>] [--format=text|json|yaml|tsv]",
+			Description: "Connect to general->remote_storage, write, stat, read, list and delete probe object in .clickhouse-backup-check folder, " +
+				"print latency, throughput and required permission of each request, fail when any request is failed",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(getOutputConfig(c))
+				return b.RemoteCheck(ctx, c.Int64("probe-size"), c.String("format"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.Int64Flag{
+					Name:   "probe-size",
+					Value:  16 * 1024 * 1024,
+					Hidden: false,
+					Usage:  "Size of probe object in bytes, throughput is measured on write and read of it",
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "text",
+					Hidden: false,
+					Usage:  "Output format: text, json, yaml, tsv",
+				},
+			),
+		},
+		{
+			Name:      "migrate-metadata",
+			Usage:     "Upgrade metadata of backup to current metadata version",
+			UsageText: "clickhouse-backup migrate-metadata [--remote] <backup_name>",
+			Description: "Set metadata_version in metadata.json of local or remote backup, detect data_format of remote backup, " +
+				"convert local backup of legacy layout with metadata/<db>/<table>.sql to current layout",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfig(c))
-				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("rbac"), c.Bool("configs"))
+				return b.MigrateMetadata(ctx, c.Args().First(), c.Bool("remote"), version)
 			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Migrate metadata.json of remote backup in place",
+				},
+			),
+		},
+		{
+			Name:      "diff",
+			Usage:     "Compare two backups",
+			UsageText: "clickhouse-backup diff [--remote] <backup_name_a> <backup_name_b>",
+			Description: "Print databases and tables added and removed, changed table schemas, " +
+				"parts and size deltas for each table from backup metadata, with --remote both backups are read from remote storage",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfig(c))
+				return b.Diff(ctx, c.Args().Get(0), c.Args().Get(1), c.Bool("remote"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Compare backups stored on remote storage",
+				},
+			),
+		},
+		{
+			Name:      "copy",
+			Usage:     "Copy backup between remote storages or inside the same remote storage with new name",
+			UsageText: "clickhouse-backup copy [--from=<remote_storage>] [--to=<remote_storage>] [--move] <backup_name> [<new_backup_name>]",
+			Description: "Stream all backup objects from one configured remote storage to another through this host, " +
+				"--from is general->remote_storage by default, metadata.json is copied last so interrupted copy is listed as broken, " +
+				"with <new_backup_name> and without --to backup is copied inside --from remote storage with server-side copy, --move renames it",
+			Action: instrument("copy", func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfig(c))
+				return b.Copy(ctx, c.Args().First(), c.Args().Get(1), c.String("from"), c.String("to"), c.Bool("move"))
+			}),
 			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "from",
+					Hidden: false,
+					Usage:  "Source remote storage: s3, gcs, azblob, cos, ftp, sftp, grpc",
+				},
+				cli.StringFlag{
+					Name:   "to",
+					Hidden: false,
+					Usage:  "Destination remote storage: s3, gcs, azblob, cos, ftp, sftp, grpc",
+				},
+				cli.BoolFlag{
+					Name:   "move",
+					Hidden: false,
+					Usage:  "Delete backup from source remote storage after successful copy",
+				},
+			),
+		},
+		{
+			Name:      "create_cluster",
+			Usage:     "Create and upload backup of each shard of cluster",
+			UsageText: "clickhouse-backup create_cluster --cluster=<cluster> [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--schema] [--rbac] [--configs] [--tag=<key>=<value>] [--description=<text>] [<backup_name>]",
+			Description: "Run create_remote through API of one replica of each shard from system.clusters, " +
+				"shard backups are named <backup_name>-shard<N>, <backup_name>/metadata.json on remote storage contains status of each shard",
+			Action: instrument("create_cluster", func(c *cli.Context) error {
+				cfg, err := getCreateConfig(c)
+				if err != nil {
+					return err
+				}
+				b := backup.NewBackuper(cfg)
+				return b.CreateCluster(ctx, c.Args().First(), c.String("cluster"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), version)
+			}),
+			Flags: append(append(cliapp.Flags, backupTagFlags...),
+				cli.StringFlag{
+					Name:     "cluster",
+					Hidden:   false,
+					Required: true,
+					Usage:    "Cluster name from system.clusters",
+				},
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
@@ -290,7 +652,51 @@ func main() {
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
-					Usage:  "partition names, separated by comma",
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
+				},
+				cli.BoolFlag{
+					Name:   "schema, s",
+					Hidden: false,
+					Usage:  "Schemas only",
+				},
+				cli.BoolFlag{
+					Name:   "rbac, backup-rbac, do-backup-rbac",
+					Hidden: false,
+					Usage:  "Backup RBAC related objects only",
+				},
+				cli.BoolFlag{
+					Name:   "configs, backup-configs, do-backup-configs",
+					Hidden: false,
+					Usage:  "Backup ClickHouse server configuration files only",
+				},
+			),
+		},
+		{
+			Name:      "restore_cluster",
+			Usage:     "Download and restore backup created by create_cluster",
+			UsageText: "clickhouse-backup restore_cluster --cluster=<cluster> [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--schema] [--data] [--rm, --drop] [--rbac] [--configs] <backup_name>",
+			Description: "Run restore_remote of shard backup through API of the first replica of each shard from system.clusters, " +
+				"other replicas of shard restore schema only and fetch data of replicated tables",
+			Action: instrument("restore_cluster", func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfig(c))
+				return b.RestoreCluster(ctx, c.Args().First(), c.String("cluster"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("rbac"), c.Bool("configs"))
+			}),
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:     "cluster",
+					Hidden:   false,
+					Required: true,
+					Usage:    "Cluster name from system.clusters",
+				},
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "partition IDs, separated by comma, `db.table:id1,id2` applies IDs to tables matched by pattern only, repeat flag for several tables",
 				},
 				cli.BoolFlag{
 					Name:   "schema, s",
@@ -333,7 +739,7 @@ func main() {
 				case "local":
 					return backup.RemoveBackupLocal(cfg, c.Args().Get(1), nil)
 				case "remote":
-					return backup.RemoveBackupRemote(cfg, c.Args().Get(1))
+					return backup.RemoveBackupRemote(ctx, cfg, c.Args().Get(1))
 				default:
 					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
@@ -352,7 +758,7 @@ func main() {
 		},
 		{
 			Name:  "print-config",
-			Usage: "Print current config",
+			Usage: "Print current config with masked secrets and source of each value",
 			Action: func(c *cli.Context) error {
 				return config.PrintConfig(c)
 			},
@@ -360,9 +766,14 @@ func main() {
 		},
 		{
 			Name:  "clean",
-			Usage: "Remove data in 'shadow' folder from all `path` folders available from `system.disks`",
+			Usage: "Remove data in 'shadow' folder from all `path` folders available from `system.disks` and incomplete local backups",
+			Description: "Frozen parts are released with SYSTEM UNFREEZE when supported, local backups without metadata.json left by interrupted create or download are deleted, " +
+				"reclaimed disk space is reported, don't run it during create or download",
 			Action: func(c *cli.Context) error {
-				return backup.Clean(config.GetConfig(c))
+				if err := backup.Clean(config.GetConfig(c)); err != nil {
+					return err
+				}
+				return backup.CleanBroken(config.GetConfig(c))
 			},
 			Flags: cliapp.Flags,
 		},
@@ -370,12 +781,187 @@ func main() {
 			Name:  "server",
 			Usage: "Run API server",
 			Action: func(c *cli.Context) error {
-				return server.Server(cliapp, config.GetConfigPath(c), version)
+				return server.Server(cliapp, config.GetConfigPath(c), config.GetConfigOverrides(c), config.GetLoadOptions(c), version)
 			},
 			Flags: cliapp.Flags,
 		},
 	}
+	setupCompletion(cliapp)
 	if err := cliapp.Run(os.Args); err != nil {
+		if backup.IsBackupSkipped(err) {
+			log.Info(err.Error())
+			return
+		}
 		log.Fatal(err.Error())
 	}
 }
+
+// getOutputConfig - load config, logs written to stdout are redirected to stderr when `--format` is not text to keep output parsable
+func getOutputConfig(c *cli.Context) *config.Config {
+	cfg := config.GetConfig(c)
+	if format := c.String("format"); format != "" && format != "text" && cfg.General.LogOutput == "stdout" {
+		cfg.General.LogOutput = "stderr"
+		if err := config.SetLogHandler(&cfg.General); err != nil {
+			log.Warnf("can't redirect logs to stderr: %v", err)
+		}
+	}
+	return cfg
+}
+
+// clickhouseTargetFlags - connection overrides for commands which could work with another ClickHouse server than defined in `clickhouse` config section
+var clickhouseTargetFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "target",
+		Hidden: false,
+		Usage:  "Use ClickHouse connection from clickhouse_targets->`name` config section, keys absent in this section are used from clickhouse section",
+	},
+	cli.StringFlag{
+		Name:   "target-host",
+		Hidden: false,
+		Usage:  "Override clickhouse->host",
+	},
+	cli.UintFlag{
+		Name:   "target-port",
+		Hidden: false,
+		Usage:  "Override clickhouse->port",
+	},
+	cli.StringFlag{
+		Name:   "target-user",
+		Hidden: false,
+		Usage:  "Override clickhouse->username",
+	},
+}
+
+// backupTagFlags - tags and description stored in metadata.json of created backup
+var backupTagFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:   "tag",
+		Hidden: false,
+		Usage:  "Backup tag in `key=value` format, repeat flag for several tags, added to general->backup_tags",
+	},
+	cli.StringFlag{
+		Name:   "description",
+		Hidden: false,
+		Usage:  "Free-form backup description, replace general->backup_description",
+	},
+}
+
+// getCreateConfig - load config, `--tag` flags are added to backup_tags, `--description` replaces backup_description
+func getCreateConfig(c *cli.Context) (*config.Config, error) {
+	cfg := config.GetConfig(c)
+	tags, err := parseTagFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 && cfg.General.BackupTags == nil {
+		cfg.General.BackupTags = map[string]string{}
+	}
+	for key, value := range tags {
+		cfg.General.BackupTags[key] = value
+	}
+	if c.IsSet("description") {
+		cfg.General.BackupDescription = c.String("description")
+	}
+	return cfg, config.ValidateConfig(cfg)
+}
+
+func parseTagFlags(c *cli.Context) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, tag := range c.StringSlice("tag") {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid --tag '%s', expected format `key=value`", tag)
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return tags, nil
+}
+
+// getTargetConfig - load config, `--target*` flags override `clickhouse` section
+func getTargetConfig(c *cli.Context) (*config.Config, error) {
+	cfg := config.GetConfig(c)
+	if err := applyTargetFlags(c, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, config.ValidateConfig(cfg)
+}
+
+func applyTargetFlags(c *cli.Context, cfg *config.Config) error {
+	if c.String("target") != "" {
+		if err := cfg.UseClickHouseTarget(c.String("target")); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("target-host") {
+		cfg.ClickHouse.Host = c.String("target-host")
+	}
+	if c.IsSet("target-port") {
+		cfg.ClickHouse.Port = c.Uint("target-port")
+	}
+	if c.IsSet("target-user") {
+		cfg.ClickHouse.Username = c.String("target-user")
+	}
+	return nil
+}
+
+// getRestoreConfig - load config, restore mapping flags override mapping from config, `--target*` flags override `clickhouse` section
+func getRestoreConfig(c *cli.Context) (*config.Config, error) {
+	cfg := config.GetConfig(c)
+	if err := applyTargetFlags(c, cfg); err != nil {
+		return nil, err
+	}
+	if databaseMapping := c.StringSlice("restore-database-mapping"); len(databaseMapping) > 0 {
+		mapping, err := config.ParseMapping(strings.Join(databaseMapping, ","))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --restore-database-mapping: %v", err)
+		}
+		cfg.General.RestoreDatabaseMapping = mapping
+	}
+	if tableMapping := c.StringSlice("restore-table-mapping"); len(tableMapping) > 0 {
+		mapping, err := config.ParseMapping(strings.Join(tableMapping, ","))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --restore-table-mapping: %v", err)
+		}
+		cfg.General.RestoreTableMapping = mapping
+	}
+	if c.IsSet("on-cluster") {
+		cfg.General.RestoreSchemaOnCluster = c.String("on-cluster")
+	}
+	if c.IsSet("restore-replicated-engine") {
+		cfg.General.RestoreReplicatedEngine = c.String("restore-replicated-engine")
+	}
+	if c.Bool("validate") {
+		cfg.General.RestoreValidate = true
+	}
+	return cfg, config.ValidateConfig(cfg)
+}
+
+// instrument - setup tracing and send command result to sinks configured in `metrics` config section
+func instrument(command string, action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		cfg := config.GetConfig(c)
+		shutdownTracing, err := tracing.Init(&cfg.Tracing, version)
+		if err != nil {
+			log.Warnf("can't setup tracing: %v", err)
+		}
+		defer shutdownTracing()
+		flushErrors, err := errtracker.Init(&cfg.Sentry, version)
+		if err != nil {
+			log.Warnf("can't setup sentry: %v", err)
+		}
+		defer flushErrors()
+		run := metrics.StartCommand(command)
+		err = action(c)
+		if backup.IsBackupSkipped(err) {
+			if sendErr := run.Finish(cfg, nil); sendErr != nil {
+				log.Warn(sendErr.Error())
+			}
+			return err
+		}
+		if sendErr := run.Finish(cfg, err); sendErr != nil {
+			log.Warn(sendErr.Error())
+		}
+		errtracker.Capture(cfg, command, c.Args().First(), err)
+		return err
+	}
+}
@@ -0,0 +1,383 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+)
+
+// WriteBackupArchive - write tar stream with all files of local or remote backup, when tablePattern is not empty, only metadata and data of matched tables will written
+func (b *Backuper) WriteBackupArchive(ctx context.Context, w io.Writer, where, backupName, tablePattern string) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "archive",
+	})
+	if err := b.prepareBackupFileAccess(ctx, where, backupName); err != nil {
+		return err
+	}
+	defer b.ch.Close()
+	tw := tar.NewWriter(w)
+	var err error
+	var filesCount int
+	if where == "local" {
+		filesCount, err = b.writeLocalBackupArchive(tw, backupName, tablePattern)
+	} else {
+		filesCount, err = b.writeRemoteBackupArchive(ctx, tw, backupName, tablePattern)
+	}
+	if err != nil {
+		return err
+	}
+	if filesCount == 0 {
+		return fmt.Errorf("no have found files for %s backup '%s' by table pattern '%s'", where, backupName, tablePattern)
+	}
+	log.Debugf("%d files written", filesCount)
+	return tw.Close()
+}
+
+// OpenBackupFile - open a single file inside local or remote backup, local files implement io.ReadSeeker
+func (b *Backuper) OpenBackupFile(ctx context.Context, where, backupName, filePath string) (io.ReadCloser, int64, time.Time, error) {
+	filePath = path.Clean("/" + filePath)[1:]
+	if filePath == "" || filePath == "." {
+		return nil, 0, time.Time{}, fmt.Errorf("file path is required")
+	}
+	if err := b.prepareBackupFileAccess(ctx, where, backupName); err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	defer b.ch.Close()
+	if where == "local" {
+		for _, diskPath := range b.DiskToPathMap {
+			localFile := path.Join(diskPath, "backup", backupName, filePath)
+			info, err := os.Stat(localFile)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			f, err := os.Open(localFile)
+			if err != nil {
+				return nil, 0, time.Time{}, err
+			}
+			return f, info.Size(), info.ModTime(), nil
+		}
+		return nil, 0, time.Time{}, new_storage.ErrNotFound
+	}
+	remoteFile, err := b.dst.StatFile(ctx, path.Join(backupName, filePath))
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	r, err := b.dst.GetFileReader(ctx, path.Join(backupName, filePath))
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	return r, remoteFile.Size(), remoteFile.LastModified(), nil
+}
+
+// ImportBackupArchive - extract tar archive created by WriteBackupArchive from local backup (optionally gzip compressed) and register it as local backup for restore
+func (b *Backuper) ImportBackupArchive(ctx context.Context, r io.Reader, backupName string) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "import",
+	})
+	startImport := time.Now()
+	if backupName == "" || strings.Contains(backupName, "/") || backupName == "." || backupName == ".." {
+		return fmt.Errorf("'%s' is wrong backup name", backupName)
+	}
+	defer markLocalBackupInProgress(backupName)()
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	disks, err := b.ch.GetDisks()
+	if err != nil {
+		return err
+	}
+	if err := b.init(ctx, disks); err != nil {
+		return err
+	}
+	backupPath := path.Join(b.DefaultDataPath, "backup", backupName)
+	if _, err := os.Stat(backupPath); err == nil {
+		return fmt.Errorf("'%s' already exists", backupName)
+	}
+	imported, err := b.extractBackupArchive(r, backupName, disks)
+	if err == nil {
+		err = b.registerImportedBackup(backupName)
+	}
+	if err != nil {
+		for _, dir := range imported {
+			if removeErr := os.RemoveAll(dir); removeErr != nil {
+				log.Warnf("can't remove %s: %v", dir, removeErr)
+			}
+		}
+		return fmt.Errorf("can't import '%s': %v", backupName, err)
+	}
+	log.WithField("duration", utils.LogDuration(time.Since(startImport))).Info("done")
+	return nil
+}
+
+// extractBackupArchive - `shadow/db/table/disk_name/...` files extracted to `disk_name` path, or to default disk when disk_name is not found, return list of created backup directories
+func (b *Backuper) extractBackupArchive(r io.Reader, backupName string, disks []clickhouse.Disk) ([]string, error) {
+	var imported []string
+	created := map[string]struct{}{}
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return imported, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, err
+		}
+		// allow archives created by `tar -C /var/lib/clickhouse/backup/backup_name -cf - .`
+		name := strings.TrimSuffix(strings.TrimPrefix(header.Name, "./"), "/")
+		if name == "" || name == "." {
+			continue
+		}
+		if strings.HasPrefix(name, "/") || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+			return imported, fmt.Errorf("'%s' is wrong archive entry name", header.Name)
+		}
+		diskPath := b.DefaultDataPath
+		parts := strings.Split(name, "/")
+		if parts[0] == "shadow" && len(parts) > 4 {
+			if p, exists := b.DiskToPathMap[parts[3]]; exists {
+				diskPath = p
+			}
+		}
+		backupPath := path.Join(diskPath, "backup", backupName)
+		if _, exists := created[backupPath]; !exists {
+			if _, err := os.Stat(backupPath); err == nil {
+				return imported, fmt.Errorf("%s already exists", backupPath)
+			}
+			if err := filesystemhelper.MkdirAll(backupPath, b.ch, disks); err != nil {
+				return imported, err
+			}
+			imported = append(imported, backupPath)
+			created[backupPath] = struct{}{}
+		}
+		dst := path.Join(backupPath, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := filesystemhelper.MkdirAll(dst, b.ch, disks); err != nil {
+				return imported, err
+			}
+		case tar.TypeReg:
+			if err := filesystemhelper.MkdirAll(path.Dir(dst), b.ch, disks); err != nil {
+				return imported, err
+			}
+			f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+			if err != nil {
+				return imported, err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return imported, err
+			}
+			if err := filesystemhelper.Chown(dst, b.ch, disks); err != nil {
+				return imported, err
+			}
+		default:
+			return imported, fmt.Errorf("'%s' has unsupported type '%c', only regular files and directories allowed", header.Name, header.Typeflag)
+		}
+	}
+	return imported, nil
+}
+
+// registerImportedBackup - validate metadata.json and set backup name, cause archive could be imported with different name
+func (b *Backuper) registerImportedBackup(backupName string) error {
+	metadataFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
+	body, err := ioutil.ReadFile(metadataFile)
+	if err != nil {
+		return fmt.Errorf("archive doesn't contain valid metadata.json: %v", err)
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err := json.Unmarshal(body, &backupMetadata); err != nil {
+		return fmt.Errorf("can't parse metadata.json: %v", err)
+	}
+	if err := backupMetadata.CheckVersion(false); err != nil {
+		return err
+	}
+	if backupMetadata.DataFormat != "" && backupMetadata.DataFormat != "directory" {
+		return fmt.Errorf("archive contains remote backup with data_format=%s, only archive of local backup could be imported", backupMetadata.DataFormat)
+	}
+	if backupMetadata.RequiredBackup != "" {
+		return fmt.Errorf("archive contains increment backup which required '%s', only full backup could be imported", backupMetadata.RequiredBackup)
+	}
+	if backupMetadata.TablesManifest != "" {
+		if err := expandTablesManifest(path.Dir(metadataFile), backupMetadata.TablesManifest); err != nil {
+			return err
+		}
+		backupMetadata.TablesManifest = ""
+		backupMetadata.BackupName = backupName
+		return backupMetadata.Save(metadataFile)
+	}
+	if backupMetadata.BackupName != backupName {
+		backupMetadata.BackupName = backupName
+		return backupMetadata.Save(metadataFile)
+	}
+	return nil
+}
+
+func (b *Backuper) prepareBackupFileAccess(ctx context.Context, where, backupName string) error {
+	if where != "local" && where != "remote" {
+		return fmt.Errorf("'%s' is wrong location, use 'local' or 'remote'", where)
+	}
+	if backupName == "" || strings.Contains(backupName, "/") || backupName == "." || backupName == ".." {
+		return fmt.Errorf("'%s' is wrong backup name", backupName)
+	}
+	if where == "remote" && b.cfg.General.RemoteStorage == "none" {
+		return fmt.Errorf("remote_storage is 'none'")
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	if err := b.init(ctx, nil); err != nil {
+		b.ch.Close()
+		return err
+	}
+	if where == "local" {
+		if _, err := os.Stat(path.Join(b.DefaultDataPath, "backup", backupName)); err != nil {
+			b.ch.Close()
+			return fmt.Errorf("backup '%s' is not found: %v", backupName, err)
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) writeLocalBackupArchive(tw *tar.Writer, backupName, tablePattern string) (int, error) {
+	filesCount := 0
+	written := map[string]struct{}{}
+	for _, diskPath := range b.DiskToPathMap {
+		backupPath := path.Join(diskPath, "backup", backupName)
+		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(backupPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			name := strings.Trim(strings.TrimPrefix(filepath.ToSlash(filePath), backupPath), "/")
+			if _, exists := written[name]; exists || !isArchiveFileMatched(name, tablePattern) {
+				return nil
+			}
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			_ = f.Close()
+			if err != nil {
+				return err
+			}
+			written[name] = struct{}{}
+			filesCount++
+			return nil
+		})
+		if err != nil {
+			return filesCount, err
+		}
+	}
+	return filesCount, nil
+}
+
+func (b *Backuper) writeRemoteBackupArchive(ctx context.Context, tw *tar.Writer, backupName, tablePattern string) (int, error) {
+	filesCount := 0
+	err := b.dst.Walk(ctx, backupName+"/", true, func(f new_storage.RemoteFile) error {
+		name := strings.Trim(f.Name(), "/")
+		if !isArchiveFileMatched(name, tablePattern) {
+			return nil
+		}
+		r, err := b.dst.GetFileReader(ctx, path.Join(backupName, name))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0640,
+			Size:     f.Size(),
+			ModTime:  f.LastModified(),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return err
+		}
+		filesCount++
+		return nil
+	})
+	return filesCount, err
+}
+
+// isArchiveFileMatched - check file path relative to backup root belongs to tables matched by tablePattern, `metadata.json` and tables manifest always matched
+func isArchiveFileMatched(name, tablePattern string) bool {
+	if tablePattern == "" || name == "metadata.json" || name == tablesManifestFile {
+		return true
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) < 3 || (parts[0] != "metadata" && parts[0] != "shadow") {
+		return false
+	}
+	database, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return false
+	}
+	table := parts[2]
+	if parts[0] == "metadata" {
+		if len(parts) != 3 {
+			return false
+		}
+		table = strings.TrimSuffix(strings.TrimSuffix(table, ".json"), ".sql")
+	}
+	if table, err = url.PathUnescape(table); err != nil {
+		return false
+	}
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	for _, pattern := range strings.Split(tablePattern, ",") {
+		if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+var backupNamePlaceholderRE = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// backupNameTimePlaceholders - placeholders of `backup_name_template` which don't require clickhouse-server, time is UTC
+var backupNameTimePlaceholders = map[string]func(time.Time) string{
+	"datetime":  func(t time.Time) string { return t.Format(TimeFormatForBackup) },
+	"date":      func(t time.Time) string { return t.Format("2006-01-02") },
+	"time":      func(t time.Time) string { return t.Format("15-04-05") },
+	"timestamp": func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) },
+}
+
+// ResolveBackupName - apply `general->backup_name_template` when backupName is empty, names with `{...}` placeholders are resolved the same way,
+// macros are read from system.macros only when name contains placeholders except time ones
+func ResolveBackupName(cfg *config.Config, backupName string) (string, error) {
+	if backupName == "" {
+		backupName = cfg.General.BackupNameTemplate
+	}
+	if !strings.Contains(backupName, "{") {
+		return backupName, nil
+	}
+	macros := map[string]string{}
+	for _, match := range backupNamePlaceholderRE.FindAllStringSubmatch(backupName, -1) {
+		if _, isTime := backupNameTimePlaceholders[match[1]]; isTime || match[1] == "hostname" {
+			continue
+		}
+		ch := &clickhouse.ClickHouse{
+			Config: &cfg.ClickHouse,
+		}
+		if err := ch.Connect(); err != nil {
+			return "", fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		var err error
+		macros, err = ch.GetMacros()
+		ch.Close()
+		if err != nil {
+			return "", fmt.Errorf("can't get system.macros: %v", err)
+		}
+		break
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return applyBackupNameTemplate(backupName, time.Now().UTC(), hostname, macros)
+}
+
+// applyBackupNameTemplate - replace time placeholders, `{hostname}` and macros, `{replica}` is hostname when macro is not defined the same as for `single_replica_backup`
+func applyBackupNameTemplate(template string, now time.Time, hostname string, macros map[string]string) (string, error) {
+	var unknown []string
+	name := backupNamePlaceholderRE.ReplaceAllStringFunc(template, func(placeholder string) string {
+		key := placeholder[1 : len(placeholder)-1]
+		if format, isTime := backupNameTimePlaceholders[key]; isTime {
+			return format(now)
+		}
+		if value, exists := macros[key]; exists {
+			return value
+		}
+		switch key {
+		case "hostname", "replica":
+			return hostname
+		}
+		unknown = append(unknown, placeholder)
+		return placeholder
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("can't resolve backup name '%s', unknown placeholders %s, only macros from system.macros, {hostname}, {datetime}, {date}, {time} and {timestamp} are allowed", template, strings.Join(unknown, ", "))
+	}
+	if strings.ContainsAny(name, "{}/") || name == "." || name == ".." {
+		return "", fmt.Errorf("backup name '%s' resolved from '%s' contains invalid characters", name, template)
+	}
+	return name, nil
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBackupNameTemplate(t *testing.T) {
+	now := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	macros := map[string]string{"cluster": "prod", "shard": "02", "replica": "ch-2-1"}
+
+	name, err := applyBackupNameTemplate("{cluster}-{shard}-{replica}-{datetime}", now, "host-1", macros)
+	assert.NoError(t, err)
+	assert.Equal(t, "prod-02-ch-2-1-2022-03-04T05-06-07", name)
+
+	name, err = applyBackupNameTemplate("{hostname}-{replica}-{date}-{time}-{timestamp}", now, "host-1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "host-1-host-1-2022-03-04-05-06-07-1646370367", name)
+
+	_, err = applyBackupNameTemplate("{layer}-{datetime}", now, "host-1", macros)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown placeholders {layer}")
+
+	_, err = applyBackupNameTemplate("{cluster}/{datetime}", now, "host-1", macros)
+	assert.Error(t, err)
+}
+
+func TestResolveBackupName(t *testing.T) {
+	cfg := config.DefaultConfig()
+	name, err := ResolveBackupName(cfg, "")
+	assert.NoError(t, err)
+	_, err = time.Parse(TimeFormatForBackup, name)
+	assert.NoError(t, err)
+
+	name, err = ResolveBackupName(cfg, "daily")
+	assert.NoError(t, err)
+	assert.Equal(t, "daily", name)
+
+	cfg.General.BackupNameTemplate = "{hostname}-{date}"
+	name, err = ResolveBackupName(cfg, "")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^.+-\d{4}-\d{2}-\d{2}$`, name)
+}
@@ -1,10 +1,14 @@
 package backup
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
 	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"golang.org/x/sync/semaphore"
 )
 
 type Backuper struct {
@@ -14,9 +18,32 @@ type Backuper struct {
 	Version         string
 	DiskToPathMap   map[string]string
 	DefaultDataPath string
+	tablesManifests tablesManifestCache
+	// uploadTransfers and downloadTransfers - shared by parts and archives of all tables which are transferred in parallel
+	transfersMu       sync.Mutex
+	uploadTransfers   *semaphore.Weighted
+	downloadTransfers *semaphore.Weighted
+}
+
+func (b *Backuper) init(ctx context.Context, disks []clickhouse.Disk) error {
+	if err := b.initDisks(disks); err != nil {
+		return err
+	}
+	if b.cfg.General.RemoteStorage != "none" {
+		var err error
+		b.dst, err = new_storage.NewBackupDestination(b.cfg, true)
+		if err != nil {
+			return err
+		}
+		if err := b.dst.Connect(ctx); err != nil {
+			return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+		}
+	}
+	return nil
 }
 
-func (b *Backuper) init(disks []clickhouse.Disk) error {
+// initDisks - fill DefaultDataPath and DiskToPathMap, disks will request from system.disks when nil
+func (b *Backuper) initDisks(disks []clickhouse.Disk) error {
 	var err error
 	if disks == nil {
 		disks, err = b.ch.GetDisks()
@@ -33,18 +60,29 @@ func (b *Backuper) init(disks []clickhouse.Disk) error {
 		diskMap[disk.Name] = disk.Path
 	}
 	b.DiskToPathMap = diskMap
-	if b.cfg.General.RemoteStorage != "none" {
-		b.dst, err = new_storage.NewBackupDestination(b.cfg, true)
-		if err != nil {
-			return err
-		}
-		if err := b.dst.Connect(); err != nil {
-			return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
-		}
-	}
 	return nil
 }
 
+// uploadSemaphore - tables are uploaded in parallel too, so total count of uploaded parts and archives is limited by one semaphore for remote storage
+func (b *Backuper) uploadSemaphore() *semaphore.Weighted {
+	b.transfersMu.Lock()
+	defer b.transfersMu.Unlock()
+	if b.uploadTransfers == nil {
+		b.uploadTransfers = semaphore.NewWeighted(int64(b.cfg.GetUploadConcurrency()))
+	}
+	return b.uploadTransfers
+}
+
+// downloadSemaphore - the same as uploadSemaphore for downloaded parts and archives
+func (b *Backuper) downloadSemaphore() *semaphore.Weighted {
+	b.transfersMu.Lock()
+	defer b.transfersMu.Unlock()
+	if b.downloadTransfers == nil {
+		b.downloadTransfers = semaphore.NewWeighted(int64(b.cfg.GetDownloadConcurrency()))
+	}
+	return b.downloadTransfers
+}
+
 func NewBackuper(cfg *config.Config) *Backuper {
 	ch := &clickhouse.ClickHouse{
 		Config: &cfg.ClickHouse,
@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+const baseCacheChecksumExt = ".sha256"
+
+// baseCacheEvictLock - eviction of the same cache folder by concurrent commands of server
+var baseCacheEvictLock sync.Mutex
+
+// baseCache - archives of required backups downloaded for incremental backups, key is remote path of archive,
+// which contains name of required backup, so cached archive is never changed, sha256 of each archive is stored near it.
+// Archives of each remote storage location are stored in own sub folder, so the same remote path on different buckets don't collide
+type baseCache struct {
+	dir      string
+	location string
+	maxSize  uint64
+	now      func() time.Time
+}
+
+// newBaseCache - nil when `base_cache_path` is empty
+func newBaseCache(cfg *config.Config) *baseCache {
+	if cfg.General.BaseCachePath == "" {
+		return nil
+	}
+	return &baseCache{dir: cfg.General.BaseCachePath, location: baseCacheLocation(cfg), maxSize: cfg.General.BaseCacheMaxSize, now: time.Now}
+}
+
+// baseCacheLocation - hash of remote storage kind, endpoint, bucket and path
+func baseCacheLocation(cfg *config.Config) string {
+	location := cfg.General.RemoteStorage
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		location = fmt.Sprintf("s3://%s/%s/%s", cfg.S3.Endpoint, cfg.S3.Bucket, cfg.S3.Path)
+	case "gcs":
+		location = fmt.Sprintf("gcs://%s/%s/%s", cfg.GCS.Endpoint, cfg.GCS.Bucket, cfg.GCS.Path)
+	case "azblob":
+		location = fmt.Sprintf("azblob://%s.%s/%s/%s", cfg.AzureBlob.AccountName, cfg.AzureBlob.EndpointSuffix, cfg.AzureBlob.Container, cfg.AzureBlob.Path)
+	case "cos":
+		location = fmt.Sprintf("cos://%s/%s", cfg.COS.RowURL, cfg.COS.Path)
+	case "ftp":
+		location = fmt.Sprintf("ftp://%s/%s", cfg.FTP.Address, cfg.FTP.Path)
+	case "sftp":
+		location = fmt.Sprintf("sftp://%s:%d/%s", cfg.SFTP.Address, cfg.SFTP.Port, cfg.SFTP.Path)
+	case "grpc":
+		location = fmt.Sprintf("grpc://%s/%s", cfg.GRPC.Address, cfg.GRPC.Path)
+	}
+	hash := sha256.Sum256([]byte(location))
+	return hex.EncodeToString(hash[:8])
+}
+
+func (c *baseCache) archivePath(key string) string {
+	return filepath.Join(c.dir, c.location, filepath.FromSlash(strings.TrimPrefix(key, "/")))
+}
+
+func fileChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			apexLog.Warnf("can't close %s: %v", filePath, err)
+		}
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// get - path of cached archive when its checksum is valid, archive is marked as recently used, broken archive is removed
+func (c *baseCache) get(key string) (string, bool) {
+	archivePath := c.archivePath(key)
+	expected, err := ioutil.ReadFile(archivePath + baseCacheChecksumExt)
+	if err != nil {
+		return "", false
+	}
+	actual, err := fileChecksum(archivePath)
+	if err != nil || actual != strings.TrimSpace(string(expected)) {
+		apexLog.Warnf("cached %s is broken, will download again, error: %v", archivePath, err)
+		c.remove(archivePath)
+		return "", false
+	}
+	now := c.now()
+	if err := os.Chtimes(archivePath, now, now); err != nil {
+		apexLog.Warnf("can't update modification time of %s: %v", archivePath, err)
+	}
+	return archivePath, true
+}
+
+// put - download archive with download into temporary file, save checksum and remove least recently used archives
+func (c *baseCache) put(key string, download func(localFile string) error) (string, error) {
+	archivePath := c.archivePath(key)
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0750); err != nil {
+		return "", err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(archivePath), filepath.Base(archivePath)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	if err := download(tmpPath); err != nil {
+		c.remove(tmpPath)
+		return "", err
+	}
+	checksum, err := fileChecksum(tmpPath)
+	if err != nil {
+		c.remove(tmpPath)
+		return "", err
+	}
+	if err := ioutil.WriteFile(archivePath+baseCacheChecksumExt, []byte(checksum), 0640); err != nil {
+		c.remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		c.remove(tmpPath)
+		return "", err
+	}
+	if err := c.evict(archivePath); err != nil {
+		apexLog.Warnf("can't evict %s: %v", c.dir, err)
+	}
+	return archivePath, nil
+}
+
+func (c *baseCache) remove(archivePath string) {
+	for _, f := range []string{archivePath, archivePath + baseCacheChecksumExt} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			apexLog.Warnf("can't remove %s: %v", f, err)
+		}
+	}
+}
+
+// evict - remove least recently used archives until size of cache is not greater than `base_cache_max_size`, keep archive which is just added
+func (c *baseCache) evict(keep string) error {
+	if c.maxSize == 0 {
+		return nil
+	}
+	baseCacheEvictLock.Lock()
+	defer baseCacheEvictLock.Unlock()
+	type cachedArchive struct {
+		path string
+		info os.FileInfo
+	}
+	var archives []cachedArchive
+	totalSize := uint64(0)
+	if err := filepath.Walk(c.dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(filePath, baseCacheChecksumExt) || strings.HasSuffix(filePath, ".tmp") {
+			return nil
+		}
+		totalSize += uint64(info.Size())
+		if filePath != keep {
+			archives = append(archives, cachedArchive{path: filePath, info: info})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].info.ModTime().Before(archives[j].info.ModTime()) })
+	for _, archive := range archives {
+		if totalSize <= c.maxSize {
+			break
+		}
+		apexLog.Debugf("evict %s from base_cache_path", archive.path)
+		c.remove(archive.path)
+		totalSize -= uint64(archive.info.Size())
+	}
+	if totalSize > c.maxSize {
+		return fmt.Errorf("size of %s is %d greater than base_cache_max_size=%d", c.dir, totalSize, c.maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseCache(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &baseCache{dir: t.TempDir(), maxSize: 10, now: func() time.Time { return now }}
+	download := func(content string) func(string) error {
+		return func(localFile string) error {
+			return ioutil.WriteFile(localFile, []byte(content), 0640)
+		}
+	}
+	_, cached := c.get("base/shadow/db/t/default_all_1_1_0.tar")
+	assert.False(t, cached)
+
+	first, err := c.put("base/shadow/db/t/default_all_1_1_0.tar", download("12345"))
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(first, now.Add(-time.Hour), now.Add(-time.Hour)))
+	second, err := c.put("base/shadow/db/t/default_all_2_2_0.tar", download("1234"))
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(second, now.Add(-2*time.Hour), now.Add(-2*time.Hour)))
+
+	cachedPath, cached := c.get("base/shadow/db/t/default_all_1_1_0.tar")
+	assert.True(t, cached)
+	assert.Equal(t, first, cachedPath)
+
+	// least recently used archive is evicted, just added archive is kept
+	third, err := c.put("base/shadow/db/t/default_all_3_3_0.tar", download("123"))
+	require.NoError(t, err)
+	assert.FileExists(t, first)
+	assert.NoFileExists(t, second)
+	assert.NoFileExists(t, second+baseCacheChecksumExt)
+	assert.FileExists(t, third)
+
+	// broken archive is removed
+	require.NoError(t, ioutil.WriteFile(first, []byte("54321"), 0640))
+	_, cached = c.get("base/shadow/db/t/default_all_1_1_0.tar")
+	assert.False(t, cached)
+	assert.NoFileExists(t, first)
+}
+
+func TestBaseCacheLocation(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.BaseCachePath = t.TempDir()
+	cfg.General.RemoteStorage = "s3"
+	cfg.S3.Bucket, cfg.S3.Path = "bucket1", "backups"
+	key := "base/shadow/db/t/default_all_1_1_0.tar"
+	first := newBaseCache(cfg).archivePath(key)
+	assert.Equal(t, first, newBaseCache(cfg).archivePath(key))
+
+	cfg.S3.Bucket = "bucket2"
+	second := newBaseCache(cfg).archivePath(key)
+	assert.NotEqual(t, first, second)
+	cfg.S3.Path = "other"
+	assert.NotEqual(t, second, newBaseCache(cfg).archivePath(key))
+
+	cfg.General.RemoteStorage = "gcs"
+	cfg.GCS.Bucket, cfg.GCS.Path = "bucket2", "other"
+	assert.NotEqual(t, second, newBaseCache(cfg).archivePath(key))
+}
@@ -0,0 +1,384 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// clusterBackupFormat - DataFormat of `create_cluster` manifest, such backup can be restored only with `restore_cluster`
+const clusterBackupFormat = "cluster"
+
+// clusterPollInterval - how often operation status is requested from API of cluster hosts
+var clusterPollInterval = 5 * time.Second
+
+// clusterAction - operation from `GET /backup/actions?id=`
+type clusterAction struct {
+	ID      int    `json:"id"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// clusterAPIClient - run commands with `POST /backup/actions` on clickhouse-backup API of cluster hosts,
+// all hosts shall use the same `api.listen` port, `api.secure`, `api.username` and `api.password`
+type clusterAPIClient struct {
+	cfg    *config.APIConfig
+	client *http.Client
+}
+
+func newClusterAPIClient(cfg *config.APIConfig) *clusterAPIClient {
+	return &clusterAPIClient{cfg: cfg, client: &http.Client{Timeout: time.Minute}}
+}
+
+func (c *clusterAPIClient) url(host, uri string) (string, error) {
+	_, port, err := net.SplitHostPort(c.cfg.ListenAddr)
+	if err != nil {
+		return "", fmt.Errorf("can't get port from api.listen: %v", err)
+	}
+	scheme := "http"
+	if c.cfg.Secure {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, port), uri), nil
+}
+
+func (c *clusterAPIClient) do(method, host, uri string, body []byte, expectedCode int, result interface{}) error {
+	url, err := c.url(host, uri)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.cfg.Username != "" {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			apexLog.Warnf("can't close response body of %s: %v", url, err)
+		}
+	}()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != expectedCode {
+		return fmt.Errorf("%s %s return %d: %s", method, url, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	// API answers with JSONEachRow, operation status is the first row
+	return json.NewDecoder(bytes.NewReader(respBody)).Decode(result)
+}
+
+// startAction - start command on host and return operation id
+func (c *clusterAPIClient) startAction(host, command string) (int, error) {
+	body, err := json.Marshal(map[string]string{"command": command})
+	if err != nil {
+		return -1, err
+	}
+	var started struct {
+		OperationId int `json:"operation_id"`
+	}
+	if err := c.do(http.MethodPost, host, "/backup/actions", body, http.StatusCreated, &started); err != nil {
+		return -1, err
+	}
+	return started.OperationId, nil
+}
+
+// waitAction - poll operation status until it is finished
+func (c *clusterAPIClient) waitAction(ctx context.Context, host string, id int) (clusterAction, error) {
+	for {
+		var action clusterAction
+		if err := c.do(http.MethodGet, host, fmt.Sprintf("/backup/actions?id=%d", id), nil, http.StatusOK, &action); err != nil {
+			return action, err
+		}
+		if action.Status != "in progress" {
+			return action, nil
+		}
+		select {
+		case <-ctx.Done():
+			return action, ctx.Err()
+		case <-time.After(clusterPollInterval):
+		}
+	}
+}
+
+// runAction - run command on host and wait its result, `skipped` status of `single_replica_backup` is not failure
+func (c *clusterAPIClient) runAction(ctx context.Context, host, command string) (clusterAction, error) {
+	log := apexLog.WithField("host", host)
+	log.Infof("run `%s`", command)
+	id, err := c.startAction(host, command)
+	if err != nil {
+		return clusterAction{Command: command, Status: "error", Error: err.Error()}, err
+	}
+	action, err := c.waitAction(ctx, host, id)
+	if err != nil {
+		return clusterAction{ID: id, Command: command, Status: "error", Error: err.Error()}, err
+	}
+	if action.Status != "success" && action.Status != "skipped" {
+		return action, fmt.Errorf("`%s` on %s finished with status %s: %s", command, host, action.Status, action.Error)
+	}
+	log.Infof("`%s` finished with status %s", command, action.Status)
+	return action, nil
+}
+
+// clusterShards - hosts of each shard ordered by replica_num
+func clusterShards(replicas []clickhouse.ClusterReplica) map[uint32][]clickhouse.ClusterReplica {
+	shards := map[uint32][]clickhouse.ClusterReplica{}
+	for _, r := range replicas {
+		shards[r.ShardNum] = append(shards[r.ShardNum], r)
+	}
+	for shard := range shards {
+		sort.Slice(shards[shard], func(i, j int) bool { return shards[shard][i].ReplicaNum < shards[shard][j].ReplicaNum })
+	}
+	return shards
+}
+
+// shardBackupName - name of backup of one shard on remote storage
+func shardBackupName(backupName string, shard uint32) string {
+	return fmt.Sprintf("%s-shard%d", backupName, shard)
+}
+
+// clusterCommand - command line for `POST /backup/actions`, arguments are quoted for shlex
+func clusterCommand(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// clusterFlags - flags of create_cluster and restore_cluster which are passed to each host
+func clusterFlags(tablePattern string, partitions []string, flags map[string]bool) []string {
+	var args []string
+	if tablePattern != "" {
+		args = append(args, "--tables="+tablePattern)
+	}
+	for _, p := range partitions {
+		args = append(args, "--partitions="+p)
+	}
+	names := make([]string, 0, len(flags))
+	for name, enabled := range flags {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--"+name)
+	}
+	return args
+}
+
+// clusterTagFlags - tags and description of cluster backup are stored in backup of each shard too
+func clusterTagFlags(cfg *config.Config) []string {
+	keys := make([]string, 0, len(cfg.General.BackupTags))
+	for key := range cfg.General.BackupTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		args = append(args, "--tag="+key+"="+cfg.General.BackupTags[key])
+	}
+	if cfg.General.BackupDescription != "" {
+		args = append(args, "--description="+cfg.General.BackupDescription)
+	}
+	return args
+}
+
+func (b *Backuper) getClusterReplicas(cluster string) ([]clickhouse.ClusterReplica, error) {
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	return b.ch.GetClusterReplicas(cluster)
+}
+
+func (b *Backuper) connectClusterRemoteStorage(ctx context.Context) error {
+	if b.cfg.General.RemoteStorage == "none" {
+		return fmt.Errorf("cluster backups require remote storage, general->remote_storage is 'none'")
+	}
+	var err error
+	b.dst, err = b.connectRemoteStorage(ctx, b.cfg.General.RemoteStorage)
+	return err
+}
+
+// CreateCluster - run `create_remote` on the first replica of each shard from system.clusters through their API
+// and upload manifest with shard backups and their status as `<backup_name>/metadata.json`
+func (b *Backuper) CreateCluster(ctx context.Context, backupName, cluster, tablePattern string, partitions []string, schemaOnly, rbac, configs bool, version string) error {
+	backupName, err := ResolveBackupName(b.cfg, backupName)
+	if err != nil {
+		return err
+	}
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "create_cluster",
+	})
+	startBackup := time.Now()
+	replicas, err := b.getClusterReplicas(cluster)
+	if err != nil {
+		return err
+	}
+	if err := b.connectClusterRemoteStorage(ctx); err != nil {
+		return err
+	}
+	shards := clusterShards(replicas)
+	flags := append(clusterFlags(tablePattern, partitions, map[string]bool{"schema": schemaOnly, "rbac": rbac, "configs": configs}), clusterTagFlags(b.cfg)...)
+	client := newClusterAPIClient(&b.cfg.API)
+	manifest := &metadata.ClusterBackup{Cluster: cluster}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []string
+	for shard, shardReplicas := range shards {
+		wg.Add(1)
+		go func(shard uint32, host string) {
+			defer wg.Done()
+			shardBackup := shardBackupName(backupName, shard)
+			action, err := client.runAction(ctx, host, clusterCommand(append(append([]string{"create_remote"}, flags...), shardBackup)...))
+			mu.Lock()
+			defer mu.Unlock()
+			manifest.Shards = append(manifest.Shards, metadata.ClusterShardBackup{
+				Shard:      shard,
+				Host:       host,
+				BackupName: shardBackup,
+				Status:     action.Status,
+				Error:      action.Error,
+			})
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("shard %d: %v", shard, err))
+			}
+		}(shard, shardReplicas[0].HostName)
+	}
+	wg.Wait()
+	sort.Slice(manifest.Shards, func(i, j int) bool { return manifest.Shards[i].Shard < manifest.Shards[j].Shard })
+	tags, err := metadata.FormatTags(b.cfg.General.BackupTags)
+	if err != nil {
+		return err
+	}
+	clusterMetadata := metadata.BackupMetadata{
+		MetadataVersion:         metadata.MetadataVersion,
+		BackupName:              backupName,
+		ClickhouseBackupVersion: version,
+		CreationDate:            time.Now().UTC(),
+		Tags:                    tags,
+		Description:             b.cfg.General.BackupDescription,
+		Tables:                  []metadata.TableTitle{},
+		Functions:               []metadata.FunctionsMeta{},
+		DataFormat:              clusterBackupFormat,
+		Cluster:                 manifest,
+	}
+	clusterMetadata.SetRequiredFeatures(nil)
+	body, err := json.MarshalIndent(&clusterMetadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := b.dst.PutFile(ctx, path.Join(backupName, "metadata.json"), ioutil.NopCloser(bytes.NewReader(body))); err != nil {
+		return fmt.Errorf("can't upload cluster backup manifest: %v", err)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%d of %d shards failed: %s", len(errs), len(shards), strings.Join(errs, "; "))
+	}
+	log.WithField("duration", time.Since(startBackup).String()).Infof("done, %d shards", len(shards))
+	return nil
+}
+
+// RestoreCluster - run `restore_remote` of shard backups from `create_cluster` manifest on the first replica of each shard
+// and `restore_remote --schema` on other replicas, replicated tables fetch data from the first replica
+func (b *Backuper) RestoreCluster(ctx context.Context, backupName, cluster, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbac, configs bool) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_cluster",
+	})
+	startRestore := time.Now()
+	replicas, err := b.getClusterReplicas(cluster)
+	if err != nil {
+		return err
+	}
+	if err := b.connectClusterRemoteStorage(ctx); err != nil {
+		return err
+	}
+	remoteBackups, err := b.dst.BackupList(ctx, true, backupName)
+	if err != nil {
+		return err
+	}
+	var manifest *metadata.ClusterBackup
+	for _, remoteBackup := range remoteBackups {
+		if remoteBackup.BackupName == backupName {
+			manifest = remoteBackup.Cluster
+			if manifest == nil {
+				return fmt.Errorf("'%s' is not cluster backup, use restore_remote", backupName)
+			}
+		}
+	}
+	if manifest == nil {
+		return fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	shards := clusterShards(replicas)
+	for _, shardBackup := range manifest.Shards {
+		if shardBackup.Status != "success" {
+			return fmt.Errorf("backup of shard %d has status %s and can't be restored: %s", shardBackup.Shard, shardBackup.Status, shardBackup.Error)
+		}
+		if len(shards[shardBackup.Shard]) == 0 {
+			return fmt.Errorf("shard %d of '%s' is not found in cluster '%s'", shardBackup.Shard, backupName, cluster)
+		}
+	}
+	flags := clusterFlags(tablePattern, partitions, map[string]bool{"schema": schemaOnly, "data": dataOnly, "rm": dropTable, "rbac": rbac, "configs": configs})
+	replicaFlags := clusterFlags(tablePattern, partitions, map[string]bool{"schema": true, "rm": dropTable})
+	client := newClusterAPIClient(&b.cfg.API)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []string
+	for _, shardBackup := range manifest.Shards {
+		wg.Add(1)
+		go func(shardBackup metadata.ClusterShardBackup, shardReplicas []clickhouse.ClusterReplica) {
+			defer wg.Done()
+			commands := []string{clusterCommand(append(append([]string{"restore_remote"}, flags...), shardBackup.BackupName)...)}
+			for range shardReplicas[1:] {
+				if !dataOnly {
+					commands = append(commands, clusterCommand(append(append([]string{"restore_remote"}, replicaFlags...), shardBackup.BackupName)...))
+				}
+			}
+			for i, command := range commands {
+				if _, err := client.runAction(ctx, shardReplicas[i].HostName, command); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("shard %d: %v", shardBackup.Shard, err))
+					mu.Unlock()
+					return
+				}
+			}
+		}(shardBackup, shards[shardBackup.Shard])
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%d of %d shards failed: %s", len(errs), len(manifest.Shards), strings.Join(errs, "; "))
+	}
+	log.WithField("duration", time.Since(startRestore).String()).Infof("done, %d shards", len(manifest.Shards))
+	return nil
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterCommand(t *testing.T) {
+	command := clusterCommand("create_remote", "--tables=db.t*", "--partitions=db.t:it's", "my backup")
+	assert.Equal(t, `create_remote --tables=db.t* '--partitions=db.t:it'"'"'s' 'my backup'`, command)
+	args, err := shlex.Split(command)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"create_remote", "--tables=db.t*", "--partitions=db.t:it's", "my backup"}, args)
+}
+
+func TestClusterFlags(t *testing.T) {
+	assert.Equal(t,
+		[]string{"--tables=db.*", "--partitions=202201", "--rbac", "--schema"},
+		clusterFlags("db.*", []string{"202201"}, map[string]bool{"schema": true, "rbac": true, "configs": false}),
+	)
+	assert.Empty(t, clusterFlags("", nil, map[string]bool{"schema": false}))
+
+	cfg := config.DefaultConfig()
+	assert.Empty(t, clusterTagFlags(cfg))
+	cfg.General.BackupTags = map[string]string{"type": "manual", "env": "prod"}
+	cfg.General.BackupDescription = "pre-upgrade"
+	assert.Equal(t, []string{"--tag=env=prod", "--tag=type=manual", "--description=pre-upgrade"}, clusterTagFlags(cfg))
+}
+
+func TestClusterShards(t *testing.T) {
+	shards := clusterShards([]clickhouse.ClusterReplica{
+		{ShardNum: 1, ReplicaNum: 2, HostName: "ch-1-2"},
+		{ShardNum: 2, ReplicaNum: 1, HostName: "ch-2-1"},
+		{ShardNum: 1, ReplicaNum: 1, HostName: "ch-1-1"},
+	})
+	assert.Len(t, shards, 2)
+	assert.Equal(t, "ch-1-1", shards[1][0].HostName)
+	assert.Equal(t, "ch-1-2", shards[1][1].HostName)
+	assert.Equal(t, "ch-2-1", shards[2][0].HostName)
+	assert.Equal(t, "daily-shard2", shardBackupName("daily", 2))
+}
+
+func TestClusterAPIClientRunAction(t *testing.T) {
+	oldInterval := clusterPollInterval
+	clusterPollInterval = time.Millisecond
+	defer func() { clusterPollInterval = oldInterval }()
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			var row struct {
+				Command string `json:"command"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&row))
+			w.WriteHeader(http.StatusCreated)
+			id := 1
+			if row.Command == "create_remote broken" {
+				id = 2
+			}
+			_, _ = fmt.Fprintf(w, "{\"status\":\"acknowledged\",\"operation\":%q,\"operation_id\":%d}\n", row.Command, id)
+		case http.MethodGet:
+			polls++
+			status, errorText := "in progress", ""
+			if polls > 1 {
+				status = "success"
+			}
+			if r.URL.Query().Get("id") == "2" {
+				status, errorText = "error", "can't connect to clickhouse"
+			}
+			_, _ = fmt.Fprintf(w, "{\"id\":%s,\"command\":\"create_remote\",\"status\":%q,\"error\":%q}\n", r.URL.Query().Get("id"), status, errorText)
+		}
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	client := newClusterAPIClient(&config.APIConfig{ListenAddr: "0.0.0.0:" + u.Port(), Username: "admin", Password: "secret"})
+	action, err := client.runAction(context.Background(), u.Hostname(), "create_remote daily-shard1")
+	assert.NoError(t, err)
+	assert.Equal(t, "success", action.Status)
+	assert.Equal(t, 2, polls)
+
+	action, err = client.runAction(context.Background(), u.Hostname(), "create_remote broken")
+	assert.Error(t, err)
+	assert.Equal(t, "error", action.Status)
+	assert.Contains(t, err.Error(), "can't connect to clickhouse")
+
+	client = newClusterAPIClient(&config.APIConfig{ListenAddr: "0.0.0.0:" + u.Port()})
+	_, err = client.runAction(context.Background(), u.Hostname(), "create_remote daily-shard1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "return 401")
+}
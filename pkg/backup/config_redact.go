@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+const redactedConfigValue = "******"
+
+// redactConfigSecrets - replace not empty values of `config_redact_tags` XML elements and YAML keys in config files copied to configBackupPath,
+// return size of all files after redaction
+func redactConfigSecrets(configBackupPath string, tags []string) (uint64, error) {
+	size := uint64(0)
+	err := filepath.Walk(configBackupPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		var redact func([]byte) []byte
+		switch strings.ToLower(filepath.Ext(filePath)) {
+		case ".xml":
+			redact = func(content []byte) []byte { return redactXMLSecrets(content, tags) }
+		case ".yaml", ".yml":
+			redact = func(content []byte) []byte { return redactYAMLSecrets(content, tags) }
+		default:
+			size += uint64(info.Size())
+			return nil
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		redacted := redact(content)
+		if !bytes.Equal(content, redacted) {
+			if err := ioutil.WriteFile(filePath, redacted, info.Mode()); err != nil {
+				return fmt.Errorf("can't write redacted %s: %v", filePath, err)
+			}
+		}
+		size += uint64(len(redacted))
+		return nil
+	})
+	return size, err
+}
+
+func redactXMLSecrets(content []byte, tags []string) []byte {
+	for _, tag := range tags {
+		re := regexp.MustCompile(`(<` + regexp.QuoteMeta(tag) + `(?:\s[^>]*)?>)([^<]*)(</` + regexp.QuoteMeta(tag) + `\s*>)`)
+		content = re.ReplaceAllFunc(content, func(element []byte) []byte {
+			match := re.FindSubmatch(element)
+			if len(bytes.TrimSpace(match[2])) == 0 {
+				return element
+			}
+			return append(append(append([]byte{}, match[1]...), redactedConfigValue...), match[3]...)
+		})
+	}
+	return content
+}
+
+func redactYAMLSecrets(content []byte, tags []string) []byte {
+	if len(tags) == 0 {
+		return content
+	}
+	quoted := make([]string, len(tags))
+	for i, tag := range tags {
+		quoted[i] = regexp.QuoteMeta(tag)
+	}
+	re := regexp.MustCompile(`(?m)^([ \t]*(?:-[ \t]+)?["']?(?:` + strings.Join(quoted, "|") + `)["']?[ \t]*:[ \t]*)(\S[^\r\n]*)$`)
+	return re.ReplaceAll(content, []byte(`${1}"`+redactedConfigValue+`"`))
+}
+
+// hasRedactedConfigSecrets - check config files in configBackupPath contain values replaced by redactConfigSecrets
+func hasRedactedConfigSecrets(configBackupPath string) bool {
+	found := false
+	_ = filepath.Walk(configBackupPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || found {
+			return nil
+		}
+		if content, err := ioutil.ReadFile(filePath); err == nil {
+			found = bytes.Contains(content, []byte(">"+redactedConfigValue+"<")) || bytes.Contains(content, []byte(`"`+redactedConfigValue+`"`))
+		}
+		return nil
+	})
+	return found
+}
+
+// configRestoreDir - `config_restore_dir` or `config_dir` when it is empty
+func configRestoreDir(cfg config.ClickHouseConfig) string {
+	if cfg.ConfigRestoreDir != "" {
+		return cfg.ConfigRestoreDir
+	}
+	return cfg.ConfigDir
+}
+
+// isConfigRestoredInPlace - clickhouse-server restart is required only when configs are restored into `config_dir`
+func isConfigRestoredInPlace(cfg config.ClickHouseConfig) bool {
+	return path.Clean(configRestoreDir(cfg)) == path.Clean(cfg.ConfigDir)
+}
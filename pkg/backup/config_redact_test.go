@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactConfigSecrets(t *testing.T) {
+	tags := config.DefaultConfig().ClickHouse.ConfigRedactTags
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(dir, "users.d"), 0755))
+	files := map[string]string{
+		"config.xml": "<clickhouse><s3><access_key_id>AKIA</access_key_id><secret_access_key>s3cr3t</secret_access_key></s3>" +
+			"<remote_servers><c><secret>interserver</secret></c></remote_servers><password_file>/etc/pass</password_file></clickhouse>",
+		"users.d/default.xml": "<clickhouse><users><default><password></password><password_sha256_hex from_env=\"X\"/></default>" +
+			"<u><password_sha256_hex>abcdef</password_sha256_hex></u></users></clickhouse>",
+		"users.d/app.yaml": "users:\n  app:\n    password: \"qwerty\"\n    password_file: /etc/pass\n    profile: default\n",
+		"README":           "password: keep",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644))
+	}
+	assert.False(t, hasRedactedConfigSecrets(dir))
+	size, err := redactConfigSecrets(dir, tags)
+	require.NoError(t, err)
+	expected := map[string]string{
+		"config.xml": "<clickhouse><s3><access_key_id>******</access_key_id><secret_access_key>******</secret_access_key></s3>" +
+			"<remote_servers><c><secret>******</secret></c></remote_servers><password_file>/etc/pass</password_file></clickhouse>",
+		"users.d/default.xml": "<clickhouse><users><default><password></password><password_sha256_hex from_env=\"X\"/></default>" +
+			"<u><password_sha256_hex>******</password_sha256_hex></u></users></clickhouse>",
+		"users.d/app.yaml": "users:\n  app:\n    password: \"******\"\n    password_file: /etc/pass\n    profile: default\n",
+		"README":           "password: keep",
+	}
+	expectedSize := 0
+	for name, content := range expected {
+		actual, err := ioutil.ReadFile(path.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(actual), name)
+		expectedSize += len(content)
+	}
+	assert.Equal(t, uint64(expectedSize), size)
+	assert.True(t, hasRedactedConfigSecrets(dir))
+}
+
+func TestConfigRestoreDir(t *testing.T) {
+	cfg := config.DefaultConfig().ClickHouse
+	assert.Equal(t, "/etc/clickhouse-server/", configRestoreDir(cfg))
+	assert.True(t, isConfigRestoredInPlace(cfg))
+	cfg.ConfigRestoreDir = "/etc/clickhouse-server"
+	assert.True(t, isConfigRestoredInPlace(cfg))
+	cfg.ConfigRestoreDir = "/tmp/restored-configs"
+	assert.Equal(t, "/tmp/restored-configs", configRestoreDir(cfg))
+	assert.False(t, isConfigRestoredInPlace(cfg))
+}
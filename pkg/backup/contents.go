@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+)
+
+// BackupContents - stable `list <local|remote> <name> --tables` schema, read from backup and table metadata only, data is not downloaded
+type BackupContents struct {
+	Name      string                `json:"name" yaml:"name"`
+	Location  string                `json:"location" yaml:"location"`
+	Created   time.Time             `json:"created" yaml:"created"`
+	Size      uint64                `json:"size" yaml:"size"`
+	Databases []string              `json:"databases" yaml:"databases"`
+	Tables    []BackupTableContents `json:"tables" yaml:"tables"`
+}
+
+// BackupTableContents - tsv columns follow fields order, disks and partition ids joined by comma, rows are 0 for backups created without parts stats
+type BackupTableContents struct {
+	Database   string                    `json:"database" yaml:"database"`
+	Table      string                    `json:"table" yaml:"table"`
+	Engine     string                    `json:"engine" yaml:"engine"`
+	TotalBytes uint64                    `json:"total_bytes" yaml:"total_bytes"`
+	TotalRows  uint64                    `json:"total_rows" yaml:"total_rows"`
+	Parts      int                       `json:"parts" yaml:"parts"`
+	Disks      []string                  `json:"disks" yaml:"disks"`
+	Partitions []BackupPartitionContents `json:"partitions" yaml:"partitions"`
+	// Replication - paths of replicated table at backup time, not printed in text and tsv output
+	Replication *metadata.TableReplication `json:"replication,omitempty" yaml:"replication,omitempty"`
+	rowsKnown   bool
+}
+
+// BackupPartitionContents - parts of one partition, bytes are known for backups created with `file_checksums`
+type BackupPartitionContents struct {
+	ID    string `json:"id" yaml:"id"`
+	Parts int    `json:"parts" yaml:"parts"`
+	Bytes uint64 `json:"bytes" yaml:"bytes"`
+	Rows  uint64 `json:"rows" yaml:"rows"`
+}
+
+func (i BackupTableContents) tsvRow() []string {
+	partitions := make([]string, len(i.Partitions))
+	for j, p := range i.Partitions {
+		partitions[j] = p.ID
+	}
+	return []string{i.Database, i.Table, i.Engine, fmt.Sprint(i.TotalBytes), fmt.Sprint(i.TotalRows), fmt.Sprint(i.Parts), strings.Join(i.Disks, ","), strings.Join(partitions, ",")}
+}
+
+// GetBackupContents - databases, tables and partitions of local or remote backup, only metadata is read
+func GetBackupContents(ctx context.Context, cfg *config.Config, backupName string, remote bool) (*BackupContents, error) {
+	backup, err := openBackupWithTables(ctx, cfg, backupName, remote)
+	if err != nil {
+		return nil, err
+	}
+	return backupContents(backup, remote), nil
+}
+
+// PrintBackupContents - print databases, tables and partitions of backup to check it before long download or restore
+func PrintBackupContents(ctx context.Context, cfg *config.Config, backupName string, remote bool, outputFormat string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	contents, err := GetBackupContents(ctx, cfg, backupName, remote)
+	if err != nil {
+		return err
+	}
+	return printBackupContents(os.Stdout, contents, outputFormat)
+}
+
+// openBackupWithTables - connect to remote storage when required and load metadata of all tables of backup
+func openBackupWithTables(ctx context.Context, cfg *config.Config, backupName string, remote bool) (*backupWithTables, error) {
+	if backupName == "" {
+		return nil, fmt.Errorf("backup name is required")
+	}
+	b := NewBackuper(cfg)
+	if remote {
+		if cfg.General.RemoteStorage == "none" {
+			return nil, fmt.Errorf("remote storage is 'none'")
+		}
+		var err error
+		if b.dst, err = new_storage.NewBackupDestination(cfg, false); err != nil {
+			return nil, err
+		}
+		if err := b.dst.Connect(ctx); err != nil {
+			return nil, fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+		}
+	}
+	return b.loadBackupWithTables(ctx, backupName, remote)
+}
+
+func backupContents(backup *backupWithTables, remote bool) *BackupContents {
+	contents := &BackupContents{
+		Name:      backup.BackupName,
+		Location:  "local",
+		Created:   backup.CreationDate,
+		Size:      backup.DataSize,
+		Databases: []string{},
+		Tables:    make([]BackupTableContents, 0, len(backup.Tables)),
+	}
+	if remote {
+		contents.Location = "remote"
+	}
+	databases := map[string]struct{}{}
+	for _, db := range backup.Databases {
+		databases[db.Name] = struct{}{}
+	}
+	for title, tm := range backup.Tables {
+		databases[title.Database] = struct{}{}
+		contents.Tables = append(contents.Tables, tableContents(title, tm))
+	}
+	for db := range databases {
+		contents.Databases = append(contents.Databases, db)
+	}
+	sort.Strings(contents.Databases)
+	sort.Slice(contents.Tables, func(i, j int) bool {
+		if contents.Tables[i].Database != contents.Tables[j].Database {
+			return contents.Tables[i].Database < contents.Tables[j].Database
+		}
+		return contents.Tables[i].Table < contents.Tables[j].Table
+	})
+	return contents
+}
+
+func tableContents(title metadata.TableTitle, tm *metadata.TableMetadata) BackupTableContents {
+	item := BackupTableContents{
+		Database:    title.Database,
+		Table:       title.Table,
+		Engine:      getEngineFromQuery(tm.Query),
+		TotalBytes:  uint64(tableMetadataSize(tm)),
+		Disks:       []string{},
+		Partitions:  []BackupPartitionContents{},
+		Replication: tm.Replication,
+	}
+	if item.TotalBytes == 0 {
+		item.TotalBytes = tm.TotalBytes
+	}
+	item.TotalRows, item.rowsKnown = expectedRestoredRows(*tm)
+	partitions := map[string]*BackupPartitionContents{}
+	for disk, parts := range tm.Parts {
+		if len(parts) > 0 {
+			item.Disks = append(item.Disks, disk)
+		}
+		for _, part := range parts {
+			id := partitionIDOfPart(part)
+			p, exists := partitions[id]
+			if !exists {
+				p = &BackupPartitionContents{ID: id}
+				partitions[id] = p
+			}
+			p.Parts++
+			p.Bytes += partBytes(part)
+			p.Rows += part.Rows
+			item.Parts++
+		}
+	}
+	sort.Strings(item.Disks)
+	for _, p := range partitions {
+		item.Partitions = append(item.Partitions, *p)
+	}
+	sort.Slice(item.Partitions, func(i, j int) bool {
+		return item.Partitions[i].ID < item.Partitions[j].ID
+	})
+	return item
+}
+
+// partitionIDOfPart - parts created by FREEZE contain only name, partition id is the name prefix, `20181023_2_2_0`
+func partitionIDOfPart(part metadata.Part) string {
+	if part.PartitionID != "" {
+		return part.PartitionID
+	}
+	return strings.Split(part.Name, "_")[0]
+}
+
+func partBytes(part metadata.Part) uint64 {
+	if len(part.Checksums) == 0 {
+		return uint64(part.Size)
+	}
+	size := uint64(0)
+	for _, checksum := range part.Checksums {
+		size += uint64(checksum.Size)
+	}
+	return size
+}
+
+// printBackupContents - text output contains line per table followed by line per partition, rows column is empty when rows are unknown
+func printBackupContents(out io.Writer, contents *BackupContents, outputFormat string) error {
+	if !isTextOutput(outputFormat) {
+		rows := make([][]string, len(contents.Tables))
+		for i := range contents.Tables {
+			rows[i] = contents.Tables[i].tsvRow()
+		}
+		return printStructured(out, outputFormat, contents, rows)
+	}
+	fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%d databases\t%d tables\n", contents.Name, contents.Location, contents.Created.Format("02/01/2006 15:04:05"), utils.FormatBytes(contents.Size), len(contents.Databases), len(contents.Tables))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, table := range contents.Tables {
+		fmt.Fprintf(w, "%s.%s\t%s\t%s\t%s\t%d parts\t%s\n", table.Database, table.Table, table.Engine, utils.FormatBytes(table.TotalBytes), formatContentsRows(table.TotalRows, table.rowsKnown), table.Parts, strings.Join(table.Disks, ","))
+		for _, p := range table.Partitions {
+			fmt.Fprintf(w, "  partition %s\t\t%s\t%s\t%d parts\t\n", p.ID, utils.FormatBytes(p.Bytes), formatContentsRows(p.Rows, table.rowsKnown), p.Parts)
+		}
+	}
+	return w.Flush()
+}
+
+func formatContentsRows(rows uint64, known bool) string {
+	if !known {
+		return ""
+	}
+	return fmt.Sprintf("%d rows", rows)
+}
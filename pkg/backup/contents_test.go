@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupContents(t *testing.T) {
+	backup := &backupWithTables{
+		BackupMetadata: metadata.BackupMetadata{
+			BackupName:   "b1",
+			CreationDate: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+			DataSize:     300,
+			Databases:    []metadata.DatabasesMeta{{Name: "empty_db"}, {Name: "db"}},
+		},
+		Tables: map[metadata.TableTitle]*metadata.TableMetadata{
+			{Database: "db", Table: "events"}: {
+				Database: "db", Table: "events", Query: "CREATE TABLE db.events (id UInt64) ENGINE = MergeTree PARTITION BY toYYYYMM(d) ORDER BY id",
+				Size: map[string]int64{"default": 200, "s3": 100}, Rows: 30, CompressedBytes: 300,
+				Parts: map[string][]metadata.Part{
+					"default": {
+						{Name: "202201_1_1_0", Rows: 10, Checksums: map[string]metadata.FileChecksum{"data.bin": {Size: 90}, "checksums.txt": {Size: 10}}},
+						{Name: "202201_2_2_0", Rows: 5, Size: 100},
+					},
+					"s3": {{Name: "202112_3_3_0", PartitionID: "202112", Rows: 15, Size: 100}},
+				},
+			},
+			{Database: "db", Table: "dict"}: {Database: "db", Table: "dict", Query: "CREATE DICTIONARY db.dict (id UInt64) PRIMARY KEY id", MetadataOnly: true},
+		},
+	}
+	contents := backupContents(backup, true)
+	assert.Equal(t, "remote", contents.Location)
+	assert.Equal(t, []string{"db", "empty_db"}, contents.Databases)
+	require.Len(t, contents.Tables, 2)
+	assert.Equal(t, "dict", contents.Tables[0].Table)
+	assert.Empty(t, contents.Tables[0].Partitions)
+	events := contents.Tables[1]
+	assert.Equal(t, uint64(300), events.TotalBytes)
+	assert.Equal(t, uint64(30), events.TotalRows)
+	assert.Equal(t, 3, events.Parts)
+	assert.Equal(t, []string{"default", "s3"}, events.Disks)
+	assert.Equal(t, []BackupPartitionContents{
+		{ID: "202112", Parts: 1, Bytes: 100, Rows: 15},
+		{ID: "202201", Parts: 2, Bytes: 200, Rows: 15},
+	}, events.Partitions)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, printBackupContents(out, contents, "tsv"))
+	assert.Equal(t, "db\tdict\tDictionary\t0\t0\t0\t\t\ndb\tevents\tMergeTree\t300\t30\t3\tdefault,s3\t202112,202201\n", out.String())
+
+	out.Reset()
+	require.NoError(t, printBackupContents(out, contents, "json"))
+	var decoded BackupContents
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, contents.Tables[1].Partitions, decoded.Tables[1].Partitions)
+
+	out.Reset()
+	require.NoError(t, printBackupContents(out, contents, "text"))
+	assert.Contains(t, out.String(), "b1\tremote\t02/01/2022 03:04:05\t300B\t2 databases\t2 tables\n")
+	assert.Contains(t, out.String(), "  partition 202201")
+	assert.Contains(t, out.String(), "15 rows")
+}
@@ -0,0 +1,263 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// Copy - stream remote backup objects from one configured remote storage to another, source backup is deleted after successful copy when move is true,
+// inside the same remote storage backup is copied or renamed to newName with server-side copy, so data is not transferred through host
+func (b *Backuper) Copy(ctx context.Context, backupName, newName, from, to string, move bool) (err error) {
+	ctx, span := tracing.Start(ctx, "copy", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if from == "" {
+		from = b.cfg.General.RemoteStorage
+	}
+	if newName == "" {
+		newName = backupName
+	}
+	if to == "" && newName != backupName {
+		to = from
+	}
+	if from == "none" || to == "" || to == "none" {
+		return fmt.Errorf("source and destination remote storage are required, use --from and --to")
+	}
+	sameStorage := from == to
+	if sameStorage && newName == backupName {
+		return fmt.Errorf("source and destination remote storage are the same: %s, set new backup name to copy inside the same remote storage", from)
+	}
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "copy",
+		"from":      from,
+		"to":        to,
+	})
+	if newName != backupName {
+		log = log.WithField("name", newName)
+	}
+	src, err := b.connectRemoteStorage(ctx, from)
+	if err != nil {
+		return err
+	}
+	dst := src
+	if !sameStorage {
+		if dst, err = b.connectRemoteStorage(ctx, to); err != nil {
+			return err
+		}
+	}
+
+	srcBackups, err := src.BackupList(ctx, true, backupName)
+	if err != nil {
+		return err
+	}
+	var backup *new_storage.Backup
+	for i := range srcBackups {
+		if srcBackups[i].BackupName == backupName {
+			backup = &srcBackups[i]
+			break
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("'%s' is not found on %s remote storage", backupName, from)
+	}
+	if backup.Broken != "" {
+		return fmt.Errorf("'%s' is broken on %s remote storage: %s", backupName, from, backup.Broken)
+	}
+	dstBackups, err := dst.BackupList(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	requiredFound := backup.RequiredBackup == ""
+	for _, dstBackup := range dstBackups {
+		if dstBackup.BackupName == newName {
+			return fmt.Errorf("'%s' already exists on %s remote storage", newName, to)
+		}
+		requiredFound = requiredFound || dstBackup.BackupName == backup.RequiredBackup
+	}
+	dst.SetBackupTime(newName, backup.CreationDate)
+	if !requiredFound {
+		log.Warnf("required backup '%s' is not found on %s remote storage, copy it too before download", backup.RequiredBackup, to)
+	}
+	if move && newName != backupName {
+		if err := checkNotRequired(ctx, src, backupName); err != nil {
+			return err
+		}
+	}
+	if dstFormat := b.cfgForRemoteStorage(to).GetCompressionFormat(); !sameStorage && !backup.Legacy && dstFormat != backup.DataFormat && !(dstFormat == "none" && backup.DataFormat == "directory") {
+		log.Warnf("backup data_format=%s differs from %s compression_format=%s, objects will copy as is", backup.DataFormat, to, dstFormat)
+	}
+
+	startCopy := time.Now()
+	var copiedSize, copiedFiles int64
+	dstKey := func(key string) string {
+		return newName + strings.TrimPrefix(key, backupName)
+	}
+	copyFile := func(key string) error {
+		if sameStorage {
+			var err error
+			if move {
+				err = src.MoveFile(ctx, key, dstKey(key))
+			} else {
+				err = src.CopyFile(ctx, key, dstKey(key))
+			}
+			if err != nil {
+				return fmt.Errorf("can't copy %s to %s on %s: %v", key, dstKey(key), from, err)
+			}
+			atomic.AddInt64(&copiedFiles, 1)
+			log.WithField("key", key).Debug("copied")
+			return nil
+		}
+		r, err := src.GetFileReader(ctx, key)
+		if err != nil {
+			return fmt.Errorf("can't read %s from %s: %v", key, from, err)
+		}
+		defer func() {
+			if err := r.Close(); err != nil {
+				log.Warnf("can't close %s reader: %v", key, err)
+			}
+		}()
+		if err := dst.PutFile(ctx, dstKey(key), r); err != nil {
+			return fmt.Errorf("can't write %s to %s: %v", dstKey(key), to, err)
+		}
+		atomic.AddInt64(&copiedFiles, 1)
+		log.WithField("key", key).Debug("copied")
+		return nil
+	}
+
+	if backup.Legacy {
+		if err := copyFile(fmt.Sprintf("%s.%s", backupName, backup.FileExtension)); err != nil {
+			return err
+		}
+		copiedSize = int64(backup.DataSize)
+	} else {
+		// metadata.json copy last, backup without it will list as broken until copy finished
+		metadataKey := path.Join(backupName, "metadata.json")
+		s := semaphore.NewWeighted(int64(b.cfgForRemoteStorage(to).GetUploadConcurrency()))
+		g, copyCtx := errgroup.WithContext(ctx)
+		walkErr := src.Walk(ctx, backupName+"/", true, func(f new_storage.RemoteFile) error {
+			key := path.Join(backupName, f.Name())
+			if key == metadataKey {
+				return nil
+			}
+			if err := s.Acquire(copyCtx, 1); err != nil {
+				return err
+			}
+			atomic.AddInt64(&copiedSize, f.Size())
+			g.Go(func() error {
+				defer s.Release(1)
+				return copyFile(key)
+			})
+			return nil
+		})
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		if walkErr != nil {
+			return fmt.Errorf("can't list %s on %s: %v", backupName, from, walkErr)
+		}
+		if newName == backupName {
+			err = copyFile(metadataKey)
+		} else {
+			err = copyRenamedMetadata(ctx, src, dst, backupName, newName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	log.WithFields(apexLog.Fields{
+		"duration": utils.LogDuration(time.Since(startCopy)),
+		"files":    copiedFiles,
+		"size":     utils.LogBytes(uint64(copiedSize)),
+	}).Info("done")
+
+	// files of legacy backup inside the same storage are moved already, metadata.json and empty folders of SFTP and FTP are left for not legacy backups
+	if move && !(sameStorage && backup.Legacy) {
+		if err := src.RemoveBackup(ctx, *backup); err != nil {
+			return fmt.Errorf("backup copied to %s, but can't delete it from %s: %v", to, from, err)
+		}
+		log.Infof("deleted from %s", from)
+	}
+	return nil
+}
+
+// copyRenamedMetadata - replace backup_name in metadata.json, other fields are kept as is, so metadata of newer versions is not truncated
+func copyRenamedMetadata(ctx context.Context, src, dst *new_storage.BackupDestination, backupName, newName string) error {
+	r, err := src.GetFileReader(ctx, path.Join(backupName, "metadata.json"))
+	if err != nil {
+		return fmt.Errorf("can't read %s/metadata.json: %v", backupName, err)
+	}
+	body, err := ioutil.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("can't read %s/metadata.json: %v", backupName, err)
+	}
+	if body, err = renameBackupMetadata(body, newName); err != nil {
+		return fmt.Errorf("can't parse %s/metadata.json: %v", backupName, err)
+	}
+	return dst.PutFile(ctx, path.Join(newName, "metadata.json"), ioutil.NopCloser(bytes.NewReader(body)))
+}
+
+func renameBackupMetadata(body []byte, newName string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	name, err := json.Marshal(newName)
+	if err != nil {
+		return nil, err
+	}
+	fields["backup_name"] = name
+	return json.MarshalIndent(fields, "", "\t")
+}
+
+// checkNotRequired - renamed backup can't be found by incremental backups which require it
+func checkNotRequired(ctx context.Context, bd *new_storage.BackupDestination, backupName string) error {
+	backups, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		if backup.RequiredBackup == backupName {
+			return fmt.Errorf("'%s' is required by incremental backup '%s', can't rename it", backupName, backup.BackupName)
+		}
+	}
+	return nil
+}
+
+// cfgForRemoteStorage - shallow config copy with another general->remote_storage, storage sections are copied by value
+func (b *Backuper) cfgForRemoteStorage(remoteStorage string) *config.Config {
+	cfg := *b.cfg
+	cfg.General.RemoteStorage = remoteStorage
+	return &cfg
+}
+
+func (b *Backuper) connectRemoteStorage(ctx context.Context, remoteStorage string) (*new_storage.BackupDestination, error) {
+	bd, err := new_storage.NewBackupDestination(b.cfgForRemoteStorage(remoteStorage), false)
+	if err != nil {
+		return nil, err
+	}
+	if err := bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %v", bd.Kind(), err)
+	}
+	return bd, nil
+}
@@ -0,0 +1,22 @@
+package backup
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameBackupMetadata(t *testing.T) {
+	body, err := renameBackupMetadata([]byte(`{"backup_name":"old","required_backup":"base","unknown_field":{"a":1}}`), "new")
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &fields))
+	assert.Equal(t, "new", fields["backup_name"])
+	assert.Equal(t, "base", fields["required_backup"])
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, fields["unknown_field"], "fields of newer versions are kept")
+
+	_, err = renameBackupMetadata([]byte("broken"), "new")
+	assert.Error(t, err)
+}
@@ -1,25 +1,30 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
 	"github.com/mxalis/clickhouse-backup/pkg/common"
 	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
 	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
 	"github.com/mxalis/clickhouse-backup/pkg/utils"
 	apexLog "github.com/apex/log"
 	"github.com/google/uuid"
 	"github.com/otiai10/copy"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
@@ -73,14 +78,17 @@ func NewBackupName() string {
 }
 
 // CreateBackup - create new backup of all tables matched by tablePattern
-// If backupName is empty string will use default backup name
-func CreateBackup(cfg *config.Config, backupName, tablePattern string, partitions []string, schemaOnly, rbacOnly, configsOnly bool, version string) error {
+// If backupName is empty string will use general->backup_name_template
+func CreateBackup(ctx context.Context, cfg *config.Config, backupName, tablePattern string, partitions []string, schemaOnly, rbacOnly, configsOnly bool, version string) (err error) {
 
 	startBackup := time.Now()
 	doBackupData := !schemaOnly
-	if backupName == "" {
-		backupName = NewBackupName()
+	if backupName, err = ResolveBackupName(cfg, backupName); err != nil {
+		return err
 	}
+	defer markLocalBackupInProgress(backupName)()
+	ctx, span := tracing.Start(ctx, "create", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "create",
@@ -92,6 +100,11 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer ch.Close()
+	if doBackupData && cfg.ClickHouse.BackupEngine == "native" {
+		if err := ch.RequireFeature(clickhouse.FeatureNativeBackup, "`backup_engine: native`"); err != nil {
+			return err
+		}
+	}
 
 	allDatabases, err := ch.GetDatabases()
 	if err != nil {
@@ -102,6 +115,9 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 	if err != nil {
 		return fmt.Errorf("can't get tables from clickhouse: %v", err)
 	}
+	if allTables, err = ch.AppendMissingDictionaries(allTables); err != nil {
+		return fmt.Errorf("can't get dictionaries from clickhouse: %v", err)
+	}
 	tables := filterTablesByPattern(allTables, tablePattern)
 	i := 0
 	for _, table := range tables {
@@ -113,11 +129,40 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 	if i == 0 && !cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("no tables for backup")
 	}
+	if doBackupData {
+		backupTables := map[metadata.TableTitle]bool{}
+		for _, table := range tables {
+			if !table.Skip {
+				backupTables[metadata.TableTitle{Database: table.Database, Table: table.Name}] = true
+			}
+		}
+		// degraded replica shall not win single_replica_backup election
+		if err := checkReplicas(ctx, ch, backupTables, log); err != nil {
+			return err
+		}
+	}
+	if cfg.General.SingleReplicaBackup {
+		winner, won, err := electBackupReplica(cfg, ch, backupName)
+		if err != nil {
+			return fmt.Errorf("can't elect replica for backup: %v", err)
+		}
+		if !won {
+			return &BackupSkippedError{BackupName: backupName, Replica: winner}
+		}
+		log.Infof("replica won single_replica_backup election")
+	}
 
 	disks, err := ch.GetDisks()
 	if err != nil {
 		return err
 	}
+	if doBackupData {
+		if err := checkFreeSpace(ch, "create", func(map[string]uint64) map[string]uint64 {
+			return requiredSpaceForCreate(cfg, tables, disks)
+		}); err != nil {
+			return err
+		}
+	}
 	// Create backup dir on all clickhouse disks
 	for _, disk := range disks {
 		if err := filesystemhelper.Mkdir(path.Join(disk.Path, "backup"), ch, disks); err != nil {
@@ -139,62 +184,166 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 		}
 	}
 	diskMap := map[string]string{}
+	diskTypes := map[string]string{}
 	for _, disk := range disks {
 		diskMap[disk.Name] = disk.Path
+		diskTypes[disk.Name] = disk.Type
 	}
 	var backupDataSize, backupMetadataSize uint64
 
-	var tableMetas []metadata.TableTitle
 	partitionsToBackupMap := filesystemhelper.CreatePartitionsToBackupMap(partitions)
-	for _, table := range tables {
-		log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name))
-		if table.Skip {
+	macros, err := ch.GetMacros()
+	if err != nil {
+		log.Warnf("can't get system.macros: %v", err)
+	}
+	replicas, err := ch.GetReplicas()
+	if err != nil {
+		log.Warnf("can't get system.replicas: %v", err)
+	}
+	tableReplicas := replicasByTable(replicas)
+	// result of each table is stored by index, so metadata.json keep order of tables when freeze run in parallel
+	backupTables := make([]*metadata.TableTitle, len(tables))
+	tablesMetadata := make([]metadata.TableMetadata, len(tables))
+	nativeBackupTables := make([]*clickhouse.NativeBackupTable, len(tables))
+	log.Debugf("prepare table concurrent semaphore with freeze_concurrency=%d len(tables)=%d", cfg.ClickHouse.FreezeConcurrency, len(tables))
+	s := semaphore.NewWeighted(int64(cfg.ClickHouse.FreezeConcurrency))
+	g, gCtx := errgroup.WithContext(ctx)
+	for i := range tables {
+		if tables[i].Skip {
 			continue
 		}
-		var realSize map[string]int64
-		var disksToPartsMap map[string][]metadata.Part
-		if doBackupData {
-			log.Debug("create data")
-			shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
-			disksToPartsMap, realSize, err = AddTableToBackup(ch, backupName, shadowBackupUUID, disks, &table, partitionsToBackupMap)
-			if err != nil {
-				log.Error(err.Error())
-				if removeBackupErr := RemoveBackupLocal(cfg, backupName, disks); removeBackupErr != nil {
-					log.Error(removeBackupErr.Error())
+		if err := s.Acquire(gCtx, 1); err != nil {
+			log.Errorf("can't acquire semaphore during create: %v", err)
+			break
+		}
+		idx := i
+		g.Go(func() error {
+			defer s.Release(1)
+			table := tables[idx]
+			log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name))
+			var realSize map[string]int64
+			var disksToPartsMap map[string][]metadata.Part
+			var partsStats []clickhouse.PartStats
+			nativeBackup := doBackupData && isNativeBackupTable(cfg, table.Engine)
+			objectDisks := tableObjectDisks(table, disks)
+			logicalBackup := doBackupData && !nativeBackup && (isLogicalBackupTable(cfg, table.Engine) || isDownloadedThroughServer(cfg, objectDisks))
+			var zeroCopyDisks []string
+			if doBackupData && !nativeBackup && !logicalBackup && len(objectDisks) > 0 {
+				log.Warnf("parts on object disks %s will contain only references to objects, backup is valid while these objects exist", strings.Join(objectDisks, ", "))
+				zeroCopyDisks = objectDisks
+			}
+			if nativeBackup {
+				nativeBackupTables[idx] = &clickhouse.NativeBackupTable{
+					Database:   table.Database,
+					Table:      table.Name,
+					Partitions: nativePartitions(partitionsToBackupMap, table.Database, table.Name),
+				}
+			} else if doBackupData {
+				log.Debug("create data")
+				shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
+				var err error
+				if logicalBackup {
+					if len(objectDisks) > 0 {
+						log.Infof("data on object disks %s will export through clickhouse-server", strings.Join(objectDisks, ", "))
+					}
+					disksToPartsMap, realSize, err = addTableToBackupLogical(cfg, ch, backupName, shadowBackupUUID, defaultPath, disks, &table)
+				} else {
+					disksToPartsMap, realSize, err = AddTableToBackup(gCtx, ch, backupName, shadowBackupUUID, disks, &table, partitionsToBackupMap, cfg.General.LinkMode, len(zeroCopyDisks) > 0)
+				}
+				if err != nil {
+					log.Error(err.Error())
+					return err
+				}
+				if cfg.General.FileChecksums {
+					if err = calculateTableChecksums(diskMap, backupName, table.Database, table.Name, disksToPartsMap); err != nil {
+						log.Error(err.Error())
+						return err
+					}
 				}
-				// fix corner cases after https://github.com/mxalis/clickhouse-backup/issues/379
-				if cleanShadowErr := Clean(cfg); cleanShadowErr != nil {
-					log.Error(cleanShadowErr.Error())
+				if !logicalBackup && len(disksToPartsMap) > 0 {
+					if partsStats, err = ch.GetPartsStats(table.Database, table.Name); err != nil {
+						log.Warnf("can't get rows of parts: %v", err)
+					}
 				}
+				// more precise data size calculation
+				for _, size := range realSize {
+					atomic.AddUint64(&backupDataSize, uint64(size))
+				}
+			}
+			var sourceFiles map[string][]byte
+			if cfg.ClickHouse.BackupDictionaryFiles && table.Engine == "Dictionary" {
+				var err error
+				if sourceFiles, err = readDictionarySourceFiles(table.CreateTableQuery, userFilesPath(cfg, defaultPath)); err != nil {
+					log.Warnf("can't read dictionary source files: %v", err)
+				}
+			}
+			log.Debug("create metadata")
+			tableMetadata := metadata.TableMetadata{
+				Table:         table.Name,
+				Database:      table.Database,
+				Query:         table.CreateTableQuery,
+				TotalBytes:    table.TotalBytes,
+				Size:          realSize,
+				Parts:         disksToPartsMap,
+				MetadataOnly:  schemaOnly,
+				LogicalBackup: logicalBackup,
+				SourceFiles:   sourceFiles,
+				ObjectDisks:   zeroCopyDisks,
+				NativeBackup:  nativeBackup,
+			}
+			if replica, exists := tableReplicas[metadata.TableTitle{Database: table.Database, Table: table.Name}]; exists {
+				tableMetadata.Replication = tableReplication(table.CreateTableQuery, replica, macros)
+			}
+			applyPartsStats(&tableMetadata, partsStats)
+			metadataSize, err := createMetadata(ch, backupPath, tableMetadata, disks)
+			if err != nil {
 				return err
 			}
-			// more precise data size calculation
-			for _, size := range realSize {
-				backupDataSize += uint64(size)
+			atomic.AddUint64(&backupMetadataSize, metadataSize)
+			tablesMetadata[idx] = tableMetadata
+			backupTables[idx] = &metadata.TableTitle{
+				Database: table.Database,
+				Table:    table.Name,
 			}
+			log.Infof("done")
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil || ctx.Err() != nil {
+		if err == nil {
+			err = ctx.Err()
 		}
-		log.Debug("create metadata")
-		metadataSize, err := createMetadata(ch, backupPath, metadata.TableMetadata{
-			Table:        table.Name,
-			Database:     table.Database,
-			Query:        table.CreateTableQuery,
-			TotalBytes:   table.TotalBytes,
-			Size:         realSize,
-			Parts:        disksToPartsMap,
-			MetadataOnly: schemaOnly,
-		}, disks)
-		if err != nil {
+		if removeBackupErr := RemoveBackupLocal(cfg, backupName, disks); removeBackupErr != nil {
+			log.Error(removeBackupErr.Error())
+		}
+		// fix corner cases after https://github.com/mxalis/clickhouse-backup/issues/379
+		if cleanShadowErr := Clean(cfg); cleanShadowErr != nil {
+			log.Error(cleanShadowErr.Error())
+		}
+		return err
+	}
+	var tableMetas []metadata.TableTitle
+	var nativeTables []clickhouse.NativeBackupTable
+	for i := range tables {
+		if backupTables[i] != nil {
+			tableMetas = append(tableMetas, *backupTables[i])
+		}
+		if nativeBackupTables[i] != nil {
+			nativeTables = append(nativeTables, *nativeBackupTables[i])
+		}
+	}
+	backupNativeSize := uint64(0)
+	if len(nativeTables) > 0 {
+		log.Debugf("create native backup of %d tables", len(nativeTables))
+		if backupNativeSize, err = createNativeBackup(cfg, ch, backupName, backupPath, nativeTables, disks); err != nil {
+			log.Errorf("can't create native backup: %v", err)
 			if removeBackupErr := RemoveBackupLocal(cfg, backupName, disks); removeBackupErr != nil {
 				log.Error(removeBackupErr.Error())
 			}
 			return err
 		}
-		backupMetadataSize += metadataSize
-		tableMetas = append(tableMetas, metadata.TableTitle{
-			Database: table.Database,
-			Table:    table.Name,
-		})
-		log.Infof("done")
+		backupDataSize += backupNativeSize
+		log.WithField("size", utils.LogBytes(backupNativeSize)).Info("done createNativeBackup")
 	}
 	backupRBACSize, backupConfigSize := uint64(0), uint64(0)
 
@@ -202,14 +351,14 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 		if backupRBACSize, err = createRBACBackup(ch, backupPath, disks); err != nil {
 			log.Errorf("error during do RBAC backup: %v", err)
 		} else {
-			log.WithField("size", utils.FormatBytes(backupRBACSize)).Info("done createRBACBackup")
+			log.WithField("size", utils.LogBytes(backupRBACSize)).Info("done createRBACBackup")
 		}
 	}
 	if configsOnly {
 		if backupConfigSize, err = createConfigBackup(cfg, backupPath); err != nil {
 			log.Errorf("error during do CONFIG backup: %v", err)
 		} else {
-			log.WithField("size", utils.FormatBytes(backupConfigSize)).Info("done createConfigBackup")
+			log.WithField("size", utils.LogBytes(backupConfigSize)).Info("done createConfigBackup")
 		}
 	}
 
@@ -217,22 +366,37 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 	if err != nil {
 		return fmt.Errorf("GetUserDefinedFunctions return error: %v", err)
 	}
+	storagePolicies, err := ch.GetStoragePolicies()
+	if err != nil {
+		log.Warnf("can't get system.storage_policies: %v", err)
+	}
+	tags, err := metadata.FormatTags(cfg.General.BackupTags)
+	if err != nil {
+		return err
+	}
 	backupMetadata := metadata.BackupMetadata{
 		// TODO: think about which tables failed or  whole backup failed
+		MetadataVersion:         metadata.MetadataVersion,
 		BackupName:              backupName,
 		Disks:                   diskMap,
+		DiskTypes:               diskTypes,
 		ClickhouseBackupVersion: version,
 		CreationDate:            time.Now().UTC(),
-		// Tags: ,
-		ClickHouseVersion: ch.GetVersionDescribe(),
-		DataSize:          backupDataSize,
-		MetadataSize:      backupMetadataSize,
-		RBACSize:          backupRBACSize,
-		ConfigSize:        backupConfigSize,
+		Tags:                    tags,
+		Description:             cfg.General.BackupDescription,
+		ClickHouseVersion:       ch.GetVersionDescribe(),
+		DataSize:                backupDataSize,
+		MetadataSize:            backupMetadataSize,
+		RBACSize:                backupRBACSize,
+		ConfigSize:              backupConfigSize,
+		NativeSize:              backupNativeSize,
 		// CompressedSize: ,
-		Tables:    tableMetas,
-		Databases: []metadata.DatabasesMeta{},
-		Functions: []metadata.FunctionsMeta{},
+		Tables:          tableMetas,
+		Databases:       []metadata.DatabasesMeta{},
+		Functions:       []metadata.FunctionsMeta{},
+		Macros:          macros,
+		StoragePolicies: storagePolicies,
+		Environment:     backupEnvironment(ch, log),
 	}
 	for _, database := range allDatabases {
 		backupMetadata.Databases = append(backupMetadata.Databases, metadata.DatabasesMeta(database))
@@ -240,20 +404,21 @@ func CreateBackup(cfg *config.Config, backupName, tablePattern string, partition
 	for _, function := range allFunctions {
 		backupMetadata.Functions = append(backupMetadata.Functions, metadata.FunctionsMeta(function))
 	}
+	backupMetadata.SetRequiredFeatures(tablesMetadata)
 	content, err := json.MarshalIndent(&backupMetadata, "", "\t")
 	if err != nil {
 		_ = RemoveBackupLocal(cfg, backupName, disks)
 		return fmt.Errorf("can't marshal backup metafile json: %v", err)
 	}
 	backupMetaFile := path.Join(defaultPath, "backup", backupName, "metadata.json")
-	if err := ioutil.WriteFile(backupMetaFile, content, 0640); err != nil {
+	if err := metadata.WriteFileAtomic(backupMetaFile, content, 0640); err != nil {
 		_ = RemoveBackupLocal(cfg, backupName, disks)
 		return err
 	}
 	if err := filesystemhelper.Chown(backupMetaFile, ch, disks); err != nil {
 		log.Warnf("can't chown %s: %v", backupMetaFile, err)
 	}
-	log.WithField("duration", utils.HumanizeDuration(time.Since(startBackup))).Info("done")
+	log.WithField("duration", utils.LogDuration(time.Since(startBackup))).Info("done")
 
 	// Clean
 	if err := RemoveOldBackupsLocal(cfg, true, disks); err != nil {
@@ -274,6 +439,9 @@ func createConfigBackup(cfg *config.Config, backupPath string) (uint64, error) {
 			return false, nil
 		},
 	})
+	if copyErr == nil && cfg.ClickHouse.ConfigRedactSecrets {
+		return redactConfigSecrets(configBackupPath, cfg.ClickHouse.ConfigRedactTags)
+	}
 	return backupConfigSize, copyErr
 }
 
@@ -284,19 +452,28 @@ func createRBACBackup(ch *clickhouse.ClickHouse, backupPath string, disks []clic
 	if err != nil {
 		return 0, err
 	}
-	apexLog.Debugf("copy %s -> %s", accessPath, rbacBackup)
-	copyErr := copy.Copy(accessPath, rbacBackup, copy.Options{
-		Skip: func(src string) (bool, error) {
-			if fileInfo, err := os.Stat(src); err == nil {
-				rbacDataSize += uint64(fileInfo.Size())
-			}
-			return false, nil
-		},
-	})
-	return rbacDataSize, copyErr
+	if _, err := os.Stat(accessPath); err == nil {
+		apexLog.Debugf("copy %s -> %s", accessPath, rbacBackup)
+		copyErr := copy.Copy(accessPath, rbacBackup, copy.Options{
+			Skip: func(src string) (bool, error) {
+				if fileInfo, err := os.Stat(src); err == nil {
+					rbacDataSize += uint64(fileInfo.Size())
+				}
+				return false, nil
+			},
+		})
+		if copyErr != nil {
+			return rbacDataSize, copyErr
+		}
+	}
+	// replicated access storage is kept in ZooKeeper, so all RBAC objects are dumped as SQL too
+	dumpSize, err := dumpAccessEntities(ch, rbacBackup)
+	return rbacDataSize + dumpSize, err
 }
 
-func AddTableToBackup(ch *clickhouse.ClickHouse, backupName, shadowBackupUUID string, diskList []clickhouse.Disk, table *clickhouse.Table, partitionsToBackupMap common.EmptyMap) (map[string][]metadata.Part, map[string]int64, error) {
+func AddTableToBackup(ctx context.Context, ch *clickhouse.ClickHouse, backupName, shadowBackupUUID string, diskList []clickhouse.Disk, table *clickhouse.Table, partitionsToBackupMap common.EmptyMap, linkMode string, zeroCopy bool) (disksToPartsMap map[string][]metadata.Part, realSize map[string]int64, err error) {
+	ctx, span := tracing.Start(ctx, "create_table", tracing.Table(table.Database, table.Name))
+	defer func() { tracing.End(span, err) }()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "create",
@@ -311,12 +488,27 @@ func AddTableToBackup(ch *clickhouse.ClickHouse, backupName, shadowBackupUUID st
 		log.WithField("engine", table.Engine).Debug("skip table backup")
 		return nil, nil, nil
 	}
-	if err := ch.FreezeTable(table, shadowBackupUUID); err != nil {
+	if err = waitMergesBeforeFreeze(ctx, ch, table, log); err != nil {
 		return nil, nil, err
 	}
+	// shadow increment is removed after parts are moved and when freeze or move fails, so aborted runs don't leave hardlinks in shadow
+	defer func() {
+		if removeErr := removeTableShadow(ch, table, shadowBackupUUID, diskList, zeroCopy, log); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}()
+	freezeStart := time.Now()
+	_, freezeSpan := tracing.Start(ctx, "freeze", tracing.Table(table.Database, table.Name))
+	partitionsFilter := filesystemhelper.GetPartitionsFilterForTable(partitionsToBackupMap, table.Database, table.Name)
+	err = ch.FreezeTable(table, shadowBackupUUID, partitionsFilter.Keys())
+	tracing.End(freezeSpan, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	freezeDuration := time.Since(freezeStart)
 	log.Debug("freezed")
-	realSize := map[string]int64{}
-	disksToPartsMap := map[string][]metadata.Part{}
+	realSize = map[string]int64{}
+	disksToPartsMap = map[string][]metadata.Part{}
 	for _, disk := range diskList {
 		shadowPath := path.Join(disk.Path, "shadow", shadowBackupUUID)
 		if _, err := os.Stat(shadowPath); err != nil && os.IsNotExist(err) {
@@ -329,23 +521,42 @@ func AddTableToBackup(ch *clickhouse.ClickHouse, backupName, shadowBackupUUID st
 			return nil, nil, err
 		}
 		// If partitionsToBackupMap is not empty, only parts in this partition will back up.
-		parts, size, err := filesystemhelper.MoveShadow(shadowPath, backupShadowPath, partitionsToBackupMap)
+		_, moveSpan := tracing.Start(ctx, "copy", tracing.Table(table.Database, table.Name), attribute.String("disk", disk.Name))
+		parts, size, err := filesystemhelper.MoveShadow(shadowPath, backupShadowPath, partitionsFilter, linkMode)
+		tracing.End(moveSpan, err)
 		if err != nil {
 			return nil, nil, err
 		}
 		realSize[disk.Name] = size
 		disksToPartsMap[disk.Name] = parts
 		log.WithField("disk", disk.Name).Debug("shadow moved")
-
-		// Clean all the files under the shadowPath.
-		if err := os.RemoveAll(shadowPath); err != nil {
-			return disksToPartsMap, realSize, err
-		}
 	}
+	stats := TableStats{Operation: "create", Database: table.Database, Table: table.Name, Duration: freezeDuration}
+	for disk := range realSize {
+		stats.Size += uint64(realSize[disk])
+		stats.Parts += len(disksToPartsMap[disk])
+	}
+	reportTableStats(stats)
 	log.Debug("done")
 	return disksToPartsMap, realSize, nil
 }
 
+// removeTableShadow - UNFREEZE release frozen objects on object disks, so it is skipped for zero-copy backups which keep references to these objects
+func removeTableShadow(ch *clickhouse.ClickHouse, table *clickhouse.Table, shadowBackupUUID string, diskList []clickhouse.Disk, zeroCopy bool, log *apexLog.Entry) error {
+	if !zeroCopy {
+		if unfreezeErr := ch.UnfreezeTable(table, shadowBackupUUID); unfreezeErr != nil {
+			log.Warnf("%v", unfreezeErr)
+		}
+	}
+	var err error
+	for _, disk := range diskList {
+		if removeErr := os.RemoveAll(path.Join(disk.Path, "shadow", shadowBackupUUID)); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
 //
 func createMetadata(ch *clickhouse.ClickHouse, backupPath string, table metadata.TableMetadata, disks []clickhouse.Disk) (uint64, error) {
 	metadataPath := path.Join(backupPath, "metadata")
@@ -361,7 +572,7 @@ func createMetadata(ch *clickhouse.ClickHouse, backupPath string, table metadata
 	if err != nil {
 		return 0, fmt.Errorf("can't marshal %s: %v", MetaFileName, err)
 	}
-	if err := ioutil.WriteFile(metadataFile, metadataBody, 0644); err != nil {
+	if err := metadata.WriteFileAtomic(metadataFile, metadataBody, 0644); err != nil {
 		return 0, fmt.Errorf("can't create %s: %v", MetaFileName, err)
 	}
 	if err := filesystemhelper.Chown(metadataFile, ch, disks); err != nil {
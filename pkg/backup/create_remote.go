@@ -1,15 +1,22 @@
 package backup
 
-import "fmt"
+import (
+	"context"
+	"fmt"
 
-func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, rbac, backupConfig bool, version string) error {
-	if backupName == "" {
-		backupName = NewBackupName()
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
+)
+
+func (b *Backuper) CreateToRemote(ctx context.Context, backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, rbac, backupConfig bool, version string) (err error) {
+	if backupName, err = ResolveBackupName(b.cfg, backupName); err != nil {
+		return err
 	}
-	if err := CreateBackup(b.cfg, backupName, tablePattern, partitions, schemaOnly, rbac, backupConfig, version); err != nil {
+	ctx, span := tracing.Start(ctx, "create_remote", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
+	if err := CreateBackup(ctx, b.cfg, backupName, tablePattern, partitions, schemaOnly, rbac, backupConfig, version); err != nil {
 		return err
 	}
-	if err := b.Upload(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly); err != nil {
+	if err := b.Upload(ctx, backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, false); err != nil {
 		return err
 	}
 	if err := RemoveOldBackupsLocal(b.cfg, false, nil); err != nil {
@@ -1,11 +1,17 @@
 package backup
 
 import (
+	"context"
 	"fmt"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"github.com/mxalis/clickhouse-backup/pkg/utils"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
@@ -14,7 +20,7 @@ import (
 	apexLog "github.com/apex/log"
 )
 
-// Clean - removed all data in shadow folder
+// Clean - removed all data in shadow folder, frozen parts unfreeze via SYSTEM UNFREEZE when supported to release zero-copy data on object disks
 func Clean(cfg *config.Config) error {
 	ch := &clickhouse.ClickHouse{
 		Config: &cfg.ClickHouse,
@@ -28,22 +34,168 @@ func Clean(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	start := time.Now()
+	var reclaimed uint64
+	freezeNames := common.EmptyMap{}
+	for _, disk := range disks {
+		shadowDir := path.Join(disk.Path, "shadow")
+		items, err := os.ReadDir(shadowDir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't read '%s': %v", shadowDir, err)
+		}
+		for _, item := range items {
+			freezeNames[item.Name()] = struct{}{}
+		}
+		reclaimed += reclaimableSize(shadowDir)
+	}
+	if len(freezeNames) > 0 {
+		unfreezeShadow(ch, freezeNames)
+	}
 	for _, disk := range disks {
 		shadowDir := path.Join(disk.Path, "shadow")
 		apexLog.Infof("Clean %s", shadowDir)
-		if err := cleanDir(shadowDir); err != nil {
+		if err := cleanDir(shadowDir); err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("can't clean '%s': %v", shadowDir, err)
 		}
 	}
+	apexLog.WithFields(apexLog.Fields{
+		"operation": "clean",
+		"reclaimed": utils.LogBytes(reclaimed),
+		"duration":  utils.LogDuration(time.Since(start)),
+	}).Info("done")
 	return nil
 }
 
+// unfreezeShadow - SYSTEM UNFREEZE available from 22.1 and require enable_system_unfreeze, any error fallback to files removing
+func unfreezeShadow(ch *clickhouse.ClickHouse, freezeNames common.EmptyMap) {
+	if supported, err := ch.SupportsFeature(clickhouse.FeatureSystemUnfreeze); err != nil || !supported {
+		return
+	}
+	for name := range freezeNames {
+		if _, err := ch.Query(fmt.Sprintf("SYSTEM UNFREEZE WITH NAME '%s'", strings.ReplaceAll(name, "'", "\\'"))); err != nil {
+			apexLog.Debugf("can't unfreeze '%s', shadow will remove as files: %v", name, err)
+			if strings.Contains(err.Error(), "SYSTEM UNFREEZE query is disabled") {
+				return
+			}
+		}
+	}
+}
+
+// CleanBroken - remove local backups without metadata.json left by interrupted create or download, old format backups are kept
+func CleanBroken(cfg *config.Config) error {
+	ch := &clickhouse.ClickHouse{
+		Config: &cfg.ClickHouse,
+	}
+	if err := ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+
+	disks, err := ch.GetDisks()
+	if err != nil {
+		return err
+	}
+	backupList, disks, err := GetLocalBackups(cfg, disks)
+	if err != nil {
+		return err
+	}
+	defaultPath, err := ch.GetDefaultPath(disks)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	var reclaimed uint64
+	for _, backup := range backupList {
+		if !backup.Legacy || !isIncompleteLocalBackup(path.Join(defaultPath, "backup", backup.BackupName)) {
+			continue
+		}
+		if isLocalBackupInProgress(backup.BackupName) {
+			apexLog.WithField("backup", backup.BackupName).Info("skip backup created by running operation")
+			continue
+		}
+		size := uint64(0)
+		for _, disk := range disks {
+			size += reclaimableSize(path.Join(disk.Path, "backup", backup.BackupName))
+		}
+		if err := RemoveBackupLocal(cfg, backup.BackupName, disks); err != nil {
+			return err
+		}
+		apexLog.WithFields(apexLog.Fields{"backup": backup.BackupName, "reclaimed": utils.LogBytes(size)}).Info("broken backup removed")
+		reclaimed += size
+	}
+	apexLog.WithFields(apexLog.Fields{
+		"operation": "clean_broken",
+		"reclaimed": utils.LogBytes(reclaimed),
+		"duration":  utils.LogDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+// localBackupsInProgress - local backups which are created, downloaded or imported by running operations in this process
+var localBackupsInProgress = struct {
+	sync.Mutex
+	names map[string]int
+}{names: map[string]int{}}
+
+// markLocalBackupInProgress - protect backup folder from CleanBroken until returned function is called
+func markLocalBackupInProgress(backupName string) func() {
+	localBackupsInProgress.Lock()
+	localBackupsInProgress.names[backupName]++
+	localBackupsInProgress.Unlock()
+	return func() {
+		localBackupsInProgress.Lock()
+		defer localBackupsInProgress.Unlock()
+		if localBackupsInProgress.names[backupName]--; localBackupsInProgress.names[backupName] <= 0 {
+			delete(localBackupsInProgress.names, backupName)
+		}
+	}
+}
+
+func isLocalBackupInProgress(backupName string) bool {
+	localBackupsInProgress.Lock()
+	defer localBackupsInProgress.Unlock()
+	return localBackupsInProgress.names[backupName] > 0
+}
+
+// isIncompleteLocalBackup - backup folder without metadata.json, old format backups contain .sql files in metadata folder
+func isIncompleteLocalBackup(backupPath string) bool {
+	if _, err := os.Stat(path.Join(backupPath, "metadata.json")); !os.IsNotExist(err) {
+		return false
+	}
+	legacySchemas, _ := filepath.Glob(path.Join(backupPath, "metadata", "*", "*.sql"))
+	return len(legacySchemas) == 0
+}
+
+// reclaimableSize - size of files which will free after dirName removal, hardlinked file counted only when all its links inside dirName
+func reclaimableSize(dirName string) uint64 {
+	var size uint64
+	links := map[uint64]uint64{}
+	_ = filepath.Walk(dirName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || stat.Nlink <= 1 {
+			size += uint64(info.Size())
+			return nil
+		}
+		links[stat.Ino]++
+		if links[stat.Ino] == uint64(stat.Nlink) {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
+
 func cleanDir(dirName string) error {
-	if items, err := os.ReadDir(dirName); err != nil {
+	items, err := os.ReadDir(dirName)
+	if err != nil {
 		return err
-	} else {
-		for _, item := range items {
-			_ = os.RemoveAll(item.Name())
+	}
+	for _, item := range items {
+		if err := os.RemoveAll(path.Join(dirName, item.Name())); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -61,7 +213,7 @@ func RemoveOldBackupsLocal(cfg *config.Config, keepLastBackup bool, disks []clic
 	if err != nil {
 		return err
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, keep)
+	backupsToDelete := GetBackupsToDelete(filterLocalBackupsByTags(backupList, cfg.General.RetentionTags), keep)
 	for _, backup := range backupsToDelete {
 		if err := RemoveBackupLocal(cfg, backup.BackupName, disks); err != nil {
 			return err
@@ -103,7 +255,7 @@ func RemoveBackupLocal(cfg *config.Config, backupName string, disks []clickhouse
 			apexLog.WithField("operation", "delete").
 				WithField("location", "local").
 				WithField("backup", backupName).
-				WithField("duration", utils.HumanizeDuration(time.Since(start))).
+				WithField("duration", utils.LogDuration(time.Since(start))).
 				Info("done")
 			return nil
 		}
@@ -111,7 +263,7 @@ func RemoveBackupLocal(cfg *config.Config, backupName string, disks []clickhouse
 	return fmt.Errorf("'%s' is not found on local storage", backupName)
 }
 
-func RemoveBackupRemote(cfg *config.Config, backupName string) error {
+func RemoveBackupRemote(ctx context.Context, cfg *config.Config, backupName string) error {
 	start := time.Now()
 	if cfg.General.RemoteStorage == "none" {
 		fmt.Println("RemoveBackupRemote aborted: RemoteStorage set to \"none\"")
@@ -122,17 +274,17 @@ func RemoveBackupRemote(cfg *config.Config, backupName string) error {
 	if err != nil {
 		return err
 	}
-	err = bd.Connect()
+	err = bd.Connect(ctx)
 	if err != nil {
 		return fmt.Errorf("can't connect to remote storage: %v", err)
 	}
-	backupList, err := bd.BackupList(true, backupName)
+	backupList, err := bd.BackupList(ctx, true, backupName)
 	if err != nil {
 		return err
 	}
 	for _, backup := range backupList {
 		if backup.BackupName == backupName {
-			if err := bd.RemoveBackup(backup); err != nil {
+			if err := bd.RemoveBackup(ctx, backup); err != nil {
 				apexLog.Warnf("RemoveBackup return error: %+v", err)
 				return err
 			}
@@ -140,7 +292,7 @@ func RemoveBackupRemote(cfg *config.Config, backupName string) error {
 				"backup":    backupName,
 				"location":  "remote",
 				"operation": "delete",
-				"duration":  utils.HumanizeDuration(time.Since(start)),
+				"duration":  utils.LogDuration(time.Since(start)),
 			}).Info("done")
 			return nil
 		}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReclaimableSizeAndCleanDir(t *testing.T) {
+	dataDir := t.TempDir()
+	shadowDir := path.Join(dataDir, "shadow")
+	require.NoError(t, os.MkdirAll(path.Join(shadowDir, "backup1", "all_1_1_0"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(shadowDir, "backup1", "all_1_1_0", "data.bin"), make([]byte, 100), 0644))
+	// frozen file still linked from table data, space will not free
+	require.NoError(t, ioutil.WriteFile(path.Join(dataDir, "linked.bin"), make([]byte, 1000), 0644))
+	require.NoError(t, os.Link(path.Join(dataDir, "linked.bin"), path.Join(shadowDir, "backup1", "all_1_1_0", "linked.bin")))
+	// both links inside shadow, space will free
+	require.NoError(t, ioutil.WriteFile(path.Join(shadowDir, "backup1", "all_1_1_0", "twice.bin"), make([]byte, 10), 0644))
+	require.NoError(t, os.Link(path.Join(shadowDir, "backup1", "all_1_1_0", "twice.bin"), path.Join(shadowDir, "backup1", "twice.bin")))
+
+	assert.Equal(t, uint64(110), reclaimableSize(shadowDir))
+	assert.Equal(t, uint64(0), reclaimableSize(path.Join(dataDir, "not_exists")))
+
+	require.NoError(t, cleanDir(shadowDir))
+	items, err := os.ReadDir(shadowDir)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+	_, err = os.Stat(path.Join(dataDir, "linked.bin"))
+	assert.NoError(t, err)
+}
+
+func TestIsIncompleteLocalBackup(t *testing.T) {
+	backupPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(backupPath, "metadata", "db"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(backupPath, "metadata", "db", "t1.json"), []byte("{}"), 0644))
+	assert.True(t, isIncompleteLocalBackup(backupPath))
+
+	require.NoError(t, ioutil.WriteFile(path.Join(backupPath, "metadata", "db", "t1.sql"), []byte("ATTACH TABLE t1"), 0644))
+	assert.False(t, isIncompleteLocalBackup(backupPath), "old format backup")
+
+	require.NoError(t, os.Remove(path.Join(backupPath, "metadata", "db", "t1.sql")))
+	require.NoError(t, ioutil.WriteFile(path.Join(backupPath, "metadata.json"), []byte("{}"), 0644))
+	assert.False(t, isIncompleteLocalBackup(backupPath))
+}
+
+func TestMarkLocalBackupInProgress(t *testing.T) {
+	assert.False(t, isLocalBackupInProgress("backup1"))
+	doneCreate := markLocalBackupInProgress("backup1")
+	doneDownload := markLocalBackupInProgress("backup1")
+	assert.True(t, isLocalBackupInProgress("backup1"))
+	assert.False(t, isLocalBackupInProgress("backup2"))
+	doneCreate()
+	assert.True(t, isLocalBackupInProgress("backup1"))
+	doneDownload()
+	assert.False(t, isLocalBackupInProgress("backup1"))
+}
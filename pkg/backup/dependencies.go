@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+const identifierPattern = "(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)"
+
+var viewToClauseRE = regexp.MustCompile("(?is)^\\s+TO\\s+" + identifierPattern + "(?:\\s*\\.\\s*" + identifierPattern + ")?")
+var distributedTableRE = regexp.MustCompile(`(?i)\bDistributed\s*\(\s*(?:'[^']*'|\w+)\s*,\s*('(?:[^'\\]|\\.)*'|\w+)\s*,\s*('(?:[^'\\]|\\.)*'|\w+)`)
+var dictGetRE = regexp.MustCompile(`(?i)\bdict\w*\s*\(\s*'((?:[^'\\]|\\.)*)'`)
+
+// tableDependencies - objects which shall exist before table is created: sources of views, TO tables of materialized and window views,
+// inner tables, local tables of Distributed engine, CLICKHOUSE source of dictionaries and dictionaries used in dictGet
+func tableDependencies(t metadata.TableMetadata, innerTables map[metadata.TableTitle][]metadata.TableTitle) []metadata.TableTitle {
+	var dependencies []metadata.TableTitle
+	title := func(database, table string) metadata.TableTitle {
+		if database == "" {
+			database = t.Database
+		}
+		return metadata.TableTitle{Database: database, Table: table}
+	}
+	switch engine := getEngineFromQuery(t.Query); engine {
+	case "View", "MaterializedView", "LiveView", "WindowView":
+		if match := createObjectNameRE.FindStringIndex(t.Query); match != nil {
+			if to := viewToClauseRE.FindStringSubmatch(t.Query[match[1]:]); to != nil {
+				if to[2] == "" {
+					dependencies = append(dependencies, title("", unquoteIdentifier(to[1])))
+				} else {
+					dependencies = append(dependencies, title(unquoteIdentifier(to[1]), unquoteIdentifier(to[2])))
+				}
+			}
+		}
+		for _, source := range selectSourceRE.FindAllStringSubmatch(t.Query, -1) {
+			if source[2] == "" {
+				dependencies = append(dependencies, title("", unquoteIdentifier(source[1])))
+			} else {
+				dependencies = append(dependencies, title(unquoteIdentifier(source[1]), unquoteIdentifier(source[2])))
+			}
+		}
+		dependencies = append(dependencies, innerTables[metadata.TableTitle{Database: t.Database, Table: t.Table}]...)
+	case "Dictionary":
+		if source, exists := dictionarySource(t.Query); exists {
+			dependencies = append(dependencies, source)
+		}
+	case "Distributed":
+		if match := distributedTableRE.FindStringSubmatch(t.Query); match != nil {
+			dependencies = append(dependencies, title(unquoteIdentifier(match[1]), unquoteIdentifier(match[2])))
+		}
+	}
+	for _, match := range dictGetRE.FindAllStringSubmatch(t.Query, -1) {
+		name := unquoteStringLiteral("'" + match[1] + "'")
+		if i := strings.Index(name, "."); i > 0 {
+			dependencies = append(dependencies, title(name[:i], name[i+1:]))
+		} else {
+			dependencies = append(dependencies, title("", name))
+		}
+	}
+	return dependencies
+}
+
+// sortTablesByDependencies - topological order of tables for create, dependencies which are absent in tables are ignored,
+// independent tables keep order by engine from ListOfTables.Sort, tables from dependency cycle are created in the same order and createTables will retry them
+func sortTablesByDependencies(tables ListOfTables) ListOfTables {
+	index := make(map[metadata.TableTitle]int, len(tables))
+	for i, t := range tables {
+		index[metadata.TableTitle{Database: t.Database, Table: t.Table}] = i
+	}
+	innerTables := map[metadata.TableTitle][]metadata.TableTitle{}
+	for inner, view := range innerTableOwners(tables) {
+		innerTables[view] = append(innerTables[view], inner)
+	}
+	pending := make([]map[int]bool, len(tables))
+	for i, t := range tables {
+		pending[i] = map[int]bool{}
+		for _, dependency := range tableDependencies(t, innerTables) {
+			if j, exists := index[dependency]; exists && j != i {
+				pending[i][j] = true
+			}
+		}
+	}
+	created := make([]bool, len(tables))
+	result := make(ListOfTables, 0, len(tables))
+	for len(result) < len(tables) {
+		next, cycle := -1, -1
+		for i, t := range tables {
+			if created[i] {
+				continue
+			}
+			if cycle == -1 || getOrderByEngine(t.Query, false) < getOrderByEngine(tables[cycle].Query, false) {
+				cycle = i
+			}
+			if len(pending[i]) == 0 && (next == -1 || getOrderByEngine(t.Query, false) < getOrderByEngine(tables[next].Query, false)) {
+				next = i
+			}
+		}
+		if next == -1 {
+			apexLog.Warnf("`%s`.`%s` has cyclic dependencies, it will be created before its dependencies", tables[cycle].Database, tables[cycle].Table)
+			next = cycle
+		}
+		created[next] = true
+		result = append(result, tables[next])
+		for i := range pending {
+			delete(pending[i], next)
+		}
+	}
+	return result
+}
+
+// reverseTables - tables in opposite order, dependent tables are dropped before their dependencies
+func reverseTables(tables ListOfTables) ListOfTables {
+	result := make(ListOfTables, len(tables))
+	for i, t := range tables {
+		result[len(tables)-1-i] = t
+	}
+	return result
+}
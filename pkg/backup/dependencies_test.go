@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableDependencies(t *testing.T) {
+	assert.Equal(t, []metadata.TableTitle{{Database: "db", Table: "t"}, {Database: "db", Table: "src"}, {Database: "other", Table: "dim"}},
+		tableDependencies(metadata.TableMetadata{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv UUID '" + testViewUUID + "' TO db.t (`id` UInt64) AS SELECT id FROM src JOIN other.dim USING id"}, nil))
+	assert.Equal(t, []metadata.TableTitle{{Database: "db", Table: "t_local"}},
+		tableDependencies(metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = Distributed('cluster', 'db', 't_local', rand())"}, nil))
+	assert.Equal(t, []metadata.TableTitle{{Database: "dicts", Table: "d"}, {Database: "db", Table: "d2"}},
+		tableDependencies(metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64, name String DEFAULT dictGetString('dicts.d', 'name', id), v UInt64 DEFAULT dictGet('d2', 'v', id)) ENGINE = MergeTree ORDER BY id"}, nil))
+	inner := map[metadata.TableTitle][]metadata.TableTitle{{Database: "db", Table: "mv"}: {{Database: "db", Table: ".inner.mv"}}}
+	assert.Equal(t, []metadata.TableTitle{{Database: "db", Table: "src"}, {Database: "db", Table: ".inner.mv"}},
+		tableDependencies(metadata.TableMetadata{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src"}, inner))
+	assert.Empty(t, tableDependencies(metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"}, nil))
+}
+
+func TestSortTablesByDependencies(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "v2", Query: "CREATE VIEW db.v2 AS SELECT * FROM db.v1"},
+		{Database: "db", Table: "v1", Query: "CREATE VIEW db.v1 AS SELECT * FROM db.dist"},
+		{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv TO db.dist (`id` UInt64) AS SELECT id FROM db.src"},
+		{Database: "db", Table: "src", Query: "CREATE TABLE db.src (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "dist", Query: "CREATE TABLE db.dist (id UInt64) ENGINE = Distributed('cluster', 'db', 'local')"},
+		{Database: "db", Table: "local", Query: "CREATE TABLE db.local (id UInt64, name String DEFAULT dictGet('db.d', 'name', id)) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "d", Query: "CREATE DICTIONARY db.d (id UInt64, name String) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 'src' DB 'db')) LIFETIME(0) LAYOUT(FLAT())"},
+		{Database: "db", Table: "c1", Query: "CREATE VIEW db.c1 AS SELECT * FROM db.c2"},
+		{Database: "db", Table: "c2", Query: "CREATE VIEW db.c2 AS SELECT * FROM db.c1"},
+	}
+	var order []string
+	for _, table := range sortTablesByDependencies(tables) {
+		order = append(order, table.Table)
+	}
+	assert.Equal(t, []string{"src", "d", "local", "dist", "v1", "v2", "mv", "c1", "c2"}, order)
+
+	order = nil
+	for _, table := range reverseTables(sortTablesByDependencies(tables[:2])) {
+		order = append(order, table.Table)
+	}
+	assert.Equal(t, []string{"v2", "v1"}, order)
+}
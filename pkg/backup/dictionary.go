@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+var dictionaryFileSourceRE = regexp.MustCompile(`(?is)\bSOURCE\s*\(\s*FILE\s*\([^)]*?\bPATH\s+'((?:[^'\\]|\\.)*)'`)
+var dictionaryClickHouseSourceRE = regexp.MustCompile(`(?is)\bSOURCE\s*\(\s*CLICKHOUSE\s*\(([^)]*)\)`)
+var dictionarySourceTableRE = regexp.MustCompile(`(?is)\bTABLE\s+('(?:[^'\\]|\\.)*'|\w+)`)
+var dictionarySourceDatabaseRE = regexp.MustCompile(`(?is)\bDB\s+('(?:[^'\\]|\\.)*'|\w+)`)
+
+// userFilesPath - `user_files_path` or `user_files` folder on default disk, the same as clickhouse-server default
+func userFilesPath(cfg *config.Config, defaultDataPath string) string {
+	if cfg.ClickHouse.UserFilesPath != "" {
+		return cfg.ClickHouse.UserFilesPath
+	}
+	return path.Join(defaultDataPath, "user_files")
+}
+
+// resolveDictionarySourcePath - relative path of FILE source is relative to user_files_path
+func resolveDictionarySourcePath(sourcePath, userFilesPath string) string {
+	if path.IsAbs(sourcePath) {
+		return sourcePath
+	}
+	return path.Join(userFilesPath, sourcePath)
+}
+
+// readDictionarySourceFiles - snapshot of files from FILE source of dictionary, keys are paths from SOURCE clause
+func readDictionarySourceFiles(query, userFilesPath string) (map[string][]byte, error) {
+	match := dictionaryFileSourceRE.FindStringSubmatch(query)
+	if match == nil {
+		return nil, nil
+	}
+	sourcePath := unquoteStringLiteral("'" + match[1] + "'")
+	content, err := ioutil.ReadFile(resolveDictionarySourcePath(sourcePath, userFilesPath))
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{sourcePath: content}, nil
+}
+
+// restoreDictionarySourceFiles - write FILE source snapshots of restored dictionaries before dictionaries are created
+func restoreDictionarySourceFiles(ch *clickhouse.ClickHouse, tables ListOfTables, userFilesPath string, disks []clickhouse.Disk) error {
+	for _, t := range tables {
+		for sourcePath, content := range t.SourceFiles {
+			filePath := resolveDictionarySourcePath(sourcePath, userFilesPath)
+			if err := os.MkdirAll(path.Dir(filePath), 0750); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filePath, content, 0640); err != nil {
+				return err
+			}
+			if err := filesystemhelper.Chown(filePath, ch, disks); err != nil {
+				return err
+			}
+			apexLog.WithField("dictionary", t.Database+"."+t.Table).Infof("restore source file %s", filePath)
+		}
+	}
+	return nil
+}
+
+// dictionarySource - database and table of CLICKHOUSE dictionary source, database is `default` when DB is not defined
+func dictionarySource(query string) (metadata.TableTitle, bool) {
+	match := dictionaryClickHouseSourceRE.FindStringSubmatch(query)
+	if match == nil {
+		return metadata.TableTitle{}, false
+	}
+	table := dictionarySourceTableRE.FindStringSubmatch(match[1])
+	if table == nil {
+		return metadata.TableTitle{}, false
+	}
+	source := metadata.TableTitle{Database: "default", Table: unquoteIdentifier(table[1])}
+	if database := dictionarySourceDatabaseRE.FindStringSubmatch(match[1]); database != nil {
+		source.Database = unquoteIdentifier(database[1])
+	}
+	return source, true
+}
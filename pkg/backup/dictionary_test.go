@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDictionarySourceFiles(t *testing.T) {
+	userFiles := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(userFiles, "dicts"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(userFiles, "dicts", "regions.tsv"), []byte("1\tEU\n"), 0644))
+
+	files, err := readDictionarySourceFiles("CREATE DICTIONARY db.regions (id UInt64, name String) PRIMARY KEY id SOURCE(FILE(PATH 'dicts/regions.tsv' FORMAT 'TabSeparated')) LIFETIME(0) LAYOUT(FLAT())", userFiles)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"dicts/regions.tsv": []byte("1\tEU\n")}, files)
+
+	files, err = readDictionarySourceFiles("CREATE DICTIONARY db.d (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 't')) LIFETIME(0) LAYOUT(FLAT())", userFiles)
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+
+	_, err = readDictionarySourceFiles("CREATE DICTIONARY db.d (id UInt64) PRIMARY KEY id SOURCE(FILE(PATH 'missing.tsv' FORMAT 'TSV')) LIFETIME(0) LAYOUT(FLAT())", userFiles)
+	assert.Error(t, err)
+	assert.Equal(t, "/data/dict.csv", resolveDictionarySourcePath("/data/dict.csv", userFiles))
+}
+
+func TestSortDictionariesByDependencies(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "d2", Query: "CREATE DICTIONARY db.d2 (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 'd1' DB 'db')) LIFETIME(0) LAYOUT(FLAT())"},
+		{Database: "db", Table: "d1", Query: "CREATE DICTIONARY db.d1 (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 't' DB 'db')) LIFETIME(0) LAYOUT(FLAT())"},
+		{Database: "db", Table: "v", Query: "CREATE VIEW db.v AS SELECT 1"},
+		{Database: "default", Table: "d3", Query: "CREATE DICTIONARY default.d3 (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE d4)) LIFETIME(0) LAYOUT(FLAT())"},
+		{Database: "default", Table: "d4", Query: "CREATE DICTIONARY default.d4 (id UInt64) PRIMARY KEY id SOURCE(FILE(PATH 'x.tsv' FORMAT 'TSV')) LIFETIME(0) LAYOUT(FLAT())"},
+	}
+	tables = sortTablesByDependencies(tables)
+	var order []string
+	for _, table := range tables {
+		order = append(order, table.Table)
+	}
+	assert.Equal(t, []string{"t", "v", "d1", "d2", "d4", "d3"}, order)
+
+	source, exists := dictionarySource(tables[3].Query)
+	assert.True(t, exists)
+	assert.Equal(t, metadata.TableTitle{Database: "db", Table: "d1"}, source)
+}
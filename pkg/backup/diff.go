@@ -0,0 +1,249 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+)
+
+// backupWithTables - backup metadata and metadata of each table, loaded from local or remote backup for diff and tables --backup
+type backupWithTables struct {
+	metadata.BackupMetadata
+	Tables map[metadata.TableTitle]*metadata.TableMetadata
+}
+
+// tableDiff - parts and size changes of table which exists in both backups
+type tableDiff struct {
+	Table         string
+	SchemaChanged bool
+	QueryA        string
+	QueryB        string
+	PartsA        int
+	PartsB        int
+	PartsAdded    int
+	PartsRemoved  int
+	SizeA         int64
+	SizeB         int64
+}
+
+// backupsDiff - all changes between two backups, tables are sorted by name
+type backupsDiff struct {
+	DatabasesAdded   []string
+	DatabasesRemoved []string
+	TablesAdded      []string
+	TablesRemoved    []string
+	Tables           []tableDiff
+}
+
+// Diff - print schema changes, added and removed tables and per-table parts and size deltas between two local or remote backups
+func (b *Backuper) Diff(ctx context.Context, backupA, backupB string, remote bool) error {
+	if backupA == "" || backupB == "" {
+		return fmt.Errorf("two backup names are required")
+	}
+	if remote {
+		if b.cfg.General.RemoteStorage == "none" {
+			return fmt.Errorf("remote storage is 'none'")
+		}
+		var err error
+		if b.dst, err = new_storage.NewBackupDestination(b.cfg, false); err != nil {
+			return err
+		}
+		if err := b.dst.Connect(ctx); err != nil {
+			return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+		}
+	}
+	metaA, err := b.loadBackupWithTables(ctx, backupA, remote)
+	if err != nil {
+		return err
+	}
+	metaB, err := b.loadBackupWithTables(ctx, backupB, remote)
+	if err != nil {
+		return err
+	}
+	return printBackupsDiff(os.Stdout, metaA, metaB, diffBackups(metaA, metaB))
+}
+
+func (b *Backuper) loadBackupWithTables(ctx context.Context, backupName string, remote bool) (*backupWithTables, error) {
+	result := &backupWithTables{Tables: map[metadata.TableTitle]*metadata.TableMetadata{}}
+	if remote {
+		remoteBackups, err := b.dst.BackupList(ctx, true, backupName)
+		if err != nil {
+			return nil, err
+		}
+		var backup *new_storage.Backup
+		for i := range remoteBackups {
+			if remoteBackups[i].BackupName == backupName {
+				backup = &remoteBackups[i]
+				break
+			}
+		}
+		if backup == nil {
+			return nil, fmt.Errorf("'%s' is not found on remote storage", backupName)
+		}
+		if backup.Legacy {
+			return nil, fmt.Errorf("'%s' is old format backup and doesn't supports diff", backupName)
+		}
+		if backup.Broken != "" {
+			return nil, fmt.Errorf("'%s' is broken: %s", backupName, backup.Broken)
+		}
+		result.BackupMetadata = backup.BackupMetadata
+		for _, title := range backup.Tables {
+			tm, err := b.readRemoteTableMetadata(ctx, backupName, title)
+			if err != nil {
+				return nil, fmt.Errorf("can't read %s.%s metadata from '%s': %v", title.Database, title.Table, backupName, err)
+			}
+			result.Tables[title] = tm
+		}
+		return result, nil
+	}
+	backup, disks, err := getLocalBackup(b.cfg, backupName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Legacy {
+		return nil, fmt.Errorf("'%s' is old format backup and doesn't supports diff", backupName)
+	}
+	if backup.Broken != "" {
+		return nil, fmt.Errorf("'%s' is broken: %s", backupName, backup.Broken)
+	}
+	if err := b.initDisks(disks); err != nil {
+		return nil, err
+	}
+	result.BackupMetadata = backup.BackupMetadata
+	for _, title := range backup.Tables {
+		metadataFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(title.Database), fmt.Sprintf("%s.json", common.TablePathEncode(title.Table)))
+		tm := &metadata.TableMetadata{}
+		if _, err := tm.Load(metadataFile); err != nil {
+			return nil, fmt.Errorf("can't load %s: %v", metadataFile, err)
+		}
+		result.Tables[title] = tm
+	}
+	return result, nil
+}
+
+func diffBackups(a, b *backupWithTables) backupsDiff {
+	diff := backupsDiff{}
+	databasesA, databasesB := common.EmptyMap{}, common.EmptyMap{}
+	for _, db := range a.Databases {
+		databasesA[db.Name] = struct{}{}
+	}
+	for _, db := range b.Databases {
+		databasesB[db.Name] = struct{}{}
+		if _, exists := databasesA[db.Name]; !exists {
+			diff.DatabasesAdded = append(diff.DatabasesAdded, db.Name)
+		}
+	}
+	for _, db := range a.Databases {
+		if _, exists := databasesB[db.Name]; !exists {
+			diff.DatabasesRemoved = append(diff.DatabasesRemoved, db.Name)
+		}
+	}
+	for title, tmB := range b.Tables {
+		tableName := fmt.Sprintf("%s.%s", title.Database, title.Table)
+		tmA, exists := a.Tables[title]
+		if !exists {
+			diff.TablesAdded = append(diff.TablesAdded, tableName)
+			continue
+		}
+		partsA, partsB := tableMetadataParts(tmA), tableMetadataParts(tmB)
+		td := tableDiff{
+			Table:         tableName,
+			SchemaChanged: tmA.Query != tmB.Query,
+			QueryA:        tmA.Query,
+			QueryB:        tmB.Query,
+			PartsA:        len(partsA),
+			PartsB:        len(partsB),
+			SizeA:         tableMetadataSize(tmA),
+			SizeB:         tableMetadataSize(tmB),
+		}
+		for part := range partsB {
+			if _, exists := partsA[part]; !exists {
+				td.PartsAdded++
+			}
+		}
+		for part := range partsA {
+			if _, exists := partsB[part]; !exists {
+				td.PartsRemoved++
+			}
+		}
+		diff.Tables = append(diff.Tables, td)
+	}
+	for title := range a.Tables {
+		if _, exists := b.Tables[title]; !exists {
+			diff.TablesRemoved = append(diff.TablesRemoved, fmt.Sprintf("%s.%s", title.Database, title.Table))
+		}
+	}
+	sort.Strings(diff.DatabasesAdded)
+	sort.Strings(diff.DatabasesRemoved)
+	sort.Strings(diff.TablesAdded)
+	sort.Strings(diff.TablesRemoved)
+	sort.Slice(diff.Tables, func(i, j int) bool { return diff.Tables[i].Table < diff.Tables[j].Table })
+	return diff
+}
+
+// tableMetadataParts - set of "disk/part" names, the same part on another disk counted as removed and added
+func tableMetadataParts(tm *metadata.TableMetadata) common.EmptyMap {
+	parts := common.EmptyMap{}
+	for disk, diskParts := range tm.Parts {
+		for _, part := range diskParts {
+			parts[path.Join(disk, part.Name)] = struct{}{}
+		}
+	}
+	return parts
+}
+
+func tableMetadataSize(tm *metadata.TableMetadata) int64 {
+	size := int64(0)
+	for _, diskSize := range tm.Size {
+		size += diskSize
+	}
+	return size
+}
+
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + utils.FormatBytes(uint64(-delta))
+	}
+	return "+" + utils.FormatBytes(uint64(delta))
+}
+
+func printBackupsDiff(out io.Writer, a, b *backupWithTables, diff backupsDiff) error {
+	fmt.Fprintf(out, "--- %s\t%s\n", a.BackupName, a.CreationDate.Format("02/01/2006 15:04:05"))
+	fmt.Fprintf(out, "+++ %s\t%s\n", b.BackupName, b.CreationDate.Format("02/01/2006 15:04:05"))
+	for _, db := range diff.DatabasesAdded {
+		fmt.Fprintf(out, "database added: %s\n", db)
+	}
+	for _, db := range diff.DatabasesRemoved {
+		fmt.Fprintf(out, "database removed: %s\n", db)
+	}
+	for _, table := range diff.TablesAdded {
+		fmt.Fprintf(out, "table added: %s\n", table)
+	}
+	for _, table := range diff.TablesRemoved {
+		fmt.Fprintf(out, "table removed: %s\n", table)
+	}
+	for _, td := range diff.Tables {
+		if td.SchemaChanged {
+			fmt.Fprintf(out, "schema changed: %s\n- %s\n+ %s\n", td.Table, td.QueryA, td.QueryB)
+		}
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "table\tparts\tadded\tremoved\tsize\tdelta")
+	for _, td := range diff.Tables {
+		if td.PartsAdded == 0 && td.PartsRemoved == 0 && td.SizeA == td.SizeB {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d -> %d\t%d\t%d\t%s -> %s\t%s\n", td.Table, td.PartsA, td.PartsB, td.PartsAdded, td.PartsRemoved, utils.FormatBytes(uint64(td.SizeA)), utils.FormatBytes(uint64(td.SizeB)), formatSizeDelta(td.SizeB-td.SizeA))
+	}
+	fmt.Fprintf(w, "total\t\t\t\t%s -> %s\t%s\n", utils.FormatBytes(a.DataSize), utils.FormatBytes(b.DataSize), formatSizeDelta(int64(b.DataSize)-int64(a.DataSize)))
+	return w.Flush()
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffBackups(t *testing.T) {
+	tableMeta := func(query string, size int64, parts ...string) *metadata.TableMetadata {
+		tm := &metadata.TableMetadata{Query: query, Parts: map[string][]metadata.Part{}, Size: map[string]int64{"default": size}}
+		for _, part := range parts {
+			tm.Parts["default"] = append(tm.Parts["default"], metadata.Part{Name: part})
+		}
+		return tm
+	}
+	a := &backupWithTables{
+		BackupMetadata: metadata.BackupMetadata{BackupName: "a", DataSize: 300, Databases: []metadata.DatabasesMeta{{Name: "db"}, {Name: "old"}}},
+		Tables: map[metadata.TableTitle]*metadata.TableMetadata{
+			{Database: "db", Table: "t1"}:  tableMeta("CREATE TABLE db.t1 (id UInt64)", 100, "all_1_1_0", "all_2_2_0"),
+			{Database: "db", Table: "t2"}:  tableMeta("CREATE TABLE db.t2 (id UInt64)", 100, "all_1_1_0"),
+			{Database: "old", Table: "t3"}: tableMeta("CREATE TABLE old.t3 (id UInt64)", 100, "all_1_1_0"),
+		},
+	}
+	b := &backupWithTables{
+		BackupMetadata: metadata.BackupMetadata{BackupName: "b", DataSize: 350, Databases: []metadata.DatabasesMeta{{Name: "db"}, {Name: "new"}}},
+		Tables: map[metadata.TableTitle]*metadata.TableMetadata{
+			{Database: "db", Table: "t1"}:  tableMeta("CREATE TABLE db.t1 (id UInt64, name String)", 150, "all_1_2_1", "all_3_3_0"),
+			{Database: "db", Table: "t2"}:  tableMeta("CREATE TABLE db.t2 (id UInt64)", 100, "all_1_1_0"),
+			{Database: "new", Table: "t4"}: tableMeta("CREATE TABLE new.t4 (id UInt64)", 100, "all_1_1_0"),
+		},
+	}
+	diff := diffBackups(a, b)
+	assert.Equal(t, []string{"new"}, diff.DatabasesAdded)
+	assert.Equal(t, []string{"old"}, diff.DatabasesRemoved)
+	assert.Equal(t, []string{"new.t4"}, diff.TablesAdded)
+	assert.Equal(t, []string{"old.t3"}, diff.TablesRemoved)
+	require.Len(t, diff.Tables, 2)
+	assert.Equal(t, tableDiff{
+		Table: "db.t1", SchemaChanged: true, QueryA: "CREATE TABLE db.t1 (id UInt64)", QueryB: "CREATE TABLE db.t1 (id UInt64, name String)",
+		PartsA: 2, PartsB: 2, PartsAdded: 2, PartsRemoved: 2, SizeA: 100, SizeB: 150,
+	}, diff.Tables[0])
+	assert.False(t, diff.Tables[1].SchemaChanged)
+	assert.Equal(t, 0, diff.Tables[1].PartsAdded+diff.Tables[1].PartsRemoved)
+
+	var out bytes.Buffer
+	require.NoError(t, printBackupsDiff(&out, a, b, diff))
+	assert.Contains(t, out.String(), "table added: new.t4\n")
+	assert.Contains(t, out.String(), "schema changed: db.t1\n")
+	assert.Contains(t, out.String(), "+50B")
+	assert.NotContains(t, out.String(), "db.t2")
+}
@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+// applyRestoreDiskMapping - return disks where each source disk from `restore_disk_mapping` has path and type of target disk,
+// so parts of source disk are downloaded and attached to target disk
+func applyRestoreDiskMapping(cfg *config.Config, disks []clickhouse.Disk) ([]clickhouse.Disk, error) {
+	if len(cfg.General.RestoreDiskMapping) == 0 {
+		return disks, nil
+	}
+	result := make([]clickhouse.Disk, len(disks))
+	copy(result, disks)
+	targets := map[string]clickhouse.Disk{}
+	for _, disk := range disks {
+		targets[disk.Name] = disk
+	}
+	for src, dst := range cfg.General.RestoreDiskMapping {
+		target, exists := targets[dst]
+		if !exists {
+			return nil, fmt.Errorf("restore_disk_mapping target disk '%s' for '%s' is not found in system.disks", dst, src)
+		}
+		found := false
+		for i := range result {
+			if result[i].Name == src {
+				result[i].Path = target.Path
+				result[i].Type = target.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, clickhouse.Disk{Name: src, Path: target.Path, Type: target.Type})
+		}
+	}
+	return result, nil
+}
+
+// requiredSpaceByDisk - sum size of parts for each target disk, source disks are translated with diskMapping, unknown disks are restored to default disk
+func requiredSpaceByDisk(tables []metadata.TableMetadata, diskMapping map[string]string, knownDisks map[string]uint64) map[string]uint64 {
+	required := map[string]uint64{}
+	for _, table := range tables {
+		for disk := range table.Parts {
+			target := disk
+			if dst, isMapped := diskMapping[disk]; isMapped {
+				target = dst
+			} else if _, exists := knownDisks[disk]; !exists {
+				target = "default"
+			}
+			if size := partsSize(table, disk); size > 0 {
+				required[target] += size
+			}
+		}
+	}
+	return required
+}
+
+// partsSize - size of table parts on disk
+func partsSize(table metadata.TableMetadata, disk string) uint64 {
+	size := int64(0)
+	for _, part := range table.Parts[disk] {
+		if part.Size == 0 {
+			// parts size is unknown in old backups, use size of all parts on disk
+			return uint64(table.Size[disk])
+		}
+		size += part.Size
+	}
+	return uint64(size)
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyRestoreDiskMapping(t *testing.T) {
+	cfg := config.DefaultConfig()
+	disks := []clickhouse.Disk{
+		{Name: "default", Path: "/var/lib/clickhouse/", Type: "local"},
+		{Name: "hdd", Path: "/mnt/hdd/", Type: "local"},
+		{Name: "s3", Path: "/var/lib/clickhouse/disks/s3/", Type: "s3"},
+	}
+	result, err := applyRestoreDiskMapping(cfg, disks)
+	assert.NoError(t, err)
+	assert.Equal(t, disks, result)
+
+	cfg.General.RestoreDiskMapping = map[string]string{"s3": "default", "ssd": "hdd"}
+	result, err = applyRestoreDiskMapping(cfg, disks)
+	assert.NoError(t, err)
+	assert.Equal(t, []clickhouse.Disk{
+		{Name: "default", Path: "/var/lib/clickhouse/", Type: "local"},
+		{Name: "hdd", Path: "/mnt/hdd/", Type: "local"},
+		{Name: "s3", Path: "/var/lib/clickhouse/", Type: "local"},
+		{Name: "ssd", Path: "/mnt/hdd/", Type: "local"},
+	}, result)
+	assert.Equal(t, "s3", disks[2].Type, "source disks shall not be changed")
+
+	cfg.General.RestoreDiskMapping = map[string]string{"s3": "unknown"}
+	_, err = applyRestoreDiskMapping(cfg, disks)
+	assert.Error(t, err)
+}
+
+func TestRequiredSpaceByDisk(t *testing.T) {
+	tables := []metadata.TableMetadata{
+		{
+			Parts: map[string][]metadata.Part{
+				"default": {{Name: "all_1_1_0", Size: 10}, {Name: "all_2_2_0", Size: 20}},
+				"s3":      {{Name: "all_3_3_0", Size: 100}},
+			},
+		},
+		{
+			Parts: map[string][]metadata.Part{
+				"missing": {{Name: "all_1_1_0"}},
+				"hdd":     {},
+			},
+			Size: map[string]int64{"missing": 5},
+		},
+	}
+	knownDisks := map[string]uint64{"default": 1000, "hdd": 1000}
+	assert.Equal(t, map[string]uint64{"default": 35, "hdd": 100}, requiredSpaceByDisk(tables, map[string]string{"s3": "hdd"}, knownDisks))
+}
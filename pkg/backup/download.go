@@ -2,15 +2,14 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
-	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,9 +21,11 @@ import (
 	"github.com/mxalis/clickhouse-backup/pkg/metadata"
 	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
 	legacyStorage "github.com/mxalis/clickhouse-backup/pkg/storage"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
 	"github.com/mxalis/clickhouse-backup/pkg/utils"
 
 	apexLog "github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -51,7 +52,9 @@ func legacyDownload(ctx context.Context, cfg *config.Config, defaultDataPath, ba
 	return nil
 }
 
-func (b *Backuper) Download(backupName string, tablePattern string, partitions []string, schemaOnly bool) error {
+func (b *Backuper) Download(ctx context.Context, backupName string, tablePattern string, partitions []string, schemaOnly, resume bool) (err error) {
+	ctx, span := tracing.Start(ctx, "download", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "download",
@@ -60,16 +63,21 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		return fmt.Errorf("remote storage is 'none'")
 	}
 	if backupName == "" {
-		_ = PrintRemoteBackups(b.cfg, "all")
+		_ = PrintRemoteBackups(ctx, b.cfg, "all", "text", nil)
 		return fmt.Errorf("select backup for download")
 	}
+	defer markLocalBackupInProgress(backupName)()
 	localBackups, disks, err := GetLocalBackups(b.cfg, nil)
 	if err != nil {
 		return err
 	}
 	for i := range localBackups {
 		if backupName == localBackups[i].BackupName {
-			return ErrBackupIsAlreadyExists
+			// local backup without metadata.json is listed as legacy, it is left by interrupted download
+			if !resume || !localBackups[i].Legacy {
+				return ErrBackupIsAlreadyExists
+			}
+			log.Infof("'%s' exists locally without metadata.json, continue interrupted download", backupName)
 		}
 	}
 	startDownload := time.Now()
@@ -77,10 +85,10 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer b.ch.Close()
-	if err := b.init(disks); err != nil {
+	if err := b.init(ctx, disks); err != nil {
 		return err
 	}
-	remoteBackups, err := b.dst.BackupList(true, backupName)
+	remoteBackups, err := b.dst.BackupList(ctx, true, backupName)
 	if err != nil {
 		return err
 	}
@@ -96,6 +104,9 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if !found {
 		return fmt.Errorf("'%s' is not found on remote storage", backupName)
 	}
+	if remoteBackup.Broken != "" {
+		return fmt.Errorf("'%s' is %s", backupName, remoteBackup.Broken)
+	}
 	//look https://github.com/mxalis/clickhouse-backup/discussions/266 need download legacy before check for empty backup
 	if remoteBackup.Legacy {
 		if tablePattern != "" {
@@ -105,7 +116,10 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 			return fmt.Errorf("'%s' is old format backup and doesn't supports download of schema only", backupName)
 		}
 		log.Warnf("'%s' is old-format backup", backupName)
-		return legacyDownload(context.Background(), b.cfg, b.DefaultDataPath, backupName)
+		return legacyDownload(ctx, b.cfg, b.DefaultDataPath, backupName)
+	}
+	if remoteBackup.Cluster != nil || remoteBackup.DataFormat == clusterBackupFormat {
+		return fmt.Errorf("'%s' is cluster backup, use restore_cluster", backupName)
 	}
 	if len(remoteBackup.Tables) == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("'%s' is empty backup", backupName)
@@ -114,7 +128,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	tableMetadataForDownload := make([]metadata.TableMetadata, len(tablesForDownload))
 
 	if !schemaOnly && !b.cfg.General.DownloadByPart && remoteBackup.RequiredBackup != "" {
-		err := b.Download(remoteBackup.RequiredBackup, tablePattern, partitions, schemaOnly)
+		err := b.Download(ctx, remoteBackup.RequiredBackup, tablePattern, partitions, schemaOnly, resume)
 		if err != nil && err != ErrBackupIsAlreadyExists {
 			return err
 		}
@@ -126,13 +140,23 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if err != nil {
 		return err
 	}
+	downloadStatePath := path.Join(b.DefaultDataPath, "backup", backupName, downloadStateFile)
+	state, err := openDownloadState(downloadStatePath, resume)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := state.Close(); closeErr != nil {
+			log.Warnf("can't close %s: %v", downloadStatePath, closeErr)
+		}
+	}()
 	partitionsToDownloadMap := filesystemhelper.CreatePartitionsToBackupMap(partitions)
 
-	log.Debugf("prepare table METADATA concurrent semaphore with concurrency=%d len(tableMetadataForDownload)=%d", b.cfg.General.DownloadConcurrency, len(tableMetadataForDownload))
-	s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
-	g, ctx := errgroup.WithContext(context.Background())
+	log.Debugf("prepare table METADATA concurrent semaphore with concurrency=%d len(tableMetadataForDownload)=%d", b.cfg.GetDownloadConcurrency(), len(tableMetadataForDownload))
+	s := semaphore.NewWeighted(int64(b.cfg.GetDownloadConcurrency()))
+	g, metadataCtx := errgroup.WithContext(ctx)
 	for i, t := range tablesForDownload {
-		if err := s.Acquire(ctx, 1); err != nil {
+		if err := s.Acquire(metadataCtx, 1); err != nil {
 			log.Errorf("can't acquire semaphore during Download metadata: %v", err)
 			break
 		}
@@ -141,7 +165,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		tableTitle := t
 		g.Go(func() error {
 			defer s.Release(1)
-			downloadedMetadata, size, err := b.downloadTableMetadata(backupName, log, tableTitle, schemaOnly, partitionsToDownloadMap)
+			downloadedMetadata, size, err := b.downloadTableMetadata(ctx, backupName, log, tableTitle, schemaOnly, partitionsToDownloadMap)
 			if err != nil {
 				return err
 			}
@@ -154,6 +178,16 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		return fmt.Errorf("one of Download Metadata go-routine return error: %v", err)
 	}
 	if !schemaOnly {
+		for src, dst := range b.cfg.General.RestoreDiskMapping {
+			dstPath, exists := b.DiskToPathMap[dst]
+			if !exists {
+				return fmt.Errorf("restore_disk_mapping target disk '%s' for '%s' is not found in system.disks", dst, src)
+			}
+			b.DiskToPathMap[src] = dstPath
+		}
+		if err := checkDisksFreeSpace(b.cfg, b.ch, tableMetadataForDownload); err != nil {
+			return err
+		}
 		for _, t := range tableMetadataForDownload {
 			for disk := range t.Parts {
 				if _, diskExists := b.DiskToPathMap[disk]; !diskExists {
@@ -162,9 +196,9 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 				}
 			}
 		}
-		log.Debugf("prepare table SHADOW concurrent semaphore with concurrency=%d len(tableMetadataForDownload)=%d", b.cfg.General.DownloadConcurrency, len(tableMetadataForDownload))
-		s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
-		g, ctx := errgroup.WithContext(context.Background())
+		log.Debugf("prepare table SHADOW concurrent semaphore with concurrency=%d len(tableMetadataForDownload)=%d", b.cfg.GetDownloadConcurrency(), len(tableMetadataForDownload))
+		s := semaphore.NewWeighted(int64(b.cfg.GetDownloadConcurrency()))
+		g, ctx := errgroup.WithContext(ctx)
 
 		for i, tableMetadata := range tableMetadataForDownload {
 			if tableMetadata.MetadataOnly {
@@ -179,14 +213,17 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 			g.Go(func() error {
 				defer s.Release(1)
 				start := time.Now()
-				if err := b.downloadTableData(remoteBackup.BackupMetadata, tableMetadataForDownload[idx]); err != nil {
+				tableCtx, tableSpan := tracing.Start(ctx, "download_table", tracing.Table(tableMetadataForDownload[idx].Database, tableMetadataForDownload[idx].Table))
+				err := b.downloadTableData(tableCtx, remoteBackup.BackupMetadata, tableMetadataForDownload[idx], partitionsToDownloadMap, state)
+				tracing.End(tableSpan, err)
+				if err != nil {
 					return err
 				}
 				log.
 					WithField("operation", "download_data").
 					WithField("table", fmt.Sprintf("%s.%s", tableMetadataForDownload[idx].Database, tableMetadataForDownload[idx].Table)).
-					WithField("duration", utils.HumanizeDuration(time.Since(start))).
-					WithField("size", utils.FormatBytes(tableMetadataForDownload[idx].TotalBytes)).
+					WithField("duration", utils.LogDuration(time.Since(start))).
+					WithField("size", utils.LogBytes(tableMetadataForDownload[idx].TotalBytes)).
 					Info("done")
 				return nil
 			})
@@ -195,16 +232,21 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 			return fmt.Errorf("one of Download go-routine return error: %v", err)
 		}
 	}
-	rbacSize, err := b.downloadRBACData(remoteBackup)
+	rbacSize, err := b.downloadRBACData(ctx, remoteBackup, state)
 	if err != nil {
 		return fmt.Errorf("download RBAC error: %v", err)
 	}
 
-	configSize, err := b.downloadConfigData(remoteBackup)
+	configSize, err := b.downloadConfigData(ctx, remoteBackup, state)
 	if err != nil {
 		return fmt.Errorf("download CONFIGS error: %v", err)
 	}
 
+	nativeSize, err := b.downloadBackupRelatedDir(ctx, remoteBackup, nativeBackupDir, state)
+	if err != nil {
+		return fmt.Errorf("download native backup error: %v", err)
+	}
+
 	backupMetadata := remoteBackup.BackupMetadata
 	backupMetadata.Tables = tablesForDownload
 	backupMetadata.DataSize = dataSize
@@ -214,49 +256,41 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	backupMetadata.RequiredBackup = ""
 	backupMetadata.ConfigSize = configSize
 	backupMetadata.RBACSize = rbacSize
+	backupMetadata.NativeSize = nativeSize
 
 	backupMetafileLocalPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
 	if err := backupMetadata.Save(backupMetafileLocalPath); err != nil {
 		return err
 	}
+	if err = os.Remove(downloadStatePath); err != nil {
+		log.Warnf("can't remove %s: %v", downloadStatePath, err)
+	}
 	log.
-		WithField("duration", utils.HumanizeDuration(time.Since(startDownload))).
-		WithField("size", utils.FormatBytes(dataSize+metadataSize+rbacSize+configSize)).
+		WithField("duration", utils.LogDuration(time.Since(startDownload))).
+		WithField("size", utils.LogBytes(dataSize+metadataSize+rbacSize+configSize)).
 		Info("done")
 	return nil
 }
 
-func (b *Backuper) downloadTableMetadataIfNotExists(backupName string, log *apexLog.Entry, tableTitle metadata.TableTitle) (*metadata.TableMetadata, error) {
+func (b *Backuper) downloadTableMetadataIfNotExists(ctx context.Context, backupName string, log *apexLog.Entry, tableTitle metadata.TableTitle) (*metadata.TableMetadata, error) {
 	metadataLocalFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
 	tm := &metadata.TableMetadata{}
 	if _, err := tm.Load(metadataLocalFile); err == nil {
 		return tm, nil
 	}
-	tm, _, err := b.downloadTableMetadata(backupName, log.WithFields(apexLog.Fields{"operation": "downloadTableMetadataIfNotExists", "backupName": backupName, "table_metadata_diff": fmt.Sprintf("%s.%s", tableTitle.Database, tableTitle.Table)}), tableTitle, false, nil)
+	tm, _, err := b.downloadTableMetadata(ctx, backupName, log.WithFields(apexLog.Fields{"operation": "downloadTableMetadataIfNotExists", "backupName": backupName, "table_metadata_diff": fmt.Sprintf("%s.%s", tableTitle.Database, tableTitle.Table)}), tableTitle, false, nil)
 	return tm, err
 }
 
-func (b *Backuper) downloadTableMetadata(backupName string, log *apexLog.Entry, tableTitle metadata.TableTitle, schemaOnly bool, partitionsFilter common.EmptyMap) (*metadata.TableMetadata, uint64, error) {
+func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string, log *apexLog.Entry, tableTitle metadata.TableTitle, schemaOnly bool, partitionsFilter common.EmptyMap) (*metadata.TableMetadata, uint64, error) {
 	start := time.Now()
 	size := uint64(0)
-	remoteTableMetadata := path.Join(backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
-	tmReader, err := b.dst.GetFileReader(remoteTableMetadata)
-	if err != nil {
-		return nil, 0, err
-	}
-	tmBody, err := ioutil.ReadAll(tmReader)
-	if err != nil {
-		return nil, 0, err
-	}
-	err = tmReader.Close()
+	remoteTableMetadata, err := b.readRemoteTableMetadata(ctx, backupName, tableTitle)
 	if err != nil {
 		return nil, 0, err
 	}
-	var tableMetadata metadata.TableMetadata
-	if err = json.Unmarshal(tmBody, &tableMetadata); err != nil {
-		return nil, 0, err
-	}
-	filterPartsByPartitionsFilter(tableMetadata, partitionsFilter)
+	tableMetadata := *remoteTableMetadata
+	filterDownloadByPartitions(&tableMetadata, partitionsFilter)
 	// save metadata
 	metadataLocalFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
 	size, err = tableMetadata.Save(metadataLocalFile, schemaOnly)
@@ -264,40 +298,101 @@ func (b *Backuper) downloadTableMetadata(backupName string, log *apexLog.Entry,
 		return nil, 0, err
 	}
 	log.
-		WithField("duration", utils.HumanizeDuration(time.Since(start))).
-		WithField("size", utils.FormatBytes(size)).
+		WithField("duration", utils.LogDuration(time.Since(start))).
+		WithField("size", utils.LogBytes(size)).
 		Info("done")
 	return &tableMetadata, size, nil
 }
 
-func (b *Backuper) downloadRBACData(remoteBackup new_storage.Backup) (uint64, error) {
-	return b.downloadBackupRelatedDir(remoteBackup, "access")
+func (b *Backuper) downloadRBACData(ctx context.Context, remoteBackup new_storage.Backup, state *downloadState) (uint64, error) {
+	return b.downloadBackupRelatedDir(ctx, remoteBackup, "access", state)
 }
 
-func (b *Backuper) downloadConfigData(remoteBackup new_storage.Backup) (uint64, error) {
-	return b.downloadBackupRelatedDir(remoteBackup, "configs")
+func (b *Backuper) downloadConfigData(ctx context.Context, remoteBackup new_storage.Backup, state *downloadState) (uint64, error) {
+	return b.downloadBackupRelatedDir(ctx, remoteBackup, "configs", state)
 }
 
-func (b *Backuper) downloadBackupRelatedDir(remoteBackup new_storage.Backup, prefix string) (uint64, error) {
+func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup new_storage.Backup, prefix string, state *downloadState) (uint64, error) {
 	archiveFile := fmt.Sprintf("%s.%s", prefix, b.cfg.GetArchiveExtension())
 	remoteFile := path.Join(remoteBackup.BackupName, archiveFile)
 	localDir := path.Join(b.DefaultDataPath, "backup", remoteBackup.BackupName, prefix)
-	remoteFileInfo, err := b.dst.StatFile(remoteFile)
+	remoteFileInfo, err := b.dst.StatFile(ctx, remoteFile)
 	if err != nil {
 		apexLog.Debugf("%s not exists on remote storage, skip download", remoteFile)
 		return 0, nil
 	}
-	if err = b.dst.DownloadCompressedStream(context.Background(), remoteFile, localDir); err != nil {
+	if state.IsCompleted(remoteFile) {
+		return uint64(remoteFileInfo.Size()), nil
+	}
+	if err = b.dst.DownloadCompressedStream(ctx, remoteFile, localDir); err != nil {
+		return 0, err
+	}
+	if err = state.CompleteFile(remoteFile, remoteFileInfo.Size()); err != nil {
 		return 0, err
 	}
 	return uint64(remoteFileInfo.Size()), nil
 }
 
-func (b *Backuper) downloadTableData(remoteBackup metadata.BackupMetadata, table metadata.TableMetadata) error {
+// filterDownloadByPartitions - keep parts and archives of parts which match `--partitions`, tables without filter are kept as is
+func filterDownloadByPartitions(tm *metadata.TableMetadata, partitionsFilter common.EmptyMap) {
+	if len(filesystemhelper.GetPartitionsFilterForTable(partitionsFilter, tm.Database, tm.Table)) == 0 {
+		return
+	}
+	partsBeforeFilter := make(map[string][]metadata.Part, len(tm.Parts))
+	for disk, parts := range tm.Parts {
+		partsBeforeFilter[disk] = parts
+	}
+	// parts and files maps are shared with metadata read from remote storage
+	tm.Parts = make(map[string][]metadata.Part, len(partsBeforeFilter))
+	for disk, parts := range partsBeforeFilter {
+		tm.Parts[disk] = parts
+	}
+	files := tm.Files
+	tm.Files = make(map[string][]string, len(files))
+	for disk, archives := range files {
+		tm.Files[disk] = archives
+	}
+	filterPartsByPartitionsFilter(*tm, partitionsFilter)
+	filterArchivesByParts(tm, partsBeforeFilter)
+}
+
+// filterArchivesByParts - keep archives of parts left after partitions filter when backup uploaded by part, archives split by size contain several parts and are kept
+func filterArchivesByParts(tm *metadata.TableMetadata, partsBeforeFilter map[string][]metadata.Part) {
+	archivePart := func(disk, archiveFile string, parts []metadata.Part) bool {
+		for _, part := range parts {
+			if strings.HasPrefix(archiveFile, fmt.Sprintf("%s_%s.", disk, common.TablePathEncode(part.Name))) {
+				return true
+			}
+		}
+		return false
+	}
+	for disk, archives := range tm.Files {
+		uploadedByPart := true
+		for _, archiveFile := range archives {
+			if !archivePart(disk, archiveFile, partsBeforeFilter[disk]) {
+				uploadedByPart = false
+				break
+			}
+		}
+		if !uploadedByPart {
+			apexLog.Warnf("%s.%s archives on disk '%s' are split by size, all of them will download and only selected partitions will restore", tm.Database, tm.Table, disk)
+			continue
+		}
+		filteredArchives := make([]string, 0, len(archives))
+		for _, archiveFile := range archives {
+			if archivePart(disk, archiveFile, tm.Parts[disk]) {
+				filteredArchives = append(filteredArchives, archiveFile)
+			}
+		}
+		tm.Files[disk] = filteredArchives
+	}
+}
+
+func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.BackupMetadata, table metadata.TableMetadata, partitionsFilter common.EmptyMap, state *downloadState) error {
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 
-	s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
-	g, ctx := errgroup.WithContext(context.Background())
+	s := b.downloadSemaphore()
+	g, dataCtx := errgroup.WithContext(ctx)
 
 	if remoteBackup.DataFormat != "directory" {
 		capacity := 0
@@ -306,14 +401,14 @@ func (b *Backuper) downloadTableData(remoteBackup metadata.BackupMetadata, table
 			capacity += len(table.Files[disk])
 			downloadOffset[disk] = 0
 		}
-		apexLog.Debugf("start downloadTableData %s.%s with concurrency=%d len(table.Files[...])=%d", table.Database, table.Table, b.cfg.General.DownloadConcurrency, capacity)
+		apexLog.Debugf("start downloadTableData %s.%s with concurrency=%d len(table.Files[...])=%d", table.Database, table.Table, b.cfg.GetDownloadConcurrency(), capacity)
 		breakByError := false
 		for common.SumMapValuesInt(downloadOffset) < capacity && !breakByError {
 			for disk := range table.Files {
 				if downloadOffset[disk] >= len(table.Files[disk]) {
 					continue
 				}
-				if err := s.Acquire(ctx, 1); err != nil {
+				if err := s.Acquire(dataCtx, 1); err != nil {
 					apexLog.Errorf("can't acquire semaphore during downloadTableData: %v", err)
 					breakByError = true
 					break
@@ -323,13 +418,24 @@ func (b *Backuper) downloadTableData(remoteBackup metadata.BackupMetadata, table
 				archiveFile := table.Files[disk][downloadOffset[disk]]
 				downloadOffset[disk] += 1
 				tableRemoteFile := path.Join(remoteBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), archiveFile)
+				if state.IsCompleted(tableRemoteFile) {
+					apexLog.Debugf("%s downloaded before, skip", tableRemoteFile)
+					s.Release(1)
+					continue
+				}
 				g.Go(func() error {
 					apexLog.Debugf("START DOWNLOAD from %s", tableRemoteFile)
 					defer s.Release(1)
-					if err := b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir); err != nil {
+					getCtx, getSpan := tracing.Start(dataCtx, "get_decompress", tracing.Table(table.Database, table.Table), attribute.String("remote_path", tableRemoteFile))
+					err := b.dst.DownloadCompressedStream(getCtx, tableRemoteFile, tableLocalDir)
+					tracing.End(getSpan, err)
+					if err != nil {
 						apexLog.Errorf("error in DownloadCompressedStream during downloadTableData: %v", err)
 						return err
 					}
+					if err = state.CompleteFile(tableRemoteFile, 0); err != nil {
+						return err
+					}
 					apexLog.Debugf("finish download from %s", tableRemoteFile)
 					return nil
 				})
@@ -340,45 +446,67 @@ func (b *Backuper) downloadTableData(remoteBackup metadata.BackupMetadata, table
 		for disk := range table.Parts {
 			capacity += len(table.Parts[disk])
 		}
-		apexLog.Debugf("start downloadTableData %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.DownloadConcurrency, capacity)
+		apexLog.Debugf("start downloadTableData %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.GetDownloadConcurrency(), capacity)
+		// with partitions filter download only directories of selected parts
+		downloadByPart := len(filesystemhelper.GetPartitionsFilterForTable(partitionsFilter, table.Database, table.Table)) > 0
 		for disk := range table.Parts {
-			if err := s.Acquire(ctx, 1); err != nil {
-				apexLog.Errorf("can't acquire semaphore during downloadTableData: %v", err)
-				break
+			remotePaths := []string{path.Join(remoteBackup.BackupName, "shadow", dbAndTableDir, disk)}
+			localDirs := []string{path.Join(b.DiskToPathMap[disk], "backup", remoteBackup.BackupName, "shadow", dbAndTableDir, disk)}
+			if downloadByPart {
+				remotePaths, localDirs = remotePaths[:0], localDirs[:0]
+				for _, part := range table.Parts[disk] {
+					if !part.Required {
+						remotePaths = append(remotePaths, path.Join(remoteBackup.BackupName, "shadow", dbAndTableDir, disk, part.Name))
+						localDirs = append(localDirs, path.Join(b.DiskToPathMap[disk], "backup", remoteBackup.BackupName, "shadow", dbAndTableDir, disk, part.Name))
+					}
+				}
 			}
-			tableRemotePath := path.Join(remoteBackup.BackupName, "shadow", dbAndTableDir, disk)
-			diskPath := b.DiskToPathMap[disk]
-			tableLocalDir := path.Join(diskPath, "backup", remoteBackup.BackupName, "shadow", dbAndTableDir, disk)
-			g.Go(func() error {
-				apexLog.Debugf("START DOWNLOAD from %s to %s", tableLocalDir, tableRemotePath)
-				defer s.Release(1)
-				if err := b.dst.DownloadPath(0, tableRemotePath, tableLocalDir); err != nil {
-					return err
+			for i := range remotePaths {
+				if err := s.Acquire(dataCtx, 1); err != nil {
+					apexLog.Errorf("can't acquire semaphore during downloadTableData: %v", err)
+					break
 				}
-				apexLog.Debugf("finish download from %s to %s", tableLocalDir, tableRemotePath)
-				return nil
-			})
+				tableRemotePath := remotePaths[i]
+				tableLocalDir := localDirs[i]
+				g.Go(func() error {
+					apexLog.Debugf("START DOWNLOAD from %s to %s", tableLocalDir, tableRemotePath)
+					defer s.Release(1)
+					_, getSpan := tracing.Start(dataCtx, "get", tracing.Table(table.Database, table.Table), attribute.String("remote_path", tableRemotePath))
+					err := b.dst.DownloadPath(ctx, 0, tableRemotePath, tableLocalDir, state)
+					tracing.End(getSpan, err)
+					if err != nil {
+						return err
+					}
+					apexLog.Debugf("finish download from %s to %s", tableLocalDir, tableRemotePath)
+					return nil
+				})
+			}
 		}
 	}
 	if err := g.Wait(); err != nil {
 		return fmt.Errorf("one of downloadTableData go-routine return error: %v", err)
 	}
 
-	err := b.downloadDiffParts(remoteBackup, table, dbAndTableDir)
+	err := b.downloadDiffParts(ctx, remoteBackup, table, dbAndTableDir)
 	if err != nil {
 		return err
 	}
+	if b.cfg.General.FileChecksums {
+		if err := verifyTableChecksums(b.DiskToPathMap, remoteBackup.BackupName, table, false); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (b *Backuper) downloadDiffParts(remoteBackup metadata.BackupMetadata, table metadata.TableMetadata, dbAndTableDir string) error {
+func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.BackupMetadata, table metadata.TableMetadata, dbAndTableDir string) error {
 	log := apexLog.WithField("operation", "downloadDiffParts")
 	log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table)).Debug("start")
 	start := time.Now()
 	downloadedDiffParts := uint32(0)
-	s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
-	g, ctx := errgroup.WithContext(context.Background())
+	s := b.downloadSemaphore()
+	g, ctx := errgroup.WithContext(ctx)
 
 	diffRemoteFilesCache := map[string]*sync.Mutex{}
 	diffRemoteFilesLock := &sync.Mutex{}
@@ -408,7 +536,7 @@ func (b *Backuper) downloadDiffParts(remoteBackup metadata.BackupMetadata, table
 				diskForDownload := disk
 				g.Go(func() error {
 					defer s.Release(1)
-					tableRemoteFiles, err := b.findDiffBackupFilesRemote(remoteBackup, table, diskForDownload, partForDownload, log)
+					tableRemoteFiles, err := b.findDiffBackupFilesRemote(ctx, remoteBackup, table, diskForDownload, partForDownload, log)
 					if err != nil {
 						return err
 					}
@@ -425,7 +553,7 @@ func (b *Backuper) downloadDiffParts(remoteBackup metadata.BackupMetadata, table
 								if !info.IsDir() {
 									return fmt.Errorf("after downloadDiffRemoteFile %s exists but is not directory", downloadedPartPath)
 								}
-								if err = makePartHardlinks(downloadedPartPath, existsPath); err != nil {
+								if err = makePartHardlinks(downloadedPartPath, existsPath, b.cfg.General.LinkMode); err != nil {
 									return fmt.Errorf("can't to add link to exists part %s -> %s error: %v", newPath, existsPath, err)
 								}
 							}
@@ -435,13 +563,13 @@ func (b *Backuper) downloadDiffParts(remoteBackup metadata.BackupMetadata, table
 						}
 						atomic.AddUint32(&downloadedDiffParts, 1)
 					}
-					if err = makePartHardlinks(existsPath, newPath); err != nil {
+					if err = makePartHardlinks(existsPath, newPath, b.cfg.General.LinkMode); err != nil {
 						return fmt.Errorf("can't to add link to exists part %s -> %s error: %v", newPath, existsPath, err)
 					}
 					return nil
 				})
 			} else {
-				if err = makePartHardlinks(existsPath, newPath); err != nil {
+				if err = makePartHardlinks(existsPath, newPath, b.cfg.General.LinkMode); err != nil {
 					return fmt.Errorf("can't to add exists part: %v", err)
 				}
 			}
@@ -453,7 +581,7 @@ func (b *Backuper) downloadDiffParts(remoteBackup metadata.BackupMetadata, table
 	if err := g.Wait(); err != nil {
 		return fmt.Errorf("one of downloadDiffParts go-routine return error: %v", err)
 	}
-	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).WithField("diff_parts", strconv.Itoa(int(downloadedDiffParts))).Info("done")
+	log.WithField("duration", utils.LogDuration(time.Since(start))).WithField("diff_parts", strconv.Itoa(int(downloadedDiffParts))).Info("done")
 	return nil
 }
 
@@ -474,13 +602,13 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 		namedLock.Lock()
 		diffRemoteFilesLock.Unlock()
 		if path.Ext(tableRemoteFile) != "" {
-			if err := b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir); err != nil {
+			if err := b.downloadDiffArchive(ctx, tableRemoteFile, tableLocalDir); err != nil {
 				log.Warnf("DownloadCompressedStream %s -> %s return error: %v", tableRemoteFile, tableLocalDir, err)
 				return err
 			}
 		} else {
 			// remoteFile could be a directory
-			if err := b.dst.DownloadPath(0, tableRemoteFile, tableLocalDir); err != nil {
+			if err := b.dst.DownloadPath(ctx, 0, tableRemoteFile, tableLocalDir, nil); err != nil {
 				log.Warnf("DownloadPath %s -> %s return error: %v", tableRemoteFile, tableLocalDir, err)
 				return err
 			}
@@ -491,6 +619,30 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 	return nil
 }
 
+// downloadDiffArchive - extract archive of required backup, when `base_cache_path` is set archive is downloaded into cache once and extracted from it
+func (b *Backuper) downloadDiffArchive(ctx context.Context, remoteFile, localDir string) error {
+	cache := newBaseCache(b.cfg)
+	if cache == nil {
+		return b.dst.DownloadCompressedStream(ctx, remoteFile, localDir)
+	}
+	archivePath, cached := cache.get(remoteFile)
+	if cached {
+		apexLog.Debugf("%s found in base_cache_path", remoteFile)
+	} else {
+		var err error
+		if archivePath, err = cache.put(remoteFile, func(localFile string) error {
+			return b.dst.DownloadFile(ctx, remoteFile, localFile)
+		}); err != nil {
+			return err
+		}
+	}
+	if err := b.dst.ExtractArchiveFile(ctx, archivePath, remoteFile, localDir); err != nil {
+		apexLog.Warnf("can't extract cached %s: %v, will download from remote storage", archivePath, err)
+		return b.dst.DownloadCompressedStream(ctx, remoteFile, localDir)
+	}
+	return nil
+}
+
 func (b *Backuper) checkNewPath(newPath string, part metadata.Part) error {
 	info, err := os.Stat(newPath)
 	if err != nil && !os.IsNotExist(err) {
@@ -505,28 +657,28 @@ func (b *Backuper) checkNewPath(newPath string, part metadata.Part) error {
 	return nil
 }
 
-func (b *Backuper) findDiffBackupFilesRemote(backup metadata.BackupMetadata, table metadata.TableMetadata, disk string, part metadata.Part, log *apexLog.Entry) (map[string]string, error) {
+func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadata.BackupMetadata, table metadata.TableMetadata, disk string, part metadata.Part, log *apexLog.Entry) (map[string]string, error) {
 	var requiredTable *metadata.TableMetadata
 	log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name}).Debugf("findDiffBackupFilesRemote")
-	requiredBackup, err := b.ReadBackupMetadataRemote(backup.RequiredBackup)
+	requiredBackup, err := b.ReadBackupMetadataRemote(ctx, backup.RequiredBackup)
 	if err != nil {
 		return nil, err
 	}
-	requiredTable, err = b.downloadTableMetadataIfNotExists(requiredBackup.BackupName, log, metadata.TableTitle{Database: table.Database, Table: table.Table})
+	requiredTable, err = b.downloadTableMetadataIfNotExists(ctx, requiredBackup.BackupName, log, metadata.TableTitle{Database: table.Database, Table: table.Table})
 	if err != nil {
 		log.Warnf("downloadTableMetadataIfNotExists %s / %s.%s return error", requiredBackup.BackupName, table.Database, table.Table)
 		return nil, err
 	}
 
 	// recursive find if part in RequiredBackup also Required
-	tableRemoteFiles, found, err := b.findDiffRecursive(requiredBackup, log, table, requiredTable, part, disk)
+	tableRemoteFiles, found, err := b.findDiffRecursive(ctx, requiredBackup, log, table, requiredTable, part, disk)
 	if found {
 		return tableRemoteFiles, nil
 	}
 
 	found = false
 	// try to find part on the same disk
-	tableRemoteFiles, err, found = b.findDiffOnePart(requiredBackup, table, disk, disk, part)
+	tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, disk, part)
 	if found {
 		return tableRemoteFiles, nil
 	}
@@ -534,7 +686,7 @@ func (b *Backuper) findDiffBackupFilesRemote(backup metadata.BackupMetadata, tab
 	// try to find part on other disks
 	for requiredDisk := range requiredBackup.Disks {
 		if requiredDisk != disk {
-			tableRemoteFiles, err, found = b.findDiffOnePart(requiredBackup, table, disk, requiredDisk, part)
+			tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, requiredDisk, part)
 			if found {
 				return tableRemoteFiles, nil
 			}
@@ -560,7 +712,7 @@ func (b *Backuper) findDiffBackupFilesRemote(backup metadata.BackupMetadata, tab
 	return nil, fmt.Errorf("%s.%s %s not found on %s and all required backups sequence", table.Database, table.Table, part.Name, requiredBackup.BackupName)
 }
 
-func (b *Backuper) findDiffRecursive(requiredBackup *metadata.BackupMetadata, log *apexLog.Entry, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, part metadata.Part, disk string) (map[string]string, bool, error) {
+func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metadata.BackupMetadata, log *apexLog.Entry, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, part metadata.Part, disk string) (map[string]string, bool, error) {
 	log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name}).Debugf("findDiffRecursive")
 	found := false
 	for _, requiredParts := range requiredTable.Parts {
@@ -568,7 +720,7 @@ func (b *Backuper) findDiffRecursive(requiredBackup *metadata.BackupMetadata, lo
 			if requiredPart.Name == part.Name {
 				found = true
 				if requiredPart.Required {
-					tableRemoteFiles, err := b.findDiffBackupFilesRemote(*requiredBackup, table, disk, part, log)
+					tableRemoteFiles, err := b.findDiffBackupFilesRemote(ctx, *requiredBackup, table, disk, part, log)
 					if err != nil {
 						found = false
 						log.Warnf("try find %s.%s %s recursive return err: %v", table.Database, table.Table, part.Name, err)
@@ -585,18 +737,18 @@ func (b *Backuper) findDiffRecursive(requiredBackup *metadata.BackupMetadata, lo
 	return nil, false, nil
 }
 
-func (b *Backuper) findDiffOnePart(requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]string, error, bool) {
+func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]string, error, bool) {
 	apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name}).Debugf("findDiffOnePart")
 	tableRemoteFiles := make(map[string]string)
 	// find same disk and part name archive
 	if requiredBackup.DataFormat != "directory" {
-		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartArchive(requiredBackup, table, localDisk, remoteDisk, part); err == nil {
+		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartArchive(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
 			tableRemoteFiles[tableRemoteFile] = tableLocalDir
 			return tableRemoteFiles, nil, true
 		}
 	} else {
 		// find same disk and part name directory
-		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartDirectory(requiredBackup, table, localDisk, remoteDisk, part); err == nil {
+		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartDirectory(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
 			tableRemoteFiles[tableRemoteFile] = tableLocalDir
 			return tableRemoteFiles, nil, true
 		}
@@ -604,26 +756,26 @@ func (b *Backuper) findDiffOnePart(requiredBackup *metadata.BackupMetadata, tabl
 	return nil, nil, false
 }
 
-func (b *Backuper) findDiffOnePartDirectory(requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
+func (b *Backuper) findDiffOnePartDirectory(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
 	apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name}).Debugf("findDiffOnePartDirectory")
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	tableRemotePath := path.Join(requiredBackup.BackupName, "shadow", dbAndTableDir, remoteDisk, part.Name)
 	tableRemoteFile := path.Join(tableRemotePath, "checksums.txt")
-	return b.findDiffFileExist(requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
+	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
 }
 
-func (b *Backuper) findDiffOnePartArchive(requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
+func (b *Backuper) findDiffOnePartArchive(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
 	apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name}).Debugf("findDiffOnePartArchive")
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	remoteExt := config.ArchiveExtensions[requiredBackup.DataFormat]
 	tableRemotePath := path.Join(requiredBackup.BackupName, "shadow", dbAndTableDir, fmt.Sprintf("%s_%s.%s", remoteDisk, part.Name, remoteExt))
 	tableRemoteFile := tableRemotePath
-	return b.findDiffFileExist(requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
+	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
 }
 
-func (b *Backuper) findDiffFileExist(requiredBackup *metadata.BackupMetadata, tableRemoteFile string, tableRemotePath string, localDisk string, dbAndTableDir string, part metadata.Part) (string, string, error) {
+func (b *Backuper) findDiffFileExist(ctx context.Context, requiredBackup *metadata.BackupMetadata, tableRemoteFile string, tableRemotePath string, localDisk string, dbAndTableDir string, part metadata.Part) (string, string, error) {
 	//apexLog.WithFields(apexLog.Fields{"tableRemoteFile": tableRemoteFile, "tableRemotePath": tableRemotePath, "part": part.Name}).Debugf("findDiffFileExist start")
-	_, err := b.dst.StatFile(tableRemoteFile)
+	_, err := b.dst.StatFile(ctx, tableRemoteFile)
 	if err != nil {
 		apexLog.WithFields(apexLog.Fields{"tableRemoteFile": tableRemoteFile, "tableRemotePath": tableRemotePath, "part": part.Name}).Debugf("findDiffFileExist not found")
 		return "", "", err
@@ -641,8 +793,8 @@ func (b *Backuper) findDiffFileExist(requiredBackup *metadata.BackupMetadata, ta
 	}
 }
 
-func (b *Backuper) ReadBackupMetadataRemote(backupName string) (*metadata.BackupMetadata, error) {
-	backupList, err := b.dst.BackupList(true, backupName)
+func (b *Backuper) ReadBackupMetadataRemote(ctx context.Context, backupName string) (*metadata.BackupMetadata, error) {
+	backupList, err := b.dst.BackupList(ctx, true, backupName)
 	if err != nil {
 		return nil, err
 	}
@@ -654,7 +806,7 @@ func (b *Backuper) ReadBackupMetadataRemote(backupName string) (*metadata.Backup
 	return nil, fmt.Errorf("%s not found on remote storage", backupName)
 }
 
-func makePartHardlinks(exists, new string) error {
+func makePartHardlinks(exists, new, linkMode string) error {
 	ex, err := os.Open(exists)
 	if err != nil {
 		return err
@@ -675,7 +827,7 @@ func makePartHardlinks(exists, new string) error {
 	for _, f := range files {
 		existsF := path.Join(exists, f)
 		newF := path.Join(new, f)
-		if err := os.Link(existsF, newF); err != nil {
+		if err := filesystemhelper.LinkFile(existsF, newF, linkMode); err != nil {
 			apexLog.Warnf("makePartHardlinks::Link %s -> %s: %v", newF, existsF, err)
 			return err
 		}
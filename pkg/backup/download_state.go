@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+)
+
+const downloadStateFile = "download.state"
+
+// downloadStateEntry - completed file or downloaded chunk of file with remote size
+type downloadStateEntry struct {
+	Key        string                     `json:"key"`
+	Completed  bool                       `json:"completed,omitempty"`
+	Size       int64                      `json:"size,omitempty"`
+	RemoteSize int64                      `json:"remote_size,omitempty"`
+	Chunk      *new_storage.DownloadChunk `json:"chunk,omitempty"`
+}
+
+// downloadState - `download --resume` skip completed files and archives, partially downloaded files continue after the last verified chunk
+type downloadState struct {
+	*stateJournal
+	mu        sync.Mutex
+	completed map[string]int64
+	// chunks - downloaded chunks of incomplete files, chunks of other remote size are ignored, remote file was uploaded again
+	chunks      map[string][]new_storage.DownloadChunk
+	remoteSizes map[string]int64
+}
+
+// openDownloadState - read state written by previous download when resume, otherwise start new state
+func openDownloadState(filePath string, resume bool) (*downloadState, error) {
+	state := &downloadState{
+		completed:   map[string]int64{},
+		chunks:      map[string][]new_storage.DownloadChunk{},
+		remoteSizes: map[string]int64{},
+	}
+	journal, err := openStateJournal(filePath, resume, func(line []byte) error {
+		entry := downloadStateEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		state.apply(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.stateJournal = journal
+	return state, nil
+}
+
+func (s *downloadState) apply(entry downloadStateEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.Completed {
+		s.completed[entry.Key] = entry.Size
+		delete(s.chunks, entry.Key)
+		delete(s.remoteSizes, entry.Key)
+		return
+	}
+	if entry.Chunk == nil {
+		return
+	}
+	if s.remoteSizes[entry.Key] != entry.RemoteSize {
+		s.remoteSizes[entry.Key] = entry.RemoteSize
+		s.chunks[entry.Key] = nil
+	}
+	s.chunks[entry.Key] = append(s.chunks[entry.Key], *entry.Chunk)
+}
+
+func (s *downloadState) save(entry downloadStateEntry) error {
+	s.apply(entry)
+	return s.append(entry)
+}
+
+// IsCompleted - nil state means download without resume
+func (s *downloadState) IsCompleted(key string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, completed := s.completed[key]
+	return completed
+}
+
+func (s *downloadState) CompleteFile(key string, size int64) error {
+	return s.save(downloadStateEntry{Key: key, Completed: true, Size: size})
+}
+
+func (s *downloadState) GetDownloadedChunks(key string, remoteSize int64) []new_storage.DownloadChunk {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remoteSizes[key] != remoteSize {
+		return nil
+	}
+	return append([]new_storage.DownloadChunk{}, s.chunks[key]...)
+}
+
+func (s *downloadState) SaveDownloadedChunk(key string, remoteSize int64, chunk new_storage.DownloadChunk) error {
+	return s.save(downloadStateEntry{Key: key, RemoteSize: remoteSize, Chunk: &chunk})
+}
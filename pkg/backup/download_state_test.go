@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadStateResume(t *testing.T) {
+	statePath := path.Join(t.TempDir(), downloadStateFile)
+	state, err := openDownloadState(statePath, false)
+	require.NoError(t, err)
+	require.NoError(t, state.SaveDownloadedChunk("b/shadow/db/t/default/all_1_1_0/data.bin", 200, new_storage.DownloadChunk{Offset: 0, Size: 100, CRC32: 1}))
+	require.NoError(t, state.SaveDownloadedChunk("b/shadow/db/t/default/all_1_1_0/data.bin", 200, new_storage.DownloadChunk{Offset: 100, Size: 100, CRC32: 2}))
+	require.NoError(t, state.SaveDownloadedChunk("b/shadow/db/t/default/all_1_1_0/data.mrk2", 50, new_storage.DownloadChunk{Offset: 0, Size: 10, CRC32: 3}))
+	require.NoError(t, state.CompleteFile("b/shadow/db/t/default_all_1_1_0.tar", 0))
+	require.NoError(t, state.Close())
+
+	state, err = openDownloadState(statePath, true)
+	require.NoError(t, err)
+	defer state.Close()
+	assert.True(t, state.IsCompleted("b/shadow/db/t/default_all_1_1_0.tar"))
+	assert.False(t, state.IsCompleted("b/shadow/db/t/default/all_1_1_0/data.bin"))
+	assert.Len(t, state.GetDownloadedChunks("b/shadow/db/t/default/all_1_1_0/data.bin", 200), 2)
+	assert.Empty(t, state.GetDownloadedChunks("b/shadow/db/t/default/all_1_1_0/data.mrk2", 60), "remote file changed")
+	require.NoError(t, state.SaveDownloadedChunk("b/shadow/db/t/default/all_1_1_0/data.mrk2", 60, new_storage.DownloadChunk{Offset: 0, Size: 60, CRC32: 4}))
+	assert.Equal(t, []new_storage.DownloadChunk{{Offset: 0, Size: 60, CRC32: 4}}, state.GetDownloadedChunks("b/shadow/db/t/default/all_1_1_0/data.mrk2", 60))
+	require.NoError(t, state.CompleteFile("b/shadow/db/t/default/all_1_1_0/data.bin", 200))
+	assert.Empty(t, state.GetDownloadedChunks("b/shadow/db/t/default/all_1_1_0/data.bin", 200))
+	assert.False(t, (*downloadState)(nil).IsCompleted("b/shadow/db/t/default_all_1_1_0.tar"))
+}
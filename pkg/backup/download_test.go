@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterArchivesByParts(t *testing.T) {
+	tm := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t",
+		Parts: map[string][]metadata.Part{
+			"default": {{Name: "202301_1_1_0"}, {Name: "202302_2_2_0"}, {Name: "202302_3_3_0"}, {Name: "202303_4_4_0"}},
+			"hdd":     {{Name: "202301_5_5_0"}, {Name: "202302_6_6_0"}},
+		},
+		Files: map[string][]string{
+			"default": {"default_202301_1_1_0.tar.lz4", "default_202302_2_2_0.tar.lz4", "default_202302_3_3_0.tar.lz4", "default_202303_4_4_0.tar.lz4"},
+			"hdd":     {"hdd_1.tar.lz4"},
+		},
+	}
+	partsBeforeFilter := map[string][]metadata.Part{}
+	for disk, parts := range tm.Parts {
+		partsBeforeFilter[disk] = parts
+	}
+	filterPartsByPartitionsFilter(tm, filesystemhelper.CreatePartitionsToBackupMap([]string{"db.t:202302"}))
+	assert.Equal(t, []metadata.Part{{Name: "202302_2_2_0"}, {Name: "202302_3_3_0"}}, tm.Parts["default"])
+	assert.Equal(t, []metadata.Part{{Name: "202302_6_6_0"}}, tm.Parts["hdd"])
+
+	filterArchivesByParts(&tm, partsBeforeFilter)
+	assert.Equal(t, []string{"default_202302_2_2_0.tar.lz4", "default_202302_3_3_0.tar.lz4"}, tm.Files["default"])
+	assert.Equal(t, []string{"hdd_1.tar.lz4"}, tm.Files["hdd"])
+}
+
+func TestFilterDownloadByPartitions(t *testing.T) {
+	newTable := func(table string) metadata.TableMetadata {
+		return metadata.TableMetadata{
+			Database: "db",
+			Table:    table,
+			Parts: map[string][]metadata.Part{
+				"default": {{Name: "202301_1_1_0"}, {Name: "202302_2_2_0"}, {Name: "202303_3_3_0"}},
+			},
+			Files: map[string][]string{
+				"default": {"default_202301_1_1_0.tar.lz4", "default_202302_2_2_0.tar.lz4", "default_202303_3_3_0.tar.lz4"},
+			},
+		}
+	}
+	// `--partitions` repeated for several tables
+	partitionsFilter := filesystemhelper.CreatePartitionsToBackupMap([]string{"db.t1:202301,202303", "db.t2:202302"})
+
+	remote := newTable("t1")
+	t1 := remote
+	filterDownloadByPartitions(&t1, partitionsFilter)
+	assert.Equal(t, []metadata.Part{{Name: "202301_1_1_0"}, {Name: "202303_3_3_0"}}, t1.Parts["default"])
+	assert.Equal(t, []string{"default_202301_1_1_0.tar.lz4", "default_202303_3_3_0.tar.lz4"}, t1.Files["default"])
+	assert.Equal(t, newTable("t1"), remote, "metadata read from remote storage is not changed")
+
+	t2 := newTable("t2")
+	filterDownloadByPartitions(&t2, partitionsFilter)
+	assert.Equal(t, []metadata.Part{{Name: "202302_2_2_0"}}, t2.Parts["default"])
+	assert.Equal(t, []string{"default_202302_2_2_0.tar.lz4"}, t2.Files["default"])
+
+	t3 := newTable("t3")
+	filterDownloadByPartitions(&t3, partitionsFilter)
+	assert.Equal(t, newTable("t3"), t3, "table without filter is downloaded whole")
+
+	t4 := newTable("t4")
+	filterDownloadByPartitions(&t4, filesystemhelper.CreatePartitionsToBackupMap(nil))
+	assert.Equal(t, newTable("t4"), t4)
+}
@@ -0,0 +1,30 @@
+package backup
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// backupEnvironment - snapshot of hosts and settings for metadata.json, unavailable properties are logged and left empty
+func backupEnvironment(ch *clickhouse.ClickHouse, log *apexLog.Entry) *metadata.BackupEnvironment {
+	env := &metadata.BackupEnvironment{OS: runtime.GOOS + "/" + runtime.GOARCH}
+	var err error
+	if env.Hostname, err = os.Hostname(); err != nil {
+		log.Warnf("can't get hostname: %v", err)
+	}
+	if env.ServerHostname, env.ServerTimezone, err = ch.GetServerInfo(); err != nil {
+		log.Warnf("can't get hostName() and timezone() of clickhouse-server: %v", err)
+	}
+	if env.ServerVersion, err = ch.GetVersion(); err != nil {
+		log.Warnf("can't get clickhouse-server version: %v", err)
+	}
+	if env.Settings, env.MergeTreeSettings, err = ch.GetChangedSettings(); err != nil {
+		log.Warnf("can't get changed settings: %v", err)
+	}
+	return env
+}
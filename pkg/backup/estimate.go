@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+)
+
+// estimateHistoryDepth - how many latest remote backups used to calculate throughput and compression ratio
+const estimateHistoryDepth = 10
+
+type partitionSize struct {
+	Database              string `db:"database"`
+	Table                 string `db:"table"`
+	Disk                  string `db:"disk_name"`
+	PartitionID           string `db:"partition_id"`
+	Parts                 uint64 `db:"parts"`
+	BytesOnDisk           uint64 `db:"bytes_on_disk"`
+	DataUncompressedBytes uint64 `db:"data_uncompressed_bytes"`
+}
+
+type diskFreeSpace struct {
+	Name      string `db:"name"`
+	FreeSpace uint64 `db:"free_space"`
+}
+
+// tableEstimate - expected backup size of one table on one disk
+type tableEstimate struct {
+	Table        string
+	Disk         string
+	Parts        uint64
+	Size         uint64
+	Uncompressed uint64
+	Archives     int
+}
+
+// Estimate - print expected backup size per table, archives count and duration based on remote backups history
+func Estimate(ctx context.Context, cfg *config.Config, tablePattern string, partitions []string) error {
+	ch := &clickhouse.ClickHouse{
+		Config: &cfg.ClickHouse,
+	}
+	if err := ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+
+	allTables, err := ch.GetTables(tablePattern)
+	if err != nil {
+		return fmt.Errorf("can't get tables from clickhouse: %v", err)
+	}
+	tables := map[string]bool{}
+	for _, table := range filterTablesByPattern(allTables, tablePattern) {
+		if !table.Skip {
+			tables[fmt.Sprintf("%s.%s", table.Database, table.Name)] = true
+		}
+	}
+	if len(tables) == 0 {
+		return fmt.Errorf("no tables for backup")
+	}
+	var sizes []partitionSize
+	query := "SELECT database, table, disk_name, partition_id, count() AS parts, sum(bytes_on_disk) AS bytes_on_disk, sum(data_uncompressed_bytes) AS data_uncompressed_bytes " +
+		"FROM system.parts WHERE active GROUP BY database, table, disk_name, partition_id"
+	if err := ch.Select(&sizes, query); err != nil {
+		return fmt.Errorf("can't get parts size from clickhouse: %v", err)
+	}
+	estimates := groupTableEstimates(sizes, tables, filesystemhelper.CreatePartitionsToBackupMap(partitions))
+
+	maxFileSize := cfg.General.MaxFileSize
+	if cfg.GetCompressionFormat() != "none" && !cfg.General.UploadByPart {
+		if calculated, err := clickhouse.CalculateMaxFileSize(cfg); err != nil {
+			apexLog.Warnf("can't calculate max_file_size: %v", err)
+		} else if maxFileSize <= 0 || maxFileSize < calculated {
+			maxFileSize = calculated
+		}
+	}
+	for i := range estimates {
+		estimates[i].Archives = estimateArchives(cfg, estimates[i], maxFileSize)
+	}
+
+	var remoteBackups []new_storage.Backup
+	if cfg.General.RemoteStorage != "none" {
+		if remoteBackups, err = GetRemoteBackups(ctx, cfg, true); err != nil {
+			apexLog.Warnf("can't get remote backups, duration will not estimate: %v", err)
+		}
+	}
+	ratio := estimateCompressionRatio(remoteBackups, cfg.GetCompressionFormat())
+	var freeSpace []diskFreeSpace
+	if supported, _ := ch.SupportsFeature(clickhouse.FeatureSystemDisks); supported {
+		if err := ch.Select(&freeSpace, "SELECT name, free_space FROM system.disks"); err != nil {
+			apexLog.Warnf("can't get disks free space: %v", err)
+		}
+	}
+	return printEstimate(os.Stdout, estimates, ratio, freeSpace, remoteBackups)
+}
+
+// groupTableEstimates - sum partitions size for each table and disk, only tables from tables map and partitions from partitionsFilter counted
+func groupTableEstimates(sizes []partitionSize, tables map[string]bool, partitionsFilter common.EmptyMap) []tableEstimate {
+	estimateMap := map[string]*tableEstimate{}
+	for _, s := range sizes {
+		tableName := fmt.Sprintf("%s.%s", s.Database, s.Table)
+		if !tables[tableName] {
+			continue
+		}
+		if tablePartitionsFilter := filesystemhelper.GetPartitionsFilterForTable(partitionsFilter, s.Database, s.Table); len(tablePartitionsFilter) > 0 && !filesystemhelper.IsPartInPartition(s.PartitionID, tablePartitionsFilter) {
+			continue
+		}
+		key := tableName + "\t" + s.Disk
+		e, exists := estimateMap[key]
+		if !exists {
+			e = &tableEstimate{Table: tableName, Disk: s.Disk}
+			estimateMap[key] = e
+		}
+		e.Parts += s.Parts
+		e.Size += s.BytesOnDisk
+		e.Uncompressed += s.DataUncompressedBytes
+	}
+	estimates := make([]tableEstimate, 0, len(estimateMap))
+	for _, e := range estimateMap {
+		estimates = append(estimates, *e)
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		if estimates[i].Table != estimates[j].Table {
+			return estimates[i].Table < estimates[j].Table
+		}
+		return estimates[i].Disk < estimates[j].Disk
+	})
+	return estimates
+}
+
+// estimateArchives - archives count which upload will create, the same split logic as splitPartFiles, -1 when each file uploaded as separate object
+func estimateArchives(cfg *config.Config, e tableEstimate, maxFileSize int64) int {
+	if cfg.GetCompressionFormat() == "none" {
+		return -1
+	}
+	if cfg.General.UploadByPart || maxFileSize <= 0 {
+		return int(e.Parts)
+	}
+	archives := int(math.Ceil(float64(e.Size) / float64(maxFileSize)))
+	if archives == 0 && e.Parts > 0 {
+		archives = 1
+	}
+	return archives
+}
+
+// estimateCompressionRatio - compressed_size / data_size for latest remote backups with the same data format, 1 when history is empty
+func estimateCompressionRatio(remoteBackups []new_storage.Backup, compressionFormat string) float64 {
+	var dataSize, compressedSize uint64
+	for _, b := range latestBackupsHistory(remoteBackups) {
+		if b.CompressedSize > 0 && b.DataFormat == compressionFormat {
+			dataSize += b.DataSize
+			compressedSize += b.CompressedSize
+		}
+	}
+	if dataSize == 0 {
+		return 1
+	}
+	return float64(compressedSize) / float64(dataSize)
+}
+
+// estimateThroughput - median bytes per second between creation and upload of latest remote backups, 0 when history is empty
+func estimateThroughput(remoteBackups []new_storage.Backup) (float64, int) {
+	var throughputs []float64
+	for _, b := range latestBackupsHistory(remoteBackups) {
+		duration := b.UploadDate.Sub(b.CreationDate)
+		if b.DataSize == 0 || duration <= 0 {
+			continue
+		}
+		throughputs = append(throughputs, float64(b.DataSize)/duration.Seconds())
+	}
+	if len(throughputs) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(throughputs)
+	middle := len(throughputs) / 2
+	if len(throughputs)%2 == 0 {
+		return (throughputs[middle-1] + throughputs[middle]) / 2, len(throughputs)
+	}
+	return throughputs[middle], len(throughputs)
+}
+
+// latestBackupsHistory - up to estimateHistoryDepth latest full remote backups, incremental backups don't upload required parts and skew throughput
+func latestBackupsHistory(remoteBackups []new_storage.Backup) []new_storage.Backup {
+	history := make([]new_storage.Backup, 0, estimateHistoryDepth)
+	for i := len(remoteBackups) - 1; i >= 0 && len(history) < estimateHistoryDepth; i-- {
+		b := remoteBackups[i]
+		if b.Legacy || b.Broken != "" || b.RequiredBackup != "" {
+			continue
+		}
+		history = append(history, b)
+	}
+	return history
+}
+
+func printEstimate(out io.Writer, estimates []tableEstimate, ratio float64, freeSpace []diskFreeSpace, remoteBackups []new_storage.Backup) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "table\tdisk\tparts\tsize\tuncompressed\tcompressed\tarchives")
+	total := tableEstimate{Archives: -1}
+	diskSize := map[string]uint64{}
+	for _, e := range estimates {
+		archives := "-"
+		if e.Archives >= 0 {
+			archives = fmt.Sprint(e.Archives)
+			if total.Archives < 0 {
+				total.Archives = 0
+			}
+			total.Archives += e.Archives
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", e.Table, e.Disk, e.Parts, utils.FormatBytes(e.Size), utils.FormatBytes(e.Uncompressed), utils.FormatBytes(uint64(float64(e.Size)*ratio)), archives)
+		total.Parts += e.Parts
+		total.Size += e.Size
+		total.Uncompressed += e.Uncompressed
+		diskSize[e.Disk] += e.Size
+	}
+	totalArchives := "-"
+	if total.Archives >= 0 {
+		totalArchives = fmt.Sprint(total.Archives)
+	}
+	fmt.Fprintf(w, "total\t\t%d\t%s\t%s\t%s\t%s\n", total.Parts, utils.FormatBytes(total.Size), utils.FormatBytes(total.Uncompressed), utils.FormatBytes(uint64(float64(total.Size)*ratio)), totalArchives)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// create use hard links, but frozen parts will keep disk space after merges until backup deleted, download require full size
+	for _, disk := range freeSpace {
+		if size, exists := diskSize[disk.Name]; exists {
+			fmt.Fprintf(out, "disk %s: backup size %s, free space %s\n", disk.Name, utils.FormatBytes(size), utils.FormatBytes(disk.FreeSpace))
+			if size > disk.FreeSpace {
+				fmt.Fprintf(out, "WARNING: disk %s free space is not enough to download backup\n", disk.Name)
+			}
+		}
+	}
+	throughput, samples := estimateThroughput(remoteBackups)
+	if samples == 0 {
+		fmt.Fprintln(out, "estimated duration: unknown, no remote backups history")
+		return nil
+	}
+	duration := time.Duration(float64(total.Size) / throughput * float64(time.Second))
+	fmt.Fprintf(out, "estimated duration: %s, %s/s median throughput of %d latest remote backups\n", utils.HumanizeDuration(duration), utils.FormatBytes(uint64(throughput)), samples)
+	return nil
+}
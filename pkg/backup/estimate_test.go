@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupTableEstimates(t *testing.T) {
+	sizes := []partitionSize{
+		{Database: "db", Table: "t1", Disk: "default", PartitionID: "202201", Parts: 2, BytesOnDisk: 100, DataUncompressedBytes: 300},
+		{Database: "db", Table: "t1", Disk: "default", PartitionID: "202202", Parts: 1, BytesOnDisk: 50, DataUncompressedBytes: 150},
+		{Database: "db", Table: "t1", Disk: "hdd", PartitionID: "202201", Parts: 3, BytesOnDisk: 10, DataUncompressedBytes: 30},
+		{Database: "db", Table: "skipped", Disk: "default", PartitionID: "all", Parts: 1, BytesOnDisk: 1000, DataUncompressedBytes: 1000},
+	}
+	tables := map[string]bool{"db.t1": true}
+	estimates := groupTableEstimates(sizes, tables, filesystemhelper.CreatePartitionsToBackupMap(nil))
+	assert.Equal(t, []tableEstimate{
+		{Table: "db.t1", Disk: "default", Parts: 3, Size: 150, Uncompressed: 450},
+		{Table: "db.t1", Disk: "hdd", Parts: 3, Size: 10, Uncompressed: 30},
+	}, estimates)
+
+	estimates = groupTableEstimates(sizes, tables, filesystemhelper.CreatePartitionsToBackupMap([]string{"202202"}))
+	assert.Equal(t, []tableEstimate{{Table: "db.t1", Disk: "default", Parts: 1, Size: 50, Uncompressed: 150}}, estimates)
+}
+
+func TestEstimateArchives(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	cfg.S3.CompressionFormat = "tar"
+	cfg.General.UploadByPart = false
+	e := tableEstimate{Parts: 5, Size: 250}
+	assert.Equal(t, 3, estimateArchives(cfg, e, 100))
+	assert.Equal(t, 1, estimateArchives(cfg, tableEstimate{Parts: 1}, 100))
+	cfg.General.UploadByPart = true
+	assert.Equal(t, 5, estimateArchives(cfg, e, 100))
+	cfg.S3.CompressionFormat = "none"
+	assert.Equal(t, -1, estimateArchives(cfg, e, 100))
+}
+
+func TestEstimateThroughput(t *testing.T) {
+	now := time.Now()
+	remoteBackup := func(dataSize uint64, duration time.Duration, requiredBackup string) new_storage.Backup {
+		return new_storage.Backup{
+			BackupMetadata: metadata.BackupMetadata{CreationDate: now.Add(-duration), DataSize: dataSize, RequiredBackup: requiredBackup, DataFormat: "tar", CompressedSize: dataSize / 2},
+			UploadDate:     now,
+		}
+	}
+	throughput, samples := estimateThroughput(nil)
+	assert.Equal(t, 0, samples)
+	assert.Equal(t, float64(0), throughput)
+
+	remoteBackups := []new_storage.Backup{
+		remoteBackup(100, 10*time.Second, ""),
+		remoteBackup(300, 10*time.Second, ""),
+		remoteBackup(1000, time.Second, "base"),
+		remoteBackup(200, 10*time.Second, ""),
+	}
+	throughput, samples = estimateThroughput(remoteBackups)
+	assert.Equal(t, 3, samples)
+	assert.InDelta(t, 20, throughput, 0.001)
+	assert.InDelta(t, 0.5, estimateCompressionRatio(remoteBackups, "tar"), 0.001)
+	assert.Equal(t, float64(1), estimateCompressionRatio(remoteBackups, "zstd"))
+}
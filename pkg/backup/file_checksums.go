@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+// hashPartFile - size and xxhash64 of local file
+func hashPartFile(filePath string) (metadata.FileChecksum, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return metadata.FileChecksum{}, err
+	}
+	defer f.Close()
+	h := xxhash.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return metadata.FileChecksum{}, err
+	}
+	return metadata.FileChecksum{Size: size, XXHash64: fmt.Sprintf("%016x", h.Sum64())}, nil
+}
+
+// calculatePartChecksums - checksums of all regular files inside part directory including projections
+func calculatePartChecksums(partPath string) (map[string]metadata.FileChecksum, error) {
+	result := map[string]metadata.FileChecksum{}
+	err := filepath.Walk(partPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		checksum, err := hashPartFile(filePath)
+		if err != nil {
+			return err
+		}
+		result[strings.Trim(strings.TrimPrefix(filePath, partPath), "/")] = checksum
+		return nil
+	})
+	return result, err
+}
+
+// verifyPartChecksums - each file from part metadata shall exist with the same size and hash, parts without checksums are not verified
+func verifyPartChecksums(partPath string, part metadata.Part) error {
+	for name, expected := range part.Checksums {
+		filePath := path.Join(partPath, name)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filePath, err)
+		}
+		if info.Size() != expected.Size {
+			return fmt.Errorf("%s: size mismatch, expected %d, actual %d", filePath, expected.Size, info.Size())
+		}
+		actual, err := hashPartFile(filePath)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filePath, err)
+		}
+		if actual.XXHash64 != expected.XXHash64 {
+			return fmt.Errorf("%s: xxhash64 mismatch, expected %s, actual %s", filePath, expected.XXHash64, actual.XXHash64)
+		}
+	}
+	return nil
+}
+
+// tableShadowPath - local folder of table parts on disk inside backup
+func tableShadowPath(diskPath, backupName, database, table, disk string) string {
+	return path.Join(diskPath, "backup", backupName, "shadow", common.TablePathEncode(database), common.TablePathEncode(table), disk)
+}
+
+// calculateTableChecksums - fill Checksums of each part of table, parts are placed in local backup folder of their disks
+func calculateTableChecksums(diskToPath map[string]string, backupName, database, table string, parts map[string][]metadata.Part) error {
+	for disk := range parts {
+		diskPath, exists := diskToPath[disk]
+		if !exists {
+			return fmt.Errorf("can't find path of disk '%s'", disk)
+		}
+		for i := range parts[disk] {
+			partPath := path.Join(tableShadowPath(diskPath, backupName, database, table, disk), parts[disk][i].Name)
+			checksums, err := calculatePartChecksums(partPath)
+			if err != nil {
+				return fmt.Errorf("can't calculate checksums of '%s.%s' part '%s': %v", database, table, parts[disk][i].Name, err)
+			}
+			parts[disk][i].Checksums = checksums
+		}
+	}
+	return nil
+}
+
+// verifyTableChecksums - compare local files of table parts with checksums from metadata, parts of required backup are skipped when skipRequired
+func verifyTableChecksums(diskToPath map[string]string, backupName string, table metadata.TableMetadata, skipRequired bool) error {
+	for disk := range table.Parts {
+		diskPath, exists := diskToPath[disk]
+		if !exists {
+			continue
+		}
+		for _, part := range table.Parts[disk] {
+			if skipRequired && part.Required {
+				continue
+			}
+			partPath := path.Join(tableShadowPath(diskPath, backupName, table.Database, table.Table, disk), part.Name)
+			if err := verifyPartChecksums(partPath, part); err != nil {
+				return fmt.Errorf("checksum verification of '%s.%s' part '%s' failed: %v", table.Database, table.Table, part.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableChecksums(t *testing.T) {
+	diskPath := t.TempDir()
+	partPath := path.Join(tableShadowPath(diskPath, "b1", "db", "t", "default"), "all_1_1_0")
+	require.NoError(t, os.MkdirAll(path.Join(partPath, "p.proj"), 0750))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "data.bin"), []byte("data"), 0640))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "p.proj", "data.bin"), []byte("projection"), 0640))
+	diskToPath := map[string]string{"default": diskPath}
+	parts := map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}
+	require.NoError(t, calculateTableChecksums(diskToPath, "b1", "db", "t", parts))
+	assert.Equal(t, map[string]metadata.FileChecksum{
+		"data.bin":        {Size: 4, XXHash64: "b7119b48552d1da3"},
+		"p.proj/data.bin": {Size: 10, XXHash64: "626250d2b991e651"},
+	}, parts["default"][0].Checksums)
+
+	table := metadata.TableMetadata{Database: "db", Table: "t", Parts: parts}
+	require.NoError(t, verifyTableChecksums(diskToPath, "b1", table, false))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "data.bin"), []byte("date"), 0640))
+	assert.EqualError(t, verifyTableChecksums(diskToPath, "b1", table, false), "checksum verification of 'db.t' part 'all_1_1_0' failed: "+path.Join(partPath, "data.bin")+": xxhash64 mismatch, expected b7119b48552d1da3, actual 7fb5099e2dfdf443")
+	require.NoError(t, os.Remove(path.Join(partPath, "p.proj", "data.bin")))
+	table.Parts["default"][0].Checksums = map[string]metadata.FileChecksum{"p.proj/data.bin": {Size: 10}}
+	assert.Error(t, verifyTableChecksums(diskToPath, "b1", table, false))
+
+	// parts of required backup are absent in local backup during upload, parts without checksums are not verified
+	table.Parts["default"][0].Required = true
+	require.NoError(t, verifyTableChecksums(diskToPath, "b1", table, true))
+	table.Parts["default"][0].Checksums = nil
+	require.NoError(t, verifyTableChecksums(diskToPath, "b1", table, false))
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+)
+
+// checkFreeSpace - fail before operation starts when required bytes exceed free space of disks from system.disks,
+// required receive free space of all known disks, check is skipped when free space is unknown
+func checkFreeSpace(ch *clickhouse.ClickHouse, operation string, required func(freeSpace map[string]uint64) map[string]uint64) error {
+	if supported, err := ch.SupportsFeature(clickhouse.FeatureSystemDisks); err != nil || !supported {
+		apexLog.Warnf("clickhouse-server doesn't have system.disks, free space check before %s skipped", operation)
+		return nil
+	}
+	var disks []diskFreeSpace
+	if err := ch.Select(&disks, "SELECT name, free_space FROM system.disks"); err != nil {
+		apexLog.Warnf("can't get disks free space, check before %s skipped: %v", operation, err)
+		return nil
+	}
+	freeSpace := map[string]uint64{}
+	for _, disk := range disks {
+		freeSpace[disk.Name] = disk.FreeSpace
+	}
+	if problems := freeSpaceProblems(required(freeSpace), freeSpace); len(problems) > 0 {
+		return fmt.Errorf("not enough free space for %s: %s", operation, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// freeSpaceProblems - disks where required size exceed free space, disks with unknown free space are ignored
+func freeSpaceProblems(required, freeSpace map[string]uint64) []string {
+	var problems []string
+	for disk, size := range required {
+		if free, exists := freeSpace[disk]; exists && size > free {
+			problems = append(problems, fmt.Sprintf("disk '%s' requires %s, free space %s", disk, utils.FormatBytes(size), utils.FormatBytes(free)))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkDisksFreeSpace - size of downloaded parts for each target disk, S3 `allow_multipart_download` keeps up to `download_concurrency` archives on disk before extract
+func checkDisksFreeSpace(cfg *config.Config, ch *clickhouse.ClickHouse, tables []metadata.TableMetadata) error {
+	return checkFreeSpace(ch, "download", func(freeSpace map[string]uint64) map[string]uint64 {
+		required := requiredSpaceByDisk(tables, cfg.General.RestoreDiskMapping, freeSpace)
+		if cfg.General.RemoteStorage == "s3" && cfg.S3.AllowMultipartDownload && cfg.GetCompressionFormat() != "none" {
+			for disk, size := range required {
+				archives := uint64(cfg.GetDownloadConcurrency()) * uint64(cfg.General.MaxFileSize)
+				if archives > size {
+					archives = size
+				}
+				required[disk] += archives
+			}
+		}
+		return required
+	})
+}
+
+// requiredSpaceForCreate - FREEZE creates hardlinks, only data exported through clickhouse-server and result of BACKUP statement are written to disk
+func requiredSpaceForCreate(cfg *config.Config, tables []clickhouse.Table, disks []clickhouse.Disk) map[string]uint64 {
+	required := map[string]uint64{}
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		if isNativeBackupTable(cfg, table.Engine) {
+			required[cfg.ClickHouse.NativeBackupDisk] += table.TotalBytes
+		} else if isLogicalBackupTable(cfg, table.Engine) || isDownloadedThroughServer(cfg, tableObjectDisks(table, disks)) {
+			required["default"] += table.TotalBytes
+		}
+	}
+	return required
+}
+
+// requiredSpaceForRestore - parts are hardlinked into `detached` and copied only when backup and table data are placed on different filesystems,
+// logical backup is copied into `user_files_path` and inserted, native backup is restored by RESTORE statement
+func requiredSpaceForRestore(tables []metadata.TableMetadata, destinations map[metadata.TableTitle]metadata.TableTitle, dstTables map[metadata.TableTitle]clickhouse.Table, disks []clickhouse.Disk) map[string]uint64 {
+	required := map[string]uint64{}
+	maxLogicalCopy := uint64(0)
+	for _, table := range tables {
+		if table.NativeBackup || table.LogicalBackup {
+			size := table.TotalBytes
+			if size == 0 {
+				for _, diskSize := range table.Size {
+					size += uint64(diskSize)
+				}
+			}
+			required["default"] += size
+			if table.LogicalBackup && size > maxLogicalCopy {
+				maxLogicalCopy = size
+			}
+			continue
+		}
+		dstDataPaths := clickhouse.GetDisksByPaths(disks, dstTables[destinations[metadata.TableTitle{Database: table.Database, Table: table.Table}]].DataPaths)
+		for _, backupDisk := range disks {
+			if len(table.Parts[backupDisk.Name]) == 0 {
+				continue
+			}
+			dstDataPath, dstDisk, exists := clickhouse.GetDataPathForDisk(dstDataPaths, backupDisk.Name)
+			if !exists || isSameFilesystem(backupDisk.Path, dstDataPath) {
+				continue
+			}
+			required[dstDisk] += partsSize(table, backupDisk.Name)
+		}
+	}
+	required["default"] += maxLogicalCopy
+	return required
+}
+
+// isSameFilesystem - hardlink is possible, unknown paths are treated as the same filesystem
+func isSameFilesystem(path1, path2 string) bool {
+	info1, err := os.Stat(path1)
+	if err != nil {
+		return true
+	}
+	info2, err := os.Stat(path2)
+	if err != nil {
+		return true
+	}
+	stat1, ok1 := info1.Sys().(*syscall.Stat_t)
+	stat2, ok2 := info2.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return true
+	}
+	return stat1.Dev == stat2.Dev
+}
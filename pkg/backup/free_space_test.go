@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeSpaceProblems(t *testing.T) {
+	problems := freeSpaceProblems(map[string]uint64{"default": 2048, "hdd": 10, "unknown": 100}, map[string]uint64{"default": 1024, "hdd": 10})
+	assert.Equal(t, []string{"disk 'default' requires 2.00KiB, free space 1.00KiB"}, problems)
+}
+
+func TestRequiredSpaceForCreate(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ClickHouse.LogicalBackupEngines = []string{"Memory"}
+	disks := []clickhouse.Disk{
+		{Name: "default", Path: "/var/lib/clickhouse/", Type: "local"},
+		{Name: "s3", Path: "/var/lib/clickhouse/disks/s3/", Type: "s3"},
+	}
+	tables := []clickhouse.Table{
+		{Database: "db", Name: "frozen", Engine: "MergeTree", DataPaths: []string{"/var/lib/clickhouse/store/abc/"}, TotalBytes: 1000},
+		{Database: "db", Name: "memory", Engine: "Memory", TotalBytes: 10},
+		{Database: "db", Name: "on_s3", Engine: "MergeTree", DataPaths: []string{"/var/lib/clickhouse/disks/s3/store/def/"}, TotalBytes: 100},
+		{Database: "db", Name: "skipped", Engine: "Memory", TotalBytes: 5, Skip: true},
+	}
+	cfg.ClickHouse.ObjectDiskBackupMode = "download"
+	assert.Equal(t, map[string]uint64{"default": 110}, requiredSpaceForCreate(cfg, tables, disks))
+	cfg.ClickHouse.ObjectDiskBackupMode = "zero-copy"
+	assert.Equal(t, map[string]uint64{"default": 10}, requiredSpaceForCreate(cfg, tables, disks))
+	cfg.ClickHouse.BackupEngine = "native"
+	assert.Equal(t, map[string]uint64{"backups": 1100, "default": 10}, requiredSpaceForCreate(cfg, tables, disks))
+}
+
+func TestRequiredSpaceForRestore(t *testing.T) {
+	diskPath := t.TempDir()
+	disks := []clickhouse.Disk{{Name: "default", Path: diskPath, Type: "local"}}
+	tables := []metadata.TableMetadata{
+		{Database: "db", Table: "t", Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Size: 100}}}},
+		{Database: "db", Table: "memory1", LogicalBackup: true, Size: map[string]int64{"default": 30}, Parts: map[string][]metadata.Part{"default": {{Name: "logical"}}}},
+		{Database: "db", Table: "memory2", LogicalBackup: true, Size: map[string]int64{"default": 20}, Parts: map[string][]metadata.Part{"default": {{Name: "logical"}}}},
+	}
+	destinations := map[metadata.TableTitle]metadata.TableTitle{{Database: "db", Table: "t"}: {Database: "db2", Table: "t"}}
+	dstTables := map[metadata.TableTitle]clickhouse.Table{{Database: "db2", Table: "t"}: {Database: "db2", Name: "t", DataPaths: []string{path.Join(diskPath, "store", "abc")}}}
+	// parts are hardlinked on the same filesystem, logical data inserted and the biggest one copied to user_files_path
+	assert.Equal(t, map[string]uint64{"default": 80}, requiredSpaceForRestore(tables, destinations, dstTables, disks))
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// restoreFunctions - create SQL user defined functions before tables, so DEFAULT expressions of tables could use them,
+// function the same as on server is kept, functions could use each other, so failed functions are created again
+func restoreFunctions(cfg *config.Config, ch *clickhouse.ClickHouse, functions []metadata.FunctionsMeta) error {
+	if len(functions) == 0 {
+		return nil
+	}
+	existingFunctions, err := ch.GetUserDefinedFunctions()
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]string, len(existingFunctions))
+	for _, f := range existingFunctions {
+		existing[f.Name] = f.CreateQuery
+	}
+	names, queries := functionsForRestore(functions, existing, cfg.General.RestoreSchemaOnCluster)
+	err = executeWithRetries(names, func(name string) error {
+		return ch.CreateUserDefinedFunction(name, queries[name], cfg.General.RestoreSchemaOnCluster)
+	})
+	if err != nil {
+		return fmt.Errorf("can't create functions: %v", err)
+	}
+	apexLog.WithFields(apexLog.Fields{"created": len(names), "kept": len(functions) - len(names)}).Info("done restore functions")
+	return nil
+}
+
+// functionsForRestore - names and create queries of functions which are absent or differ on server, with cluster all functions are created on cluster hosts
+func functionsForRestore(functions []metadata.FunctionsMeta, existing map[string]string, cluster string) ([]string, map[string]string) {
+	names := make([]string, 0, len(functions))
+	queries := make(map[string]string, len(functions))
+	for _, f := range functions {
+		if query, exists := existing[f.Name]; exists && query == f.CreateQuery && cluster == "" {
+			continue
+		}
+		names = append(names, f.Name)
+		queries[f.Name] = f.CreateQuery
+	}
+	return names, queries
+}
@@ -0,0 +1,26 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionsForRestore(t *testing.T) {
+	functions := []metadata.FunctionsMeta{
+		{Name: "linear", CreateQuery: "CREATE FUNCTION linear AS (x, k, b) -> ((k * x) + b)"},
+		{Name: "twice", CreateQuery: "CREATE FUNCTION twice AS x -> linear(x, 2, 0)"},
+		{Name: "same", CreateQuery: "CREATE FUNCTION same AS x -> x"},
+	}
+	existing := map[string]string{
+		"same":  "CREATE FUNCTION same AS x -> x",
+		"twice": "CREATE FUNCTION twice AS x -> (x * 2)",
+	}
+	names, queries := functionsForRestore(functions, existing, "")
+	assert.Equal(t, []string{"linear", "twice"}, names)
+	assert.Equal(t, "CREATE FUNCTION twice AS x -> linear(x, 2, 0)", queries["twice"])
+
+	names, _ = functionsForRestore(functions, existing, "cluster")
+	assert.Equal(t, []string{"linear", "twice", "same"}, names)
+}
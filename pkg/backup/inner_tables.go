@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+	"github.com/google/uuid"
+)
+
+const (
+	// inner table of materialized view without TO clause is named `.inner.<view>` in Ordinary and `.inner_id.<view uuid>` in Atomic database
+	innerTablePrefix   = ".inner."
+	innerIdTablePrefix = ".inner_id."
+)
+
+// isAtomicDatabaseEngine - databases with these engines store tables by UUID
+func isAtomicDatabaseEngine(engine string) bool {
+	return engine == "Atomic" || engine == "Replicated"
+}
+
+// queryUUID - UUID from CREATE or ATTACH query, empty when query doesn't contain UUID clause
+func queryUUID(query string) string {
+	match := createObjectNameRE.FindStringSubmatch(query)
+	if match == nil || match[4] == "" {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(match[4])[len("UUID"):], " '")
+}
+
+// setQueryUUID - replace UUID clause of CREATE or ATTACH query, empty id removes UUID clause
+func setQueryUUID(query, id string) string {
+	match := createObjectNameRE.FindStringSubmatchIndex(query)
+	if match == nil {
+		return query
+	}
+	uuidClause := ""
+	if id != "" {
+		uuidClause = " UUID '" + id + "'"
+	}
+	return query[:match[7]] + uuidClause + query[match[1]:]
+}
+
+// innerTableOwners - materialized view of each inner table in tables, views without TO clause and their inner tables shall be restored together
+func innerTableOwners(tables ListOfTables) map[metadata.TableTitle]metadata.TableTitle {
+	viewsByUUID := map[string]metadata.TableTitle{}
+	views := map[metadata.TableTitle]bool{}
+	for _, t := range tables {
+		if getEngineFromQuery(t.Query) != "MaterializedView" {
+			continue
+		}
+		title := metadata.TableTitle{Database: t.Database, Table: t.Table}
+		views[title] = true
+		if id := queryUUID(t.Query); id != "" {
+			viewsByUUID[id] = title
+		}
+	}
+	owners := map[metadata.TableTitle]metadata.TableTitle{}
+	for _, t := range tables {
+		title := metadata.TableTitle{Database: t.Database, Table: t.Table}
+		if strings.HasPrefix(t.Table, innerIdTablePrefix) {
+			if view, exists := viewsByUUID[strings.TrimPrefix(t.Table, innerIdTablePrefix)]; exists && view.Database == t.Database {
+				owners[title] = view
+			}
+		} else if strings.HasPrefix(t.Table, innerTablePrefix) {
+			if view := (metadata.TableTitle{Database: t.Database, Table: strings.TrimPrefix(t.Table, innerTablePrefix)}); views[view] {
+				owners[title] = view
+			}
+		}
+	}
+	return owners
+}
+
+// databaseEnginesForRestore - engines of databases where tables are restored, absent databases will be created with default engine
+func databaseEnginesForRestore(ch *clickhouse.ClickHouse, tables ListOfTables) (map[string]string, error) {
+	databases, err := ch.GetDatabases()
+	if err != nil {
+		return nil, err
+	}
+	engines := make(map[string]string, len(databases))
+	for _, db := range databases {
+		engines[db.Name] = db.Engine
+	}
+	defaultEngine := ""
+	for _, t := range tables {
+		if _, exists := engines[t.Database]; exists {
+			continue
+		}
+		if defaultEngine == "" {
+			if defaultEngine, err = ch.GetDefaultDatabaseEngine(); err != nil {
+				return nil, err
+			}
+		}
+		engines[t.Database] = defaultEngine
+	}
+	return engines, nil
+}
+
+// alignInnerTables - rename inner tables and set UUID of materialized views according to engine of destination database,
+// owners are found before restore mapping, cause mapping removes UUID from renamed views
+func alignInnerTables(cfg *config.Config, tables ListOfTables, owners map[metadata.TableTitle]metadata.TableTitle, engines map[string]string) (ListOfTables, error) {
+	if len(owners) == 0 {
+		return tables, nil
+	}
+	dstOwners := make(map[metadata.TableTitle]metadata.TableTitle, len(owners))
+	for inner, view := range owners {
+		innerDatabase, innerTable := getRestoreDestination(cfg, inner.Database, inner.Table)
+		viewDatabase, viewTable := getRestoreDestination(cfg, view.Database, view.Table)
+		dstOwners[metadata.TableTitle{Database: innerDatabase, Table: innerTable}] = metadata.TableTitle{Database: viewDatabase, Table: viewTable}
+	}
+	result := make(ListOfTables, len(tables))
+	copy(result, tables)
+	viewIndex := map[metadata.TableTitle]int{}
+	for i, t := range result {
+		viewIndex[metadata.TableTitle{Database: t.Database, Table: t.Table}] = i
+	}
+	for i, t := range result {
+		view, isInner := dstOwners[metadata.TableTitle{Database: t.Database, Table: t.Table}]
+		j, viewExists := viewIndex[view]
+		if !isInner || !viewExists || view.Database != t.Database {
+			continue
+		}
+		viewQuery := result[j].Query
+		var innerName string
+		if isAtomicDatabaseEngine(engines[t.Database]) {
+			id := queryUUID(viewQuery)
+			if id == "" {
+				id = uuid.New().String()
+				viewQuery = setQueryUUID(viewQuery, id)
+			}
+			innerName = innerIdTablePrefix + id
+		} else {
+			viewQuery = setQueryUUID(viewQuery, "")
+			innerName = innerTablePrefix + view.Table
+		}
+		result[j].Query = viewQuery
+		if innerName == t.Table {
+			continue
+		}
+		query, err := renameCreateQuery(t.Query, t.Database, innerName)
+		if err != nil {
+			return nil, err
+		}
+		apexLog.Infof("inner table `%s`.`%s` of materialized view `%s` will restore as `%s`", t.Database, t.Table, view.Table, innerName)
+		result[i].Table = innerName
+		result[i].Query = query
+	}
+	return result, nil
+}
+
+// innerTableDestinations - names of restored inner tables which differ from restore mapping, view UUID is read from destination server cause it could be generated during schema restore
+func innerTableDestinations(cfg *config.Config, tables ListOfTables, dstTables map[metadata.TableTitle]clickhouse.Table) map[metadata.TableTitle]string {
+	destinations := map[metadata.TableTitle]string{}
+	for inner, view := range innerTableOwners(tables) {
+		viewDatabase, viewTable := getRestoreDestination(cfg, view.Database, view.Table)
+		dstView, exists := dstTables[metadata.TableTitle{Database: viewDatabase, Table: viewTable}]
+		if !exists {
+			continue
+		}
+		innerDatabase, innerTable := getRestoreDestination(cfg, inner.Database, inner.Table)
+		if innerDatabase != viewDatabase {
+			continue
+		}
+		name := innerTablePrefix + viewTable
+		if dstView.UUID != "" {
+			name = innerIdTablePrefix + dstView.UUID
+		}
+		if name != innerTable {
+			destinations[inner] = name
+		}
+	}
+	return destinations
+}
+
+// getDataRestoreDestination - the same as getRestoreDestination, but inner tables are renamed according to innerTableDestinations
+func getDataRestoreDestination(cfg *config.Config, innerDestinations map[metadata.TableTitle]string, database, table string) (string, string) {
+	dstDatabase, dstTable := getRestoreDestination(cfg, database, table)
+	if name, renamed := innerDestinations[metadata.TableTitle{Database: database, Table: table}]; renamed {
+		dstTable = name
+	}
+	return dstDatabase, dstTable
+}
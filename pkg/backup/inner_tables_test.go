@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+const testViewUUID = "5f8a3b2c-1d4e-4f60-8a7b-9c0d1e2f3a4b"
+
+func TestQueryUUID(t *testing.T) {
+	query := "ATTACH MATERIALIZED VIEW db.mv UUID '" + testViewUUID + "' (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src"
+	assert.Equal(t, testViewUUID, queryUUID(query))
+	assert.Equal(t, "", queryUUID("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"))
+	assert.Equal(t, "ATTACH MATERIALIZED VIEW db.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src", setQueryUUID(query, ""))
+	assert.Equal(t, "CREATE TABLE db.t UUID '"+testViewUUID+"' (id UInt64) ENGINE = Log", setQueryUUID("CREATE TABLE db.t (id UInt64) ENGINE = Log", testViewUUID))
+}
+
+func TestInnerTableOwners(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "ordinary", Table: "mv", Query: "ATTACH MATERIALIZED VIEW ordinary.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM ordinary.src"},
+		{Database: "ordinary", Table: ".inner.mv", Query: "CREATE TABLE ordinary.`.inner.mv` (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "ordinary", Table: ".inner.lost", Query: "CREATE TABLE ordinary.`.inner.lost` (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "atomic", Table: "mv", Query: "ATTACH MATERIALIZED VIEW atomic.mv UUID '" + testViewUUID + "' (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM atomic.src"},
+		{Database: "atomic", Table: ".inner_id." + testViewUUID, Query: "CREATE TABLE atomic.`.inner_id." + testViewUUID + "` (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	assert.Equal(t, map[metadata.TableTitle]metadata.TableTitle{
+		{Database: "ordinary", Table: ".inner.mv"}:               {Database: "ordinary", Table: "mv"},
+		{Database: "atomic", Table: ".inner_id." + testViewUUID}: {Database: "atomic", Table: "mv"},
+	}, innerTableOwners(tables))
+}
+
+func TestAlignInnerTables(t *testing.T) {
+	cfg := config.DefaultConfig()
+	ordinaryTables := ListOfTables{
+		{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src"},
+		{Database: "db", Table: ".inner.mv", Query: "CREATE TABLE db.`.inner.mv` (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	owners := innerTableOwners(ordinaryTables)
+
+	result, err := alignInnerTables(cfg, ordinaryTables, owners, map[string]string{"db": "Ordinary"})
+	assert.NoError(t, err)
+	assert.Equal(t, ordinaryTables, result)
+
+	result, err = alignInnerTables(cfg, ordinaryTables, owners, map[string]string{"db": "Atomic"})
+	assert.NoError(t, err)
+	id := queryUUID(result[0].Query)
+	assert.NotEmpty(t, id)
+	assert.Equal(t, ".inner_id."+id, result[1].Table)
+	assert.True(t, strings.HasPrefix(result[1].Query, "CREATE TABLE `db`.`.inner_id."+id+"` (`id` UInt64)"))
+	assert.Equal(t, ".inner.mv", ordinaryTables[1].Table, "source tables shall not be changed")
+
+	atomicTables := ListOfTables{
+		{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv UUID '" + testViewUUID + "' (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src"},
+		{Database: "db", Table: ".inner_id." + testViewUUID, Query: "CREATE TABLE db.`.inner_id." + testViewUUID + "` UUID 'a1b2c3d4-0000-4000-8000-000000000001' (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	result, err = alignInnerTables(cfg, atomicTables, innerTableOwners(atomicTables), map[string]string{"db": "Ordinary"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ATTACH MATERIALIZED VIEW db.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src", result[0].Query)
+	assert.Equal(t, ".inner.mv", result[1].Table)
+	assert.Equal(t, "CREATE TABLE `db`.`.inner.mv` (`id` UInt64) ENGINE = MergeTree ORDER BY id", result[1].Query)
+}
+
+func TestAlignInnerTablesWithMapping(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreTableMapping = map[string]string{"db.mv": "mv2"}
+	tables := ListOfTables{
+		{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src"},
+		{Database: "db", Table: ".inner.mv", Query: "CREATE TABLE db.`.inner.mv` (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	owners := innerTableOwners(tables)
+	mapped, err := applyRestoreMapping(cfg, tables)
+	assert.NoError(t, err)
+	result, err := alignInnerTables(cfg, mapped, owners, map[string]string{"db": "Ordinary"})
+	assert.NoError(t, err)
+	assert.Equal(t, "mv2", result[0].Table)
+	assert.Equal(t, ".inner.mv2", result[1].Table)
+}
+
+func TestInnerTableDestinations(t *testing.T) {
+	cfg := config.DefaultConfig()
+	tables := ListOfTables{
+		{Database: "db", Table: "mv", Query: "ATTACH MATERIALIZED VIEW db.mv (`id` UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src"},
+		{Database: "db", Table: ".inner.mv", Query: "CREATE TABLE db.`.inner.mv` (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	dstTables := map[metadata.TableTitle]clickhouse.Table{{Database: "db", Table: "mv"}: {Database: "db", Name: "mv", UUID: testViewUUID}}
+	destinations := innerTableDestinations(cfg, tables, dstTables)
+	assert.Equal(t, map[metadata.TableTitle]string{{Database: "db", Table: ".inner.mv"}: ".inner_id." + testViewUUID}, destinations)
+	database, table := getDataRestoreDestination(cfg, destinations, "db", ".inner.mv")
+	assert.Equal(t, []string{"db", ".inner_id." + testViewUUID}, []string{database, table})
+
+	dstTables[metadata.TableTitle{Database: "db", Table: "mv"}] = clickhouse.Table{Database: "db", Name: "mv"}
+	assert.Empty(t, innerTableDestinations(cfg, tables, dstTables))
+}
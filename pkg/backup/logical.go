@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+const (
+	// logicalPartName - pseudo part on default disk which contains exported data of table from `logical_backup_engines`, upload and download handle it as other parts
+	logicalPartName = "logical"
+	logicalDataFile = "data.native"
+	logicalDisk     = "default"
+)
+
+// isLogicalBackupTable - tables with engine from `logical_backup_engines` can't be frozen, their data is exported with SELECT
+func isLogicalBackupTable(cfg *config.Config, engine string) bool {
+	return clickhouse.IsTableEngineInList(engine, cfg.ClickHouse.LogicalBackupEngines)
+}
+
+// logicalDataPath - path of exported data inside backup, the same as path of part files in `shadow`
+func logicalDataPath(diskPath, backupName, database, table string) string {
+	return path.Join(diskPath, "backup", backupName, "shadow", common.TablePathEncode(database), common.TablePathEncode(table), logicalDisk, logicalPartName, logicalDataFile)
+}
+
+// addTableToBackupLogical - export table data into `user_files_path` with `file` table function and move it into backup as `logical` part
+func addTableToBackupLogical(cfg *config.Config, ch *clickhouse.ClickHouse, backupName, shadowBackupUUID, defaultPath string, disks []clickhouse.Disk, table *clickhouse.Table) (map[string][]metadata.Part, map[string]int64, error) {
+	fileName := fmt.Sprintf("clickhouse-backup-%s.native", shadowBackupUUID)
+	exportPath := path.Join(userFilesPath(cfg, defaultPath), fileName)
+	if err := ch.ExportTableData(table.Database, table.Name, fileName); err != nil {
+		_ = os.Remove(exportPath)
+		return nil, nil, fmt.Errorf("can't export data of '%s.%s': %v", table.Database, table.Name, err)
+	}
+	dataPath := logicalDataPath(defaultPath, backupName, table.Database, table.Name)
+	if err := filesystemhelper.MkdirAll(path.Dir(dataPath), ch, disks); err != nil {
+		return nil, nil, err
+	}
+	if err := moveFile(exportPath, dataPath); err != nil {
+		return nil, nil, fmt.Errorf("can't move exported data of '%s.%s': %v", table.Database, table.Name, err)
+	}
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	apexLog.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).WithField("engine", table.Engine).Debug("data exported")
+	return map[string][]metadata.Part{logicalDisk: {{Name: logicalPartName}}}, map[string]int64{logicalDisk: info.Size()}, nil
+}
+
+// restoreLogicalData - copy exported data into `user_files_path` and insert it into dstTable, rows are appended to existing table data
+func restoreLogicalData(cfg *config.Config, ch *clickhouse.ClickHouse, backupName string, table metadata.TableMetadata, dstTable metadata.TableMetadata, disks []clickhouse.Disk) error {
+	if len(table.Parts[logicalDisk]) == 0 {
+		return nil
+	}
+	defaultPath, err := ch.GetDefaultPath(disks)
+	if err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("clickhouse-backup-%s-%s.%s.native", backupName, common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	importPath := path.Join(userFilesPath(cfg, defaultPath), fileName)
+	if err := filesystemhelper.CopyFile(logicalDataPath(defaultPath, backupName, table.Database, table.Table), importPath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(importPath); err != nil {
+			apexLog.Warnf("can't remove %s: %v", importPath, err)
+		}
+	}()
+	if err := filesystemhelper.Chown(importPath, ch, disks); err != nil {
+		return err
+	}
+	return ch.ImportTableData(dstTable.Database, dstTable.Table, fileName)
+}
+
+// moveFile - rename file, `user_files_path` could be placed on another filesystem, so file is copied when rename fails
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := filesystemhelper.CopyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLogicalBackupTable(t *testing.T) {
+	cfg := config.DefaultConfig()
+	assert.False(t, isLogicalBackupTable(cfg, "Log"))
+	cfg.ClickHouse.LogicalBackupEngines = []string{"Log", "tinylog", "Memory"}
+	assert.True(t, isLogicalBackupTable(cfg, "Log"))
+	assert.True(t, isLogicalBackupTable(cfg, "TinyLog"))
+	assert.False(t, isLogicalBackupTable(cfg, "StripeLog"))
+	assert.False(t, isLogicalBackupTable(cfg, "MergeTree"))
+}
+
+func TestLogicalDataPath(t *testing.T) {
+	assert.Equal(t, "/var/lib/clickhouse/backup/b1/shadow/db/my%2Dlog/default/logical/data.native", logicalDataPath("/var/lib/clickhouse", "b1", "db", "my-log"))
+}
+
+func TestMoveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logical")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src, dst := path.Join(dir, "export.native"), path.Join(dir, "backup", "data.native")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("native"), 0640))
+	assert.NoError(t, os.MkdirAll(path.Dir(dst), 0750))
+	assert.NoError(t, moveFile(src, dst))
+	content, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "native", string(content))
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMarkDuplicatedPartsSkipLogical(t *testing.T) {
+	b := &Backuper{}
+	existsTable := &metadata.TableMetadata{Parts: map[string][]metadata.Part{"default": {{Name: logicalPartName}}}}
+	newTable := &metadata.TableMetadata{LogicalBackup: true, Parts: map[string][]metadata.Part{"default": {{Name: logicalPartName}}}}
+	b.markDuplicatedParts(&metadata.BackupMetadata{}, existsTable, newTable, false)
+	assert.False(t, newTable.Parts["default"][0].Required)
+}
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+
+	apexLog "github.com/apex/log"
+)
+
+var waitMergesPollInterval = time.Second
+
+// waitMergesBeforeFreeze - with `wait_merges_before_freeze` wait until running merges and not finished mutations of table drain to `wait_merges_threshold`
+func waitMergesBeforeFreeze(ctx context.Context, ch *clickhouse.ClickHouse, table *clickhouse.Table, log *apexLog.Entry) error {
+	if !ch.Config.WaitMergesBeforeFreeze {
+		return nil
+	}
+	timeout, err := time.ParseDuration(ch.Config.WaitMergesTimeout)
+	if err != nil {
+		return err
+	}
+	return waitMerges(ctx, func() (uint64, uint64, error) {
+		return ch.GetMergesCount(table.Database, table.Name)
+	}, ch.Config.WaitMergesThreshold, timeout, waitMergesPollInterval, log)
+}
+
+// waitMerges - poll count until merges and mutations are not greater than threshold, after timeout freeze continues with warning,
+// error of count query doesn't fail backup cause waiting is optional
+func waitMerges(ctx context.Context, count func() (uint64, uint64, error), threshold uint64, timeout, interval time.Duration, log *apexLog.Entry) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		merges, mutations, err := count()
+		if err != nil {
+			log.Warnf("can't get merges and mutations, freeze without waiting: %v", err)
+			return nil
+		}
+		if merges+mutations <= threshold {
+			if time.Since(start) >= interval {
+				log.Infof("merges and mutations drained after %s", time.Since(start).Round(time.Second))
+			}
+			return nil
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			log.Warnf("%d merges and %d mutations still running after %s, freeze anyway", merges, mutations, timeout)
+			return nil
+		}
+		log.Debugf("wait %d merges and %d mutations", merges, mutations)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitMerges(t *testing.T) {
+	log := apexLog.WithField("test", "TestWaitMerges")
+	counts := [][2]uint64{{2, 1}, {1, 1}, {0, 1}, {0, 0}}
+	calls := 0
+	count := func() (uint64, uint64, error) {
+		c := counts[calls]
+		calls++
+		return c[0], c[1], nil
+	}
+	assert.NoError(t, waitMerges(context.Background(), count, 0, time.Minute, time.Millisecond, log))
+	assert.Equal(t, 4, calls)
+
+	calls = 0
+	assert.NoError(t, waitMerges(context.Background(), count, 2, time.Minute, time.Millisecond, log))
+	assert.Equal(t, 2, calls, "threshold allows 2 merges and mutations")
+
+	calls = 0
+	busy := func() (uint64, uint64, error) {
+		calls++
+		return 1, 0, nil
+	}
+	assert.NoError(t, waitMerges(context.Background(), busy, 0, 5*time.Millisecond, time.Millisecond, log), "timeout doesn't fail backup")
+	assert.True(t, calls > 1)
+
+	assert.NoError(t, waitMerges(context.Background(), func() (uint64, uint64, error) { return 0, 0, fmt.Errorf("code: 60") }, 0, time.Minute, time.Millisecond, log))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, waitMerges(ctx, busy, 0, time.Minute, time.Millisecond, log), context.Canceled)
+}
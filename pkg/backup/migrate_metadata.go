@@ -0,0 +1,355 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+
+	apexLog "github.com/apex/log"
+)
+
+// MigrateMetadata - upgrade metadata of local or remote backup to metadata.MetadataVersion in place,
+// local backup of legacy layout with `metadata/<db>/<table>.sql` is converted to current layout
+func (b *Backuper) MigrateMetadata(ctx context.Context, backupName string, remote bool, version string) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "migrate-metadata",
+	})
+	if backupName == "" {
+		return fmt.Errorf("select backup for migration")
+	}
+	if remote && b.cfg.General.RemoteStorage == "none" {
+		return fmt.Errorf("remote storage is 'none'")
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if !remote {
+		if err := b.initDisks(nil); err != nil {
+			return err
+		}
+		return b.migrateMetadataLocal(backupName, version, log)
+	}
+	if err := b.init(ctx, nil); err != nil {
+		return err
+	}
+	return b.migrateMetadataRemote(ctx, backupName, log)
+}
+
+func (b *Backuper) migrateMetadataLocal(backupName, version string, log *apexLog.Entry) error {
+	backupPath := path.Join(b.DefaultDataPath, "backup", backupName)
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("'%s' is not found locally: %v", backupName, err)
+	}
+	metadataFile := path.Join(backupPath, "metadata.json")
+	body, err := ioutil.ReadFile(metadataFile)
+	if os.IsNotExist(err) {
+		if isIncompleteLocalBackup(backupPath) {
+			return fmt.Errorf("'%s' doesn't contain metadata.json and legacy schema, it is left by interrupted create or download", backupName)
+		}
+		return b.migrateLegacyLayout(backupName, backupPath, version, log)
+	}
+	if err != nil {
+		return err
+	}
+	migrated, changed, err := migrateBackupMetadataBody(body, backupName, "")
+	if err != nil {
+		return fmt.Errorf("can't migrate %s: %v", metadataFile, err)
+	}
+	if !changed {
+		log.Infof("metadata version %d is up to date", metadata.MetadataVersion)
+		return nil
+	}
+	if err := metadata.WriteFileAtomic(metadataFile, migrated, 0640); err != nil {
+		return err
+	}
+	log.WithField("version", metadata.MetadataVersion).Info("done")
+	return nil
+}
+
+func (b *Backuper) migrateMetadataRemote(ctx context.Context, backupName string, log *apexLog.Entry) error {
+	metadataFile := path.Join(backupName, "metadata.json")
+	r, err := b.dst.GetFileReader(ctx, metadataFile)
+	if errors.Is(err, new_storage.ErrNotFound) {
+		return fmt.Errorf("'%s' doesn't contain metadata.json, old format archive backup shall be downloaded, migrated locally and uploaded again", backupName)
+	}
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	var backupMetadata metadata.BackupMetadata
+	if err := json.Unmarshal(body, &backupMetadata); err != nil {
+		return fmt.Errorf("can't parse %s: %v", metadataFile, err)
+	}
+	dataFormat := ""
+	if backupMetadata.MetadataVersion == 0 && backupMetadata.DataFormat == "" {
+		if dataFormat, err = b.detectRemoteDataFormat(ctx, backupName, backupMetadata.Tables); err != nil {
+			return err
+		}
+		log.Infof("detected data_format: %s", dataFormat)
+	}
+	migrated, changed, err := migrateBackupMetadataBody(body, backupName, dataFormat)
+	if err != nil {
+		return fmt.Errorf("can't migrate %s: %v", metadataFile, err)
+	}
+	if !changed {
+		log.Infof("metadata version %d is up to date", metadata.MetadataVersion)
+		return nil
+	}
+	if err := b.dst.PutFile(ctx, metadataFile, ioutil.NopCloser(strings.NewReader(string(migrated)))); err != nil {
+		return fmt.Errorf("can't upload %s: %v", metadataFile, err)
+	}
+	log.WithField("version", metadata.MetadataVersion).Info("done")
+	return nil
+}
+
+// detectRemoteDataFormat - archives of table data are listed in `files` of table metadata, directory backups contain only parts
+func (b *Backuper) detectRemoteDataFormat(ctx context.Context, backupName string, tables []metadata.TableTitle) (string, error) {
+	for _, title := range tables {
+		tm, err := b.readRemoteTableMetadata(ctx, backupName, title)
+		if err != nil {
+			return "", fmt.Errorf("can't read metadata of '%s.%s': %v", title.Database, title.Table, err)
+		}
+		for _, files := range tm.Files {
+			if len(files) > 0 {
+				return compressionFormatByArchive(files[0])
+			}
+		}
+		for _, parts := range tm.Parts {
+			if len(parts) > 0 {
+				return "directory", nil
+			}
+		}
+	}
+	return "directory", nil
+}
+
+// compressionFormatByArchive - format with the longest extension of archive, `br` is preferred to `brotli` with the same extension
+func compressionFormatByArchive(archiveName string) (string, error) {
+	formats := make([]string, 0, len(config.ArchiveExtensions))
+	for format := range config.ArchiveExtensions {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	detected := ""
+	for _, format := range formats {
+		extension := config.ArchiveExtensions[format]
+		if strings.HasSuffix(archiveName, "."+extension) && (detected == "" || len(extension) > len(config.ArchiveExtensions[detected])) {
+			detected = format
+		}
+	}
+	if detected == "" {
+		return "", fmt.Errorf("can't detect compression format of archive '%s'", archiveName)
+	}
+	return detected, nil
+}
+
+// migrateBackupMetadataBody - set metadata_version, empty backup_name and data_format, other fields are kept as is,
+// false when metadata already has current version
+func migrateBackupMetadataBody(body []byte, backupName, dataFormat string) ([]byte, bool, error) {
+	var backupMetadata metadata.BackupMetadata
+	if err := json.Unmarshal(body, &backupMetadata); err != nil {
+		return nil, false, err
+	}
+	if err := backupMetadata.CheckVersion(false); err != nil {
+		return nil, false, err
+	}
+	if backupMetadata.MetadataVersion == metadata.MetadataVersion {
+		return body, false, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, false, err
+	}
+	values := map[string]interface{}{"metadata_version": metadata.MetadataVersion}
+	if backupMetadata.BackupName == "" {
+		values["backup_name"] = backupName
+	}
+	if dataFormat != "" {
+		values["data_format"] = dataFormat
+	}
+	for name, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, false, err
+		}
+		fields[name] = encoded
+	}
+	migrated, err := json.MarshalIndent(fields, "", "\t")
+	return migrated, true, err
+}
+
+// legacyPart - part directory of legacy layout, `shadow/<db>/<table>/<part>` or frozen ClickHouse shadow `shadow/<increment>/data/<db>/<table>/<part>`
+type legacyPart struct {
+	database, table, name, path string
+}
+
+// findLegacyParts - parts of legacy layout, parts moved to `shadow/<db>/<table>/default/<part>` by interrupted migration are found too
+func findLegacyParts(shadowPath string) ([]legacyPart, error) {
+	var parts []legacyPart
+	err := filepath.Walk(shadowPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || filePath == shadowPath {
+			return nil
+		}
+		segments := strings.Split(strings.Trim(strings.TrimPrefix(filePath, shadowPath), "/"), "/")
+		dbNum, depth := 0, 3
+		if _, err := strconv.Atoi(segments[0]); err == nil && (len(segments) == 1 || segments[1] == "data") {
+			dbNum, depth = 2, 5
+		} else if len(segments) >= 3 && segments[2] == "default" {
+			dbNum, depth = 0, 4
+		}
+		if len(segments) < depth {
+			return nil
+		}
+		parts = append(parts, legacyPart{database: segments[dbNum], table: segments[dbNum+1], name: segments[depth-1], path: filePath})
+		return filepath.SkipDir
+	})
+	return parts, err
+}
+
+// migrateLegacyLayout - parts are moved to `default` disk folder of current layout, `<table>.sql` schemas are replaced with table metadata,
+// metadata.json is written after all table metadata, so interrupted migration could be repeated
+func (b *Backuper) migrateLegacyLayout(backupName, backupPath, version string, log *apexLog.Entry) error {
+	schemas, err := filepath.Glob(path.Join(backupPath, "metadata", "*", "*.sql"))
+	if err != nil {
+		return err
+	}
+	shadowPath := path.Join(backupPath, "shadow")
+	var parts []legacyPart
+	if _, err := os.Stat(shadowPath); err == nil {
+		if parts, err = findLegacyParts(shadowPath); err != nil {
+			return err
+		}
+	}
+	tables := map[metadata.TableTitle]*metadata.TableMetadata{}
+	var titles []metadata.TableTitle
+	for _, schema := range schemas {
+		query, err := ioutil.ReadFile(schema)
+		if err != nil {
+			return err
+		}
+		encodedTable := strings.TrimSuffix(path.Base(schema), ".sql")
+		encodedDatabase := path.Base(path.Dir(schema))
+		database, _ := url.PathUnescape(encodedDatabase)
+		table, _ := url.PathUnescape(encodedTable)
+		title := metadata.TableTitle{Database: database, Table: table}
+		titles = append(titles, title)
+		tables[title] = &metadata.TableMetadata{
+			Database: database,
+			Table:    table,
+			Query:    strings.Replace(string(query), "ATTACH", "CREATE", 1),
+			Parts:    map[string][]metadata.Part{},
+			Size:     map[string]int64{},
+		}
+	}
+	var dataSize uint64
+	for _, part := range parts {
+		database, _ := url.PathUnescape(part.database)
+		table, _ := url.PathUnescape(part.table)
+		tm, exists := tables[metadata.TableTitle{Database: database, Table: table}]
+		if !exists {
+			log.Warnf("'%s' data of '%s.%s' doesn't have schema, skipped", part.path, database, table)
+			continue
+		}
+		dstPath := path.Join(tableShadowPath(b.DefaultDataPath, backupName, database, table, "default"), part.name)
+		if part.path != dstPath {
+			if err := os.MkdirAll(path.Dir(dstPath), 0750); err != nil {
+				return err
+			}
+			if err := os.Rename(part.path, dstPath); err != nil {
+				return err
+			}
+		}
+		size := int64(0)
+		if err := filepath.Walk(dstPath, func(filePath string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+		tm.Parts["default"] = append(tm.Parts["default"], metadata.Part{Name: part.name})
+		tm.Size["default"] += size
+		dataSize += uint64(size)
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		if titles[i].Database != titles[j].Database {
+			return titles[i].Database < titles[j].Database
+		}
+		return titles[i].Table < titles[j].Table
+	})
+	var metadataSize uint64
+	for _, title := range titles {
+		tm := tables[title]
+		sort.Slice(tm.Parts["default"], func(i, j int) bool { return tm.Parts["default"][i].Name < tm.Parts["default"][j].Name })
+		if b.cfg.General.FileChecksums {
+			if err := calculateTableChecksums(map[string]string{"default": b.DefaultDataPath}, backupName, tm.Database, tm.Table, tm.Parts); err != nil {
+				return err
+			}
+		}
+		size, err := tm.Save(path.Join(backupPath, "metadata", common.TablePathEncode(tm.Database), common.TablePathEncode(tm.Table)+".json"), false)
+		if err != nil {
+			return err
+		}
+		metadataSize += size
+	}
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		return err
+	}
+	backupMetadata := metadata.BackupMetadata{
+		MetadataVersion:         metadata.MetadataVersion,
+		BackupName:              backupName,
+		Disks:                   map[string]string{"default": b.DefaultDataPath},
+		ClickhouseBackupVersion: version,
+		CreationDate:            info.ModTime().UTC(),
+		DataSize:                dataSize,
+		MetadataSize:            metadataSize,
+		Tables:                  titles,
+		Databases:               []metadata.DatabasesMeta{},
+		Functions:               []metadata.FunctionsMeta{},
+	}
+	if err := backupMetadata.Save(path.Join(backupPath, "metadata.json")); err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		if err := os.Remove(schema); err != nil {
+			log.Warnf("can't remove %s: %v", schema, err)
+		}
+	}
+	// frozen ClickHouse shadow increments are empty after parts are moved
+	increments, _ := filepath.Glob(path.Join(shadowPath, "[0-9]*"))
+	for _, increment := range increments {
+		if err := os.RemoveAll(increment); err != nil {
+			log.Warnf("can't remove %s: %v", increment, err)
+		}
+	}
+	_ = os.Remove(path.Join(shadowPath, "increment.txt"))
+	log.WithField("tables", len(titles)).WithField("parts", len(parts)).Info("legacy backup converted")
+	return nil
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	apexLog "github.com/apex/log"
+)
+
+func TestCompressionFormatByArchive(t *testing.T) {
+	for archive, format := range map[string]string{
+		"default_1.tar":      "tar",
+		"default_1.tar.gz":   "gzip",
+		"default_1.tar.br":   "br",
+		"default_1.tar.zstd": "zstd",
+	} {
+		detected, err := compressionFormatByArchive(archive)
+		require.NoError(t, err)
+		assert.Equal(t, format, detected, archive)
+	}
+	_, err := compressionFormatByArchive("default_1.zip")
+	assert.EqualError(t, err, "can't detect compression format of archive 'default_1.zip'")
+}
+
+func TestMigrateBackupMetadataBody(t *testing.T) {
+	body := []byte(`{"creation_date":"2022-01-01T00:00:00Z","tables":[{"database":"db","table":"t"}],"unknown":1}`)
+	migrated, changed, err := migrateBackupMetadataBody(body, "b1", "gzip")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(migrated, &fields))
+	assert.Equal(t, float64(metadata.MetadataVersion), fields["metadata_version"])
+	assert.Equal(t, "b1", fields["backup_name"])
+	assert.Equal(t, "gzip", fields["data_format"])
+	assert.Equal(t, float64(1), fields["unknown"], "unknown fields shall be kept")
+
+	var backupMetadata metadata.BackupMetadata
+	require.NoError(t, json.Unmarshal(migrated, &backupMetadata))
+	require.NoError(t, backupMetadata.CheckVersion(true))
+
+	_, changed, err = migrateBackupMetadataBody(migrated, "b2", "")
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, _, err = migrateBackupMetadataBody([]byte(`{"metadata_version":1000}`), "b1", "")
+	assert.Error(t, err)
+	_, _, err = migrateBackupMetadataBody([]byte(`{"metadata_version":1,"required_version":"2.1.0","required_features":["incremental","encryption"]}`), "b1", "")
+	assert.EqualError(t, err, "backup requires clickhouse-backup 2.1.0 or newer, features encryption are not supported by this clickhouse-backup, upgrade clickhouse-backup")
+
+	backupMetadata = metadata.BackupMetadata{RequiredBackup: "b0", TablesManifest: tablesManifestFile}
+	backupMetadata.SetRequiredFeatures([]metadata.TableMetadata{{NativeBackup: true}, {Database: "db", Table: "t"}})
+	assert.Equal(t, []string{metadata.FeatureIncremental, metadata.FeatureNativeBackup, metadata.FeatureTablesManifest}, backupMetadata.RequiredFeatures)
+	assert.Equal(t, "1.5.0", backupMetadata.RequiredVersion)
+	require.NoError(t, backupMetadata.CheckVersion(true))
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	dataPath := t.TempDir()
+	backupPath := path.Join(dataPath, "backup", "legacy")
+	partPaths := []string{
+		path.Join(backupPath, "shadow", "db", "t1", "all_1_1_0"),
+		path.Join(backupPath, "shadow", "1", "data", "db", "t2", "all_2_2_0"),
+		// moved by interrupted migration
+		path.Join(backupPath, "shadow", "db", "t1", "default", "all_3_3_0"),
+	}
+	for _, partPath := range partPaths {
+		require.NoError(t, os.MkdirAll(partPath, 0750))
+		require.NoError(t, ioutil.WriteFile(path.Join(partPath, "data.bin"), []byte("data"), 0640))
+	}
+	require.NoError(t, os.MkdirAll(path.Join(backupPath, "metadata", "db"), 0750))
+	for _, table := range []string{"t1", "t2"} {
+		query := []byte("ATTACH TABLE db." + table + " (id UInt64) ENGINE = MergeTree ORDER BY id")
+		require.NoError(t, ioutil.WriteFile(path.Join(backupPath, "metadata", "db", table+".sql"), query, 0640))
+	}
+
+	b := &Backuper{cfg: config.DefaultConfig(), DefaultDataPath: dataPath}
+	require.NoError(t, b.migrateLegacyLayout("legacy", backupPath, "test", apexLog.WithField("backup", "legacy")))
+
+	body, err := ioutil.ReadFile(path.Join(backupPath, "metadata.json"))
+	require.NoError(t, err)
+	var backupMetadata metadata.BackupMetadata
+	require.NoError(t, json.Unmarshal(body, &backupMetadata))
+	assert.Equal(t, metadata.MetadataVersion, backupMetadata.MetadataVersion)
+	assert.Equal(t, []metadata.TableTitle{{Database: "db", Table: "t1"}, {Database: "db", Table: "t2"}}, backupMetadata.Tables)
+	assert.Equal(t, uint64(12), backupMetadata.DataSize)
+
+	var tm metadata.TableMetadata
+	_, err = tm.Load(path.Join(backupPath, "metadata", "db", "t1.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id", tm.Query)
+	require.Len(t, tm.Parts["default"], 2)
+	assert.Equal(t, "all_1_1_0", tm.Parts["default"][0].Name)
+	assert.Equal(t, "all_3_3_0", tm.Parts["default"][1].Name)
+	assert.NotEmpty(t, tm.Parts["default"][0].Checksums)
+	require.NoError(t, verifyTableChecksums(map[string]string{"default": dataPath}, "legacy", tm, false))
+
+	tm = metadata.TableMetadata{}
+	_, err = tm.Load(path.Join(backupPath, "metadata", "db", "t2.json"))
+	require.NoError(t, err)
+	assert.Equal(t, []metadata.Part{{Name: "all_2_2_0", Checksums: tm.Parts["default"][0].Checksums}}, tm.Parts["default"])
+	assert.NoDirExists(t, path.Join(backupPath, "shadow", "1"))
+	assert.NoFileExists(t, path.Join(backupPath, "metadata", "db", "t1.sql"))
+	assert.False(t, isIncompleteLocalBackup(backupPath))
+}
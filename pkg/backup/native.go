@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// nativeBackupDir - folder of backup with result of BACKUP statement, upload and download handle it as `access` and `configs`
+const nativeBackupDir = "native"
+
+// nativeBackupEngines - engines which are not *MergeTree, but support BACKUP statement
+var nativeBackupEngines = []string{"Log", "TinyLog", "StripeLog"}
+
+// isNativeBackupTable - with `backup_engine: native` data of these tables is written by BACKUP statement instead of FREEZE
+func isNativeBackupTable(cfg *config.Config, engine string) bool {
+	if cfg.ClickHouse.BackupEngine != "native" {
+		return false
+	}
+	return strings.HasSuffix(engine, "MergeTree") || clickhouse.IsTableEngineInList(engine, nativeBackupEngines)
+}
+
+// nativePartitions - sorted partition IDs of table from `--partitions`
+func nativePartitions(partitionsMap common.EmptyMap, database, table string) []string {
+	partitionsFilter := filesystemhelper.GetPartitionsFilterForTable(partitionsMap, database, table)
+	partitions := make([]string, 0, len(partitionsFilter))
+	for id := range partitionsFilter {
+		partitions = append(partitions, id)
+	}
+	sort.Strings(partitions)
+	return partitions
+}
+
+// nativeBackupDiskPath - local path of `native_backup_disk`, this disk shall be listed in `<backups><allowed_disk>` server config
+func nativeBackupDiskPath(cfg *config.Config, disks []clickhouse.Disk) (string, error) {
+	for _, disk := range disks {
+		if disk.Name == cfg.ClickHouse.NativeBackupDisk {
+			return disk.Path, nil
+		}
+	}
+	return "", fmt.Errorf("native_backup_disk '%s' is not found in system.disks", cfg.ClickHouse.NativeBackupDisk)
+}
+
+// createNativeBackup - run one BACKUP statement for all tables and move its result into `native` folder of backup
+func createNativeBackup(cfg *config.Config, ch *clickhouse.ClickHouse, backupName, backupPath string, tables []clickhouse.NativeBackupTable, disks []clickhouse.Disk) (uint64, error) {
+	diskPath, err := nativeBackupDiskPath(cfg, disks)
+	if err != nil {
+		return 0, err
+	}
+	nativePath := path.Join(diskPath, backupName)
+	if err := ch.BackupNative(tables, cfg.ClickHouse.NativeBackupDisk, backupName); err != nil {
+		if removeErr := os.RemoveAll(nativePath); removeErr != nil {
+			apexLog.Warnf("can't remove %s: %v", nativePath, removeErr)
+		}
+		return 0, err
+	}
+	dstPath := path.Join(backupPath, nativeBackupDir)
+	if err := os.Rename(nativePath, dstPath); err != nil {
+		if err := linkOrCopyDir(nativePath, dstPath, cfg.General.LinkMode); err != nil {
+			return 0, fmt.Errorf("can't move %s to %s: %v", nativePath, dstPath, err)
+		}
+		if err := os.RemoveAll(nativePath); err != nil {
+			return 0, err
+		}
+	}
+	return dirSize(dstPath)
+}
+
+// restoreNativeData - link `native` folder of backup into `native_backup_disk` and restore data of all tables with one RESTORE statement
+func restoreNativeData(cfg *config.Config, ch *clickhouse.ClickHouse, backupName, backupPath string, tables []clickhouse.NativeBackupTable, disks []clickhouse.Disk) error {
+	diskPath, err := nativeBackupDiskPath(cfg, disks)
+	if err != nil {
+		return err
+	}
+	name := "clickhouse-backup-restore-" + backupName
+	nativePath := path.Join(diskPath, name)
+	if _, err := os.Stat(nativePath); err == nil {
+		return fmt.Errorf("%s already exists, another restore of '%s' is running or was interrupted", nativePath, backupName)
+	}
+	if err := linkOrCopyDir(path.Join(backupPath, nativeBackupDir), nativePath, cfg.General.LinkMode); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(nativePath); err != nil {
+			apexLog.Warnf("can't remove %s: %v", nativePath, err)
+		}
+	}()
+	return ch.RestoreNative(tables, cfg.ClickHouse.NativeBackupDisk, name)
+}
+
+// nativeRestoreTables - tables with data in `native` folder and their restore destinations
+func nativeRestoreTables(cfg *config.Config, tables ListOfTables, innerDestinations map[metadata.TableTitle]string, partitionsMap common.EmptyMap) []clickhouse.NativeBackupTable {
+	var result []clickhouse.NativeBackupTable
+	for _, t := range tables {
+		if !t.NativeBackup {
+			continue
+		}
+		dstDatabase, dstTable := getDataRestoreDestination(cfg, innerDestinations, t.Database, t.Table)
+		result = append(result, clickhouse.NativeBackupTable{
+			Database:    t.Database,
+			Table:       t.Table,
+			DstDatabase: dstDatabase,
+			DstTable:    dstTable,
+			Partitions:  nativePartitions(partitionsMap, t.Database, t.Table),
+		})
+	}
+	return result
+}
+
+// linkOrCopyDir - hardlink all files of src into dst according to `link_mode`, see filesystemhelper.LinkFile
+func linkOrCopyDir(src, dst, linkMode string) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(dstPath, info.Mode().Perm())
+		}
+		return filesystemhelper.LinkFile(filePath, dstPath, linkMode)
+	})
+}
+
+// dirSize - total size of regular files in dir
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNativeBackupTable(t *testing.T) {
+	cfg := config.DefaultConfig()
+	assert.False(t, isNativeBackupTable(cfg, "MergeTree"))
+	cfg.ClickHouse.BackupEngine = "native"
+	assert.True(t, isNativeBackupTable(cfg, "ReplicatedReplacingMergeTree"))
+	assert.True(t, isNativeBackupTable(cfg, "StripeLog"))
+	assert.False(t, isNativeBackupTable(cfg, "MaterializedView"))
+	assert.False(t, isNativeBackupTable(cfg, "Distributed"))
+}
+
+func TestNativeRestoreTables(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db": "db_copy"}
+	tables := ListOfTables{
+		{Database: "db", Table: "t", NativeBackup: true},
+		{Database: "db", Table: "v"},
+		{Database: "other", Table: "log", NativeBackup: true},
+	}
+	partitionsMap := filesystemhelper.CreatePartitionsToBackupMap([]string{"db.t:202302,202301"})
+	assert.Equal(t, []clickhouse.NativeBackupTable{
+		{Database: "db", Table: "t", DstDatabase: "db_copy", DstTable: "t", Partitions: []string{"202301", "202302"}},
+		{Database: "other", Table: "log", DstDatabase: "other", DstTable: "log", Partitions: []string{}},
+	}, nativeRestoreTables(cfg, tables, map[metadata.TableTitle]string{}, partitionsMap))
+}
+
+func TestLinkOrCopyDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "native")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "data", "db", "t", "all_1_1_0"), 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, ".backup"), []byte("<config/>"), 0640))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "data", "db", "t", "all_1_1_0", "data.bin"), []byte("12345"), 0640))
+	dst := filepath.Join(t.TempDir(), "restore")
+	require.NoError(t, linkOrCopyDir(src, dst, "auto"))
+	data, err := ioutil.ReadFile(filepath.Join(dst, "data", "db", "t", "all_1_1_0", "data.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "12345", string(data))
+	size, err := dirSize(dst)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(14), size)
+}
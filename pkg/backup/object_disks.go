@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+// tableObjectDisks - object disks with data of *MergeTree table, other engines are not frozen
+func tableObjectDisks(table clickhouse.Table, disks []clickhouse.Disk) []string {
+	if !strings.HasSuffix(table.Engine, "MergeTree") {
+		return nil
+	}
+	return clickhouse.GetObjectDisks(disks, table.DataPaths)
+}
+
+// isDownloadedThroughServer - with `object_disk_backup_mode: download` data of tables on object disks is exported with SELECT the same way as `logical_backup_engines`
+func isDownloadedThroughServer(cfg *config.Config, objectDisks []string) bool {
+	return len(objectDisks) > 0 && cfg.ClickHouse.ObjectDiskBackupMode == "download"
+}
+
+// objectDiskProblems - parts frozen in `zero-copy` mode contain only references to objects,
+// so destination disk with the same name shall be object disk of the same type which points to the same bucket
+func objectDiskProblems(tables ListOfTables, disks []clickhouse.Disk, backupDiskTypes map[string]string) []string {
+	dstDisks := make(map[string]clickhouse.Disk, len(disks))
+	for _, disk := range disks {
+		dstDisks[disk.Name] = disk
+	}
+	var problems []string
+	for _, t := range tables {
+		for _, diskName := range t.ObjectDisks {
+			if len(t.Parts[diskName]) == 0 {
+				continue
+			}
+			disk, exists := dstDisks[diskName]
+			backupType := backupDiskTypes[diskName]
+			if exists && clickhouse.IsObjectDisk(disk) && (backupType == "" || strings.EqualFold(backupType, disk.Type)) {
+				continue
+			}
+			dstType := "absent"
+			if exists {
+				dstType = disk.Type
+			}
+			problems = append(problems, fmt.Sprintf("`%s`.`%s` parts on disk '%s' contain references to %s objects, but destination disk is %s, use `restore_disk_mapping` to disk with the same bucket or create backup with `object_disk_backup_mode: download`", t.Database, t.Table, diskName, backupType, dstType))
+		}
+	}
+	return problems
+}
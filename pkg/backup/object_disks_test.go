@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableObjectDisks(t *testing.T) {
+	disks := []clickhouse.Disk{
+		{Name: "default", Path: "/var/lib/clickhouse/", Type: "local"},
+		{Name: "s3", Path: "/var/lib/clickhouse/disks/s3/", Type: "s3"},
+		{Name: "azure", Path: "/var/lib/clickhouse/disks/azure/", Type: "azure_blob_storage"},
+	}
+	table := clickhouse.Table{
+		Database:  "db",
+		Name:      "t",
+		Engine:    "ReplicatedMergeTree",
+		DataPaths: []string{"/var/lib/clickhouse/store/abc/abcd/", "/var/lib/clickhouse/disks/s3/store/abc/abcd/", "/var/lib/clickhouse/disks/azure/store/abc/abcd/"},
+	}
+	objectDisks := tableObjectDisks(table, disks)
+	assert.Equal(t, []string{"azure", "s3"}, objectDisks)
+
+	cfg := config.DefaultConfig()
+	assert.True(t, isDownloadedThroughServer(cfg, objectDisks))
+	cfg.ClickHouse.ObjectDiskBackupMode = "zero-copy"
+	assert.False(t, isDownloadedThroughServer(cfg, objectDisks))
+
+	table.DataPaths = table.DataPaths[:1]
+	assert.Empty(t, tableObjectDisks(table, disks))
+	table.Engine, table.DataPaths = "Log", []string{"/var/lib/clickhouse/disks/s3/store/abc/abcd/"}
+	assert.Empty(t, tableObjectDisks(table, disks))
+}
+
+func TestRemoveTableShadowZeroCopy(t *testing.T) {
+	disks := []clickhouse.Disk{
+		{Name: "default", Path: t.TempDir(), Type: "local"},
+		{Name: "s3", Path: t.TempDir(), Type: "s3"},
+	}
+	for _, disk := range disks {
+		assert.NoError(t, os.MkdirAll(path.Join(disk.Path, "shadow", "uuid1", "store", "abc"), 0750))
+	}
+	// ClickHouse without connection and config panics on any query, so UNFREEZE must not be executed for zero-copy backup
+	ch := &clickhouse.ClickHouse{}
+	table := &clickhouse.Table{Database: "db", Name: "t", Engine: "MergeTree"}
+	assert.NotPanics(t, func() {
+		assert.NoError(t, removeTableShadow(ch, table, "uuid1", disks, true, apexLog.WithField("table", "db.t")))
+	})
+	for _, disk := range disks {
+		assert.NoDirExists(t, path.Join(disk.Path, "shadow", "uuid1"))
+	}
+}
+
+func TestObjectDiskProblems(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "t", ObjectDisks: []string{"s3"}, Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}, "s3": {{Name: "all_2_2_0"}}}},
+		{Database: "db", Table: "empty", ObjectDisks: []string{"s3"}, Parts: map[string][]metadata.Part{"s3": {}}},
+		{Database: "db", Table: "local", Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}},
+	}
+	backupDiskTypes := map[string]string{"default": "local", "s3": "s3"}
+	assert.Empty(t, objectDiskProblems(tables, []clickhouse.Disk{{Name: "default", Type: "local"}, {Name: "s3", Type: "s3"}}, backupDiskTypes))
+	assert.Equal(t, []string{
+		"`db`.`t` parts on disk 's3' contain references to s3 objects, but destination disk is absent, use `restore_disk_mapping` to disk with the same bucket or create backup with `object_disk_backup_mode: download`",
+	}, objectDiskProblems(tables, []clickhouse.Disk{{Name: "default", Type: "local"}}, backupDiskTypes))
+	assert.Len(t, objectDiskProblems(tables, []clickhouse.Disk{{Name: "s3", Type: "local"}}, backupDiskTypes), 1)
+	assert.Len(t, objectDiskProblems(tables, []clickhouse.Disk{{Name: "s3", Type: "azure_blob_storage"}}, backupDiskTypes), 1)
+	assert.Empty(t, objectDiskProblems(tables, []clickhouse.Disk{{Name: "s3", Type: "azure_blob_storage"}}, nil))
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"gopkg.in/yaml.v2"
+)
+
+// OutputFormats - values allowed for `--format` of list, tables and verify commands, text is human-readable default
+var OutputFormats = []string{"text", "json", "yaml", "tsv"}
+
+// BackupListItem - stable `list --format` schema, tsv columns follow fields order
+type BackupListItem struct {
+	Name           string            `json:"name" yaml:"name"`
+	Location       string            `json:"location" yaml:"location"`
+	Created        time.Time         `json:"created" yaml:"created"`
+	Size           uint64            `json:"size" yaml:"size"`
+	CompressedSize uint64            `json:"compressed_size" yaml:"compressed_size"`
+	DataFormat     string            `json:"data_format" yaml:"data_format"`
+	RequiredBackup string            `json:"required_backup" yaml:"required_backup"`
+	Legacy         bool              `json:"legacy" yaml:"legacy"`
+	Broken         string            `json:"broken" yaml:"broken"`
+	Tags           map[string]string `json:"tags" yaml:"tags"`
+	Description    string            `json:"description" yaml:"description"`
+}
+
+func (i BackupListItem) tsvRow() []string {
+	tags, _ := metadata.FormatTags(i.Tags)
+	return []string{i.Name, i.Location, i.Created.UTC().Format(time.RFC3339), fmt.Sprint(i.Size), fmt.Sprint(i.CompressedSize), i.DataFormat, i.RequiredBackup, fmt.Sprint(i.Legacy), i.Broken, tags, i.Description}
+}
+
+// TableListItem - stable `tables --format` schema, tsv columns follow fields order, disks joined by comma
+type TableListItem struct {
+	Database   string   `json:"database" yaml:"database"`
+	Table      string   `json:"table" yaml:"table"`
+	Engine     string   `json:"engine" yaml:"engine"`
+	TotalBytes uint64   `json:"total_bytes" yaml:"total_bytes"`
+	TotalRows  uint64   `json:"total_rows" yaml:"total_rows"`
+	Disks      []string `json:"disks" yaml:"disks"`
+	Skip       bool     `json:"skip" yaml:"skip"`
+}
+
+func (i TableListItem) tsvRow() []string {
+	return []string{i.Database, i.Table, i.Engine, fmt.Sprint(i.TotalBytes), fmt.Sprint(i.TotalRows), strings.Join(i.Disks, ","), fmt.Sprint(i.Skip)}
+}
+
+// VerifyReport - stable `verify --format` schema, tsv contains one row per problem after summary row
+type VerifyReport struct {
+	Backup   string   `json:"backup" yaml:"backup"`
+	Location string   `json:"location" yaml:"location"`
+	Status   string   `json:"status" yaml:"status"`
+	Files    int64    `json:"files" yaml:"files"`
+	Duration float64  `json:"duration_seconds" yaml:"duration_seconds"`
+	Problems []string `json:"problems" yaml:"problems"`
+}
+
+func validateOutputFormat(outputFormat string) error {
+	if outputFormat == "" {
+		return nil
+	}
+	for _, f := range OutputFormats {
+		if outputFormat == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format '%s', allowed values: %s", outputFormat, strings.Join(OutputFormats, ", "))
+}
+
+// printStructured - write v as json or yaml document, tsv rows are written without header, tab and new line inside values are escaped
+func printStructured(w io.Writer, outputFormat string, v interface{}, rows [][]string) error {
+	switch outputFormat {
+	case "json":
+		return json.NewEncoder(w).Encode(v)
+	case "yaml":
+		body, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(body)
+		return err
+	case "tsv":
+		escaper := strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n")
+		for _, row := range rows {
+			for i := range row {
+				row[i] = escaper.Replace(row[i])
+			}
+			if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return validateOutputFormat(outputFormat)
+	}
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintStructured(t *testing.T) {
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []BackupListItem{
+		{Name: "b1", Location: "local", Created: created, Size: 100, DataFormat: "tar", Tags: map[string]string{"type": "manual", "env": "prod"}, Description: "pre-upgrade"},
+		{Name: "b2", Location: "remote", Created: created, Size: 200, CompressedSize: 50, DataFormat: "tar", RequiredBackup: "b1", Broken: "broken\t(can't stat metadata.json)"},
+	}
+	rows := [][]string{items[0].tsvRow(), items[1].tsvRow()}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, printStructured(out, "tsv", items, rows))
+	assert.Equal(t, "b1\tlocal\t2022-01-02T03:04:05Z\t100\t0\ttar\t\tfalse\t\tenv=prod,type=manual\tpre-upgrade\n"+
+		"b2\tremote\t2022-01-02T03:04:05Z\t200\t50\ttar\tb1\tfalse\tbroken\\t(can't stat metadata.json)\t\t\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, printStructured(out, "json", items[:1], nil))
+	assert.Equal(t, `[{"name":"b1","location":"local","created":"2022-01-02T03:04:05Z","size":100,"compressed_size":0,"data_format":"tar","required_backup":"","legacy":false,"broken":"","tags":{"env":"prod","type":"manual"},"description":"pre-upgrade"}]`+"\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, printStructured(out, "yaml", TableListItem{Database: "db", Table: "t", Engine: "MergeTree", Disks: []string{"default"}}, nil))
+	assert.Equal(t, "database: db\ntable: t\nengine: MergeTree\ntotal_bytes: 0\ntotal_rows: 0\ndisks:\n- default\nskip: false\n", out.String())
+
+	assert.Error(t, printStructured(out, "xml", items, rows))
+	assert.NoError(t, validateOutputFormat(""))
+	assert.NoError(t, validateOutputFormat("text"))
+}
+
+func TestSelectBackupListItems(t *testing.T) {
+	items := []BackupListItem{{Name: "b1"}, {Name: "b2"}, {Name: "b3"}}
+	selected, err := selectBackupListItems(items, "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupListItem{{Name: "b3"}}, selected)
+	selected, err = selectBackupListItems(items, "penult")
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupListItem{{Name: "b2"}}, selected)
+	selected, err = selectBackupListItems(items, "")
+	assert.NoError(t, err)
+	assert.Equal(t, items, selected)
+	_, err = selectBackupListItems(items[:1], "penult")
+	assert.Error(t, err)
+	_, err = selectBackupListItems(items, "unknown")
+	assert.Error(t, err)
+}
+
+func TestBackupTableListItems(t *testing.T) {
+	backup := &backupWithTables{
+		Tables: map[metadata.TableTitle]*metadata.TableMetadata{
+			{Database: "db", Table: "t2"}: {
+				Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = MergeTree ORDER BY id",
+				Parts: map[string][]metadata.Part{"hdd": {{Name: "all_1_1_0"}}, "default": {{Name: "all_2_2_0"}}, "empty": {}},
+				Size:  map[string]int64{"hdd": 100, "default": 50},
+			},
+			{Database: "db", Table: "t1"}: {Query: "CREATE VIEW db.t1 AS SELECT 1", MetadataOnly: true},
+		},
+	}
+	assert.Equal(t, []TableListItem{
+		{Database: "db", Table: "t1", Engine: "View", Disks: []string{}, Skip: true},
+		{Database: "db", Table: "t2", Engine: "MergeTree", TotalBytes: 150, Disks: []string{"default", "hdd"}},
+	}, backupTableListItems(backup, []string{"system.*", " db.t1 "}))
+}
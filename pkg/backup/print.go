@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
@@ -8,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -52,7 +54,7 @@ func printBackupsRemote(w io.Writer, backupList []new_storage.Backup, format str
 				description = backup.Broken
 				size = "???"
 			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", backup.BackupName, size, uploadDate, "remote", required, description)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", backup.BackupName, size, uploadDate, "remote", required, description, backup.Tags, backup.Description)
 		}
 	default:
 		return fmt.Errorf("'%s' undefined", format)
@@ -94,7 +96,7 @@ func printBackupsLocal(w io.Writer, backupList []BackupLocal, format string) err
 				description = backup.Broken
 				size = "???"
 			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", backup.BackupName, size, creationDate, "local", required, description)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", backup.BackupName, size, creationDate, "local", required, description, backup.Tags, backup.Description)
 		}
 	default:
 		return fmt.Errorf("'%s' undefined", format)
@@ -102,14 +104,97 @@ func printBackupsLocal(w io.Writer, backupList []BackupLocal, format string) err
 	return nil
 }
 
-// PrintLocalBackups - print all backups stored locally
-func PrintLocalBackups(cfg *config.Config, format string) error {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
-	defer w.Flush()
+func localBackupListItems(backupList []BackupLocal) []BackupListItem {
+	items := make([]BackupListItem, len(backupList))
+	for i, backup := range backupList {
+		items[i] = BackupListItem{
+			Name:           backup.BackupName,
+			Location:       "local",
+			Created:        backup.CreationDate,
+			Size:           backup.DataSize + backup.MetadataSize,
+			CompressedSize: backup.CompressedSize,
+			DataFormat:     backup.DataFormat,
+			RequiredBackup: backup.RequiredBackup,
+			Legacy:         backup.Legacy,
+			Broken:         backup.Broken,
+			Tags:           backup.GetTags(),
+			Description:    backup.Description,
+		}
+	}
+	return items
+}
+
+func remoteBackupListItems(backupList []new_storage.Backup) []BackupListItem {
+	items := make([]BackupListItem, len(backupList))
+	for i, backup := range backupList {
+		items[i] = BackupListItem{
+			Name:           backup.BackupName,
+			Location:       "remote",
+			Created:        backup.CreationDate,
+			Size:           backup.DataSize + backup.MetadataSize,
+			CompressedSize: backup.CompressedSize,
+			DataFormat:     backup.DataFormat,
+			RequiredBackup: backup.RequiredBackup,
+			Legacy:         backup.Legacy,
+			Broken:         backup.Broken,
+			Tags:           backup.GetTags(),
+			Description:    backup.Description,
+		}
+	}
+	return items
+}
+
+// selectBackupListItems - apply `latest` and `penult` list argument the same way as text output
+func selectBackupListItems(items []BackupListItem, format string) ([]BackupListItem, error) {
+	switch format {
+	case "latest", "last", "l":
+		if len(items) < 1 {
+			return nil, fmt.Errorf("no backups found")
+		}
+		return items[len(items)-1:], nil
+	case "penult", "prev", "previous", "p":
+		if len(items) < 2 {
+			return nil, fmt.Errorf("no penult backup is found")
+		}
+		return items[len(items)-2 : len(items)-1], nil
+	case "all", "":
+		return items, nil
+	default:
+		return nil, fmt.Errorf("'%s' undefined", format)
+	}
+}
+
+func printBackupListItems(items []BackupListItem, outputFormat string) error {
+	rows := make([][]string, len(items))
+	for i := range items {
+		rows[i] = items[i].tsvRow()
+	}
+	return printStructured(os.Stdout, outputFormat, items, rows)
+}
+
+func isTextOutput(outputFormat string) bool {
+	return outputFormat == "" || outputFormat == "text"
+}
+
+// PrintLocalBackups - print all backups stored locally, only backups with all tags are printed when tags is not empty
+func PrintLocalBackups(cfg *config.Config, format, outputFormat string, tags map[string]string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
 	backupList, _, err := GetLocalBackups(cfg, nil)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	backupList = filterLocalBackupsByTags(backupList, tags)
+	if !isTextOutput(outputFormat) {
+		items, err := selectBackupListItems(localBackupListItems(backupList), format)
+		if err != nil {
+			return err
+		}
+		return printBackupListItems(items, outputFormat)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	defer w.Flush()
 	return printBackupsLocal(w, backupList, format)
 }
 
@@ -172,9 +257,14 @@ func GetLocalBackups(cfg *config.Config, disks []clickhouse.Disk) ([]BackupLocal
 		if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
 			return nil, disks, err
 		}
+		broken := ""
+		if err := backupMetadata.CheckVersion(false); err != nil {
+			broken = fmt.Sprintf("broken (%v)", err)
+		}
 		result = append(result, BackupLocal{
 			BackupMetadata: backupMetadata,
 			Legacy:         false,
+			Broken:         broken,
 		})
 	}
 	sort.SliceStable(result, func(i, j int) bool {
@@ -183,33 +273,58 @@ func GetLocalBackups(cfg *config.Config, disks []clickhouse.Disk) ([]BackupLocal
 	return result, disks, nil
 }
 
-func PrintAllBackups(cfg *config.Config, format string) error {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
-	defer w.Flush()
+func PrintAllBackups(ctx context.Context, cfg *config.Config, format, outputFormat string, tags map[string]string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
 	localBackups, _, err := GetLocalBackups(cfg, nil)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	printBackupsLocal(w, localBackups, format)
-
+	localBackups = filterLocalBackupsByTags(localBackups, tags)
+	var remoteBackups []new_storage.Backup
 	if cfg.General.RemoteStorage != "none" {
-		remoteBackups, err := GetRemoteBackups(cfg, true)
-		if err != nil {
+		if remoteBackups, err = GetRemoteBackups(ctx, cfg, true); err != nil {
 			return err
 		}
+		remoteBackups = new_storage.FilterBackupsByTags(remoteBackups, tags)
+	}
+	if !isTextOutput(outputFormat) {
+		localItems, localErr := selectBackupListItems(localBackupListItems(localBackups), format)
+		remoteItems, remoteErr := selectBackupListItems(remoteBackupListItems(remoteBackups), format)
+		if localErr != nil && remoteErr != nil {
+			return localErr
+		}
+		return printBackupListItems(append(localItems, remoteItems...), outputFormat)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	defer w.Flush()
+	printBackupsLocal(w, localBackups, format)
+	if cfg.General.RemoteStorage != "none" {
 		printBackupsRemote(w, remoteBackups, format)
 	}
 	return nil
 }
 
-// PrintRemoteBackups - print all backups stored on remote storage
-func PrintRemoteBackups(cfg *config.Config, format string) error {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
-	defer w.Flush()
-	backupList, err := GetRemoteBackups(cfg, true)
+// PrintRemoteBackups - print all backups stored on remote storage, only backups with all tags are printed when tags is not empty
+func PrintRemoteBackups(ctx context.Context, cfg *config.Config, format, outputFormat string, tags map[string]string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	backupList, err := GetRemoteBackups(ctx, cfg, true)
 	if err != nil {
 		return err
 	}
+	backupList = new_storage.FilterBackupsByTags(backupList, tags)
+	if !isTextOutput(outputFormat) {
+		items, err := selectBackupListItems(remoteBackupListItems(backupList), format)
+		if err != nil {
+			return err
+		}
+		return printBackupListItems(items, outputFormat)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	defer w.Flush()
 	return printBackupsRemote(w, backupList, format)
 }
 
@@ -229,8 +344,23 @@ func getLocalBackup(cfg *config.Config, backupName string, disks []clickhouse.Di
 	return nil, disks, fmt.Errorf("backup '%s' is not found", backupName)
 }
 
+// GetRemoteStorageUsage - total bytes and objects count on remote storage
+func GetRemoteStorageUsage(ctx context.Context, cfg *config.Config) (new_storage.StorageUsage, error) {
+	if cfg.General.RemoteStorage == "none" {
+		return new_storage.StorageUsage{}, fmt.Errorf("remote_storage is 'none'")
+	}
+	bd, err := new_storage.NewBackupDestination(cfg, false)
+	if err != nil {
+		return new_storage.StorageUsage{}, err
+	}
+	if err := bd.Connect(ctx); err != nil {
+		return new_storage.StorageUsage{}, err
+	}
+	return bd.Usage(ctx)
+}
+
 // GetRemoteBackups - get all backups stored on remote storage
-func GetRemoteBackups(cfg *config.Config, parseMetadata bool) ([]new_storage.Backup, error) {
+func GetRemoteBackups(ctx context.Context, cfg *config.Config, parseMetadata bool) ([]new_storage.Backup, error) {
 	if cfg.General.RemoteStorage == "none" {
 		return nil, fmt.Errorf("remote_storage is 'none'")
 	}
@@ -238,17 +368,17 @@ func GetRemoteBackups(cfg *config.Config, parseMetadata bool) ([]new_storage.Bac
 	if err != nil {
 		return []new_storage.Backup{}, err
 	}
-	if err := bd.Connect(); err != nil {
+	if err := bd.Connect(ctx); err != nil {
 		return []new_storage.Backup{}, err
 	}
-	backupList, err := bd.BackupList(parseMetadata, "")
+	backupList, err := bd.BackupList(ctx, parseMetadata, "")
 	if err != nil {
 		return []new_storage.Backup{}, err
 	}
 	// ugly hack to fix https://github.com/mxalis/clickhouse-backup/issues/309
 	if parseMetadata == false && len(backupList) > 0 {
 		lastBackup := backupList[len(backupList)-1]
-		backupList, err = bd.BackupList(true, lastBackup.BackupName)
+		backupList, err = bd.BackupList(ctx, true, lastBackup.BackupName)
 		if err != nil {
 			return []new_storage.Backup{}, err
 		}
@@ -275,7 +405,10 @@ func GetTables(cfg *config.Config) ([]clickhouse.Table, error) {
 }
 
 // PrintTables - print all tables suitable for backup
-func PrintTables(cfg *config.Config, printAll bool) error {
+func PrintTables(cfg *config.Config, printAll bool, outputFormat string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
 	ch := &clickhouse.ClickHouse{
 		Config: &cfg.ClickHouse,
 	}
@@ -292,21 +425,86 @@ func PrintTables(cfg *config.Config, printAll bool) error {
 	if err != nil {
 		return err
 	}
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.DiscardEmptyColumns)
+	items := make([]TableListItem, 0, len(allTables))
 	for _, table := range allTables {
-		if table.Skip && !printAll {
-			continue
-		}
 		tableDisks := []string{}
 		for disk := range clickhouse.GetDisksByPaths(disks, table.DataPaths) {
 			tableDisks = append(tableDisks, disk)
 		}
-		if table.Skip {
-			fmt.Fprintf(w, "%s.%s\t%s\t%v\tskip\n", table.Database, table.Name, utils.FormatBytes(table.TotalBytes), strings.Join(tableDisks, ","))
-			continue
+		sort.Strings(tableDisks)
+		items = append(items, TableListItem{Database: table.Database, Table: table.Name, Engine: table.Engine, TotalBytes: table.TotalBytes, TotalRows: table.TotalRows, Disks: tableDisks, Skip: table.Skip})
+	}
+	return printTableListItems(items, printAll, outputFormat, true)
+}
+
+// PrintBackupTables - print tables stored in local or remote backup, size and disks are read from table metadata, skip shows match with current skip_tables
+func PrintBackupTables(ctx context.Context, cfg *config.Config, backupName string, remote, printAll bool, outputFormat string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	backup, err := openBackupWithTables(ctx, cfg, backupName, remote)
+	if err != nil {
+		return err
+	}
+	return printTableListItems(backupTableListItems(backup, cfg.ClickHouse.SkipTables), printAll, outputFormat, false)
+}
+
+func backupTableListItems(backup *backupWithTables, skipTables []string) []TableListItem {
+	items := make([]TableListItem, 0, len(backup.Tables))
+	for title, tm := range backup.Tables {
+		item := TableListItem{Database: title.Database, Table: title.Table, Engine: getEngineFromQuery(tm.Query), TotalBytes: uint64(tableMetadataSize(tm)), Disks: []string{}}
+		if item.TotalBytes == 0 {
+			item.TotalBytes = tm.TotalBytes
+		}
+		for disk, parts := range tm.Parts {
+			if len(parts) > 0 {
+				item.Disks = append(item.Disks, disk)
+			}
 		}
-		fmt.Fprintf(w, "%s.%s\t%s\t%v\t\n", table.Database, table.Name, utils.FormatBytes(table.TotalBytes), strings.Join(tableDisks, ","))
+		sort.Strings(item.Disks)
+		tableName := fmt.Sprintf("%s.%s", title.Database, title.Table)
+		for _, skipPattern := range skipTables {
+			if item.Skip, _ = filepath.Match(strings.Trim(skipPattern, " \t\r\n"), tableName); item.Skip {
+				break
+			}
+		}
+		items = append(items, item)
 	}
-	w.Flush()
-	return nil
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Database != items[j].Database {
+			return items[i].Database < items[j].Database
+		}
+		return items[i].Table < items[j].Table
+	})
+	return items
+}
+
+// printTableListItems - skipped tables are printed only with printAll, rows column is empty in text output when rows count is unknown
+func printTableListItems(items []TableListItem, printAll bool, outputFormat string, rowsKnown bool) error {
+	shown := make([]TableListItem, 0, len(items))
+	for _, item := range items {
+		if !item.Skip || printAll {
+			shown = append(shown, item)
+		}
+	}
+	if !isTextOutput(outputFormat) {
+		rows := make([][]string, len(shown))
+		for i := range shown {
+			rows[i] = shown[i].tsvRow()
+		}
+		return printStructured(os.Stdout, outputFormat, shown, rows)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.DiscardEmptyColumns)
+	for _, item := range shown {
+		totalRows := ""
+		if rowsKnown {
+			totalRows = fmt.Sprintf("%d rows", item.TotalRows)
+		}
+		skip := ""
+		if item.Skip {
+			skip = "skip"
+		}
+		fmt.Fprintf(w, "%s.%s\t%s\t%s\t%s\t%s\t%s\n", item.Database, item.Table, item.Engine, utils.FormatBytes(item.TotalBytes), totalRows, strings.Join(item.Disks, ","), skip)
+	}
+	return w.Flush()
 }
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// tableProjections - names of projections stored in parts of table, backups created before projections were stored in part metadata contain `<part>/<projection>.proj` parts
+func tableProjections(table metadata.TableMetadata) []string {
+	names := map[string]bool{}
+	for _, parts := range table.Parts {
+		for _, part := range parts {
+			for _, projection := range part.Projections {
+				names[projection] = true
+			}
+			if strings.HasSuffix(part.Name, ".proj") && strings.Contains(part.Name, "/") {
+				names[strings.TrimSuffix(part.Name[strings.LastIndex(part.Name, "/")+1:], ".proj")] = true
+			}
+		}
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+var projectionClauseRE = regexp.MustCompile("(?is)[(,]\\s*PROJECTION\\s+(?:\\w+|`[^`]+`)\\s*\\(\\s*SELECT\\s")
+
+// requireProjectionsSupport - fail before schema restore when tables with projections are restored to clickhouse-server without projections support
+func requireProjectionsSupport(ch *clickhouse.ClickHouse, tables ListOfTables) error {
+	var names []string
+	for _, t := range tables {
+		if len(tableProjections(t)) > 0 || projectionClauseRE.MatchString(t.Query) {
+			names = append(names, fmt.Sprintf("`%s`.`%s`", t.Database, t.Table))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return ch.RequireFeature(clickhouse.FeatureProjections, "tables "+strings.Join(names, ", "))
+}
+
+// missingProjections - projections from backup which are not materialized in all active parts of restored table
+func missingProjections(projections []string, activeParts uint64, projectionParts map[string]uint64) map[string]uint64 {
+	missing := map[string]uint64{}
+	for _, name := range projections {
+		if projectionParts[name] < activeParts {
+			missing[name] = activeParts - projectionParts[name]
+		}
+	}
+	return missing
+}
+
+// checkRestoredProjections - after attach check projections from backup exist in all active parts,
+// with `restore_rebuild_projections` missing projections are materialized
+func checkRestoredProjections(cfg *config.Config, ch *clickhouse.ClickHouse, table metadata.TableMetadata) error {
+	projections := tableProjections(table)
+	if len(projections) == 0 {
+		return nil
+	}
+	log := apexLog.WithField("table", table.Database+"."+table.Table)
+	if supported, err := ch.SupportsFeature(clickhouse.FeatureProjections); err != nil || !supported {
+		log.Warnf("clickhouse-server doesn't support projections, check skipped")
+		return nil
+	}
+	activeParts, projectionParts, err := ch.GetProjectionPartsCount(table.Database, table.Table)
+	if err != nil {
+		log.Warnf("can't check projections: %v", err)
+		return nil
+	}
+	missing := missingProjections(projections, activeParts, projectionParts)
+	for _, name := range projections {
+		count, isMissing := missing[name]
+		if !isMissing {
+			log.WithField("projection", name).Debug("projection is intact")
+			continue
+		}
+		if !cfg.General.RestoreRebuildProjections {
+			log.WithField("projection", name).Warnf("projection is absent in %d of %d parts, use `restore_rebuild_projections: true` or `ALTER TABLE ... MATERIALIZE PROJECTION` to rebuild it", count, activeParts)
+			continue
+		}
+		log.WithField("projection", name).Infof("projection is absent in %d of %d parts, materialize it", count, activeParts)
+		if err := ch.MaterializeProjection(table.Database, table.Table, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
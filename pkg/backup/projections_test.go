@@ -0,0 +1,30 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableProjections(t *testing.T) {
+	table := metadata.TableMetadata{
+		Parts: map[string][]metadata.Part{
+			"default": {{Name: "all_1_1_0", Projections: []string{"by_name", "agg"}}, {Name: "all_2_2_0", Projections: []string{"agg"}}},
+			"hdd":     {{Name: "all_3_3_0"}, {Name: "all_3_3_0/legacy.proj"}},
+		},
+	}
+	assert.Equal(t, []string{"agg", "by_name", "legacy"}, tableProjections(table))
+	assert.Empty(t, tableProjections(metadata.TableMetadata{Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}}))
+}
+
+func TestMissingProjections(t *testing.T) {
+	assert.Equal(t, map[string]uint64{"by_name": 3, "agg": 1}, missingProjections([]string{"agg", "by_name", "intact"}, 3, map[string]uint64{"agg": 2, "intact": 3}))
+	assert.Empty(t, missingProjections([]string{"agg"}, 0, map[string]uint64{}))
+}
+
+func TestProjectionClauseRE(t *testing.T) {
+	assert.True(t, projectionClauseRE.MatchString("CREATE TABLE db.t (id UInt64, name String, PROJECTION by_name (SELECT * ORDER BY name)) ENGINE = MergeTree ORDER BY id"))
+	assert.True(t, projectionClauseRE.MatchString("CREATE TABLE db.t (`id` UInt64,\n    PROJECTION p (SELECT count() GROUP BY id)) ENGINE = MergeTree ORDER BY id"))
+	assert.False(t, projectionClauseRE.MatchString("CREATE TABLE db.t (id UInt64, projection Array(String)) ENGINE = MergeTree ORDER BY projection"))
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// accessEntitiesFile - dump of RBAC objects inside `access` backup folder, it is not copied to access_data_path during restore
+const accessEntitiesFile = "access_entities.json"
+
+// dumpAccessEntities - save SHOW CREATE and SHOW GRANTS of RBAC objects from local and replicated access storages into rbacBackup folder
+func dumpAccessEntities(ch *clickhouse.ClickHouse, rbacBackup string) (uint64, error) {
+	entities, err := ch.GetAccessEntities()
+	if err != nil {
+		return 0, err
+	}
+	if len(entities) == 0 {
+		return 0, nil
+	}
+	content, err := json.MarshalIndent(entities, "", "\t")
+	if err != nil {
+		return 0, fmt.Errorf("can't marshal RBAC objects: %v", err)
+	}
+	if err := os.MkdirAll(rbacBackup, 0750); err != nil {
+		return 0, err
+	}
+	if err := metadata.WriteFileAtomic(path.Join(rbacBackup, accessEntitiesFile), content, 0640); err != nil {
+		return 0, err
+	}
+	apexLog.WithField("objects", len(entities)).Debug("RBAC objects dumped")
+	return uint64(len(content)), nil
+}
+
+// readAccessEntities - read RBAC objects dump from backupAccessPath, backups created before dump was added don't contain it
+func readAccessEntities(backupAccessPath string) ([]metadata.AccessEntity, error) {
+	content, err := ioutil.ReadFile(path.Join(backupAccessPath, accessEntitiesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entities []metadata.AccessEntity
+	if err := json.Unmarshal(content, &entities); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", accessEntitiesFile, err)
+	}
+	return entities, nil
+}
+
+// restoreAccessEntities - recreate RBAC objects of replicated access storage from dump, objects of local access storage are restored with files copy
+func restoreAccessEntities(ch *clickhouse.ClickHouse, backupAccessPath string) error {
+	entities, err := readAccessEntities(backupAccessPath)
+	if err != nil {
+		return err
+	}
+	createQueries, grantQueries := accessEntitiesQueries(entities)
+	if len(createQueries) == 0 {
+		return nil
+	}
+	execute := func(query string) error {
+		_, err := ch.Query(query)
+		return err
+	}
+	if err := executeWithRetries(createQueries, execute); err != nil {
+		return fmt.Errorf("can't create RBAC objects: %v", err)
+	}
+	if err := executeWithRetries(grantQueries, execute); err != nil {
+		return fmt.Errorf("can't grant RBAC privileges: %v", err)
+	}
+	apexLog.WithField("objects", len(createQueries)).Info("done restore RBAC objects")
+	return nil
+}
+
+// accessEntitiesQueries - CREATE ... OR REPLACE for replicated RBAC objects and grants for them, grants are executed after all objects are created
+func accessEntitiesQueries(entities []metadata.AccessEntity) ([]string, []string) {
+	var createQueries, grantQueries []string
+	for _, entity := range entities {
+		if !entity.Replicated {
+			continue
+		}
+		prefix := "CREATE " + entity.Type + " "
+		query := entity.CreateQuery
+		if strings.HasPrefix(query, prefix) {
+			query = prefix + "OR REPLACE " + strings.TrimPrefix(query, prefix)
+		}
+		createQueries = append(createQueries, query)
+		grantQueries = append(grantQueries, entity.Grants...)
+	}
+	return createQueries, grantQueries
+}
+
+// executeWithRetries - RBAC objects could reference each other in any order, so failed queries are executed again while at least one query succeeds
+func executeWithRetries(queries []string, execute func(query string) error) error {
+	for len(queries) > 0 {
+		var failed []string
+		var lastErr error
+		for _, query := range queries {
+			if err := execute(query); err != nil {
+				failed = append(failed, query)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(queries) {
+			return fmt.Errorf("%d queries failed, last error: %v", len(failed), lastErr)
+		}
+		queries = failed
+	}
+	return nil
+}
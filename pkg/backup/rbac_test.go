@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessEntitiesQueries(t *testing.T) {
+	entities := []metadata.AccessEntity{
+		{Type: "ROLE", Name: "`reader`", Replicated: true, CreateQuery: "CREATE ROLE reader", Grants: []string{"GRANT SELECT ON db.* TO reader"}},
+		{Type: "USER", Name: "`local_user`", CreateQuery: "CREATE USER local_user", Grants: []string{"GRANT reader TO local_user"}},
+		{Type: "USER", Name: "`app`", Replicated: true, CreateQuery: "CREATE USER app IDENTIFIED WITH sha256_hash BY 'x' SETTINGS PROFILE default", Grants: []string{"GRANT reader TO app"}},
+		{Type: "ROW POLICY", Name: "`p` ON `db`.`t`", Replicated: true, CreateQuery: "CREATE ROW POLICY p ON db.t FOR SELECT USING id > 0 TO app"},
+	}
+	createQueries, grantQueries := accessEntitiesQueries(entities)
+	assert.Equal(t, []string{
+		"CREATE ROLE OR REPLACE reader",
+		"CREATE USER OR REPLACE app IDENTIFIED WITH sha256_hash BY 'x' SETTINGS PROFILE default",
+		"CREATE ROW POLICY OR REPLACE p ON db.t FOR SELECT USING id > 0 TO app",
+	}, createQueries)
+	assert.Equal(t, []string{"GRANT SELECT ON db.* TO reader", "GRANT reader TO app"}, grantQueries)
+}
+
+func TestExecuteWithRetries(t *testing.T) {
+	created := map[string]bool{}
+	var executed []string
+	execute := func(query string) error {
+		executed = append(executed, query)
+		if query == "user" && !created["role"] {
+			return fmt.Errorf("role not found")
+		}
+		created[query] = true
+		return nil
+	}
+	assert.NoError(t, executeWithRetries([]string{"user", "role"}, execute))
+	assert.Equal(t, []string{"user", "role", "user"}, executed)
+
+	err := executeWithRetries([]string{"broken"}, func(query string) error { return fmt.Errorf("syntax error") })
+	assert.EqualError(t, err, "1 queries failed, last error: syntax error")
+}
+
+func TestReadAccessEntities(t *testing.T) {
+	dir := t.TempDir()
+	entities, err := readAccessEntities(dir)
+	assert.NoError(t, err)
+	assert.Nil(t, entities)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, accessEntitiesFile), []byte(`[{"type":"ROLE","name":"`+"`r`"+`","storage":"replicated","replicated":true,"create_query":"CREATE ROLE r"}]`), 0640))
+	entities, err = readAccessEntities(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []metadata.AccessEntity{{Type: "ROLE", Name: "`r`", Storage: "replicated", Replicated: true, CreateQuery: "CREATE ROLE r"}}, entities)
+}
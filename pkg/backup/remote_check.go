@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+)
+
+// remoteCheckPrefix - folder for probe objects, it is not a backup, so concurrent `list` could show it as broken until check is finished
+const remoteCheckPrefix = ".clickhouse-backup-check"
+
+// RemoteCheckStep - result of one probe request, Permission is what remote storage credentials shall allow for this request
+type RemoteCheckStep struct {
+	Step       string  `json:"step" yaml:"step"`
+	Permission string  `json:"permission" yaml:"permission"`
+	Status     string  `json:"status" yaml:"status"`
+	Duration   float64 `json:"duration_seconds" yaml:"duration_seconds"`
+	Bytes      int64   `json:"bytes" yaml:"bytes"`
+	Throughput float64 `json:"throughput_bytes_per_second" yaml:"throughput_bytes_per_second"`
+	Error      string  `json:"error" yaml:"error"`
+}
+
+// RemoteCheckReport - stable `remote-check --format` schema, tsv contains one row per step after summary row
+type RemoteCheckReport struct {
+	Storage string            `json:"storage" yaml:"storage"`
+	Key     string            `json:"key" yaml:"key"`
+	Status  string            `json:"status" yaml:"status"`
+	Steps   []RemoteCheckStep `json:"steps" yaml:"steps"`
+}
+
+func (r RemoteCheckReport) tsvRows() [][]string {
+	rows := [][]string{{r.Storage, r.Key, r.Status}}
+	for _, s := range r.Steps {
+		rows = append(rows, []string{s.Step, s.Permission, s.Status, fmt.Sprintf("%.3f", s.Duration), fmt.Sprint(s.Bytes), fmt.Sprintf("%.0f", s.Throughput), s.Error})
+	}
+	return rows
+}
+
+func (r RemoteCheckReport) failed() int {
+	failed := 0
+	for _, s := range r.Steps {
+		if s.Status == "failed" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// remoteChecker - run probe steps one by one, step is skipped when the step it depends on is failed
+type remoteChecker struct {
+	report *RemoteCheckReport
+	failed map[string]bool
+}
+
+func (c *remoteChecker) run(step, permission string, dependsOn []string, fn func() (int64, error)) {
+	result := RemoteCheckStep{Step: step, Permission: permission, Status: "ok"}
+	for _, d := range dependsOn {
+		if c.failed[d] {
+			result.Status = "skipped"
+			result.Error = fmt.Sprintf("%s failed", d)
+			c.failed[step] = true
+			c.report.Steps = append(c.report.Steps, result)
+			return
+		}
+	}
+	start := time.Now()
+	size, err := fn()
+	duration := time.Since(start)
+	result.Duration = duration.Seconds()
+	result.Bytes = size
+	if size > 0 && duration > 0 {
+		result.Throughput = float64(size) / duration.Seconds()
+	}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		c.failed[step] = true
+	}
+	c.report.Steps = append(c.report.Steps, result)
+}
+
+// RemoteCheck - connect to remote storage and write, stat, read, list and delete probe object, report latency and throughput of each request
+func (b *Backuper) RemoteCheck(ctx context.Context, probeSize int64, outputFormat string) (err error) {
+	ctx, span := tracing.Start(ctx, "remote_check")
+	defer func() { tracing.End(span, err) }()
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	if b.cfg.General.RemoteStorage == "none" {
+		return fmt.Errorf("remote storage is 'none'")
+	}
+	if probeSize < 0 {
+		return fmt.Errorf("probe size %d shall be positive", probeSize)
+	}
+	if b.dst, err = new_storage.NewBackupDestination(b.cfg, false); err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	key := path.Join(remoteCheckPrefix, fmt.Sprintf("%s-%d", hostname, time.Now().UnixNano()))
+	report := b.remoteCheck(ctx, key, probeSize)
+	if isTextOutput(outputFormat) {
+		if err := printRemoteCheck(os.Stdout, report); err != nil {
+			return err
+		}
+	} else if err := printStructured(os.Stdout, outputFormat, report, report.tsvRows()); err != nil {
+		return err
+	}
+	if failed := report.failed(); failed > 0 {
+		return fmt.Errorf("%s remote storage check failed, %d of %d steps are failed", report.Storage, failed, len(report.Steps))
+	}
+	apexLog.WithField("storage", report.Storage).Info("remote storage check done")
+	return nil
+}
+
+// remoteCheck - probe object is deleted even when read or list are failed
+func (b *Backuper) remoteCheck(ctx context.Context, key string, probeSize int64) RemoteCheckReport {
+	report := RemoteCheckReport{Storage: b.dst.Kind(), Key: key, Status: "ok"}
+	c := &remoteChecker{report: &report, failed: map[string]bool{}}
+	var written []byte
+	c.run("connect", "connect", nil, func() (int64, error) {
+		return 0, b.dst.Connect(ctx)
+	})
+	c.run("write", "write", []string{"connect"}, func() (int64, error) {
+		hash := md5.New()
+		probe := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(time.Now().UnixNano())), probeSize), hash)
+		if err := b.dst.PutFile(ctx, key, ioutil.NopCloser(probe)); err != nil {
+			return 0, err
+		}
+		written = hash.Sum(nil)
+		return probeSize, nil
+	})
+	c.run("stat", "read metadata", []string{"write"}, func() (int64, error) {
+		f, err := b.dst.StatFile(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if f.Size() != probeSize {
+			return 0, fmt.Errorf("size is %d, expected %d", f.Size(), probeSize)
+		}
+		return 0, nil
+	})
+	c.run("read", "read", []string{"write"}, func() (int64, error) {
+		reader, err := b.dst.GetFileReader(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		hash := md5.New()
+		size, err := io.Copy(hash, reader)
+		if closeErr := reader.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return size, err
+		}
+		if !bytes.Equal(hash.Sum(nil), written) {
+			return size, fmt.Errorf("read content is different from written, %d of %d bytes read", size, probeSize)
+		}
+		return size, nil
+	})
+	c.run("list", "list", []string{"write"}, func() (int64, error) {
+		found := false
+		name := path.Base(key)
+		err := b.dst.Walk(ctx, path.Dir(key)+"/", true, func(f new_storage.RemoteFile) error {
+			if strings.Trim(f.Name(), "/") == name {
+				found = true
+			}
+			return nil
+		})
+		if err == nil && !found {
+			err = fmt.Errorf("%s is not listed", key)
+		}
+		return 0, err
+	})
+	c.run("delete", "delete", []string{"write"}, func() (int64, error) {
+		return 0, b.dst.DeleteFile(ctx, key)
+	})
+	c.run("check deleted", "read metadata", []string{"delete"}, func() (int64, error) {
+		_, err := b.dst.StatFile(ctx, key)
+		if err == nil {
+			return 0, fmt.Errorf("%s still exists after delete", key)
+		}
+		if errors.Is(err, new_storage.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	})
+	if report.failed() > 0 {
+		report.Status = "failed"
+	}
+	return report
+}
+
+func printRemoteCheck(w io.Writer, report RemoteCheckReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range report.Steps {
+		throughput := ""
+		if s.Throughput > 0 {
+			throughput = utils.FormatBytes(uint64(s.Throughput)) + "/s"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Step, s.Status, utils.HumanizeDuration(time.Duration(s.Duration*float64(time.Second))), throughput, s.Permission, s.Error); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", report.Storage, report.Status, report.Key); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryRemoteStorage - keep written objects in memory, operations from denied fail like requests without permission
+type memoryRemoteStorage struct {
+	fakeRemoteStorage
+	objects map[string][]byte
+	denied  map[string]bool
+}
+
+func (s *memoryRemoteStorage) deny(operation string) error {
+	if s.denied[operation] {
+		return fmt.Errorf("%s: access denied", operation)
+	}
+	return nil
+}
+
+func (s *memoryRemoteStorage) StatFile(_ context.Context, key string) (new_storage.RemoteFile, error) {
+	if err := s.deny("StatFile"); err != nil {
+		return nil, err
+	}
+	if body, exists := s.objects[key]; exists {
+		return fakeRemoteFile{name: key, size: int64(len(body))}, nil
+	}
+	return nil, new_storage.ErrNotFound
+}
+
+func (s *memoryRemoteStorage) PutFile(_ context.Context, key string, r io.ReadCloser) error {
+	if err := s.deny("PutFile"); err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = body
+	return nil
+}
+
+func (s *memoryRemoteStorage) GetFileReader(_ context.Context, key string) (io.ReadCloser, error) {
+	if err := s.deny("GetFileReader"); err != nil {
+		return nil, err
+	}
+	if body, exists := s.objects[key]; exists {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil, new_storage.ErrNotFound
+}
+
+func (s *memoryRemoteStorage) Walk(_ context.Context, prefix string, _ bool, fn func(new_storage.RemoteFile) error) error {
+	if err := s.deny("Walk"); err != nil {
+		return err
+	}
+	for key, body := range s.objects {
+		if strings.HasPrefix(key, strings.TrimPrefix(prefix, "/")) {
+			if err := fn(fakeRemoteFile{name: strings.TrimPrefix(key, prefix), size: int64(len(body))}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryRemoteStorage) DeleteFile(_ context.Context, key string) error {
+	if err := s.deny("DeleteFile"); err != nil {
+		return err
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func TestRemoteCheck(t *testing.T) {
+	key := path.Join(remoteCheckPrefix, "host-1")
+	stepStatuses := func(report RemoteCheckReport) map[string]string {
+		statuses := map[string]string{}
+		for _, s := range report.Steps {
+			statuses[s.Step] = s.Status
+		}
+		return statuses
+	}
+	testCases := []struct {
+		name     string
+		denied   string
+		status   string
+		statuses map[string]string
+	}{
+		{name: "all allowed", status: "ok", statuses: map[string]string{"connect": "ok", "write": "ok", "stat": "ok", "read": "ok", "list": "ok", "delete": "ok", "check deleted": "ok"}},
+		{name: "write denied", denied: "PutFile", status: "failed", statuses: map[string]string{"connect": "ok", "write": "failed", "stat": "skipped", "read": "skipped", "list": "skipped", "delete": "skipped", "check deleted": "skipped"}},
+		{name: "read denied", denied: "GetFileReader", status: "failed", statuses: map[string]string{"connect": "ok", "write": "ok", "stat": "ok", "read": "failed", "list": "ok", "delete": "ok", "check deleted": "ok"}},
+		{name: "list denied", denied: "Walk", status: "failed", statuses: map[string]string{"connect": "ok", "write": "ok", "stat": "ok", "read": "ok", "list": "failed", "delete": "ok", "check deleted": "ok"}},
+		{name: "delete denied", denied: "DeleteFile", status: "failed", statuses: map[string]string{"connect": "ok", "write": "ok", "stat": "ok", "read": "ok", "list": "ok", "delete": "failed", "check deleted": "skipped"}},
+	}
+	for _, tc := range testCases {
+		storage := &memoryRemoteStorage{fakeRemoteStorage: fakeRemoteStorage{kind: "S3"}, objects: map[string][]byte{}, denied: map[string]bool{tc.denied: true}}
+		b := &Backuper{cfg: config.DefaultConfig(), dst: &new_storage.BackupDestination{RemoteStorage: storage}}
+		report := b.remoteCheck(context.Background(), key, 100*1024)
+		assert.Equal(t, tc.status, report.Status, tc.name)
+		assert.Equal(t, tc.statuses, stepStatuses(report), tc.name)
+		if tc.denied != "DeleteFile" {
+			assert.Empty(t, storage.objects, tc.name)
+		}
+	}
+}
+
+func TestRemoteCheckReportTSV(t *testing.T) {
+	report := RemoteCheckReport{Storage: "S3", Key: "k", Status: "failed", Steps: []RemoteCheckStep{
+		{Step: "write", Permission: "write", Status: "failed", Error: "access denied"},
+	}}
+	assert.Equal(t, [][]string{{"S3", "k", "failed"}, {"write", "write", "failed", "0.000", "0", "0", "access denied"}}, report.tsvRows())
+	assert.Equal(t, 1, report.failed())
+}
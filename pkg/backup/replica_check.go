@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+var degradedReplicasPollInterval = 5 * time.Second
+
+// checkReplicas - read-only and session expired replicas of tables are reported according to `check_replicas`,
+// backup of read-only replica contains stale data, attach to read-only replica fails
+func checkReplicas(ctx context.Context, ch *clickhouse.ClickHouse, tables map[metadata.TableTitle]bool, log *apexLog.Entry) error {
+	if ch.Config.CheckReplicas == "skip" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(ch.Config.CheckReplicasTimeout)
+	if err != nil && ch.Config.CheckReplicas == "wait" {
+		return err
+	}
+	return waitHealthyReplicas(ctx, ch.Config.CheckReplicas, func() ([]string, error) {
+		replicas, err := ch.GetDegradedReplicas()
+		if err != nil {
+			return nil, err
+		}
+		return degradedReplicasProblems(replicas, tables), nil
+	}, timeout, degradedReplicasPollInterval, log)
+}
+
+// degradedReplicasProblems - description of degraded replicas for tables only
+func degradedReplicasProblems(replicas []clickhouse.DegradedReplica, tables map[metadata.TableTitle]bool) []string {
+	var problems []string
+	for _, r := range replicas {
+		if !tables[metadata.TableTitle{Database: r.Database, Table: r.Table}] {
+			continue
+		}
+		var reasons []string
+		if r.IsReadonly > 0 {
+			reasons = append(reasons, "read-only")
+		}
+		if r.IsSessionExpired > 0 {
+			reasons = append(reasons, "ZooKeeper session expired")
+		}
+		problems = append(problems, fmt.Sprintf("`%s`.`%s` is %s", r.Database, r.Table, strings.Join(reasons, ", ")))
+	}
+	return problems
+}
+
+// waitHealthyReplicas - `warn` logs problems, `fail` returns error, `wait` polls degraded until problems disappear or timeout is exceeded
+func waitHealthyReplicas(ctx context.Context, mode string, degraded func() ([]string, error), timeout, interval time.Duration, log *apexLog.Entry) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		problems, err := degraded()
+		if err != nil {
+			log.Warnf("can't check system.replicas: %v", err)
+			return nil
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		switch mode {
+		case "warn":
+			for _, problem := range problems {
+				log.Warnf("%s, data could be stale", problem)
+			}
+			return nil
+		case "wait":
+			if time.Now().Add(interval).Before(deadline) {
+				log.Infof("wait replicas recovery: %s", strings.Join(problems, "; "))
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(interval):
+				}
+				continue
+			}
+			return fmt.Errorf("replicas are not recovered after %s: %s", timeout, strings.Join(problems, "; "))
+		}
+		return fmt.Errorf("degraded replicas found, use `check_replicas: warn` to ignore: %s", strings.Join(problems, "; "))
+	}
+}
@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradedReplicasProblems(t *testing.T) {
+	replicas := []clickhouse.DegradedReplica{
+		{Database: "db", Table: "t1", IsReadonly: 1},
+		{Database: "db", Table: "t2", IsReadonly: 1, IsSessionExpired: 1},
+		{Database: "other", Table: "t1", IsReadonly: 1},
+	}
+	tables := map[metadata.TableTitle]bool{{Database: "db", Table: "t1"}: true, {Database: "db", Table: "t2"}: true}
+	assert.Equal(t, []string{"`db`.`t1` is read-only", "`db`.`t2` is read-only, ZooKeeper session expired"}, degradedReplicasProblems(replicas, tables))
+	assert.Empty(t, degradedReplicasProblems(replicas, map[metadata.TableTitle]bool{}))
+}
+
+func TestWaitHealthyReplicas(t *testing.T) {
+	log := apexLog.WithField("test", "TestWaitHealthyReplicas")
+	degraded := func() ([]string, error) { return []string{"`db`.`t1` is read-only"}, nil }
+	assert.NoError(t, waitHealthyReplicas(context.Background(), "warn", degraded, time.Minute, time.Millisecond, log))
+	assert.EqualError(t, waitHealthyReplicas(context.Background(), "fail", degraded, time.Minute, time.Millisecond, log), "degraded replicas found, use `check_replicas: warn` to ignore: `db`.`t1` is read-only")
+	assert.EqualError(t, waitHealthyReplicas(context.Background(), "wait", degraded, 5*time.Millisecond, time.Millisecond, log), "replicas are not recovered after 5ms: `db`.`t1` is read-only")
+	assert.NoError(t, waitHealthyReplicas(context.Background(), "fail", func() ([]string, error) { return nil, fmt.Errorf("code: 60") }, time.Minute, time.Millisecond, log))
+
+	calls := 0
+	recovering := func() ([]string, error) {
+		calls++
+		if calls < 3 {
+			return degraded()
+		}
+		return nil, nil
+	}
+	assert.NoError(t, waitHealthyReplicas(context.Background(), "wait", recovering, time.Minute, time.Millisecond, log))
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+// BackupSkippedError - another replica of the same shard won `single_replica_backup` election and creates backup
+type BackupSkippedError struct {
+	BackupName string
+	Replica    string
+}
+
+func (e *BackupSkippedError) Error() string {
+	return fmt.Sprintf("'%s' skipped, replica %s backed up", e.BackupName, e.Replica)
+}
+
+// electBackupReplica - each replica creates `<single_replica_backup_path>/<backup_name>/<replica>` znode, replica with the earliest created znode makes backup,
+// so all replicas of shard shall run backup with the same name, replica name is `{replica}` macro or hostname
+func electBackupReplica(cfg *config.Config, ch *clickhouse.ClickHouse, backupName string) (string, bool, error) {
+	macros, err := ch.GetMacros()
+	if err != nil {
+		return "", false, fmt.Errorf("can't get system.macros: %v", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", false, err
+	}
+	replica := macros["replica"]
+	if replica == "" {
+		replica = hostname
+	}
+	replaces := make([]string, 0, len(macros)*2)
+	for name, substitution := range macros {
+		replaces = append(replaces, fmt.Sprintf("{%s}", name), substitution)
+	}
+	electionPath := path.Join(strings.NewReplacer(replaces...).Replace(cfg.General.SingleReplicaBackupPath), backupName)
+	if err := ch.CreateKeeperNode(path.Join(electionPath, replica), hostname); err != nil {
+		return "", false, err
+	}
+	candidates, err := ch.GetKeeperChildren(electionPath)
+	if err != nil {
+		return "", false, err
+	}
+	winner := firstCreatedNode(candidates)
+	return winner, winner == replica, nil
+}
+
+// firstCreatedNode - name of znode with the lowest czxid, Keeper orders all znode creations
+func firstCreatedNode(nodes []clickhouse.KeeperNode) string {
+	first := -1
+	for i, node := range nodes {
+		if first == -1 || node.Czxid < nodes[first].Czxid {
+			first = i
+		}
+	}
+	if first == -1 {
+		return ""
+	}
+	return nodes[first].Name
+}
+
+// IsBackupSkipped - true when err means another replica creates backup, callers shall report it as skipped operation, not as failure
+func IsBackupSkipped(err error) bool {
+	var skipped *BackupSkippedError
+	return errors.As(err, &skipped)
+}
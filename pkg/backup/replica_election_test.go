@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstCreatedNode(t *testing.T) {
+	assert.Equal(t, "", firstCreatedNode(nil))
+	assert.Equal(t, "ch-2", firstCreatedNode([]clickhouse.KeeperNode{
+		{Name: "ch-1", Czxid: 4294967311},
+		{Name: "ch-2", Czxid: 4294967302},
+		{Name: "ch-3", Czxid: 4294967320},
+	}))
+}
+
+func TestIsBackupSkipped(t *testing.T) {
+	err := &BackupSkippedError{BackupName: "daily", Replica: "ch-2"}
+	assert.True(t, IsBackupSkipped(err))
+	assert.True(t, IsBackupSkipped(fmt.Errorf("create_remote: %w", err)))
+	assert.Equal(t, "'daily' skipped, replica ch-2 backed up", err.Error())
+	assert.False(t, IsBackupSkipped(fmt.Errorf("can't connect to clickhouse")))
+	assert.False(t, IsBackupSkipped(nil))
+}
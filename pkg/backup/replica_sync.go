@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+)
+
+var replicationQueuePollInterval = 5 * time.Second
+
+// replicaSyncStatus - result of replication sync for one restored table
+type replicaSyncStatus struct {
+	Table    metadata.TableTitle
+	Synced   bool
+	Duration time.Duration
+	Err      error
+}
+
+// syncRestoredReplicas - with `restore_sync_replicas` run SYSTEM SYNC REPLICA for restored Replicated tables and wait until replication_queue is empty,
+// tables are synced one by one after all parts are attached, so replicas fetch parts of all tables meanwhile
+func syncRestoredReplicas(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, tables []metadata.TableTitle, dstTablesMap map[metadata.TableTitle]clickhouse.Table, log *apexLog.Entry) error {
+	if !cfg.General.RestoreSyncReplicas {
+		return nil
+	}
+	timeout, err := time.ParseDuration(cfg.General.RestoreSyncReplicasTimeout)
+	if err != nil {
+		return err
+	}
+	var statuses []replicaSyncStatus
+	for _, title := range tables {
+		if !strings.HasPrefix(dstTablesMap[title].Engine, "Replicated") {
+			continue
+		}
+		log := log.WithField("table", fmt.Sprintf("%s.%s", title.Database, title.Table))
+		start := time.Now()
+		status := replicaSyncStatus{Table: title}
+		if status.Err = ch.SyncReplica(title.Database, title.Table); status.Err == nil {
+			status.Err = waitReplicationQueue(ctx, func() (uint64, error) {
+				return ch.GetReplicationQueueSize(title.Database, title.Table)
+			}, timeout, replicationQueuePollInterval, log)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		status.Synced = status.Err == nil
+		status.Duration = time.Since(start)
+		if status.Synced {
+			log.WithField("duration", utils.LogDuration(status.Duration)).Info("replica synced")
+		} else {
+			log.WithField("duration", utils.LogDuration(status.Duration)).Errorf("replica not synced: %v", status.Err)
+		}
+		statuses = append(statuses, status)
+	}
+	return replicaSyncError(statuses)
+}
+
+// waitReplicationQueue - poll size of replication queue until it is empty, progress is logged on each poll
+func waitReplicationQueue(ctx context.Context, size func() (uint64, error), timeout, interval time.Duration, log *apexLog.Entry) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		queueSize, err := size()
+		if err != nil {
+			return fmt.Errorf("can't get replication_queue: %v", err)
+		}
+		if queueSize == 0 {
+			return nil
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			return fmt.Errorf("%d entries left in replication_queue after %s", queueSize, timeout)
+		}
+		log.Infof("wait %d entries of replication_queue", queueSize)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// replicaSyncError - summary of not synced tables, nil when all tables are synced
+func replicaSyncError(statuses []replicaSyncStatus) error {
+	var problems []string
+	for _, s := range statuses {
+		if !s.Synced {
+			problems = append(problems, fmt.Sprintf("`%s`.`%s`: %v", s.Table.Database, s.Table.Table, s.Err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d replicated tables are not synced after restore: %s", len(problems), len(statuses), strings.Join(problems, "; "))
+}
@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitReplicationQueue(t *testing.T) {
+	log := apexLog.WithField("test", "TestWaitReplicationQueue")
+	sizes := []uint64{3, 1, 0}
+	calls := 0
+	size := func() (uint64, error) {
+		s := sizes[calls]
+		calls++
+		return s, nil
+	}
+	assert.NoError(t, waitReplicationQueue(context.Background(), size, time.Minute, time.Millisecond, log))
+	assert.Equal(t, 3, calls)
+
+	stuck := func() (uint64, error) { return 2, nil }
+	assert.EqualError(t, waitReplicationQueue(context.Background(), stuck, 5*time.Millisecond, time.Millisecond, log), "2 entries left in replication_queue after 5ms")
+	assert.Error(t, waitReplicationQueue(context.Background(), func() (uint64, error) { return 0, fmt.Errorf("code: 60") }, time.Minute, time.Millisecond, log))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, waitReplicationQueue(ctx, stuck, time.Minute, time.Millisecond, log), context.Canceled)
+}
+
+func TestReplicaSyncError(t *testing.T) {
+	assert.NoError(t, replicaSyncError(nil))
+	assert.NoError(t, replicaSyncError([]replicaSyncStatus{{Table: metadata.TableTitle{Database: "db", Table: "t1"}, Synced: true}}))
+	err := replicaSyncError([]replicaSyncStatus{
+		{Table: metadata.TableTitle{Database: "db", Table: "t1"}, Synced: true},
+		{Table: metadata.TableTitle{Database: "db", Table: "t2"}, Err: fmt.Errorf("1 entries left in replication_queue after 1s")},
+	})
+	assert.EqualError(t, err, "1 of 2 replicated tables are not synced after restore: `db`.`t2`: 1 entries left in replication_queue after 1s")
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+var engineMacroRE = regexp.MustCompile(`\{(\w+)\}`)
+
+// replicasByTable - system.replicas of backup host by table, empty when replicas can't be read, backup doesn't fail without topology
+func replicasByTable(replicas []clickhouse.ReplicaInfo) map[metadata.TableTitle]clickhouse.ReplicaInfo {
+	result := make(map[metadata.TableTitle]clickhouse.ReplicaInfo, len(replicas))
+	for _, replica := range replicas {
+		result[metadata.TableTitle{Database: replica.Database, Table: replica.Table}] = replica
+	}
+	return result
+}
+
+// tableReplication - expanded paths from system.replicas, engine arguments with macros from query and values of these macros on backup host,
+// engine arguments are empty when table use default_replica_path and default_replica_name of server config
+func tableReplication(query string, replica clickhouse.ReplicaInfo, macros map[string]string) *metadata.TableReplication {
+	replication := &metadata.TableReplication{
+		ZooKeeperPath: replica.ZooKeeperPath,
+		ReplicaName:   replica.ReplicaName,
+		ReplicaPath:   replica.ReplicaPath,
+	}
+	if loc := mergeTreeEngineRE.FindStringSubmatchIndex(query); loc != nil {
+		if args, _, err := splitEngineArguments(query, loc[1]); err == nil && len(args) >= 2 && isStringLiteral(args[0]) && isStringLiteral(args[1]) {
+			replication.EngineZooKeeperPath, replication.EngineReplicaName = unquoteStringLiteral(args[0]), unquoteStringLiteral(args[1])
+		}
+	}
+	usedMacros := map[string]string{}
+	for _, name := range []string{"shard", "replica"} {
+		if value, exists := macros[name]; exists {
+			usedMacros[name] = value
+		}
+	}
+	for _, match := range engineMacroRE.FindAllStringSubmatch(replication.EngineZooKeeperPath+"/"+replication.EngineReplicaName, -1) {
+		if value, exists := macros[match[1]]; exists {
+			usedMacros[match[1]] = value
+		}
+	}
+	if len(usedMacros) > 0 {
+		replication.Macros = usedMacros
+	}
+	return replication
+}
+
+// replicationPathProblems - restored replicated tables which get replica path already used by another table of destination server or by another restored table,
+// paths are expanded with macros of destination server, tables with default engine arguments, `{uuid}` or unknown macros are not checked
+func replicationPathProblems(tables ListOfTables, macros map[string]string, replicas []clickhouse.ReplicaInfo) []string {
+	used := make(map[string]metadata.TableTitle, len(replicas))
+	for _, replica := range replicas {
+		used[path.Join(replica.ZooKeeperPath, "replicas", replica.ReplicaName)] = metadata.TableTitle{Database: replica.Database, Table: replica.Table}
+	}
+	var problems []string
+	for _, t := range tables {
+		replicaPath, ok := expandedReplicaPath(t.Query, t.Database, t.Table, macros)
+		if !ok {
+			continue
+		}
+		title := metadata.TableTitle{Database: t.Database, Table: t.Table}
+		owner, exists := used[replicaPath]
+		if !exists {
+			used[replicaPath] = title
+			continue
+		}
+		// existing destination table is reported separately or dropped before restore
+		if owner == title {
+			continue
+		}
+		msg := fmt.Sprintf("`%s`.`%s` replica path %s is already used by `%s`.`%s`", t.Database, t.Table, replicaPath, owner.Database, owner.Table)
+		if t.Replication != nil && t.Replication.ReplicaPath == replicaPath {
+			msg += ", path is the same as on backup host, use restore_replicated_macros or restore_macros_override"
+		}
+		problems = append(problems, msg)
+	}
+	return problems
+}
+
+// expandedReplicaPath - `<zookeeper_path>/replicas/<replica_name>` from engine arguments of Replicated*MergeTree as clickhouse-server expands them
+func expandedReplicaPath(query, database, table string, macros map[string]string) (string, bool) {
+	loc := mergeTreeEngineRE.FindStringSubmatchIndex(query)
+	if loc == nil || !strings.HasPrefix(strings.ToLower(query[loc[2]:loc[3]]), "replicated") {
+		return "", false
+	}
+	args, _, err := splitEngineArguments(query, loc[1])
+	if err != nil || len(args) < 2 || !isStringLiteral(args[0]) || !isStringLiteral(args[1]) {
+		return "", false
+	}
+	replaces := []string{"{database}", database, "{table}", table}
+	for name, value := range macros {
+		replaces = append(replaces, "{"+name+"}", value)
+	}
+	replacer := strings.NewReplacer(replaces...)
+	zookeeperPath, replicaName := replacer.Replace(unquoteStringLiteral(args[0])), replacer.Replace(unquoteStringLiteral(args[1]))
+	if strings.Contains(zookeeperPath+replicaName, "{") {
+		return "", false
+	}
+	return path.Join(zookeeperPath, "replicas", replicaName), true
+}
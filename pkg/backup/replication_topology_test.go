@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableReplication(t *testing.T) {
+	replica := clickhouse.ReplicaInfo{
+		Database:      "db",
+		Table:         "t",
+		ZooKeeperPath: "/clickhouse/tables/01/db/t",
+		ReplicaName:   "ch-1",
+		ReplicaPath:   "/clickhouse/tables/01/db/t/replicas/ch-1",
+	}
+	macros := map[string]string{"shard": "01", "replica": "ch-1", "layer": "l1", "cluster": "c1"}
+	query := "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{layer}-{shard}/{database}/{table}', '{replica}') ORDER BY id"
+	assert.Equal(t, &metadata.TableReplication{
+		ZooKeeperPath:       "/clickhouse/tables/01/db/t",
+		ReplicaName:         "ch-1",
+		ReplicaPath:         "/clickhouse/tables/01/db/t/replicas/ch-1",
+		EngineZooKeeperPath: "/clickhouse/tables/{layer}-{shard}/{database}/{table}",
+		EngineReplicaName:   "{replica}",
+		Macros:              map[string]string{"shard": "01", "replica": "ch-1", "layer": "l1"},
+	}, tableReplication(query, replica, macros))
+
+	// default_replica_path and default_replica_name
+	replication := tableReplication("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id", replica, map[string]string{})
+	assert.Equal(t, "/clickhouse/tables/01/db/t", replication.ZooKeeperPath)
+	assert.Empty(t, replication.EngineZooKeeperPath)
+	assert.Nil(t, replication.Macros)
+}
+
+func TestReplicationPathProblems(t *testing.T) {
+	replicas := []clickhouse.ReplicaInfo{
+		{Database: "db", Table: "t", ZooKeeperPath: "/clickhouse/tables/01/db/t", ReplicaName: "ch-1"},
+	}
+	macros := map[string]string{"shard": "01", "replica": "ch-1"}
+	tables := ListOfTables{
+		// restored into another table with explicit path of source table
+		{
+			Database: "db", Table: "t_restored",
+			Query:       "CREATE TABLE db.t_restored (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+			Replication: &metadata.TableReplication{ReplicaPath: "/clickhouse/tables/01/db/t/replicas/ch-1"},
+		},
+		// the same table is reported as existing table
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}') ORDER BY id"},
+		{Database: "db", Table: "t2", Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/shared', '{replica}') ORDER BY id"},
+		{Database: "db2", Table: "t2", Query: "CREATE TABLE db2.t2 (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/shared', '{replica}') ORDER BY id"},
+		{Database: "db", Table: "uuid", Query: "CREATE TABLE db.uuid (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{uuid}/{shard}', '{replica}') ORDER BY id"},
+		{Database: "db", Table: "plain", Query: "CREATE TABLE db.plain (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	assert.Equal(t, []string{
+		"`db`.`t_restored` replica path /clickhouse/tables/01/db/t/replicas/ch-1 is already used by `db`.`t`, path is the same as on backup host, use restore_replicated_macros or restore_macros_override",
+		"`db2`.`t2` replica path /clickhouse/tables/01/db/shared/replicas/ch-1 is already used by `db`.`t2`",
+	}, replicationPathProblems(tables, macros, replicas))
+}
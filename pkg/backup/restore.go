@@ -10,6 +10,8 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
 	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
 	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
 	"github.com/mxalis/clickhouse-backup/pkg/utils"
 	apexLog "github.com/apex/log"
 	"github.com/otiai10/copy"
@@ -25,7 +28,9 @@ import (
 )
 
 // Restore - restore tables matched by tablePattern from backupName
-func Restore(cfg *config.Config, backupName string, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly bool) error {
+func Restore(ctx context.Context, cfg *config.Config, backupName string, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly, dryRun bool) (err error) {
+	ctx, span := tracing.Start(ctx, "restore", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
@@ -36,7 +41,7 @@ func Restore(cfg *config.Config, backupName string, tablePattern string, partiti
 		Config: &cfg.ClickHouse,
 	}
 	if backupName == "" {
-		_ = PrintLocalBackups(cfg, "all")
+		_ = PrintLocalBackups(cfg, "all", "text", nil)
 		return fmt.Errorf("select backup for restore")
 	}
 	if err := ch.Connect(); err != nil {
@@ -47,6 +52,9 @@ func Restore(cfg *config.Config, backupName string, tablePattern string, partiti
 	if err != nil {
 		return err
 	}
+	if dryRun {
+		return restoreDryRun(cfg, ch, backupName, tablePattern, partitions, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly, disks)
+	}
 	defaultDataPath, err := ch.GetDefaultPath(disks)
 	if err != nil {
 		return ErrUnknownClickhouseDataPath
@@ -58,18 +66,29 @@ func Restore(cfg *config.Config, backupName string, tablePattern string, partiti
 		if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
 			return err
 		}
+		if err := backupMetadata.CheckVersion(false); err != nil {
+			return fmt.Errorf("'%s': %v", backupName, err)
+		}
+		if doRestoreData && backupMetadata.NativeSize > 0 {
+			if err := ch.RequireFeature(clickhouse.FeatureNativeBackup, fmt.Sprintf("backup '%s' created with `backup_engine: native`", backupName)); err != nil {
+				return err
+			}
+		}
 		if schemaOnly || doRestoreData {
 			for _, database := range backupMetadata.Databases {
 				if !IsInformationSchema(database.Name) {
-					if err := ch.CreateDatabaseFromQuery(database.Query); err != nil {
+					if dstDatabase, isMapped := cfg.General.RestoreDatabaseMapping[database.Name]; isMapped {
+						if database.Query, err = renameCreateDatabaseQuery(database.Query, dstDatabase); err != nil {
+							return err
+						}
+					}
+					if err := ch.CreateDatabaseFromQuery(database.Query, cfg.General.RestoreSchemaOnCluster); err != nil {
 						return err
 					}
 				}
 			}
-			for _, function := range backupMetadata.Functions {
-				if err := ch.CreateUserDefinedFunction(function.Name, function.CreateQuery); err != nil {
-					return err
-				}
+			if err := restoreFunctions(cfg, ch, backupMetadata.Functions); err != nil {
+				return err
 			}
 		}
 		if len(backupMetadata.Tables) == 0 {
@@ -83,16 +102,21 @@ func Restore(cfg *config.Config, backupName string, tablePattern string, partiti
 	}
 	needRestart := false
 	if rbacOnly {
-		if err := restoreRBAC(ch, backupName, disks); err != nil {
+		rbacNeedRestart, err := restoreRBAC(ch, backupName, disks)
+		if err != nil {
 			return err
 		}
-		needRestart = true
+		needRestart = needRestart || rbacNeedRestart
 	}
 	if configsOnly {
 		if err := restoreConfigs(ch, backupName, disks); err != nil {
 			return err
 		}
-		needRestart = true
+		if isConfigRestoredInPlace(*ch.Config) {
+			needRestart = true
+		} else {
+			log.Infof("configs restored to %s, copy them to %s and restart clickhouse-server manually", configRestoreDir(*ch.Config), ch.Config.ConfigDir)
+		}
 	}
 
 	if needRestart {
@@ -115,13 +139,13 @@ func Restore(cfg *config.Config, backupName string, tablePattern string, partiti
 	}
 
 	if schemaOnly || (schemaOnly == dataOnly) {
-		if err := RestoreSchema(cfg, ch, backupName, tablePattern, dropTable, disks); err != nil {
+		if err := RestoreSchema(ctx, cfg, ch, backupName, tablePattern, dropTable, disks); err != nil {
 			return err
 		}
 	}
 	if dataOnly || (schemaOnly == dataOnly) {
 		partitionsToRestore := filesystemhelper.CreatePartitionsToBackupMap(partitions)
-		if err := RestoreData(cfg, ch, backupName, tablePattern, partitionsToRestore, disks); err != nil {
+		if err := RestoreData(ctx, cfg, ch, backupName, tablePattern, partitionsToRestore, disks); err != nil {
 			return err
 		}
 	}
@@ -129,42 +153,58 @@ func Restore(cfg *config.Config, backupName string, tablePattern string, partiti
 	return nil
 }
 
-// restoreRBAC - copy backup_name>/rbac folder to access_data_path
-func restoreRBAC(ch *clickhouse.ClickHouse, backupName string, disks []clickhouse.Disk) error {
+// restoreRBAC - copy backup_name>/access folder to access_data_path and recreate objects of replicated access storage,
+// restart of clickhouse-server is required when local access storage files are copied
+func restoreRBAC(ch *clickhouse.ClickHouse, backupName string, disks []clickhouse.Disk) (bool, error) {
 	accessPath, err := ch.GetAccessManagementPath(nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	defaultDataPath, err := ch.GetDefaultPath(disks)
+	if err != nil {
+		return false, ErrUnknownClickhouseDataPath
+	}
+	backupAccessPath := path.Join(defaultDataPath, "backup", backupName, "access")
+	if err := restoreAccessEntities(ch, backupAccessPath); err != nil {
+		return false, err
+	}
+	if localFiles, err := filepathx.Glob(path.Join(backupAccessPath, "*.sql")); err != nil || len(localFiles) == 0 {
+		return false, err
 	}
 	if err = restoreBackupRelatedDir(ch, backupName, "access", accessPath, disks); err == nil {
 		markFile := path.Join(accessPath, "need_rebuild_lists.mark")
 		apexLog.Infof("create %s for properly rebuild RBAC after restart clickhouse-server", markFile)
 		file, err := os.Create(markFile)
 		if err != nil {
-			return err
+			return false, err
 		}
 		_ = file.Close()
 		_ = filesystemhelper.Chown(markFile, ch, disks)
 		listFilesPattern := path.Join(accessPath, "*.list")
 		apexLog.Infof("remove %s for properly rebuild RBAC after restart clickhouse-server", listFilesPattern)
 		if listFiles, err := filepathx.Glob(listFilesPattern); err != nil {
-			return err
+			return false, err
 		} else {
 			for _, f := range listFiles {
 				if err := os.Remove(f); err != nil {
-					return err
+					return false, err
 				}
 			}
 		}
+		return true, nil
 	}
 	if !os.IsNotExist(err) {
-		return err
+		return false, err
 	}
-	return nil
+	return false, nil
 }
 
-// restoreConfigs - copy backup_name/configs folder to /etc/clickhouse-server/
+// restoreConfigs - copy backup_name/configs folder to `config_restore_dir` or `config_dir`
 func restoreConfigs(ch *clickhouse.ClickHouse, backupName string, disks []clickhouse.Disk) error {
-	if err := restoreBackupRelatedDir(ch, backupName, "configs", ch.Config.ConfigDir, disks); err != nil && os.IsNotExist(err) {
+	if defaultDataPath, err := ch.GetDefaultPath(disks); err == nil && hasRedactedConfigSecrets(path.Join(defaultDataPath, "backup", backupName, "configs")) {
+		apexLog.Warnf("%s contains redacted secrets in configs, replace `%s` values in %s before start clickhouse-server", backupName, redactedConfigValue, configRestoreDir(*ch.Config))
+	}
+	if err := restoreBackupRelatedDir(ch, backupName, "configs", configRestoreDir(*ch.Config), disks); err != nil && os.IsNotExist(err) {
 		return nil
 	} else {
 		return err
@@ -188,6 +228,8 @@ func restoreBackupRelatedDir(ch *clickhouse.ClickHouse, backupName, backupPrefix
 	apexLog.Debugf("copy %s -> %s", srcBackupDir, destinationDir)
 	copyOptions := copy.Options{OnDirExists: func(src, dest string) copy.DirExistsAction {
 		return copy.Merge
+	}, Skip: func(src string) (bool, error) {
+		return path.Base(src) == accessEntitiesFile, nil
 	}}
 	if err := copy.Copy(srcBackupDir, destinationDir, copyOptions); err != nil {
 		return err
@@ -207,7 +249,9 @@ func restoreBackupRelatedDir(ch *clickhouse.ClickHouse, backupName, backupPrefix
 }
 
 // RestoreSchema - restore schemas matched by tablePattern from backupName
-func RestoreSchema(cfg *config.Config, ch *clickhouse.ClickHouse, backupName string, tablePattern string, dropTable bool, disks []clickhouse.Disk) error {
+func RestoreSchema(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, backupName string, tablePattern string, dropTable bool, disks []clickhouse.Disk) (err error) {
+	_, span := tracing.Start(ctx, "restore_schema", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
@@ -236,11 +280,51 @@ func RestoreSchema(cfg *config.Config, ch *clickhouse.ClickHouse, backupName str
 	if err != nil {
 		return err
 	}
+	tablesForRestore = filterTablesByEngines(tablesForRestore, ch.Config.SkipTableEngines)
 	if len(tablesForRestore) == 0 {
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
+	if err = requireProjectionsSupport(ch, tablesForRestore); err != nil {
+		return err
+	}
+	backup, _, err := getLocalBackup(cfg, backupName, disks)
+	if err != nil {
+		return err
+	}
+	owners := innerTableOwners(tablesForRestore)
+	if tablesForRestore, err = applySchemaRewrites(cfg, tablesForRestore, backup.Macros); err != nil {
+		return err
+	}
+	serverPolicies, err := ch.GetStoragePolicies()
+	if err != nil {
+		return err
+	}
+	if problems := storagePolicyProblems(tablesForRestore, serverPolicies, backup.StoragePolicies); len(problems) > 0 {
+		return fmt.Errorf("can't restore schema: %s", strings.Join(problems, "; "))
+	}
+	databaseEngines, err := databaseEnginesForRestore(ch, tablesForRestore)
+	if err != nil {
+		return err
+	}
+	chTables, err := ch.GetTables("")
+	if err != nil {
+		return err
+	}
+	tablesForRestore = applyTableUUIDs(cfg, tablesForRestore, databaseEngines, existingTableUUIDs(chTables), dropTable)
+	if tablesForRestore, err = alignInnerTables(cfg, tablesForRestore, owners, databaseEngines); err != nil {
+		return err
+	}
+	tablesForRestore = sortTablesByDependencies(tablesForRestore)
+	if err = restoreDictionarySourceFiles(ch, tablesForRestore, userFilesPath(cfg, defaultDataPath), disks); err != nil {
+		return fmt.Errorf("can't restore dictionary source files: %v", err)
+	}
+	if cfg.General.RestoreSchemaOnCluster != "" {
+		defer func() {
+			logDistributedDDLSummary(cfg.General.RestoreSchemaOnCluster, ch.TakeDistributedDDLResults(), log)
+		}()
+	}
 
-	if dropErr := dropExistsTables(cfg, ch, tablesForRestore, version, log); dropErr != nil {
+	if dropErr := dropExistsTables(cfg, ch, reverseTables(tablesForRestore), version, log); dropErr != nil {
 		return dropErr
 	}
 
@@ -250,15 +334,61 @@ func RestoreSchema(cfg *config.Config, ch *clickhouse.ClickHouse, backupName str
 	return nil
 }
 
+// distributedDDLHostSummary - count of succeeded and failed `ON CLUSTER` queries on one host
+type distributedDDLHostSummary struct {
+	Host      string
+	Succeeded int
+	Failed    int
+	Errors    []string
+}
+
+func summarizeDistributedDDL(results []clickhouse.DistributedDDLResult) []distributedDDLHostSummary {
+	hosts := map[string]*distributedDDLHostSummary{}
+	var summary []distributedDDLHostSummary
+	for _, r := range results {
+		host := fmt.Sprintf("%s:%d", r.Host, r.Port)
+		if _, exists := hosts[host]; !exists {
+			hosts[host] = &distributedDDLHostSummary{Host: host}
+		}
+		if r.Status != 0 {
+			hosts[host].Failed++
+			hosts[host].Errors = append(hosts[host].Errors, r.Error)
+		} else {
+			hosts[host].Succeeded++
+		}
+	}
+	for _, h := range hosts {
+		summary = append(summary, *h)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Host < summary[j].Host })
+	return summary
+}
+
+// logDistributedDDLSummary - print per-host results of schema restore with `ON CLUSTER`
+func logDistributedDDLSummary(cluster string, results []clickhouse.DistributedDDLResult, log *apexLog.Entry) {
+	for _, h := range summarizeDistributedDDL(results) {
+		hostLog := log.WithFields(apexLog.Fields{"cluster": cluster, "host": h.Host, "succeeded": h.Succeeded, "failed": h.Failed})
+		if h.Failed > 0 {
+			hostLog.Errorf("schema restore on cluster host failed: %s", strings.Join(h.Errors, "; "))
+		} else {
+			hostLog.Info("schema restore on cluster host done")
+		}
+	}
+}
+
 func createTables(cfg *config.Config, ch *clickhouse.ClickHouse, tablesForRestore ListOfTables, version int, log *apexLog.Entry) error {
-	totalRetries := len(tablesForRestore)
+	totalRetries := schemaRetries(cfg, len(tablesForRestore))
 	restoreRetries := 0
 	var restoreErr error
+	var pausedConsumers map[metadata.TableTitle]bool
+	if cfg.General.RestorePauseStreamingTables {
+		pausedConsumers = streamingConsumers(tablesForRestore)
+	}
 	for restoreRetries < totalRetries {
 		var notRestoredTables ListOfTables
 		for _, schema := range tablesForRestore {
 			// if metadata.json doesn't contains "databases", we will re-create tables with default engine
-			if err := ch.CreateDatabase(schema.Database); err != nil {
+			if err := ch.CreateDatabase(schema.Database, cfg.General.RestoreSchemaOnCluster); err != nil {
 				return fmt.Errorf("can't create database '%s': %v", schema.Database, err)
 			}
 			//materialized and window views should restore via ATTACH
@@ -286,6 +416,13 @@ func createTables(cfg *config.Config, ch *clickhouse.ClickHouse, tablesForRestor
 					)
 				}
 				notRestoredTables = append(notRestoredTables, schema)
+				continue
+			}
+			if pausedConsumers[metadata.TableTitle{Database: schema.Database, Table: schema.Table}] {
+				if err := ch.DetachTablePermanently(clickhouse.Table{Database: schema.Database, Name: schema.Table}, cfg.General.RestoreSchemaOnCluster, version); err != nil {
+					return fmt.Errorf("can't detach materialized view `%s`.`%s` which reads from streaming table: %v", schema.Database, schema.Table, err)
+				}
+				log.Warnf("materialized view `%s`.`%s` reads from streaming table and is detached, execute ATTACH TABLE `%s`.`%s` to start consuming", schema.Database, schema.Table, schema.Database, schema.Table)
 			}
 		}
 		tablesForRestore = notRestoredTables
@@ -296,10 +433,18 @@ func createTables(cfg *config.Config, ch *clickhouse.ClickHouse, tablesForRestor
 	return nil
 }
 
+// schemaRetries - `restore_schema_retries` or count of tables, each failed query decrease retries
+func schemaRetries(cfg *config.Config, tablesCount int) int {
+	if cfg.General.RestoreSchemaRetries > 0 {
+		return int(cfg.General.RestoreSchemaRetries)
+	}
+	return tablesCount
+}
+
 func dropExistsTables(cfg *config.Config, ch *clickhouse.ClickHouse, tablesForDrop ListOfTables, version int, log *apexLog.Entry) error {
 	var dropErr error
 	dropRetries := 0
-	totalRetries := len(tablesForDrop)
+	totalRetries := schemaRetries(cfg, len(tablesForDrop))
 	for dropRetries < totalRetries {
 		var notDroppedTables ListOfTables
 		for _, schema := range tablesForDrop {
@@ -332,7 +477,9 @@ func dropExistsTables(cfg *config.Config, ch *clickhouse.ClickHouse, tablesForDr
 }
 
 // RestoreData - restore data for tables matched by tablePattern from backupName
-func RestoreData(cfg *config.Config, ch *clickhouse.ClickHouse, backupName string, tablePattern string, partitionsToRestore common.EmptyMap, disks []clickhouse.Disk) error {
+func RestoreData(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, backupName string, tablePattern string, partitionsToRestore common.EmptyMap, disks []clickhouse.Disk) (err error) {
+	ctx, span := tracing.Start(ctx, "restore_data", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
 	startRestore := time.Now()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
@@ -345,6 +492,9 @@ func RestoreData(cfg *config.Config, ch *clickhouse.ClickHouse, backupName strin
 	if clickhouse.IsClickhouseShadow(path.Join(defaultDataPath, "backup", backupName, "shadow")) {
 		return fmt.Errorf("backups created in v0.0.1 is not supported now")
 	}
+	if disks, err = applyRestoreDiskMapping(cfg, disks); err != nil {
+		return err
+	}
 	backup, _, err := getLocalBackup(cfg, backupName, disks)
 	if err != nil {
 		return fmt.Errorf("can't restore: %v", err)
@@ -359,11 +509,20 @@ func RestoreData(cfg *config.Config, ch *clickhouse.ClickHouse, backupName strin
 	if err != nil {
 		return err
 	}
+	tablesForRestore = filterTablesByEngines(tablesForRestore, ch.Config.SkipTableEngines)
 	if len(tablesForRestore) == 0 {
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
 	log.Debugf("found %d tables with data in backup", len(tablesForRestore))
-	chTables, err := ch.GetTables(tablePattern)
+	if problems := objectDiskProblems(tablesForRestore, disks, backup.DiskTypes); len(problems) > 0 {
+		return fmt.Errorf("can't restore data: %s", strings.Join(problems, "; "))
+	}
+	chTablePattern := tablePattern
+	if isRestoreMappingPresent(cfg) {
+		// destination tables could be renamed, so tablePattern is not applicable
+		chTablePattern = ""
+	}
+	chTables, err := ch.GetTables(chTablePattern)
 	if err != nil {
 		return err
 	}
@@ -401,38 +560,216 @@ func RestoreData(cfg *config.Config, ch *clickhouse.ClickHouse, backupName strin
 		}] = chTables[i]
 	}
 
+	innerDestinations := innerTableDestinations(cfg, tablesForRestore, dstTablesMap)
 	var missingTables []string
+	dstTables := map[metadata.TableTitle]bool{}
+	destinations := map[metadata.TableTitle]metadata.TableTitle{}
 	for _, restoreTable := range tablesForRestore {
-		found := false
-		for _, chTable := range chTables {
-			if (restoreTable.Database == chTable.Database) && (restoreTable.Table == chTable.Name) {
-				found = true
-				break
-			}
-		}
-		if !found {
-			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", restoreTable.Database, restoreTable.Table))
+		dstDatabase, dstTable := getDataRestoreDestination(cfg, innerDestinations, restoreTable.Database, restoreTable.Table)
+		destinations[metadata.TableTitle{Database: restoreTable.Database, Table: restoreTable.Table}] = metadata.TableTitle{Database: dstDatabase, Table: dstTable}
+		if _, found := dstTablesMap[metadata.TableTitle{Database: dstDatabase, Table: dstTable}]; !found {
+			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", dstDatabase, dstTable))
 		}
+		dstTables[metadata.TableTitle{Database: dstDatabase, Table: dstTable}] = true
 	}
 	if len(missingTables) > 0 {
 		return fmt.Errorf("%s is not created. Restore schema first or create missing tables manually", strings.Join(missingTables, ", "))
 	}
+	if err := checkReplicas(ctx, ch, dstTables, log); err != nil {
+		return err
+	}
+	if err := checkFreeSpace(ch, "restore", func(map[string]uint64) map[string]uint64 {
+		return requiredSpaceForRestore(tablesForRestore, destinations, dstTablesMap, disks)
+	}); err != nil {
+		return err
+	}
+	if nativeTables := nativeRestoreTables(cfg, tablesForRestore, innerDestinations, partitionsToRestore); len(nativeTables) > 0 {
+		log.Debugf("restore %d tables from native backup", len(nativeTables))
+		if err := restoreNativeData(cfg, ch, backupName, path.Join(defaultDataPath, "backup", backupName), nativeTables, disks); err != nil {
+			return fmt.Errorf("can't restore native backup: %v", err)
+		}
+	}
 
+	// disks absent in system.disks are added above with default path
+	diskToPath := map[string]string{}
+	for _, disk := range disks {
+		diskToPath[disk.Name] = disk.Path
+	}
+	restoredTables := make([]metadata.TableTitle, 0, len(tablesForRestore))
+	var validationProblems []string
 	for _, table := range tablesForRestore {
-		log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table))
+		dstTable := table
+		dstTable.Database, dstTable.Table = getDataRestoreDestination(cfg, innerDestinations, table.Database, table.Table)
+		restoredTables = append(restoredTables, metadata.TableTitle{Database: dstTable.Database, Table: dstTable.Table})
+		log := log.WithField("table", fmt.Sprintf("%s.%s", dstTable.Database, dstTable.Table))
+		if table.NativeBackup {
+			log.Info("done")
+			continue
+		}
+		if table.LogicalBackup {
+			if err := restoreLogicalData(cfg, ch, backupName, table, dstTable, disks); err != nil {
+				return fmt.Errorf("can't insert data into '%s.%s': %v", dstTable.Database, dstTable.Table, err)
+			}
+			log.Info("done")
+			continue
+		}
+		if cfg.General.FileChecksums {
+			if err := verifyTableChecksums(diskToPath, backupName, table, false); err != nil {
+				return err
+			}
+		}
 		dstTableDataPaths := dstTablesMap[metadata.TableTitle{
-			Database: table.Database,
-			Table:    table.Table}].DataPaths
-		if err := filesystemhelper.CopyDataToDetached(backupName, table, disks, dstTableDataPaths, ch); err != nil {
-			return fmt.Errorf("can't restore '%s.%s': %v", table.Database, table.Table, err)
+			Database: dstTable.Database,
+			Table:    dstTable.Table}].DataPaths
+		_, copySpan := tracing.Start(ctx, "copy", tracing.Table(dstTable.Database, dstTable.Table))
+		err := filesystemhelper.CopyDataToDetached(backupName, table, disks, dstTableDataPaths, ch, cfg.General.LinkMode)
+		tracing.End(copySpan, err)
+		if err != nil {
+			return fmt.Errorf("can't restore '%s.%s': %v", dstTable.Database, dstTable.Table, err)
 		}
 		log.Debugf("copied data to 'detached'")
-		if err := ch.AttachPartitions(table, disks); err != nil {
-			return fmt.Errorf("can't attach partitions for table '%s.%s': %v", table.Database, table.Table, err)
+		_, attachSpan := tracing.Start(ctx, "attach", tracing.Table(dstTable.Database, dstTable.Table))
+		err = ch.AttachPartitions(dstTable, disks)
+		tracing.End(attachSpan, err)
+		if err != nil {
+			return fmt.Errorf("can't attach partitions for table '%s.%s': %v", dstTable.Database, dstTable.Table, err)
 		}
 		log.Debugf("attached parts")
+		if err := checkRestoredProjections(cfg, ch, dstTable); err != nil {
+			return fmt.Errorf("can't rebuild projections for table '%s.%s': %v", dstTable.Database, dstTable.Table, err)
+		}
+		if cfg.General.RestoreValidate {
+			if err := validateRestoredRows(ch, table, dstTable, log); err != nil {
+				log.Errorf("validation failed: %v", err)
+				validationProblems = append(validationProblems, fmt.Sprintf("`%s`.`%s`: %v", dstTable.Database, dstTable.Table, err))
+			}
+		}
 		log.Info("done")
 	}
-	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestore))).Info("done")
+	if err := syncRestoredReplicas(ctx, cfg, ch, restoredTables, dstTablesMap, log); err != nil {
+		return err
+	}
+	if err := restoreValidationError(validationProblems, len(restoredTables)); err != nil {
+		return err
+	}
+	log.WithField("duration", utils.LogDuration(time.Since(startRestore))).Info("done")
 	return nil
 }
+
+var createObjectNameRE = regexp.MustCompile("(?is)^\\s*((?:CREATE|ATTACH)\\s+(?:TABLE|VIEW|MATERIALIZED\\s+VIEW|LIVE\\s+VIEW|WINDOW\\s+VIEW|DICTIONARY)\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?)(?:(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)\\.)?(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)(\\s+UUID\\s+'[^']+')?")
+var createDatabaseNameRE = regexp.MustCompile("(?is)^\\s*((?:CREATE|ATTACH)\\s+DATABASE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?)(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)(\\s+UUID\\s+'[^']+')?")
+
+func isRestoreMappingPresent(cfg *config.Config) bool {
+	return len(cfg.General.RestoreDatabaseMapping) > 0 || len(cfg.General.RestoreTableMapping) > 0
+}
+
+// getRestoreDestination - return database and table name which will be used for restore according to `restore_table_mapping` and `restore_database_mapping`
+func getRestoreDestination(cfg *config.Config, database, table string) (string, string) {
+	if dst, isMapped := cfg.General.RestoreTableMapping[database+"."+table]; isMapped {
+		if i := strings.Index(dst, "."); i >= 0 {
+			return dst[:i], dst[i+1:]
+		}
+		table = dst
+	}
+	if dst, isMapped := cfg.General.RestoreDatabaseMapping[database]; isMapped {
+		database = dst
+	}
+	return database, table
+}
+
+// applyRestoreMapping - rename databases and tables in schemas which will restore
+func applyRestoreMapping(cfg *config.Config, tablesForRestore ListOfTables) (ListOfTables, error) {
+	if !isRestoreMappingPresent(cfg) {
+		return tablesForRestore, nil
+	}
+	result := make(ListOfTables, len(tablesForRestore))
+	sources := map[metadata.TableTitle]metadata.TableTitle{}
+	for i, t := range tablesForRestore {
+		dstDatabase, dstTable := getRestoreDestination(cfg, t.Database, t.Table)
+		dst := metadata.TableTitle{Database: dstDatabase, Table: dstTable}
+		if src, exists := sources[dst]; exists {
+			return nil, fmt.Errorf("`%s`.`%s` and `%s`.`%s` can't be restored to the same `%s`.`%s`", src.Database, src.Table, t.Database, t.Table, dstDatabase, dstTable)
+		}
+		sources[dst] = metadata.TableTitle{Database: t.Database, Table: t.Table}
+		if len(cfg.General.RestoreDatabaseMapping) > 0 {
+			t.Query = renameDatabaseReferences(t.Query, cfg.General.RestoreDatabaseMapping)
+		}
+		if dstDatabase != t.Database || dstTable != t.Table {
+			query, err := renameCreateQuery(t.Query, dstDatabase, dstTable)
+			if err != nil {
+				return nil, fmt.Errorf("can't rename `%s`.`%s` to `%s`.`%s`: %v", t.Database, t.Table, dstDatabase, dstTable, err)
+			}
+			t.Database = dstDatabase
+			t.Table = dstTable
+			t.Query = query
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// renameCreateQuery - replace object name in CREATE / ATTACH query, UUID is removed, cause source table could still exist
+func renameCreateQuery(query, database, table string) (string, error) {
+	match := createObjectNameRE.FindStringSubmatch(query)
+	if match == nil {
+		return "", fmt.Errorf("can't find object name in ```%s```", query)
+	}
+	return match[1] + quoteIdentifier(database) + "." + quoteIdentifier(table) + query[len(match[0]):], nil
+}
+
+// renameCreateDatabaseQuery - replace database name in CREATE DATABASE query
+func renameCreateDatabaseQuery(query, database string) (string, error) {
+	match := createDatabaseNameRE.FindStringSubmatch(query)
+	if match == nil {
+		return "", fmt.Errorf("can't find database name in ```%s```", query)
+	}
+	return match[1] + quoteIdentifier(database) + query[len(match[0]):], nil
+}
+
+var stringLiteralRE = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+var databaseReferenceRE = regexp.MustCompile("(?i)(\\b(?:FROM|JOIN|TO|INTO)\\s+)(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)(\\s*\\.)")
+var distributedDatabaseRE = regexp.MustCompile(`(?i)(\bDistributed\s*\(\s*(?:'[^']*'|\w+)\s*,\s*)('(?:[^'\\]|\\.)*'|\w+)`)
+var dictionaryDatabaseRE = regexp.MustCompile(`(?is)(\bSOURCE\s*\(\s*CLICKHOUSE\s*\([^)]*?\bDB\s+)('(?:[^'\\]|\\.)*'|\w+)`)
+
+// renameDatabaseReferences - replace mapped databases in FROM, JOIN, TO and INTO clauses outside of string literals, in Distributed engine and in dictionary CLICKHOUSE source
+func renameDatabaseReferences(query string, databaseMapping map[string]string) string {
+	var result strings.Builder
+	lastLiteralEnd := 0
+	replaceReferences := func(part string) string {
+		return databaseReferenceRE.ReplaceAllStringFunc(part, func(reference string) string {
+			match := databaseReferenceRE.FindStringSubmatch(reference)
+			if dst, isMapped := databaseMapping[unquoteIdentifier(match[2])]; isMapped {
+				return match[1] + quoteIdentifier(dst) + match[3]
+			}
+			return reference
+		})
+	}
+	for _, literal := range stringLiteralRE.FindAllStringIndex(query, -1) {
+		result.WriteString(replaceReferences(query[lastLiteralEnd:literal[0]]))
+		result.WriteString(query[literal[0]:literal[1]])
+		lastLiteralEnd = literal[1]
+	}
+	result.WriteString(replaceReferences(query[lastLiteralEnd:]))
+	query = result.String()
+	for _, re := range []*regexp.Regexp{distributedDatabaseRE, dictionaryDatabaseRE} {
+		query = re.ReplaceAllStringFunc(query, func(argument string) string {
+			match := re.FindStringSubmatch(argument)
+			if dst, isMapped := databaseMapping[unquoteIdentifier(match[2])]; isMapped {
+				return match[1] + "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(dst) + "'"
+			}
+			return argument
+		})
+	}
+	return query
+}
+
+func unquoteIdentifier(name string) string {
+	if len(name) >= 2 && (name[0] == '`' || name[0] == '"' || name[0] == '\'') && name[len(name)-1] == name[0] {
+		return strings.NewReplacer("\\\\", "\\", "\\"+name[:1], name[:1]).Replace(name[1 : len(name)-1])
+	}
+	return name
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) + "`"
+}
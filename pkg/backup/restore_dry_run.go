@@ -0,0 +1,298 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+)
+
+// restoreDryRun - print every action which Restore will do and check prerequisites, only read queries are sent to ClickHouse
+func restoreDryRun(cfg *config.Config, ch *clickhouse.ClickHouse, backupName string, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly bool, disks []clickhouse.Disk) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore",
+		"dry_run":   true,
+	})
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		problems = append(problems, msg)
+		log.Error(msg)
+	}
+
+	defaultDataPath, err := ch.GetDefaultPath(disks)
+	if err != nil {
+		return ErrUnknownClickhouseDataPath
+	}
+	backup, _, err := getLocalBackup(cfg, backupName, disks)
+	if err != nil {
+		return err
+	}
+	if backup.Broken != "" {
+		return fmt.Errorf("backup '%s' is broken: %s", backupName, backup.Broken)
+	}
+	if !backup.Legacy {
+		backupMetadataBody, err := ioutil.ReadFile(path.Join(defaultDataPath, "backup", backupName, "metadata.json"))
+		if err != nil {
+			return err
+		}
+		backupMetadata := metadata.BackupMetadata{}
+		if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+			return err
+		}
+		for _, database := range backupMetadata.Databases {
+			if IsInformationSchema(database.Name) {
+				continue
+			}
+			query := database.Query
+			if dstDatabase, isMapped := cfg.General.RestoreDatabaseMapping[database.Name]; isMapped {
+				if query, err = renameCreateDatabaseQuery(query, dstDatabase); err != nil {
+					return err
+				}
+			}
+			log.Infof("create database: %s", query)
+		}
+		for _, function := range backupMetadata.Functions {
+			log.Infof("create function %s: %s", function.Name, function.CreateQuery)
+		}
+		if len(backupMetadata.Tables) == 0 && !rbacOnly && !configsOnly {
+			log.Warnf("'%s' doesn't contains tables for restore", backupName)
+			return nil
+		}
+	}
+
+	if rbacOnly || configsOnly {
+		if rbacOnly {
+			accessPath, err := ch.GetAccessManagementPath(nil)
+			if err != nil {
+				return err
+			}
+			entities, err := readAccessEntities(path.Join(defaultDataPath, "backup", backupName, "access"))
+			if err != nil {
+				problems = append(problems, err.Error())
+			}
+			createQueries, grantQueries := accessEntitiesQueries(entities)
+			for _, query := range append(createQueries, grantQueries...) {
+				log.Infof("execute: %s", query)
+			}
+			if planBackupRelatedDir(log, defaultDataPath, backupName, "access", accessPath) {
+				log.Infof("create %s", path.Join(accessPath, "need_rebuild_lists.mark"))
+				log.Infof("remove %s", path.Join(accessPath, "*.list"))
+			}
+		}
+		if configsOnly {
+			planBackupRelatedDir(log, defaultDataPath, backupName, "configs", configRestoreDir(*ch.Config))
+		}
+		log.Infof("execute restart command: %s", ch.Config.RestartCommand)
+		return finishRestoreDryRun(log, problems)
+	}
+
+	metadataPath := path.Join(defaultDataPath, "backup", backupName, "metadata")
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	chTables, err := ch.GetTables("")
+	if err != nil {
+		return err
+	}
+	existsTables := map[metadata.TableTitle]clickhouse.Table{}
+	for _, t := range chTables {
+		existsTables[metadata.TableTitle{Database: t.Database, Table: t.Name}] = t
+	}
+	createdTables := map[metadata.TableTitle]bool{}
+
+	if schemaOnly || (schemaOnly == dataOnly) {
+		tablesForRestore, err := getTableListByPatternLocal(metadataPath, tablePattern, ch.Config.SkipTables, dropTable, nil)
+		if err != nil {
+			return err
+		}
+		tablesForRestore = filterTablesByEngines(tablesForRestore, ch.Config.SkipTableEngines)
+		if len(tablesForRestore) == 0 {
+			return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
+		}
+		owners := innerTableOwners(tablesForRestore)
+		if tablesForRestore, err = applySchemaRewrites(cfg, tablesForRestore, backup.Macros); err != nil {
+			return err
+		}
+		serverPolicies, err := ch.GetStoragePolicies()
+		if err != nil {
+			return err
+		}
+		for _, msg := range storagePolicyProblems(tablesForRestore, serverPolicies, backup.StoragePolicies) {
+			problem("%s", msg)
+		}
+		serverMacros, err := ch.GetMacros()
+		if err != nil {
+			return err
+		}
+		serverReplicas, err := ch.GetReplicas()
+		if err != nil {
+			return err
+		}
+		for _, msg := range replicationPathProblems(tablesForRestore, serverMacros, serverReplicas) {
+			problem("%s", msg)
+		}
+		databaseEngines, err := databaseEnginesForRestore(ch, tablesForRestore)
+		if err != nil {
+			return err
+		}
+		tablesForRestore = applyTableUUIDs(cfg, tablesForRestore, databaseEngines, existingTableUUIDs(chTables), dropTable)
+		if tablesForRestore, err = alignInnerTables(cfg, tablesForRestore, owners, databaseEngines); err != nil {
+			return err
+		}
+		tablesForRestore = sortTablesByDependencies(tablesForRestore)
+		var pausedConsumers map[metadata.TableTitle]bool
+		if cfg.General.RestorePauseStreamingTables {
+			pausedConsumers = streamingConsumers(tablesForRestore)
+		}
+		for _, schema := range tablesForRestore {
+			title := metadata.TableTitle{Database: schema.Database, Table: schema.Table}
+			if _, exists := existsTables[title]; exists {
+				if dropTable {
+					log.Infof("drop table: `%s`.`%s`", schema.Database, schema.Table)
+				} else {
+					problem("`%s`.`%s` already exists, use --rm to drop it before restore or restore data only", schema.Database, schema.Table)
+				}
+			}
+			query := strings.Replace(schema.Query, "CREATE MATERIALIZED VIEW", "ATTACH MATERIALIZED VIEW", 1)
+			query = strings.Replace(query, "CREATE WINDOW VIEW", "ATTACH WINDOW VIEW", 1)
+			if cfg.General.RestoreSchemaOnCluster != "" {
+				log.Infof("create table on cluster '%s': %s", cfg.General.RestoreSchemaOnCluster, query)
+			} else {
+				log.Infof("create table: %s", query)
+			}
+			if pausedConsumers[title] {
+				log.Infof("detach permanently materialized view which reads from streaming table: `%s`.`%s`", schema.Database, schema.Table)
+			}
+			createdTables[title] = true
+		}
+	}
+
+	if dataOnly || (schemaOnly == dataOnly) {
+		partitionsToRestore := filesystemhelper.CreatePartitionsToBackupMap(partitions)
+		var tablesForRestore ListOfTables
+		if backup.Legacy {
+			tablesForRestore, err = ch.GetBackupTablesLegacy(backupName, disks)
+		} else {
+			tablesForRestore, err = getTableListByPatternLocal(metadataPath, tablePattern, ch.Config.SkipTables, false, partitionsToRestore)
+		}
+		if err != nil {
+			return err
+		}
+		tablesForRestore = filterTablesByEngines(tablesForRestore, ch.Config.SkipTableEngines)
+		if len(tablesForRestore) == 0 {
+			return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
+		}
+		if mappedDisks, err := applyRestoreDiskMapping(cfg, disks); err != nil {
+			problem("%v", err)
+		} else {
+			for _, msg := range objectDiskProblems(tablesForRestore, mappedDisks, backup.DiskTypes) {
+				problem("%s", msg)
+			}
+		}
+		diskMap := map[string]string{}
+		for _, disk := range disks {
+			diskMap[disk.Name] = disk.Path
+		}
+		for _, table := range tablesForRestore {
+			dstDatabase, dstTable := getRestoreDestination(cfg, table.Database, table.Table)
+			dstTitle := metadata.TableTitle{Database: dstDatabase, Table: dstTable}
+			chTable, exists := existsTables[dstTitle]
+			if !exists && !createdTables[dstTitle] {
+				problem("`%s`.`%s` is not created, restore schema first or create missing table manually", dstDatabase, dstTable)
+				continue
+			}
+			dstDataPaths := clickhouse.GetDisksByPaths(disks, chTable.DataPaths)
+			dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+			if table.NativeBackup {
+				nativePath := path.Join(defaultDataPath, "backup", backupName, nativeBackupDir)
+				if _, err := os.Stat(nativePath); err != nil {
+					problem("`%s`.`%s` native backup not found in %s", table.Database, table.Table, nativePath)
+					continue
+				}
+				if _, err := nativeBackupDiskPath(cfg, disks); err != nil {
+					problem("%v", err)
+					continue
+				}
+				log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, dstTable)).Infof("restore data from native backup %s", nativePath)
+				continue
+			}
+			if table.LogicalBackup {
+				dataPath := logicalDataPath(diskMap[logicalDisk], backupName, table.Database, table.Table)
+				if _, err := os.Stat(dataPath); err != nil {
+					problem("`%s`.`%s` exported data not found in %s", table.Database, table.Table, dataPath)
+					continue
+				}
+				log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, dstTable)).Infof("insert exported data from %s", dataPath)
+				continue
+			}
+			for disk, parts := range table.Parts {
+				backupDiskPath, diskExists := diskMap[disk]
+				if !diskExists {
+					log.Warnf("table '%s.%s' require disk '%s' that not found in clickhouse table system.disks, data will restored to %s", table.Database, table.Table, disk, diskMap["default"])
+					backupDiskPath = diskMap["default"]
+				}
+				detachedPath := "<new table data path>/detached"
+				if exists {
+					detachedPath = path.Join(dstDataPaths[disk], "detached")
+				}
+				size := int64(0)
+				for _, part := range parts {
+					partPath := path.Join(backupDiskPath, "backup", backupName, "shadow", dbAndTableDir, disk, part.Name)
+					// legacy backups store parts without disk folder
+					legacyPartPath := path.Join(backupDiskPath, "backup", backupName, "shadow", dbAndTableDir, part.Name)
+					if !isDir(partPath) && !isDir(legacyPartPath) {
+						problem("`%s`.`%s` part %s not found in %s", table.Database, table.Table, part.Name, partPath)
+						continue
+					}
+					size += part.Size
+					if !strings.HasSuffix(part.Name, ".proj") {
+						log.WithFields(apexLog.Fields{"table": fmt.Sprintf("%s.%s", dstDatabase, dstTable), "disk": disk, "part": part.Name}).Infof("hardlink to %s and attach part", detachedPath)
+					}
+				}
+				log.WithFields(apexLog.Fields{
+					"table": fmt.Sprintf("%s.%s", dstDatabase, dstTable),
+					"disk":  disk,
+					"parts": len(parts),
+					"size":  utils.LogBytes(uint64(size)),
+				}).Info("attach parts")
+			}
+		}
+	}
+	return finishRestoreDryRun(log, problems)
+}
+
+// planBackupRelatedDir - print copy action for access or configs backup folder, return false when folder is absent
+func planBackupRelatedDir(log *apexLog.Entry, defaultDataPath, backupName, backupPrefixDir, destinationDir string) bool {
+	srcBackupDir := path.Join(defaultDataPath, "backup", backupName, backupPrefixDir)
+	if !isDir(srcBackupDir) {
+		log.Infof("%s not found, nothing to restore", srcBackupDir)
+		return false
+	}
+	log.Infof("copy %s -> %s", srcBackupDir, destinationDir)
+	return true
+}
+
+func finishRestoreDryRun(log *apexLog.Entry, problems []string) error {
+	if len(problems) > 0 {
+		return fmt.Errorf("restore dry-run found %d problems, first: %s", len(problems), problems[0])
+	}
+	log.Info("done, nothing was changed")
+	return nil
+}
+
+func isDir(dirPath string) bool {
+	info, err := os.Stat(dirPath)
+	return err == nil && info.IsDir()
+}
@@ -1,8 +1,19 @@
 package backup
 
-func (b *Backuper) RestoreFromRemote(backupName string, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly bool) error {
-	if err := b.Download(backupName, tablePattern, partitions, schemaOnly); err != nil {
+import (
+	"context"
+
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
+)
+
+func (b *Backuper) RestoreFromRemote(ctx context.Context, backupName string, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly, stream bool) (err error) {
+	ctx, span := tracing.Start(ctx, "restore_remote", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
+	if stream && (!schemaOnly || dataOnly) && !rbacOnly && !configsOnly {
+		return b.restoreFromRemoteStream(ctx, backupName, tablePattern, partitions, dataOnly, dropTable)
+	}
+	if err := b.Download(ctx, backupName, tablePattern, partitions, schemaOnly, false); err != nil {
 		return err
 	}
-	return Restore(b.cfg, backupName, tablePattern, partitions, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly)
+	return Restore(ctx, b.cfg, backupName, tablePattern, partitions, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly, false)
 }
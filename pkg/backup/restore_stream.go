@@ -0,0 +1,328 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// streamRestoreItem - one remote archive or part directory and detached directory of destination table where it will be extracted
+type streamRestoreItem struct {
+	Disk       string
+	RemotePath string
+	LocalPath  string
+	Archive    bool
+}
+
+// restoreFromRemoteStream - download schema only, restore it and extract table data from remote storage directly into `detached` directory of destination tables
+func (b *Backuper) restoreFromRemoteStream(ctx context.Context, backupName string, tablePattern string, partitions []string, dataOnly, dropTable bool) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_remote_stream",
+	})
+	if b.cfg.General.RemoteStorage == "none" {
+		return fmt.Errorf("remote storage is 'none'")
+	}
+	if backupName == "" {
+		_ = PrintRemoteBackups(ctx, b.cfg, "all", "text", nil)
+		return fmt.Errorf("select backup for restore")
+	}
+	remoteBackup, err := b.getRemoteBackupForStream(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if err := b.Download(ctx, backupName, tablePattern, partitions, true, false); err != nil {
+		return err
+	}
+	if !dataOnly {
+		if err := Restore(ctx, b.cfg, backupName, tablePattern, partitions, true, false, dropTable, false, false, false); err != nil {
+			return err
+		}
+	}
+	startRestore := time.Now()
+	if err := b.restoreDataStream(ctx, remoteBackup, tablePattern, partitions); err != nil {
+		return err
+	}
+	log.WithField("duration", utils.LogDuration(time.Since(startRestore))).Info("done")
+	return nil
+}
+
+// getRemoteBackupForStream - find remote backup and check it could be restored without local copy of data
+func (b *Backuper) getRemoteBackupForStream(ctx context.Context, backupName string) (*new_storage.Backup, error) {
+	var err error
+	if b.dst, err = new_storage.NewBackupDestination(b.cfg, true); err != nil {
+		return nil, err
+	}
+	if err := b.dst.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+	}
+	remoteBackups, err := b.dst.BackupList(ctx, true, backupName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range remoteBackups {
+		if remoteBackups[i].BackupName != backupName {
+			continue
+		}
+		if remoteBackups[i].Legacy {
+			return nil, fmt.Errorf("'%s' is old format backup and doesn't supports restore with --stream", backupName)
+		}
+		if remoteBackups[i].RequiredBackup != "" {
+			return nil, fmt.Errorf("'%s' is incremental backup and requires '%s', it doesn't supports restore with --stream", backupName, remoteBackups[i].RequiredBackup)
+		}
+		if remoteBackups[i].Broken != "" {
+			return nil, fmt.Errorf("'%s' is broken: %s", backupName, remoteBackups[i].Broken)
+		}
+		return &remoteBackups[i], nil
+	}
+	return nil, fmt.Errorf("'%s' is not found on remote storage", backupName)
+}
+
+func (b *Backuper) restoreDataStream(ctx context.Context, remoteBackup *new_storage.Backup, tablePattern string, partitions []string) (err error) {
+	ctx, span := tracing.Start(ctx, "restore_data_stream", tracing.Backup(remoteBackup.BackupName))
+	defer func() { tracing.End(span, err) }()
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    remoteBackup.BackupName,
+		"operation": "restore_remote_stream",
+	})
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	disks, err := b.ch.GetDisks()
+	if err != nil {
+		return err
+	}
+	if err := b.initDisks(disks); err != nil {
+		return err
+	}
+	if disks, err = applyRestoreDiskMapping(b.cfg, disks); err != nil {
+		return err
+	}
+	partitionsToRestore := filesystemhelper.CreatePartitionsToBackupMap(partitions)
+	metadataPath := path.Join(b.DefaultDataPath, "backup", remoteBackup.BackupName, "metadata")
+	tablesForRestore, err := getTableListByPatternLocal(metadataPath, tablePattern, b.ch.Config.SkipTables, false, partitionsToRestore)
+	if err != nil {
+		return err
+	}
+	tablesForRestore = filterTablesByEngines(tablesForRestore, b.ch.Config.SkipTableEngines)
+	if len(tablesForRestore) == 0 {
+		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, remoteBackup.BackupName)
+	}
+	chTables, err := b.ch.GetTables("")
+	if err != nil {
+		return err
+	}
+	dstTablesMap := map[metadata.TableTitle]clickhouse.Table{}
+	for i := range chTables {
+		dstTablesMap[metadata.TableTitle{Database: chTables[i].Database, Table: chTables[i].Name}] = chTables[i]
+	}
+	innerDestinations := innerTableDestinations(b.cfg, tablesForRestore, dstTablesMap)
+	// all tables metadata are read before extracting, so free space of disks is checked for all selected parts
+	remoteTables := make([]metadata.TableMetadata, 0, len(tablesForRestore))
+	partsBeforeFilterList := make([]map[string][]metadata.Part, 0, len(tablesForRestore))
+	for _, t := range tablesForRestore {
+		table, err := b.readRemoteTableMetadata(ctx, remoteBackup.BackupName, metadata.TableTitle{Database: t.Database, Table: t.Table})
+		if err != nil {
+			return fmt.Errorf("can't read %s.%s metadata from '%s': %v", t.Database, t.Table, remoteBackup.BackupName, err)
+		}
+		if table.MetadataOnly {
+			continue
+		}
+		partsBeforeFilter := make(map[string][]metadata.Part, len(table.Parts))
+		for disk, parts := range table.Parts {
+			partsBeforeFilter[disk] = parts
+		}
+		if len(filesystemhelper.GetPartitionsFilterForTable(partitionsToRestore, table.Database, table.Table)) > 0 {
+			filterPartsByPartitionsFilter(*table, partitionsToRestore)
+			filterArchivesByParts(table, partsBeforeFilter)
+		}
+		remoteTables = append(remoteTables, *table)
+		partsBeforeFilterList = append(partsBeforeFilterList, partsBeforeFilter)
+	}
+	if problems := objectDiskProblems(remoteTables, disks, remoteBackup.DiskTypes); len(problems) > 0 {
+		return fmt.Errorf("can't restore data: %s", strings.Join(problems, "; "))
+	}
+	if err := checkDisksFreeSpace(b.cfg, b.ch, remoteTables); err != nil {
+		return err
+	}
+	for i := range remoteTables {
+		table, partsBeforeFilter := &remoteTables[i], partsBeforeFilterList[i]
+		dstTable := *table
+		dstTable.Database, dstTable.Table = getDataRestoreDestination(b.cfg, innerDestinations, table.Database, table.Table)
+		chTable, found := dstTablesMap[metadata.TableTitle{Database: dstTable.Database, Table: dstTable.Table}]
+		if !found {
+			return fmt.Errorf("'%s.%s' is not created. Restore schema first or create missing tables manually", dstTable.Database, dstTable.Table)
+		}
+		if table.LogicalBackup {
+			log.Warnf("'%s.%s' data is exported with `logical_backup_engines` and can't be streamed, use `restore_remote` without `--stream`", table.Database, table.Table)
+			continue
+		}
+		if table.NativeBackup {
+			log.Warnf("'%s.%s' data is written by BACKUP statement and can't be streamed, use `restore_remote` without `--stream`", table.Database, table.Table)
+			continue
+		}
+		for disk := range table.Parts {
+			disks = appendMissingDisk(disks, disk, b.DiskToPathMap["default"], log)
+		}
+		items, err := streamRestoreItems(remoteBackup.BackupName, remoteBackup.DataFormat, table, clickhouse.GetDisksByPaths(disks, chTable.DataPaths))
+		if err != nil {
+			return fmt.Errorf("can't restore '%s.%s': %v", dstTable.Database, dstTable.Table, err)
+		}
+		start := time.Now()
+		if err := b.extractStreamRestoreItems(ctx, table, items, partsBeforeFilter, disks); err != nil {
+			return fmt.Errorf("can't restore '%s.%s': %v", dstTable.Database, dstTable.Table, err)
+		}
+		_, attachSpan := tracing.Start(ctx, "attach", tracing.Table(dstTable.Database, dstTable.Table))
+		err = b.ch.AttachPartitions(dstTable, disks)
+		tracing.End(attachSpan, err)
+		if err != nil {
+			return fmt.Errorf("can't attach partitions for table '%s.%s': %v", dstTable.Database, dstTable.Table, err)
+		}
+		if err := checkRestoredProjections(b.cfg, b.ch, dstTable); err != nil {
+			return fmt.Errorf("can't rebuild projections for table '%s.%s': %v", dstTable.Database, dstTable.Table, err)
+		}
+		log.
+			WithField("table", fmt.Sprintf("%s.%s", dstTable.Database, dstTable.Table)).
+			WithField("duration", utils.LogDuration(time.Since(start))).
+			Info("done")
+	}
+	return nil
+}
+
+// appendMissingDisk - disk which exists in backup but not in system.disks is restored to default disk path, the same as RestoreData does
+func appendMissingDisk(disks []clickhouse.Disk, diskName, defaultPath string, log *apexLog.Entry) []clickhouse.Disk {
+	for _, d := range disks {
+		if d.Name == diskName {
+			return disks
+		}
+	}
+	log.Warnf("disk '%s' not found in clickhouse table system.disks, you can add nonexistent disks to `disk_mapping` in  `clickhouse` config section, data will restored to %s", diskName, defaultPath)
+	return append(disks, clickhouse.Disk{Name: diskName, Path: defaultPath, Type: "local"})
+}
+
+// streamRestoreItems - archives of each disk extract into `detached` directly, for directory format each part is downloaded into `detached/<part>`
+func streamRestoreItems(backupName, dataFormat string, table *metadata.TableMetadata, dstDataPaths map[string]string) ([]streamRestoreItem, error) {
+	remoteTablePath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	diskNames := make([]string, 0, len(table.Parts))
+	for disk, parts := range table.Parts {
+		if len(parts) > 0 {
+			diskNames = append(diskNames, disk)
+		}
+	}
+	sort.Strings(diskNames)
+	var items []streamRestoreItem
+	for _, disk := range diskNames {
+		dstDataPath, _, exists := clickhouse.GetDataPathForDisk(dstDataPaths, disk)
+		if !exists {
+			return nil, fmt.Errorf("can't find data path of destination table on disk '%s'", disk)
+		}
+		detachedPath := path.Join(dstDataPath, "detached")
+		if dataFormat == "directory" {
+			for _, part := range table.Parts[disk] {
+				if part.Required {
+					return nil, fmt.Errorf("part '%s' is stored in required backup", part.Name)
+				}
+				items = append(items, streamRestoreItem{
+					Disk:       disk,
+					RemotePath: path.Join(remoteTablePath, disk, part.Name),
+					LocalPath:  path.Join(detachedPath, part.Name),
+				})
+			}
+			continue
+		}
+		for _, archiveFile := range table.Files[disk] {
+			items = append(items, streamRestoreItem{
+				Disk:       disk,
+				RemotePath: path.Join(remoteTablePath, archiveFile),
+				LocalPath:  detachedPath,
+				Archive:    true,
+			})
+		}
+	}
+	return items, nil
+}
+
+// extractStreamRestoreItems - download items concurrently, then remove parts of not selected partitions extracted from archives split by size and change owner of extracted files
+func (b *Backuper) extractStreamRestoreItems(ctx context.Context, table *metadata.TableMetadata, items []streamRestoreItem, partsBeforeFilter map[string][]metadata.Part, disks []clickhouse.Disk) error {
+	s := b.downloadSemaphore()
+	g, dataCtx := errgroup.WithContext(ctx)
+	for i := range items {
+		if err := s.Acquire(dataCtx, 1); err != nil {
+			apexLog.Errorf("can't acquire semaphore during extractStreamRestoreItems: %v", err)
+			break
+		}
+		item := items[i]
+		g.Go(func() error {
+			defer s.Release(1)
+			apexLog.Debugf("start stream %s to %s", item.RemotePath, item.LocalPath)
+			var err error
+			if item.Archive {
+				getCtx, getSpan := tracing.Start(dataCtx, "get_decompress", tracing.Table(table.Database, table.Table), attribute.String("remote_path", item.RemotePath))
+				err = b.dst.DownloadCompressedStream(getCtx, item.RemotePath, item.LocalPath)
+				tracing.End(getSpan, err)
+			} else {
+				_, getSpan := tracing.Start(dataCtx, "get", tracing.Table(table.Database, table.Table), attribute.String("remote_path", item.RemotePath))
+				err = b.dst.DownloadPath(ctx, 0, item.RemotePath, item.LocalPath, nil)
+				tracing.End(getSpan, err)
+			}
+			if err != nil {
+				return err
+			}
+			apexLog.Debugf("finish stream %s to %s", item.RemotePath, item.LocalPath)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("one of extractStreamRestoreItems go-routine return error: %v", err)
+	}
+	detachedPaths := map[string]string{}
+	for _, item := range items {
+		if item.Archive {
+			detachedPaths[item.Disk] = item.LocalPath
+		} else {
+			detachedPaths[item.Disk] = path.Dir(item.LocalPath)
+		}
+	}
+	for disk, detachedPath := range detachedPaths {
+		selectedParts := common.EmptyMap{}
+		for _, part := range table.Parts[disk] {
+			selectedParts[part.Name] = struct{}{}
+		}
+		for _, part := range partsBeforeFilter[disk] {
+			if _, selected := selectedParts[part.Name]; !selected {
+				if err := os.RemoveAll(path.Join(detachedPath, part.Name)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, part := range table.Parts[disk] {
+			if err := filepath.Walk(path.Join(detachedPath, part.Name), func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				return filesystemhelper.Chown(filePath, b.ch, disks)
+			}); err != nil {
+				return fmt.Errorf("can't change owner of part '%s': %v", part.Name, err)
+			}
+		}
+	}
+	return nil
+}
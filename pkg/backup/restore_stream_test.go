@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamRestoreItems(t *testing.T) {
+	table := &metadata.TableMetadata{
+		Database: "db",
+		Table:    "t-1",
+		Parts:    map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}, "hdd": {{Name: "all_3_3_0"}}, "empty": {}},
+		Files:    map[string][]string{"default": {"default_1.tar"}, "hdd": {"hdd_all_3_3_0.tar"}},
+	}
+	dstDataPaths := map[string]string{"default": "/var/lib/clickhouse/store/abc/abcd/", "hdd": "/hdd/store/abc/abcd/"}
+	items, err := streamRestoreItems("b1", "tar", table, dstDataPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []streamRestoreItem{
+		{Disk: "default", RemotePath: "b1/shadow/db/t%2D1/default_1.tar", LocalPath: "/var/lib/clickhouse/store/abc/abcd/detached", Archive: true},
+		{Disk: "hdd", RemotePath: "b1/shadow/db/t%2D1/hdd_all_3_3_0.tar", LocalPath: "/hdd/store/abc/abcd/detached", Archive: true},
+	}, items)
+
+	items, err = streamRestoreItems("b1", "directory", table, dstDataPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []streamRestoreItem{
+		{Disk: "default", RemotePath: "b1/shadow/db/t%2D1/default/all_1_1_0", LocalPath: "/var/lib/clickhouse/store/abc/abcd/detached/all_1_1_0"},
+		{Disk: "default", RemotePath: "b1/shadow/db/t%2D1/default/all_2_2_0", LocalPath: "/var/lib/clickhouse/store/abc/abcd/detached/all_2_2_0"},
+		{Disk: "hdd", RemotePath: "b1/shadow/db/t%2D1/hdd/all_3_3_0", LocalPath: "/hdd/store/abc/abcd/detached/all_3_3_0"},
+	}, items)
+
+	items, err = streamRestoreItems("b1", "tar", table, map[string]string{"default": "/var/lib/clickhouse/data/db/t/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/clickhouse/data/db/t/detached", items[1].LocalPath)
+	_, err = streamRestoreItems("b1", "tar", table, map[string]string{})
+	assert.Error(t, err)
+	table.Parts["default"][0].Required = true
+	_, err = streamRestoreItems("b1", "directory", table, dstDataPaths)
+	assert.Error(t, err)
+}
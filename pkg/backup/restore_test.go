@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameCreateQuery(t *testing.T) {
+	testData := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"CREATE TABLE db.t UUID 'aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee' (id UInt64) ENGINE = MergeTree ORDER BY id",
+			"CREATE TABLE `new_db`.`new_t` (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			"CREATE TABLE `db`.`t` (id UInt64) ENGINE = Memory",
+			"CREATE TABLE `new_db`.`new_t` (id UInt64) ENGINE = Memory",
+		},
+		{
+			"ATTACH MATERIALIZED VIEW IF NOT EXISTS db.mv TO db.t AS SELECT * FROM db.src",
+			"ATTACH MATERIALIZED VIEW IF NOT EXISTS `new_db`.`new_t` TO db.t AS SELECT * FROM db.src",
+		},
+		{
+			"CREATE TABLE `t` (id UInt64) ENGINE = Log",
+			"CREATE TABLE `new_db`.`new_t` (id UInt64) ENGINE = Log",
+		},
+	}
+	for _, tc := range testData {
+		actual, err := renameCreateQuery(tc.query, "new_db", "new_t")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+	_, err := renameCreateQuery("SELECT 1", "new_db", "new_t")
+	assert.Error(t, err)
+
+	actual, err := renameCreateDatabaseQuery("CREATE DATABASE db UUID 'aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee' ENGINE = Atomic", "staging_db")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE DATABASE `staging_db` ENGINE = Atomic", actual)
+}
+
+func TestApplyRestoreMapping(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db": "staging_db"}
+	cfg.General.RestoreTableMapping = map[string]string{"db.t1": "t1_copy", "db.t2": "other_db.t2"}
+	tables := ListOfTables{
+		{Database: "db", Table: "t1", Query: "CREATE TABLE db.t1 (id UInt64) ENGINE = Memory"},
+		{Database: "db", Table: "t2", Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = Memory"},
+		{Database: "db", Table: "t3", Query: "CREATE TABLE db.t3 (id UInt64) ENGINE = Memory"},
+		{Database: "db2", Table: "t4", Query: "CREATE TABLE db2.t4 (id UInt64) ENGINE = Memory"},
+	}
+	result, err := applyRestoreMapping(cfg, tables)
+	assert.NoError(t, err)
+	actual := make([]metadata.TableTitle, len(result))
+	for i := range result {
+		actual[i] = metadata.TableTitle{Database: result[i].Database, Table: result[i].Table}
+	}
+	assert.Equal(t, []metadata.TableTitle{
+		{Database: "staging_db", Table: "t1_copy"},
+		{Database: "other_db", Table: "t2"},
+		{Database: "staging_db", Table: "t3"},
+		{Database: "db2", Table: "t4"},
+	}, actual)
+	assert.Equal(t, "CREATE TABLE `staging_db`.`t1_copy` (id UInt64) ENGINE = Memory", result[0].Query)
+	assert.Equal(t, "db", tables[0].Database)
+
+	cfg.General.RestoreTableMapping = map[string]string{"db.t1": "t3"}
+	_, err = applyRestoreMapping(cfg, tables)
+	assert.Error(t, err)
+}
+
+func TestRenameDatabaseReferences(t *testing.T) {
+	mapping := map[string]string{"db": "staging_db", "src": "staging_src"}
+	testData := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"CREATE MATERIALIZED VIEW db.mv TO db.t AS SELECT a.id FROM `src`.events AS a LEFT JOIN db2.dim USING id WHERE a.name != 'FROM db.t'",
+			"CREATE MATERIALIZED VIEW db.mv TO `staging_db`.t AS SELECT a.id FROM `staging_src`.events AS a LEFT JOIN db2.dim USING id WHERE a.name != 'FROM db.t'",
+		},
+		{
+			"CREATE TABLE db.t_dist (id UInt64) ENGINE = Distributed('{cluster}', 'db', 't', rand())",
+			"CREATE TABLE db.t_dist (id UInt64) ENGINE = Distributed('{cluster}', 'staging_db', 't', rand())",
+		},
+		{
+			"CREATE TABLE db.t_dist (id UInt64) ENGINE = Distributed(cluster, currentDatabase(), 't')",
+			"CREATE TABLE db.t_dist (id UInt64) ENGINE = Distributed(cluster, currentDatabase(), 't')",
+		},
+		{
+			"CREATE DICTIONARY db.d (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(HOST 'localhost' PORT 9000 DB 'src' TABLE 'dim')) LAYOUT(FLAT()) LIFETIME(300)",
+			"CREATE DICTIONARY db.d (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(HOST 'localhost' PORT 9000 DB 'staging_src' TABLE 'dim')) LAYOUT(FLAT()) LIFETIME(300)",
+		},
+		{
+			"CREATE VIEW db.v AS SELECT * FROM numbers(10)",
+			"CREATE VIEW db.v AS SELECT * FROM numbers(10)",
+		},
+	}
+	for _, tc := range testData {
+		assert.Equal(t, tc.expected, renameDatabaseReferences(tc.query, mapping))
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db": "staging_db"}
+	result, err := applyRestoreMapping(cfg, ListOfTables{
+		{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv TO db.t AS SELECT * FROM db.src"},
+		{Database: "db2", Table: "v", Query: "CREATE VIEW db2.v AS SELECT * FROM db.t"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE MATERIALIZED VIEW `staging_db`.`mv` TO `staging_db`.t AS SELECT * FROM `staging_db`.src", result[0].Query)
+	assert.Equal(t, "CREATE VIEW db2.v AS SELECT * FROM `staging_db`.t", result[1].Query)
+}
+
+func TestSummarizeDistributedDDL(t *testing.T) {
+	results := []clickhouse.DistributedDDLResult{
+		{Host: "ch2", Port: 9000, Status: 0},
+		{Host: "ch1", Port: 9000, Status: 0},
+		{Host: "ch2", Port: 9000, Status: 57, Error: "Table already exists"},
+		{Host: "ch1", Port: 9000, Status: 0},
+	}
+	assert.Equal(t, []distributedDDLHostSummary{
+		{Host: "ch1:9000", Succeeded: 2},
+		{Host: "ch2:9000", Succeeded: 1, Failed: 1, Errors: []string{"Table already exists"}},
+	}, summarizeDistributedDDL(results))
+	assert.Nil(t, summarizeDistributedDDL(nil))
+}
@@ -0,0 +1,356 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+var mergeTreeEngineRE = regexp.MustCompile(`(?i)\bENGINE\s*=\s*(\w*)MergeTree\b`)
+var storagePolicyRE = regexp.MustCompile(`(?i)(\bstorage_policy\s*=\s*)'([^']*)'`)
+
+// applySchemaRewrites - apply `restore_database_mapping`, `restore_table_mapping`, engine conversion, macros, storage policy, table settings and TTL rewriting to schemas which will restore,
+// sourceMacros are system.macros of backup host
+func applySchemaRewrites(cfg *config.Config, tablesForRestore ListOfTables, sourceMacros map[string]string) (ListOfTables, error) {
+	tablesForRestore, err := applyRestoreMapping(cfg, tablesForRestore)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.General.RestoreReplicatedEngine == "" && !cfg.General.RestoreReplicatedMacros && len(cfg.General.RestoreStoragePolicyMapping) == 0 &&
+		len(cfg.General.RestoreTableSettings) == 0 && !cfg.General.RestoreStripTTL {
+		return tablesForRestore, nil
+	}
+	result := make(ListOfTables, len(tablesForRestore))
+	for i, t := range tablesForRestore {
+		if cfg.General.RestoreReplicatedEngine != "" {
+			if t.Query, err = convertReplicatedEngine(t.Query, t.Database, t.Table, cfg.General); err != nil {
+				return nil, fmt.Errorf("can't convert engine of `%s`.`%s`: %v", t.Database, t.Table, err)
+			}
+		}
+		if cfg.General.RestoreReplicatedMacros {
+			if t.Query, err = rewriteReplicatedMacros(t.Query, sourceMacros, cfg.General.RestoreMacrosOverride); err != nil {
+				return nil, fmt.Errorf("can't rewrite macros of `%s`.`%s`: %v", t.Database, t.Table, err)
+			}
+		}
+		t.Query = rewriteStoragePolicy(t.Query, cfg.General.RestoreStoragePolicyMapping)
+		if cfg.General.RestoreStripTTL {
+			t.Query = stripTTL(t.Query)
+		}
+		t.Query = overrideTableSettings(t.Query, cfg.General.RestoreTableSettings)
+		result[i] = t
+	}
+	return result, nil
+}
+
+// convertReplicatedEngine - `merge_tree` replace Replicated*MergeTree with *MergeTree and remove ZooKeeper path and replica name,
+// `replicated` replace *MergeTree with Replicated*MergeTree and add `restore_replicated_zookeeper_path` and `restore_replicated_replica_name`,
+// {database} and {table} in ZooKeeper path are replaced with destination names, other macros are expanded by clickhouse-server
+func convertReplicatedEngine(query, database, table string, cfg config.GeneralConfig) (string, error) {
+	loc := mergeTreeEngineRE.FindStringSubmatchIndex(query)
+	if loc == nil {
+		return query, nil
+	}
+	prefix := query[loc[2]:loc[3]]
+	isReplicated := strings.HasPrefix(strings.ToLower(prefix), "replicated")
+	args, argsEnd, err := splitEngineArguments(query, loc[1])
+	if err != nil {
+		return "", err
+	}
+	switch cfg.RestoreReplicatedEngine {
+	case "merge_tree":
+		if !isReplicated {
+			return query, nil
+		}
+		if len(args) >= 2 && isStringLiteral(args[0]) && isStringLiteral(args[1]) {
+			args = args[2:]
+		}
+		prefix = prefix[len("replicated"):]
+	case "replicated":
+		if isReplicated {
+			return query, nil
+		}
+		zookeeperPath := strings.NewReplacer("{database}", database, "{table}", table).Replace(cfg.RestoreReplicatedZookeeperPath)
+		args = append([]string{quoteStringLiteral(zookeeperPath), quoteStringLiteral(cfg.RestoreReplicatedReplicaName)}, args...)
+		prefix = "Replicated" + prefix
+	default:
+		return "", fmt.Errorf("unknown restore_replicated_engine '%s'", cfg.RestoreReplicatedEngine)
+	}
+	engine := query[loc[0]:loc[2]] + prefix + "MergeTree"
+	if len(args) > 0 {
+		engine += "(" + strings.Join(args, ", ") + ")"
+	}
+	return query[:loc[0]] + engine + query[argsEnd:], nil
+}
+
+// rewriteReplicatedMacros - ZooKeeper path segments equal to macro value of backup host are replaced with `{macro}`, replica name equal to `{replica}` value
+// of backup host or any explicit replica name when macros of backup host are unknown is replaced with `{replica}`, so clickhouse-server expands macros of restore host,
+// then `{macro}` from override are replaced with override values
+func rewriteReplicatedMacros(query string, sourceMacros, override map[string]string) (string, error) {
+	loc := mergeTreeEngineRE.FindStringSubmatchIndex(query)
+	if loc == nil || !strings.HasPrefix(strings.ToLower(query[loc[2]:loc[3]]), "replicated") {
+		return query, nil
+	}
+	args, argsEnd, err := splitEngineArguments(query, loc[1])
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 2 || !isStringLiteral(args[0]) || !isStringLiteral(args[1]) {
+		return query, nil
+	}
+	zookeeperPath, replicaName := unquoteStringLiteral(args[0]), unquoteStringLiteral(args[1])
+	// shard and replica first, other macros sorted by name, so the same value is always replaced with the same macro
+	macroNames := make([]string, 0, len(sourceMacros))
+	for name := range sourceMacros {
+		if name != "shard" && name != "replica" {
+			macroNames = append(macroNames, name)
+		}
+	}
+	sort.Strings(macroNames)
+	macroNames = append([]string{"shard", "replica"}, macroNames...)
+	segments := strings.Split(zookeeperPath, "/")
+	for i, segment := range segments {
+		for _, name := range macroNames {
+			if value, exists := sourceMacros[name]; exists && value != "" && segment == value {
+				segments[i] = "{" + name + "}"
+				break
+			}
+		}
+	}
+	zookeeperPath = strings.Join(segments, "/")
+	if value, exists := sourceMacros["replica"]; (exists && value != "" && replicaName == value) || (len(sourceMacros) == 0 && !strings.Contains(replicaName, "{")) {
+		replicaName = "{replica}"
+	}
+	if len(override) > 0 {
+		replaces := make([]string, 0, len(override)*2)
+		for name, value := range override {
+			replaces = append(replaces, "{"+name+"}", value)
+		}
+		replacer := strings.NewReplacer(replaces...)
+		zookeeperPath, replicaName = replacer.Replace(zookeeperPath), replacer.Replace(replicaName)
+	}
+	args[0], args[1] = quoteStringLiteral(zookeeperPath), quoteStringLiteral(replicaName)
+	return query[:loc[1]] + "(" + strings.Join(args, ", ") + ")" + query[argsEnd:], nil
+}
+
+// rewriteStoragePolicy - replace `storage_policy` in table SETTINGS with target policy from `restore_storage_policy_mapping`
+func rewriteStoragePolicy(query string, mapping map[string]string) string {
+	if len(mapping) == 0 {
+		return query
+	}
+	return storagePolicyRE.ReplaceAllStringFunc(query, func(setting string) string {
+		match := storagePolicyRE.FindStringSubmatch(setting)
+		if dst, isMapped := mapping[match[2]]; isMapped {
+			return match[1] + quoteStringLiteral(dst)
+		}
+		return setting
+	})
+}
+
+// stripTTL - remove table TTL clause and column TTL expressions from MergeTree schema, so restored data is not expired right after attach
+func stripTTL(query string) string {
+	loc := mergeTreeEngineRE.FindStringIndex(query)
+	if loc == nil {
+		return query
+	}
+	if ttlStart, _ := findTopLevelKeyword(query, loc[1], 0, "TTL"); ttlStart < len(query) {
+		ttlEnd, _ := findTopLevelKeyword(query, ttlStart+len("TTL"), 0, storageClauseEndKeywords...)
+		if ttlEnd < len(query) {
+			query = strings.TrimRight(query[:ttlStart], " \t\r\n") + " " + query[ttlEnd:]
+		} else {
+			query = strings.TrimRight(query[:ttlStart], " \t\r\n")
+		}
+	}
+	// column TTL is the last part of column declaration, it ends at next column or at the end of columns list
+	var ranges [][2]int
+	ttlStart := -1
+	walkQuery(query[:loc[0]], 0, func(i, depth int) bool {
+		switch {
+		case ttlStart < 0 && depth == 1 && isKeywordAt(query, i, "TTL"):
+			ttlStart = i
+		case ttlStart >= 0 && ((depth == 1 && query[i] == ',') || (depth == 0 && query[i] == ')')):
+			ranges = append(ranges, [2]int{ttlStart, i})
+			ttlStart = -1
+		}
+		return true
+	})
+	for i := len(ranges) - 1; i >= 0; i-- {
+		query = strings.TrimRight(query[:ranges[i][0]], " \t\r\n") + query[ranges[i][1]:]
+	}
+	return query
+}
+
+// storageClauseEndKeywords - keywords which could follow TTL and SETTINGS clauses of MergeTree storage definition
+var storageClauseEndKeywords = []string{"SETTINGS", "COMMENT", "POPULATE", "AS"}
+
+// overrideTableSettings - replace values of existing MergeTree SETTINGS and append missing settings, settings are appended sorted by name
+func overrideTableSettings(query string, settings map[string]string) string {
+	if len(settings) == 0 {
+		return query
+	}
+	loc := mergeTreeEngineRE.FindStringIndex(query)
+	if loc == nil {
+		return query
+	}
+	settingsStart, keyword := findTopLevelKeyword(query, loc[1], 0, storageClauseEndKeywords...)
+	var existing []string
+	clauseStart, clauseEnd := settingsStart, settingsStart
+	if keyword == "SETTINGS" {
+		clauseEnd, _ = findTopLevelKeyword(query, settingsStart+len("SETTINGS"), 0, storageClauseEndKeywords[1:]...)
+		existing = splitTopLevel(query[settingsStart+len("SETTINGS") : clauseEnd])
+	}
+	applied := map[string]bool{}
+	for i, setting := range existing {
+		name := strings.TrimSpace(strings.SplitN(setting, "=", 2)[0])
+		if value, exists := settings[name]; exists {
+			existing[i] = name + " = " + value
+			applied[name] = true
+		}
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		if !applied[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		existing = append(existing, name+" = "+settings[name])
+	}
+	clause := " SETTINGS " + strings.Join(existing, ", ")
+	if clauseEnd < len(query) {
+		clause += " "
+	}
+	return strings.TrimRight(query[:clauseStart], " \t\r\n") + clause + strings.TrimLeft(query[clauseEnd:], " \t\r\n")
+}
+
+// walkQuery - call fn for each byte of query after start outside of string literals and quoted identifiers with parentheses depth,
+// parentheses have depth of enclosing expression, stop when fn returns false
+func walkQuery(query string, start int, fn func(i, depth int) bool) {
+	depth := 0
+	for i := start; i < len(query); i++ {
+		switch query[i] {
+		case '\'', '`', '"':
+			quote := query[i]
+			for i++; i < len(query) && query[i] != quote; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+			continue
+		case '(':
+			if !fn(i, depth) {
+				return
+			}
+			depth++
+			continue
+		case ')':
+			depth--
+		}
+		if !fn(i, depth) {
+			return
+		}
+	}
+}
+
+// findTopLevelKeyword - return position of first keyword after start with parentheses depth, len(query) when keywords are not found
+func findTopLevelKeyword(query string, start, depth int, keywords ...string) (int, string) {
+	pos, found := len(query), ""
+	walkQuery(query, start, func(i, d int) bool {
+		if d != depth {
+			return true
+		}
+		for _, keyword := range keywords {
+			if isKeywordAt(query, i, keyword) {
+				pos, found = i, keyword
+				return false
+			}
+		}
+		return true
+	})
+	return pos, found
+}
+
+// isKeywordAt - check query contains keyword at position i as separate word
+func isKeywordAt(query string, i int, keyword string) bool {
+	if !strings.HasPrefix(query[i:], keyword) {
+		return false
+	}
+	isWordChar := func(c byte) bool {
+		return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+	return (i == 0 || !isWordChar(query[i-1])) && (i+len(keyword) == len(query) || !isWordChar(query[i+len(keyword)]))
+}
+
+// splitTopLevel - split expression list by commas outside of parentheses and quotes
+func splitTopLevel(list string) []string {
+	var result []string
+	itemStart := 0
+	walkQuery(list, 0, func(i, depth int) bool {
+		if depth == 0 && list[i] == ',' {
+			result = append(result, strings.TrimSpace(list[itemStart:i]))
+			itemStart = i + 1
+		}
+		return true
+	})
+	if item := strings.TrimSpace(list[itemStart:]); item != "" {
+		result = append(result, item)
+	}
+	return result
+}
+
+// splitEngineArguments - split engine arguments in parentheses started at `start` by top level commas, return end of arguments,
+// engine without parentheses has no arguments
+func splitEngineArguments(query string, start int) ([]string, int, error) {
+	i := start
+	for i < len(query) && (query[i] == ' ' || query[i] == '\t' || query[i] == '\n' || query[i] == '\r') {
+		i++
+	}
+	if i >= len(query) || query[i] != '(' {
+		return nil, start, nil
+	}
+	var args []string
+	depth := 0
+	argStart := i + 1
+	for j := i; j < len(query); j++ {
+		switch query[j] {
+		case '\'', '`', '"':
+			quote := query[j]
+			for j++; j < len(query) && query[j] != quote; j++ {
+				if query[j] == '\\' {
+					j++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				if arg := strings.TrimSpace(query[argStart:j]); arg != "" || len(args) > 0 {
+					args = append(args, arg)
+				}
+				return args, j + 1, nil
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(query[argStart:j]))
+				argStart = j + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("unbalanced parentheses in engine arguments")
+}
+
+func isStringLiteral(arg string) bool {
+	return len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\''
+}
+
+func unquoteStringLiteral(arg string) string {
+	return strings.NewReplacer("\\\\", "\\", "\\'", "'").Replace(arg[1 : len(arg)-1])
+}
+
+func quoteStringLiteral(s string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s) + "'"
+}
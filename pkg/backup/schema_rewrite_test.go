@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertReplicatedEngine(t *testing.T) {
+	cfg := config.DefaultConfig()
+	testData := []struct {
+		engine   string
+		query    string
+		expected string
+	}{
+		{
+			"merge_tree",
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+			"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			"merge_tree",
+			"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/zk/t', 'r1', v) ORDER BY id",
+			"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplacingMergeTree(v) ORDER BY id",
+		},
+		{
+			"merge_tree",
+			"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree(v) ORDER BY id",
+			"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplacingMergeTree(v) ORDER BY id",
+		},
+		{
+			"merge_tree",
+			"CREATE TABLE db.t (d Date, id UInt64) ENGINE = ReplicatedMergeTree('/zk/(t)', 'r\\'1', d, (d, id), 8192)",
+			"CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree(d, (d, id), 8192)",
+		},
+		{
+			"merge_tree",
+			"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+			"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			"replicated",
+			"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+		},
+		{
+			"replicated",
+			"CREATE TABLE db.t (id UInt64, s Int8) ENGINE = CollapsingMergeTree(s) ORDER BY id",
+			"CREATE TABLE db.t (id UInt64, s Int8) ENGINE = ReplicatedCollapsingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', s) ORDER BY id",
+		},
+		{
+			"replicated",
+			"CREATE MATERIALIZED VIEW db.t (id UInt64) ENGINE = SummingMergeTree() ORDER BY id AS SELECT id FROM db.src",
+			"CREATE MATERIALIZED VIEW db.t (id UInt64) ENGINE = ReplicatedSummingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id AS SELECT id FROM db.src",
+		},
+		{
+			"replicated",
+			"CREATE TABLE db.t (id UInt64) ENGINE = Memory",
+			"CREATE TABLE db.t (id UInt64) ENGINE = Memory",
+		},
+	}
+	for _, tc := range testData {
+		cfg.General.RestoreReplicatedEngine = tc.engine
+		actual, err := convertReplicatedEngine(tc.query, "db", "t", cfg.General)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+	_, err := convertReplicatedEngine("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/zk', 'r' ORDER BY id", "db", "t", cfg.General)
+	assert.Error(t, err)
+}
+
+func TestApplySchemaRewrites(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreTableMapping = map[string]string{"db.t": "t_copy"}
+	cfg.General.RestoreReplicatedEngine = "replicated"
+	cfg.General.RestoreReplicatedZookeeperPath = "/zk/{database}.{table}"
+	result, err := applySchemaRewrites(cfg, ListOfTables{{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE `db`.`t_copy` (id UInt64) ENGINE = ReplicatedMergeTree('/zk/db.t_copy', '{replica}') ORDER BY id", result[0].Query)
+}
+
+func TestRewriteReplicatedMacros(t *testing.T) {
+	sourceMacros := map[string]string{"shard": "01", "replica": "ch-src-1", "layer": "l1", "cluster": "01"}
+	testData := []struct {
+		query    string
+		macros   map[string]string
+		override map[string]string
+		expected string
+	}{
+		{
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/l1/tables/01/db/t01', 'ch-src-1') ORDER BY id",
+			sourceMacros, nil,
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/{layer}/tables/{shard}/db/t01', '{replica}') ORDER BY id",
+		},
+		{
+			"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/01/db/t', 'ch-src-1', v) ORDER BY id",
+			sourceMacros, map[string]string{"shard": "02"},
+			"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/02/db/t', '{replica}', v) ORDER BY id",
+		},
+		{
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/01/db/t', 'ch-src-1') ORDER BY id",
+			nil, nil,
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/01/db/t', '{replica}') ORDER BY id",
+		},
+		{
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+			sourceMacros, nil,
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+		},
+		{
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id",
+			sourceMacros, nil,
+			"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id",
+		},
+		{
+			"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+			sourceMacros, nil,
+			"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+	}
+	for _, tc := range testData {
+		actual, err := rewriteReplicatedMacros(tc.query, tc.macros, tc.override)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+}
+
+func TestRewriteStoragePolicy(t *testing.T) {
+	mapping := map[string]string{"hot_cold": "default", "s3": "s3_new"}
+	assert.Equal(t,
+		"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS index_granularity = 8192, storage_policy = 'default'",
+		rewriteStoragePolicy("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS index_granularity = 8192, storage_policy = 'hot_cold'", mapping),
+	)
+	assert.Equal(t,
+		"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy='ssd'",
+		rewriteStoragePolicy("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy='ssd'", mapping),
+	)
+	assert.Equal(t,
+		"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+		rewriteStoragePolicy("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id", mapping),
+	)
+}
+
+func TestStripTTL(t *testing.T) {
+	testData := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"CREATE TABLE db.t (d Date, v String TTL d + toIntervalDay(1)) ENGINE = MergeTree ORDER BY d TTL d + toIntervalMonth(1) SETTINGS index_granularity = 8192",
+			"CREATE TABLE db.t (d Date, v String) ENGINE = MergeTree ORDER BY d SETTINGS index_granularity = 8192",
+		},
+		{
+			"CREATE TABLE db.t (d Date, v String TTL d + toIntervalDay(1), w String CODEC(ZSTD(1)) TTL d + toIntervalDay(2), `TTL` String) ENGINE = ReplicatedMergeTree('/zk/t', '{replica}') ORDER BY d TTL d + toIntervalDay(7) TO VOLUME 'cold', d + toIntervalMonth(1)",
+			"CREATE TABLE db.t (d Date, v String, w String CODEC(ZSTD(1)), `TTL` String) ENGINE = ReplicatedMergeTree('/zk/t', '{replica}') ORDER BY d",
+		},
+		{
+			"CREATE MATERIALIZED VIEW db.mv (d Date) ENGINE = MergeTree ORDER BY d TTL d + toIntervalDay(1) AS SELECT d FROM db.t",
+			"CREATE MATERIALIZED VIEW db.mv (d Date) ENGINE = MergeTree ORDER BY d AS SELECT d FROM db.t",
+		},
+		{
+			"CREATE TABLE db.t (d Date) ENGINE = Log",
+			"CREATE TABLE db.t (d Date) ENGINE = Log",
+		},
+	}
+	for _, tc := range testData {
+		assert.Equal(t, tc.expected, stripTTL(tc.query))
+	}
+}
+
+func TestOverrideTableSettings(t *testing.T) {
+	settings := map[string]string{"storage_policy": "'default'", "index_granularity": "1024"}
+	testData := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"CREATE TABLE db.t (d Date) ENGINE = MergeTree ORDER BY d SETTINGS index_granularity = 8192, min_bytes_for_wide_part = 0",
+			"CREATE TABLE db.t (d Date) ENGINE = MergeTree ORDER BY d SETTINGS index_granularity = 1024, min_bytes_for_wide_part = 0, storage_policy = 'default'",
+		},
+		{
+			"CREATE TABLE db.t (d Date) ENGINE = MergeTree ORDER BY d",
+			"CREATE TABLE db.t (d Date) ENGINE = MergeTree ORDER BY d SETTINGS index_granularity = 1024, storage_policy = 'default'",
+		},
+		{
+			"CREATE TABLE db.t (d Date) ENGINE = MergeTree ORDER BY d SETTINGS storage_policy = 'hot_cold' COMMENT 'table, with settings'",
+			"CREATE TABLE db.t (d Date) ENGINE = MergeTree ORDER BY d SETTINGS storage_policy = 'default', index_granularity = 1024 COMMENT 'table, with settings'",
+		},
+		{
+			"CREATE MATERIALIZED VIEW db.mv (d Date) ENGINE = MergeTree ORDER BY d AS SELECT d FROM db.t SETTINGS max_threads = 1",
+			"CREATE MATERIALIZED VIEW db.mv (d Date) ENGINE = MergeTree ORDER BY d SETTINGS index_granularity = 1024, storage_policy = 'default' AS SELECT d FROM db.t SETTINGS max_threads = 1",
+		},
+		{
+			"CREATE TABLE db.t (d Date) ENGINE = Kafka SETTINGS kafka_broker_list = 'localhost:9092'",
+			"CREATE TABLE db.t (d Date) ENGINE = Kafka SETTINGS kafka_broker_list = 'localhost:9092'",
+		},
+	}
+	for _, tc := range testData {
+		assert.Equal(t, tc.expected, overrideTableSettings(tc.query, settings))
+	}
+}
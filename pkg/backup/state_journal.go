@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	apexLog "github.com/apex/log"
+)
+
+// stateJournal - progress of upload or download inside local backup folder, each entry appended as JSON line,
+// so interrupted write lose only the last entry
+type stateJournal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openStateJournal - when resume pass each entry written before to apply, otherwise start new journal
+func openStateJournal(filePath string, resume bool, apply func(line []byte) error) (*stateJournal, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		if err := readStateJournal(filePath, apply); err != nil {
+			return nil, err
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(filePath, flags, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %v", filePath, err)
+	}
+	return &stateJournal{file: f}, nil
+}
+
+func readStateJournal(filePath string, apply func(line []byte) error) error {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't read %s: %v", filePath, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := apply(scanner.Bytes()); err != nil {
+			apexLog.Warnf("can't parse %s, ignore rest of file: %v", filePath, err)
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("can't read %s: %v", filePath, err)
+	}
+	return nil
+}
+
+func (j *stateJournal) append(entry interface{}) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err = j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("can't write %s: %v", j.file.Name(), err)
+	}
+	return j.file.Sync()
+}
+
+func (j *stateJournal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+// storagePolicyProblems - storage policies from SETTINGS of restored tables which don't exist on destination server,
+// shall be called after `restore_storage_policy_mapping` is applied, tables without `storage_policy` use `default` policy which always exists
+func storagePolicyProblems(tables ListOfTables, serverPolicies map[string][]string, backupPolicies map[string][]string) []string {
+	missing := map[string][]metadata.TableTitle{}
+	for _, t := range tables {
+		for _, match := range storagePolicyRE.FindAllStringSubmatch(t.Query, -1) {
+			if _, exists := serverPolicies[match[2]]; exists || match[2] == "default" {
+				continue
+			}
+			missing[match[2]] = append(missing[match[2]], metadata.TableTitle{Database: t.Database, Table: t.Table})
+		}
+	}
+	policies := make([]string, 0, len(missing))
+	for policy := range missing {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	problems := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		tableNames := make([]string, len(missing[policy]))
+		for i, t := range missing[policy] {
+			tableNames[i] = fmt.Sprintf("`%s`.`%s`", t.Database, t.Table)
+		}
+		msg := fmt.Sprintf("storage policy '%s' of %s doesn't exist", policy, strings.Join(tableNames, ", "))
+		if disks, exists := backupPolicies[policy]; exists {
+			msg += fmt.Sprintf(", disks in backup: %s", strings.Join(disks, ", "))
+		}
+		problems = append(problems, msg+", create it or use `restore_storage_policy_mapping`")
+	}
+	return problems
+}
@@ -0,0 +1,29 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoragePolicyProblems(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "hot", Query: "CREATE TABLE db.hot (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy = 'hot_and_cold', index_granularity = 8192"},
+		{Database: "db", Table: "tiered", Query: "CREATE TABLE db.tiered (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy = 'tiered'"},
+		{Database: "db", Table: "tiered2", Query: "CREATE TABLE db.tiered2 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy = 'tiered'"},
+		{Database: "db", Table: "plain", Query: "CREATE TABLE db.plain (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy = 'default'"},
+		{Database: "db", Table: "no_policy", Query: "CREATE TABLE db.no_policy (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	serverPolicies := map[string][]string{"default": {"default"}, "hot_and_cold": {"default", "hdd"}}
+	backupPolicies := map[string][]string{"tiered": {"default", "s3"}}
+	assert.Equal(t, []string{
+		"storage policy 'tiered' of `db`.`tiered`, `db`.`tiered2` doesn't exist, disks in backup: default, s3, create it or use `restore_storage_policy_mapping`",
+	}, storagePolicyProblems(tables, serverPolicies, backupPolicies))
+
+	assert.Equal(t, []string{
+		"storage policy 'hot_and_cold' of `db`.`hot` doesn't exist, create it or use `restore_storage_policy_mapping`",
+		"storage policy 'tiered' of `db`.`tiered`, `db`.`tiered2` doesn't exist, create it or use `restore_storage_policy_mapping`",
+	}, storagePolicyProblems(tables, map[string][]string{}, nil))
+
+	assert.Empty(t, storagePolicyProblems(tables[3:], map[string][]string{}, nil))
+}
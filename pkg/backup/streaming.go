@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"regexp"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+// streamingEngines - tables with these engines start consuming from external queue as soon as materialized view reads from them
+var streamingEngines = []string{"Kafka", "RabbitMQ"}
+
+var selectSourceRE = regexp.MustCompile("(?is)\\b(?:FROM|JOIN)\\s+(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)(?:\\s*\\.\\s*(`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+))?")
+
+// streamingConsumers - materialized views from tables which read from restored Kafka or RabbitMQ tables,
+// source without database is resolved to database of materialized view
+func streamingConsumers(tables ListOfTables) map[metadata.TableTitle]bool {
+	streamingTables := map[metadata.TableTitle]bool{}
+	for _, t := range tables {
+		if clickhouse.IsTableEngineInList(getEngineFromQuery(t.Query), streamingEngines) {
+			streamingTables[metadata.TableTitle{Database: t.Database, Table: t.Table}] = true
+		}
+	}
+	consumers := map[metadata.TableTitle]bool{}
+	if len(streamingTables) == 0 {
+		return consumers
+	}
+	for _, t := range tables {
+		if getEngineFromQuery(t.Query) != "MaterializedView" {
+			continue
+		}
+		for _, match := range selectSourceRE.FindAllStringSubmatch(t.Query, -1) {
+			source := metadata.TableTitle{Database: t.Database, Table: unquoteIdentifier(match[1])}
+			if match[2] != "" {
+				source = metadata.TableTitle{Database: unquoteIdentifier(match[1]), Table: unquoteIdentifier(match[2])}
+			}
+			if streamingTables[source] {
+				consumers[metadata.TableTitle{Database: t.Database, Table: t.Table}] = true
+				break
+			}
+		}
+	}
+	return consumers
+}
@@ -0,0 +1,25 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingConsumers(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "queue", Query: "CREATE TABLE db.queue (id UInt64) ENGINE = Kafka SETTINGS kafka_broker_list = 'kafka:9092', kafka_topic_list = 't', kafka_group_name = 'g', kafka_format = 'JSONEachRow'"},
+		{Database: "db", Table: "rabbit", Query: "CREATE TABLE db.rabbit (id UInt64) ENGINE = RabbitMQ SETTINGS rabbitmq_host_port = 'rabbitmq:5672', rabbitmq_exchange_name = 'e', rabbitmq_format = 'JSONEachRow'"},
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "mv_kafka", Query: "CREATE MATERIALIZED VIEW db.mv_kafka TO db.t (`id` UInt64) AS SELECT id FROM db.queue"},
+		{Database: "db", Table: "mv_rabbit", Query: "CREATE MATERIALIZED VIEW db.mv_rabbit TO db.t (`id` UInt64) AS SELECT id FROM `rabbit`"},
+		{Database: "other", Table: "mv_queue", Query: "CREATE MATERIALIZED VIEW other.mv_queue TO db.t (`id` UInt64) AS SELECT id FROM queue"},
+		{Database: "db", Table: "mv_table", Query: "CREATE MATERIALIZED VIEW db.mv_table TO db.t (`id` UInt64) AS SELECT id FROM db.t"},
+	}
+	assert.Equal(t, map[metadata.TableTitle]bool{
+		{Database: "db", Table: "mv_kafka"}:  true,
+		{Database: "db", Table: "mv_rabbit"}: true,
+	}, streamingConsumers(tables))
+	assert.Empty(t, streamingConsumers(tables[2:]))
+}
@@ -1,20 +1,23 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/common"
 	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
-	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
 	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
 )
 
 type ListOfTables []metadata.TableMetadata
@@ -109,27 +112,29 @@ func getTableListByPatternLocal(metadataPath string, tablePattern string, skipTa
 	return result, nil
 }
 
+// filterPartsByPartitionsFilter - keep only parts of partitions which apply to table, tableMetadata.Parts map is changed in place
 func filterPartsByPartitionsFilter(tableMetadata metadata.TableMetadata, partitionsFilter common.EmptyMap) {
-	if len(partitionsFilter) > 0 {
+	tablePartitionsFilter := filesystemhelper.GetPartitionsFilterForTable(partitionsFilter, tableMetadata.Database, tableMetadata.Table)
+	if len(tablePartitionsFilter) > 0 {
 		for disk, parts := range tableMetadata.Parts {
-			for i, part := range parts {
-				if !filesystemhelper.IsPartInPartition(part.Name, partitionsFilter) {
-					parts = append(parts[:i], parts[i+1:]...)
+			filteredParts := make([]metadata.Part, 0, len(parts))
+			for _, part := range parts {
+				if filesystemhelper.IsPartInPartition(part.Name, tablePartitionsFilter) {
+					filteredParts = append(filteredParts, part)
 				}
 			}
-			tableMetadata.Parts[disk] = parts
+			tableMetadata.Parts[disk] = filteredParts
 		}
 	}
 }
 
-func getTableListByPatternRemote(b *Backuper, remoteBackupMetadata *metadata.BackupMetadata, tablePattern string, skipTables []string, dropTable bool) (ListOfTables, error) {
+func getTableListByPatternRemote(ctx context.Context, b *Backuper, remoteBackupMetadata *metadata.BackupMetadata, tablePattern string, skipTables []string, dropTable bool) (ListOfTables, error) {
 	result := ListOfTables{}
 	tablePatterns := []string{"*"}
 
 	if tablePattern != "" {
 		tablePatterns = strings.Split(tablePattern, ",")
 	}
-	metadataPath := path.Join(remoteBackupMetadata.BackupName, "metadata")
 	for _, t := range remoteBackupMetadata.Tables {
 		if IsInformationSchema(t.Database) {
 			continue
@@ -145,24 +150,11 @@ func getTableListByPatternRemote(b *Backuper, remoteBackupMetadata *metadata.Bac
 			if matched, _ := filepath.Match(strings.Trim(p, " \t\r\n"), tableName); !matched || shallSkipped {
 				continue
 			}
-			tmReader, err := b.dst.GetFileReader(path.Join(metadataPath, common.TablePathEncode(t.Database), fmt.Sprintf("%s.json", common.TablePathEncode(t.Table))))
+			tm, err := b.readRemoteTableMetadata(ctx, remoteBackupMetadata.BackupName, t)
 			if err != nil {
 				return nil, err
 			}
-			data, err := io.ReadAll(tmReader)
-			if err != nil {
-				return nil, err
-			}
-			err = tmReader.Close()
-			if err != nil {
-				return nil, err
-			}
-
-			var t metadata.TableMetadata
-			if err = json.Unmarshal(data, &t); err != nil {
-				return nil, err
-			}
-			result = addTableToListIfNotExists(result, t)
+			result = addTableToListIfNotExists(result, *tm)
 			break
 		}
 	}
@@ -170,7 +162,34 @@ func getTableListByPatternRemote(b *Backuper, remoteBackupMetadata *metadata.Bac
 	return result, nil
 }
 
+// filterTablesByEngines - exclude tables with engine from `skip_table_engines`, engine is detected from create query stored in backup
+func filterTablesByEngines(tables ListOfTables, skipEngines []string) ListOfTables {
+	if len(skipEngines) == 0 {
+		return tables
+	}
+	result := make(ListOfTables, 0, len(tables))
+	for _, t := range tables {
+		if engine := getEngineFromQuery(t.Query); clickhouse.IsTableEngineInList(engine, skipEngines) {
+			apexLog.Infof("skip %s.%s with %s engine", t.Database, t.Table, engine)
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
 func getOrderByEngine(query string, dropTable bool) int64 {
+	// LIVE VIEW and WINDOW VIEW could select from any other object, so they are created last and dropped first
+	if strings.HasPrefix(query, "CREATE LIVE VIEW") ||
+		strings.HasPrefix(query, "ATTACH LIVE VIEW") ||
+		strings.HasPrefix(query, "CREATE WINDOW VIEW") ||
+		strings.HasPrefix(query, "ATTACH WINDOW VIEW") {
+		if dropTable {
+			return 1
+		} else {
+			return 5
+		}
+	}
 	if strings.Contains(query, "ENGINE = Distributed") || strings.Contains(query, "ENGINE = Kafka") || strings.Contains(query, "ENGINE = RabbitMQ") {
 		return 4
 	}
@@ -178,9 +197,6 @@ func getOrderByEngine(query string, dropTable bool) int64 {
 		return 3
 	}
 	if strings.HasPrefix(query, "CREATE VIEW") ||
-		strings.HasPrefix(query, "CREATE LIVE VIEW") ||
-		strings.HasPrefix(query, "CREATE WINDOW VIEW") ||
-		strings.HasPrefix(query, "ATTACH WINDOW VIEW") ||
 		strings.HasPrefix(query, "CREATE MATERIALIZED VIEW") ||
 		strings.HasPrefix(query, "ATTACH MATERIALIZED VIEW") {
 		if dropTable {
@@ -201,6 +217,33 @@ func getOrderByEngine(query string, dropTable bool) int64 {
 	return 0
 }
 
+var queryEngineRE = regexp.MustCompile(`(?is)^\s*(?:CREATE|ATTACH)\s+(?:OR\s+REPLACE\s+)?(MATERIALIZED\s+VIEW|LIVE\s+VIEW|WINDOW\s+VIEW|VIEW|DICTIONARY|TABLE)\b`)
+var tableEngineRE = regexp.MustCompile(`(?i)\bENGINE\s*=\s*(\w+)`)
+
+// getEngineFromQuery - engine name the same as system.tables engine column for create query stored in backup metadata
+func getEngineFromQuery(query string) string {
+	kind := queryEngineRE.FindStringSubmatch(query)
+	if kind == nil {
+		return ""
+	}
+	switch strings.ToUpper(strings.Join(strings.Fields(kind[1]), " ")) {
+	case "MATERIALIZED VIEW":
+		return "MaterializedView"
+	case "LIVE VIEW":
+		return "LiveView"
+	case "WINDOW VIEW":
+		return "WindowView"
+	case "VIEW":
+		return "View"
+	case "DICTIONARY":
+		return "Dictionary"
+	}
+	if engine := tableEngineRE.FindStringSubmatch(query); engine != nil {
+		return engine[1]
+	}
+	return ""
+}
+
 func parseTablePatternForDownload(tables []metadata.TableTitle, tablePattern string) []metadata.TableTitle {
 	tablePatterns := []string{"*"}
 	if tablePattern != "" {
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEngineFromQuery(t *testing.T) {
+	assert.Equal(t, "ReplicatedMergeTree", getEngineFromQuery("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/{shard}/t', '{replica}') ORDER BY id"))
+	assert.Equal(t, "MergeTree", getEngineFromQuery("ATTACH TABLE t\n(\n    `id` UInt64\n)\nENGINE=MergeTree ORDER BY id"))
+	assert.Equal(t, "MaterializedView", getEngineFromQuery("CREATE MATERIALIZED VIEW db.mv TO db.t AS SELECT * FROM db.src"))
+	assert.Equal(t, "View", getEngineFromQuery("CREATE OR REPLACE VIEW db.v AS SELECT 1"))
+	assert.Equal(t, "LiveView", getEngineFromQuery("CREATE LIVE VIEW db.lv AS SELECT 1"))
+	assert.Equal(t, "Dictionary", getEngineFromQuery("CREATE DICTIONARY db.d (id UInt64) PRIMARY KEY id SOURCE(NULL()) LAYOUT(FLAT()) LIFETIME(0)"))
+	assert.Equal(t, "", getEngineFromQuery(""))
+}
+
+func TestSortLiveAndWindowViews(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "lv", Query: "CREATE LIVE VIEW db.lv AS SELECT count() FROM db.dist"},
+		{Database: "db", Table: "wv", Query: "ATTACH WINDOW VIEW db.wv TO db.t AS SELECT count() FROM db.kafka GROUP BY tumble(ts, toIntervalSecond(10))"},
+		{Database: "db", Table: "dist", Query: "CREATE TABLE db.dist (id UInt64) ENGINE = Distributed('cluster', 'db', 't')"},
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	tables.Sort(false)
+	assert.Equal(t, []string{"t", "dist"}, []string{tables[0].Table, tables[1].Table})
+	assert.ElementsMatch(t, []string{"lv", "wv"}, []string{tables[2].Table, tables[3].Table})
+	tables.Sort(true)
+	assert.ElementsMatch(t, []string{"lv", "wv"}, []string{tables[1].Table, tables[2].Table})
+	assert.Equal(t, "dist", tables[3].Table)
+}
+
+func TestFilterTablesByEngines(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "lv", Query: "CREATE LIVE VIEW db.lv AS SELECT 1"},
+		{Database: "db", Table: "wv", Query: "ATTACH WINDOW VIEW db.wv TO db.t AS SELECT 1"},
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	assert.Equal(t, tables, filterTablesByEngines(tables, nil))
+	assert.Equal(t, ListOfTables{tables[2]}, filterTablesByEngines(tables, []string{"liveview", " WindowView"}))
+	assert.Equal(t, ListOfTables{tables[0], tables[1]}, filterTablesByEngines(tables, []string{"MergeTree"}))
+}
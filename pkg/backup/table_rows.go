@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// applyPartsStats - rows of each backed up part and totals of table from system.parts, parts absent in stats keep zero rows
+func applyPartsStats(table *metadata.TableMetadata, stats []clickhouse.PartStats) {
+	byName := make(map[string]clickhouse.PartStats, len(stats))
+	for _, s := range stats {
+		byName[s.Name] = s
+	}
+	for disk := range table.Parts {
+		for i := range table.Parts[disk] {
+			s, exists := byName[table.Parts[disk][i].Name]
+			if !exists {
+				continue
+			}
+			table.Parts[disk][i].Rows = s.Rows
+			table.Rows += s.Rows
+			table.UncompressedBytes += s.DataUncompressedBytes
+			table.CompressedBytes += s.DataCompressedBytes
+		}
+	}
+}
+
+// expectedRestoredRows - rows of parts which are restored, false when backup was created without parts stats
+func expectedRestoredRows(table metadata.TableMetadata) (uint64, bool) {
+	if table.Rows == 0 && table.CompressedBytes == 0 {
+		return 0, false
+	}
+	rows := uint64(0)
+	for disk := range table.Parts {
+		for _, part := range table.Parts[disk] {
+			rows += part.Rows
+		}
+	}
+	return rows, true
+}
+
+// validateRestoredRows - with `restore_validate` count() of restored table shall be not less than rows of restored parts,
+// more rows are possible when table contained data before restore
+func validateRestoredRows(ch *clickhouse.ClickHouse, table, dstTable metadata.TableMetadata, log *apexLog.Entry) error {
+	expected, exists := expectedRestoredRows(table)
+	if !exists {
+		log.Warn("backup doesn't contain rows count, validation skipped")
+		return nil
+	}
+	actual, err := ch.GetTableRowsCount(dstTable.Database, dstTable.Table)
+	if err != nil {
+		return err
+	}
+	if actual < expected {
+		return fmt.Errorf("expected %d rows, actual %d", expected, actual)
+	}
+	if actual > expected {
+		log.Warnf("table contains %d rows, %d rows were restored, table wasn't empty before restore", actual, expected)
+	}
+	log.WithField("rows", actual).Info("validated")
+	return nil
+}
+
+// restoreValidationError - summary of tables with failed validation, nil when all tables are valid
+func restoreValidationError(problems []string, tablesCount int) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d restored tables failed validation: %s", len(problems), tablesCount, strings.Join(problems, "; "))
+}
@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyPartsStats(t *testing.T) {
+	table := metadata.TableMetadata{Parts: map[string][]metadata.Part{
+		"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}},
+		"hdd":     {{Name: "all_3_3_0"}},
+	}}
+	applyPartsStats(&table, []clickhouse.PartStats{
+		{Name: "all_1_1_0", Rows: 10, DataCompressedBytes: 100, DataUncompressedBytes: 1000},
+		{Name: "all_2_2_0", Rows: 5, DataCompressedBytes: 50, DataUncompressedBytes: 500},
+		{Name: "all_1_2_1", Rows: 15, DataCompressedBytes: 150, DataUncompressedBytes: 1500},
+		{Name: "all_3_3_0", Rows: 1, DataCompressedBytes: 1, DataUncompressedBytes: 1},
+	})
+	assert.Equal(t, uint64(16), table.Rows)
+	assert.Equal(t, uint64(151), table.CompressedBytes)
+	assert.Equal(t, uint64(1501), table.UncompressedBytes)
+	assert.Equal(t, uint64(5), table.Parts["default"][1].Rows)
+
+	// restore with partitions filter expects rows of filtered parts only
+	table.Parts["default"] = table.Parts["default"][:1]
+	rows, exists := expectedRestoredRows(table)
+	assert.True(t, exists)
+	assert.Equal(t, uint64(11), rows)
+	_, exists = expectedRestoredRows(metadata.TableMetadata{Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}})
+	assert.False(t, exists, "backup created without parts stats")
+}
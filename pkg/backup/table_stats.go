@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+// TableStats - per-table statistics collected during create and upload
+type TableStats struct {
+	Operation string
+	Database  string
+	Table     string
+	Size      uint64
+	Parts     int
+	Duration  time.Duration
+}
+
+var (
+	tableStatsObserverMutex sync.RWMutex
+	tableStatsObserver      func(TableStats)
+)
+
+// SetTableStatsObserver - register function which receive TableStats for each table after freeze and upload, nil disable reporting
+func SetTableStatsObserver(fn func(TableStats)) {
+	tableStatsObserverMutex.Lock()
+	defer tableStatsObserverMutex.Unlock()
+	tableStatsObserver = fn
+}
+
+func reportTableStats(stats TableStats) {
+	tableStatsObserverMutex.RLock()
+	defer tableStatsObserverMutex.RUnlock()
+	if tableStatsObserver != nil {
+		tableStatsObserver(stats)
+	}
+}
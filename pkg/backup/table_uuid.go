@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+	"github.com/google/uuid"
+)
+
+// applyTableUUIDs - UUID clause is removed for databases which don't store tables by UUID, for Atomic databases UUID is regenerated
+// when `restore_table_uuid: regenerate` or when UUID is used by another table on server, tables which will be dropped with `--rm` don't hold UUID
+func applyTableUUIDs(cfg *config.Config, tables ListOfTables, engines map[string]string, existingUUIDs map[string]metadata.TableTitle, dropTable bool) ListOfTables {
+	result := make(ListOfTables, len(tables))
+	copy(result, tables)
+	restored := make(map[metadata.TableTitle]bool, len(tables))
+	for _, t := range tables {
+		restored[metadata.TableTitle{Database: t.Database, Table: t.Table}] = true
+	}
+	for i, t := range result {
+		id := queryUUID(t.Query)
+		if id == "" {
+			continue
+		}
+		if !isAtomicDatabaseEngine(engines[t.Database]) {
+			result[i].Query = setQueryUUID(t.Query, "")
+			continue
+		}
+		owner, used := existingUUIDs[id]
+		usedByAnotherTable := used && owner != (metadata.TableTitle{Database: t.Database, Table: t.Table}) && !(dropTable && restored[owner])
+		if cfg.General.RestoreTableUUID != "regenerate" && !usedByAnotherTable {
+			continue
+		}
+		if usedByAnotherTable {
+			apexLog.Warnf("UUID %s of `%s`.`%s` is used by `%s`.`%s`, new UUID will be generated", id, t.Database, t.Table, owner.Database, owner.Table)
+		}
+		result[i].Query = setQueryUUID(t.Query, uuid.New().String())
+	}
+	return result
+}
+
+// existingTableUUIDs - tables on server by UUID, tables from Ordinary databases don't have UUID
+func existingTableUUIDs(chTables []clickhouse.Table) map[string]metadata.TableTitle {
+	uuids := make(map[string]metadata.TableTitle, len(chTables))
+	for _, t := range chTables {
+		if t.UUID != "" {
+			uuids[t.UUID] = metadata.TableTitle{Database: t.Database, Table: t.Name}
+		}
+	}
+	return uuids
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTableUUIDs(t *testing.T) {
+	cfg := config.DefaultConfig()
+	tables := ListOfTables{
+		{Database: "atomic", Table: "t", Query: "CREATE TABLE atomic.t UUID '" + testViewUUID + "' (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "ordinary", Table: "t", Query: "CREATE TABLE ordinary.t UUID 'a1b2c3d4-0000-4000-8000-000000000001' (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "atomic", Table: "no_uuid", Query: "CREATE TABLE atomic.no_uuid (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	engines := map[string]string{"atomic": "Atomic", "ordinary": "Ordinary"}
+
+	result := applyTableUUIDs(cfg, tables, engines, nil, false)
+	assert.Equal(t, tables[0].Query, result[0].Query)
+	assert.Equal(t, "CREATE TABLE ordinary.t (id UInt64) ENGINE = MergeTree ORDER BY id", result[1].Query)
+	assert.Equal(t, tables[2].Query, result[2].Query)
+
+	usedByCopy := map[string]metadata.TableTitle{testViewUUID: {Database: "atomic", Table: "t_copy"}}
+	result = applyTableUUIDs(cfg, tables, engines, usedByCopy, false)
+	assert.NotEmpty(t, queryUUID(result[0].Query))
+	assert.NotEqual(t, testViewUUID, queryUUID(result[0].Query))
+
+	usedBySame := map[string]metadata.TableTitle{testViewUUID: {Database: "atomic", Table: "t"}}
+	assert.Equal(t, tables[0].Query, applyTableUUIDs(cfg, tables, engines, usedBySame, false)[0].Query)
+
+	cfg.General.RestoreTableUUID = "regenerate"
+	result = applyTableUUIDs(cfg, tables, engines, nil, false)
+	assert.NotEqual(t, testViewUUID, queryUUID(result[0].Query))
+	assert.Equal(t, tables[2].Query, result[2].Query)
+}
+
+func TestApplyTableUUIDsDroppedOwner(t *testing.T) {
+	cfg := config.DefaultConfig()
+	tables := ListOfTables{
+		{Database: "db", Table: "a", Query: "CREATE TABLE db.a UUID '" + testViewUUID + "' (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "b", Query: "CREATE TABLE db.b (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	existing := existingTableUUIDs([]clickhouse.Table{{Database: "db", Name: "b", UUID: testViewUUID}, {Database: "db", Name: "c"}})
+	assert.Equal(t, map[string]metadata.TableTitle{testViewUUID: {Database: "db", Table: "b"}}, existing)
+	assert.Equal(t, tables[0].Query, applyTableUUIDs(cfg, tables, map[string]string{"db": "Atomic"}, existing, true)[0].Query)
+	assert.NotEqual(t, tables[0].Query, applyTableUUIDs(cfg, tables, map[string]string{"db": "Atomic"}, existing, false)[0].Query)
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+)
+
+// tablesManifestFile - gzipped JSON array with metadata of all tables, uploaded instead of `metadata/<db>/<table>.json` when `consolidate_metadata: true`
+const tablesManifestFile = "metadata.tables.json.gz"
+
+// tablesManifestCache - table metadata of remote backups with manifest, nil value means backup without manifest,
+// tables are kept as raw JSON, so each read returns new copy which could be filtered by caller
+type tablesManifestCache struct {
+	mu        sync.Mutex
+	manifests map[string]map[metadata.TableTitle]json.RawMessage
+}
+
+func encodeTablesManifest(tables []metadata.TableMetadata) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(gz).Encode(tables); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeTablesManifest(r io.Reader) (map[metadata.TableTitle]json.RawMessage, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	var tables []json.RawMessage
+	if err := json.NewDecoder(gz).Decode(&tables); err != nil {
+		return nil, err
+	}
+	result := make(map[metadata.TableTitle]json.RawMessage, len(tables))
+	for _, body := range tables {
+		var title metadata.TableTitle
+		if err := json.Unmarshal(body, &title); err != nil {
+			return nil, err
+		}
+		result[title] = body
+	}
+	return result, nil
+}
+
+// uploadTablesManifest - metadata of all tables as one object, returns uploaded size
+func (b *Backuper) uploadTablesManifest(ctx context.Context, backupName string, tables []metadata.TableMetadata) (int64, error) {
+	body, err := encodeTablesManifest(tables)
+	if err != nil {
+		return 0, fmt.Errorf("can't encode %s: %v", tablesManifestFile, err)
+	}
+	if err := b.dst.PutFile(ctx, path.Join(backupName, tablesManifestFile), ioutil.NopCloser(bytes.NewReader(body))); err != nil {
+		return 0, fmt.Errorf("can't upload %s: %v", tablesManifestFile, err)
+	}
+	return int64(len(body)), nil
+}
+
+// remoteTablesManifest - metadata.json of remote backup is read once to check `tables_manifest`, manifest is downloaded once for all tables
+func (b *Backuper) remoteTablesManifest(ctx context.Context, backupName string) (map[metadata.TableTitle]json.RawMessage, error) {
+	b.tablesManifests.mu.Lock()
+	defer b.tablesManifests.mu.Unlock()
+	if manifest, exists := b.tablesManifests.manifests[backupName]; exists {
+		return manifest, nil
+	}
+	var backupMetadata metadata.BackupMetadata
+	body, err := b.readRemoteFile(ctx, path.Join(backupName, "metadata.json"))
+	if err != nil && !errors.Is(err, new_storage.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(body, &backupMetadata); err != nil {
+			return nil, fmt.Errorf("can't parse %s/metadata.json: %v", backupName, err)
+		}
+	}
+	var manifest map[metadata.TableTitle]json.RawMessage
+	if backupMetadata.TablesManifest != "" {
+		r, err := b.dst.GetFileReader(ctx, path.Join(backupName, backupMetadata.TablesManifest))
+		if err != nil {
+			return nil, err
+		}
+		manifest, err = decodeTablesManifest(r)
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s/%s: %v", backupName, backupMetadata.TablesManifest, err)
+		}
+	}
+	if b.tablesManifests.manifests == nil {
+		b.tablesManifests.manifests = map[string]map[metadata.TableTitle]json.RawMessage{}
+	}
+	b.tablesManifests.manifests[backupName] = manifest
+	return manifest, nil
+}
+
+// expandTablesManifest - local backup keeps metadata of each table in `metadata/<db>/<table>.json`, manifest is removed after expand
+func expandTablesManifest(backupPath, manifestName string) error {
+	manifestPath := path.Join(backupPath, manifestName)
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return err
+	}
+	manifest, err := decodeTablesManifest(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("can't read %s: %v", manifestPath, err)
+	}
+	for title, body := range manifest {
+		metadataDir := path.Join(backupPath, "metadata", common.TablePathEncode(title.Database))
+		if err := os.MkdirAll(metadataDir, 0750); err != nil {
+			return err
+		}
+		if err := metadata.WriteFileAtomic(path.Join(metadataDir, common.TablePathEncode(title.Table)+".json"), body, 0640); err != nil {
+			return err
+		}
+	}
+	return os.Remove(manifestPath)
+}
+
+func (b *Backuper) readRemoteFile(ctx context.Context, key string) ([]byte, error) {
+	r, err := b.dst.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return body, err
+}
@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTablesManifest(t *testing.T) {
+	ctx := context.Background()
+	storage := &memoryRemoteStorage{fakeRemoteStorage: fakeRemoteStorage{kind: "S3"}, objects: map[string][]byte{}}
+	b := &Backuper{cfg: config.DefaultConfig(), dst: &new_storage.BackupDestination{RemoteStorage: storage}}
+	tables := []metadata.TableMetadata{
+		{Database: "db", Table: "t1", Query: "CREATE TABLE db.t1", Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}},
+		{Database: "db", Table: "t-2", Query: "CREATE TABLE db.`t-2`"},
+	}
+	size, err := b.uploadTablesManifest(ctx, "consolidated", tables)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(storage.objects["consolidated/"+tablesManifestFile])), size)
+	body, err := json.Marshal(metadata.BackupMetadata{BackupName: "consolidated", TablesManifest: tablesManifestFile})
+	require.NoError(t, err)
+	storage.objects["consolidated/metadata.json"] = body
+
+	tm, err := b.readRemoteTableMetadata(ctx, "consolidated", metadata.TableTitle{Database: "db", Table: "t1"})
+	require.NoError(t, err)
+	assert.Equal(t, tables[0], *tm)
+	// each read returns new copy
+	tm.Parts["default"] = nil
+	tm, err = b.readRemoteTableMetadata(ctx, "consolidated", metadata.TableTitle{Database: "db", Table: "t1"})
+	require.NoError(t, err)
+	assert.Len(t, tm.Parts["default"], 1)
+	_, err = b.readRemoteTableMetadata(ctx, "consolidated", metadata.TableTitle{Database: "db", Table: "absent"})
+	assert.EqualError(t, err, "db.absent is not found in metadata.tables.json.gz of 'consolidated'")
+
+	// backup without manifest
+	storage.objects["plain/metadata.json"] = []byte(`{"backup_name":"plain"}`)
+	storage.objects["plain/metadata/db/t%2D2.json"] = []byte(`{"database":"db","table":"t-2","query":"CREATE TABLE db.t2"}`)
+	tm, err = b.readRemoteTableMetadata(ctx, "plain", metadata.TableTitle{Database: "db", Table: "t-2"})
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE db.t2", tm.Query)
+
+	// manifest is expanded by import of archive
+	backupPath := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(path.Join(backupPath, tablesManifestFile), storage.objects["consolidated/"+tablesManifestFile], 0640))
+	require.NoError(t, expandTablesManifest(backupPath, tablesManifestFile))
+	assert.NoFileExists(t, path.Join(backupPath, tablesManifestFile))
+	var local metadata.TableMetadata
+	_, err = local.Load(path.Join(backupPath, "metadata", "db", "t%2D2.json"))
+	require.NoError(t, err)
+	assert.Equal(t, tables[1], local)
+	_, err = os.Stat(path.Join(backupPath, "metadata", "db", "t1.json"))
+	assert.NoError(t, err)
+}
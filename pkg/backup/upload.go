@@ -3,9 +3,12 @@ package backup
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -21,13 +24,17 @@ import (
 	"github.com/mxalis/clickhouse-backup/pkg/common"
 	"github.com/mxalis/clickhouse-backup/pkg/filesystemhelper"
 	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
 	"github.com/mxalis/clickhouse-backup/pkg/utils"
 	apexLog "github.com/apex/log"
 	"github.com/yargevad/filepathx"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly bool) error {
-	var err error
+func (b *Backuper) Upload(ctx context.Context, backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool) (err error) {
+	ctx, span := tracing.Start(ctx, "upload", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
 	var disks []clickhouse.Disk
 	if err = b.validateUploadParams(backupName, diffFrom, diffFromRemote); err != nil {
 		return err
@@ -44,22 +51,39 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	if _, disks, err = getLocalBackup(b.cfg, backupName, nil); err != nil {
 		return fmt.Errorf("can't upload: %v", err)
 	}
-	if err := b.init(disks); err != nil {
+	if err := b.init(ctx, disks); err != nil {
 		return err
 	}
-	remoteBackups, err := b.dst.BackupList(false, "")
+	remoteBackups, err := b.dst.BackupList(ctx, false, "")
 	if err != nil {
 		return err
 	}
 	for i := range remoteBackups {
 		if backupName == remoteBackups[i].BackupName {
-			return fmt.Errorf("'%s' already exists on remote", backupName)
+			if !resume {
+				return fmt.Errorf("'%s' already exists on remote", backupName)
+			}
+			if err := b.validateUploadResume(ctx, backupName); err != nil {
+				return err
+			}
+			log.Infof("'%s' already exists on remote, only missing files will upload", backupName)
 		}
 	}
 	backupMetadata, err := b.ReadBackupMetadataLocal(backupName)
 	if err != nil {
 		return err
 	}
+	b.dst.SetBackupTime(backupName, backupMetadata.CreationDate)
+	uploadStatePath := path.Join(b.DefaultDataPath, "backup", backupName, uploadStateFile)
+	state, err := openUploadState(uploadStatePath, resume)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := state.Close(); closeErr != nil {
+			log.Warnf("can't close %s: %v", uploadStatePath, closeErr)
+		}
+	}()
 	var tablesForUpload ListOfTables
 	partitionsToUploadMap := filesystemhelper.CreatePartitionsToBackupMap(partitions)
 	if len(backupMetadata.Tables) != 0 {
@@ -77,7 +101,7 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		}
 	}
 	if diffFromRemote != "" {
-		tablesForUploadFromDiff, err = b.getTablesForUploadDiffRemote(diffFromRemote, backupMetadata, tablePattern, b.cfg.ClickHouse.SkipTables)
+		tablesForUploadFromDiff, err = b.getTablesForUploadDiffRemote(ctx, diffFromRemote, backupMetadata, tablePattern, b.cfg.ClickHouse.SkipTables)
 		if err != nil {
 			return err
 		}
@@ -86,11 +110,12 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	compressedDataSize := int64(0)
 	metadataSize := int64(0)
 
-	log.Debugf("prepare table concurrent semaphore with concurrency=%d len(tablesForUpload)=%d", b.cfg.General.UploadConcurrency, len(tablesForUpload))
-	s := semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
-	g, ctx := errgroup.WithContext(context.Background())
+	log.Debugf("prepare table concurrent semaphore with concurrency=%d len(tablesForUpload)=%d", b.cfg.GetUploadConcurrency(), len(tablesForUpload))
+	s := semaphore.NewWeighted(int64(b.cfg.GetUploadConcurrency()))
+	g, ctx := errgroup.WithContext(ctx)
 
-	for i, table := range tablesForUpload {
+	for _, i := range uploadOrder(tablesForUpload, b.cfg.General.UploadOrder) {
+		table := tablesForUpload[i]
 		if err := s.Acquire(ctx, 1); err != nil {
 			log.Errorf("can't acquire semaphore during Upload table: %v", err)
 			break
@@ -106,28 +131,38 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			}
 		}
 		idx := i
-		g.Go(func() error {
+		g.Go(func() (err error) {
 			defer s.Release(1)
+			tableCtx, tableSpan := tracing.Start(ctx, "upload_table", tracing.Table(tablesForUpload[idx].Database, tablesForUpload[idx].Table))
+			defer func() { tracing.End(tableSpan, err) }()
 			var uploadedBytes int64
 			if !schemaOnly {
 				var files map[string][]string
-				var err error
-				files, uploadedBytes, err = b.uploadTableData(backupName, tablesForUpload[idx])
+				files, uploadedBytes, err = b.uploadTableData(tableCtx, backupName, tablesForUpload[idx], resume, state)
 				if err != nil {
 					return err
 				}
 				atomic.AddInt64(&compressedDataSize, uploadedBytes)
 				tablesForUpload[idx].Files = files
 			}
-			tableMetadataSize, err := b.uploadTableMetadata(backupName, tablesForUpload[idx])
-			if err != nil {
-				return err
+			var tableMetadataSize int64
+			if !b.cfg.General.ConsolidateMetadata {
+				if tableMetadataSize, err = b.uploadTableMetadata(ctx, backupName, tablesForUpload[idx]); err != nil {
+					return err
+				}
+				atomic.AddInt64(&metadataSize, tableMetadataSize)
+			}
+			if !schemaOnly {
+				stats := TableStats{Operation: "upload", Database: tablesForUpload[idx].Database, Table: tablesForUpload[idx].Table, Size: uint64(uploadedBytes), Duration: time.Since(start)}
+				for _, parts := range tablesForUpload[idx].Parts {
+					stats.Parts += len(parts)
+				}
+				reportTableStats(stats)
 			}
-			atomic.AddInt64(&metadataSize, tableMetadataSize)
 			log.
 				WithField("table", fmt.Sprintf("%s.%s", tablesForUpload[idx].Database, tablesForUpload[idx].Table)).
-				WithField("duration", utils.HumanizeDuration(time.Since(start))).
-				WithField("size", utils.FormatBytes(uint64(uploadedBytes+tableMetadataSize))).
+				WithField("duration", utils.LogDuration(time.Since(start))).
+				WithField("size", utils.LogBytes(uint64(uploadedBytes+tableMetadataSize))).
 				Info("done")
 			return nil
 		})
@@ -137,15 +172,31 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	}
 
 	// upload rbac for backup
-	if backupMetadata.RBACSize, err = b.uploadRBACData(backupName); err != nil {
+	if backupMetadata.RBACSize, err = b.uploadRBACData(ctx, backupName, state); err != nil {
 		return err
 	}
 
 	// upload configs for backup
-	if backupMetadata.ConfigSize, err = b.uploadConfigData(backupName); err != nil {
+	if backupMetadata.ConfigSize, err = b.uploadConfigData(ctx, backupName, state); err != nil {
+		return err
+	}
+
+	// upload result of BACKUP statement
+	if backupMetadata.NativeSize, err = b.uploadNativeData(ctx, backupName, state); err != nil {
 		return err
 	}
 
+	// metadata of all tables in one object, so download and list of huge schemas don't request each table
+	backupMetadata.TablesManifest = ""
+	if b.cfg.General.ConsolidateMetadata {
+		manifestSize, err := b.uploadTablesManifest(ctx, backupName, tablesForUpload)
+		if err != nil {
+			return err
+		}
+		metadataSize += manifestSize
+		backupMetadata.TablesManifest = tablesManifestFile
+	}
+
 	// upload metadata for backup
 	backupMetadata.CompressedSize = uint64(compressedDataSize)
 	backupMetadata.MetadataSize = uint64(metadataSize)
@@ -162,22 +213,27 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	} else {
 		backupMetadata.DataFormat = "directory"
 	}
+	backupMetadata.MetadataVersion = metadata.MetadataVersion
+	backupMetadata.SetRequiredFeatures(tablesForUpload)
 	newBackupMetadataBody, err := json.MarshalIndent(backupMetadata, "", "\t")
 	if err != nil {
 		return err
 	}
 	remoteBackupMetaFile := path.Join(backupName, "metadata.json")
-	if err = b.dst.PutFile(remoteBackupMetaFile,
+	if err = b.dst.PutFile(ctx, remoteBackupMetaFile,
 		ioutil.NopCloser(bytes.NewReader(newBackupMetadataBody))); err != nil {
 		return fmt.Errorf("can't upload: %v", err)
 	}
+	if err = os.Remove(uploadStatePath); err != nil {
+		log.Warnf("can't remove %s: %v", uploadStatePath, err)
+	}
 	log.
-		WithField("duration", utils.HumanizeDuration(time.Since(startUpload))).
-		WithField("size", utils.FormatBytes(uint64(compressedDataSize)+uint64(metadataSize)+uint64(len(newBackupMetadataBody))+backupMetadata.RBACSize+backupMetadata.ConfigSize)).
+		WithField("duration", utils.LogDuration(time.Since(startUpload))).
+		WithField("size", utils.LogBytes(uint64(compressedDataSize)+uint64(metadataSize)+uint64(len(newBackupMetadataBody))+backupMetadata.RBACSize+backupMetadata.ConfigSize+backupMetadata.NativeSize)).
 		Info("done")
 
 	// Clean
-	if err = b.dst.RemoveOldBackups(b.cfg.General.BackupsToKeepRemote); err != nil {
+	if err = b.dst.RemoveOldBackups(ctx, b.cfg.General.BackupsToKeepRemote, b.cfg.General.RetentionTags); err != nil {
 		return fmt.Errorf("can't remove old backups on remote storage: %v", err)
 	}
 	return nil
@@ -207,9 +263,9 @@ func (b *Backuper) getTablesForUploadDiffLocal(diffFrom string, backupMetadata *
 	return tablesForUploadFromDiff, nil
 }
 
-func (b *Backuper) getTablesForUploadDiffRemote(diffFromRemote string, backupMetadata *metadata.BackupMetadata, tablePattern string, skipTables []string) (tablesForUploadFromDiff map[metadata.TableTitle]metadata.TableMetadata, err error) {
+func (b *Backuper) getTablesForUploadDiffRemote(ctx context.Context, diffFromRemote string, backupMetadata *metadata.BackupMetadata, tablePattern string, skipTables []string) (tablesForUploadFromDiff map[metadata.TableTitle]metadata.TableMetadata, err error) {
 	tablesForUploadFromDiff = make(map[metadata.TableTitle]metadata.TableMetadata)
-	backupList, err := b.dst.BackupList(true, diffFromRemote)
+	backupList, err := b.dst.BackupList(ctx, true, diffFromRemote)
 	if err != nil {
 		return nil, err
 	}
@@ -229,7 +285,7 @@ func (b *Backuper) getTablesForUploadDiffRemote(diffFromRemote string, backupMet
 
 	if len(diffRemoteMetadata.Tables) != 0 {
 		backupMetadata.RequiredBackup = diffFromRemote
-		diffTablesList, err := getTableListByPatternRemote(b, diffRemoteMetadata, tablePattern, skipTables, false)
+		diffTablesList, err := getTableListByPatternRemote(ctx, b, diffRemoteMetadata, tablePattern, skipTables, false)
 		if err != nil {
 			return nil, err
 		}
@@ -248,7 +304,7 @@ func (b *Backuper) validateUploadParams(backupName string, diffFrom string, diff
 		return fmt.Errorf("general->remote_storage shall not be \"none\", change you config or use REMOTE_STORAGE environment variable")
 	}
 	if backupName == "" {
-		_ = PrintLocalBackups(b.cfg, "all")
+		_ = PrintLocalBackups(b.cfg, "all", "text", nil)
 		return fmt.Errorf("select backup for upload")
 	}
 	if backupName == diffFrom || backupName == diffFromRemote {
@@ -267,25 +323,61 @@ func (b *Backuper) validateUploadParams(backupName string, diffFrom string, diff
 	return nil
 }
 
-func (b *Backuper) uploadConfigData(backupName string) (uint64, error) {
+func (b *Backuper) uploadConfigData(ctx context.Context, backupName string, state *uploadState) (uint64, error) {
 	configBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "configs")
 	configFilesGlobPattern := path.Join(configBackupPath, "**/*.*")
 	remoteConfigsArchive := path.Join(backupName, fmt.Sprintf("configs.%s", b.cfg.GetArchiveExtension()))
-	return b.uploadAndArchiveBackupRelatedDir(configBackupPath, configFilesGlobPattern, remoteConfigsArchive)
+	return b.uploadAndArchiveBackupRelatedDir(ctx, configBackupPath, configFilesGlobPattern, remoteConfigsArchive, state)
 
 }
 
-func (b *Backuper) uploadRBACData(backupName string) (uint64, error) {
+func (b *Backuper) uploadRBACData(ctx context.Context, backupName string, state *uploadState) (uint64, error) {
 	rbacBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "access")
 	accessFilesGlobPattern := path.Join(rbacBackupPath, "*.*")
 	remoteRBACArchive := path.Join(backupName, fmt.Sprintf("access.%s", b.cfg.GetArchiveExtension()))
-	return b.uploadAndArchiveBackupRelatedDir(rbacBackupPath, accessFilesGlobPattern, remoteRBACArchive)
+	return b.uploadAndArchiveBackupRelatedDir(ctx, rbacBackupPath, accessFilesGlobPattern, remoteRBACArchive, state)
 }
 
-func (b *Backuper) uploadAndArchiveBackupRelatedDir(localBackupRelatedDir, localFilesGlobPattern, remoteFile string) (uint64, error) {
+// uploadNativeData - BACKUP statement writes files without extension, so all regular files of `native` folder are archived
+func (b *Backuper) uploadNativeData(ctx context.Context, backupName string, state *uploadState) (uint64, error) {
+	nativeBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, nativeBackupDir)
+	if _, err := os.Stat(nativeBackupPath); os.IsNotExist(err) {
+		return 0, nil
+	}
+	var localFiles []string
+	err := filepath.Walk(nativeBackupPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			localFiles = append(localFiles, strings.TrimPrefix(filePath, nativeBackupPath))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't list %s: %v", nativeBackupPath, err)
+	}
+	remoteNativeArchive := path.Join(backupName, fmt.Sprintf("%s.%s", nativeBackupDir, b.cfg.GetArchiveExtension()))
+	if size, isCompleted := state.isCompleted(remoteNativeArchive); isCompleted {
+		return uint64(size), nil
+	}
+	if err := b.dst.UploadCompressedStream(ctx, nativeBackupPath, localFiles, remoteNativeArchive, state); err != nil {
+		return 0, fmt.Errorf("can't upload native backup: %v", err)
+	}
+	remoteUploaded, err := b.dst.StatFile(ctx, remoteNativeArchive)
+	if err != nil {
+		return 0, fmt.Errorf("can't check uploaded %s file: %v", remoteNativeArchive, err)
+	}
+	return uint64(remoteUploaded.Size()), nil
+}
+
+func (b *Backuper) uploadAndArchiveBackupRelatedDir(ctx context.Context, localBackupRelatedDir, localFilesGlobPattern, remoteFile string, state *uploadState) (uint64, error) {
 	if _, err := os.Stat(localBackupRelatedDir); os.IsNotExist(err) {
 		return 0, nil
 	}
+	if size, isCompleted := state.isCompleted(remoteFile); isCompleted {
+		return uint64(size), nil
+	}
 	var localFiles []string
 	var err error
 	if localFiles, err = filepathx.Glob(localFilesGlobPattern); err != nil || localFiles == nil || len(localFiles) == 0 {
@@ -295,26 +387,31 @@ func (b *Backuper) uploadAndArchiveBackupRelatedDir(localBackupRelatedDir, local
 		localFiles[i] = strings.Replace(localFiles[i], localBackupRelatedDir, "", 1)
 	}
 
-	if err := b.dst.UploadCompressedStream(localBackupRelatedDir, localFiles, remoteFile); err != nil {
+	if err := b.dst.UploadCompressedStream(ctx, localBackupRelatedDir, localFiles, remoteFile, state); err != nil {
 		return 0, fmt.Errorf("can't RBAC upload: %v", err)
 	}
-	remoteUploaded, err := b.dst.StatFile(remoteFile)
+	remoteUploaded, err := b.dst.StatFile(ctx, remoteFile)
 	if err != nil {
 		return 0, fmt.Errorf("can't check uploaded %s file: %v", remoteFile, err)
 	}
 	return uint64(remoteUploaded.Size()), nil
 }
 
-func (b *Backuper) uploadTableData(backupName string, table metadata.TableMetadata) (map[string][]string, int64, error) {
+func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table metadata.TableMetadata, resume bool, state *uploadState) (map[string][]string, int64, error) {
 	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	metadataFiles := map[string][]string{}
 	capacity := 0
 	for disk := range table.Parts {
 		capacity += len(table.Parts[disk])
 	}
-	apexLog.Debugf("start uploadTableData %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity)
-	s := semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
-	g, ctx := errgroup.WithContext(context.Background())
+	apexLog.Debugf("start uploadTableData %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.GetUploadConcurrency(), capacity)
+	if b.cfg.General.FileChecksums {
+		if err := verifyTableChecksums(b.DiskToPathMap, backupName, table, true); err != nil {
+			return nil, 0, err
+		}
+	}
+	s := b.uploadSemaphore()
+	g, ctx := errgroup.WithContext(ctx)
 	var uploadedBytes int64
 
 	splittedParts := make(map[string][]metadata.PartFilesSplitted, 0)
@@ -330,6 +427,11 @@ func (b *Backuper) uploadTableData(backupName string, table metadata.TableMetada
 		splittedPartsOffset[disk] = 0
 		splittedPartsCapacity += len(splittedPartsList)
 	}
+	var uploadedBefore common.EmptyMap
+	uploadedBeforeCount := 0
+	if resume {
+		uploadedBefore = b.getUploadedBeforeArchives(ctx, backupName, table)
+	}
 	breakByError := false
 	for common.SumMapValuesInt(splittedPartsOffset) < splittedPartsCapacity && !breakByError {
 		for disk := range table.Parts {
@@ -350,10 +452,22 @@ func (b *Backuper) uploadTableData(backupName string, table metadata.TableMetada
 			if b.cfg.GetCompressionFormat() == "none" {
 				localPath := path.Join(backupPath, partSuffix)
 				remotePath := path.Join(baseRemoteDataPath, disk, partSuffix)
+				if resume {
+					filesCount := len(partFiles)
+					partFiles = b.filterUploadedBeforeFiles(ctx, localPath, partFiles, remotePath, state)
+					uploadedBeforeCount += filesCount - len(partFiles)
+					if len(partFiles) == 0 {
+						s.Release(1)
+						continue
+					}
+				}
 				g.Go(func() error {
 					defer s.Release(1)
 					apexLog.Debugf("start upload %d files to %s", len(partFiles), remotePath)
-					if err := b.dst.UploadPath(0, localPath, partFiles, remotePath); err != nil {
+					_, putSpan := tracing.Start(ctx, "put", tracing.Table(table.Database, table.Table), attribute.String("remote_path", remotePath))
+					err := b.dst.UploadPath(ctx, 0, localPath, partFiles, remotePath, state)
+					tracing.End(putSpan, err)
+					if err != nil {
 						apexLog.Errorf("UploadPath return error: %v", err)
 						return fmt.Errorf("can't upload: %v", err)
 					}
@@ -365,14 +479,30 @@ func (b *Backuper) uploadTableData(backupName string, table metadata.TableMetada
 				metadataFiles[disk] = append(metadataFiles[disk], fileName)
 				remoteDataFile := path.Join(baseRemoteDataPath, fileName)
 				localFiles := partFiles
+				if resume {
+					size, isUploaded := state.isCompleted(remoteDataFile)
+					if !isUploaded {
+						_, listedInMetadata := uploadedBefore[fileName]
+						size, isUploaded = b.isUploadedBefore(ctx, remoteDataFile, listedInMetadata, -1)
+					}
+					if isUploaded {
+						atomic.AddInt64(&uploadedBytes, size)
+						uploadedBeforeCount++
+						s.Release(1)
+						continue
+					}
+				}
 				g.Go(func() error {
 					defer s.Release(1)
 					apexLog.Debugf("start upload %d files to %s", len(localFiles), remoteDataFile)
-					if err := b.dst.UploadCompressedStream(backupPath, localFiles, remoteDataFile); err != nil {
+					_, putSpan := tracing.Start(ctx, "compress_put", tracing.Table(table.Database, table.Table), attribute.String("remote_path", remoteDataFile), attribute.String("compression", b.cfg.GetCompressionFormat()))
+					err := b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile, state)
+					tracing.End(putSpan, err)
+					if err != nil {
 						apexLog.Errorf("UploadCompressedStream return error: %v", err)
 						return fmt.Errorf("can't upload: %v", err)
 					}
-					remoteFile, err := b.dst.StatFile(remoteDataFile)
+					remoteFile, err := b.dst.StatFile(ctx, remoteDataFile)
 					if err != nil {
 						return fmt.Errorf("can't check uploaded file: %v", err)
 					}
@@ -386,18 +516,21 @@ func (b *Backuper) uploadTableData(backupName string, table metadata.TableMetada
 	if err := g.Wait(); err != nil {
 		return nil, 0, fmt.Errorf("one of uploadTableData go-routine return error: %v", err)
 	}
-	apexLog.Debugf("finish uploadTableData %s.%s with concurrency=%d len(table.Parts[...])=%d metadataFiles=%v, uploadedBytes=%v", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity, metadataFiles, uploadedBytes)
+	if uploadedBeforeCount > 0 {
+		apexLog.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table)).Infof("resume upload, skip %d files uploaded before", uploadedBeforeCount)
+	}
+	apexLog.Debugf("finish uploadTableData %s.%s with concurrency=%d len(table.Parts[...])=%d metadataFiles=%v, uploadedBytes=%v", table.Database, table.Table, b.cfg.GetUploadConcurrency(), capacity, metadataFiles, uploadedBytes)
 	return metadataFiles, uploadedBytes, nil
 }
 
-func (b *Backuper) uploadTableMetadata(backupName string, table metadata.TableMetadata) (int64, error) {
+func (b *Backuper) uploadTableMetadata(ctx context.Context, backupName string, table metadata.TableMetadata) (int64, error) {
 	tableMetafile := table
 	content, err := json.MarshalIndent(&tableMetafile, "", "\t")
 	if err != nil {
 		return 0, fmt.Errorf("can't marshal json: %v", err)
 	}
 	remoteTableMetaFile := path.Join(backupName, "metadata", common.TablePathEncode(table.Database), fmt.Sprintf("%s.%s", common.TablePathEncode(table.Table), "json"))
-	if err := b.dst.PutFile(remoteTableMetaFile,
+	if err := b.dst.PutFile(ctx, remoteTableMetaFile,
 		ioutil.NopCloser(bytes.NewReader(content))); err != nil {
 		return 0, fmt.Errorf("can't upload: %v", err)
 	}
@@ -405,6 +538,10 @@ func (b *Backuper) uploadTableMetadata(backupName string, table metadata.TableMe
 }
 
 func (b *Backuper) markDuplicatedParts(backup *metadata.BackupMetadata, existsTable *metadata.TableMetadata, newTable *metadata.TableMetadata, checkLocal bool) {
+	// exported data always has the same `logical` part name, so it can't be shared with base backup
+	if newTable.LogicalBackup {
+		return
+	}
 	for disk, newParts := range newTable.Parts {
 		if _, diskExists := existsTable.Parts[disk]; diskExists {
 			if len(existsTable.Parts[disk]) == 0 {
@@ -444,6 +581,9 @@ func (b *Backuper) ReadBackupMetadataLocal(backupName string) (*metadata.BackupM
 	if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
 		return nil, err
 	}
+	if err := backupMetadata.CheckVersion(false); err != nil {
+		return nil, fmt.Errorf("'%s': %v", backupName, err)
+	}
 	if len(backupMetadata.Tables) == 0 && !b.cfg.General.AllowEmptyBackups {
 		return nil, fmt.Errorf("'%s' is empty backup", backupName)
 	}
@@ -532,3 +672,99 @@ func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part) ([]m
 	}
 	return result, nil
 }
+
+// validateUploadResume - resume allowed only for backup with the same data format, incomplete backup without metadata.json listed as broken
+func (b *Backuper) validateUploadResume(ctx context.Context, backupName string) error {
+	remoteBackups, err := b.dst.BackupList(ctx, true, backupName)
+	if err != nil {
+		return err
+	}
+	for _, remoteBackup := range remoteBackups {
+		if remoteBackup.BackupName != backupName {
+			continue
+		}
+		if remoteBackup.Legacy {
+			return fmt.Errorf("'%s' is old format backup on remote and can't be resumed", backupName)
+		}
+		dataFormat := b.cfg.GetCompressionFormat()
+		if dataFormat == "none" {
+			dataFormat = "directory"
+		}
+		if remoteBackup.Broken == "" && remoteBackup.DataFormat != dataFormat {
+			return fmt.Errorf("'%s' has data_format=%s on remote, current compression_format=%s, can't resume upload", backupName, remoteBackup.DataFormat, b.cfg.GetCompressionFormat())
+		}
+	}
+	return nil
+}
+
+// getUploadedBeforeArchives - archives listed in remote table metadata, table metadata uploaded only after all table archives
+func (b *Backuper) getUploadedBeforeArchives(ctx context.Context, backupName string, table metadata.TableMetadata) common.EmptyMap {
+	uploadedBefore := common.EmptyMap{}
+	tm, err := b.readRemoteTableMetadata(ctx, backupName, metadata.TableTitle{Database: table.Database, Table: table.Table})
+	if err != nil {
+		return uploadedBefore
+	}
+	for _, files := range tm.Files {
+		for _, file := range files {
+			uploadedBefore[file] = struct{}{}
+		}
+	}
+	return uploadedBefore
+}
+
+// isUploadedBefore - remote file exists and complete, expectedSize < 0 when size is unknown before compression
+func (b *Backuper) isUploadedBefore(ctx context.Context, remoteFile string, listedInMetadata bool, expectedSize int64) (int64, bool) {
+	f, err := b.dst.StatFile(ctx, remoteFile)
+	if err != nil {
+		return 0, false
+	}
+	if expectedSize >= 0 {
+		return f.Size(), f.Size() == expectedSize
+	}
+	// object storages create object only after successful upload, FTP and SFTP could keep partially written file
+	if f.Size() > 0 && (listedInMetadata || (b.dst.Kind() != "FTP" && b.dst.Kind() != "SFTP")) {
+		return f.Size(), true
+	}
+	apexLog.Debugf("%s with size %d could be incomplete, will upload again", remoteFile, f.Size())
+	return 0, false
+}
+
+// filterUploadedBeforeFiles - files which absent on remote storage or have different size or checksum, files completed in upload state are not checked on remote storage
+func (b *Backuper) filterUploadedBeforeFiles(ctx context.Context, localPath string, files []string, remotePath string, state *uploadState) []string {
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(path.Join(localPath, file))
+		if err != nil {
+			result = append(result, file)
+			continue
+		}
+		if size, isCompleted := state.isCompleted(path.Join(remotePath, file)); isCompleted && size == info.Size() {
+			continue
+		}
+		if remoteFile, err := b.dst.StatFile(ctx, path.Join(remotePath, file)); err != nil || remoteFile.Size() != info.Size() || !isSameContent(path.Join(localPath, file), remoteFile) {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// isSameContent - files of `directory` data format are uploaded as is, so MD5 of local file is compared with checksum of remote file when storage knows it
+func isSameContent(localFile string, remoteFile new_storage.RemoteFile) bool {
+	if remoteFile.Checksum() == "" {
+		return true
+	}
+	f, err := os.Open(localFile)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			apexLog.Warnf("can't close %s: %v", localFile, err)
+		}
+	}()
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == remoteFile.Checksum()
+}
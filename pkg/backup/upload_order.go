@@ -0,0 +1,47 @@
+package backup
+
+import (
+	"sort"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+// tableDataSize - size of table data on all disks, TotalBytes for backups which don't save size of each disk
+func tableDataSize(table metadata.TableMetadata) int64 {
+	size := int64(0)
+	for _, diskSize := range table.Size {
+		size += diskSize
+	}
+	if size == 0 {
+		size = int64(table.TotalBytes)
+	}
+	return size
+}
+
+// uploadOrder - indexes of tables in order of `upload_order`, tables list is not changed, so metadata.json keep tables order of backup
+func uploadOrder(tables ListOfTables, order string) []int {
+	indexes := make([]int, len(tables))
+	for i := range tables {
+		indexes[i] = i
+	}
+	var less func(i, j metadata.TableMetadata) bool
+	switch order {
+	case "largest_first":
+		less = func(i, j metadata.TableMetadata) bool { return tableDataSize(i) > tableDataSize(j) }
+	case "smallest_first":
+		less = func(i, j metadata.TableMetadata) bool { return tableDataSize(i) < tableDataSize(j) }
+	case "alphabetical":
+		less = func(i, j metadata.TableMetadata) bool {
+			if i.Database != j.Database {
+				return i.Database < j.Database
+			}
+			return i.Table < j.Table
+		}
+	default:
+		return indexes
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return less(tables[indexes[i]], tables[indexes[j]])
+	})
+	return indexes
+}
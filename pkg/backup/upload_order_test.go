@@ -0,0 +1,23 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadOrder(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db2", Table: "medium", Size: map[string]int64{"default": 100, "s3": 50}},
+		{Database: "db1", Table: "small", Size: map[string]int64{"default": 10}},
+		{Database: "db1", Table: "large", TotalBytes: 1000},
+		{Database: "db1", Table: "empty"},
+	}
+	assert.Equal(t, []int{0, 1, 2, 3}, uploadOrder(tables, ""))
+	assert.Equal(t, []int{2, 0, 1, 3}, uploadOrder(tables, "largest_first"))
+	assert.Equal(t, []int{3, 1, 0, 2}, uploadOrder(tables, "smallest_first"))
+	assert.Equal(t, []int{3, 2, 1, 0}, uploadOrder(tables, "alphabetical"))
+	assert.Equal(t, "db2", tables[0].Database, "tables list is not changed")
+	assert.Equal(t, int64(150), tableDataSize(metadata.TableMetadata{Size: map[string]int64{"default": 100, "s3": 50}, TotalBytes: 10}))
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+)
+
+const uploadStateFile = "upload.state"
+
+// uploadStateEntry - completed file or started multipart upload
+type uploadStateEntry struct {
+	Key       string                       `json:"key"`
+	Completed bool                         `json:"completed,omitempty"`
+	Size      int64                        `json:"size,omitempty"`
+	Multipart *new_storage.MultipartUpload `json:"multipart,omitempty"`
+}
+
+// uploadState - `upload --resume` skip completed files and continue multipart uploads
+type uploadState struct {
+	*stateJournal
+	mu        sync.Mutex
+	completed map[string]int64
+	multipart map[string]new_storage.MultipartUpload
+}
+
+// openUploadState - read state written by previous upload when resume, otherwise start new state
+func openUploadState(filePath string, resume bool) (*uploadState, error) {
+	state := &uploadState{
+		completed: map[string]int64{},
+		multipart: map[string]new_storage.MultipartUpload{},
+	}
+	journal, err := openStateJournal(filePath, resume, func(line []byte) error {
+		entry := uploadStateEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		state.apply(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.stateJournal = journal
+	return state, nil
+}
+
+func (s *uploadState) apply(entry uploadStateEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.Completed {
+		s.completed[entry.Key] = entry.Size
+		delete(s.multipart, entry.Key)
+	} else if entry.Multipart != nil {
+		s.multipart[entry.Key] = *entry.Multipart
+	}
+}
+
+func (s *uploadState) save(entry uploadStateEntry) error {
+	s.apply(entry)
+	return s.append(entry)
+}
+
+// isCompleted - remote file uploaded completely, nil state means resume is not possible
+func (s *uploadState) isCompleted(key string) (int64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size, completed := s.completed[key]
+	return size, completed
+}
+
+func (s *uploadState) CompleteFile(key string, size int64) error {
+	return s.save(uploadStateEntry{Key: key, Completed: true, Size: size})
+}
+
+func (s *uploadState) GetMultipartUpload(key string) *new_storage.MultipartUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if upload, exists := s.multipart[key]; exists {
+		return &upload
+	}
+	return nil
+}
+
+func (s *uploadState) SaveMultipartUpload(key string, upload new_storage.MultipartUpload) error {
+	return s.save(uploadStateEntry{Key: key, Multipart: &upload})
+}
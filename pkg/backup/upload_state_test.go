@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadStateResume(t *testing.T) {
+	statePath := path.Join(t.TempDir(), uploadStateFile)
+	state, err := openUploadState(statePath, false)
+	require.NoError(t, err)
+	require.NoError(t, state.SaveMultipartUpload("b/shadow/db/t/default_1.tar", new_storage.MultipartUpload{UploadID: "id1", PartSize: 5}))
+	require.NoError(t, state.SaveMultipartUpload("b/shadow/db/t/default_2.tar", new_storage.MultipartUpload{UploadID: "id2", PartSize: 5}))
+	require.NoError(t, state.CompleteFile("b/shadow/db/t/default_1.tar", 100))
+	require.NoError(t, state.Close())
+
+	// interrupted write of last entry
+	f, err := os.OpenFile(statePath, os.O_WRONLY|os.O_APPEND, 0640)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"b/shadow/db/t/defau`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	state, err = openUploadState(statePath, true)
+	require.NoError(t, err)
+	size, isCompleted := state.isCompleted("b/shadow/db/t/default_1.tar")
+	assert.True(t, isCompleted)
+	assert.Equal(t, int64(100), size)
+	assert.Nil(t, state.GetMultipartUpload("b/shadow/db/t/default_1.tar"), "completed upload")
+	assert.Equal(t, &new_storage.MultipartUpload{UploadID: "id2", PartSize: 5}, state.GetMultipartUpload("b/shadow/db/t/default_2.tar"))
+	_, isCompleted = state.isCompleted("b/shadow/db/t/default_2.tar")
+	assert.False(t, isCompleted)
+	require.NoError(t, state.Close())
+
+	state, err = openUploadState(statePath, false)
+	require.NoError(t, err)
+	_, isCompleted = state.isCompleted("b/shadow/db/t/default_1.tar")
+	assert.False(t, isCompleted, "upload without resume start from scratch")
+	require.NoError(t, state.Close())
+	content, err := ioutil.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.Empty(t, content)
+}
+
+func TestFilterUploadedBeforeFilesWithState(t *testing.T) {
+	localPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(localPath, "all_1_1_0"), 0755))
+	for name, size := range map[string]int{"data.bin": 10, "data.mrk2": 5} {
+		require.NoError(t, ioutil.WriteFile(path.Join(localPath, "all_1_1_0", name), make([]byte, size), 0644))
+	}
+	state, err := openUploadState(path.Join(t.TempDir(), uploadStateFile), false)
+	require.NoError(t, err)
+	defer state.Close()
+	require.NoError(t, state.CompleteFile("b/shadow/db/t/default/all_1_1_0/data.bin", 10))
+	require.NoError(t, state.CompleteFile("b/shadow/db/t/default/all_1_1_0/data.mrk2", 4))
+	storage := &fakeRemoteStorage{kind: "SFTP", files: map[string]int64{}}
+	b := &Backuper{cfg: config.DefaultConfig(), dst: &new_storage.BackupDestination{RemoteStorage: storage}}
+	files := b.filterUploadedBeforeFiles(context.Background(), localPath, []string{"/all_1_1_0/data.bin", "/all_1_1_0/data.mrk2"}, "b/shadow/db/t/default", state)
+	assert.Equal(t, []string{"/all_1_1_0/data.mrk2"}, files)
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRemoteFile struct {
+	name     string
+	size     int64
+	checksum string
+}
+
+func (f fakeRemoteFile) Size() int64             { return f.size }
+func (f fakeRemoteFile) Name() string            { return f.name }
+func (f fakeRemoteFile) LastModified() time.Time { return time.Time{} }
+func (f fakeRemoteFile) ETag() string            { return f.checksum }
+func (f fakeRemoteFile) Checksum() string        { return f.checksum }
+
+// fakeRemoteStorage - only StatFile is implemented, enough to check resume logic
+type fakeRemoteStorage struct {
+	kind      string
+	files     map[string]int64
+	checksums map[string]string
+}
+
+func (s *fakeRemoteStorage) Kind() string { return s.kind }
+func (s *fakeRemoteStorage) StatFile(_ context.Context, key string) (new_storage.RemoteFile, error) {
+	if size, exists := s.files[key]; exists {
+		return fakeRemoteFile{name: key, size: size, checksum: s.checksums[key]}, nil
+	}
+	return nil, new_storage.ErrNotFound
+}
+func (s *fakeRemoteStorage) DeleteFile(context.Context, string) error    { return nil }
+func (s *fakeRemoteStorage) DeleteFiles(context.Context, []string) error { return nil }
+func (s *fakeRemoteStorage) Connect(context.Context) error               { return nil }
+func (s *fakeRemoteStorage) Walk(context.Context, string, bool, func(new_storage.RemoteFile) error) error {
+	return nil
+}
+func (s *fakeRemoteStorage) GetFileReader(context.Context, string) (io.ReadCloser, error) {
+	return nil, new_storage.ErrNotFound
+}
+func (s *fakeRemoteStorage) GetFileReaderWithLocalPath(context.Context, string, string) (io.ReadCloser, error) {
+	return nil, new_storage.ErrNotFound
+}
+func (s *fakeRemoteStorage) PutFile(context.Context, string, io.ReadCloser) error { return nil }
+func (s *fakeRemoteStorage) CopyFile(context.Context, string, string) error       { return nil }
+func (s *fakeRemoteStorage) MoveFile(context.Context, string, string) error       { return nil }
+
+func TestIsUploadedBefore(t *testing.T) {
+	storage := &fakeRemoteStorage{kind: "S3", files: map[string]int64{"b/shadow/db/t/default_1.tar": 100, "b/empty.tar": 0}}
+	b := &Backuper{cfg: config.DefaultConfig(), dst: &new_storage.BackupDestination{RemoteStorage: storage}}
+	ctx := context.Background()
+
+	size, isUploaded := b.isUploadedBefore(ctx, "b/shadow/db/t/default_1.tar", false, -1)
+	assert.True(t, isUploaded)
+	assert.Equal(t, int64(100), size)
+	_, isUploaded = b.isUploadedBefore(ctx, "b/shadow/db/t/default_2.tar", false, -1)
+	assert.False(t, isUploaded)
+	_, isUploaded = b.isUploadedBefore(ctx, "b/empty.tar", false, -1)
+	assert.False(t, isUploaded)
+	_, isUploaded = b.isUploadedBefore(ctx, "b/shadow/db/t/default_1.tar", false, 101)
+	assert.False(t, isUploaded, "size mismatch")
+
+	storage.kind = "SFTP"
+	_, isUploaded = b.isUploadedBefore(ctx, "b/shadow/db/t/default_1.tar", false, -1)
+	assert.False(t, isUploaded, "SFTP file could be written partially")
+	_, isUploaded = b.isUploadedBefore(ctx, "b/shadow/db/t/default_1.tar", true, -1)
+	assert.True(t, isUploaded, "listed in uploaded table metadata")
+}
+
+func TestFilterUploadedBeforeFiles(t *testing.T) {
+	localPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(localPath, "all_1_1_0"), 0755))
+	for name, size := range map[string]int{"data.bin": 10, "data.mrk2": 5, "checksums.txt": 3} {
+		require.NoError(t, ioutil.WriteFile(path.Join(localPath, "all_1_1_0", name), make([]byte, size), 0644))
+	}
+	storage := &fakeRemoteStorage{kind: "FTP", files: map[string]int64{
+		"b/shadow/db/t/default/all_1_1_0/data.bin":  10,
+		"b/shadow/db/t/default/all_1_1_0/data.mrk2": 4,
+	}}
+	b := &Backuper{cfg: config.DefaultConfig(), dst: &new_storage.BackupDestination{RemoteStorage: storage}}
+	ctx := context.Background()
+	files := b.filterUploadedBeforeFiles(ctx, localPath, []string{"/all_1_1_0/data.bin", "/all_1_1_0/data.mrk2", "/all_1_1_0/checksums.txt"}, "b/shadow/db/t/default", nil)
+	assert.Equal(t, []string{"/all_1_1_0/data.mrk2", "/all_1_1_0/checksums.txt"}, files)
+
+	// md5 of 10 zero bytes
+	storage.checksums = map[string]string{"b/shadow/db/t/default/all_1_1_0/data.bin": "a63c90cc3684ad8b0a2176a6a8fe9005"}
+	files = b.filterUploadedBeforeFiles(ctx, localPath, []string{"/all_1_1_0/data.bin"}, "b/shadow/db/t/default", nil)
+	assert.Empty(t, files, "checksum match")
+	storage.checksums["b/shadow/db/t/default/all_1_1_0/data.bin"] = "5d41402abc4b2a76b9719d911017c592"
+	files = b.filterUploadedBeforeFiles(ctx, localPath, []string{"/all_1_1_0/data.bin"}, "b/shadow/db/t/default", nil)
+	assert.Equal(t, []string{"/all_1_1_0/data.bin"}, files, "same size, different content")
+}
+
+func TestTransferSemaphoreShared(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	cfg.General.UploadConcurrency = 4
+	cfg.General.DownloadConcurrency = 4
+	cfg.S3.MaxConcurrentTransfers = 2
+	b := NewBackuper(cfg)
+	// semaphore of table parts is the same for all tables, so parallel tables don't multiply transfers
+	assert.Same(t, b.uploadSemaphore(), b.uploadSemaphore())
+	assert.Same(t, b.downloadSemaphore(), b.downloadSemaphore())
+	assert.True(t, b.uploadSemaphore().TryAcquire(2))
+	assert.False(t, b.uploadSemaphore().TryAcquire(1))
+	assert.True(t, b.downloadSemaphore().TryAcquire(2))
+}
@@ -13,3 +13,17 @@ func GetBackupsToDelete(backups []BackupLocal, keep int) []BackupLocal {
 	}
 	return []BackupLocal{}
 }
+
+// filterLocalBackupsByTags - backups which contain all tags, empty tags match all backups
+func filterLocalBackupsByTags(backups []BackupLocal, tags map[string]string) []BackupLocal {
+	if len(tags) == 0 {
+		return backups
+	}
+	filtered := make([]BackupLocal, 0, len(backups))
+	for _, b := range backups {
+		if b.HasTags(tags) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,609 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/cespare/xxhash/v2"
+	"github.com/mxalis/clickhouse-backup/pkg/checksums"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// verifyResult - problems found by Verify, verification continue after each problem to show full report
+type verifyResult struct {
+	log      *apexLog.Entry
+	problems int64
+	files    int64
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *verifyResult) fail(format string, args ...interface{}) {
+	atomic.AddInt64(&r.problems, 1)
+	r.log.Errorf(format, args...)
+	r.mu.Lock()
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+// report - problems and stats in stable `verify --format` schema
+func (r *verifyResult) report(backupName, location string, duration time.Duration) VerifyReport {
+	report := VerifyReport{
+		Backup:   backupName,
+		Location: location,
+		Status:   "ok",
+		Files:    r.files,
+		Duration: duration.Seconds(),
+		Problems: r.messages,
+	}
+	if r.problems > 0 {
+		report.Status = "failed"
+	}
+	if report.Problems == nil {
+		report.Problems = []string{}
+	}
+	return report
+}
+
+func (r VerifyReport) tsvRows() [][]string {
+	rows := [][]string{{r.Backup, r.Location, r.Status, fmt.Sprint(r.Files), fmt.Sprintf("%.3f", r.Duration)}}
+	for _, problem := range r.Problems {
+		rows = append(rows, []string{problem})
+	}
+	return rows
+}
+
+// collectedFile - calculated size and CityHash128 to compare with checksums.txt, xxhash64 to compare with checksums recorded in table metadata
+type collectedFile struct {
+	checksums.FileChecksum
+	xxhash64 string
+}
+
+// partFilesCollector - checksums.txt content and calculated size and hash for each file, grouped by directory relative to disk shadow path
+type partFilesCollector struct {
+	mu        sync.Mutex
+	result    *verifyResult
+	checksums map[string]map[string]checksums.FileChecksum
+	files     map[string]map[string]collectedFile
+	// withoutChecksumsTxt - parts don't contain checksums.txt from ClickHouse, like `logical` part which contain only `data.native`,
+	// or contain only references to objects instead of it, like zero-copy parts on object disks
+	withoutChecksumsTxt bool
+}
+
+func newPartFilesCollector(result *verifyResult) *partFilesCollector {
+	return &partFilesCollector{
+		result:    result,
+		checksums: map[string]map[string]checksums.FileChecksum{},
+		files:     map[string]map[string]collectedFile{},
+	}
+}
+
+func (c *partFilesCollector) collect(name string, r io.Reader) error {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	dir, file := path.Dir(name), path.Base(name)
+	if file == checksums.FileName && !c.withoutChecksumsTxt {
+		sums, err := checksums.Parse(r)
+		if err != nil {
+			c.result.fail("can't parse %s: %v", name, err)
+			return nil
+		}
+		c.mu.Lock()
+		c.checksums[dir] = sums
+		c.mu.Unlock()
+		return nil
+	}
+	h := checksums.NewHasher()
+	xh := xxhash.New()
+	if _, err := io.Copy(io.MultiWriter(h, xh), r); err != nil {
+		return fmt.Errorf("can't read %s: %v", name, err)
+	}
+	atomic.AddInt64(&c.result.files, 1)
+	c.mu.Lock()
+	if _, exists := c.files[dir]; !exists {
+		c.files[dir] = map[string]collectedFile{}
+	}
+	c.files[dir][file] = collectedFile{
+		FileChecksum: checksums.FileChecksum{Size: h.Size(), Hash: h.Sum()},
+		xxhash64:     fmt.Sprintf("%016x", xh.Sum64()),
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// verify - compare files from checksums.txt in dir with calculated, projections checked recursively
+func (c *partFilesCollector) verify(table, dir string) {
+	sums, exists := c.checksums[dir]
+	if !exists {
+		c.result.fail("%s: %s/%s not found", table, dir, checksums.FileName)
+		return
+	}
+	for name, expected := range sums {
+		if strings.HasSuffix(name, ".proj") {
+			c.verify(table, path.Join(dir, name))
+			continue
+		}
+		actual, exists := c.files[dir][name]
+		if !exists {
+			c.result.fail("%s: %s/%s not found", table, dir, name)
+			continue
+		}
+		if err := expected.Verify(actual.Size, actual.Hash); err != nil {
+			c.result.fail("%s: %s/%s %v", table, dir, name, err)
+		}
+	}
+}
+
+// verifyRecorded - compare files of part in dir with checksums recorded in table metadata with `file_checksums: true`, requiredFiles shall exist even without recorded checksums
+func (c *partFilesCollector) verifyRecorded(table, dir string, part metadata.Part, requiredFiles ...string) {
+	for _, name := range requiredFiles {
+		if _, recorded := part.Checksums[name]; !recorded {
+			if _, exists := c.files[dir][name]; !exists {
+				c.result.fail("%s: %s/%s not found", table, dir, name)
+			}
+		}
+	}
+	for name, expected := range part.Checksums {
+		filePath := path.Join(dir, name)
+		actual, exists := c.files[path.Dir(filePath)][path.Base(filePath)]
+		if !exists {
+			c.result.fail("%s: %s not found", table, filePath)
+			continue
+		}
+		if uint64(expected.Size) != actual.Size {
+			c.result.fail("%s: %s size mismatch, expected %d, actual %d", table, filePath, expected.Size, actual.Size)
+		} else if expected.XXHash64 != actual.xxhash64 {
+			c.result.fail("%s: %s xxhash64 mismatch, expected %s, actual %s", table, filePath, expected.XXHash64, actual.xxhash64)
+		}
+	}
+}
+
+// verifyPart - logical parts don't contain checksums.txt and verified with checksums from table metadata,
+// zero-copy parts contain only references to objects, so only reference files are verified, objects data is not read
+func (c *partFilesCollector) verifyPart(table string, tm *metadata.TableMetadata, disk string, part metadata.Part) {
+	if tm.LogicalBackup {
+		c.verifyRecorded(table, part.Name, part, logicalDataFile)
+		return
+	}
+	if isZeroCopyDisk(tm, disk) {
+		c.verifyRecorded(table, part.Name, part, checksums.FileName)
+		return
+	}
+	c.verify(table, part.Name)
+}
+
+// isZeroCopyDisk - parts of table on disk were frozen with `object_disk_backup_mode: zero-copy`
+func isZeroCopyDisk(tm *metadata.TableMetadata, disk string) bool {
+	for _, objectDisk := range tm.ObjectDisks {
+		if objectDisk == disk {
+			return true
+		}
+	}
+	return false
+}
+
+// nativeBackupFile - file entry of `.backup` written by BACKUP statement
+type nativeBackupFile struct {
+	Name     string `xml:"name"`
+	Size     uint64 `xml:"size"`
+	Checksum string `xml:"checksum"`
+	UseBase  bool   `xml:"use_base"`
+	DataFile string `xml:"data_file"`
+}
+
+// nativeFilesCollector - `.backup` content and calculated size and hash of each file of `native` folder
+type nativeFilesCollector struct {
+	mu      sync.Mutex
+	result  *verifyResult
+	content []byte
+	files   map[string]checksums.FileChecksum
+}
+
+func newNativeFilesCollector(result *verifyResult) *nativeFilesCollector {
+	return &nativeFilesCollector{result: result, files: map[string]checksums.FileChecksum{}}
+}
+
+func (c *nativeFilesCollector) collect(name string, r io.Reader) error {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == ".backup" {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("can't read %s: %v", name, err)
+		}
+		c.mu.Lock()
+		c.content = content
+		c.mu.Unlock()
+		return nil
+	}
+	h := checksums.NewHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("can't read %s: %v", name, err)
+	}
+	atomic.AddInt64(&c.result.files, 1)
+	c.mu.Lock()
+	c.files[name] = checksums.FileChecksum{Size: h.Size(), Hash: h.Sum()}
+	c.mu.Unlock()
+	return nil
+}
+
+// verify - compare files listed in `.backup` with calculated, files from base backup and empty files are not stored
+func (c *nativeFilesCollector) verify(location string) {
+	if c.content == nil {
+		c.result.fail("%s/.backup not found", location)
+		return
+	}
+	var backupFile struct {
+		Files []nativeBackupFile `xml:"contents>file"`
+	}
+	if err := xml.Unmarshal(c.content, &backupFile); err != nil {
+		c.result.fail("can't parse %s/.backup: %v", location, err)
+		return
+	}
+	for _, expected := range backupFile.Files {
+		if expected.UseBase || expected.Size == 0 {
+			continue
+		}
+		dataFile := expected.Name
+		if expected.DataFile != "" {
+			dataFile = expected.DataFile
+		}
+		actual, exists := c.files[dataFile]
+		if !exists {
+			c.result.fail("%s/%s not found", location, dataFile)
+			continue
+		}
+		if expected.Size != actual.Size {
+			c.result.fail("%s/%s size mismatch, expected %d, actual %d", location, dataFile, expected.Size, actual.Size)
+		} else if expected.Checksum != "" && expected.Checksum != checksums.FormatHash(actual.Hash) {
+			c.result.fail("%s/%s hash mismatch, expected %s, actual %s", location, dataFile, expected.Checksum, checksums.FormatHash(actual.Hash))
+		}
+	}
+}
+
+// Verify - check backup integrity without restore: metadata readability and consistency, archives readability, size and hash of each part file from checksums.txt
+func (b *Backuper) Verify(ctx context.Context, backupName string, remote bool, outputFormat string) (err error) {
+	ctx, span := tracing.Start(ctx, "verify", tracing.Backup(backupName))
+	defer func() { tracing.End(span, err) }()
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	location := "local"
+	if remote {
+		location = "remote"
+	}
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "verify",
+		"location":  location,
+	})
+	startVerify := time.Now()
+	result := &verifyResult{log: log}
+	if remote {
+		err = b.verifyRemote(ctx, backupName, result)
+	} else {
+		err = b.verifyLocal(ctx, backupName, result)
+	}
+	if err != nil {
+		return err
+	}
+	if !isTextOutput(outputFormat) {
+		report := result.report(backupName, location, time.Since(startVerify))
+		if err := printStructured(os.Stdout, outputFormat, report, report.tsvRows()); err != nil {
+			return err
+		}
+	}
+	if result.problems > 0 {
+		return fmt.Errorf("backup '%s' verification failed, %d problems found", backupName, result.problems)
+	}
+	log.WithFields(apexLog.Fields{
+		"duration": utils.LogDuration(time.Since(startVerify)),
+		"files":    result.files,
+	}).Info("done")
+	return nil
+}
+
+func (b *Backuper) verifyLocal(ctx context.Context, backupName string, result *verifyResult) error {
+	backup, disks, err := getLocalBackup(b.cfg, backupName, nil)
+	if err != nil {
+		return err
+	}
+	if backup.Legacy {
+		return fmt.Errorf("'%s' is old format backup and doesn't supports verify", backupName)
+	}
+	if backup.Broken != "" {
+		result.fail("backup is broken: %s", backup.Broken)
+		return nil
+	}
+	if err := b.initDisks(disks); err != nil {
+		return err
+	}
+	s := semaphore.NewWeighted(int64(b.cfg.GetUploadConcurrency()))
+	g, verifyCtx := errgroup.WithContext(ctx)
+	nativeBackup := false
+	for _, title := range backup.Tables {
+		metadataFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(title.Database), fmt.Sprintf("%s.json", common.TablePathEncode(title.Table)))
+		tm := &metadata.TableMetadata{}
+		if _, err := tm.Load(metadataFile); err != nil {
+			result.fail("can't load %s: %v", metadataFile, err)
+			continue
+		}
+		if !verifyTableMetadata(title, tm, result) || tm.MetadataOnly {
+			continue
+		}
+		nativeBackup = nativeBackup || tm.NativeBackup
+		tableName := fmt.Sprintf("%s.%s", title.Database, title.Table)
+		dbAndTablePath := path.Join(common.TablePathEncode(title.Database), common.TablePathEncode(title.Table))
+		for disk, parts := range tm.Parts {
+			diskPath, exists := b.DiskToPathMap[disk]
+			if !exists {
+				result.fail("%s: disk '%s' not found in system.disks", tableName, disk)
+				continue
+			}
+			shadowPath := path.Join(diskPath, "backup", backupName, "shadow", dbAndTablePath, disk)
+			for _, part := range parts {
+				if err := s.Acquire(verifyCtx, 1); err != nil {
+					return err
+				}
+				part, disk := part, disk
+				partName := part.Name
+				g.Go(func() error {
+					defer s.Release(1)
+					collector := newPartFilesCollector(result)
+					collector.withoutChecksumsTxt = tm.LogicalBackup || isZeroCopyDisk(tm, disk)
+					err := filepath.Walk(path.Join(shadowPath, partName), func(filePath string, info os.FileInfo, err error) error {
+						if err != nil {
+							return err
+						}
+						if !info.Mode().IsRegular() {
+							return nil
+						}
+						f, err := os.Open(filePath)
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						return collector.collect(strings.TrimPrefix(filePath, shadowPath), f)
+					})
+					if err != nil {
+						result.fail("%s: can't read part %s: %v", tableName, partName, err)
+						return nil
+					}
+					collector.verifyPart(tableName, tm, disk, part)
+					return nil
+				})
+			}
+		}
+	}
+	if nativeBackup {
+		nativeBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, nativeBackupDir)
+		collector := newNativeFilesCollector(result)
+		err := filepath.Walk(nativeBackupPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return collector.collect(strings.TrimPrefix(filePath, nativeBackupPath), f)
+		})
+		if err != nil {
+			result.fail("can't read %s: %v", nativeBackupPath, err)
+		} else {
+			collector.verify(nativeBackupDir)
+		}
+	}
+	return g.Wait()
+}
+
+func (b *Backuper) verifyRemote(ctx context.Context, backupName string, result *verifyResult) error {
+	if b.cfg.General.RemoteStorage == "none" {
+		return fmt.Errorf("remote storage is 'none'")
+	}
+	var err error
+	if b.dst, err = new_storage.NewBackupDestination(b.cfg, false); err != nil {
+		return err
+	}
+	if err := b.dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+	}
+	remoteBackups, err := b.dst.BackupList(ctx, true, backupName)
+	if err != nil {
+		return err
+	}
+	var backup *new_storage.Backup
+	for i := range remoteBackups {
+		if remoteBackups[i].BackupName == backupName {
+			backup = &remoteBackups[i]
+			break
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	if backup.Broken != "" {
+		result.fail("backup is broken: %s", backup.Broken)
+		return nil
+	}
+	if backup.Legacy {
+		archiveFile := fmt.Sprintf("%s.%s", backupName, backup.FileExtension)
+		return b.verifyRemoteArchive(ctx, archiveFile, nil, result)
+	}
+	if backup.RequiredBackup != "" {
+		requiredFound := false
+		for _, r := range remoteBackups {
+			requiredFound = requiredFound || r.BackupName == backup.RequiredBackup
+		}
+		if !requiredFound {
+			result.fail("required backup '%s' is not found on remote storage", backup.RequiredBackup)
+		}
+	}
+	if backup.RBACSize > 0 {
+		_ = b.verifyRemoteArchive(ctx, path.Join(backupName, fmt.Sprintf("access.%s", b.cfg.GetArchiveExtension())), nil, result)
+	}
+	if backup.ConfigSize > 0 {
+		_ = b.verifyRemoteArchive(ctx, path.Join(backupName, fmt.Sprintf("configs.%s", b.cfg.GetArchiveExtension())), nil, result)
+	}
+	if backup.NativeSize > 0 {
+		remoteNativeArchive := path.Join(backupName, fmt.Sprintf("%s.%s", nativeBackupDir, b.cfg.GetArchiveExtension()))
+		collector := newNativeFilesCollector(result)
+		if err := b.dst.ReadCompressedStream(ctx, remoteNativeArchive, collector.collect); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result.fail("can't read archive %s: %v", remoteNativeArchive, err)
+		} else {
+			collector.verify(remoteNativeArchive)
+		}
+	}
+	s := semaphore.NewWeighted(int64(b.cfg.GetDownloadConcurrency()))
+	g, verifyCtx := errgroup.WithContext(ctx)
+	for _, title := range backup.Tables {
+		tm, err := b.readRemoteTableMetadata(ctx, backupName, title)
+		if err != nil {
+			result.fail("can't read %s.%s metadata: %v", title.Database, title.Table, err)
+			continue
+		}
+		if !verifyTableMetadata(title, tm, result) || tm.MetadataOnly {
+			continue
+		}
+		tableName := fmt.Sprintf("%s.%s", title.Database, title.Table)
+		remoteTablePath := path.Join(backupName, "shadow", common.TablePathEncode(title.Database), common.TablePathEncode(title.Table))
+		for disk, parts := range tm.Parts {
+			collector := newPartFilesCollector(result)
+			collector.withoutChecksumsTxt = tm.LogicalBackup || isZeroCopyDisk(tm, disk)
+			diskGroup, diskCtx := errgroup.WithContext(verifyCtx)
+			if backup.DataFormat != "directory" {
+				if len(tm.Files[disk]) == 0 && len(parts) > 0 {
+					result.fail("%s: archives list for disk '%s' is empty", tableName, disk)
+					continue
+				}
+				for _, archiveFile := range tm.Files[disk] {
+					if err := s.Acquire(verifyCtx, 1); err != nil {
+						return err
+					}
+					remoteFile := path.Join(remoteTablePath, archiveFile)
+					diskGroup.Go(func() error {
+						defer s.Release(1)
+						return b.verifyRemoteArchive(diskCtx, remoteFile, collector.collect, result)
+					})
+				}
+			} else {
+				remoteDiskPath := path.Join(remoteTablePath, disk)
+				if err := s.Acquire(verifyCtx, 1); err != nil {
+					return err
+				}
+				diskGroup.Go(func() error {
+					defer s.Release(1)
+					err := b.dst.Walk(ctx, remoteDiskPath+"/", true, func(f new_storage.RemoteFile) error {
+						r, err := b.dst.GetFileReader(ctx, path.Join(remoteDiskPath, f.Name()))
+						if err != nil {
+							return err
+						}
+						defer r.Close()
+						return collector.collect(f.Name(), r)
+					})
+					if err != nil {
+						result.fail("%s: can't read %s: %v", tableName, remoteDiskPath, err)
+					}
+					return nil
+				})
+			}
+			diskParts := parts
+			tm, disk := tm, disk
+			g.Go(func() error {
+				if err := diskGroup.Wait(); err != nil {
+					return err
+				}
+				for _, part := range diskParts {
+					// required parts stored in RequiredBackup and will verify with it
+					if !part.Required {
+						collector.verifyPart(tableName, tm, disk, part)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return g.Wait()
+}
+
+// verifyRemoteArchive - read whole archive, fn receive each file when defined, unreadable archive counted as problem
+func (b *Backuper) verifyRemoteArchive(ctx context.Context, remoteFile string, fn func(name string, r io.Reader) error, result *verifyResult) error {
+	if fn == nil {
+		fn = func(name string, r io.Reader) error {
+			_, err := io.Copy(ioutil.Discard, r)
+			atomic.AddInt64(&result.files, 1)
+			return err
+		}
+	}
+	if err := b.dst.ReadCompressedStream(ctx, remoteFile, fn); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		result.fail("can't read archive %s: %v", remoteFile, err)
+	}
+	return nil
+}
+
+// readRemoteTableMetadata - table metadata from tables manifest of backup, or from `metadata/<db>/<table>.json` when backup doesn't have manifest
+func (b *Backuper) readRemoteTableMetadata(ctx context.Context, backupName string, title metadata.TableTitle) (*metadata.TableMetadata, error) {
+	manifest, err := b.remoteTablesManifest(ctx, backupName)
+	if err != nil {
+		return nil, err
+	}
+	var tmBody []byte
+	if manifest != nil {
+		var exists bool
+		if tmBody, exists = manifest[title]; !exists {
+			return nil, fmt.Errorf("%s.%s is not found in %s of '%s'", title.Database, title.Table, tablesManifestFile, backupName)
+		}
+	} else {
+		remoteTableMetadata := path.Join(backupName, "metadata", common.TablePathEncode(title.Database), fmt.Sprintf("%s.json", common.TablePathEncode(title.Table)))
+		if tmBody, err = b.readRemoteFile(ctx, remoteTableMetadata); err != nil {
+			return nil, err
+		}
+	}
+	tm := &metadata.TableMetadata{}
+	if err := json.Unmarshal(tmBody, tm); err != nil {
+		return nil, err
+	}
+	return tm, nil
+}
+
+// verifyTableMetadata - table metadata shall describe the same table as backup metadata and contain create query
+func verifyTableMetadata(title metadata.TableTitle, tm *metadata.TableMetadata, result *verifyResult) bool {
+	if tm.Database != title.Database || tm.Table != title.Table {
+		result.fail("metadata for %s.%s contains %s.%s", title.Database, title.Table, tm.Database, tm.Table)
+		return false
+	}
+	if tm.Query == "" {
+		result.fail("metadata for %s.%s doesn't contain create query", title.Database, title.Table)
+		return false
+	}
+	return true
+}
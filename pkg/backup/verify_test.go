@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/checksums"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeChecksums - write checksums.txt in format version 3 for files in dir, projections listed as single entry
+func writeChecksums(t *testing.T, dir string, files ...string) {
+	var body bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) { body.Write(buf[:binary.PutUvarint(buf, v)]) }
+	writeUvarint(uint64(len(files)))
+	for _, name := range files {
+		size, hash := uint64(0), checksums.NewHasher().Sum()
+		if !strings.HasSuffix(name, ".proj") {
+			var err error
+			size, hash, err = checksums.HashFile(path.Join(dir, name))
+			require.NoError(t, err)
+		}
+		writeUvarint(uint64(len(name)))
+		body.WriteString(name)
+		writeUvarint(size)
+		_ = binary.Write(&body, binary.LittleEndian, hash.Low)
+		_ = binary.Write(&body, binary.LittleEndian, hash.High)
+		body.WriteByte(0)
+	}
+	content := append([]byte("checksums format version: 3\n"), body.Bytes()...)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, checksums.FileName), content, 0640))
+}
+
+func collectDir(t *testing.T, collector *partFilesCollector, baseDir string, files ...string) {
+	for _, name := range files {
+		f, err := os.Open(path.Join(baseDir, name))
+		require.NoError(t, err)
+		require.NoError(t, collector.collect("/"+name, f))
+		_ = f.Close()
+	}
+}
+
+func TestPartFilesCollector(t *testing.T) {
+	shadowPath := t.TempDir()
+	partPath := path.Join(shadowPath, "all_1_1_0")
+	require.NoError(t, os.MkdirAll(path.Join(partPath, "p.proj"), 0750))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "data.bin"), bytes.Repeat([]byte("x"), 5000), 0640))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "count.txt"), []byte("10"), 0640))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "p.proj", "data.bin"), []byte("projection"), 0640))
+	writeChecksums(t, path.Join(partPath, "p.proj"), "data.bin")
+	writeChecksums(t, partPath, "data.bin", "count.txt", "p.proj")
+	files := []string{"all_1_1_0/checksums.txt", "all_1_1_0/data.bin", "all_1_1_0/count.txt", "all_1_1_0/p.proj/checksums.txt", "all_1_1_0/p.proj/data.bin"}
+
+	result := &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector := newPartFilesCollector(result)
+	collectDir(t, collector, shadowPath, files...)
+	collector.verify("default.t", "all_1_1_0")
+	assert.Equal(t, int64(0), result.problems)
+	assert.Equal(t, int64(3), result.files)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "p.proj", "data.bin"), []byte("corrupted!"), 0640))
+	require.NoError(t, os.Remove(path.Join(partPath, "count.txt")))
+	result = &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector = newPartFilesCollector(result)
+	collectDir(t, collector, shadowPath, "all_1_1_0/checksums.txt", "all_1_1_0/data.bin", "all_1_1_0/p.proj/checksums.txt", "all_1_1_0/p.proj/data.bin")
+	collector.verify("default.t", "all_1_1_0")
+	collector.verify("default.t", "all_2_2_0")
+	assert.Equal(t, int64(3), result.problems)
+}
+
+func TestPartFilesCollectorLogical(t *testing.T) {
+	shadowPath := t.TempDir()
+	partPath := path.Join(shadowPath, logicalPartName)
+	require.NoError(t, os.MkdirAll(partPath, 0750))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, logicalDataFile), []byte("native rows"), 0640))
+	recorded, err := calculatePartChecksums(partPath)
+	require.NoError(t, err)
+	tm := &metadata.TableMetadata{Database: "default", Table: "log", LogicalBackup: true}
+	files := []string{"logical/data.native"}
+
+	// without `file_checksums: true` data.native shall exist and be readable
+	result := &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector := newPartFilesCollector(result)
+	collector.withoutChecksumsTxt = true
+	collectDir(t, collector, shadowPath, files...)
+	collector.verifyPart("default.log", tm, logicalDisk, metadata.Part{Name: logicalPartName})
+	collector.verifyPart("default.log", tm, logicalDisk, metadata.Part{Name: logicalPartName, Checksums: recorded})
+	assert.Equal(t, int64(0), result.problems)
+	assert.Equal(t, int64(1), result.files)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, logicalDataFile), []byte("native r0ws"), 0640))
+	result = &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector = newPartFilesCollector(result)
+	collector.withoutChecksumsTxt = true
+	collectDir(t, collector, shadowPath, files...)
+	collector.verifyPart("default.log", tm, logicalDisk, metadata.Part{Name: logicalPartName, Checksums: recorded})
+	assert.Equal(t, int64(1), result.problems)
+	assert.Contains(t, result.messages[0], "xxhash64 mismatch")
+
+	result = &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector = newPartFilesCollector(result)
+	collector.withoutChecksumsTxt = true
+	collector.verifyPart("default.log", tm, logicalDisk, metadata.Part{Name: logicalPartName})
+	assert.Equal(t, []string{"default.log: logical/data.native not found"}, result.messages)
+}
+
+func TestPartFilesCollectorZeroCopy(t *testing.T) {
+	shadowPath := t.TempDir()
+	partPath := path.Join(shadowPath, "all_1_1_0")
+	require.NoError(t, os.MkdirAll(partPath, 0750))
+	// local files of parts on object disks contain only references to objects, checksums.txt too
+	for _, name := range []string{checksums.FileName, "data.bin"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(partPath, name), []byte("3\n1\t100\nr0000/"+name+"\n0\n0\n"), 0640))
+	}
+	recorded, err := calculatePartChecksums(partPath)
+	require.NoError(t, err)
+	tm := &metadata.TableMetadata{Database: "default", Table: "s3", ObjectDisks: []string{"s3"}}
+	files := []string{"all_1_1_0/checksums.txt", "all_1_1_0/data.bin"}
+
+	result := &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector := newPartFilesCollector(result)
+	collector.withoutChecksumsTxt = isZeroCopyDisk(tm, "s3")
+	collectDir(t, collector, shadowPath, files...)
+	collector.verifyPart("default.s3", tm, "s3", metadata.Part{Name: "all_1_1_0"})
+	collector.verifyPart("default.s3", tm, "s3", metadata.Part{Name: "all_1_1_0", Checksums: recorded})
+	assert.Equal(t, int64(0), result.problems)
+	assert.Equal(t, int64(2), result.files)
+
+	require.NoError(t, os.Remove(path.Join(partPath, "data.bin")))
+	result = &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector = newPartFilesCollector(result)
+	collector.withoutChecksumsTxt = isZeroCopyDisk(tm, "s3")
+	collectDir(t, collector, shadowPath, files[:1]...)
+	collector.verifyPart("default.s3", tm, "s3", metadata.Part{Name: "all_1_1_0", Checksums: recorded})
+	collector.verifyPart("default.s3", tm, "s3", metadata.Part{Name: "all_2_2_0"})
+	assert.Equal(t, []string{"default.s3: all_1_1_0/data.bin not found", "default.s3: all_2_2_0/checksums.txt not found"}, result.messages)
+	assert.False(t, isZeroCopyDisk(tm, "default"))
+}
+
+func TestNativeFilesCollector(t *testing.T) {
+	nativePath := t.TempDir()
+	partPath := path.Join(nativePath, "data", "default", "t", "all_1_1_0")
+	require.NoError(t, os.MkdirAll(partPath, 0750))
+	require.NoError(t, ioutil.WriteFile(path.Join(partPath, "data.bin"), []byte("12345"), 0640))
+	size, hash, err := checksums.HashFile(path.Join(partPath, "data.bin"))
+	require.NoError(t, err)
+	backupFile := fmt.Sprintf(`<config><version>1</version><contents>
+<file><name>data/default/t/all_1_1_0/data.bin</name><size>%d</size><checksum>%s</checksum></file>
+<file><name>data/default/t/all_2_2_0/data.bin</name><size>%d</size><checksum>%s</checksum><data_file>data/default/t/all_1_1_0/data.bin</data_file></file>
+<file><name>data/default/t/all_1_1_0/count.txt</name><size>2</size><checksum>0</checksum><use_base>true</use_base></file>
+<file><name>metadata/default/t.sql</name><size>0</size></file>
+</contents></config>`, size, checksums.FormatHash(hash), size, checksums.FormatHash(hash))
+	require.NoError(t, ioutil.WriteFile(path.Join(nativePath, ".backup"), []byte(backupFile), 0640))
+	files := []string{".backup", "data/default/t/all_1_1_0/data.bin"}
+
+	result := &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector := newNativeFilesCollector(result)
+	for _, name := range files {
+		f, err := os.Open(path.Join(nativePath, name))
+		require.NoError(t, err)
+		require.NoError(t, collector.collect("/"+name, f))
+		_ = f.Close()
+	}
+	collector.verify(nativeBackupDir)
+	assert.Equal(t, int64(0), result.problems)
+	assert.Equal(t, int64(1), result.files)
+
+	result = &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector = newNativeFilesCollector(result)
+	require.NoError(t, collector.collect(".backup", strings.NewReader(backupFile)))
+	require.NoError(t, collector.collect("data/default/t/all_1_1_0/data.bin", strings.NewReader("54321")))
+	collector.verify(nativeBackupDir)
+	// deduplicated file is checked for each entry which refer it
+	assert.Equal(t, int64(2), result.problems)
+	assert.Contains(t, result.messages[0], "native/data/default/t/all_1_1_0/data.bin hash mismatch")
+
+	result = &verifyResult{log: apexLog.WithField("test", t.Name())}
+	collector = newNativeFilesCollector(result)
+	collector.verify(nativeBackupDir)
+	assert.Equal(t, []string{"native/.backup not found"}, result.messages)
+}
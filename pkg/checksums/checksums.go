@@ -0,0 +1,251 @@
+// Package checksums read ClickHouse data part checksums.txt and calculate file hashes in the same way as ClickHouse HashingWriteBuffer.
+package checksums
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-faster/city"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	// FileName - name of checksums file inside part directory
+	FileName = "checksums.txt"
+
+	hashingBlockSize = 2048
+
+	compressionMethodNone = 0x02
+	compressionMethodLZ4  = 0x82
+	compressionMethodZSTD = 0x90
+)
+
+// FileChecksum - one checksums.txt record
+type FileChecksum struct {
+	Size             uint64
+	Hash             city.U128
+	IsCompressed     bool
+	UncompressedSize uint64
+	UncompressedHash city.U128
+}
+
+// ParseFile - read checksums.txt from part directory file
+func ParseFile(filePath string) (map[string]FileChecksum, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse - read checksums.txt, binary formats version 3 and 4 are supported
+func Parse(r io.Reader) (map[string]FileChecksum, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("can't read checksums header: %v", err)
+	}
+	var version int
+	if _, err := fmt.Sscanf(strings.TrimSpace(header), "checksums format version: %d", &version); err != nil {
+		return nil, fmt.Errorf("unexpected checksums header %q", header)
+	}
+	switch version {
+	case 3:
+		return parseBinary(br)
+	case 4:
+		body, err := decompress(br)
+		if err != nil {
+			return nil, err
+		}
+		return parseBinary(bufio.NewReader(bytes.NewReader(body)))
+	}
+	return nil, fmt.Errorf("unsupported checksums format version %d", version)
+}
+
+func parseBinary(r *bufio.Reader) (map[string]FileChecksum, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't read checksums count: %v", err)
+	}
+	result := make(map[string]FileChecksum, count)
+	for i := uint64(0); i < count; i++ {
+		nameLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		c := FileChecksum{}
+		if c.Size, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		if c.Hash, err = readHash(r); err != nil {
+			return nil, err
+		}
+		isCompressed, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if isCompressed != 0 {
+			c.IsCompressed = true
+			if c.UncompressedSize, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+			if c.UncompressedHash, err = readHash(r); err != nil {
+				return nil, err
+			}
+		}
+		result[string(name)] = c
+	}
+	return result, nil
+}
+
+func readHash(r io.Reader) (city.U128, error) {
+	var buf [16]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return city.U128{}, err
+	}
+	return city.U128{Low: binary.LittleEndian.Uint64(buf[0:8]), High: binary.LittleEndian.Uint64(buf[8:16])}, nil
+}
+
+// decompress - read ClickHouse compressed blocks: 16 bytes checksum, 1 byte method, 4 bytes compressed size with 9 bytes header, 4 bytes decompressed size
+func decompress(r io.Reader) ([]byte, error) {
+	var result bytes.Buffer
+	for {
+		checksum, err := readHash(r)
+		if err == io.EOF {
+			return result.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		header := make([]byte, 9)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		compressedSize := binary.LittleEndian.Uint32(header[1:5])
+		decompressedSize := binary.LittleEndian.Uint32(header[5:9])
+		if compressedSize < 9 {
+			return nil, fmt.Errorf("wrong compressed block size %d", compressedSize)
+		}
+		block := make([]byte, compressedSize)
+		copy(block, header)
+		if _, err := io.ReadFull(r, block[9:]); err != nil {
+			return nil, err
+		}
+		if city.CH128(block) != checksum {
+			return nil, fmt.Errorf("compressed block checksum mismatch")
+		}
+		data := make([]byte, decompressedSize)
+		switch header[0] {
+		case compressionMethodNone:
+			data = block[9:]
+		case compressionMethodLZ4:
+			if _, err := lz4.UncompressBlock(block[9:], data); err != nil {
+				return nil, err
+			}
+		case compressionMethodZSTD:
+			decoder, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			data, err = decoder.DecodeAll(block[9:], nil)
+			decoder.Close()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported compression method 0x%x", header[0])
+		}
+		result.Write(data)
+	}
+}
+
+// Hasher - io.Writer which calculate CityHash128 chained by 2048 bytes blocks, the same way as ClickHouse HashingWriteBuffer
+type Hasher struct {
+	state city.U128
+	block []byte
+	size  uint64
+}
+
+// NewHasher - create empty Hasher
+func NewHasher() *Hasher {
+	return &Hasher{block: make([]byte, 0, hashingBlockSize)}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.size += uint64(n)
+	if len(h.block) > 0 {
+		free := hashingBlockSize - len(h.block)
+		if len(p) < free {
+			h.block = append(h.block, p...)
+			return n, nil
+		}
+		h.block = append(h.block, p[:free]...)
+		h.state = city.CH128Seed(h.block, h.state)
+		h.block = h.block[:0]
+		p = p[free:]
+	}
+	for len(p) >= hashingBlockSize {
+		h.state = city.CH128Seed(p[:hashingBlockSize], h.state)
+		p = p[hashingBlockSize:]
+	}
+	h.block = append(h.block, p...)
+	return n, nil
+}
+
+// Sum - hash of all written data
+func (h *Hasher) Sum() city.U128 {
+	if len(h.block) > 0 {
+		return city.CH128Seed(h.block, h.state)
+	}
+	return h.state
+}
+
+// Size - count of written bytes
+func (h *Hasher) Size() uint64 {
+	return h.size
+}
+
+// HashFile - calculate size and hash of local file
+func HashFile(filePath string) (uint64, city.U128, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, city.U128{}, err
+	}
+	defer f.Close()
+	h := NewHasher()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, city.U128{}, err
+	}
+	return h.Size(), h.Sum(), nil
+}
+
+// Verify - compare calculated size and hash with checksums.txt record
+func (c FileChecksum) Verify(size uint64, hash city.U128) error {
+	if c.Size != size {
+		return fmt.Errorf("size mismatch, expected %d, actual %d", c.Size, size)
+	}
+	if c.Hash != hash {
+		return fmt.Errorf("hash mismatch, expected %s, actual %s", FormatHash(c.Hash), FormatHash(hash))
+	}
+	return nil
+}
+
+// FormatHash - hex representation same as ClickHouse use in system.parts hash_of_all_files
+func FormatHash(h city.U128) string {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[0:8], h.Low)
+	binary.LittleEndian.PutUint64(buf[8:16], h.High)
+	return fmt.Sprintf("%x", buf)
+}
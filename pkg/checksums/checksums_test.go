@@ -0,0 +1,114 @@
+package checksums
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/go-faster/city"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeHash(buf *bytes.Buffer, h city.U128) {
+	_ = binary.Write(buf, binary.LittleEndian, h.Low)
+	_ = binary.Write(buf, binary.LittleEndian, h.High)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	buf.Write(tmp[:binary.PutUvarint(tmp, v)])
+}
+
+// compressBlock - produce ClickHouse compressed block
+func compressBlock(t *testing.T, method byte, data []byte) []byte {
+	payload := data
+	if method == compressionMethodLZ4 {
+		payload = make([]byte, lz4.CompressBlockBound(len(data)))
+		n, err := lz4.CompressBlock(data, payload, nil)
+		require.NoError(t, err)
+		payload = payload[:n]
+	}
+	block := make([]byte, 9, 9+len(payload))
+	block[0] = method
+	binary.LittleEndian.PutUint32(block[1:5], uint32(9+len(payload)))
+	binary.LittleEndian.PutUint32(block[5:9], uint32(len(data)))
+	block = append(block, payload...)
+	var buf bytes.Buffer
+	writeHash(&buf, city.CH128(block))
+	buf.Write(block)
+	return buf.Bytes()
+}
+
+func TestHasherBlocks(t *testing.T) {
+	data := make([]byte, 3*hashingBlockSize+17)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	expected := city.U128{}
+	for i := 0; i < len(data); i += hashingBlockSize {
+		end := i + hashingBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		expected = city.CH128Seed(data[i:end], expected)
+	}
+
+	for _, chunk := range []int{1, 100, hashingBlockSize, 5000, len(data)} {
+		h := NewHasher()
+		for i := 0; i < len(data); i += chunk {
+			end := i + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			_, _ = h.Write(data[i:end])
+		}
+		assert.Equal(t, expected, h.Sum(), "chunk=%d", chunk)
+		assert.Equal(t, uint64(len(data)), h.Size())
+	}
+	assert.Equal(t, city.U128{}, NewHasher().Sum())
+}
+
+func TestParse(t *testing.T) {
+	h := NewHasher()
+	_, _ = h.Write([]byte("column data"))
+	var body bytes.Buffer
+	writeUvarint(&body, 2)
+	writeUvarint(&body, uint64(len("a.bin")))
+	body.WriteString("a.bin")
+	writeUvarint(&body, h.Size())
+	writeHash(&body, h.Sum())
+	body.WriteByte(1)
+	writeUvarint(&body, 100)
+	writeHash(&body, city.U128{Low: 1, High: 2})
+	writeUvarint(&body, uint64(len("count.txt")))
+	body.WriteString("count.txt")
+	writeUvarint(&body, 1)
+	writeHash(&body, city.U128{Low: 3, High: 4})
+	body.WriteByte(0)
+
+	v3 := append([]byte("checksums format version: 3\n"), body.Bytes()...)
+	v4 := append([]byte("checksums format version: 4\n"), compressBlock(t, compressionMethodLZ4, body.Bytes()[:10])...)
+	v4 = append(v4, compressBlock(t, compressionMethodNone, body.Bytes()[10:])...)
+
+	for _, content := range [][]byte{v3, v4} {
+		parsed, err := Parse(bytes.NewReader(content))
+		require.NoError(t, err)
+		require.Len(t, parsed, 2)
+		assert.True(t, parsed["a.bin"].IsCompressed)
+		assert.Equal(t, uint64(100), parsed["a.bin"].UncompressedSize)
+		assert.NoError(t, parsed["a.bin"].Verify(h.Size(), h.Sum()))
+		assert.Error(t, parsed["a.bin"].Verify(h.Size()+1, h.Sum()))
+		assert.Error(t, parsed["count.txt"].Verify(1, h.Sum()))
+		assert.False(t, parsed["count.txt"].IsCompressed)
+	}
+
+	corrupted := append([]byte{}, v4...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, err := Parse(bytes.NewReader(corrupted))
+	assert.Error(t, err)
+
+	_, err = Parse(bytes.NewReader([]byte("checksums format version: 2\n")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+)
+
+// accessEntityTypes - RBAC objects in order of creation, settings profiles and roles could be referenced by users, quotas and row policies
+var accessEntityTypes = []struct {
+	Type  string
+	Table string
+}{
+	{"SETTINGS PROFILE", "settings_profiles"},
+	{"ROLE", "roles"},
+	{"USER", "users"},
+	{"QUOTA", "quotas"},
+	{"ROW POLICY", "row_policies"},
+}
+
+type accessEntityRow struct {
+	Name     string `db:"name"`
+	Storage  string `db:"storage"`
+	Database string `db:"database"`
+	Table    string `db:"table"`
+}
+
+// GetAccessEntities - dump users, roles, row policies, quotas and settings profiles from writable access storages (`local directory` and `replicated`),
+// objects from users.xml and LDAP are managed outside clickhouse-server and skipped
+func (ch *ClickHouse) GetAccessEntities() ([]metadata.AccessEntity, error) {
+	var directories []struct {
+		Name string `db:"name"`
+		Type string `db:"type"`
+	}
+	if err := ch.Select(&directories, "SELECT name, type FROM system.user_directories"); err != nil {
+		log.Warnf("can't get system.user_directories, RBAC objects will not dump: %v", err)
+		return nil, nil
+	}
+	storageTypes := map[string]string{}
+	for _, d := range directories {
+		storageTypes[d.Name] = strings.ReplaceAll(d.Type, "_", " ")
+	}
+	entities := make([]metadata.AccessEntity, 0)
+	for _, entityType := range accessEntityTypes {
+		columns := "name, storage, '' AS database, '' AS table"
+		if entityType.Type == "ROW POLICY" {
+			columns = "short_name AS name, storage, database, table"
+		}
+		var rows []accessEntityRow
+		if err := ch.Select(&rows, fmt.Sprintf("SELECT %s FROM system.%s ORDER BY name", columns, entityType.Table)); err != nil {
+			return nil, fmt.Errorf("can't get %s list: %v", strings.ToLower(entityType.Type), err)
+		}
+		for _, row := range rows {
+			storageType := storageTypes[row.Storage]
+			if storageType != "local directory" && storageType != "replicated" {
+				continue
+			}
+			name := quoteAccessName(row.Name)
+			if entityType.Type == "ROW POLICY" {
+				name = fmt.Sprintf("%s ON %s.%s", quoteAccessName(row.Name), quoteAccessName(row.Database), quoteAccessName(row.Table))
+			}
+			entity := metadata.AccessEntity{
+				Type:       entityType.Type,
+				Name:       name,
+				Storage:    row.Storage,
+				Replicated: storageType == "replicated",
+			}
+			var createQuery []string
+			if err := ch.Select(&createQuery, fmt.Sprintf("SHOW CREATE %s %s", entityType.Type, name)); err != nil || len(createQuery) == 0 {
+				return nil, fmt.Errorf("can't get create query for %s %s: %v", strings.ToLower(entityType.Type), name, err)
+			}
+			entity.CreateQuery = createQuery[0]
+			if entityType.Type == "USER" || entityType.Type == "ROLE" {
+				if err := ch.Select(&entity.Grants, fmt.Sprintf("SHOW GRANTS FOR %s", name)); err != nil {
+					return nil, fmt.Errorf("can't get grants for %s %s: %v", strings.ToLower(entityType.Type), name, err)
+				}
+			}
+			entities = append(entities, entity)
+		}
+	}
+	return entities, nil
+}
+
+func quoteAccessName(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) + "`"
+}
@@ -7,14 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mxalis/clickhouse-backup/pkg/config"
@@ -31,15 +34,58 @@ type ClickHouse struct {
 	conn    *sqlx.DB
 	disks   []Disk
 	version int
+
+	ddlResults   []DistributedDDLResult
+	ddlResultsMu sync.Mutex
+
+	// connMu - protect conn and version, when several goroutines share ClickHouse only one of them reconnects in withFailover
+	connMu         sync.RWMutex
+	connGeneration uint64
 }
 
-// Connect - establish connection to ClickHouse
+// Connect - establish connection to the first healthy endpoint from `host` and `hosts`, see endpoints
 func (ch *ClickHouse) Connect() error {
+	ch.connMu.Lock()
+	defer ch.connMu.Unlock()
+	return ch.connectEndpoints()
+}
+
+func (ch *ClickHouse) connectEndpoints() error {
+	var errs []string
+	var lastErr error
+	for _, e := range ch.endpoints() {
+		err := ch.connect(e)
+		if err == nil {
+			err = ch.detectVersion(e)
+		}
+		if err == nil {
+			if len(errs) > 0 {
+				log.Warnf("clickhouse connected to %s after failover, %s", e, strings.Join(errs, "; "))
+			}
+			return nil
+		}
+		if ch.conn != nil {
+			_ = ch.conn.Close()
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", e, err))
+		lastErr = err
+	}
+	if len(errs) == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("all clickhouse hosts unavailable: %s", strings.Join(errs, "; "))
+}
+
+func (ch *ClickHouse) connect(e endpoint) error {
 	timeout, err := time.ParseDuration(ch.Config.Timeout)
 	if err != nil {
 		return err
 	}
 
+	// shorter per-operation timeouts are applied with context, see timeouts.go
+	if connectionTimeout := ch.connectionTimeout(); connectionTimeout > timeout {
+		timeout = connectionTimeout
+	}
 	timeoutSeconds := fmt.Sprintf("%d", int(timeout.Seconds()))
 	params := url.Values{}
 	params.Add("username", ch.Config.Username)
@@ -56,34 +102,39 @@ func (ch *ClickHouse) Connect() error {
 		params.Add("debug", "true")
 	}
 
+	var tlsConfig *tls.Config
 	if ch.Config.Secure {
 		params.Add("secure", "true")
 		params.Add("skip_verify", strconv.FormatBool(ch.Config.SkipVerify))
-		if ch.Config.TLSKey != "" || ch.Config.TLSCert != "" || ch.Config.TLSCa != "" {
-			tlsConfig := &tls.Config{
-				InsecureSkipVerify: ch.Config.SkipVerify,
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: ch.Config.SkipVerify,
+			ServerName:         ch.Config.TLSServerName,
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = e.serverName
+		}
+		if ch.Config.TLSCert != "" || ch.Config.TLSKey != "" {
+			cert, err := tls.LoadX509KeyPair(ch.Config.TLSCert, ch.Config.TLSKey)
+			if err != nil {
+				log.Errorf("tls.LoadX509KeyPair error: %v", err)
+				return err
 			}
-			if ch.Config.TLSCert != "" || ch.Config.TLSKey != "" {
-				cert, err := tls.LoadX509KeyPair("server.crt", "server.key")
-				if err != nil {
-					log.Errorf("tls.LoadX509KeyPair error: %v", err)
-					return err
-				}
-				tlsConfig.Certificates = []tls.Certificate{cert}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if ch.Config.TLSCa != "" {
+			caCert, err := ioutil.ReadFile(ch.Config.TLSCa)
+			if err != nil {
+				log.Errorf("read `tls_ca` file %s return error: %v ", ch.Config.TLSCa, err)
+				return err
 			}
-			if ch.Config.TLSCa != "" {
-				caCert, err := ioutil.ReadFile(ch.Config.TLSCa)
-				if err != nil {
-					log.Errorf("read `tls_ca` file %s return error: %v ", ch.Config.TLSCa, err)
-					return err
-				}
-				caCertPool := x509.NewCertPool()
-				if caCertPool.AppendCertsFromPEM(caCert) != true {
-					log.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
-					return fmt.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
-				}
-				tlsConfig.RootCAs = caCertPool
+			caCertPool := x509.NewCertPool()
+			if caCertPool.AppendCertsFromPEM(caCert) != true {
+				log.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
+				return fmt.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
 			}
+			tlsConfig.RootCAs = caCertPool
+		}
+		if ch.Config.Protocol != "http" && (ch.Config.TLSKey != "" || ch.Config.TLSCert != "" || ch.Config.TLSCa != "" || tlsConfig.ServerName != "") {
 			err = clickhouse.RegisterTLSConfig("clickhouse-backup", tlsConfig)
 			if err != nil {
 				log.Errorf("RegisterTLSConfig return error: %v", err)
@@ -97,13 +148,34 @@ func (ch *ClickHouse) Connect() error {
 	if !ch.Config.LogSQLQueries {
 		params.Add("log_queries", "0")
 	}
-	connectionString := fmt.Sprintf("tcp://%v:%v?%s", ch.Config.Host, ch.Config.Port, params.Encode())
-	if ch.conn, err = sqlx.Open("clickhouse", connectionString); err != nil {
-		return err
+	ch.applySettingsToParams(params)
+	if ch.Config.Protocol == "http" {
+		// HTTP interface accepts settings as URL parameters, options of clickhouse-go DSN are not settings
+		for _, option := range []string{"debug", "secure", "skip_verify", "connect_timeout", "receive_timeout", "send_timeout", "timeout", "read_timeout", "write_timeout"} {
+			params.Del(option)
+		}
+		ch.conn = sqlx.NewDb(sql.OpenDB(newHTTPConnector(e.host, e.port, ch.Config.Secure, tlsConfig, timeout, ch.maxConnections(), ch.connectionIdleTimeout(), params)), "clickhouse")
+	} else {
+		connectionString := fmt.Sprintf("tcp://%s?%s", net.JoinHostPort(e.host, strconv.FormatUint(uint64(e.port), 10)), params.Encode())
+		if settings := ch.querySettings(); len(settings) > 0 {
+			connector, err := newSettingsConnector(connectionString, settings)
+			if err != nil {
+				return err
+			}
+			ch.conn = sqlx.NewDb(sql.OpenDB(connector), "clickhouse")
+		} else if ch.conn, err = sqlx.Open("clickhouse", connectionString); err != nil {
+			return err
+		}
 	}
-	ch.conn.SetMaxOpenConns(1)
+	// connections are reused for queries of the same command, TLS handshake and authentication are done once per pooled connection
+	ch.conn.SetMaxOpenConns(ch.maxConnections())
 	ch.conn.SetConnMaxLifetime(0)
-	ch.conn.SetMaxIdleConns(0)
+	if idleTimeout := ch.connectionIdleTimeout(); idleTimeout > 0 {
+		ch.conn.SetMaxIdleConns(ch.maxConnections())
+		ch.conn.SetConnMaxIdleTime(idleTimeout)
+	} else {
+		ch.conn.SetMaxIdleConns(0)
+	}
 
 	return ch.conn.Ping()
 }
@@ -115,7 +187,7 @@ func (ch *ClickHouse) GetDisks() ([]Disk, error) {
 		return nil, err
 	}
 	var disks []Disk
-	if version < 19015000 {
+	if version < FeatureSystemDisks.MinVersion {
 		disks, err = ch.getDataPathFromSystemSettings()
 	} else {
 		disks, err = ch.getDataPathFromSystemDisks()
@@ -192,8 +264,31 @@ func (ch *ClickHouse) getDataPathFromSystemDisks() ([]Disk, error) {
 	return result, err
 }
 
+// maxConnections - size of connections pool, config could be created without DefaultConfig, so pool has at least one connection
+func (ch *ClickHouse) maxConnections() int {
+	if ch.Config.MaxConnections < 1 {
+		return 1
+	}
+	return ch.Config.MaxConnections
+}
+
+// connectionIdleTimeout - how long unused pooled connection is kept, 0 means connection per query
+func (ch *ClickHouse) connectionIdleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(ch.Config.ConnectionIdleTimeout)
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
 // Close - closing connection to ClickHouse
 func (ch *ClickHouse) Close() {
+	ch.connMu.Lock()
+	defer ch.connMu.Unlock()
+	ch.closeConn()
+}
+
+func (ch *ClickHouse) closeConn() {
 	if err := ch.conn.Close(); err != nil {
 		log.Warnf("can't close clickhouse connection: %v", err)
 	}
@@ -225,6 +320,9 @@ func (ch *ClickHouse) GetTables(tablePattern string) ([]Table, error) {
 				break
 			}
 		}
+		if !t.Skip && IsTableEngineInList(t.Engine, ch.Config.SkipTableEngines) {
+			t.Skip = true
+		}
 		if t.Skip {
 			tables[i] = t
 			continue
@@ -236,12 +334,22 @@ func (ch *ClickHouse) GetTables(tablePattern string) ([]Table, error) {
 	}
 	for i, table := range tables {
 		if table.TotalBytes == 0 && !table.Skip && strings.HasSuffix(table.Engine, "Tree") {
-			tables[i].TotalBytes = ch.getTableSizeFromParts(tables[i])
+			tables[i].TotalBytes, tables[i].TotalRows = ch.getTableSizeFromParts(tables[i])
 		}
 	}
 	return tables, nil
 }
 
+// IsTableEngineInList - check engine is present in engines list from config, like `skip_table_engines`, engine names are case-insensitive
+func IsTableEngineInList(engine string, engines []string) bool {
+	for _, e := range engines {
+		if strings.EqualFold(strings.Trim(e, " \t\r\n"), engine) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ch *ClickHouse) prepareAllTablesSQL(tablePattern string, err error, skipDatabases []string, isUUIDPresent []int) (string, error) {
 	isSystemTablesFieldPresent := make([]IsSystemTablesFieldPresent, 0)
 	isFieldPresentSQL := `
@@ -250,7 +358,8 @@ func (ch *ClickHouse) prepareAllTablesSQL(tablePattern string, err error, skipDa
 			countIf(name='data_paths') is_data_paths_present, 
 			countIf(name='uuid') is_uuid_present, 
 			countIf(name='create_table_query') is_create_table_query_present, 
-			countIf(name='total_bytes') is_total_bytes_present 
+			countIf(name='total_bytes') is_total_bytes_present, 
+			countIf(name='total_rows') is_total_rows_present 
 		FROM system.columns WHERE database='system' AND table='tables'
 	`
 	if err = ch.Select(&isSystemTablesFieldPresent, isFieldPresentSQL); err != nil {
@@ -273,6 +382,9 @@ func (ch *ClickHouse) prepareAllTablesSQL(tablePattern string, err error, skipDa
 	if len(isSystemTablesFieldPresent) > 0 && isSystemTablesFieldPresent[0].IsTotalBytesPresent > 0 {
 		allTablesSQL += ", coalesce(total_bytes, 0) AS total_bytes "
 	}
+	if len(isSystemTablesFieldPresent) > 0 && isSystemTablesFieldPresent[0].IsTotalRowsPresent > 0 {
+		allTablesSQL += ", coalesce(total_rows, 0) AS total_rows "
+	}
 
 	allTablesSQL += "  FROM system.tables WHERE is_temporary = 0"
 	if tablePattern != "" {
@@ -309,18 +421,20 @@ func (ch *ClickHouse) GetDatabases() ([]Database, error) {
 	return allDatabases, nil
 }
 
-func (ch *ClickHouse) getTableSizeFromParts(table Table) uint64 {
+// getTableSizeFromParts - bytes and rows of active parts, for versions where system.tables doesn't contain total_bytes and total_rows
+func (ch *ClickHouse) getTableSizeFromParts(table Table) (uint64, uint64) {
 	var tablesSize []struct {
 		Size uint64 `db:"size"`
+		Rows uint64 `db:"rows"`
 	}
-	query := fmt.Sprintf("SELECT sum(bytes_on_disk) as size FROM system.parts WHERE active AND database='%s' AND table='%s' GROUP BY database, table", table.Database, table.Name)
+	query := fmt.Sprintf("SELECT sum(bytes_on_disk) as size, sum(rows) as rows FROM system.parts WHERE active AND database='%s' AND table='%s' GROUP BY database, table", table.Database, table.Name)
 	if err := ch.SoftSelect(&tablesSize, query); err != nil {
 		log.Warnf("error parsing tablesSize: %w", err)
 	}
 	if len(tablesSize) > 0 {
-		return tablesSize[0].Size
+		return tablesSize[0].Size, tablesSize[0].Rows
 	}
-	return 0
+	return 0, 0
 }
 
 func (ch *ClickHouse) fixVariousVersions(t Table) Table {
@@ -348,20 +462,27 @@ func (ch *ClickHouse) fixVariousVersions(t Table) Table {
 // GetVersion - returned ClickHouse version in number format
 // Example value: 19001005
 func (ch *ClickHouse) GetVersion() (int, error) {
-	if ch.version != 0 {
-		return ch.version, nil
+	ch.connMu.RLock()
+	version := ch.version
+	ch.connMu.RUnlock()
+	if version != 0 {
+		return version, nil
 	}
 	var result []string
 	var err error
-	query := "SELECT value FROM `system`.`build_options` where name='VERSION_INTEGER'"
-	if err = ch.Select(&result, query); err != nil {
+	if err = ch.Select(&result, versionQuery); err != nil {
 		return 0, fmt.Errorf("can't get ClickHouse version: %w", err)
 	}
 	if len(result) == 0 {
 		return 0, nil
 	}
-	ch.version, err = strconv.Atoi(result[0])
-	return ch.version, err
+	if version, err = strconv.Atoi(result[0]); err != nil {
+		return 0, err
+	}
+	ch.connMu.Lock()
+	ch.version = version
+	ch.connMu.Unlock()
+	return version, nil
 }
 
 func (ch *ClickHouse) GetVersionDescribe() string {
@@ -373,21 +494,28 @@ func (ch *ClickHouse) GetVersionDescribe() string {
 	return result[0]
 }
 
-// FreezeTableOldWay - freeze all partitions in table one by one
+// FreezeTableOldWay - freeze all partitions in table one by one, when partitions is not empty only partitions with these IDs are frozen
 // This way using for ClickHouse below v19.1
-func (ch *ClickHouse) FreezeTableOldWay(table *Table, name string) error {
-	var partitions []struct {
+func (ch *ClickHouse) FreezeTableOldWay(table *Table, name string, partitions []string) error {
+	var tablePartitions []struct {
 		PartitionID string `db:"partition_id"`
 	}
 	q := fmt.Sprintf("SELECT DISTINCT partition_id FROM `system`.`parts` WHERE database='%s' AND table='%s' %s", table.Database, table.Name, ch.Config.FreezeByPartWhere)
-	if err := ch.conn.Select(&partitions, q); err != nil {
+	if err := ch.GetConn().Select(&tablePartitions, q); err != nil {
 		return fmt.Errorf("can't get partitions for '%s.%s': %w", table.Database, table.Name, err)
 	}
+	selected := make(map[string]bool, len(partitions))
+	for _, id := range partitions {
+		selected[id] = true
+	}
 	withNameQuery := ""
 	if name != "" {
 		withNameQuery = fmt.Sprintf("WITH NAME '%s'", name)
 	}
-	for _, item := range partitions {
+	for _, item := range tablePartitions {
+		if len(selected) > 0 && !selected[item.PartitionID] {
+			continue
+		}
 		log.Debugf("  partition '%v'", item.PartitionID)
 		query := fmt.Sprintf(
 			"ALTER TABLE `%v`.`%v` FREEZE PARTITION ID '%v' %s;",
@@ -404,7 +532,7 @@ func (ch *ClickHouse) FreezeTableOldWay(table *Table, name string) error {
 				withNameQuery,
 			)
 		}
-		if _, err := ch.Query(query); err != nil {
+		if _, err := ch.execWithTimeout(ch.Config.FreezeTimeout, query); err != nil {
 			if (strings.Contains(err.Error(), "code: 60") || strings.Contains(err.Error(), "code: 81")) && ch.Config.IgnoreNotExistsErrorDuringFreeze {
 				log.Warnf("can't freeze partition: %v", err)
 			} else {
@@ -415,30 +543,29 @@ func (ch *ClickHouse) FreezeTableOldWay(table *Table, name string) error {
 	return nil
 }
 
-// FreezeTable - freeze all partitions for table
+// FreezeTable - freeze all partitions for table, or only partitions with IDs from partitions with FREEZE PARTITION
 // This way available for ClickHouse since v19.1
-func (ch *ClickHouse) FreezeTable(table *Table, name string) error {
+func (ch *ClickHouse) FreezeTable(table *Table, name string, partitions []string) error {
 	version, err := ch.GetVersion()
 	if err != nil {
 		return err
 	}
 	if strings.HasPrefix(table.Engine, "Replicated") && ch.Config.SyncReplicatedTables {
-		query := fmt.Sprintf("SYSTEM SYNC REPLICA `%s`.`%s`;", table.Database, table.Name)
-		if _, err := ch.Query(query); err != nil {
+		if err := ch.SyncReplica(table.Database, table.Name); err != nil {
 			log.Warnf("can't sync replica: %v", err)
 		} else {
 			log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).Debugf("replica synced")
 		}
 	}
-	if version < 19001005 || ch.Config.FreezeByPart {
-		return ch.FreezeTableOldWay(table, name)
+	if version < FeatureFreezeTable.MinVersion || ch.Config.FreezeByPart || len(partitions) > 0 {
+		return ch.FreezeTableOldWay(table, name, partitions)
 	}
 	withNameQuery := ""
 	if name != "" {
 		withNameQuery = fmt.Sprintf("WITH NAME '%s'", name)
 	}
 	query := fmt.Sprintf("ALTER TABLE `%s`.`%s` FREEZE %s;", table.Database, table.Name, withNameQuery)
-	if _, err := ch.Query(query); err != nil {
+	if _, err := ch.execWithTimeout(ch.Config.FreezeTimeout, query); err != nil {
 		if (strings.Contains(err.Error(), "code: 60") || strings.Contains(err.Error(), "code: 81")) && ch.Config.IgnoreNotExistsErrorDuringFreeze {
 			log.Warnf("can't freeze table: %v", err)
 			return nil
@@ -448,6 +575,27 @@ func (ch *ClickHouse) FreezeTable(table *Table, name string) error {
 	return nil
 }
 
+// UnfreezeTable - remove shadow increment created by FreezeTable with name, ClickHouse releases references of object storage disks,
+// ClickHouse below v21.4 doesn't support UNFREEZE, shadow folder shall be removed by caller anyway
+func (ch *ClickHouse) UnfreezeTable(table *Table, name string) error {
+	version, err := ch.GetVersion()
+	if err != nil {
+		return err
+	}
+	if version < FeatureUnfreeze.MinVersion || name == "" {
+		return nil
+	}
+	query := fmt.Sprintf("ALTER TABLE `%s`.`%s` UNFREEZE WITH NAME '%s'", table.Database, table.Name, name)
+	if _, err := ch.execWithTimeout(ch.Config.FreezeTimeout, query); err != nil {
+		if (strings.Contains(err.Error(), "code: 60") || strings.Contains(err.Error(), "code: 81")) && ch.Config.IgnoreNotExistsErrorDuringFreeze {
+			log.Warnf("can't unfreeze table: %v", err)
+			return nil
+		}
+		return fmt.Errorf("can't unfreeze table: %v", err)
+	}
+	return nil
+}
+
 // AttachPartitions - execute ATTACH command for specific table
 func (ch *ClickHouse) AttachPartitions(table metadata.TableMetadata, disks []Disk) error {
 	for _, disk := range disks {
@@ -469,17 +617,31 @@ func (ch *ClickHouse) ShowCreateTable(database, name string) string {
 		Statement string `db:"statement"`
 	}
 	query := fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`;", database, name)
-	if err := ch.conn.Select(&result, query); err != nil {
+	if err := ch.GetConn().Select(&result, query); err != nil {
 		return ""
 	}
 	return result[0].Statement
 }
 
-// CreateDatabase - create ClickHouse database
-func (ch *ClickHouse) CreateDatabase(database string) error {
+// CreateDatabase - create ClickHouse database, with not empty cluster database is created on all cluster hosts
+func (ch *ClickHouse) CreateDatabase(database string, cluster string) error {
 	query := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", database)
-	_, err := ch.Query(query)
-	return err
+	if cluster != "" {
+		query += " ON CLUSTER '" + cluster + "'"
+	}
+	return ch.queryDDL(query, cluster)
+}
+
+// GetDefaultDatabaseEngine - engine of database created without ENGINE clause, versions without `default_database_engine` setting support only Ordinary
+func (ch *ClickHouse) GetDefaultDatabaseEngine() (string, error) {
+	var engine []string
+	if err := ch.Select(&engine, "SELECT value FROM system.settings WHERE name='default_database_engine'"); err != nil {
+		return "", err
+	}
+	if len(engine) == 0 {
+		return "Ordinary", nil
+	}
+	return engine[0], nil
 }
 
 func (ch *ClickHouse) CreateDatabaseWithEngine(database string, engine string) error {
@@ -488,12 +650,29 @@ func (ch *ClickHouse) CreateDatabaseWithEngine(database string, engine string) e
 	return err
 }
 
-func (ch *ClickHouse) CreateDatabaseFromQuery(query string) error {
+var createDatabaseOnClusterRe = regexp.MustCompile("(?is)^(CREATE\\s+DATABASE\\s+IF\\s+NOT\\s+EXISTS\\s+(?:`(?:[^`\\\\]|\\\\.)+`|\"[^\"]+\"|\\w+)(?:\\s+UUID\\s+'[^']+')?)")
+
+var atomicDatabaseEngineRe = regexp.MustCompile("(?is)\\s+UUID\\s+'[^']+'(.*\\sENGINE\\s*=\\s*)Atomic\\b|(\\sENGINE\\s*=\\s*)Atomic\\b")
+
+// CreateDatabaseFromQuery - create database from backup query, Atomic database is created as Ordinary on clickhouse-server without Atomic engine
+func (ch *ClickHouse) CreateDatabaseFromQuery(query string, cluster string) error {
+	if atomicDatabaseEngineRe.MatchString(query) {
+		if version, err := ch.GetVersion(); err != nil {
+			return err
+		} else if version < FeatureAtomicDatabase.MinVersion {
+			log.Warnf("clickhouse-server %s doesn't support Atomic databases, database will create with Ordinary engine: %s", FormatVersion(version), query)
+			query = atomicDatabaseEngineRe.ReplaceAllString(query, "${1}${2}Ordinary")
+		}
+	}
 	if !strings.HasPrefix(query, "CREATE DATABASE IF NOT EXISTS") {
 		query = strings.Replace(query, "CREATE DATABASE", "CREATE DATABASE IF NOT EXISTS", 1)
 	}
-	_, err := ch.Query(query)
-	return err
+	if cluster != "" && createDatabaseOnClusterRe.MatchString(query) && !strings.Contains(strings.ToUpper(query), " ON CLUSTER ") {
+		query = createDatabaseOnClusterRe.ReplaceAllString(query, "$1 ON CLUSTER '"+cluster+"'")
+	} else {
+		cluster = ""
+	}
+	return ch.queryDDL(query, cluster)
 }
 
 // DropTable - drop ClickHouse table
@@ -510,14 +689,24 @@ func (ch *ClickHouse) DropTable(table Table, query string, onCluster string, ver
 	dropQuery := fmt.Sprintf("DROP %s IF EXISTS `%s`.`%s`", kind, table.Database, table.Name)
 	if version > 19000000 && onCluster != "" {
 		dropQuery += " ON CLUSTER '" + onCluster + "' "
+	} else {
+		onCluster = ""
 	}
 	if isAtomic {
 		dropQuery += " NO DELAY"
 	}
-	if _, err := ch.Query(dropQuery); err != nil {
-		return err
+	return ch.queryDDL(dropQuery, onCluster)
+}
+
+// DetachTablePermanently - detach table which shall not be attached after clickhouse-server restart, table could be attached back with `ATTACH TABLE`
+func (ch *ClickHouse) DetachTablePermanently(table Table, onCluster string, version int) error {
+	detachQuery := fmt.Sprintf("DETACH TABLE `%s`.`%s`", table.Database, table.Name)
+	if version > 19000000 && onCluster != "" {
+		detachQuery += " ON CLUSTER '" + onCluster + "'"
+	} else {
+		onCluster = ""
 	}
-	return nil
+	return ch.queryDDL(detachQuery+" PERMANENTLY", onCluster)
 }
 
 var createViewToClauseRe = regexp.MustCompile(`(?im)^(CREATE[\s\w]+VIEW[^(]+)(\s+TO\s+.+)`)
@@ -544,6 +733,8 @@ func (ch *ClickHouse) CreateTable(table Table, query string, dropTable bool, onC
 				break
 			}
 		}
+	} else if !onClusterRe.MatchString(query) {
+		onCluster = ""
 	}
 
 	if !strings.Contains(query, table.Name) {
@@ -571,15 +762,13 @@ func (ch *ClickHouse) CreateTable(table Table, query string, dropTable bool, onC
 		query = strings.Replace(query, fmt.Sprintf("%s", table.Name), fmt.Sprintf("%s.%s", table.Database, table.Name), 1)
 	}
 
-	if _, err := ch.Query(query); err != nil {
-		return err
-	}
-	return nil
+	return ch.queryDDL(query, onCluster)
 }
 
-// GetConn - return current connection
+// GetConn - return current connection, it could be replaced by failover from other goroutine
 func (ch *ClickHouse) GetConn() *sqlx.DB {
-	return ch.conn
+	conn, _ := ch.connection()
+	return conn
 }
 
 func IsClickhouseShadow(path string) bool {
@@ -607,10 +796,13 @@ func IsClickhouseShadow(path string) bool {
 	return true
 }
 
+// SoftSelect - select into dest with columns which don't have field in struct, limited by `metadata_timeout`
 func (ch *ClickHouse) SoftSelect(dest interface{}, query string) error {
-	rows, err := ch.Queryx(query)
+	ctx, cancel, duration := ch.timeoutContext(ch.Config.MetadataTimeout)
+	defer cancel()
+	rows, err := ch.queryxContext(ctx, query)
 	if err != nil {
-		return err
+		return timeoutError(err, ctx, duration)
 	}
 	defer func() {
 		err = rows.Close()
@@ -669,7 +861,7 @@ func (ch *ClickHouse) SoftSelect(dest interface{}, query string) error {
 		}
 
 	}
-	return rows.Err()
+	return timeoutError(rows.Err(), ctx, duration)
 }
 
 // GetPartitions - return slice of all partitions for a table
@@ -712,16 +904,24 @@ func (ch *ClickHouse) GetPartitions(database, table string) (map[string][]metada
 	return result, nil
 }
 
+// Query - execute query limited by `timeout`
 func (ch *ClickHouse) Query(query string, args ...interface{}) (sql.Result, error) {
-	return ch.conn.Exec(ch.LogQuery(query), args...)
+	return ch.execWithTimeout(ch.Config.Timeout, query, args...)
 }
 
 func (ch *ClickHouse) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
-	return ch.conn.Queryx(ch.LogQuery(query), args...)
+	query = ch.LogQuery(query)
+	var rows *sqlx.Rows
+	err := ch.withFailover(func(conn *sqlx.DB) (err error) {
+		rows, err = conn.Queryx(query, args...)
+		return err
+	})
+	return rows, err
 }
 
+// Select - metadata query limited by `metadata_timeout`
 func (ch *ClickHouse) Select(dest interface{}, query string, args ...interface{}) error {
-	return ch.conn.Select(dest, ch.LogQuery(query), args...)
+	return ch.selectWithTimeout(ch.Config.MetadataTimeout, dest, query, args...)
 }
 
 func (ch *ClickHouse) LogQuery(query string) string {
@@ -783,13 +983,21 @@ func (ch *ClickHouse) GetUserDefinedFunctions() ([]Function, error) {
 	return allFunctions, nil
 }
 
-func (ch *ClickHouse) CreateUserDefinedFunction(name string, query string) error {
-	_, err := ch.Query(fmt.Sprintf("DROP FUNCTION IF EXISTS `%s`", name))
-	if err != nil {
+var createFunctionRe = regexp.MustCompile("(?is)^(\\s*CREATE\\s+FUNCTION\\s+(?:`(?:[^`\\\\]|\\\\.)+`|\\w+))")
+
+// CreateUserDefinedFunction - replace SQL user defined function, with not empty cluster function is replaced on all cluster hosts
+func (ch *ClickHouse) CreateUserDefinedFunction(name string, query string, cluster string) error {
+	onCluster := ""
+	if cluster != "" {
+		onCluster = " ON CLUSTER '" + cluster + "'"
+		if !strings.Contains(strings.ToUpper(query), " ON CLUSTER ") {
+			query = createFunctionRe.ReplaceAllString(query, "${1}"+onCluster)
+		}
+	}
+	if err := ch.queryDDL(fmt.Sprintf("DROP FUNCTION IF EXISTS `%s`%s", name, onCluster), cluster); err != nil {
 		return err
 	}
-	_, err = ch.Query(query)
-	return err
+	return ch.queryDDL(query, cluster)
 }
 
 func CalculateMaxFileSize(cfg *config.Config) (int64, error) {
@@ -824,22 +1032,56 @@ func ApplyMacros(cfg *config.Config, path string) string {
 		return path
 	}
 	defer ch.Close()
-	macrosExists := make([]int, 0)
-	err := ch.Select(&macrosExists, "SELECT count() AS is_macros_exists FROM system.tables WHERE database='system' AND table='macros'")
-	if err != nil || len(macrosExists) == 0 || macrosExists[0] == 0 {
-		return path
-	}
-	macros := make([]macro, 0)
-	err = ch.SoftSelect(&macros, "SELECT * FROM system.macros")
+	macros, err := ch.GetMacros()
 	if err != nil || len(macros) == 0 {
 		return path
 	}
 
-	replaces := make([]string, len(macros)*2)
-	for i, macro := range macros {
-		replaces[i*2] = fmt.Sprintf("{%s}", macro.Macro)
-		replaces[i*2+1] = fmt.Sprintf("%s", macro.Substitution)
+	replaces := make([]string, 0, len(macros)*2)
+	for name, substitution := range macros {
+		replaces = append(replaces, fmt.Sprintf("{%s}", name), substitution)
 	}
 	path = strings.NewReplacer(replaces...).Replace(path)
 	return path
 }
+
+// GetMacros - return macros from system.macros, empty map when system.macros doesn't exist
+func (ch *ClickHouse) GetMacros() (map[string]string, error) {
+	result := map[string]string{}
+	macrosExists := make([]int, 0)
+	if err := ch.Select(&macrosExists, "SELECT count() AS is_macros_exists FROM system.tables WHERE database='system' AND table='macros'"); err != nil {
+		return nil, err
+	}
+	if len(macrosExists) == 0 || macrosExists[0] == 0 {
+		return result, nil
+	}
+	macros := make([]macro, 0)
+	if err := ch.SoftSelect(&macros, "SELECT * FROM system.macros"); err != nil {
+		return nil, err
+	}
+	for _, m := range macros {
+		result[m.Macro] = m.Substitution
+	}
+	return result, nil
+}
+
+// GetStoragePolicies - disks of all volumes for each storage policy, ClickHouse before 19.15 doesn't have storage policies
+func (ch *ClickHouse) GetStoragePolicies() (map[string][]string, error) {
+	result := map[string][]string{}
+	version, err := ch.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version < FeatureSystemDisks.MinVersion {
+		return result, nil
+	}
+	policies := make([]storagePolicy, 0)
+	if err := ch.SoftSelect(&policies, "SELECT policy_name, groupUniqArrayArray(disks) AS disks FROM system.storage_policies GROUP BY policy_name"); err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		sort.Strings(p.Disks)
+		result[p.PolicyName] = p.Disks
+	}
+	return result, nil
+}
@@ -0,0 +1,23 @@
+package clickhouse
+
+import "fmt"
+
+// ClusterReplica - host of cluster from system.clusters
+type ClusterReplica struct {
+	ShardNum   uint32 `db:"shard_num"`
+	ReplicaNum uint32 `db:"replica_num"`
+	HostName   string `db:"host_name"`
+	IsLocal    uint8  `db:"is_local"`
+}
+
+// GetClusterReplicas - all hosts of cluster ordered by shard and replica, error when cluster is not defined in `remote_servers`
+func (ch *ClickHouse) GetClusterReplicas(cluster string) ([]ClusterReplica, error) {
+	replicas := make([]ClusterReplica, 0)
+	if err := ch.Select(&replicas, "SELECT shard_num, replica_num, host_name, is_local FROM system.clusters WHERE cluster=? ORDER BY shard_num, replica_num", cluster); err != nil {
+		return nil, err
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("cluster '%s' is not found in system.clusters", cluster)
+	}
+	return replicas, nil
+}
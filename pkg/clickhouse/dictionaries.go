@@ -0,0 +1,53 @@
+package clickhouse
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// AppendMissingDictionaries - add DDL dictionaries from system.dictionaries which are not present in system.tables, old clickhouse-server versions don't show dictionaries in system.tables
+func (ch *ClickHouse) AppendMissingDictionaries(tables []Table) ([]Table, error) {
+	isDatabasePresent := make([]int, 0)
+	if err := ch.Select(&isDatabasePresent, "SELECT count() FROM system.columns WHERE database='system' AND table='dictionaries' AND name='database'"); err != nil {
+		return nil, err
+	}
+	if len(isDatabasePresent) == 0 || isDatabasePresent[0] == 0 {
+		return tables, nil
+	}
+	var dictionaries []struct {
+		Database string `db:"database"`
+		Name     string `db:"name"`
+	}
+	if err := ch.Select(&dictionaries, "SELECT database, name FROM system.dictionaries WHERE database != ''"); err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, t := range tables {
+		existing[t.Database+"."+t.Name] = true
+	}
+	for _, d := range dictionaries {
+		if existing[d.Database+"."+d.Name] {
+			continue
+		}
+		table := Table{Database: d.Database, Name: d.Name, Engine: "Dictionary"}
+		for _, filter := range ch.Config.SkipTables {
+			if matched, _ := filepath.Match(strings.Trim(filter, " \t\r\n"), fmt.Sprintf("%s.%s", d.Database, d.Name)); matched {
+				table.Skip = true
+				break
+			}
+		}
+		if !table.Skip {
+			var createQuery []string
+			if err := ch.Select(&createQuery, fmt.Sprintf("SHOW CREATE DICTIONARY `%s`.`%s`", d.Database, d.Name)); err != nil || len(createQuery) == 0 {
+				log.Warnf("can't get create query for dictionary `%s`.`%s`: %v", d.Database, d.Name, err)
+				continue
+			}
+			table.CreateTableQuery = createQuery[0]
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
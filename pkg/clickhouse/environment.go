@@ -0,0 +1,41 @@
+package clickhouse
+
+// GetServerInfo - hostName() and timezone() of clickhouse-server
+func (ch *ClickHouse) GetServerInfo() (string, string, error) {
+	var info []struct {
+		Hostname string `db:"hostname"`
+		Timezone string `db:"timezone"`
+	}
+	if err := ch.Select(&info, "SELECT hostName() AS hostname, timezone() AS timezone"); err != nil {
+		return "", "", err
+	}
+	if len(info) == 0 {
+		return "", "", nil
+	}
+	return info[0].Hostname, info[0].Timezone, nil
+}
+
+// GetChangedSettings - settings of current user and server MergeTree settings which differ from defaults
+func (ch *ClickHouse) GetChangedSettings() (map[string]string, map[string]string, error) {
+	settings, err := ch.selectSettings("SELECT name, value FROM system.settings WHERE changed")
+	if err != nil {
+		return nil, nil, err
+	}
+	mergeTreeSettings, err := ch.selectSettings("SELECT name, value FROM system.merge_tree_settings WHERE changed")
+	if err != nil {
+		return nil, nil, err
+	}
+	return settings, mergeTreeSettings, nil
+}
+
+func (ch *ClickHouse) selectSettings(query string) (map[string]string, error) {
+	var rows []setting
+	if err := ch.Select(&rows, query); err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(rows))
+	for _, row := range rows {
+		result[row.Name] = row.Value
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package clickhouse
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/jmoiron/sqlx"
+)
+
+// endpoint - address of clickhouse-server, serverName is host name from config when address is resolved from DNS name with several addresses
+type endpoint struct {
+	host       string
+	port       uint
+	serverName string
+}
+
+func (e endpoint) String() string {
+	return net.JoinHostPort(e.host, strconv.FormatUint(uint64(e.port), 10))
+}
+
+// endpoints - `host` and then `hosts` in config order, `host:port` items override `port`,
+// names which resolve to several addresses are expanded to each address, so DNS name of several servers works as the list of hosts
+func (ch *ClickHouse) endpoints() []endpoint {
+	hosts := append([]string{ch.Config.Host}, ch.Config.Hosts...)
+	result := make([]endpoint, 0, len(hosts))
+	for i, item := range hosts {
+		host, port := item, ch.Config.Port
+		if h, p, err := net.SplitHostPort(item); err == nil {
+			if parsedPort, err := strconv.ParseUint(p, 10, 16); err == nil {
+				host, port = h, uint(parsedPort)
+			}
+		}
+		if host == "" && i > 0 {
+			continue
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil || len(addrs) < 2 || net.ParseIP(host) != nil {
+			result = append(result, endpoint{host: host, port: port})
+			continue
+		}
+		for _, addr := range addrs {
+			result = append(result, endpoint{host: addr, port: port, serverName: host})
+		}
+	}
+	return result
+}
+
+// isDialError - connection to clickhouse-server can't be established, query was not sent and can be retried on another host
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isClosedDBError - query was started on connection which is closed by failover in other goroutine, database/sql doesn't export this error
+func isClosedDBError(err error) bool {
+	return strings.Contains(err.Error(), "sql: database is closed")
+}
+
+// connection - current connection and its generation, generation is increased by each failover reconnect
+func (ch *ClickHouse) connection() (*sqlx.DB, uint64) {
+	ch.connMu.RLock()
+	defer ch.connMu.RUnlock()
+	return ch.conn, ch.connGeneration
+}
+
+// withFailover - when current host refuses connection, for example during restart, connect to the next healthy endpoint and retry query once
+func (ch *ClickHouse) withFailover(query func(conn *sqlx.DB) error) error {
+	conn, generation := ch.connection()
+	err := query(conn)
+	if err == nil || !(isDialError(err) || isClosedDBError(err)) || len(ch.endpoints()) < 2 {
+		return err
+	}
+	if conn, err = ch.reconnect(generation, err); err != nil {
+		return err
+	}
+	return query(conn)
+}
+
+// reconnect - only first goroutine which got dial error on connection with this generation reconnects, others retry on its new connection
+func (ch *ClickHouse) reconnect(generation uint64, queryErr error) (*sqlx.DB, error) {
+	ch.connMu.Lock()
+	defer ch.connMu.Unlock()
+	if ch.connGeneration != generation {
+		return ch.conn, nil
+	}
+	log.Warnf("clickhouse connection lost: %v, try to failover", queryErr)
+	ch.closeConn()
+	if connectErr := ch.connectEndpoints(); connectErr != nil {
+		log.Warnf("clickhouse failover failed: %v", connectErr)
+		return nil, queryErr
+	}
+	ch.connGeneration++
+	return ch.conn, nil
+}
@@ -0,0 +1,69 @@
+package clickhouse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeHTTPServer - clickhouse-server HTTP interface which answer version query and `SELECT 1`
+func newFakeHTTPServer(queries *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(queries, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "VERSION_INTEGER") {
+			_, _ = w.Write([]byte("value\nString\n22008000\n"))
+			return
+		}
+		_, _ = w.Write([]byte("1\nUInt8\n1\n"))
+	}))
+}
+
+func TestConcurrentFailover(t *testing.T) {
+	var firstQueries, secondQueries int64
+	first := newFakeHTTPServer(&firstQueries)
+	second := newFakeHTTPServer(&secondQueries)
+	defer second.Close()
+
+	cfg := config.DefaultConfig().ClickHouse
+	cfg.Protocol = "http"
+	cfg.Host = strings.TrimPrefix(first.URL, "http://")
+	cfg.Hosts = []string{strings.TrimPrefix(second.URL, "http://")}
+	cfg.MaxConnections = 8
+	// each query dial, so all queries after first server stop get dial error instead of EOF on pooled connection
+	cfg.ConnectionIdleTimeout = "0s"
+	cfg.LogSQLQueries = false
+	ch := &ClickHouse{Config: &cfg}
+	require.NoError(t, ch.Connect())
+	defer ch.Close()
+	version, err := ch.GetVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 22008000, version)
+
+	first.Close()
+	var wg sync.WaitGroup
+	errs := make([]error, 32)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var result []uint64
+			errs[i] = ch.Select(&result, "SELECT 1")
+		}(i)
+	}
+	wg.Wait()
+	for i := range errs {
+		assert.NoError(t, errs[i])
+	}
+	_, generation := ch.connection()
+	assert.Equal(t, uint64(1), generation, "only one goroutine shall reconnect")
+	assert.Greater(t, atomic.LoadInt64(&secondQueries), int64(len(errs)))
+}
@@ -0,0 +1,75 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/apex/log"
+)
+
+// Feature - functionality of clickhouse-server which is available since MinVersion, version is in number format, see GetVersion
+type Feature struct {
+	Name       string
+	MinVersion int
+}
+
+const versionQuery = "SELECT value FROM `system`.`build_options` where name='VERSION_INTEGER'"
+
+var (
+	FeatureFreezeTable    = Feature{Name: "ALTER TABLE ... FREEZE", MinVersion: 19001005}
+	FeatureSystemDisks    = Feature{Name: "system.disks and storage policies", MinVersion: 19015000}
+	FeatureAtomicDatabase = Feature{Name: "Atomic database engine", MinVersion: 20005000}
+	FeatureUnfreeze       = Feature{Name: "ALTER TABLE ... UNFREEZE", MinVersion: 21004000}
+	FeatureProjections    = Feature{Name: "projections", MinVersion: 21006000}
+	FeatureSystemUnfreeze = Feature{Name: "SYSTEM UNFREEZE", MinVersion: 22001000}
+	FeatureNativeBackup   = Feature{Name: "BACKUP and RESTORE statements", MinVersion: 22008000}
+)
+
+// FormatVersion - version in number format as `major.minor.patch`, 21004000 is 21.4.0
+func FormatVersion(version int) string {
+	return fmt.Sprintf("%d.%d.%d", version/1000000, version/1000%1000, version%1000)
+}
+
+// SupportsFeature - connected clickhouse-server version is not less than feature.MinVersion,
+// servers which don't report VERSION_INTEGER are too old for any feature
+func (ch *ClickHouse) SupportsFeature(feature Feature) (bool, error) {
+	version, err := ch.GetVersion()
+	if err != nil {
+		return false, err
+	}
+	return version >= feature.MinVersion, nil
+}
+
+// RequireFeature - fail early with clear message when reason, for example config option, needs newer clickhouse-server
+func (ch *ClickHouse) RequireFeature(feature Feature, reason string) error {
+	version, err := ch.GetVersion()
+	if err != nil {
+		return err
+	}
+	if version < feature.MinVersion {
+		return fmt.Errorf("%s require %s which is available since clickhouse-server %s, current version %s", reason, feature.Name, FormatVersion(feature.MinVersion), FormatVersion(version))
+	}
+	return nil
+}
+
+// detectVersion - query version right after connect without failover, each host of `hosts` could have own version during rolling upgrade
+func (ch *ClickHouse) detectVersion(e endpoint) error {
+	ch.version = 0
+	ctx, cancel, duration := ch.timeoutContext(ch.Config.MetadataTimeout)
+	defer cancel()
+	var result []string
+	if err := ch.conn.SelectContext(ctx, &result, versionQuery); err != nil {
+		return fmt.Errorf("can't get ClickHouse version: %w", timeoutError(err, ctx, duration))
+	}
+	if len(result) == 0 {
+		log.Debugf("clickhouse %s doesn't report version, features which require version detection are disabled", e)
+		return nil
+	}
+	version, err := strconv.Atoi(result[0])
+	if err != nil {
+		return fmt.Errorf("can't parse ClickHouse version '%s': %v", result[0], err)
+	}
+	ch.version = version
+	log.Debugf("clickhouse %s version %s", e, FormatVersion(version))
+	return nil
+}
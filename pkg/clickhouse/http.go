@@ -0,0 +1,478 @@
+package clickhouse
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+)
+
+var httpExceptionRE = regexp.MustCompile(`(?s)^Code:\s*(\d+)(?:\.|,\s*e\.displayText\(\)\s*=)\s*(?:DB::Exception:\s*)?(.*)$`)
+var dateTimeTimezoneRE = regexp.MustCompile(`^DateTime(?:64)?\((?:\s*\d+\s*,)?\s*'([^']+)'\s*\)$`)
+
+// httpConnector - database/sql connector for ClickHouse HTTP interface, each connection is separate HTTP session,
+// so SET on connection from Connx is applied to next queries of the same connection the same way as for native protocol
+type httpConnector struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newHTTPConnector(host string, port uint, secure bool, tlsConfig *tls.Config, timeout time.Duration, maxConnections int, idleTimeout time.Duration, params url.Values) *httpConnector {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	username, password := params.Get("username"), params.Get("password")
+	params.Del("username")
+	params.Del("password")
+	params.Set("default_format", "TabSeparatedWithNamesAndTypes")
+	return &httpConnector{
+		url:      fmt.Sprintf("%s://%s/?%s", scheme, net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)), params.Encode()),
+		username: username,
+		password: password,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: maxConnections,
+				IdleConnTimeout:     idleTimeout,
+				DisableKeepAlives:   idleTimeout <= 0,
+			},
+		},
+	}
+}
+
+func (c *httpConnector) Connect(context.Context) (driver.Conn, error) {
+	return &httpConn{connector: c, sessionID: uuid.New().String()}, nil
+}
+
+func (c *httpConnector) Driver() driver.Driver {
+	return httpDriver{}
+}
+
+// httpDriver - required by driver.Connector, connections are created only by httpConnector
+type httpDriver struct{}
+
+func (httpDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("clickhouse http driver doesn't support DSN, use connector")
+}
+
+type httpConn struct {
+	connector *httpConnector
+	sessionID string
+}
+
+func (c *httpConn) Prepare(query string) (driver.Stmt, error) {
+	return &httpStmt{conn: c, query: query}, nil
+}
+
+func (c *httpConn) Close() error {
+	return nil
+}
+
+func (c *httpConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("clickhouse http interface doesn't support transactions")
+}
+
+func (c *httpConn) Ping(ctx context.Context) error {
+	_, err := c.ExecContext(ctx, "SELECT 1", nil)
+	return err
+}
+
+func (c *httpConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	resp, err := c.post(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return driver.RowsAffected(0), resp.Body.Close()
+}
+
+func (c *httpConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	resp, err := c.post(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	rows := &httpRows{body: resp.Body, reader: bufio.NewReader(resp.Body), location: serverLocation(resp.Header)}
+	if err := rows.readHeader(); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return rows, nil
+}
+
+// serverLocation - clickhouse-server send own timezone in X-ClickHouse-Timezone header, DateTime columns without timezone are in this timezone
+func serverLocation(header http.Header) *time.Location {
+	if timezone := header.Get("X-ClickHouse-Timezone"); timezone != "" {
+		if location, err := time.LoadLocation(timezone); err == nil {
+			return location
+		}
+		log.Warnf("can't load clickhouse-server timezone %s, local timezone will use", timezone)
+	}
+	return time.Local
+}
+
+func (c *httpConn) post(ctx context.Context, query string, args []driver.NamedValue) (*http.Response, error) {
+	query, err := bindHTTPArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.connector.url+"&session_id="+url.QueryEscape(c.sessionID), strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ClickHouse-User", c.connector.username)
+	req.Header.Set("X-ClickHouse-Key", c.connector.password)
+	resp, err := c.connector.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't send query to clickhouse http interface: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Warnf("can't close clickhouse http response: %v", err)
+			}
+		}()
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, httpException(resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// httpException - errors of HTTP interface have the same type and text as errors of native protocol, callers check `code: N` in error text
+func httpException(statusCode int, message string) error {
+	if match := httpExceptionRE.FindStringSubmatch(message); match != nil {
+		code, _ := strconv.ParseInt(match[1], 10, 32)
+		return &clickhouse.Exception{Code: int32(code), Message: strings.TrimSpace(match[2])}
+	}
+	return fmt.Errorf("clickhouse http interface return %d: %s", statusCode, message)
+}
+
+type httpStmt struct {
+	conn  *httpConn
+	query string
+}
+
+func (s *httpStmt) Close() error {
+	return nil
+}
+
+func (s *httpStmt) NumInput() int {
+	return -1
+}
+
+func (s *httpStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *httpStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// bindHTTPArgs - replace `?` placeholders outside of quotes with literals, native clickhouse-go driver binds arguments on client side too
+func bindHTTPArgs(query string, args []driver.NamedValue) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+	var result strings.Builder
+	var quote byte
+	argIndex := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0 && c == '\\' && i+1 < len(query):
+			result.WriteByte(c)
+			i++
+			c = query[i]
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+		case quote == 0 && c == '?':
+			if argIndex >= len(args) {
+				return "", fmt.Errorf("not enough arguments for query: %s", query)
+			}
+			literal, err := httpLiteral(args[argIndex].Value)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(literal)
+			argIndex++
+			continue
+		}
+		result.WriteByte(c)
+	}
+	if argIndex != len(args) {
+		return "", fmt.Errorf("%d arguments passed, query has %d placeholders: %s", len(args), argIndex, query)
+	}
+	return result.String(), nil
+}
+
+func httpLiteral(value driver.Value) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteHTTPString(v), nil
+	case []byte:
+		return quoteHTTPString(string(v)), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return quoteHTTPString(v.Format("2006-01-02 15:04:05")), nil
+	}
+	return "", fmt.Errorf("unsupported argument type %T for clickhouse http interface", value)
+}
+
+func quoteHTTPString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// httpRows - rows of TabSeparatedWithNamesAndTypes, values are converted by column type to the same Go types as native protocol returns
+type httpRows struct {
+	body     io.ReadCloser
+	reader   *bufio.Reader
+	location *time.Location
+	columns  []string
+	types    []string
+}
+
+func (r *httpRows) readLine() ([]string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(line, "\n"), "\t"), nil
+}
+
+func (r *httpRows) readHeader() error {
+	columns, err := r.readLine()
+	if err == io.EOF {
+		// queries without result set, for example DDL, return empty body
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	types, err := r.readLine()
+	if err != nil {
+		return fmt.Errorf("can't read column types from clickhouse http response: %v", err)
+	}
+	if len(types) != len(columns) {
+		return fmt.Errorf("clickhouse http response has %d columns and %d types", len(columns), len(types))
+	}
+	for i := range columns {
+		columns[i] = unescapeTSV(columns[i])
+		types[i] = unescapeTSV(types[i])
+	}
+	r.columns, r.types = columns, types
+	return nil
+}
+
+func (r *httpRows) Columns() []string {
+	return r.columns
+}
+
+func (r *httpRows) Close() error {
+	return r.body.Close()
+}
+
+func (r *httpRows) Next(dest []driver.Value) error {
+	if len(r.columns) == 0 {
+		return io.EOF
+	}
+	values, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	if len(values) != len(r.columns) {
+		return fmt.Errorf("clickhouse http response row has %d values, expected %d", len(values), len(r.columns))
+	}
+	for i := range values {
+		if dest[i], err = parseTSVValue(r.types[i], values[i], r.location); err != nil {
+			return fmt.Errorf("can't parse column %s: %v", r.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// unwrapType - remove Nullable and LowCardinality wrappers, they don't change text representation of value
+func unwrapType(columnType string) (string, bool) {
+	nullable := false
+	for {
+		switch {
+		case strings.HasPrefix(columnType, "Nullable(") && strings.HasSuffix(columnType, ")"):
+			nullable = true
+			columnType = columnType[len("Nullable(") : len(columnType)-1]
+		case strings.HasPrefix(columnType, "LowCardinality(") && strings.HasSuffix(columnType, ")"):
+			columnType = columnType[len("LowCardinality(") : len(columnType)-1]
+		default:
+			return columnType, nullable
+		}
+	}
+}
+
+// parseTSVValue - DateTime is parsed in timezone of column type, or in location of clickhouse-server when column type doesn't contain timezone
+func parseTSVValue(columnType, value string, location *time.Location) (driver.Value, error) {
+	columnType, nullable := unwrapType(columnType)
+	if nullable && value == `\N` {
+		return nil, nil
+	}
+	switch {
+	case strings.HasPrefix(columnType, "UInt"):
+		return strconv.ParseUint(value, 10, 64)
+	case strings.HasPrefix(columnType, "Int"):
+		return strconv.ParseInt(value, 10, 64)
+	case strings.HasPrefix(columnType, "Float"):
+		return strconv.ParseFloat(value, 64)
+	case columnType == "Bool":
+		return value == "true" || value == "1", nil
+	case columnType == "Date" || columnType == "Date32":
+		return time.ParseInLocation("2006-01-02", value, time.Local)
+	case strings.HasPrefix(columnType, "DateTime"):
+		if match := dateTimeTimezoneRE.FindStringSubmatch(columnType); match != nil {
+			columnLocation, err := time.LoadLocation(match[1])
+			if err != nil {
+				return nil, fmt.Errorf("can't load timezone of %s: %v", columnType, err)
+			}
+			location = columnLocation
+		}
+		return time.ParseInLocation("2006-01-02 15:04:05.999999999", value, location)
+	case strings.HasPrefix(columnType, "Array("):
+		return parseTSVArray(unescapeTSV(value))
+	}
+	return unescapeTSV(value), nil
+}
+
+// parseTSVArray - elements of arrays as strings, numbers are kept as text, string elements are unquoted,
+// nested arrays and tuples are kept as text, commas inside them and inside quoted strings don't split elements
+func parseTSVArray(value string) ([]string, error) {
+	if len(value) < 2 || value[0] != '[' || value[len(value)-1] != ']' {
+		return nil, fmt.Errorf("unexpected array value %s", value)
+	}
+	value = value[1 : len(value)-1]
+	result := make([]string, 0)
+	var element strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case depth == 0 && c == '\'' && element.Len() == 0 && !quoted:
+			// string element on top level is unquoted and unescaped
+			j := i + 1
+			for ; j < len(value) && value[j] != '\''; j++ {
+				if value[j] == '\\' && j+1 < len(value) {
+					j++
+				}
+				element.WriteByte(value[j])
+			}
+			if j >= len(value) {
+				return nil, errors.New("unterminated string in array")
+			}
+			i = j
+			quoted = true
+		case depth > 0 && c == '\'':
+			// quoted string inside nested array or tuple is kept as is with escaped chars
+			j := i + 1
+			for ; j < len(value) && value[j] != '\''; j++ {
+				if value[j] == '\\' && j+1 < len(value) {
+					j++
+				}
+			}
+			if j >= len(value) {
+				return nil, errors.New("unterminated string in array")
+			}
+			element.WriteString(value[i : j+1])
+			i = j
+		case c == '[' || c == '(':
+			depth++
+			element.WriteByte(c)
+		case c == ']' || c == ')':
+			if depth == 0 {
+				return nil, fmt.Errorf("unexpected %c in array %s", c, value)
+			}
+			depth--
+			element.WriteByte(c)
+		case depth == 0 && c == ',':
+			result = append(result, element.String())
+			element.Reset()
+			quoted = false
+		default:
+			element.WriteByte(c)
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unterminated nested array in %s", value)
+	}
+	if len(value) > 0 {
+		result = append(result, element.String())
+	}
+	return result, nil
+}
+
+func unescapeTSV(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			result.WriteByte('\n')
+		case 't':
+			result.WriteByte('\t')
+		case 'r':
+			result.WriteByte('\r')
+		case 'b':
+			result.WriteByte('\b')
+		case 'f':
+			result.WriteByte('\f')
+		case '0':
+			result.WriteByte(0)
+		default:
+			result.WriteByte(value[i])
+		}
+	}
+	return result.String()
+}
@@ -0,0 +1,143 @@
+package clickhouse
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindHTTPArgs(t *testing.T) {
+	testData := []struct {
+		query    string
+		args     []driver.Value
+		expected string
+		err      bool
+	}{
+		{"SELECT 1", nil, "SELECT 1", false},
+		{"SELECT ?, ?, ?", []driver.Value{int64(-1), uint64(2), 1.5}, "SELECT -1, 2, 1.5", false},
+		{"SELECT ?, ?", []driver.Value{nil, true}, "SELECT NULL, 1", false},
+		{"SELECT * FROM t WHERE name=? AND value='?'", []driver.Value{"it's \\ here"}, "SELECT * FROM t WHERE name='it\\'s \\\\ here' AND value='?'", false},
+		{"SELECT `a?`, \"b?\", 'c\\'?', ?", []driver.Value{[]byte("d")}, "SELECT `a?`, \"b?\", 'c\\'?', 'd'", false},
+		{"SELECT ?", []driver.Value{time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}, "SELECT '2023-01-02 03:04:05'", false},
+		{"SELECT ?, ?", []driver.Value{int64(1)}, "", true},
+		{"SELECT ?", []driver.Value{int64(1), int64(2)}, "", true},
+		{"SELECT ?", []driver.Value{struct{}{}}, "", true},
+	}
+	for _, tt := range testData {
+		actual, err := bindHTTPArgs(tt.query, namedValues(tt.args))
+		if tt.err {
+			assert.Error(t, err, tt.query)
+			continue
+		}
+		assert.NoError(t, err, tt.query)
+		assert.Equal(t, tt.expected, actual)
+	}
+}
+
+func TestParseTSVValue(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	testData := []struct {
+		columnType string
+		value      string
+		expected   driver.Value
+	}{
+		{"UInt64", "18446744073709551615", uint64(18446744073709551615)},
+		{"Int32", "-5", int64(-5)},
+		{"Float64", "0.5", 0.5},
+		{"Bool", "true", true},
+		{"Nullable(String)", `\N`, nil},
+		{"LowCardinality(Nullable(String))", `a\tb`, "a\tb"},
+		{"String", `\N`, `N`},
+		{"DateTime", "2023-01-02 03:04:05", time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"DateTime('Europe/Moscow')", "2023-01-02 03:04:05", time.Date(2023, 1, 2, 3, 4, 5, 0, moscow)},
+		{"DateTime64(3, 'Europe/Moscow')", "2023-01-02 03:04:05.123", time.Date(2023, 1, 2, 3, 4, 5, 123000000, moscow)},
+		{"Nullable(DateTime64(6))", "2023-01-02 03:04:05.000001", time.Date(2023, 1, 2, 3, 4, 5, 1000, time.UTC)},
+		{"Array(String)", `['a,b','c\\'d']`, []string{"a,b", "c'd"}},
+	}
+	for _, tt := range testData {
+		actual, err := parseTSVValue(tt.columnType, tt.value, time.UTC)
+		assert.NoError(t, err, tt.columnType)
+		if expectedTime, isTime := tt.expected.(time.Time); isTime {
+			assert.True(t, expectedTime.Equal(actual.(time.Time)), "%s %s: expected %v, actual %v", tt.columnType, tt.value, expectedTime, actual)
+			continue
+		}
+		assert.Equal(t, tt.expected, actual, tt.columnType)
+	}
+	_, err = parseTSVValue("UInt8", "x", time.UTC)
+	assert.Error(t, err)
+	_, err = parseTSVValue("DateTime('Unknown/Zone')", "2023-01-02 03:04:05", time.UTC)
+	assert.Error(t, err)
+}
+
+func TestServerLocation(t *testing.T) {
+	header := http.Header{}
+	assert.Equal(t, time.Local, serverLocation(header))
+	header.Set("X-ClickHouse-Timezone", "Europe/Moscow")
+	assert.Equal(t, "Europe/Moscow", serverLocation(header).String())
+	header.Set("X-ClickHouse-Timezone", "Unknown/Zone")
+	assert.Equal(t, time.Local, serverLocation(header))
+}
+
+func TestParseTSVArray(t *testing.T) {
+	testData := []struct {
+		value    string
+		expected []string
+	}{
+		{"[]", []string{}},
+		{"[1,2,3]", []string{"1", "2", "3"}},
+		{"['']", []string{""}},
+		{"['a','',' b ']", []string{"a", "", " b "}},
+		{`['a,b','c\'d','e\\f']`, []string{"a,b", "c'd", `e\f`}},
+		{"[[1,2],[],[3]]", []string{"[1,2]", "[]", "[3]"}},
+		{`[['a,b','c]'],['d']]`, []string{`['a,b','c]']`, "['d']"}},
+		{"[('a',1),('b,c',2)]", []string{"('a',1)", "('b,c',2)"}},
+	}
+	for _, tt := range testData {
+		actual, err := parseTSVArray(tt.value)
+		assert.NoError(t, err, tt.value)
+		assert.Equal(t, tt.expected, actual, tt.value)
+	}
+	for _, value := range []string{"", "1,2", "['a]", "[[1,2]", "[1]]"} {
+		_, err := parseTSVArray(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestUnescapeTSV(t *testing.T) {
+	testData := map[string]string{
+		"plain":          "plain",
+		`a\tb\nc\\d`:     "a\tb\nc\\d",
+		`\r\b\f\0`:       "\r\b\f\x00",
+		`\'quoted\'`:     "'quoted'",
+		`trailing\`:      `trailing\`,
+		`\N`:             "N",
+		`multi\\\\slash`: `multi\\slash`,
+	}
+	for value, expected := range testData {
+		assert.Equal(t, expected, unescapeTSV(value), value)
+	}
+}
+
+func TestHTTPException(t *testing.T) {
+	err := httpException(http.StatusNotFound, "Code: 60. DB::Exception: Table db.t doesn't exist. (UNKNOWN_TABLE) (version 22.8.1.1)")
+	var exception *clickhouse.Exception
+	require.ErrorAs(t, err, &exception)
+	assert.Equal(t, int32(60), exception.Code)
+	assert.Equal(t, "Table db.t doesn't exist. (UNKNOWN_TABLE) (version 22.8.1.1)", exception.Message)
+	assert.Contains(t, err.Error(), "code: 60")
+
+	// format of clickhouse-server before 21.5
+	err = httpException(http.StatusInternalServerError, "Code: 81, e.displayText() = DB::Exception: Database db doesn't exist (version 21.3.1.1)")
+	require.ErrorAs(t, err, &exception)
+	assert.Equal(t, int32(81), exception.Code)
+	assert.Equal(t, "Database db doesn't exist (version 21.3.1.1)", exception.Message)
+
+	err = httpException(http.StatusBadGateway, "Bad Gateway")
+	assert.EqualError(t, err, "clickhouse http interface return 502: Bad Gateway")
+}
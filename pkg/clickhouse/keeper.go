@@ -0,0 +1,61 @@
+package clickhouse
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// KeeperNode - znode from system.zookeeper
+type KeeperNode struct {
+	Name  string `db:"name"`
+	Value string `db:"value"`
+	Czxid int64  `db:"czxid"`
+}
+
+// GetKeeperChildren - children of znode through ZooKeeper / Keeper connection of clickhouse-server, empty when znode doesn't exist
+func (ch *ClickHouse) GetKeeperChildren(nodePath string) ([]KeeperNode, error) {
+	nodes := make([]KeeperNode, 0)
+	query := fmt.Sprintf("SELECT name, value, czxid FROM system.zookeeper WHERE path=%s", quoteStringLiteral(nodePath))
+	if err := ch.Select(&nodes, query); err != nil {
+		if strings.Contains(err.Error(), "No node") {
+			return nodes, nil
+		}
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// CreateKeeperNode - create znode with absent ancestors with INSERT INTO system.zookeeper, clickhouse-server replace value of existing znode
+func (ch *ClickHouse) CreateKeeperNode(nodePath, value string) error {
+	names := strings.Split(strings.Trim(nodePath, "/"), "/")
+	parent := "/"
+	for i, name := range names {
+		nodeValue := ""
+		if i == len(names)-1 {
+			nodeValue = value
+		} else {
+			children, err := ch.GetKeeperChildren(parent)
+			if err != nil {
+				return err
+			}
+			exists := false
+			for _, child := range children {
+				if child.Name == name {
+					exists = true
+					break
+				}
+			}
+			if exists {
+				parent = path.Join(parent, name)
+				continue
+			}
+		}
+		query := fmt.Sprintf("INSERT INTO system.zookeeper (name, path, value) SELECT %s, %s, %s", quoteStringLiteral(name), quoteStringLiteral(parent), quoteStringLiteral(nodeValue))
+		if _, err := ch.Query(query); err != nil {
+			return fmt.Errorf("can't create %s: %v", path.Join(parent, name), err)
+		}
+		parent = path.Join(parent, name)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logicalColumns - columns which are returned by `SELECT *` and could be inserted, MATERIALIZED, ALIAS and EPHEMERAL columns are calculated by server
+func (ch *ClickHouse) logicalColumns(database, table string) (string, string, error) {
+	var columns []struct {
+		Name string `db:"name"`
+		Type string `db:"type"`
+	}
+	if err := ch.Select(&columns, "SELECT name, type FROM system.columns WHERE database=? AND table=? AND default_kind NOT IN ('MATERIALIZED', 'ALIAS', 'EPHEMERAL') ORDER BY position", database, table); err != nil {
+		return "", "", err
+	}
+	if len(columns) == 0 {
+		return "", "", fmt.Errorf("can't find columns of `%s`.`%s` in system.columns", database, table)
+	}
+	names := make([]string, len(columns))
+	structure := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = quoteAccessName(c.Name)
+		structure[i] = quoteAccessName(c.Name) + " " + c.Type
+	}
+	return strings.Join(names, ", "), strings.Join(structure, ", "), nil
+}
+
+// ExportTableData - write all rows of table in Native format into file relative to `user_files_path` with `file` table function
+func (ch *ClickHouse) ExportTableData(database, table, fileName string) error {
+	names, structure, err := ch.logicalColumns(database, table)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO FUNCTION file(%s, 'Native', %s) SELECT %s FROM %s.%s", quoteStringLiteral(fileName), quoteStringLiteral(structure), names, quoteAccessName(database), quoteAccessName(table))
+	_, err = ch.Query(query)
+	return err
+}
+
+// ImportTableData - insert rows from Native file relative to `user_files_path` into table, file shall be written by ExportTableData
+func (ch *ClickHouse) ImportTableData(database, table, fileName string) error {
+	names, structure, err := ch.logicalColumns(database, table)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) SELECT * FROM file(%s, 'Native', %s)", quoteAccessName(database), quoteAccessName(table), names, quoteStringLiteral(fileName), quoteStringLiteral(structure))
+	_, err = ch.Query(query)
+	return err
+}
+
+func quoteStringLiteral(s string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s) + "'"
+}
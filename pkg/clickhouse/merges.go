@@ -0,0 +1,37 @@
+package clickhouse
+
+import "fmt"
+
+// GetMergesCount - count of running merges and not finished mutations of table
+func (ch *ClickHouse) GetMergesCount(database, table string) (uint64, uint64, error) {
+	var merges []uint64
+	if err := ch.Select(&merges, "SELECT count() FROM system.merges WHERE database=? AND table=?", database, table); err != nil {
+		return 0, 0, err
+	}
+	var mutations []uint64
+	if err := ch.Select(&mutations, "SELECT count() FROM system.mutations WHERE database=? AND table=? AND NOT is_done", database, table); err != nil {
+		return 0, 0, err
+	}
+	if len(merges) == 0 || len(mutations) == 0 {
+		return 0, 0, nil
+	}
+	return merges[0], mutations[0], nil
+}
+
+// SyncReplica - SYSTEM SYNC REPLICA waits until replica fetches parts from replication queue which were known at query start, limited by `sync_replica_timeout`
+func (ch *ClickHouse) SyncReplica(database, table string) error {
+	_, err := ch.execWithTimeout(ch.Config.SyncReplicaTimeout, fmt.Sprintf("SYSTEM SYNC REPLICA `%s`.`%s`", database, table))
+	return err
+}
+
+// GetReplicationQueueSize - count of entries in system.replication_queue of table
+func (ch *ClickHouse) GetReplicationQueueSize(database, table string) (uint64, error) {
+	var size []uint64
+	if err := ch.Select(&size, "SELECT count() FROM system.replication_queue WHERE database=? AND table=?", database, table); err != nil {
+		return 0, err
+	}
+	if len(size) == 0 {
+		return 0, nil
+	}
+	return size[0], nil
+}
@@ -0,0 +1,69 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NativeBackupTable - table of BACKUP / RESTORE statement, Partitions are partition IDs, empty means whole table,
+// DstDatabase and DstTable are used only by RESTORE when table restores with another name
+type NativeBackupTable struct {
+	Database    string
+	Table       string
+	DstDatabase string
+	DstTable    string
+	Partitions  []string
+}
+
+// nativeBackupResult - result of BACKUP and RESTORE statements
+type nativeBackupResult struct {
+	ID     string `db:"id"`
+	Status string `db:"status"`
+}
+
+// nativeTablesClause - `TABLE db.t [AS dst_db.dst_t] [PARTITIONS ID '...']` list of BACKUP / RESTORE statement
+func nativeTablesClause(tables []NativeBackupTable, restore bool) string {
+	clauses := make([]string, len(tables))
+	for i, t := range tables {
+		clause := "TABLE " + quoteAccessName(t.Database) + "." + quoteAccessName(t.Table)
+		if restore && (t.DstDatabase != t.Database || t.DstTable != t.Table) {
+			clause += " AS " + quoteAccessName(t.DstDatabase) + "." + quoteAccessName(t.DstTable)
+		}
+		if len(t.Partitions) > 0 {
+			partitions := make([]string, len(t.Partitions))
+			for j, id := range t.Partitions {
+				partitions[j] = "ID " + quoteStringLiteral(id)
+			}
+			clause += " PARTITIONS " + strings.Join(partitions, ", ")
+		}
+		clauses[i] = clause
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// queryNative - BACKUP and RESTORE are available since 22.8 and return status of operation
+func (ch *ClickHouse) queryNative(query string, expectedStatus string) error {
+	if err := ch.RequireFeature(FeatureNativeBackup, "`backup_engine: native`"); err != nil {
+		return err
+	}
+	var result []nativeBackupResult
+	if err := ch.selectWithTimeout(ch.Config.Timeout, &result, query); err != nil {
+		return err
+	}
+	if len(result) == 0 || result[0].Status != expectedStatus {
+		return fmt.Errorf("`%s` return unexpected result: %v", query, result)
+	}
+	return nil
+}
+
+// BackupNative - write consistent snapshot of all tables into directory name on disk from `<backups><allowed_disk>` server config with one BACKUP statement
+func (ch *ClickHouse) BackupNative(tables []NativeBackupTable, disk, name string) error {
+	query := fmt.Sprintf("BACKUP %s TO Disk(%s, %s)", nativeTablesClause(tables, false), quoteStringLiteral(disk), quoteStringLiteral(name))
+	return ch.queryNative(query, "BACKUP_CREATED")
+}
+
+// RestoreNative - insert data from BACKUP into already created tables, table definitions could differ after schema rewriting, rows are appended to existing data
+func (ch *ClickHouse) RestoreNative(tables []NativeBackupTable, disk, name string) error {
+	query := fmt.Sprintf("RESTORE %s FROM Disk(%s, %s) SETTINGS create_database=0, create_table=0, allow_different_table_def=1, allow_non_empty_tables=1", nativeTablesClause(tables, true), quoteStringLiteral(disk), quoteStringLiteral(name))
+	return ch.queryNative(query, "RESTORED")
+}
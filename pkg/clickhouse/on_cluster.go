@@ -0,0 +1,125 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/jmoiron/sqlx"
+)
+
+// DistributedDDLResult - one row of `ON CLUSTER` query result, Status is 0 when query succeeded on host
+type DistributedDDLResult struct {
+	Query             string `db:"-"`
+	Host              string `db:"host"`
+	Port              uint16 `db:"port"`
+	Status            int64  `db:"status"`
+	Error             string `db:"error"`
+	NumHostsRemaining uint64 `db:"num_hosts_remaining"`
+	NumHostsActive    uint64 `db:"num_hosts_active"`
+}
+
+// QueryOnCluster - execute distributed DDL query limited by `ddl_timeout` and return result of each host, error contains all failed hosts
+func (ch *ClickHouse) QueryOnCluster(query string) ([]DistributedDDLResult, error) {
+	var results []DistributedDDLResult
+	if err := ch.selectWithTimeout(ch.Config.DDLTimeout, &results, query); err != nil {
+		return nil, err
+	}
+	return ch.collectDistributedDDLResults(query, results)
+}
+
+// collectDistributedDDLResults - log and keep result of each host, error contains all failed hosts
+func (ch *ClickHouse) collectDistributedDDLResults(query string, results []DistributedDDLResult) ([]DistributedDDLResult, error) {
+	var failed []string
+	for i := range results {
+		results[i].Query = query
+		logger := log.WithFields(log.Fields{"host": fmt.Sprintf("%s:%d", results[i].Host, results[i].Port), "status": results[i].Status})
+		if results[i].Status != 0 {
+			logger.Errorf("distributed DDL failed: %s", results[i].Error)
+			failed = append(failed, fmt.Sprintf("%s:%d: %s", results[i].Host, results[i].Port, results[i].Error))
+			continue
+		}
+		logger.Debug("distributed DDL done")
+	}
+	ch.ddlResultsMu.Lock()
+	ch.ddlResults = append(ch.ddlResults, results...)
+	ch.ddlResultsMu.Unlock()
+	if len(failed) > 0 {
+		return results, fmt.Errorf("distributed DDL failed on %d of %d hosts: %s", len(failed), len(results), strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// TakeDistributedDDLResults - return host results of all `ON CLUSTER` queries executed after previous call
+func (ch *ClickHouse) TakeDistributedDDLResults() []DistributedDDLResult {
+	ch.ddlResultsMu.Lock()
+	defer ch.ddlResultsMu.Unlock()
+	results := ch.ddlResults
+	ch.ddlResults = nil
+	return results
+}
+
+// queryDDL - execute schema query, with not empty onCluster query shall contain `ON CLUSTER` clause and result of each host is collected
+func (ch *ClickHouse) queryDDL(query string, onCluster string) error {
+	if settings := experimentalQuerySettings(query); len(settings) > 0 {
+		return ch.queryDDLWithSettings(query, onCluster, settings)
+	}
+	if onCluster == "" {
+		_, err := ch.execWithTimeout(ch.Config.DDLTimeout, query)
+		return err
+	}
+	_, err := ch.QueryOnCluster(query)
+	return err
+}
+
+var liveViewQueryRE = regexp.MustCompile(`(?is)^\s*(?:CREATE|ATTACH)\s+(?:OR\s+REPLACE\s+)?LIVE\s+VIEW\b`)
+var windowViewQueryRE = regexp.MustCompile(`(?is)^\s*(?:CREATE|ATTACH)\s+(?:OR\s+REPLACE\s+)?WINDOW\s+VIEW\b`)
+
+// experimentalQuerySettings - LIVE VIEW and WINDOW VIEW can't be created without experimental settings enabled
+func experimentalQuerySettings(query string) []string {
+	if liveViewQueryRE.MatchString(query) {
+		return []string{"allow_experimental_live_view=1"}
+	}
+	if windowViewQueryRE.MatchString(query) {
+		return []string{"allow_experimental_window_view=1"}
+	}
+	return nil
+}
+
+// queryDDLWithSettings - clickhouse-go pass only known settings in DSN, so settings are applied with SET on dedicated connection before query
+func (ch *ClickHouse) queryDDLWithSettings(query string, onCluster string, settings []string) error {
+	ctx, cancel, duration := ch.timeoutContext(ch.Config.DDLTimeout)
+	defer cancel()
+	conn, err := ch.GetConn().Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer discardConn(conn)
+	for _, setting := range settings {
+		if _, err := conn.ExecContext(ctx, ch.LogQuery("SET "+setting)); err != nil {
+			return fmt.Errorf("can't apply %s: %v", setting, err)
+		}
+	}
+	if onCluster == "" {
+		_, err = conn.ExecContext(ctx, ch.LogQuery(query))
+		return timeoutError(err, ctx, duration)
+	}
+	var results []DistributedDDLResult
+	if err := conn.SelectContext(ctx, &results, ch.LogQuery(query)); err != nil {
+		return timeoutError(err, ctx, duration)
+	}
+	_, err = ch.collectDistributedDDLResults(query, results)
+	return err
+}
+
+// discardConn - connection with SET settings is closed instead of return to pool, so session settings don't change next queries
+func discardConn(conn *sqlx.Conn) {
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+		log.Warnf("can't close clickhouse connection: %v", err)
+	}
+}
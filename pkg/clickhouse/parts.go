@@ -0,0 +1,32 @@
+package clickhouse
+
+import "fmt"
+
+// PartStats - rows and sizes of data part from system.parts
+type PartStats struct {
+	Name                  string `db:"name"`
+	Rows                  uint64 `db:"rows"`
+	DataCompressedBytes   uint64 `db:"data_compressed_bytes"`
+	DataUncompressedBytes uint64 `db:"data_uncompressed_bytes"`
+}
+
+// GetPartsStats - stats of all parts of table, inactive parts are included, so parts merged after freeze are found too
+func (ch *ClickHouse) GetPartsStats(database, table string) ([]PartStats, error) {
+	var stats []PartStats
+	if err := ch.Select(&stats, "SELECT name, rows, data_compressed_bytes, data_uncompressed_bytes FROM system.parts WHERE database=? AND table=?", database, table); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetTableRowsCount - SELECT count() of table
+func (ch *ClickHouse) GetTableRowsCount(database, table string) (uint64, error) {
+	var count []uint64
+	if err := ch.Select(&count, fmt.Sprintf("SELECT count() FROM `%s`.`%s`", database, table)); err != nil {
+		return 0, err
+	}
+	if len(count) == 0 {
+		return 0, nil
+	}
+	return count[0], nil
+}
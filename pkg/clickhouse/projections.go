@@ -0,0 +1,32 @@
+package clickhouse
+
+import "fmt"
+
+// GetProjectionPartsCount - count of active parts of table and count of active projection parts for each projection
+func (ch *ClickHouse) GetProjectionPartsCount(database, table string) (uint64, map[string]uint64, error) {
+	var activeParts []uint64
+	if err := ch.Select(&activeParts, "SELECT count() FROM system.parts WHERE database=? AND table=? AND active", database, table); err != nil {
+		return 0, nil, err
+	}
+	var projectionParts []struct {
+		Name  string `db:"name"`
+		Count uint64 `db:"count"`
+	}
+	if err := ch.Select(&projectionParts, "SELECT name, count() AS count FROM system.projection_parts WHERE database=? AND table=? AND active GROUP BY name", database, table); err != nil {
+		return 0, nil, err
+	}
+	projections := make(map[string]uint64, len(projectionParts))
+	for _, p := range projectionParts {
+		projections[p.Name] = p.Count
+	}
+	if len(activeParts) == 0 {
+		return 0, projections, nil
+	}
+	return activeParts[0], projections, nil
+}
+
+// MaterializeProjection - build projection for all parts of table, mutation is executed asynchronously
+func (ch *ClickHouse) MaterializeProjection(database, table, projection string) error {
+	_, err := ch.Query(fmt.Sprintf("ALTER TABLE `%s`.`%s` MATERIALIZE PROJECTION `%s`", database, table, projection))
+	return err
+}
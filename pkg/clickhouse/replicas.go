@@ -0,0 +1,36 @@
+package clickhouse
+
+// DegradedReplica - replicated table which can't write or sync data with ZooKeeper / Keeper
+type DegradedReplica struct {
+	Database         string `db:"database"`
+	Table            string `db:"table"`
+	IsReadonly       uint8  `db:"is_readonly"`
+	IsSessionExpired uint8  `db:"is_session_expired"`
+}
+
+// GetDegradedReplicas - read-only and session expired replicas from system.replicas
+func (ch *ClickHouse) GetDegradedReplicas() ([]DegradedReplica, error) {
+	var replicas []DegradedReplica
+	if err := ch.Select(&replicas, "SELECT database, table, toUInt8(is_readonly) AS is_readonly, toUInt8(is_session_expired) AS is_session_expired FROM system.replicas WHERE is_readonly OR is_session_expired"); err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+// ReplicaInfo - ZooKeeper / Keeper paths of replicated table, expanded by clickhouse-server
+type ReplicaInfo struct {
+	Database      string `db:"database"`
+	Table         string `db:"table"`
+	ZooKeeperPath string `db:"zookeeper_path"`
+	ReplicaName   string `db:"replica_name"`
+	ReplicaPath   string `db:"replica_path"`
+}
+
+// GetReplicas - paths of all replicated tables from system.replicas, columns which require ZooKeeper requests are not selected
+func (ch *ClickHouse) GetReplicas() ([]ReplicaInfo, error) {
+	var replicas []ReplicaInfo
+	if err := ch.Select(&replicas, "SELECT database, table, zookeeper_path, replica_name, replica_path FROM system.replicas"); err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
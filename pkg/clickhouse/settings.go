@@ -0,0 +1,90 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// querySettings - `clickhouse->settings` as `SET` queries sorted by name, values are passed as string literals, clickhouse-server converts them to type of setting
+func (ch *ClickHouse) querySettings() []string {
+	names := make([]string, 0, len(ch.Config.Settings))
+	for name := range ch.Config.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	queries := make([]string, len(names))
+	for i, name := range names {
+		queries[i] = fmt.Sprintf("SET %s = %s", name, quoteStringLiteral(ch.Config.Settings[name]))
+	}
+	return queries
+}
+
+// applySettingsToParams - `clickhouse->settings` override settings which clickhouse-backup passes in connection params, HTTP interface accepts any setting as URL parameter
+func (ch *ClickHouse) applySettingsToParams(params url.Values) {
+	for name, value := range ch.Config.Settings {
+		params.Del(name)
+		if ch.Config.Protocol == "http" {
+			params.Set(name, value)
+		}
+	}
+}
+
+// settingsConnector - clickhouse-go pass only known numeric settings in DSN, so each new connection of pool executes SET for `clickhouse->settings`,
+// settings of native protocol session are applied to all next queries of connection
+type settingsConnector struct {
+	dsn      string
+	driver   driver.Driver
+	settings []string
+}
+
+func newSettingsConnector(dsn string, settings []string) (*settingsConnector, error) {
+	db, err := sql.Open("clickhouse", dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+	return &settingsConnector{dsn: dsn, driver: drv, settings: settings}, nil
+}
+
+func (c *settingsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	for _, setting := range c.settings {
+		if err := execSetting(ctx, conn, setting); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("can't apply clickhouse->settings `%s`: %w", setting, err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *settingsConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func execSetting(ctx context.Context, conn driver.Conn, setting string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, setting, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	stmt, err := conn.Prepare(setting)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+	_, err = stmt.Exec(nil)
+	return err
+}
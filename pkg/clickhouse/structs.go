@@ -16,6 +16,7 @@ type Table struct {
 	UUID             string   `db:"uuid,omitempty"`
 	CreateTableQuery string   `db:"create_table_query,omitempty"`
 	TotalBytes       uint64   `db:"total_bytes,omitempty"`
+	TotalRows        uint64   `db:"total_rows,omitempty"`
 	Skip             bool
 }
 
@@ -26,6 +27,7 @@ type IsSystemTablesFieldPresent struct {
 	IsUUIDPresent             int `db:"is_uuid_present"`
 	IsCreateTableQueryPresent int `db:"is_create_table_query_present"`
 	IsTotalBytesPresent       int `db:"is_total_bytes_present"`
+	IsTotalRowsPresent        int `db:"is_total_rows_present"`
 }
 
 type Disk struct {
@@ -62,8 +64,20 @@ type partition struct {
 	DataUncompressedBytes             int64     `db:"data_uncompressed_bytes"`
 }
 
+// storagePolicy - disks of storage policy from system.storage_policies
+type storagePolicy struct {
+	PolicyName string   `db:"policy_name"`
+	Disks      []string `db:"disks"`
+}
+
 // macro - info from system.macros
 type macro struct {
 	Macro        string `db:"macro"`
 	Substitution string `db:"substitution"`
 }
+
+// setting - name and value of changed setting from system.settings or system.merge_tree_settings
+type setting struct {
+	Name  string `db:"name"`
+	Value string `db:"value"`
+}
@@ -0,0 +1,86 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// queryTimeout - per-operation timeout from config, empty value means `timeout`
+func (ch *ClickHouse) queryTimeout(value string) time.Duration {
+	if value == "" {
+		value = ch.Config.Timeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+// connectionTimeout - read and write timeout of connection shall allow the longest operation, shorter operations are limited by context
+func (ch *ClickHouse) connectionTimeout() time.Duration {
+	timeout := ch.queryTimeout("")
+	for _, value := range []string{ch.Config.FreezeTimeout, ch.Config.DDLTimeout, ch.Config.MetadataTimeout, ch.Config.SyncReplicaTimeout} {
+		if t := ch.queryTimeout(value); t > timeout {
+			timeout = t
+		}
+	}
+	return timeout
+}
+
+func (ch *ClickHouse) timeoutContext(timeout string) (context.Context, context.CancelFunc, time.Duration) {
+	duration := ch.queryTimeout(timeout)
+	if duration <= 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		return ctx, cancel, duration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	return ctx, cancel, duration
+}
+
+func timeoutError(err error, ctx context.Context, duration time.Duration) error {
+	if err != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		return fmt.Errorf("query timeout %s exceeded: %w", duration, err)
+	}
+	return err
+}
+
+// execWithTimeout - execute query limited by timeout, for example `freeze_timeout` or `ddl_timeout`
+func (ch *ClickHouse) execWithTimeout(timeout string, query string, args ...interface{}) (sql.Result, error) {
+	query = ch.LogQuery(query)
+	var result sql.Result
+	err := ch.withFailover(func(conn *sqlx.DB) error {
+		ctx, cancel, duration := ch.timeoutContext(timeout)
+		defer cancel()
+		var err error
+		result, err = conn.ExecContext(ctx, query, args...)
+		return timeoutError(err, ctx, duration)
+	})
+	return result, err
+}
+
+// selectWithTimeout - select into dest limited by timeout
+func (ch *ClickHouse) selectWithTimeout(timeout string, dest interface{}, query string, args ...interface{}) error {
+	query = ch.LogQuery(query)
+	return ch.withFailover(func(conn *sqlx.DB) error {
+		ctx, cancel, duration := ch.timeoutContext(timeout)
+		defer cancel()
+		return timeoutError(conn.SelectContext(ctx, dest, query, args...), ctx, duration)
+	})
+}
+
+// queryxContext - rows shall be read before ctx is canceled
+func (ch *ClickHouse) queryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	query = ch.LogQuery(query)
+	var rows *sqlx.Rows
+	err := ch.withFailover(func(conn *sqlx.DB) (err error) {
+		rows, err = conn.QueryxContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
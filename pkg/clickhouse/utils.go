@@ -3,6 +3,7 @@ package clickhouse
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/jmoiron/sqlx/reflectx"
@@ -40,7 +41,8 @@ func fieldsByTraversal(v reflect.Value, traversals [][]int, values []interface{}
 func getDisksByPath(disks []Disk, dataPath string) []string {
 	resultDisks := []Disk{}
 	for _, disk := range disks {
-		if strings.HasPrefix(dataPath, disk.Path) {
+		// compare whole path elements, `/var/lib/clickhouse` is not prefix of `/var/lib/clickhouse2/store/...`
+		if strings.HasPrefix(strings.TrimSuffix(dataPath, "/")+"/", strings.TrimSuffix(disk.Path, "/")+"/") {
 			if len(resultDisks) == 0 {
 				resultDisks = append(resultDisks, disk)
 			} else {
@@ -72,3 +74,50 @@ func GetDisksByPaths(disks []Disk, dataPaths []string) map[string]string {
 	}
 	return result
 }
+
+// GetDataPathForDisk - data path of table on disk, when storage policy of table doesn't contain disk then `default` disk or first disk in name order is used
+func GetDataPathForDisk(dataPaths map[string]string, disk string) (string, string, bool) {
+	if dataPath, exists := dataPaths[disk]; exists {
+		return dataPath, disk, true
+	}
+	if dataPath, exists := dataPaths["default"]; exists {
+		return dataPath, "default", true
+	}
+	names := make([]string, 0, len(dataPaths))
+	for name := range dataPaths {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "", "", false
+	}
+	sort.Strings(names)
+	return dataPaths[names[0]], names[0], true
+}
+
+// objectDiskTypes - disks which store data in object storage, their local part files contain only references to objects
+var objectDiskTypes = []string{"s3", "s3_plain", "azure_blob_storage", "hdfs", "web"}
+
+// IsObjectDisk - FREEZE of table on object disk doesn't copy data, shadow contains references to objects which could be removed by merges later
+func IsObjectDisk(disk Disk) bool {
+	for _, diskType := range objectDiskTypes {
+		if strings.EqualFold(disk.Type, diskType) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetObjectDisks - names of object disks which contain table data paths
+func GetObjectDisks(disks []Disk, dataPaths []string) []string {
+	var result []string
+	for name := range GetDisksByPaths(disks, dataPaths) {
+		for _, disk := range disks {
+			if disk.Name == name && IsObjectDisk(disk) {
+				result = append(result, name)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
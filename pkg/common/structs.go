@@ -1,4 +1,16 @@
 package common
 
+import "sort"
+
 // EmptyMap - like a python set, for less memory usage
 type EmptyMap map[string]struct{}
+
+// Keys - sorted values of set
+func (m EmptyMap) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
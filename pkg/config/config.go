@@ -2,17 +2,28 @@ package config
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/mxalis/clickhouse-backup/pkg/logcli"
+	"github.com/mxalis/clickhouse-backup/pkg/logfmt"
+	"github.com/mxalis/clickhouse-backup/pkg/logjournald"
+	"github.com/mxalis/clickhouse-backup/pkg/logjson"
+	"github.com/mxalis/clickhouse-backup/pkg/logsyslog"
 	"github.com/urfave/cli"
 	"gopkg.in/yaml.v2"
 )
@@ -31,7 +42,27 @@ type Config struct {
 	API        APIConfig        `yaml:"api" envconfig:"_"`
 	FTP        FTPConfig        `yaml:"ftp" envconfig:"_"`
 	SFTP       SFTPConfig       `yaml:"sftp" envconfig:"_"`
+	GRPC       GRPCConfig       `yaml:"grpc" envconfig:"_"`
 	AzureBlob  AzureBlobConfig  `yaml:"azblob" envconfig:"_"`
+	Metrics    MetricsConfig    `yaml:"metrics" envconfig:"_"`
+	Tracing    TracingConfig    `yaml:"tracing" envconfig:"_"`
+	Sentry     SentryConfig     `yaml:"sentry" envconfig:"_"`
+	// ClickHouseTargets - named sections with the same keys as `clickhouse` section, selected by `--target=<name>` for restore on another server
+	ClickHouseTargets map[string]map[string]interface{} `yaml:"clickhouse_targets,omitempty" ignored:"true"`
+	// Include - files or glob patterns merged over current file in listed order, relative paths are resolved from directory of current file
+	Include []string `yaml:"include,omitempty" ignored:"true"`
+	// sources - config file, environment variable or `--set` which define value of `section.key`, printed by `print-config`
+	sources map[string]string
+	// options - options which config was loaded with, applied to `clickhouse_targets` selected later
+	options LoadOptions
+}
+
+// LoadOptions - command line options of config loading, passed to each LoadConfig call
+type LoadOptions struct {
+	// AllowUnknownKeys - set by `--allow-unknown`, unknown keys of config file are ignored instead of error, for example config shared with another clickhouse-backup version
+	AllowUnknownKeys bool
+	// EnvPrefix - set by `--env-prefix`, only `<prefix>_<NAME>` environment variables like CHB1_S3_BUCKET are applied, several instances on one host don't share variables
+	EnvPrefix string
 }
 
 // GeneralConfig - general setting section
@@ -42,42 +73,116 @@ type GeneralConfig struct {
 	BackupsToKeepLocal     int    `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
 	BackupsToKeepRemote    int    `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
 	LogLevel               string `yaml:"log_level" envconfig:"LOG_LEVEL"`
+	LogFormat              string `yaml:"log_format" envconfig:"LOG_FORMAT"`
+	LogOutput              string `yaml:"log_output" envconfig:"LOG_OUTPUT"`
+	SyslogNetwork          string `yaml:"syslog_network" envconfig:"SYSLOG_NETWORK"`
+	SyslogAddress          string `yaml:"syslog_address" envconfig:"SYSLOG_ADDRESS"`
+	SyslogFacility         string `yaml:"syslog_facility" envconfig:"SYSLOG_FACILITY"`
+	SyslogTag              string `yaml:"syslog_tag" envconfig:"SYSLOG_TAG"`
 	AllowEmptyBackups      bool   `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
 	DownloadConcurrency    uint8  `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
 	UploadConcurrency      uint8  `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
 	RestoreSchemaOnCluster string `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
 	UploadByPart           bool   `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
-	DownloadByPart         bool   `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
+	// LinkMode - how files of frozen parts are placed into backup folder and backup parts into detached folder, `auto` hardlink and copy when hardlink is impossible across filesystems, `hardlink` only hardlink, `copy` always copy with reflink when filesystem support it
+	LinkMode string `yaml:"link_mode" envconfig:"LINK_MODE"`
+	// UploadOrder - order of tables upload, empty keep order of metadata, `largest_first`, `smallest_first` by data size or `alphabetical` by database and table name
+	UploadOrder string `yaml:"upload_order" envconfig:"UPLOAD_ORDER"`
+	// UploadMaxBytesPerSecond, DownloadMaxBytesPerSecond - bandwidth limit of all transfers of process, 0 means unlimited, ThrottleSchedule - `HH:MM-HH:MM` local time windows when limits are applied, empty means always
+	UploadMaxBytesPerSecond   uint64   `yaml:"upload_max_bytes_per_second" envconfig:"UPLOAD_MAX_BYTES_PER_SECOND"`
+	DownloadMaxBytesPerSecond uint64   `yaml:"download_max_bytes_per_second" envconfig:"DOWNLOAD_MAX_BYTES_PER_SECOND"`
+	ThrottleSchedule          []string `yaml:"throttle_schedule" envconfig:"THROTTLE_SCHEDULE"`
+	DownloadByPart            bool     `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
+	// BaseCachePath - local folder for archives of required backups downloaded for incremental backups, empty disable cache, BaseCacheMaxSize - least recently used archives are removed when cache is bigger, 0 means unlimited
+	BaseCachePath    string `yaml:"base_cache_path" envconfig:"BASE_CACHE_PATH"`
+	BaseCacheMaxSize uint64 `yaml:"base_cache_max_size" envconfig:"BASE_CACHE_MAX_SIZE"`
+	// DeleteConcurrency - parallel delete requests to remote storage without batch delete API, S3 and COS delete objects by batches of 1000 keys
+	DeleteConcurrency int `yaml:"delete_concurrency" envconfig:"DELETE_CONCURRENCY"`
+	// DownloadRetries - how many times broken read of remote file continue from the last received byte with range request
+	DownloadRetries int `yaml:"download_retries" envconfig:"DOWNLOAD_RETRIES"`
+	// StorageRetries - how many times failed request to remote storage is repeated, pause between retries grows twice from StorageRetriesPause up to StorageRetriesMaxPause, 0 disable retries
+	StorageRetries         int    `yaml:"storage_retries" envconfig:"STORAGE_RETRIES"`
+	StorageRetriesPause    string `yaml:"storage_retries_pause" envconfig:"STORAGE_RETRIES_PAUSE"`
+	StorageRetriesMaxPause string `yaml:"storage_retries_max_pause" envconfig:"STORAGE_RETRIES_MAX_PAUSE"`
+	// StorageMetrics - record duration, bytes and errors of each remote storage operation for API metrics, StorageDebug - log each remote storage request
+	StorageMetrics bool `yaml:"storage_metrics" envconfig:"STORAGE_METRICS"`
+	StorageDebug   bool `yaml:"storage_debug" envconfig:"STORAGE_DEBUG"`
+	// FileChecksums - calculate size and xxhash64 of each part file during create, verify them before upload and restore and after download
+	FileChecksums bool `yaml:"file_checksums" envconfig:"FILE_CHECKSUMS"`
+	// ConsolidateMetadata - upload metadata of all tables as one gzipped object instead of object per table
+	ConsolidateMetadata bool `yaml:"consolidate_metadata" envconfig:"CONSOLIDATE_METADATA"`
+	// MaxMemoryBytes - approximate limit for compression and transfer buffers, part concurrency, concurrency and part size are reduced to fit, 0 means unlimited
+	MaxMemoryBytes         uint64            `yaml:"max_memory_bytes" envconfig:"MAX_MEMORY_BYTES"`
+	RestoreDatabaseMapping map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
+	RestoreTableMapping    map[string]string `yaml:"restore_table_mapping" envconfig:"RESTORE_TABLE_MAPPING"`
+	// RestoreReplicatedEngine - empty keep engines as is, `merge_tree` convert Replicated*MergeTree to *MergeTree, `replicated` convert *MergeTree to Replicated*MergeTree
+	RestoreReplicatedEngine        string `yaml:"restore_replicated_engine" envconfig:"RESTORE_REPLICATED_ENGINE"`
+	RestoreReplicatedZookeeperPath string `yaml:"restore_replicated_zookeeper_path" envconfig:"RESTORE_REPLICATED_ZOOKEEPER_PATH"`
+	RestoreReplicatedReplicaName   string `yaml:"restore_replicated_replica_name" envconfig:"RESTORE_REPLICATED_REPLICA_NAME"`
+	// RestoreReplicatedMacros - replace macros values of backup host in explicit ZooKeeper paths and replica names with macros, RestoreMacrosOverride values are used instead of macros of restore host
+	RestoreReplicatedMacros bool              `yaml:"restore_replicated_macros" envconfig:"RESTORE_REPLICATED_MACROS"`
+	RestoreMacrosOverride   map[string]string `yaml:"restore_macros_override" envconfig:"RESTORE_MACROS_OVERRIDE"`
+	// RestoreDiskMapping - parts of source disk are restored to target disk, RestoreStoragePolicyMapping - storage_policy in schema is replaced with target policy
+	RestoreDiskMapping          map[string]string `yaml:"restore_disk_mapping" envconfig:"RESTORE_DISK_MAPPING"`
+	RestoreStoragePolicyMapping map[string]string `yaml:"restore_storage_policy_mapping" envconfig:"RESTORE_STORAGE_POLICY_MAPPING"`
+	// RestoreTableSettings - MergeTree table settings which replace or add to SETTINGS of restored schema, values are SQL literals, RestoreStripTTL - remove table and column TTL from restored schema
+	RestoreTableSettings map[string]string `yaml:"restore_table_settings" envconfig:"RESTORE_TABLE_SETTINGS"`
+	RestoreStripTTL      bool              `yaml:"restore_strip_ttl" envconfig:"RESTORE_STRIP_TTL"`
+	// RestoreRebuildProjections - materialize projections which are absent in attached parts
+	RestoreRebuildProjections bool `yaml:"restore_rebuild_projections" envconfig:"RESTORE_REBUILD_PROJECTIONS"`
+	// RestoreValidate - compare count() of restored tables with rows of restored parts recorded during create
+	RestoreValidate bool `yaml:"restore_validate" envconfig:"RESTORE_VALIDATE"`
+	// RestoreSyncReplicas - after attach run SYSTEM SYNC REPLICA for Replicated tables and wait until replication_queue is empty during RestoreSyncReplicasTimeout
+	RestoreSyncReplicas        bool   `yaml:"restore_sync_replicas" envconfig:"RESTORE_SYNC_REPLICAS"`
+	RestoreSyncReplicasTimeout string `yaml:"restore_sync_replicas_timeout" envconfig:"RESTORE_SYNC_REPLICAS_TIMEOUT"`
+	// RestorePauseStreamingTables - materialized views which read from Kafka and RabbitMQ tables are detached right after create, so restore doesn't start consuming
+	RestorePauseStreamingTables bool `yaml:"restore_pause_streaming_tables" envconfig:"RESTORE_PAUSE_STREAMING_TABLES"`
+	// RestoreSchemaRetries - how many failed create and drop queries are retried, 0 means count of restored tables
+	RestoreSchemaRetries uint `yaml:"restore_schema_retries" envconfig:"RESTORE_SCHEMA_RETRIES"`
+	// RestoreTableUUID - `keep` UUID from backup when it is not used by another table, `regenerate` new UUID for each restored table in Atomic database
+	RestoreTableUUID string `yaml:"restore_table_uuid" envconfig:"RESTORE_TABLE_UUID"`
+	// SingleReplicaBackup - replicas race for znode in SingleReplicaBackupPath, only first replica of shard creates backup, macros from system.macros are applied to path
+	SingleReplicaBackup     bool   `yaml:"single_replica_backup" envconfig:"SINGLE_REPLICA_BACKUP"`
+	SingleReplicaBackupPath string `yaml:"single_replica_backup_path" envconfig:"SINGLE_REPLICA_BACKUP_PATH"`
+	// BackupNameTemplate - name of backup when it is not passed, macros from system.macros, {hostname} and UTC {datetime}, {date}, {time}, {timestamp} are applied
+	BackupNameTemplate string `yaml:"backup_name_template" envconfig:"BACKUP_NAME_TEMPLATE"`
+	// BackupTags - tags stored in metadata.json of created backup, `create --tag` adds or replaces them, BackupDescription - free-form text stored in metadata.json, `create --description` replaces it
+	BackupTags        map[string]string `yaml:"backup_tags" envconfig:"BACKUP_TAGS"`
+	BackupDescription string            `yaml:"backup_description" envconfig:"BACKUP_DESCRIPTION"`
+	// RetentionTags - backups_to_keep_local and backups_to_keep_remote count and delete only backups which contain all these tags, other backups are kept
+	RetentionTags map[string]string `yaml:"retention_tags" envconfig:"RETENTION_TAGS"`
 }
 
 // GCSConfig - GCS settings section
 type GCSConfig struct {
-	CredentialsFile   string `yaml:"credentials_file" envconfig:"GCS_CREDENTIALS_FILE"`
-	CredentialsJSON   string `yaml:"credentials_json" envconfig:"GCS_CREDENTIALS_JSON"`
-	Bucket            string `yaml:"bucket" envconfig:"GCS_BUCKET"`
-	Path              string `yaml:"path" envconfig:"GCS_PATH"`
-	CompressionLevel  int    `yaml:"compression_level" envconfig:"GCS_COMPRESSION_LEVEL"`
-	CompressionFormat string `yaml:"compression_format" envconfig:"GCS_COMPRESSION_FORMAT"`
-	Debug             bool   `yaml:"debug" envconfig:"GCS_DEBUG"`
-	Endpoint          string `yaml:"endpoint" envconfig:"GCS_ENDPOINT"`
+	CredentialsFile        string `yaml:"credentials_file" envconfig:"GCS_CREDENTIALS_FILE"`
+	CredentialsJSON        string `yaml:"credentials_json" envconfig:"GCS_CREDENTIALS_JSON"`
+	Bucket                 string `yaml:"bucket" envconfig:"GCS_BUCKET"`
+	Path                   string `yaml:"path" envconfig:"GCS_PATH"`
+	CompressionLevel       int    `yaml:"compression_level" envconfig:"GCS_COMPRESSION_LEVEL"`
+	CompressionFormat      string `yaml:"compression_format" envconfig:"GCS_COMPRESSION_FORMAT"`
+	Debug                  bool   `yaml:"debug" envconfig:"GCS_DEBUG"`
+	Endpoint               string `yaml:"endpoint" envconfig:"GCS_ENDPOINT"`
+	MaxConcurrentTransfers uint8  `yaml:"max_concurrent_transfers" envconfig:"GCS_MAX_CONCURRENT_TRANSFERS"`
 }
 
 // AzureBlobConfig - Azure Blob settings section
 type AzureBlobConfig struct {
-	EndpointSuffix        string `yaml:"endpoint_suffix" envconfig:"AZBLOB_ENDPOINT_SUFFIX"`
-	AccountName           string `yaml:"account_name" envconfig:"AZBLOB_ACCOUNT_NAME"`
-	AccountKey            string `yaml:"account_key" envconfig:"AZBLOB_ACCOUNT_KEY"`
-	SharedAccessSignature string `yaml:"sas" envconfig:"AZBLOB_SAS"`
-	UseManagedIdentity    bool   `yaml:"use_managed_identity" envconfig:"AZBLOB_USE_MANAGED_IDENTITY"`
-	Container             string `yaml:"container" envconfig:"AZBLOB_CONTAINER"`
-	Path                  string `yaml:"path" envconfig:"AZBLOB_PATH"`
-	CompressionLevel      int    `yaml:"compression_level" envconfig:"AZBLOB_COMPRESSION_LEVEL"`
-	CompressionFormat     string `yaml:"compression_format" envconfig:"AZBLOB_COMPRESSION_FORMAT"`
-	SSEKey                string `yaml:"sse_key" envconfig:"AZBLOB_SSE_KEY"`
-	BufferSize            int    `yaml:"buffer_size" envconfig:"AZBLOB_BUFFER_SIZE"`
-	MaxBuffers            int    `yaml:"buffer_count" envconfig:"AZBLOB_MAX_BUFFERS"`
-	MaxPartsCount         int    `yaml:"max_parts_count" envconfig:"AZBLOB_MAX_PARTS_COUNT"`
-	Timeout               string `yaml:"timeout" envconfig:"AZBLOB_TIMEOUT"`
+	EndpointSuffix         string `yaml:"endpoint_suffix" envconfig:"AZBLOB_ENDPOINT_SUFFIX"`
+	AccountName            string `yaml:"account_name" envconfig:"AZBLOB_ACCOUNT_NAME"`
+	AccountKey             string `yaml:"account_key" envconfig:"AZBLOB_ACCOUNT_KEY"`
+	SharedAccessSignature  string `yaml:"sas" envconfig:"AZBLOB_SAS"`
+	UseManagedIdentity     bool   `yaml:"use_managed_identity" envconfig:"AZBLOB_USE_MANAGED_IDENTITY"`
+	Container              string `yaml:"container" envconfig:"AZBLOB_CONTAINER"`
+	Path                   string `yaml:"path" envconfig:"AZBLOB_PATH"`
+	CompressionLevel       int    `yaml:"compression_level" envconfig:"AZBLOB_COMPRESSION_LEVEL"`
+	CompressionFormat      string `yaml:"compression_format" envconfig:"AZBLOB_COMPRESSION_FORMAT"`
+	SSEKey                 string `yaml:"sse_key" envconfig:"AZBLOB_SSE_KEY"`
+	BufferSize             int    `yaml:"buffer_size" envconfig:"AZBLOB_BUFFER_SIZE"`
+	MaxBuffers             int    `yaml:"buffer_count" envconfig:"AZBLOB_MAX_BUFFERS"`
+	MaxPartsCount          int    `yaml:"max_parts_count" envconfig:"AZBLOB_MAX_PARTS_COUNT"`
+	Timeout                string `yaml:"timeout" envconfig:"AZBLOB_TIMEOUT"`
+	MaxConcurrentTransfers uint8  `yaml:"max_concurrent_transfers" envconfig:"AZBLOB_MAX_CONCURRENT_TRANSFERS"`
 }
 
 // S3Config - s3 settings section
@@ -102,18 +207,20 @@ type S3Config struct {
 	MaxPartsCount           int64  `yaml:"max_parts_count" envconfig:"S3_MAX_PARTS_COUNT"`
 	AllowMultipartDownload  bool   `yaml:"allow_multipart_download" envconfig:"S3_ALLOW_MULTIPART_DOWNLOAD"`
 	Debug                   bool   `yaml:"debug" envconfig:"S3_DEBUG"`
+	MaxConcurrentTransfers  uint8  `yaml:"max_concurrent_transfers" envconfig:"S3_MAX_CONCURRENT_TRANSFERS"`
 }
 
 // COSConfig - cos settings section
 type COSConfig struct {
-	RowURL            string `yaml:"url" envconfig:"COS_URL"`
-	Timeout           string `yaml:"timeout" envconfig:"COS_TIMEOUT"`
-	SecretID          string `yaml:"secret_id" envconfig:"COS_SECRET_ID"`
-	SecretKey         string `yaml:"secret_key" envconfig:"COS_SECRET_KEY"`
-	Path              string `yaml:"path" envconfig:"COS_PATH"`
-	CompressionFormat string `yaml:"compression_format" envconfig:"COS_COMPRESSION_FORMAT"`
-	CompressionLevel  int    `yaml:"compression_level" envconfig:"COS_COMPRESSION_LEVEL"`
-	Debug             bool   `yaml:"debug" envconfig:"COS_DEBUG"`
+	RowURL                 string `yaml:"url" envconfig:"COS_URL"`
+	Timeout                string `yaml:"timeout" envconfig:"COS_TIMEOUT"`
+	SecretID               string `yaml:"secret_id" envconfig:"COS_SECRET_ID"`
+	SecretKey              string `yaml:"secret_key" envconfig:"COS_SECRET_KEY"`
+	Path                   string `yaml:"path" envconfig:"COS_PATH"`
+	CompressionFormat      string `yaml:"compression_format" envconfig:"COS_COMPRESSION_FORMAT"`
+	CompressionLevel       int    `yaml:"compression_level" envconfig:"COS_COMPRESSION_LEVEL"`
+	Debug                  bool   `yaml:"debug" envconfig:"COS_DEBUG"`
+	MaxConcurrentTransfers uint8  `yaml:"max_concurrent_transfers" envconfig:"COS_MAX_CONCURRENT_TRANSFERS"`
 }
 
 // FTPConfig - ftp settings section
@@ -132,16 +239,32 @@ type FTPConfig struct {
 
 // SFTPConfig - sftp settings section
 type SFTPConfig struct {
-	Address           string `yaml:"address" envconfig:"SFTP_ADDRESS"`
-	Port              uint   `yaml:"port" envconfig:"SFTP_PORT"`
-	Username          string `yaml:"username" envconfig:"SFTP_USERNAME"`
-	Password          string `yaml:"password" envconfig:"SFTP_PASSWORD"`
-	Key               string `yaml:"key" envconfig:"SFTP_KEY"`
-	Path              string `yaml:"path" envconfig:"SFTP_PATH"`
-	CompressionFormat string `yaml:"compression_format" envconfig:"SFTP_COMPRESSION_FORMAT"`
-	CompressionLevel  int    `yaml:"compression_level" envconfig:"SFTP_COMPRESSION_LEVEL"`
-	Concurrency       int    `yaml:"concurrency" envconfig:"SFTP_CONCURRENCY"`
-	Debug             bool   `yaml:"debug" envconfig:"SFTP_DEBUG"`
+	Address                string `yaml:"address" envconfig:"SFTP_ADDRESS"`
+	Port                   uint   `yaml:"port" envconfig:"SFTP_PORT"`
+	Username               string `yaml:"username" envconfig:"SFTP_USERNAME"`
+	Password               string `yaml:"password" envconfig:"SFTP_PASSWORD"`
+	Key                    string `yaml:"key" envconfig:"SFTP_KEY"`
+	Path                   string `yaml:"path" envconfig:"SFTP_PATH"`
+	CompressionFormat      string `yaml:"compression_format" envconfig:"SFTP_COMPRESSION_FORMAT"`
+	CompressionLevel       int    `yaml:"compression_level" envconfig:"SFTP_COMPRESSION_LEVEL"`
+	Concurrency            int    `yaml:"concurrency" envconfig:"SFTP_CONCURRENCY"`
+	Debug                  bool   `yaml:"debug" envconfig:"SFTP_DEBUG"`
+	MaxConcurrentTransfers uint8  `yaml:"max_concurrent_transfers" envconfig:"SFTP_MAX_CONCURRENT_TRANSFERS"`
+}
+
+// GRPCConfig - storage plugin settings section, plugin is gRPC server which implements pkg/new_storage/grpc_storage.proto
+type GRPCConfig struct {
+	Address                string `yaml:"address" envconfig:"GRPC_ADDRESS"`
+	Path                   string `yaml:"path" envconfig:"GRPC_PATH"`
+	Token                  string `yaml:"token" envconfig:"GRPC_TOKEN"`
+	Timeout                string `yaml:"timeout" envconfig:"GRPC_TIMEOUT"`
+	TLS                    bool   `yaml:"tls" envconfig:"GRPC_TLS"`
+	TLSCa                  string `yaml:"tls_ca" envconfig:"GRPC_TLS_CA"`
+	TLSCert                string `yaml:"tls_cert" envconfig:"GRPC_TLS_CERT"`
+	TLSKey                 string `yaml:"tls_key" envconfig:"GRPC_TLS_KEY"`
+	CompressionFormat      string `yaml:"compression_format" envconfig:"GRPC_COMPRESSION_FORMAT"`
+	CompressionLevel       int    `yaml:"compression_level" envconfig:"GRPC_COMPRESSION_LEVEL"`
+	MaxConcurrentTransfers uint8  `yaml:"max_concurrent_transfers" envconfig:"GRPC_MAX_CONCURRENT_TRANSFERS"`
 }
 
 // ClickHouseConfig - clickhouse settings section
@@ -150,38 +273,122 @@ type ClickHouseConfig struct {
 	Password                         string            `yaml:"password" envconfig:"CLICKHOUSE_PASSWORD"`
 	Host                             string            `yaml:"host" envconfig:"CLICKHOUSE_HOST"`
 	Port                             uint              `yaml:"port" envconfig:"CLICKHOUSE_PORT"`
+	Protocol                         string            `yaml:"protocol" envconfig:"CLICKHOUSE_PROTOCOL"`
+	Hosts                            []string          `yaml:"hosts" envconfig:"CLICKHOUSE_HOSTS"`
 	DiskMapping                      map[string]string `yaml:"disk_mapping" envconfig:"CLICKHOUSE_DISK_MAPPING"`
 	SkipTables                       []string          `yaml:"skip_tables" envconfig:"CLICKHOUSE_SKIP_TABLES"`
+	SkipTableEngines                 []string          `yaml:"skip_table_engines" envconfig:"CLICKHOUSE_SKIP_TABLE_ENGINES"`
 	Timeout                          string            `yaml:"timeout" envconfig:"CLICKHOUSE_TIMEOUT"`
+	FreezeTimeout                    string            `yaml:"freeze_timeout" envconfig:"CLICKHOUSE_FREEZE_TIMEOUT"`
+	DDLTimeout                       string            `yaml:"ddl_timeout" envconfig:"CLICKHOUSE_DDL_TIMEOUT"`
+	MetadataTimeout                  string            `yaml:"metadata_timeout" envconfig:"CLICKHOUSE_METADATA_TIMEOUT"`
+	SyncReplicaTimeout               string            `yaml:"sync_replica_timeout" envconfig:"CLICKHOUSE_SYNC_REPLICA_TIMEOUT"`
+	Settings                         map[string]string `yaml:"settings" envconfig:"CLICKHOUSE_SETTINGS"`
+	MaxConnections                   int               `yaml:"max_connections" envconfig:"CLICKHOUSE_MAX_CONNECTIONS"`
+	ConnectionIdleTimeout            string            `yaml:"connection_idle_timeout" envconfig:"CLICKHOUSE_CONNECTION_IDLE_TIMEOUT"`
 	FreezeByPart                     bool              `yaml:"freeze_by_part" envconfig:"CLICKHOUSE_FREEZE_BY_PART"`
 	FreezeByPartWhere                string            `yaml:"freeze_by_part_where" envconfig:"CLICKHOUSE_FREEZE_BY_PART_WHERE"`
+	FreezeConcurrency                uint8             `yaml:"freeze_concurrency" envconfig:"CLICKHOUSE_FREEZE_CONCURRENCY"`
 	Secure                           bool              `yaml:"secure" envconfig:"CLICKHOUSE_SECURE"`
 	SkipVerify                       bool              `yaml:"skip_verify" envconfig:"CLICKHOUSE_SKIP_VERIFY"`
 	SyncReplicatedTables             bool              `yaml:"sync_replicated_tables" envconfig:"CLICKHOUSE_SYNC_REPLICATED_TABLES"`
+	CheckReplicas                    string            `yaml:"check_replicas" envconfig:"CLICKHOUSE_CHECK_REPLICAS"`
+	CheckReplicasTimeout             string            `yaml:"check_replicas_timeout" envconfig:"CLICKHOUSE_CHECK_REPLICAS_TIMEOUT"`
+	WaitMergesBeforeFreeze           bool              `yaml:"wait_merges_before_freeze" envconfig:"CLICKHOUSE_WAIT_MERGES_BEFORE_FREEZE"`
+	WaitMergesThreshold              uint64            `yaml:"wait_merges_threshold" envconfig:"CLICKHOUSE_WAIT_MERGES_THRESHOLD"`
+	WaitMergesTimeout                string            `yaml:"wait_merges_timeout" envconfig:"CLICKHOUSE_WAIT_MERGES_TIMEOUT"`
 	LogSQLQueries                    bool              `yaml:"log_sql_queries" envconfig:"CLICKHOUSE_LOG_SQL_QUERIES"`
 	ConfigDir                        string            `yaml:"config_dir" envconfig:"CLICKHOUSE_CONFIG_DIR"`
+	ConfigRestoreDir                 string            `yaml:"config_restore_dir" envconfig:"CLICKHOUSE_CONFIG_RESTORE_DIR"`
+	ConfigRedactSecrets              bool              `yaml:"config_redact_secrets" envconfig:"CLICKHOUSE_CONFIG_REDACT_SECRETS"`
+	ConfigRedactTags                 []string          `yaml:"config_redact_tags" envconfig:"CLICKHOUSE_CONFIG_REDACT_TAGS"`
+	BackupDictionaryFiles            bool              `yaml:"backup_dictionary_files" envconfig:"CLICKHOUSE_BACKUP_DICTIONARY_FILES"`
+	UserFilesPath                    string            `yaml:"user_files_path" envconfig:"CLICKHOUSE_USER_FILES_PATH"`
+	LogicalBackupEngines             []string          `yaml:"logical_backup_engines" envconfig:"CLICKHOUSE_LOGICAL_BACKUP_ENGINES"`
+	ObjectDiskBackupMode             string            `yaml:"object_disk_backup_mode" envconfig:"CLICKHOUSE_OBJECT_DISK_BACKUP_MODE"`
+	BackupEngine                     string            `yaml:"backup_engine" envconfig:"CLICKHOUSE_BACKUP_ENGINE"`
+	NativeBackupDisk                 string            `yaml:"native_backup_disk" envconfig:"CLICKHOUSE_NATIVE_BACKUP_DISK"`
 	RestartCommand                   string            `yaml:"restart_command" envconfig:"CLICKHOUSE_RESTART_COMMAND"`
 	IgnoreNotExistsErrorDuringFreeze bool              `yaml:"ignore_not_exists_error_during_freeze" envconfig:"CLICKHOUSE_IGNORE_NOT_EXISTS_ERROR_DURING_FREEZE"`
 	TLSKey                           string            `yaml:"tls_key" envconfig:"CLICKHOUSE_TLS_KEY"`
 	TLSCert                          string            `yaml:"tls_cert" envconfig:"CLICKHOUSE_TLS_CERT"`
 	TLSCa                            string            `yaml:"tls_ca" envconfig:"CLICKHOUSE_TLS_CA"`
+	TLSServerName                    string            `yaml:"tls_server_name" envconfig:"CLICKHOUSE_TLS_SERVER_NAME"`
 	Debug                            bool              `yaml:"debug" envconfig:"CLICKHOUSE_DEBUG"`
 }
 
 type APIConfig struct {
-	ListenAddr              string `yaml:"listen" envconfig:"API_LISTEN"`
-	EnableMetrics           bool   `yaml:"enable_metrics" envconfig:"API_ENABLE_METRICS"`
-	EnablePprof             bool   `yaml:"enable_pprof" envconfig:"API_ENABLE_PPROF"`
-	Username                string `yaml:"username" envconfig:"API_USERNAME"`
-	Password                string `yaml:"password" envconfig:"API_PASSWORD"`
-	Secure                  bool   `yaml:"secure" envconfig:"API_SECURE"`
-	CertificateFile         string `yaml:"certificate_file" envconfig:"API_CERTIFICATE_FILE"`
-	PrivateKeyFile          string `yaml:"private_key_file" envconfig:"API_PRIVATE_KEY_FILE"`
-	CreateIntegrationTables bool   `yaml:"create_integration_tables" envconfig:"API_CREATE_INTEGRATION_TABLES"`
-	IntegrationTablesHost   string `yaml:"integration_tables_host" envconfig:"API_INTEGRATION_TABLES_HOST"`
-	AllowParallel           bool   `yaml:"allow_parallel" envconfig:"API_ALLOW_PARALLEL"`
+	ListenAddr              string         `yaml:"listen" envconfig:"API_LISTEN"`
+	EnableMetrics           bool           `yaml:"enable_metrics" envconfig:"API_ENABLE_METRICS"`
+	EnableTableMetrics      bool           `yaml:"enable_table_metrics" envconfig:"API_ENABLE_TABLE_METRICS"`
+	TableMetricsLimit       int            `yaml:"table_metrics_limit" envconfig:"API_TABLE_METRICS_LIMIT"`
+	RemoteUsageInterval     string         `yaml:"remote_usage_interval" envconfig:"API_REMOTE_USAGE_INTERVAL"`
+	EnablePprof             bool           `yaml:"enable_pprof" envconfig:"API_ENABLE_PPROF"`
+	EnableSwagger           bool           `yaml:"enable_swagger" envconfig:"API_ENABLE_SWAGGER"`
+	Username                string         `yaml:"username" envconfig:"API_USERNAME"`
+	Password                string         `yaml:"password" envconfig:"API_PASSWORD"`
+	Secure                  bool           `yaml:"secure" envconfig:"API_SECURE"`
+	CertificateFile         string         `yaml:"certificate_file" envconfig:"API_CERTIFICATE_FILE"`
+	PrivateKeyFile          string         `yaml:"private_key_file" envconfig:"API_PRIVATE_KEY_FILE"`
+	CreateIntegrationTables bool           `yaml:"create_integration_tables" envconfig:"API_CREATE_INTEGRATION_TABLES"`
+	IntegrationTablesHost   string         `yaml:"integration_tables_host" envconfig:"API_INTEGRATION_TABLES_HOST"`
+	AllowParallel           bool           `yaml:"allow_parallel" envconfig:"API_ALLOW_PARALLEL"`
+	MaxConcurrentOperations map[string]int `yaml:"max_concurrent_operations" envconfig:"API_MAX_CONCURRENT_OPERATIONS"`
+	RateLimit               float64        `yaml:"rate_limit" envconfig:"API_RATE_LIMIT"`
+	RateLimitBurst          int            `yaml:"rate_limit_burst" envconfig:"API_RATE_LIMIT_BURST"`
+}
+
+// APIOperations - names of operations started by API server, they and `all` are allowed keys of `api.max_concurrent_operations`
+var APIOperations = []string{
+	"create", "upload", "download", "restore", "create_remote", "restore_remote", "delete", "import", "clean",
+	"verify", "remote-check", "copy", "create_cluster", "restore_cluster", "migrate-metadata",
+}
+
+// IsAPIOperation - operation is one of APIOperations
+func IsAPIOperation(operation string) bool {
+	for _, apiOperation := range APIOperations {
+		if operation == apiOperation {
+			return true
+		}
+	}
+	return false
+}
+
+// MetricsConfig - metrics sinks which receive results of create, upload, download and restore commands
+type MetricsConfig struct {
+	Timeout             string `yaml:"timeout" envconfig:"METRICS_TIMEOUT"`
+	PushgatewayURL      string `yaml:"pushgateway_url" envconfig:"METRICS_PUSHGATEWAY_URL"`
+	PushgatewayJob      string `yaml:"pushgateway_job" envconfig:"METRICS_PUSHGATEWAY_JOB"`
+	PushgatewayInstance string `yaml:"pushgateway_instance" envconfig:"METRICS_PUSHGATEWAY_INSTANCE"`
+	PushgatewayUsername string `yaml:"pushgateway_username" envconfig:"METRICS_PUSHGATEWAY_USERNAME"`
+	PushgatewayPassword string `yaml:"pushgateway_password" envconfig:"METRICS_PUSHGATEWAY_PASSWORD"`
+	StatsdAddress       string `yaml:"statsd_address" envconfig:"METRICS_STATSD_ADDRESS"`
+	StatsdPrefix        string `yaml:"statsd_prefix" envconfig:"METRICS_STATSD_PREFIX"`
+	GraphiteAddress     string `yaml:"graphite_address" envconfig:"METRICS_GRAPHITE_ADDRESS"`
+	GraphitePrefix      string `yaml:"graphite_prefix" envconfig:"METRICS_GRAPHITE_PREFIX"`
+}
+
+// TracingConfig - OpenTelemetry tracing settings, spans exported with OTLP over HTTP
+type TracingConfig struct {
+	Endpoint    string            `yaml:"endpoint" envconfig:"TRACING_ENDPOINT"`
+	URLPath     string            `yaml:"url_path" envconfig:"TRACING_URL_PATH"`
+	Insecure    bool              `yaml:"insecure" envconfig:"TRACING_INSECURE"`
+	Headers     map[string]string `yaml:"headers" envconfig:"TRACING_HEADERS"`
+	ServiceName string            `yaml:"service_name" envconfig:"TRACING_SERVICE_NAME"`
+	SampleRatio float64           `yaml:"sample_ratio" envconfig:"TRACING_SAMPLE_RATIO"`
+	Timeout     string            `yaml:"timeout" envconfig:"TRACING_TIMEOUT"`
 }
 
+// SentryConfig - report failed operations to Sentry or compatible error tracker
+type SentryConfig struct {
+	DSN         string            `yaml:"dsn" envconfig:"SENTRY_DSN"`
+	Environment string            `yaml:"environment" envconfig:"SENTRY_ENVIRONMENT"`
+	Tags        map[string]string `yaml:"tags" envconfig:"SENTRY_TAGS"`
+	Timeout     string            `yaml:"timeout" envconfig:"SENTRY_TIMEOUT"`
+}
+
+var settingNameRE = regexp.MustCompile(`^\w+$`)
+
 // ArchiveExtensions - list of availiable compression formats and associated file extensions
 var ArchiveExtensions = map[string]string{
 	"tar":    "tar",
@@ -207,6 +414,8 @@ func (cfg *Config) GetArchiveExtension() string {
 		return ArchiveExtensions[cfg.FTP.CompressionFormat]
 	case "sftp":
 		return ArchiveExtensions[cfg.SFTP.CompressionFormat]
+	case "grpc":
+		return ArchiveExtensions[cfg.GRPC.CompressionFormat]
 	case "azblob":
 		return ArchiveExtensions[cfg.AzureBlob.CompressionFormat]
 	default:
@@ -214,6 +423,43 @@ func (cfg *Config) GetArchiveExtension() string {
 	}
 }
 
+// GetUploadConcurrency - `upload_concurrency` limited by `max_concurrent_transfers` of remote storage, it limits count of tables and count of archives of each table uploaded at the same time
+func (cfg *Config) GetUploadConcurrency() uint8 {
+	return cfg.capConcurrency(cfg.General.UploadConcurrency)
+}
+
+// GetDownloadConcurrency - `download_concurrency` limited by `max_concurrent_transfers` of remote storage
+func (cfg *Config) GetDownloadConcurrency() uint8 {
+	return cfg.capConcurrency(cfg.General.DownloadConcurrency)
+}
+
+func (cfg *Config) capConcurrency(concurrency uint8) uint8 {
+	var maxTransfers uint8
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		maxTransfers = cfg.S3.MaxConcurrentTransfers
+	case "gcs":
+		maxTransfers = cfg.GCS.MaxConcurrentTransfers
+	case "cos":
+		maxTransfers = cfg.COS.MaxConcurrentTransfers
+	case "ftp":
+		maxTransfers = cfg.FTP.Concurrency
+	case "sftp":
+		maxTransfers = cfg.SFTP.MaxConcurrentTransfers
+	case "grpc":
+		maxTransfers = cfg.GRPC.MaxConcurrentTransfers
+	case "azblob":
+		maxTransfers = cfg.AzureBlob.MaxConcurrentTransfers
+	}
+	if maxTransfers > 0 && maxTransfers < concurrency {
+		return maxTransfers
+	}
+	if concurrency == 0 {
+		return 1
+	}
+	return concurrency
+}
+
 func (cfg *Config) GetCompressionFormat() string {
 	switch cfg.General.RemoteStorage {
 	case "s3":
@@ -226,6 +472,8 @@ func (cfg *Config) GetCompressionFormat() string {
 		return cfg.FTP.CompressionFormat
 	case "sftp":
 		return cfg.SFTP.CompressionFormat
+	case "grpc":
+		return cfg.GRPC.CompressionFormat
 	case "azblob":
 		return cfg.AzureBlob.CompressionFormat
 	case "none":
@@ -236,26 +484,171 @@ func (cfg *Config) GetCompressionFormat() string {
 }
 
 // LoadConfig - load config from file + environment variables
-func LoadConfig(configLocation string) (*Config, error) {
+func LoadConfig(configLocation string, options LoadOptions) (*Config, error) {
+	return LoadConfigWithOverrides(configLocation, nil, options)
+}
+
+// LoadConfigWithOverrides - values from config file, then from environment variables, then from `--set section.key=value` overrides
+func LoadConfigWithOverrides(configLocation string, overrides []string, options LoadOptions) (*Config, error) {
 	cfg := DefaultConfig()
+	cfg.options = options
 	configYaml, err := ioutil.ReadFile(configLocation)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("can't open config file: %v", err)
 	}
-	if err := yaml.Unmarshal(configYaml, &cfg); err != nil {
-		return nil, fmt.Errorf("can't parse config file: %v", err)
+	if err := loadConfigFile(cfg, configLocation, configYaml, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	for name, target := range cfg.ClickHouseTargets {
+		if _, err := clickHouseTargetConfig(cfg.ClickHouse, name, target, options.AllowUnknownKeys); err != nil {
+			return nil, err
+		}
 	}
-	if err := envconfig.Process("", cfg); err != nil {
+	if err := processEnv(cfg, options.EnvPrefix); err != nil {
+		return nil, err
+	}
+	cfg.setEnvSources()
+	if err := cfg.ApplyOverrides(overrides); err != nil {
 		return nil, err
 	}
 	cfg.AzureBlob.Path = strings.TrimPrefix(cfg.AzureBlob.Path, "/")
 	cfg.S3.Path = strings.TrimPrefix(cfg.S3.Path, "/")
 	cfg.GCS.Path = strings.TrimPrefix(cfg.GCS.Path, "/")
-	log.SetLevelFromString(cfg.General.LogLevel)
-	return cfg, ValidateConfig(cfg)
+	setLogLevel(cfg.General.LogLevel)
+	if err := ValidateConfig(cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, SetLogHandler(&cfg.General)
+}
+
+// loadConfigFile - unmarshal file over cfg and then its includes, mappings are merged key by key, lists and scalar values of later file replace previous one
+func loadConfigFile(cfg *Config, configLocation string, configYaml []byte, loaded map[string]bool) error {
+	if absPath, err := filepath.Abs(configLocation); err == nil {
+		configLocation = absPath
+	}
+	if loaded[configLocation] {
+		return fmt.Errorf("config file %s is included recursively", configLocation)
+	}
+	loaded[configLocation] = true
+	defer delete(loaded, configLocation)
+	cfg.Include = nil
+	if err := unmarshalConfigYaml(configYaml, cfg, "", cfg.options.AllowUnknownKeys); err != nil {
+		return fmt.Errorf("can't parse config file %s: %v", configLocation, err)
+	}
+	cfg.setFileSources(configLocation, configYaml)
+	includes := cfg.Include
+	cfg.Include = nil
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(configLocation), include)
+		}
+		files := []string{include}
+		if strings.ContainsAny(include, "*?[") {
+			var err error
+			// glob matches are sorted, pattern without matches is allowed to keep conf.d directory empty
+			if files, err = filepath.Glob(include); err != nil {
+				return fmt.Errorf("invalid include pattern %s in config file %s: %v", include, configLocation, err)
+			}
+		}
+		for _, file := range files {
+			includeYaml, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("can't open config file %s included from %s: %v", file, configLocation, err)
+			}
+			if err := loadConfigFile(cfg, file, includeYaml, loaded); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	// logSettingsMu - config is loaded concurrently by API handlers
+	logSettingsMu     sync.Mutex
+	currentLogHandler = "stdout/text"
+	currentLogLevel   = ""
+)
+
+// setLogLevel - change level only when `log_level` changed, level is read without lock by each log call of running operations
+func setLogLevel(level string) {
+	logSettingsMu.Lock()
+	defer logSettingsMu.Unlock()
+	if level == currentLogLevel {
+		return
+	}
+	log.SetLevelFromString(level)
+	currentLogLevel = level
+}
+
+// SetLogHandler - replace log handler only when log output options changed, previous syslog or journald connection will close
+func SetLogHandler(general *GeneralConfig) error {
+	logSettingsMu.Lock()
+	defer logSettingsMu.Unlock()
+	handlerKey := general.LogOutput + "/" + general.LogFormat
+	if general.LogOutput == "syslog" {
+		handlerKey = strings.Join([]string{general.LogOutput, general.SyslogNetwork, general.SyslogAddress, general.SyslogFacility, general.SyslogTag}, "/")
+	} else if general.LogOutput == "journald" {
+		handlerKey = general.LogOutput + "/" + general.SyslogTag
+	}
+	if handlerKey == currentLogHandler {
+		return nil
+	}
+	var handler log.Handler
+	var err error
+	switch general.LogOutput {
+	case "syslog":
+		if handler, err = logsyslog.New(general.SyslogNetwork, general.SyslogAddress, general.SyslogFacility, general.SyslogTag); err != nil {
+			return err
+		}
+	case "journald":
+		if handler, err = logjournald.New(logjournald.DefaultSocket, general.SyslogTag); err != nil {
+			return err
+		}
+	default:
+		out := os.Stdout
+		if general.LogOutput == "stderr" {
+			out = os.Stderr
+		}
+		switch general.LogFormat {
+		case "json":
+			handler = logjson.New(out)
+		case "logfmt":
+			handler = logfmt.New(out)
+		default:
+			handler = logcli.New(out)
+		}
+	}
+	if logger, ok := log.Log.(*log.Logger); ok {
+		if closer, ok := logger.Handler.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+	log.SetHandler(handler)
+	currentLogHandler = handlerKey
+	return nil
 }
 
 func ValidateConfig(cfg *Config) error {
+	switch cfg.General.LogFormat {
+	case "text", "json", "logfmt":
+	default:
+		return fmt.Errorf("'%s' is unknown log_format, allowed values text, json, logfmt", cfg.General.LogFormat)
+	}
+	switch cfg.General.LogOutput {
+	case "stdout", "stderr", "journald":
+	case "syslog":
+		switch cfg.General.SyslogNetwork {
+		case "udp", "tcp", "unix", "unixgram":
+		default:
+			return fmt.Errorf("'%s' is unknown syslog_network, allowed values udp, tcp, unix, unixgram", cfg.General.SyslogNetwork)
+		}
+	default:
+		return fmt.Errorf("'%s' is unknown log_output, allowed values stdout, stderr, syslog, journald", cfg.General.LogOutput)
+	}
+	if _, err := logsyslog.ParseFacility(cfg.General.SyslogFacility); err != nil {
+		return err
+	}
 	if cfg.GetCompressionFormat() == "unknown" {
 		return fmt.Errorf("'%s' is unknown remote storage", cfg.General.RemoteStorage)
 	}
@@ -265,6 +658,19 @@ func ValidateConfig(cfg *Config) error {
 			cfg.FTP.Concurrency, cfg.General.DownloadConcurrency, cfg.General.UploadConcurrency,
 		)
 	}
+	if _, err := ParseThrottleSchedule(cfg.General.ThrottleSchedule); err != nil {
+		return err
+	}
+	switch cfg.General.LinkMode {
+	case "auto", "hardlink", "copy":
+	default:
+		return fmt.Errorf("'%s' is unknown link_mode, allowed values auto, hardlink, copy", cfg.General.LinkMode)
+	}
+	switch cfg.General.UploadOrder {
+	case "", "largest_first", "smallest_first", "alphabetical":
+	default:
+		return fmt.Errorf("'%s' is unknown upload_order, allowed values largest_first, smallest_first, alphabetical", cfg.General.UploadOrder)
+	}
 	if cfg.GetCompressionFormat() == "lz4" {
 		return fmt.Errorf("clickhouse already compressed data by lz4")
 	}
@@ -274,15 +680,72 @@ func ValidateConfig(cfg *Config) error {
 	if _, err := time.ParseDuration(cfg.ClickHouse.Timeout); err != nil {
 		return err
 	}
+	if _, err := time.ParseDuration(cfg.ClickHouse.ConnectionIdleTimeout); err != nil {
+		return err
+	}
+	for name, timeout := range map[string]string{
+		"freeze_timeout":       cfg.ClickHouse.FreezeTimeout,
+		"ddl_timeout":          cfg.ClickHouse.DDLTimeout,
+		"metadata_timeout":     cfg.ClickHouse.MetadataTimeout,
+		"sync_replica_timeout": cfg.ClickHouse.SyncReplicaTimeout,
+	} {
+		if _, err := time.ParseDuration(timeout); timeout != "" && err != nil {
+			return fmt.Errorf("can't parse clickhouse->%s: %v", name, err)
+		}
+	}
+	switch cfg.ClickHouse.CheckReplicas {
+	case "skip", "warn", "fail":
+	case "wait":
+		if _, err := time.ParseDuration(cfg.ClickHouse.CheckReplicasTimeout); err != nil {
+			return fmt.Errorf("can't parse clickhouse->check_replicas_timeout: %v", err)
+		}
+	default:
+		return fmt.Errorf("'%s' is bad check_replicas, allowed values: skip, warn, fail, wait", cfg.ClickHouse.CheckReplicas)
+	}
+	if _, err := time.ParseDuration(cfg.ClickHouse.WaitMergesTimeout); cfg.ClickHouse.WaitMergesBeforeFreeze && err != nil {
+		return fmt.Errorf("can't parse clickhouse->wait_merges_timeout: %v", err)
+	}
+	for name := range cfg.ClickHouse.Settings {
+		if !settingNameRE.MatchString(name) {
+			return fmt.Errorf("'%s' is bad setting name in clickhouse->settings", name)
+		}
+	}
+	if cfg.ClickHouse.MaxConnections < 1 {
+		return fmt.Errorf("clickhouse->max_connections shall be greater than 0")
+	}
+	if cfg.ClickHouse.FreezeConcurrency < 1 || int(cfg.ClickHouse.FreezeConcurrency) > cfg.ClickHouse.MaxConnections {
+		return fmt.Errorf("clickhouse->freeze_concurrency=%d shall be greater than 0 and not greater than clickhouse->max_connections=%d", cfg.ClickHouse.FreezeConcurrency, cfg.ClickHouse.MaxConnections)
+	}
 	if _, err := time.ParseDuration(cfg.COS.Timeout); err != nil {
 		return err
 	}
 	if _, err := time.ParseDuration(cfg.FTP.Timeout); err != nil {
 		return err
 	}
+	if _, err := time.ParseDuration(cfg.GRPC.Timeout); err != nil {
+		return err
+	}
+	if cfg.General.RemoteStorage == "grpc" && cfg.GRPC.Address == "" {
+		return fmt.Errorf("grpc->address shall be defined for remote_storage: grpc")
+	}
 	if _, err := time.ParseDuration(cfg.AzureBlob.Timeout); err != nil {
 		return err
 	}
+	if _, err := time.ParseDuration(cfg.Metrics.Timeout); err != nil {
+		return err
+	}
+	if cfg.Metrics.PushgatewayURL != "" && cfg.Metrics.PushgatewayJob == "" {
+		return fmt.Errorf("metrics.pushgateway_job should be defined when metrics.pushgateway_url is defined")
+	}
+	if _, err := time.ParseDuration(cfg.Tracing.Timeout); err != nil {
+		return err
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio should be between 0 and 1")
+	}
+	if _, err := time.ParseDuration(cfg.Sentry.Timeout); err != nil {
+		return err
+	}
 	storageClassOk := false
 	for _, storageClass := range s3.StorageClass_Values() {
 		if strings.ToUpper(cfg.S3.StorageClass) == storageClass {
@@ -294,6 +757,100 @@ func ValidateConfig(cfg *Config) error {
 		return fmt.Errorf("'%s' is bad S3_STORAGE_CLASS, select one of: %s",
 			cfg.S3.StorageClass, strings.Join(s3.StorageClass_Values(), ", "))
 	}
+	for src, dst := range cfg.General.RestoreDatabaseMapping {
+		if src == "" || dst == "" {
+			return fmt.Errorf("restore_database_mapping contains empty database name in '%s:%s'", src, dst)
+		}
+	}
+	for src, dst := range cfg.General.RestoreTableMapping {
+		if !strings.Contains(src, ".") || dst == "" {
+			return fmt.Errorf("restore_table_mapping '%s:%s' is wrong, source should be `database.table`, destination should be `database.table` or `table`", src, dst)
+		}
+	}
+	for src, dst := range cfg.General.RestoreDiskMapping {
+		if src == "" || dst == "" {
+			return fmt.Errorf("restore_disk_mapping contains empty disk name in '%s:%s'", src, dst)
+		}
+	}
+	for src, dst := range cfg.General.RestoreStoragePolicyMapping {
+		if src == "" || dst == "" {
+			return fmt.Errorf("restore_storage_policy_mapping contains empty storage policy name in '%s:%s'", src, dst)
+		}
+	}
+	for name, value := range cfg.General.RestoreTableSettings {
+		if !settingNameRE.MatchString(name) || strings.TrimSpace(value) == "" {
+			return fmt.Errorf("restore_table_settings '%s:%s' is wrong, setting name should contain only letters, digits and underscore, value shouldn't be empty", name, value)
+		}
+	}
+	for option, tags := range map[string]map[string]string{"backup_tags": cfg.General.BackupTags, "retention_tags": cfg.General.RetentionTags} {
+		for key, value := range tags {
+			if key == "" || strings.ContainsAny(key, ",=") || strings.Contains(value, ",") {
+				return fmt.Errorf("%s '%s:%s' is wrong, key shouldn't be empty, key and value shouldn't contain `,`, key shouldn't contain `=`", option, key, value)
+			}
+		}
+	}
+	switch cfg.General.RestoreReplicatedEngine {
+	case "", "merge_tree":
+	case "replicated":
+		if cfg.General.RestoreReplicatedZookeeperPath == "" || cfg.General.RestoreReplicatedReplicaName == "" {
+			return fmt.Errorf("restore_replicated_zookeeper_path and restore_replicated_replica_name are required for restore_replicated_engine: replicated")
+		}
+	default:
+		return fmt.Errorf("'%s' is bad restore_replicated_engine, allowed values: merge_tree, replicated", cfg.General.RestoreReplicatedEngine)
+	}
+	if cfg.General.RestoreTableUUID != "keep" && cfg.General.RestoreTableUUID != "regenerate" {
+		return fmt.Errorf("'%s' is bad restore_table_uuid, allowed values: keep, regenerate", cfg.General.RestoreTableUUID)
+	}
+	if cfg.General.DeleteConcurrency <= 0 {
+		return fmt.Errorf("delete_concurrency shall be greater than 0, current value %d", cfg.General.DeleteConcurrency)
+	}
+	if _, err := time.ParseDuration(cfg.General.StorageRetriesPause); cfg.General.StorageRetries > 0 && err != nil {
+		return fmt.Errorf("can't parse storage_retries_pause: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.General.StorageRetriesMaxPause); cfg.General.StorageRetries > 0 && err != nil {
+		return fmt.Errorf("can't parse storage_retries_max_pause: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.General.RestoreSyncReplicasTimeout); cfg.General.RestoreSyncReplicas && err != nil {
+		return fmt.Errorf("can't parse restore_sync_replicas_timeout: %v", err)
+	}
+	if cfg.General.SingleReplicaBackup && !strings.HasPrefix(cfg.General.SingleReplicaBackupPath, "/") {
+		return fmt.Errorf("single_replica_backup_path shall be absolute znode path, current value '%s'", cfg.General.SingleReplicaBackupPath)
+	}
+	if cfg.General.BackupNameTemplate == "" {
+		return fmt.Errorf("backup_name_template can't be empty")
+	}
+	if cfg.ClickHouse.Protocol != "native" && cfg.ClickHouse.Protocol != "http" {
+		return fmt.Errorf("'%s' is bad clickhouse->protocol, allowed values: native, http", cfg.ClickHouse.Protocol)
+	}
+	if (cfg.ClickHouse.TLSCert == "") != (cfg.ClickHouse.TLSKey == "") {
+		return fmt.Errorf("clickhouse->tls_cert and clickhouse->tls_key shall be defined together for TLS client certificate")
+	}
+	if cfg.ClickHouse.ObjectDiskBackupMode != "download" && cfg.ClickHouse.ObjectDiskBackupMode != "zero-copy" {
+		return fmt.Errorf("'%s' is bad object_disk_backup_mode, allowed values: download, zero-copy", cfg.ClickHouse.ObjectDiskBackupMode)
+	}
+	if cfg.ClickHouse.BackupEngine != "freeze" && cfg.ClickHouse.BackupEngine != "native" {
+		return fmt.Errorf("'%s' is bad backup_engine, allowed values: freeze, native", cfg.ClickHouse.BackupEngine)
+	}
+	if cfg.ClickHouse.BackupEngine == "native" && cfg.ClickHouse.NativeBackupDisk == "" {
+		return fmt.Errorf("native_backup_disk shall be defined for backup_engine: native")
+	}
+	for operation, limit := range cfg.API.MaxConcurrentOperations {
+		if operation != "all" && !IsAPIOperation(operation) {
+			return fmt.Errorf("api.max_concurrent_operations contains unknown operation '%s', allowed values: all, %s", operation, strings.Join(APIOperations, ", "))
+		}
+		if limit < 0 {
+			return fmt.Errorf("api.max_concurrent_operations for '%s' should be positive", operation)
+		}
+	}
+	if cfg.API.RateLimit < 0 || cfg.API.RateLimitBurst < 0 {
+		return fmt.Errorf("api.rate_limit and api.rate_limit_burst should be positive")
+	}
+	if cfg.API.EnableTableMetrics && cfg.API.TableMetricsLimit <= 0 {
+		return fmt.Errorf("api.table_metrics_limit should be greater than 0 when api.enable_table_metrics is true")
+	}
+	if _, err := time.ParseDuration(cfg.API.RemoteUsageInterval); err != nil {
+		return err
+	}
 	if cfg.API.Secure {
 		if cfg.API.CertificateFile == "" {
 			return fmt.Errorf("api.certificate_file must be defined")
@@ -309,14 +866,184 @@ func ValidateConfig(cfg *Config) error {
 	return nil
 }
 
-// PrintConfig - print default / current config to stdout
+// ParseMapping - parse `src1:dst1,src2:dst2` into map, the same format as envconfig use for map[string]string
+func ParseMapping(mapping string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(mapping, ",") {
+		pair = strings.Trim(pair, " \t\r\n")
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid mapping '%s', expected format `src:dst`", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// UseClickHouseTarget - overwrite `clickhouse` section with keys defined in `clickhouse_targets.<name>`, absent keys are kept from `clickhouse` section
+func (cfg *Config) UseClickHouseTarget(name string) error {
+	target, exists := cfg.ClickHouseTargets[name]
+	if !exists {
+		return fmt.Errorf("clickhouse_targets.%s is not defined in config", name)
+	}
+	targetConfig, err := clickHouseTargetConfig(cfg.ClickHouse, name, target, cfg.options.AllowUnknownKeys)
+	if err != nil {
+		return err
+	}
+	cfg.ClickHouse = targetConfig
+	return nil
+}
+
+// clickHouseTargetConfig - keys of target override `clickhouse` section, unknown keys fail the same way as in config file
+func clickHouseTargetConfig(base ClickHouseConfig, name string, target map[string]interface{}, allowUnknownKeys bool) (ClickHouseConfig, error) {
+	targetYaml, err := yaml.Marshal(target)
+	if err != nil {
+		return base, fmt.Errorf("can't marshal clickhouse_targets.%s: %v", name, err)
+	}
+	if err := unmarshalConfigYaml(targetYaml, &base, "clickhouse_targets."+name, allowUnknownKeys); err != nil {
+		return base, fmt.Errorf("can't parse clickhouse_targets.%s: %v", name, err)
+	}
+	return base, nil
+}
+
+// ApplyOverrides - `section.key=value` pairs of `--set`, value is parsed as YAML, so lists and maps could be passed as `[a, b]` and `{k: v}`,
+// value which is not valid YAML is used as string, unknown keys always fail
+func (cfg *Config) ApplyOverrides(overrides []string) error {
+	for _, override := range overrides {
+		key, value, found := strings.Cut(override, "=")
+		section, field, isNested := strings.Cut(strings.TrimSpace(key), ".")
+		if !found || !isNested || section == "" || field == "" {
+			return fmt.Errorf("invalid --set '%s', expected format `section.key=value`", override)
+		}
+		if !isConfigKey(section, field) {
+			return fmt.Errorf("invalid --set '%s', unknown config key `%s.%s`", override, section, field)
+		}
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			parsed = value
+		}
+		if err := applyOverride(cfg, section, field, parsed); err != nil {
+			if _, isString := parsed.(string); isString || applyOverride(cfg, section, field, value) != nil {
+				return fmt.Errorf("invalid --set '%s': %v", override, err)
+			}
+		}
+		_, merged := parsed.(map[interface{}]interface{})
+		cfg.setSource(section+"."+field, "--set", merged)
+	}
+	return nil
+}
+
+func applyOverride(cfg *Config, section, field string, value interface{}) error {
+	body, err := yaml.Marshal(map[string]map[string]interface{}{section: {field: value}})
+	if err != nil {
+		return err
+	}
+	err = yaml.UnmarshalStrict(body, cfg)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		// lines of marshaled override don't mean anything for user
+		return fmt.Errorf("%s", overrideLineRE.ReplaceAllString(strings.Join(typeErr.Errors, ", "), ""))
+	}
+	return err
+}
+
+var overrideLineRE = regexp.MustCompile(`line \d+: `)
+
+// isConfigKey - field with `yaml` tag exists in config section, `clickhouse_targets` can't be overridden
+func isConfigKey(section, field string) bool {
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		sectionField := configType.Field(i)
+		if strings.Split(sectionField.Tag.Get("yaml"), ",")[0] != section || sectionField.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < sectionField.Type.NumField(); j++ {
+			if strings.Split(sectionField.Type.Field(j).Tag.Get("yaml"), ",")[0] == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// envVariableName - name of environment variable for `envconfig` tag with `--env-prefix`
+func envVariableName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// processEnv - envconfig.Process with prefix reads `<prefix>_<NAME>` variables of each section and falls back to not prefixed `<NAME>`,
+// so fields defined only by not prefixed variable are restored
+func processEnv(cfg *Config, prefix string) error {
+	if prefix == "" {
+		return envconfig.Process("", cfg)
+	}
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		section := value.Field(i)
+		if value.Type().Field(i).Tag.Get("envconfig") != "_" {
+			continue
+		}
+		previous := reflect.New(section.Type()).Elem()
+		previous.Set(section)
+		if err := envconfig.Process(prefix, section.Addr().Interface()); err != nil {
+			return err
+		}
+		for j := 0; j < section.NumField(); j++ {
+			if _, exists := os.LookupEnv(envVariableName(prefix, section.Type().Field(j).Tag.Get("envconfig"))); !exists {
+				section.Field(j).Set(previous.Field(j))
+			}
+		}
+	}
+	return nil
+}
+
+var unknownConfigKeyRE = regexp.MustCompile(`line (\d+): field (\S+) not found in type (\S+)`)
+
+// unmarshalConfigYaml - unknown keys fail with line and full key name like `general.compression_fromat`, section is taken from yaml tags of Config,
+// lines of marshaled sections with prefix don't match config file and are not reported
+func unmarshalConfigYaml(body []byte, v interface{}, prefix string, allowUnknownKeys bool) error {
+	if allowUnknownKeys {
+		return yaml.Unmarshal(body, v)
+	}
+	err := yaml.UnmarshalStrict(body, v)
+	if err == nil {
+		return nil
+	}
+	sections := map[string]string{}
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		sections[field.Type.String()] = strings.Split(field.Tag.Get("yaml"), ",")[0]
+	}
+	msg := unknownConfigKeyRE.ReplaceAllStringFunc(err.Error(), func(s string) string {
+		match := unknownConfigKeyRE.FindStringSubmatch(s)
+		if prefix != "" {
+			return fmt.Sprintf("unknown key `%s.%s`", prefix, match[2])
+		}
+		key := match[2]
+		if section, exists := sections[match[3]]; exists {
+			key = section + "." + key
+		}
+		return fmt.Sprintf("line %s: unknown key `%s`", match[1], key)
+	})
+	if msg == err.Error() {
+		return err
+	}
+	return fmt.Errorf("%s, fix it or use --allow-unknown to ignore unknown keys", msg)
+}
+
+// PrintConfig - print default config or current config with masked secrets and source of each value to stdout
 func PrintConfig(ctx *cli.Context) error {
-	var cfg *Config
-	if ctx == nil {
-		cfg = DefaultConfig()
-	} else {
-		cfg = GetConfig(ctx)
+	if ctx != nil {
+		return printEffectiveConfig(os.Stdout, GetConfig(ctx))
 	}
+	cfg := DefaultConfig()
 	yml, _ := yaml.Marshal(&cfg)
 	fmt.Print(string(yml))
 	return nil
@@ -329,34 +1056,66 @@ func DefaultConfig() *Config {
 	}
 	return &Config{
 		General: GeneralConfig{
-			RemoteStorage:          "none",
-			MaxFileSize:            0,
-			BackupsToKeepLocal:     0,
-			BackupsToKeepRemote:    0,
-			LogLevel:               "info",
-			DisableProgressBar:     true,
-			UploadConcurrency:      availableConcurrency,
-			DownloadConcurrency:    availableConcurrency,
-			RestoreSchemaOnCluster: "",
-			UploadByPart:           true,
-			DownloadByPart:         true,
+			RemoteStorage:              "none",
+			MaxFileSize:                0,
+			BackupsToKeepLocal:         0,
+			BackupsToKeepRemote:        0,
+			LogLevel:                   "info",
+			LogFormat:                  "text",
+			LogOutput:                  "stdout",
+			SyslogNetwork:              "unixgram",
+			SyslogAddress:              "/dev/log",
+			SyslogFacility:             "daemon",
+			SyslogTag:                  "clickhouse-backup",
+			DisableProgressBar:         true,
+			UploadConcurrency:          availableConcurrency,
+			DownloadConcurrency:        availableConcurrency,
+			RestoreSchemaOnCluster:     "",
+			RestoreTableUUID:           "keep",
+			RestoreSyncReplicasTimeout: "30m",
+			SingleReplicaBackupPath:    "/clickhouse/clickhouse-backup/{shard}",
+			BackupNameTemplate:         "{datetime}",
+			UploadByPart:               true,
+			LinkMode:                   "auto",
+			DownloadByPart:             true,
+			DownloadRetries:            5,
+			DeleteConcurrency:          16,
+			StorageRetries:             3,
+			StorageRetriesPause:        "1s",
+			StorageRetriesMaxPause:     "30s",
+			StorageMetrics:             true,
+			FileChecksums:              true,
+
+			RestoreReplicatedZookeeperPath: "/clickhouse/tables/{shard}/{database}/{table}",
+			RestoreReplicatedReplicaName:   "{replica}",
 		},
 		ClickHouse: ClickHouseConfig{
 			Username: "default",
 			Password: "",
 			Host:     "localhost",
 			Port:     9000,
+			Protocol: "native",
 			SkipTables: []string{
 				"system.*",
 				"INFORMATION_SCHEMA.*",
 				"information_schema.*",
 			},
 			Timeout:                          "5m",
+			MaxConnections:                   1,
+			FreezeConcurrency:                1,
+			ConnectionIdleTimeout:            "1m",
 			SyncReplicatedTables:             false,
+			CheckReplicas:                    "warn",
+			CheckReplicasTimeout:             "5m",
+			WaitMergesTimeout:                "10m",
 			LogSQLQueries:                    true,
 			ConfigDir:                        "/etc/clickhouse-server/",
+			ConfigRedactTags:                 []string{"password", "password_sha256_hex", "password_double_sha1_hex", "access_key_id", "secret_access_key", "secret", "bind_password"},
 			RestartCommand:                   "systemctl restart clickhouse-server",
 			IgnoreNotExistsErrorDuringFreeze: true,
+			ObjectDiskBackupMode:             "download",
+			BackupEngine:                     "freeze",
+			NativeBackupDisk:                 "backups",
 		},
 		AzureBlob: AzureBlobConfig{
 			EndpointSuffix:    "core.windows.net",
@@ -394,8 +1153,11 @@ func DefaultConfig() *Config {
 			CompressionLevel:  1,
 		},
 		API: APIConfig{
-			ListenAddr:    "localhost:7171",
-			EnableMetrics: true,
+			ListenAddr:          "localhost:7171",
+			EnableMetrics:       true,
+			TableMetricsLimit:   100,
+			RemoteUsageInterval: "0s",
+			RateLimitBurst:      10,
 		},
 		FTP: FTPConfig{
 			Timeout:           "2m",
@@ -409,18 +1171,56 @@ func DefaultConfig() *Config {
 			CompressionLevel:  1,
 			Concurrency:       1,
 		},
+		GRPC: GRPCConfig{
+			Timeout:           "30s",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
+		Metrics: MetricsConfig{
+			Timeout:        "30s",
+			PushgatewayJob: "clickhouse_backup",
+			StatsdPrefix:   "clickhouse_backup",
+			GraphitePrefix: "clickhouse_backup",
+		},
+		Tracing: TracingConfig{
+			URLPath:     "/v1/traces",
+			ServiceName: "clickhouse-backup",
+			SampleRatio: 1,
+			Timeout:     "10s",
+		},
+		Sentry: SentryConfig{
+			Timeout: "5s",
+		},
 	}
 }
 
 func GetConfig(ctx *cli.Context) *Config {
 	configPath := GetConfigPath(ctx)
-	cfg, err := LoadConfig(configPath)
+	cfg, err := LoadConfigWithOverrides(configPath, GetConfigOverrides(ctx), GetLoadOptions(ctx))
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 	return cfg
 }
 
+// GetConfigOverrides - `--set` values of app and command, values of command are applied last
+func GetConfigOverrides(ctx *cli.Context) []string {
+	return append(ctx.GlobalStringSlice("set"), ctx.StringSlice("set")...)
+}
+
+// GetLoadOptions - `--allow-unknown` and `--env-prefix` of command or app
+func GetLoadOptions(ctx *cli.Context) LoadOptions {
+	envPrefix := ctx.String("env-prefix")
+	if envPrefix == "" {
+		envPrefix = ctx.GlobalString("env-prefix")
+	}
+	return LoadOptions{
+		AllowUnknownKeys: ctx.Bool("allow-unknown") || ctx.GlobalBool("allow-unknown"),
+		EnvPrefix:        strings.TrimSuffix(envPrefix, "_"),
+	}
+}
+
+// GetConfigPath - config file from `--config` or CLICKHOUSE_BACKUP_CONFIG
 func GetConfigPath(ctx *cli.Context) string {
 	if ctx.String("config") != DefaultConfigPath {
 		return ctx.String("config")
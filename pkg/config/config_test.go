@@ -0,0 +1,331 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapConcurrency(t *testing.T) {
+	testData := []struct {
+		remoteStorage string
+		concurrency   uint8
+		maxTransfers  uint8
+		expected      uint8
+	}{
+		{"s3", 4, 0, 4},
+		{"s3", 4, 2, 2},
+		{"s3", 2, 4, 2},
+		{"s3", 0, 0, 1},
+		{"gcs", 8, 3, 3},
+		{"azblob", 8, 3, 3},
+		{"cos", 8, 3, 3},
+		{"sftp", 8, 3, 3},
+		{"grpc", 8, 3, 3},
+		{"ftp", 8, 3, 3},
+		{"none", 8, 3, 8},
+	}
+	for _, tt := range testData {
+		cfg := DefaultConfig()
+		cfg.General.RemoteStorage = tt.remoteStorage
+		cfg.S3.MaxConcurrentTransfers = tt.maxTransfers
+		cfg.GCS.MaxConcurrentTransfers = tt.maxTransfers
+		cfg.AzureBlob.MaxConcurrentTransfers = tt.maxTransfers
+		cfg.COS.MaxConcurrentTransfers = tt.maxTransfers
+		cfg.SFTP.MaxConcurrentTransfers = tt.maxTransfers
+		cfg.GRPC.MaxConcurrentTransfers = tt.maxTransfers
+		cfg.FTP.Concurrency = tt.maxTransfers
+		assert.Equal(t, tt.expected, cfg.capConcurrency(tt.concurrency), "%s concurrency=%d max_concurrent_transfers=%d", tt.remoteStorage, tt.concurrency, tt.maxTransfers)
+
+		cfg.General.UploadConcurrency, cfg.General.DownloadConcurrency = tt.concurrency, tt.concurrency
+		assert.Equal(t, tt.expected, cfg.GetUploadConcurrency())
+		assert.Equal(t, tt.expected, cfg.GetDownloadConcurrency())
+	}
+}
+
+func TestIsConfigKey(t *testing.T) {
+	testData := []struct {
+		section  string
+		field    string
+		expected bool
+	}{
+		{"general", "remote_storage", true},
+		{"general", "restore_database_mapping", true},
+		{"clickhouse", "skip_tables", true},
+		{"s3", "disable_ssl", true},
+		{"general", "unknown_key", false},
+		{"unknown_section", "remote_storage", false},
+		{"clickhouse_targets", "host", false},
+		{"general", "", false},
+	}
+	for _, tt := range testData {
+		assert.Equal(t, tt.expected, isConfigKey(tt.section, tt.field), "%s.%s", tt.section, tt.field)
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.ApplyOverrides([]string{
+		"general.remote_storage=s3",
+		"general.upload_concurrency=7",
+		"s3.disable_ssl=true",
+		"clickhouse.timeout=10m",
+		"clickhouse.skip_tables=[system.*, db.tmp_*]",
+		"general.restore_database_mapping={db: db_copy, db2: db2_copy}",
+		"general.log_level=123",
+		"s3.path=backup=path",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "s3", cfg.General.RemoteStorage)
+	assert.Equal(t, uint8(7), cfg.General.UploadConcurrency)
+	assert.True(t, cfg.S3.DisableSSL)
+	assert.Equal(t, "10m", cfg.ClickHouse.Timeout)
+	assert.Equal(t, []string{"system.*", "db.tmp_*"}, cfg.ClickHouse.SkipTables)
+	assert.Equal(t, map[string]string{"db": "db_copy", "db2": "db2_copy"}, cfg.General.RestoreDatabaseMapping)
+	assert.Equal(t, "123", cfg.General.LogLevel, "number is applied to string key as is")
+	assert.Equal(t, "backup=path", cfg.S3.Path, "only first `=` separates key and value")
+	assert.Equal(t, "--set", cfg.ValueSource("general.upload_concurrency"))
+	assert.Equal(t, "default", cfg.ValueSource("general.download_concurrency"))
+}
+
+func TestApplyOverridesMergeMapping(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db1": "db1_copy"}
+	cfg.setSource("general.restore_database_mapping", "/etc/clickhouse-backup/config.yml", true)
+	assert.NoError(t, cfg.ApplyOverrides([]string{"general.restore_database_mapping={db2: db2_copy}"}))
+	assert.Equal(t, map[string]string{"db1": "db1_copy", "db2": "db2_copy"}, cfg.General.RestoreDatabaseMapping)
+	assert.Equal(t, "/etc/clickhouse-backup/config.yml, --set", cfg.ValueSource("general.restore_database_mapping"))
+}
+
+func TestApplyOverridesErrors(t *testing.T) {
+	testData := []struct {
+		override string
+		expected string
+	}{
+		{"general.remote_storage", "expected format `section.key=value`"},
+		{"remote_storage=s3", "expected format `section.key=value`"},
+		{".remote_storage=s3", "expected format `section.key=value`"},
+		{"general.=s3", "expected format `section.key=value`"},
+		{"general.unknown_key=1", "unknown config key `general.unknown_key`"},
+		{"unknown.remote_storage=s3", "unknown config key `unknown.remote_storage`"},
+		{"clickhouse_targets.host=localhost", "unknown config key `clickhouse_targets.host`"},
+		{"general.upload_concurrency=many", "invalid --set 'general.upload_concurrency=many'"},
+		{"general.upload_concurrency=1000", "invalid --set 'general.upload_concurrency=1000'"},
+		{"s3.disable_ssl=maybe", "invalid --set 's3.disable_ssl=maybe'"},
+		{"clickhouse.skip_tables={a: b}", "invalid --set 'clickhouse.skip_tables={a: b}'"},
+	}
+	for _, tt := range testData {
+		cfg := DefaultConfig()
+		err := cfg.ApplyOverrides([]string{tt.override})
+		if assert.Error(t, err, tt.override) {
+			assert.Contains(t, err.Error(), tt.expected)
+			assert.NotContains(t, err.Error(), "line 1:")
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, dir, name, body string) string {
+	configFile := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(configFile, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	testData := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "section key",
+			body:     "general:\n  remote_storage: none\n  compression_fromat: tar\n",
+			expected: "line 3: unknown key `general.compression_fromat`, fix it or use --allow-unknown to ignore unknown keys",
+		},
+		{
+			name:     "section",
+			body:     "general:\n  remote_storage: none\nunknown_section:\n  key: value\n",
+			expected: "line 3: unknown key `unknown_section`, fix it or use --allow-unknown to ignore unknown keys",
+		},
+		{
+			name:     "clickhouse target key",
+			body:     "clickhouse_targets:\n  replica:\n    hots: replica\n",
+			expected: "unknown key `clickhouse_targets.replica.hots`, fix it or use --allow-unknown to ignore unknown keys",
+		},
+	}
+	for _, tt := range testData {
+		configFile := writeConfigFile(t, t.TempDir(), "config.yml", tt.body)
+		_, err := LoadConfig(configFile, LoadOptions{})
+		if assert.Error(t, err, tt.name) {
+			assert.Contains(t, err.Error(), tt.expected, tt.name)
+		}
+
+		cfg, err := LoadConfig(configFile, LoadOptions{AllowUnknownKeys: true})
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, "none", cfg.General.RemoteStorage, tt.name)
+	}
+}
+
+func TestUseClickHouseTargetAllowUnknownKeys(t *testing.T) {
+	configFile := writeConfigFile(t, t.TempDir(), "config.yml", "clickhouse:\n  host: primary\nclickhouse_targets:\n  replica:\n    host: replica\n    hots: replica\n")
+	cfg, err := LoadConfig(configFile, LoadOptions{AllowUnknownKeys: true})
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.UseClickHouseTarget("replica"))
+	assert.Equal(t, "replica", cfg.ClickHouse.Host)
+}
+
+func TestLoadConfigFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	for _, subDir := range []string{"conf.d", "nested"} {
+		if err := os.Mkdir(filepath.Join(dir, subDir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configFile := writeConfigFile(t, dir, "config.yml", `general:
+  remote_storage: none
+  upload_concurrency: 2
+  restore_database_mapping:
+    db1: db1_main
+clickhouse:
+  skip_tables: [system.*, a.*]
+include:
+  - storage.yml
+  - conf.d/*.yml
+  - `+filepath.Join(dir, "nested", "absolute.yml")+`
+`)
+	writeConfigFile(t, dir, "storage.yml", "general:\n  remote_storage: s3\n  restore_database_mapping:\n    db2: db2_storage\ns3:\n  bucket: storage\n")
+	writeConfigFile(t, dir, "conf.d/02.yml", "s3:\n  bucket: second\ninclude:\n  - ../nested/relative.yml\n")
+	first := writeConfigFile(t, dir, "conf.d/01.yml", "s3:\n  bucket: first\nclickhouse:\n  skip_tables: [b.*]\n")
+	writeConfigFile(t, dir, "conf.d/03.yaml", "s3:\n  bucket: not_matched\n")
+	writeConfigFile(t, dir, "nested/relative.yml", "s3:\n  path: relative\n")
+	absolute := writeConfigFile(t, dir, "nested/absolute.yml", "s3:\n  bucket: absolute\n")
+
+	cfg, err := LoadConfig(configFile, LoadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "s3", cfg.General.RemoteStorage, "included file overrides including file")
+	assert.Equal(t, uint8(2), cfg.General.UploadConcurrency, "keys absent in includes are kept")
+	assert.Equal(t, map[string]string{"db1": "db1_main", "db2": "db2_storage"}, cfg.General.RestoreDatabaseMapping, "mappings are merged")
+	assert.Equal(t, []string{"b.*"}, cfg.ClickHouse.SkipTables, "lists are replaced")
+	assert.Equal(t, "absolute", cfg.S3.Bucket, "includes are applied in listed order, glob matches are sorted")
+	assert.Equal(t, "relative", cfg.S3.Path, "relative include is resolved from directory of including file")
+	assert.Equal(t, absolute, cfg.ValueSource("s3.bucket"))
+	assert.Equal(t, first, cfg.ValueSource("clickhouse.skip_tables"))
+	assert.Equal(t, configFile+", "+filepath.Join(dir, "storage.yml"), cfg.ValueSource("general.restore_database_mapping"))
+	assert.Nil(t, cfg.Include)
+}
+
+func TestLoadConfigFileIncludeErrors(t *testing.T) {
+	testData := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			name:     "empty glob",
+			files:    map[string]string{"config.yml": "include: [conf.d/*.yml]\n"},
+			expected: "",
+		},
+		{
+			name: "same file from several includes",
+			files: map[string]string{
+				"config.yml": "include: [a.yml, b.yml]\n",
+				"a.yml":      "include: [common.yml]\n",
+				"b.yml":      "include: [common.yml]\n",
+				"common.yml": "s3:\n  bucket: common\n",
+			},
+			expected: "",
+		},
+		{
+			name:     "missing file",
+			files:    map[string]string{"config.yml": "include: [missing.yml]\n"},
+			expected: "can't open config file {dir}/missing.yml included from {dir}/config.yml",
+		},
+		{
+			name:     "invalid pattern",
+			files:    map[string]string{"config.yml": "include: ['conf.d/[*.yml']\n"},
+			expected: "invalid include pattern {dir}/conf.d/[*.yml in config file {dir}/config.yml",
+		},
+		{
+			name:     "self include",
+			files:    map[string]string{"config.yml": "include: [config.yml]\n"},
+			expected: "config file {dir}/config.yml is included recursively",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"config.yml": "include: [a.yml]\n",
+				"a.yml":      "include: [b.yml]\n",
+				"b.yml":      "include: [./a.yml]\n",
+			},
+			expected: "config file {dir}/a.yml is included recursively",
+		},
+		{
+			name: "unknown key in include",
+			files: map[string]string{
+				"config.yml": "include: [a.yml]\n",
+				"a.yml":      "s3:\n  buckett: typo\n",
+			},
+			expected: "can't parse config file {dir}/a.yml: yaml: unmarshal errors:\n  line 2: unknown key `s3.buckett`",
+		},
+	}
+	for _, tt := range testData {
+		dir := t.TempDir()
+		for name, body := range tt.files {
+			writeConfigFile(t, dir, name, body)
+		}
+		_, err := LoadConfig(filepath.Join(dir, "config.yml"), LoadOptions{})
+		if tt.expected == "" {
+			assert.NoError(t, err, tt.name)
+		} else if assert.Error(t, err, tt.name) {
+			assert.Contains(t, err.Error(), strings.ReplaceAll(tt.expected, "{dir}", dir), tt.name)
+		}
+	}
+}
+
+func TestLoadConfigEnvPrefix(t *testing.T) {
+	t.Setenv("S3_BUCKET", "not_prefixed")
+	t.Setenv("CHB1_S3_BUCKET", "prefixed")
+	t.Setenv("UPLOAD_CONCURRENCY", "5")
+	t.Setenv("CHB1_CLICKHOUSE_SKIP_TABLES", "system.*,db.tmp")
+	t.Setenv("CHB2_S3_BUCKET", "other_instance")
+	configFile := writeConfigFile(t, t.TempDir(), "config.yml", "general:\n  upload_concurrency: 2\ns3:\n  path: from_file\n")
+
+	cfg, err := LoadConfig(configFile, LoadOptions{EnvPrefix: "CHB1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "prefixed", cfg.S3.Bucket)
+	assert.Equal(t, []string{"system.*", "db.tmp"}, cfg.ClickHouse.SkipTables)
+	assert.Equal(t, uint8(2), cfg.General.UploadConcurrency, "not prefixed variable is ignored")
+	assert.Equal(t, "from_file", cfg.S3.Path)
+	assert.Equal(t, "env CHB1_S3_BUCKET", cfg.ValueSource("s3.bucket"))
+	assert.Equal(t, configFile, cfg.ValueSource("general.upload_concurrency"))
+
+	cfg, err = LoadConfig(configFile, LoadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "not_prefixed", cfg.S3.Bucket)
+	assert.Equal(t, uint8(5), cfg.General.UploadConcurrency)
+	assert.Equal(t, "env S3_BUCKET", cfg.ValueSource("s3.bucket"))
+
+	t.Setenv("CHB1_UPLOAD_CONCURRENCY", "many")
+	_, err = LoadConfig(configFile, LoadOptions{EnvPrefix: "CHB1"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CHB1_UPLOAD_CONCURRENCY")
+	}
+}
+
+func TestValidateConfigMaxConcurrentOperations(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.API.MaxConcurrentOperations = map[string]int{"all": 4}
+	for _, operation := range APIOperations {
+		cfg.API.MaxConcurrentOperations[operation] = 1
+	}
+	assert.NoError(t, ValidateConfig(cfg))
+	cfg.API.MaxConcurrentOperations = map[string]int{"freeze": 1}
+	assert.ErrorContains(t, ValidateConfig(cfg), "api.max_concurrent_operations contains unknown operation 'freeze'")
+	cfg.API.MaxConcurrentOperations = map[string]int{"remote-check": -1}
+	assert.EqualError(t, ValidateConfig(cfg), "api.max_concurrent_operations for 'remote-check' should be positive")
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const maskedConfigValue = "******"
+
+// secretConfigKeys - values replaced by maskedConfigValue in `print-config`, empty values are printed as is to show secret is not set
+var secretConfigKeys = map[string]bool{
+	"clickhouse.password":          true,
+	"s3.access_key":                true,
+	"s3.secret_key":                true,
+	"gcs.credentials_json":         true,
+	"cos.secret_id":                true,
+	"cos.secret_key":               true,
+	"azblob.account_key":           true,
+	"azblob.sas":                   true,
+	"azblob.sse_key":               true,
+	"ftp.password":                 true,
+	"sftp.password":                true,
+	"api.password":                 true,
+	"grpc.token":                   true,
+	"metrics.pushgateway_password": true,
+	"sentry.dsn":                   true,
+	"clickhouse_targets.password":  true,
+}
+
+// secretMapConfigKeys - all not empty values of mapping are replaced by maskedConfigValue, keys are printed as is
+var secretMapConfigKeys = map[string]bool{
+	"tracing.headers": true,
+}
+
+// urlConfigKeys - userinfo of URL values is stripped in `print-config`, values of mappings are checked one by one
+var urlConfigKeys = map[string]bool{
+	"metrics.pushgateway_url":     true,
+	"tracing.endpoint":            true,
+	"clickhouse.settings":         true,
+	"clickhouse_targets.settings": true,
+}
+
+// ValueSource - where effective value of `section.key` comes from: `default`, config file path, `env <NAME>` or `--set`
+func (cfg *Config) ValueSource(key string) string {
+	if source, exists := cfg.sources[key]; exists {
+		return source
+	}
+	return "default"
+}
+
+// setSource - merged mappings keep all sources, other values keep only last source
+func (cfg *Config) setSource(key, source string, merged bool) {
+	if cfg.sources == nil {
+		cfg.sources = map[string]string{}
+	}
+	if previous, exists := cfg.sources[key]; exists && merged && previous != source {
+		source = previous + ", " + source
+	}
+	cfg.sources[key] = source
+}
+
+// setFileSources - keys defined in config file, `clickhouse_targets` keys are tracked per target
+func (cfg *Config) setFileSources(configLocation string, configYaml []byte) {
+	sections := map[string]interface{}{}
+	if err := yaml.Unmarshal(configYaml, &sections); err != nil {
+		return
+	}
+	for section, values := range sections {
+		keys, ok := values.(map[interface{}]interface{})
+		if !ok || section == "include" {
+			continue
+		}
+		for key, value := range keys {
+			if section == "clickhouse_targets" {
+				if targetKeys, ok := value.(map[interface{}]interface{}); ok {
+					for targetKey := range targetKeys {
+						cfg.setSource(fmt.Sprintf("%s.%v.%v", section, key, targetKey), configLocation, false)
+					}
+				}
+				continue
+			}
+			_, merged := value.(map[interface{}]interface{})
+			cfg.setSource(fmt.Sprintf("%s.%v", section, key), configLocation, merged)
+		}
+	}
+}
+
+// setEnvSources - envconfig.Process replaces value when variable is defined even with empty value
+func (cfg *Config) setEnvSources() {
+	configType := reflect.TypeOf(*cfg)
+	for i := 0; i < configType.NumField(); i++ {
+		section := configType.Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			envName := field.Tag.Get("envconfig")
+			if envName == "" {
+				continue
+			}
+			if _, exists := os.LookupEnv(envVariableName(cfg.options.EnvPrefix, envName)); exists {
+				cfg.setSource(yamlKey(section)+"."+yamlKey(field), "env "+envVariableName(cfg.options.EnvPrefix, envName), false)
+			}
+		}
+	}
+}
+
+func yamlKey(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+// maskedConfig - copy of config with not empty secrets replaced, sections are copied by value, mappings and `clickhouse_targets` maps are copied before replace
+func maskedConfig(cfg *Config) *Config {
+	masked := *cfg
+	value := reflect.ValueOf(&masked).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		section := value.Type().Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := value.Field(i).Field(j)
+			key := yamlKey(section) + "." + yamlKey(section.Type.Field(j))
+			switch {
+			case field.Kind() == reflect.String && field.String() != "" && secretConfigKeys[key]:
+				field.SetString(maskedConfigValue)
+			case field.Kind() == reflect.String && urlConfigKeys[key]:
+				field.SetString(stripURLUserinfo(field.String()))
+			case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.String && (secretMapConfigKeys[key] || urlConfigKeys[key]) && field.Len() > 0:
+				maskedMap := reflect.MakeMapWithSize(field.Type(), field.Len())
+				for iter := field.MapRange(); iter.Next(); {
+					mapValue := iter.Value().String()
+					if secretMapConfigKeys[key] && mapValue != "" {
+						mapValue = maskedConfigValue
+					} else if urlConfigKeys[key] {
+						mapValue = stripURLUserinfo(mapValue)
+					}
+					maskedMap.SetMapIndex(iter.Key(), reflect.ValueOf(mapValue).Convert(field.Type().Elem()))
+				}
+				field.Set(maskedMap)
+			}
+		}
+	}
+	if len(cfg.ClickHouseTargets) > 0 {
+		masked.ClickHouseTargets = make(map[string]map[string]interface{}, len(cfg.ClickHouseTargets))
+		for name, target := range cfg.ClickHouseTargets {
+			maskedTarget := make(map[string]interface{}, len(target))
+			for key, targetValue := range target {
+				if secretConfigKeys["clickhouse_targets."+key] && fmt.Sprint(targetValue) != "" {
+					targetValue = maskedConfigValue
+				} else if settings, isMap := targetValue.(map[interface{}]interface{}); isMap && urlConfigKeys["clickhouse_targets."+key] {
+					maskedSettings := make(map[interface{}]interface{}, len(settings))
+					for settingKey, settingValue := range settings {
+						if settingString, isString := settingValue.(string); isString {
+							settingValue = stripURLUserinfo(settingString)
+						}
+						maskedSettings[settingKey] = settingValue
+					}
+					targetValue = maskedSettings
+				}
+				maskedTarget[key] = targetValue
+			}
+			masked.ClickHouseTargets[name] = maskedTarget
+		}
+	}
+	return &masked
+}
+
+// stripURLUserinfo - `user:password@` of URL is removed, `host:port` endpoints without scheme are parsed as `//host:port`, other values are returned as is
+func stripURLUserinfo(value string) string {
+	withoutScheme := !strings.Contains(value, "://")
+	parsed, err := url.Parse(value)
+	if withoutScheme {
+		parsed, err = url.Parse("//" + value)
+	}
+	if err != nil || parsed.User == nil {
+		return value
+	}
+	parsed.User = nil
+	if withoutScheme {
+		return strings.TrimPrefix(parsed.String(), "//")
+	}
+	return parsed.String()
+}
+
+var configLineKeyRE = regexp.MustCompile(`^( *)([^\s:#-][^:]*):( |$)`)
+
+// printEffectiveConfig - YAML of config with masked secrets, every key of sections and `clickhouse_targets` is followed by comment with source of its value
+func printEffectiveConfig(out io.Writer, cfg *Config) error {
+	body, err := yaml.Marshal(maskedConfig(cfg))
+	if err != nil {
+		return err
+	}
+	var path []string
+	blockIndent := -1
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		// lines of multiline string value are not keys
+		if blockIndent >= 0 && indent > blockIndent {
+			if _, err := fmt.Fprintln(out, line); err != nil {
+				return err
+			}
+			continue
+		}
+		blockIndent = -1
+		if match := configLineKeyRE.FindStringSubmatch(line); match != nil {
+			depth := len(match[1]) / 2
+			if value := strings.TrimSpace(line[len(match[0]):]); strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+				blockIndent = indent
+			}
+			if depth <= len(path) {
+				path = append(path[:depth], match[2])
+				if len(path) == 2 && path[0] != "clickhouse_targets" || len(path) == 3 && path[0] == "clickhouse_targets" {
+					line += "  # " + cfg.ValueSource(strings.Join(path, "."))
+				}
+			}
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow - daily interval of local time, From after To means interval across midnight
+type TimeWindow struct {
+	From time.Duration
+	To   time.Duration
+}
+
+// Contains - t is inside window, To is exclusive
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.From <= w.To {
+		return offset >= w.From && offset < w.To
+	}
+	return offset >= w.From || offset < w.To
+}
+
+// ParseThrottleSchedule - parse `throttle_schedule` items in `HH:MM-HH:MM` format
+func ParseThrottleSchedule(schedule []string) ([]TimeWindow, error) {
+	windows := make([]TimeWindow, 0, len(schedule))
+	for _, item := range schedule {
+		from, to, found := strings.Cut(strings.TrimSpace(item), "-")
+		if !found {
+			return nil, fmt.Errorf("'%s' is bad throttle_schedule item, expected format HH:MM-HH:MM", item)
+		}
+		var window TimeWindow
+		var err error
+		if window.From, err = parseDayTime(from); err != nil {
+			return nil, fmt.Errorf("'%s' is bad throttle_schedule item: %v", item, err)
+		}
+		if window.To, err = parseDayTime(to); err != nil {
+			return nil, fmt.Errorf("'%s' is bad throttle_schedule item: %v", item, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func parseDayTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,78 @@
+package errtracker
+
+import (
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/getsentry/sentry-go"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+var (
+	initMutex   sync.Mutex
+	initialized bool
+)
+
+// Init - setup global Sentry client when sentry.dsn defined
+// returned function flush pending events, only first successful Init in process setup client, next calls return no-op
+func Init(cfg *config.SentryConfig, version string) (func(), error) {
+	noop := func() {}
+	if cfg.DSN == "" {
+		return noop, nil
+	}
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	if initialized {
+		return noop, nil
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return noop, err
+	}
+	err = sentry.Init(sentry.ClientOptions{
+		Dsn:              cfg.DSN,
+		Environment:      cfg.Environment,
+		Release:          "clickhouse-backup@" + version,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return noop, err
+	}
+	for k, v := range cfg.Tags {
+		sentry.CurrentHub().Scope().SetTag(k, v)
+	}
+	initialized = true
+	return func() {
+		if !sentry.Flush(timeout) {
+			apexLog.Warnf("can't flush sentry events during %s", cfg.Timeout)
+		}
+		initMutex.Lock()
+		sentry.CurrentHub().BindClient(nil)
+		initialized = false
+		initMutex.Unlock()
+	}, nil
+}
+
+// Capture - report failed command with backup name and remote storage type, do nothing when err is nil or Sentry not initialized
+func Capture(cfg *config.Config, command, backupName string, err error) {
+	if err == nil {
+		return
+	}
+	initMutex.Lock()
+	enabled := initialized
+	initMutex.Unlock()
+	if !enabled {
+		return
+	}
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag("command", command)
+		scope.SetTag("remote_storage", cfg.General.RemoteStorage)
+		if backupName != "" {
+			scope.SetTag("backup_name", backupName)
+		}
+		scope.SetFingerprint([]string{command, "{{ default }}"})
+	})
+	hub.CaptureException(err)
+}
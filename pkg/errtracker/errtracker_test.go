@@ -0,0 +1,51 @@
+package errtracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	events := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		event := map[string]interface{}{}
+		if err := json.Unmarshal(body, &event); err == nil {
+			events <- event
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	cfg.Sentry.DSN = fmt.Sprintf("http://public@%s/1", strings.TrimPrefix(srv.URL, "http://"))
+	cfg.Sentry.Tags = map[string]string{"cluster": "prod"}
+
+	Capture(cfg, "upload", "b1", fmt.Errorf("not initialized"))
+	flush, err := Init(&cfg.Sentry, "test")
+	require.NoError(t, err)
+	Capture(cfg, "upload", "b1", nil)
+	Capture(cfg, "upload", "b1", fmt.Errorf("can't upload: connection reset"))
+	flush()
+
+	require.Len(t, events, 1)
+	event := <-events
+	tags := event["tags"].(map[string]interface{})
+	assert.Equal(t, "upload", tags["command"])
+	assert.Equal(t, "b1", tags["backup_name"])
+	assert.Equal(t, "s3", tags["remote_storage"])
+	assert.Equal(t, "prod", tags["cluster"])
+	assert.Equal(t, "clickhouse-backup@test", event["release"])
+	exception := event["exception"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "can't upload: connection reset", exception["value"])
+	assert.NotNil(t, exception["stacktrace"])
+}
@@ -1,11 +1,14 @@
 package filesystemhelper
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"github.com/mxalis/clickhouse-backup/pkg/utils"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
@@ -103,7 +106,7 @@ func MkdirAll(path string, ch *clickhouse.ClickHouse, disks []clickhouse.Disk) e
 }
 
 // CopyDataToDetached - copy partitions for specific table to detached folder
-func CopyDataToDetached(backupName string, backupTable metadata.TableMetadata, disks []clickhouse.Disk, tableDataPaths []string, ch *clickhouse.ClickHouse) error {
+func CopyDataToDetached(backupName string, backupTable metadata.TableMetadata, disks []clickhouse.Disk, tableDataPaths []string, ch *clickhouse.ClickHouse, linkMode string) error {
 	// TODO: check when disk exists in backup, but miss in ClickHouse
 	dstDataPaths := clickhouse.GetDisksByPaths(disks, tableDataPaths)
 	log := apexLog.WithFields(apexLog.Fields{"operation": "CopyDataToDetached"})
@@ -113,7 +116,14 @@ func CopyDataToDetached(backupName string, backupTable metadata.TableMetadata, d
 			log.Debugf("%s disk have no parts", backupDisk.Name)
 			continue
 		}
-		detachedParentDir := filepath.Join(dstDataPaths[backupDisk.Name], "detached")
+		dstDataPath, dstDisk, exists := clickhouse.GetDataPathForDisk(dstDataPaths, backupDisk.Name)
+		if !exists {
+			return fmt.Errorf("can't find data path of '%s.%s' for parts from disk '%s'", backupTable.Database, backupTable.Table, backupDisk.Name)
+		}
+		if dstDisk != backupDisk.Name {
+			log.Warnf("storage policy of '%s.%s' doesn't contain disk '%s', parts will restore to disk '%s'", backupTable.Database, backupTable.Table, backupDisk.Name, dstDisk)
+		}
+		detachedParentDir := filepath.Join(dstDataPath, "detached")
 		for _, part := range backupTable.Parts[backupDisk.Name] {
 			detachedPath := filepath.Join(detachedParentDir, part.Name)
 			info, err := os.Stat(detachedPath)
@@ -150,10 +160,9 @@ func CopyDataToDetached(backupName string, backupTable metadata.TableMetadata, d
 					return nil
 				}
 				log.Debugf("Link %s -> %s", filePath, dstFilePath)
-				if err := os.Link(filePath, dstFilePath); err != nil {
-					if !os.IsExist(err) {
-						return fmt.Errorf("failed to create hard link '%s' -> '%s': %w", filePath, dstFilePath, err)
-					}
+				// parts restored to another disk could be placed on another filesystem
+				if err := LinkFile(filePath, dstFilePath, linkMode); err != nil && !os.IsExist(err) {
+					return err
 				}
 				return Chown(dstFilePath, ch, disks)
 			}); err != nil {
@@ -161,18 +170,90 @@ func CopyDataToDetached(backupName string, backupTable metadata.TableMetadata, d
 			}
 		}
 	}
-	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Debugf("done")
+	log.WithField("duration", utils.LogDuration(time.Since(start))).Debugf("done")
 	return nil
 }
 
+// LinkFile - create dst from src according to `link_mode`, `auto` make hard link and copy when dst is placed on another filesystem,
+// `hardlink` fail on cross-device link, `copy` always copy
+func LinkFile(src, dst, linkMode string) error {
+	if linkMode != "copy" {
+		err := os.Link(src, dst)
+		if err == nil || os.IsExist(err) {
+			return err
+		}
+		if !errors.Is(err, syscall.EXDEV) || linkMode == "hardlink" {
+			return fmt.Errorf("failed to create hard link '%s' -> '%s', use link_mode: auto or copy when backup folder is placed on another filesystem: %w", src, dst, err)
+		}
+	}
+	if err := CopyFile(src, dst); err != nil {
+		return fmt.Errorf("failed to copy '%s' -> '%s': %w", src, dst, err)
+	}
+	return nil
+}
+
+// MoveFile - rename src to dst, when `link_mode` is `copy` or `auto` and dst is placed on another filesystem, src is copied and removed
+func MoveFile(src, dst, linkMode string) error {
+	if linkMode != "copy" {
+		err := os.Rename(src, dst)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, syscall.EXDEV) || linkMode == "hardlink" {
+			return fmt.Errorf("failed to move '%s' -> '%s', use link_mode: auto or copy when backup folder is placed on another filesystem: %w", src, dst, err)
+		}
+	}
+	if err := CopyFile(src, dst); err != nil {
+		return fmt.Errorf("failed to copy '%s' -> '%s': %w", src, dst, err)
+	}
+	return os.Remove(src)
+}
+
+// ficlone - FICLONE ioctl, on linux it shares data blocks of src with dst on btrfs and xfs with reflink=1
+const ficlone = 0x40049409
+
+// reflink - clone content of src into dst without copy of data, return error when filesystem or OS doesn't support it
+func reflink(dst, src *os.File) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd()); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// CopyFile - copy content of regular file, dst is truncated when exists, reflink is used when filesystem support it
+func CopyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := srcFile.Close(); err != nil {
+			apexLog.Warnf("can't close %s: %v", src, err)
+		}
+	}()
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if reflink(dstFile, srcFile) == nil {
+		return dstFile.Close()
+	}
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
+}
+
 func IsPartInPartition(partName string, partitionsBackupMap common.EmptyMap) bool {
 	_, ok := partitionsBackupMap[strings.Split(partName, "_")[0]]
 	return ok
 }
 
-func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.EmptyMap) ([]metadata.Part, int64, error) {
+func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.EmptyMap, linkMode string) ([]metadata.Part, int64, error) {
 	size := int64(0)
 	parts := []metadata.Part{}
+	partIndex := map[string]int{}
 	err := filepath.Walk(shadowPath, func(filePath string, info os.FileInfo, err error) error {
 		relativePath := strings.Trim(strings.TrimPrefix(filePath, shadowPath), "/")
 		pathParts := strings.SplitN(relativePath, "/", 4)
@@ -186,6 +267,14 @@ func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.E
 		}
 		dstFilePath := filepath.Join(backupPartsPath, pathParts[3])
 		if info.IsDir() {
+			// projections are directories inside part, they are stored in part metadata instead of separate part
+			if partName, projection, isNested := strings.Cut(pathParts[3], "/"); isNested {
+				if i, exists := partIndex[partName]; exists && strings.HasSuffix(projection, ".proj") && !strings.Contains(projection, "/") {
+					parts[i].Projections = append(parts[i].Projections, strings.TrimSuffix(projection, ".proj"))
+				}
+				return os.MkdirAll(dstFilePath, 0750)
+			}
+			partIndex[pathParts[3]] = len(parts)
 			parts = append(parts, metadata.Part{
 				Name: pathParts[3],
 			})
@@ -196,7 +285,7 @@ func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.E
 			return nil
 		}
 		size += info.Size()
-		return os.Rename(filePath, dstFilePath)
+		return MoveFile(filePath, dstFilePath, linkMode)
 	})
 	return parts, size, err
 }
@@ -247,6 +336,7 @@ func IsDuplicatedParts(part1, part2 string) error {
 	return nil
 }
 
+// CreatePartitionsToBackupMap - partition IDs from --partitions arguments, `db.table:id1,id2` argument applies IDs only to tables matched by `db.table` pattern, such IDs are stored as `db.table:id` keys
 func CreatePartitionsToBackupMap(partitions []string) common.EmptyMap {
 	if len(partitions) == 0 {
 		return make(common.EmptyMap, 0)
@@ -254,10 +344,36 @@ func CreatePartitionsToBackupMap(partitions []string) common.EmptyMap {
 		partitionsMap := common.EmptyMap{}
 		// to avoid use --partitions val1 --partitions val2, https://github.com/mxalis/clickhouse-backup/issues/425#issuecomment-1149855063
 		for _, partitionArg := range partitions {
+			tablePrefix := ""
+			if match := tablePartitionsRE.FindStringSubmatch(strings.Trim(partitionArg, " ")); match != nil {
+				tablePrefix = match[1] + ":"
+				partitionArg = match[2]
+			}
 			for _, partition := range strings.Split(partitionArg, ",") {
-				partitionsMap[strings.Trim(partition, " ")] = struct{}{}
+				if partition = strings.Trim(partition, " "); partition != "" {
+					partitionsMap[tablePrefix+partition] = struct{}{}
+				}
 			}
 		}
 		return partitionsMap
 	}
 }
+
+var tablePartitionsRE = regexp.MustCompile(`^([^:,\s]+\.[^:,\s]+):(.*)$`)
+
+// GetPartitionsFilterForTable - partition IDs from CreatePartitionsToBackupMap which apply to database.table, empty result means all partitions
+func GetPartitionsFilterForTable(partitionsMap common.EmptyMap, database, table string) common.EmptyMap {
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	result := common.EmptyMap{}
+	for partition := range partitionsMap {
+		i := strings.LastIndex(partition, ":")
+		if i < 0 {
+			result[partition] = struct{}{}
+			continue
+		}
+		if matched, _ := filepath.Match(partition[:i], tableName); matched {
+			result[partition[i+1:]] = struct{}{}
+		}
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package filesystemhelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/clickhouse"
+	"github.com/mxalis/clickhouse-backup/pkg/common"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPartitionsFilterForTable(t *testing.T) {
+	partitionsMap := CreatePartitionsToBackupMap([]string{"202301, 202302", "db.t1:202212,202211", "db.t*:all"})
+	assert.Equal(t, common.EmptyMap{"202301": {}, "202302": {}, "db.t1:202212": {}, "db.t1:202211": {}, "db.t*:all": {}}, partitionsMap)
+	assert.Equal(t, common.EmptyMap{"202301": {}, "202302": {}, "202212": {}, "202211": {}, "all": {}}, GetPartitionsFilterForTable(partitionsMap, "db", "t1"))
+	assert.Equal(t, common.EmptyMap{"202301": {}, "202302": {}, "all": {}}, GetPartitionsFilterForTable(partitionsMap, "db", "t2"))
+	assert.Equal(t, common.EmptyMap{"202301": {}, "202302": {}}, GetPartitionsFilterForTable(partitionsMap, "other", "t1"))
+
+	partitionsMap = CreatePartitionsToBackupMap([]string{"db.t1:202212"})
+	assert.Empty(t, GetPartitionsFilterForTable(partitionsMap, "db", "t2"))
+	assert.Empty(t, GetPartitionsFilterForTable(CreatePartitionsToBackupMap(nil), "db", "t1"))
+}
+
+func TestMoveShadowProjections(t *testing.T) {
+	shadowPath := t.TempDir()
+	backupPath := t.TempDir()
+	partPath := filepath.Join(shadowPath, "store", "1f9", "1f9dc899-0de9-41f8-b95c-26c1f0d67d93", "all_1_1_0")
+	for _, dir := range []string{partPath, filepath.Join(partPath, "by_name.proj"), filepath.Join(partPath, "agg.proj"), filepath.Join(shadowPath, "store", "1f9", "1f9dc899-0de9-41f8-b95c-26c1f0d67d93", "all_2_2_0")} {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(partPath, "checksums.txt"), []byte("1234"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(partPath, "by_name.proj", "checksums.txt"), []byte("12"), 0644))
+
+	parts, size, err := MoveShadow(shadowPath, backupPath, nil, "auto")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), size)
+	assert.Equal(t, []metadata.Part{{Name: "all_1_1_0", Projections: []string{"agg", "by_name"}}, {Name: "all_2_2_0"}}, parts)
+	assert.FileExists(t, filepath.Join(backupPath, "all_1_1_0", "by_name.proj", "checksums.txt"))
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	require.NoError(t, ioutil.WriteFile(src, []byte("part data"), 0640))
+	dst := filepath.Join(dir, "detached", "dst.bin")
+	require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0755))
+	require.NoError(t, CopyFile(src, dst))
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "part data", string(data))
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestGetDataPathForDisk(t *testing.T) {
+	dataPaths := map[string]string{"default": "/var/lib/clickhouse/data/db/t", "hdd": "/hdd/data/db/t"}
+	dataPath, disk, exists := clickhouse.GetDataPathForDisk(dataPaths, "hdd")
+	assert.True(t, exists)
+	assert.Equal(t, "hdd", disk)
+	assert.Equal(t, "/hdd/data/db/t", dataPath)
+
+	_, disk, exists = clickhouse.GetDataPathForDisk(dataPaths, "s3")
+	assert.True(t, exists)
+	assert.Equal(t, "default", disk)
+
+	_, disk, exists = clickhouse.GetDataPathForDisk(map[string]string{"ssd": "/ssd/data/db/t", "hdd": "/hdd/data/db/t"}, "s3")
+	assert.True(t, exists)
+	assert.Equal(t, "hdd", disk)
+
+	_, _, exists = clickhouse.GetDataPathForDisk(nil, "default")
+	assert.False(t, exists)
+}
+
+func TestLinkFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	require.NoError(t, ioutil.WriteFile(src, []byte("part data"), 0640))
+	srcInfo, err := os.Stat(src)
+	require.NoError(t, err)
+
+	for _, linkMode := range []string{"auto", "hardlink"} {
+		dst := filepath.Join(dir, linkMode+".bin")
+		require.NoError(t, LinkFile(src, dst, linkMode))
+		dstInfo, err := os.Stat(dst)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(srcInfo, dstInfo), linkMode)
+	}
+
+	dst := filepath.Join(dir, "copy.bin")
+	require.NoError(t, LinkFile(src, dst, "copy"))
+	dstInfo, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(srcInfo, dstInfo))
+	content, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "part data", string(content))
+
+	moved := filepath.Join(dir, "moved.bin")
+	require.NoError(t, MoveFile(dst, moved, "copy"))
+	assert.NoFileExists(t, dst)
+	assert.FileExists(t, moved)
+}
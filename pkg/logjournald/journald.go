@@ -0,0 +1,92 @@
+// Package logjournald implements a handler which send entries to systemd-journald over native journal protocol.
+package logjournald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/logsyslog"
+)
+
+// DefaultSocket - journald native protocol socket
+const DefaultSocket = "/run/systemd/journal/socket"
+
+// Handler implementation.
+type Handler struct {
+	mu         sync.Mutex
+	identifier string
+	conn       net.Conn
+}
+
+// New handler.
+func New(socket, identifier string) (*Handler, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to journald %s: %v", socket, err)
+	}
+	return &Handler{
+		identifier: identifier,
+		conn:       conn,
+	}, nil
+}
+
+// HandleLog implements log.Handler, each field is sent as separate journal field with upper case name.
+func (h *Handler) HandleLog(e *log.Entry) error {
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", e.Message)
+	writeField(&buf, "PRIORITY", fmt.Sprint(logsyslog.Severity(e.Level)))
+	writeField(&buf, "SYSLOG_IDENTIFIER", h.identifier)
+	for _, name := range e.Fields.Names() {
+		key := fieldName(name)
+		if key == "" {
+			continue
+		}
+		writeField(&buf, key, fmt.Sprint(e.Fields.Get(name)))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+// Close journald connection.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.conn.Close()
+}
+
+// writeField - KEY=value, multi line values use KEY\n<uint64 little endian length>value\n
+func writeField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// fieldName - journal field names allow only upper case letters, digits and underscore and can't start with underscore
+func fieldName(name string) string {
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.TrimLeft(key, "_0123456789")
+}
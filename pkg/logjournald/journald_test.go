@@ -0,0 +1,40 @@
+package logjournald_test
+
+import (
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/logjournald"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/apex/log"
+)
+
+func TestJournald(t *testing.T) {
+	socket := path.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenPacket("unixgram", socket)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	h, err := logjournald.New(socket, "clickhouse-backup")
+	require.NoError(t, err)
+	defer h.Close()
+	log.SetHandler(h)
+
+	log.WithField("backup_name", "b1").WithField("_hidden", "x").Warn("multi\nline")
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	expected := "MESSAGE\n\x0a\x00\x00\x00\x00\x00\x00\x00multi\nline\n" +
+		"PRIORITY=4\n" +
+		"SYSLOG_IDENTIFIER=clickhouse-backup\n" +
+		"HIDDEN=x\n" +
+		"BACKUP_NAME=b1\n"
+	assert.Equal(t, expected, string(buf[:n]))
+}
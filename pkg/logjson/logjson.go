@@ -0,0 +1,67 @@
+// Package logjson implements a JSON lines format handler.
+package logjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Default handler outputting to stderr.
+var Default = New(os.Stderr)
+
+// Handler implementation.
+type Handler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New handler.
+func New(w io.Writer) *Handler {
+	return &Handler{
+		w: w,
+	}
+}
+
+// HandleLog implements log.Handler, each entry is written as one flat JSON object per line.
+func (h *Handler) HandleLog(e *log.Entry) error {
+	names := e.Fields.Names()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeField(&buf, "ts", e.Timestamp.Format(time.RFC3339Nano), true)
+	writeField(&buf, "level", e.Level.String(), false)
+	writeField(&buf, "msg", e.Message, false)
+	for _, name := range names {
+		writeField(&buf, name, e.Fields.Get(name), false)
+	}
+	buf.WriteString("}\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func writeField(buf *bytes.Buffer, name string, value interface{}, first bool) {
+	if !first {
+		buf.WriteByte(',')
+	}
+	key, _ := json.Marshal(name)
+	buf.Write(key)
+	buf.WriteByte(':')
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encoded)
+}
@@ -0,0 +1,51 @@
+package logjson_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/logjson"
+	"github.com/mxalis/clickhouse-backup/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apex/log"
+)
+
+func init() {
+	log.Now = func() time.Time {
+		return time.Unix(0, 0).UTC()
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	log.SetHandler(logjson.New(&buf))
+	log.WithFields(log.Fields{
+		"backup_name": "b1",
+		"table":       "default.t1",
+		"operation":   "upload",
+		"duration":    utils.LogDuration(1500 * time.Millisecond),
+		"size":        utils.LogBytes(1024),
+	}).Info("done")
+	log.WithError(errors.New("boom")).Error("failed")
+
+	expected := `{"ts":"1970-01-01T00:00:00Z","level":"info","msg":"done","backup_name":"b1","duration":1.5,"operation":"upload","size":1024,"table":"default.t1"}
+{"ts":"1970-01-01T00:00:00Z","level":"error","msg":"failed","error":"boom"}
+`
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func Benchmark(b *testing.B) {
+	log.SetHandler(logjson.New(ioutil.Discard))
+	ctx := log.WithField("user", "tj").WithField("id", "123")
+
+	for i := 0; i < b.N; i++ {
+		ctx.Info("hello")
+	}
+}
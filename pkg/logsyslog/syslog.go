@@ -0,0 +1,165 @@
+// Package logsyslog implements a RFC5424 syslog handler, messages can be sent over UDP, TCP or unix sockets.
+package logsyslog
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/go-logfmt/logfmt"
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// ParseFacility - return syslog facility code by name
+func ParseFacility(name string) (int, error) {
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("'%s' is unknown syslog facility", name)
+	}
+	return facility, nil
+}
+
+// Severity - map log level to syslog severity
+func Severity(level log.Level) int {
+	switch level {
+	case log.DebugLevel:
+		return 7
+	case log.InfoLevel:
+		return 6
+	case log.WarnLevel:
+		return 4
+	case log.ErrorLevel:
+		return 3
+	case log.FatalLevel:
+		return 2
+	}
+	return 5
+}
+
+// Handler implementation.
+type Handler struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	pid      string
+	conn     net.Conn
+}
+
+// New handler, network could be udp, tcp, unix (stream) or unixgram, stream connections use octet counting framing from RFC6587.
+func New(network, address, facility, tag string) (*Handler, error) {
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("'%s' is unknown syslog network, allowed values udp, tcp, unix, unixgram", network)
+	}
+	facilityCode, err := ParseFacility(facility)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	h := &Handler{
+		network:  network,
+		address:  address,
+		facility: facilityCode,
+		tag:      tag,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	if h.conn, err = net.Dial(network, address); err != nil {
+		return nil, fmt.Errorf("can't connect to syslog %s://%s: %v", network, address, err)
+	}
+	return h, nil
+}
+
+// HandleLog implements log.Handler.
+func (h *Handler) HandleLog(e *log.Entry) error {
+	msg := h.format(e)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.write(msg)
+	if err != nil && h.isStream() {
+		// reconnect once, syslog daemon could be restarted
+		_ = h.conn.Close()
+		if h.conn, err = net.Dial(h.network, h.address); err != nil {
+			return err
+		}
+		err = h.write(msg)
+	}
+	return err
+}
+
+// Close syslog connection.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.conn.Close()
+}
+
+func (h *Handler) isStream() bool {
+	return h.network == "tcp" || h.network == "unix"
+}
+
+func (h *Handler) write(msg []byte) error {
+	if h.isStream() {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	_, err := h.conn.Write(msg)
+	return err
+}
+
+// format - <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG, fields are appended to MSG as logfmt pairs
+func (h *Handler) format(e *log.Entry) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(
+		&buf, "<%d>1 %s %s %s %s - - ",
+		h.facility*8+Severity(e.Level), e.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"), h.hostname, h.tag, h.pid,
+	)
+	buf.WriteString(e.Message)
+	names := e.Fields.Names()
+	if len(names) > 0 {
+		keyvals := make([]interface{}, 0, len(names)*2)
+		for _, name := range names {
+			keyvals = append(keyvals, name, e.Fields.Get(name))
+		}
+		if fields, err := logfmt.MarshalKeyvals(keyvals...); err == nil {
+			buf.WriteByte(' ')
+			buf.Write(fields)
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,94 @@
+package logsyslog_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/logsyslog"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/apex/log"
+)
+
+func init() {
+	log.Now = func() time.Time {
+		return time.Unix(0, 0).UTC()
+	}
+}
+
+func expectedPrefix(pri int) string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("<%d>1 1970-01-01T00:00:00.000000Z %s clickhouse-backup %d - - ", pri, hostname, os.Getpid())
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	h, err := logsyslog.New("udp", conn.LocalAddr().String(), "local0", "clickhouse-backup")
+	require.NoError(t, err)
+	defer h.Close()
+	log.SetHandler(h)
+
+	log.WithField("backup", "b1").WithField("table", "default.t1").Info("done")
+	log.Error("boom")
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, expectedPrefix(16*8+6)+"done backup=b1 table=default.t1", string(buf[:n]))
+	n, _, err = conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, expectedPrefix(16*8+3)+"boom", string(buf[:n]))
+}
+
+func TestSyslogTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var size int
+		r := bufio.NewReader(conn)
+		if _, err := fmt.Fscanf(r, "%d ", &size); err != nil {
+			return
+		}
+		msg := make([]byte, size)
+		if _, err := r.Read(msg); err == nil {
+			received <- string(msg)
+		}
+	}()
+
+	h, err := logsyslog.New("tcp", listener.Addr().String(), "daemon", "clickhouse-backup")
+	require.NoError(t, err)
+	defer h.Close()
+	log.SetHandler(h)
+	log.Warn("disk is full")
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, expectedPrefix(3*8+4)+"disk is full", msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("syslog message not received")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := logsyslog.New("http", "127.0.0.1:514", "daemon", "clickhouse-backup")
+	assert.Error(t, err)
+	_, err = logsyslog.New("udp", "127.0.0.1:514", "unknown", "clickhouse-backup")
+	assert.Error(t, err)
+}
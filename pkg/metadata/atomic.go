@@ -0,0 +1,54 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// WriteFileAtomic - body is written to temporary file in the same folder, synced and renamed to location,
+// so after crash location contains previous or new content, but never truncated JSON
+func WriteFileAtomic(location string, body []byte, perm os.FileMode) error {
+	dir := path.Dir(location)
+	tmp, err := ioutil.TempFile(dir, "."+path.Base(location)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	removeTmp := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if _, err = tmp.Write(body); err != nil {
+		return removeTmp(err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return removeTmp(err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return removeTmp(err)
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err = os.Rename(tmpName, location); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir - rename is durable only after fsync of parent folder
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -10,22 +10,58 @@ type TableTitle struct {
 }
 
 type BackupMetadata struct {
-	BackupName              string            `json:"backup_name"`
-	Disks                   map[string]string `json:"disks"` // "default": "/var/lib/clickhouse"
-	ClickhouseBackupVersion string            `json:"version"`
-	CreationDate            time.Time         `json:"creation_date"`
-	Tags                    string            `json:"tags,omitempty"` // "type=manual", "type=sheduled", "hostname": "", "shard="
-	ClickHouseVersion       string            `json:"clickhouse_version,omitempty"`
-	DataSize                uint64            `json:"data_size,omitempty"`
-	MetadataSize            uint64            `json:"metadata_size"`
-	RBACSize                uint64            `json:"rbac_size,omitempty"`
-	ConfigSize              uint64            `json:"config_size,omitempty"`
-	CompressedSize          uint64            `json:"compressed_size,omitempty"`
-	Databases               []DatabasesMeta   `json:"databases,omitempty"`
-	Tables                  []TableTitle      `json:"tables"`
-	Functions               []FunctionsMeta   `json:"functions"`
-	DataFormat              string            `json:"data_format"`
-	RequiredBackup          string            `json:"required_backup,omitempty"`
+	MetadataVersion         int                 `json:"metadata_version,omitempty"` // see MetadataVersion, 0 for backups created before versioning
+	BackupName              string              `json:"backup_name"`
+	Disks                   map[string]string   `json:"disks"`                // "default": "/var/lib/clickhouse"
+	DiskTypes               map[string]string   `json:"disk_types,omitempty"` // "default": "local", "s3": "s3"
+	ClickhouseBackupVersion string              `json:"version"`
+	CreationDate            time.Time           `json:"creation_date"`
+	Tags                    string              `json:"tags,omitempty"`        // "env=prod,type=manual", see ParseTags
+	Description             string              `json:"description,omitempty"` // free-form text of `create --description`
+	ClickHouseVersion       string              `json:"clickhouse_version,omitempty"`
+	DataSize                uint64              `json:"data_size,omitempty"`
+	MetadataSize            uint64              `json:"metadata_size"`
+	RBACSize                uint64              `json:"rbac_size,omitempty"`
+	ConfigSize              uint64              `json:"config_size,omitempty"`
+	NativeSize              uint64              `json:"native_size,omitempty"` // size of `native` folder written by BACKUP statement, compressed size on remote storage
+	CompressedSize          uint64              `json:"compressed_size,omitempty"`
+	Databases               []DatabasesMeta     `json:"databases,omitempty"`
+	Tables                  []TableTitle        `json:"tables"`
+	Functions               []FunctionsMeta     `json:"functions"`
+	DataFormat              string              `json:"data_format"`
+	RequiredBackup          string              `json:"required_backup,omitempty"`
+	Macros                  map[string]string   `json:"macros,omitempty"`           // system.macros of backup host, "shard": "01", "replica": "ch-1"
+	StoragePolicies         map[string][]string `json:"storage_policies,omitempty"` // disks of storage policies of backup host, "hot_and_cold": ["default", "s3"]
+	Cluster                 *ClusterBackup      `json:"cluster,omitempty"`          // manifest of `create_cluster`, backup doesn't contain data, each shard is separate backup
+	Environment             *BackupEnvironment  `json:"environment,omitempty"`      // hosts and settings which produced backup, versions, macros and disks are stored in fields above
+	TablesManifest          string              `json:"tables_manifest,omitempty"`  // "metadata.tables.json.gz", metadata of all tables in one gzipped object instead of metadata/<db>/<table>.json on remote storage
+	RequiredVersion         string              `json:"required_version,omitempty"` // minimal clickhouse-backup version which can restore backup, see SetRequiredFeatures
+	RequiredFeatures        []string            `json:"required_features,omitempty"`
+}
+
+// BackupEnvironment - snapshot of clickhouse-backup host and clickhouse-server during create
+type BackupEnvironment struct {
+	Hostname          string            `json:"hostname"`                      // host of clickhouse-backup
+	OS                string            `json:"os"`                            // GOOS/GOARCH of clickhouse-backup binary
+	ServerHostname    string            `json:"server_hostname,omitempty"`     // hostName() of clickhouse-server
+	ServerTimezone    string            `json:"server_timezone,omitempty"`     // timezone() of clickhouse-server
+	ServerVersion     int               `json:"server_version,omitempty"`      // VERSION_INTEGER, 22008001 is 22.8.1
+	Settings          map[string]string `json:"settings,omitempty"`            // changed settings of backup user from system.settings
+	MergeTreeSettings map[string]string `json:"merge_tree_settings,omitempty"` // changed settings from system.merge_tree_settings
+}
+
+// ClusterBackup - shards of cluster backup, data of each shard is uploaded by clickhouse-backup of shard host
+type ClusterBackup struct {
+	Cluster string               `json:"cluster"`
+	Shards  []ClusterShardBackup `json:"shards"`
+}
+
+type ClusterShardBackup struct {
+	Shard      uint32 `json:"shard"`
+	Host       string `json:"host"`
+	BackupName string `json:"backup_name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
 }
 
 type DatabasesMeta struct {
@@ -39,6 +75,16 @@ type FunctionsMeta struct {
 	CreateQuery string `json:"create_query"`
 }
 
+// AccessEntity - RBAC object dumped with SHOW CREATE, Grants contain SHOW GRANTS result for users and roles
+type AccessEntity struct {
+	Type        string   `json:"type"` // USER, ROLE, ROW POLICY, QUOTA, SETTINGS PROFILE
+	Name        string   `json:"name"`
+	Storage     string   `json:"storage"`
+	Replicated  bool     `json:"replicated,omitempty"`
+	CreateQuery string   `json:"create_query"`
+	Grants      []string `json:"grants,omitempty"`
+}
+
 type TableMetadata struct {
 	Files map[string][]string `json:"files,omitempty"`
 	// Disks       map[string]string   `json:"disks"` // "default": "/var/lib/clickhouse"
@@ -49,11 +95,29 @@ type TableMetadata struct {
 	Query       string            `json:"query"`
 	// UUID        string            `json:"uuid,omitempty"`
 	// Macros ???
-	Size                 map[string]int64 `json:"size"`                  // how much size on each disk
-	TotalBytes           uint64           `json:"total_bytes,omitempty"` // total table size
-	DependenciesTable    string           `json:"dependencies_table,omitempty"`
-	DependenciesDatabase string           `json:"dependencies_database,omitempty"`
-	MetadataOnly         bool             `json:"metadata_only"`
+	Size                 map[string]int64  `json:"size"`                         // how much size on each disk
+	TotalBytes           uint64            `json:"total_bytes,omitempty"`        // total table size
+	Rows                 uint64            `json:"rows,omitempty"`               // rows of backed up parts from system.parts at freeze time
+	UncompressedBytes    uint64            `json:"uncompressed_bytes,omitempty"` // data_uncompressed_bytes of backed up parts
+	CompressedBytes      uint64            `json:"compressed_bytes,omitempty"`   // data_compressed_bytes of backed up parts
+	DependenciesTable    string            `json:"dependencies_table,omitempty"`
+	DependenciesDatabase string            `json:"dependencies_database,omitempty"`
+	MetadataOnly         bool              `json:"metadata_only"`
+	LogicalBackup        bool              `json:"logical_backup,omitempty"` // data exported with SELECT ... FORMAT Native into `logical` part instead of FREEZE
+	SourceFiles          map[string][]byte `json:"source_files,omitempty"`   // FILE source of dictionary, path from SOURCE clause: content
+	ObjectDisks          []string          `json:"object_disks,omitempty"`   // disks with parts frozen in `zero-copy` mode, part files contain references to objects instead of data
+	NativeBackup         bool              `json:"native_backup,omitempty"`  // data is stored in `native` folder of backup by BACKUP statement
+	Replication          *TableReplication `json:"replication,omitempty"`    // replicated table paths at backup time
+}
+
+// TableReplication - ZooKeeper / Keeper paths of Replicated*MergeTree table, restore tools compare them to detect path collisions
+type TableReplication struct {
+	ZooKeeperPath       string            `json:"zookeeper_path"`                  // expanded by clickhouse-server, "/clickhouse/tables/01/db/t"
+	ReplicaName         string            `json:"replica_name"`                    // expanded by clickhouse-server, "ch-1"
+	ReplicaPath         string            `json:"replica_path,omitempty"`          // "/clickhouse/tables/01/db/t/replicas/ch-1"
+	EngineZooKeeperPath string            `json:"engine_zookeeper_path,omitempty"` // engine argument with macros, "/clickhouse/tables/{shard}/{database}/{table}", empty for default arguments
+	EngineReplicaName   string            `json:"engine_replica_name,omitempty"`   // engine argument with macros, "{replica}"
+	Macros              map[string]string `json:"macros,omitempty"`                // values of macros of engine arguments, shard and replica on backup host, "shard": "01"
 }
 
 type Part struct {
@@ -67,9 +131,19 @@ type Part struct {
 	PartitionID                       string     `json:"partition_id,omitempty"`
 	ModificationTime                  *time.Time `json:"modification_time,omitempty"`
 	Size                              int64      `json:"size,omitempty"`
+	Rows                              uint64     `json:"rows,omitempty"`
+	Projections                       []string   `json:"projections,omitempty"` // names of projections stored inside part
+	// Checksums - size and hash of each part file, path is relative to part directory, "p.proj/data.bin"
+	Checksums map[string]FileChecksum `json:"checksums,omitempty"`
 	// bytes_on_disk, data_compressed_bytes, data_uncompressed_bytes
 }
 
+// FileChecksum - calculated during create, verified after download and before upload and restore
+type FileChecksum struct {
+	Size     int64  `json:"size"`
+	XXHash64 string `json:"xxhash64"`
+}
+
 type PartFilesSplitted struct {
 	Prefix string
 	Files  []string
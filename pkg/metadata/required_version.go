@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// features of backup which can't be restored by clickhouse-backup without their support, stored in `required_features` of metadata.json
+const (
+	FeatureIncremental    = "incremental"     // parts with `required` flag are stored in `required_backup`
+	FeatureNativeBackup   = "native_backup"   // data of tables is stored in `native` folder by BACKUP statement
+	FeatureLogicalBackup  = "logical_backup"  // data of tables is exported with SELECT ... FORMAT Native
+	FeatureZeroCopy       = "zero_copy"       // parts on object disks contain references to objects instead of data
+	FeatureTablesManifest = "tables_manifest" // metadata of tables is stored in one gzipped object on remote storage
+	FeatureClusterBackup  = "cluster_backup"  // backup contains only manifest of shard backups
+)
+
+// minimalToolVersion - first clickhouse-backup with `metadata/<db>/<table>.json` and `shadow/<db>/<table>/<disk>` layout
+const minimalToolVersion = "1.0.0"
+
+// FeatureVersions - first clickhouse-backup version which supports feature, feature absent here is unknown to this clickhouse-backup
+var FeatureVersions = map[string]string{
+	FeatureIncremental:    "1.0.0",
+	FeatureNativeBackup:   "1.5.0",
+	FeatureLogicalBackup:  "1.5.0",
+	FeatureZeroCopy:       "1.5.0",
+	FeatureTablesManifest: "1.5.0",
+	FeatureClusterBackup:  "1.5.0",
+}
+
+// SetRequiredFeatures - calculate `required_features` and `required_version` from backup and its tables, shall be called after all fields are filled
+func (bm *BackupMetadata) SetRequiredFeatures(tables []TableMetadata) {
+	features := map[string]struct{}{}
+	if bm.RequiredBackup != "" {
+		features[FeatureIncremental] = struct{}{}
+	}
+	if bm.TablesManifest != "" {
+		features[FeatureTablesManifest] = struct{}{}
+	}
+	if bm.Cluster != nil {
+		features[FeatureClusterBackup] = struct{}{}
+	}
+	for _, tm := range tables {
+		if tm.NativeBackup {
+			features[FeatureNativeBackup] = struct{}{}
+		}
+		if tm.LogicalBackup {
+			features[FeatureLogicalBackup] = struct{}{}
+		}
+		if len(tm.ObjectDisks) > 0 {
+			features[FeatureZeroCopy] = struct{}{}
+		}
+	}
+	bm.RequiredFeatures = make([]string, 0, len(features))
+	bm.RequiredVersion = minimalToolVersion
+	for feature := range features {
+		bm.RequiredFeatures = append(bm.RequiredFeatures, feature)
+		if compareToolVersions(FeatureVersions[feature], bm.RequiredVersion) > 0 {
+			bm.RequiredVersion = FeatureVersions[feature]
+		}
+	}
+	sort.Strings(bm.RequiredFeatures)
+}
+
+// checkRequiredFeatures - backup created by newer clickhouse-backup could use features which this version doesn't know
+func (bm *BackupMetadata) checkRequiredFeatures() error {
+	var unknown []string
+	for _, feature := range bm.RequiredFeatures {
+		if _, exists := FeatureVersions[feature]; !exists {
+			unknown = append(unknown, feature)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	return fmt.Errorf("backup requires clickhouse-backup %s or newer, features %s are not supported by this clickhouse-backup, upgrade clickhouse-backup", bm.RequiredVersion, strings.Join(unknown, ", "))
+}
+
+// compareToolVersions - compare numeric `major.minor.patch` prefixes, `v` prefix and suffixes like `-rc1` are ignored
+func compareToolVersions(a, b string) int {
+	partsA, partsB := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		numA, numB := 0, 0
+		if i < len(partsA) {
+			numA = leadingNumber(partsA[i])
+		}
+		if i < len(partsB) {
+			numB = leadingNumber(partsB[i])
+		}
+		if numA != numB {
+			if numA < numB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func leadingNumber(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
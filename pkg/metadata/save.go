@@ -3,7 +3,6 @@ package metadata
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 )
@@ -17,14 +16,18 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 		DependenciesTable:    tm.DependenciesTable,
 		DependenciesDatabase: tm.DependenciesDatabase,
 		MetadataOnly:         true,
+		SourceFiles:          tm.SourceFiles,
 	}
 	parts := map[string][]Part{}
 	for disk, p := range tm.Parts {
 		newp := make([]Part, len(p))
 		for i := range p {
 			newp[i] = Part{
-				Name:     p[i].Name,
-				Required: p[i].Required,
+				Name:        p[i].Name,
+				Required:    p[i].Required,
+				Projections: p[i].Projections,
+				Checksums:   p[i].Checksums,
+				Rows:        p[i].Rows,
 			}
 		}
 		parts[disk] = newp
@@ -34,6 +37,12 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 		newTM.Parts = parts
 		newTM.Size = tm.Size
 		newTM.TotalBytes = tm.TotalBytes
+		newTM.Rows = tm.Rows
+		newTM.UncompressedBytes = tm.UncompressedBytes
+		newTM.CompressedBytes = tm.CompressedBytes
+		newTM.LogicalBackup = tm.LogicalBackup
+		newTM.ObjectDisks = tm.ObjectDisks
+		newTM.NativeBackup = tm.NativeBackup
 		newTM.MetadataOnly = false
 	}
 	if err := os.MkdirAll(path.Dir(location), 0750); err != nil {
@@ -43,7 +52,7 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 	if err != nil {
 		return 0, err
 	}
-	return uint64(len(body)), ioutil.WriteFile(location, body, 0640)
+	return uint64(len(body)), WriteFileAtomic(location, body, 0640)
 }
 
 func (bm *BackupMetadata) Save(location string) error {
@@ -51,7 +60,7 @@ func (bm *BackupMetadata) Save(location string) error {
 	if err != nil {
 		return fmt.Errorf("can't marshall backup metadata: %v", err)
 	}
-	if err := ioutil.WriteFile(location, tbBody, 0640); err != nil {
+	if err := WriteFileAtomic(location, tbBody, 0640); err != nil {
 		return fmt.Errorf("can't save backup metadata: %v", err)
 	}
 	return nil
@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseTags - parse `key1=value1,key2=value2` from `tags` of metadata.json and `--tag` arguments, value could be empty
+func ParseTags(tags string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(tags, ",") {
+		pair = strings.Trim(pair, " \t\r\n")
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid tag '%s', expected format `key=value`", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// FormatTags - join tags sorted by key, keys and values shall not contain `,` and keys shall not contain `=`
+func FormatTags(tags map[string]string) (string, error) {
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if key == "" || strings.ContainsAny(key, ",=") || strings.Contains(value, ",") {
+			return "", fmt.Errorf("invalid tag '%s=%s', key shall not be empty, key and value shall not contain `,`, key shall not contain `=`", key, value)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + tags[key]
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// GetTags - tags of backup, tags which can't be parsed are ignored
+func (bm *BackupMetadata) GetTags() map[string]string {
+	tags, err := ParseTags(bm.Tags)
+	if err != nil {
+		return map[string]string{}
+	}
+	return tags
+}
+
+// HasTags - backup contains all tags of filter with the same values, empty filter match any backup
+func (bm *BackupMetadata) HasTags(filter map[string]string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	tags := bm.GetTags()
+	for key, value := range filter {
+		if actual, exists := tags[key]; !exists || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package metadata
+
+import "fmt"
+
+// MetadataVersion - format version of metadata.json and table metadata written by this clickhouse-backup, it is increased when meaning of fields changes,
+// backups without version were written before versioning, `migrate-metadata` upgrades them
+const MetadataVersion = 1
+
+// CheckVersion - metadata of newer format can't be read, because fields could have another meaning, remote backup without version and data_format
+// could be directory or archives, such backups shall be migrated before use
+func (bm *BackupMetadata) CheckVersion(remote bool) error {
+	if bm.MetadataVersion > MetadataVersion {
+		return fmt.Errorf("metadata version %d is newer than version %d supported by this clickhouse-backup, upgrade clickhouse-backup", bm.MetadataVersion, MetadataVersion)
+	}
+	if err := bm.checkRequiredFeatures(); err != nil {
+		return err
+	}
+	if bm.MetadataVersion == 0 && remote && bm.DataFormat == "" && len(bm.Tables) > 0 {
+		return fmt.Errorf("metadata.json doesn't contain metadata_version and data_format, run `migrate-metadata --remote %s`", bm.BackupName)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// graphiteSink - send metrics over TCP with Graphite plaintext protocol
+type graphiteSink struct {
+	address string
+	prefix  string
+	timeout time.Duration
+}
+
+func (g *graphiteSink) Name() string {
+	return "graphite"
+}
+
+func (g *graphiteSink) Send(result CommandResult) error {
+	conn, err := net.DialTimeout("tcp", g.address, g.timeout)
+	if err != nil {
+		return fmt.Errorf("can't connect to %s: %v", g.address, err)
+	}
+	defer conn.Close()
+	name := metricPath(g.prefix, result.Command)
+	ts := result.Finish.Unix()
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s.duration_seconds %f %d\n", name, result.Finish.Sub(result.Start).Seconds(), ts)
+	fmt.Fprintf(buf, "%s.status %d %d\n", name, result.Status(), ts)
+	if result.Err == nil {
+		fmt.Fprintf(buf, "%s.last_success %d %d\n", name, ts, ts)
+	}
+	fmt.Fprintf(buf, "%s.uploaded_bytes %d %d\n", name, result.UploadedBytes, ts)
+	fmt.Fprintf(buf, "%s.downloaded_bytes %d %d\n", name, result.DownloadedBytes, ts)
+	fmt.Fprintf(buf, "%s.storage_retries %d %d\n", name, result.StorageRetries, ts)
+	if err := conn.SetWriteDeadline(time.Now().Add(g.timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("can't send metrics to %s: %v", g.address, err)
+	}
+	return nil
+}
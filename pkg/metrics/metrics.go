@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+)
+
+// CommandResult - metrics of one finished command run
+type CommandResult struct {
+	Command         string
+	Start           time.Time
+	Finish          time.Time
+	Err             error
+	UploadedBytes   uint64
+	DownloadedBytes uint64
+	StorageRetries  uint64
+}
+
+// Status - 1 for successful run, 0 for failed
+func (r CommandResult) Status() int {
+	if r.Err != nil {
+		return 0
+	}
+	return 1
+}
+
+// Sink - external metrics system which receive CommandResult
+type Sink interface {
+	Name() string
+	Send(result CommandResult) error
+}
+
+// NewSinks - create all sinks configured in `metrics` config section
+func NewSinks(cfg *config.MetricsConfig) ([]Sink, error) {
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	sinks := make([]Sink, 0)
+	if cfg.PushgatewayURL != "" {
+		sinks = append(sinks, &pushgatewaySink{cfg: cfg, timeout: timeout})
+	}
+	if cfg.StatsdAddress != "" {
+		sinks = append(sinks, &statsdSink{address: cfg.StatsdAddress, prefix: cfg.StatsdPrefix, timeout: timeout})
+	}
+	if cfg.GraphiteAddress != "" {
+		sinks = append(sinks, &graphiteSink{address: cfg.GraphiteAddress, prefix: cfg.GraphitePrefix, timeout: timeout})
+	}
+	return sinks, nil
+}
+
+// CommandRun - measure command run, shall be created before command start
+type CommandRun struct {
+	command              string
+	start                time.Time
+	uploadedBytesStart   uint64
+	downloadedBytesStart uint64
+	storageRetriesStart  uint64
+}
+
+func StartCommand(command string) *CommandRun {
+	return &CommandRun{
+		command:              command,
+		start:                time.Now(),
+		uploadedBytesStart:   new_storage.UploadedBytes(),
+		downloadedBytesStart: new_storage.DownloadedBytes(),
+		storageRetriesStart:  new_storage.StorageRetries(),
+	}
+}
+
+// Finish - send command result to all configured sinks, return error when any sink failed
+func (r *CommandRun) Finish(cfg *config.Config, commandErr error) error {
+	sinks, err := NewSinks(&cfg.Metrics)
+	if err != nil {
+		return err
+	}
+	result := CommandResult{
+		Command:         r.command,
+		Start:           r.start,
+		Finish:          time.Now(),
+		Err:             commandErr,
+		UploadedBytes:   new_storage.UploadedBytes() - r.uploadedBytesStart,
+		DownloadedBytes: new_storage.DownloadedBytes() - r.downloadedBytesStart,
+		StorageRetries:  new_storage.StorageRetries() - r.storageRetriesStart,
+	}
+	var sinkErrors []string
+	for _, sink := range sinks {
+		if err := sink.Send(result); err != nil {
+			sinkErrors = append(sinkErrors, fmt.Sprintf("%s: %v", sink.Name(), err))
+		}
+	}
+	if len(sinkErrors) > 0 {
+		return fmt.Errorf("can't send %s metrics: %s", r.command, strings.Join(sinkErrors, "; "))
+	}
+	return nil
+}
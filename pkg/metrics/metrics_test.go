@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushgatewaySink(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	cfg := config.DefaultConfig()
+	assert.NoError(t, StartCommand("create").Finish(cfg, nil))
+	assert.Empty(t, method)
+
+	cfg.Metrics.PushgatewayURL = srv.URL
+	cfg.Metrics.PushgatewayInstance = "host1"
+	assert.NoError(t, StartCommand("upload").Finish(cfg, nil))
+	assert.Equal(t, http.MethodPost, method)
+	assert.Equal(t, "/metrics/job/clickhouse_backup/instance/host1", path)
+	assert.Contains(t, body, "clickhouse_backup_last_upload_status")
+	assert.Contains(t, body, "clickhouse_backup_last_upload_success")
+
+	assert.NoError(t, StartCommand("upload").Finish(cfg, fmt.Errorf("upload failed")))
+	assert.Contains(t, body, "clickhouse_backup_last_upload_status")
+	assert.NotContains(t, body, "clickhouse_backup_last_upload_success")
+}
+
+func TestStatsdAndGraphiteSinks(t *testing.T) {
+	result := CommandResult{
+		Command:        "create",
+		Start:          time.Unix(1600000000, 0),
+		Finish:         time.Unix(1600000060, 0),
+		UploadedBytes:  1024,
+		StorageRetries: 2,
+	}
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer udp.Close()
+	statsd := &statsdSink{address: udp.LocalAddr().String(), prefix: "ch", timeout: time.Second}
+	assert.NoError(t, statsd.Send(result))
+	buf := make([]byte, 1024)
+	n, _, err := udp.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ch.create.duration:60000|ms\nch.create.status:1|g\nch.create.successful:1|c\nch.create.last_success:1600000060|g\nch.create.uploaded_bytes:1024|c\nch.create.downloaded_bytes:0|c\nch.create.storage_retries:2|c", string(buf[:n]))
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tcp.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := tcp.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+	result.Err = fmt.Errorf("create failed")
+	graphite := &graphiteSink{address: tcp.Addr().String(), prefix: "ch", timeout: time.Second}
+	assert.NoError(t, graphite.Send(result))
+	assert.Equal(t, "ch.create.duration_seconds 60.000000 1600000060\nch.create.status 0 1600000060\nch.create.uploaded_bytes 1024 1600000060\nch.create.downloaded_bytes 0 1600000060\nch.create.storage_retries 2 1600000060\n", <-received)
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+type pushgatewaySink struct {
+	cfg     *config.MetricsConfig
+	timeout time.Duration
+}
+
+func (p *pushgatewaySink) Name() string {
+	return "pushgateway"
+}
+
+func (p *pushgatewaySink) Send(result CommandResult) error {
+	instance := p.cfg.PushgatewayInstance
+	if instance == "" {
+		var err error
+		if instance, err = os.Hostname(); err != nil {
+			return fmt.Errorf("can't get hostname for pushgateway instance label: %v", err)
+		}
+	}
+	command := result.Command
+	pusher := push.New(p.cfg.PushgatewayURL, p.cfg.PushgatewayJob).
+		Grouping("instance", instance).
+		Client(&http.Client{Timeout: p.timeout}).
+		Collector(newGauge(fmt.Sprintf("last_%s_start", command), fmt.Sprintf("Last backup %s start timestamp", command), float64(result.Start.Unix()))).
+		Collector(newGauge(fmt.Sprintf("last_%s_finish", command), fmt.Sprintf("Last backup %s finish timestamp", command), float64(result.Finish.Unix()))).
+		Collector(newGauge(fmt.Sprintf("last_%s_duration", command), fmt.Sprintf("Backup %s duration in nanoseconds", command), float64(result.Finish.Sub(result.Start).Nanoseconds()))).
+		Collector(newGauge(fmt.Sprintf("last_%s_status", command), fmt.Sprintf("Last backup %s status: 0=failed, 1=success", command), float64(result.Status()))).
+		Collector(newGauge(fmt.Sprintf("last_%s_uploaded_bytes", command), fmt.Sprintf("Bytes uploaded to remote storage during last backup %s", command), float64(result.UploadedBytes))).
+		Collector(newGauge(fmt.Sprintf("last_%s_downloaded_bytes", command), fmt.Sprintf("Bytes downloaded from remote storage during last backup %s", command), float64(result.DownloadedBytes))).
+		Collector(newGauge(fmt.Sprintf("last_%s_storage_retries", command), fmt.Sprintf("Retries of failed remote storage requests during last backup %s", command), float64(result.StorageRetries)))
+	// last_*_success is omitted for failed runs, so Add keeps previously pushed value
+	if result.Err == nil {
+		pusher = pusher.Collector(newGauge(fmt.Sprintf("last_%s_success", command), fmt.Sprintf("Last successful backup %s finish timestamp", command), float64(result.Finish.Unix())))
+	}
+	if p.cfg.PushgatewayUsername != "" || p.cfg.PushgatewayPassword != "" {
+		pusher = pusher.BasicAuth(p.cfg.PushgatewayUsername, p.cfg.PushgatewayPassword)
+	}
+	if err := pusher.Add(); err != nil {
+		return fmt.Errorf("can't push metrics to %s: %v", p.cfg.PushgatewayURL, err)
+	}
+	return nil
+}
+
+func newGauge(name, help string, value float64) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      name,
+		Help:      help,
+	})
+	g.Set(value)
+	return g
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// statsdSink - send metrics as one UDP datagram in StatsD line protocol
+type statsdSink struct {
+	address string
+	prefix  string
+	timeout time.Duration
+}
+
+func (s *statsdSink) Name() string {
+	return "statsd"
+}
+
+func (s *statsdSink) Send(result CommandResult) error {
+	conn, err := net.DialTimeout("udp", s.address, s.timeout)
+	if err != nil {
+		return fmt.Errorf("can't connect to %s: %v", s.address, err)
+	}
+	defer conn.Close()
+	name := metricPath(s.prefix, result.Command)
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s.duration:%d|ms\n", name, result.Finish.Sub(result.Start).Milliseconds())
+	fmt.Fprintf(buf, "%s.status:%d|g\n", name, result.Status())
+	if result.Err != nil {
+		fmt.Fprintf(buf, "%s.failed:1|c\n", name)
+	} else {
+		fmt.Fprintf(buf, "%s.successful:1|c\n", name)
+		fmt.Fprintf(buf, "%s.last_success:%d|g\n", name, result.Finish.Unix())
+	}
+	fmt.Fprintf(buf, "%s.uploaded_bytes:%d|c\n", name, result.UploadedBytes)
+	fmt.Fprintf(buf, "%s.downloaded_bytes:%d|c\n", name, result.DownloadedBytes)
+	fmt.Fprintf(buf, "%s.storage_retries:%d|c", name, result.StorageRetries)
+	if err := conn.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("can't send metrics to %s: %v", s.address, err)
+	}
+	return nil
+}
+
+func metricPath(prefix, command string) string {
+	if prefix == "" {
+		return command
+	}
+	return prefix + "." + command
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"io"
@@ -15,6 +16,7 @@ import (
 
 	x "github.com/mxalis/clickhouse-backup/pkg/new_storage/azblob"
 
+	apexLog "github.com/apex/log"
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/Azure/go-autorest/autorest/adal"
@@ -30,7 +32,7 @@ type AzureBlob struct {
 }
 
 // Connect - connect to Azure
-func (s *AzureBlob) Connect() error {
+func (s *AzureBlob) Connect(ctx context.Context) error {
 	if s.Config.EndpointSuffix == "" {
 		return fmt.Errorf("endpoint suffix not set")
 	}
@@ -101,7 +103,7 @@ func (s *AzureBlob) Connect() error {
 			TryTimeout: 30 * time.Minute,
 			},
 		})).NewContainerURL(s.Config.Container)
-	_, err = s.Container.Create(context.Background(), azblob.Metadata{}, azblob.PublicAccessNone)
+	_, err = s.Container.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
 	if err != nil && !isContainerAlreadyExists(err) {
 		return err
 	}
@@ -110,7 +112,7 @@ func (s *AzureBlob) Connect() error {
 		return errors.Wrapf(err, "azblob: failed to generate test blob name")
 	}
 	test_blob := s.Container.NewBlockBlobURL(base64.URLEncoding.EncodeToString(test_name))
-	if _, err = test_blob.GetProperties(context.Background(), azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{}); err != nil {
+	if _, err = test_blob.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{}); err != nil {
 		if se, ok := err.(azblob.StorageError); !ok || se.ServiceCode() != azblob.ServiceCodeBlobNotFound {
 			return errors.Wrapf(err, "azblob: failed to access container %s", s.Config.Container)
 		}
@@ -136,8 +138,7 @@ func (s *AzureBlob) Kind() string {
 	return "azblob"
 }
 
-func (s *AzureBlob) GetFileReader(key string) (io.ReadCloser, error) {
-	ctx := context.Background()
+func (s *AzureBlob) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	r, err := blob.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, s.CPK)
 	if err != nil {
@@ -146,12 +147,20 @@ func (s *AzureBlob) GetFileReader(key string) (io.ReadCloser, error) {
 	return r.Body(azblob.RetryReaderOptions{}), nil
 }
 
-func (s *AzureBlob) GetFileReaderWithLocalPath(key, _ string) (io.ReadCloser, error) {
-	return s.GetFileReader(key)
+func (s *AzureBlob) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
+	r, err := blob.Download(ctx, offset, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, s.CPK)
+	if err != nil {
+		return nil, err
+	}
+	return r.Body(azblob.RetryReaderOptions{}), nil
+}
+
+func (s *AzureBlob) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return s.GetFileReader(ctx, key)
 }
 
-func (s *AzureBlob) PutFile(key string, r io.ReadCloser) error {
-	ctx := context.Background()
+func (s *AzureBlob) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	bufferSize := s.Config.BufferSize // Configure the size of the rotating buffers that are used when uploading
 	maxBuffers := s.Config.MaxBuffers // Configure the number of rotating buffers that are used when uploading
@@ -159,15 +168,61 @@ func (s *AzureBlob) PutFile(key string, r io.ReadCloser) error {
 	return err
 }
 
-func (s *AzureBlob) DeleteFile(key string) error {
-	ctx := context.Background()
+func (s *AzureBlob) DeleteFile(ctx context.Context, key string) error {
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	_, err := blob.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
 	return err
 }
 
-func (s *AzureBlob) StatFile(key string) (RemoteFile, error) {
-	ctx := context.Background()
+// DeleteFiles - azure-storage-blob-go doesn't implement blob batch API, so blobs are deleted with `delete_concurrency` parallel requests
+func (s *AzureBlob) DeleteFiles(ctx context.Context, keys []string) error {
+	return deleteFilesConcurrently(ctx, keys, s.DeleteFile, func(err error) bool {
+		se, ok := err.(azblob.StorageError)
+		return ok && se.ServiceCode() == azblob.ServiceCodeBlobNotFound
+	})
+}
+
+// CopyFile - server-side Copy Blob, source in the same container is authorized with the same credential, copy is asynchronous, so status is polled until copy finished,
+// Copy Blob doesn't support customer-provided keys, so blobs encrypted with `sse_key` are copied through host
+func (s *AzureBlob) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	if s.CPK.EncryptionKey != nil {
+		return copyFileByStream(ctx, s, srcKey, dstKey)
+	}
+	src := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, srcKey))
+	dst := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, dstKey))
+	started, err := dst.StartCopyFromURL(ctx, src.URL(), azblob.Metadata{}, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+	status := started.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			if _, err := dst.AbortCopyFromURL(context.Background(), started.CopyID(), azblob.LeaseAccessConditions{}); err != nil {
+				apexLog.Warnf("can't abort copy %s to %s: %v", srcKey, dstKey, err)
+			}
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		props, err := dst.GetProperties(ctx, azblob.BlobAccessConditions{}, s.CPK)
+		if err != nil {
+			return err
+		}
+		if status = props.CopyStatus(); status != azblob.CopyStatusPending && status != azblob.CopyStatusSuccess {
+			return fmt.Errorf("copy %s to %s finished with status %s: %s", srcKey, dstKey, status, props.CopyStatusDescription())
+		}
+	}
+	if status != azblob.CopyStatusSuccess {
+		return fmt.Errorf("copy %s to %s finished with status %s", srcKey, dstKey, status)
+	}
+	return nil
+}
+
+func (s *AzureBlob) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	return moveFileByCopy(ctx, s, srcKey, dstKey)
+}
+
+func (s *AzureBlob) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	r, err := blob.GetProperties(ctx, azblob.BlobAccessConditions{}, s.CPK)
 	if err != nil {
@@ -180,11 +235,12 @@ func (s *AzureBlob) StatFile(key string) (RemoteFile, error) {
 		name:         key,
 		size:         r.ContentLength(),
 		lastModified: r.LastModified(),
+		etag:         string(r.ETag()),
+		checksum:     hex.EncodeToString(r.ContentMD5()),
 	}, nil
 }
 
-func (s *AzureBlob) Walk(azPath string, recursive bool, process func(r RemoteFile) error) error {
-	ctx := context.Background()
+func (s *AzureBlob) Walk(ctx context.Context, azPath string, recursive bool, process func(r RemoteFile) error) error {
 	prefix := path.Join(s.Config.Path, azPath)
 	if prefix == "" || prefix == "/" {
 		prefix = ""
@@ -223,6 +279,8 @@ func (s *AzureBlob) Walk(azPath string, recursive bool, process func(r RemoteFil
 				name:         strings.TrimPrefix(blob.Name, prefix),
 				size:         size,
 				lastModified: blob.Properties.LastModified,
+				etag:         string(blob.Properties.Etag),
+				checksum:     hex.EncodeToString(blob.Properties.ContentMD5),
 			}); err != nil {
 				return err
 			}
@@ -236,6 +294,8 @@ type azureBlobFile struct {
 	size         int64
 	lastModified time.Time
 	name         string
+	etag         string
+	checksum     string
 }
 
 func (f *azureBlobFile) Size() int64 {
@@ -250,6 +310,14 @@ func (f *azureBlobFile) LastModified() time.Time {
 	return f.lastModified
 }
 
+func (f *azureBlobFile) ETag() string {
+	return f.etag
+}
+
+func (f *azureBlobFile) Checksum() string {
+	return f.checksum
+}
+
 func isContainerAlreadyExists(err error) bool {
 	if err != nil {
 		if serr, ok := err.(azblob.StorageError); ok { // This error is a Service-specific
@@ -0,0 +1,29 @@
+package new_storage
+
+import (
+	"context"
+
+	apexLog "github.com/apex/log"
+)
+
+// copyFileByStream - storages without server-side copy read source file and write it back through host
+func copyFileByStream(ctx context.Context, storage RemoteStorage, srcKey, dstKey string) error {
+	r, err := storage.GetFileReader(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			apexLog.Warnf("can't close %s reader: %v", srcKey, err)
+		}
+	}()
+	return storage.PutFile(ctx, dstKey, r)
+}
+
+// moveFileByCopy - object storages don't have rename, so source is deleted after server-side copy
+func moveFileByCopy(ctx context.Context, storage RemoteStorage, srcKey, dstKey string) error {
+	if err := storage.CopyFile(ctx, srcKey, dstKey); err != nil {
+		return err
+	}
+	return storage.DeleteFile(ctx, srcKey)
+}
@@ -2,6 +2,7 @@ package new_storage
 
 import (
 	"context"
+	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"io"
 	"net/http"
@@ -20,7 +21,7 @@ type COS struct {
 }
 
 // Connect - connect to cos
-func (c *COS) Connect() error {
+func (c *COS) Connect(ctx context.Context) error {
 	u, err := url.Parse(c.Config.RowURL)
 	if err != nil {
 		return err
@@ -45,7 +46,7 @@ func (c *COS) Connect() error {
 		},
 	})
 	// check bucket exists
-	_, err = c.client.Bucket.Head(context.Background())
+	_, err = c.client.Bucket.Head(ctx)
 	return err
 }
 
@@ -53,9 +54,9 @@ func (c *COS) Kind() string {
 	return "COS"
 }
 
-func (c *COS) StatFile(key string) (RemoteFile, error) {
+func (c *COS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	// file max size is 5Gb
-	resp, err := c.client.Object.Get(context.Background(), path.Join(c.Config.Path, key), nil)
+	resp, err := c.client.Object.Get(ctx, path.Join(c.Config.Path, key), nil)
 	if err != nil {
 		cosErr, ok := err.(*cos.ErrorResponse)
 		if ok && cosErr.Code == "NoSuchKey" {
@@ -68,17 +69,57 @@ func (c *COS) StatFile(key string) (RemoteFile, error) {
 		size:         resp.Response.ContentLength,
 		name:         resp.Request.URL.Path,
 		lastModified: modifiedTime,
+		etag:         resp.Response.Header.Get("ETag"),
+		checksum:     md5FromETag(resp.Response.Header.Get("ETag")),
 	}, nil
 }
 
-func (c *COS) DeleteFile(key string) error {
-	_, err := c.client.Object.Delete(context.Background(), path.Join(c.Config.Path, key))
+func (c *COS) DeleteFile(ctx context.Context, key string) error {
+	_, err := c.client.Object.Delete(ctx, path.Join(c.Config.Path, key))
 	return err
 }
 
-func (c *COS) Walk(cosPath string, recursive bool, process func(RemoteFile) error) error {
+// DeleteFiles - DeleteMulti by batches of 1000 keys
+func (c *COS) DeleteFiles(ctx context.Context, keys []string) error {
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]cos.Object, end-start)
+		for i, key := range keys[start:end] {
+			objects[i] = cos.Object{Key: path.Join(c.Config.Path, key)}
+		}
+		result, _, err := c.client.Object.DeleteMulti(ctx, &cos.ObjectDeleteMultiOptions{Quiet: true, Objects: objects})
+		if err != nil {
+			return err
+		}
+		if len(result.Errors) > 0 {
+			e := result.Errors[0]
+			return fmt.Errorf("DeleteFiles, %d of %d objects are not deleted, %s: %s %s", len(result.Errors), len(objects), e.Key, e.Code, e.Message)
+		}
+	}
+	return nil
+}
+
+// CopyFile - server-side copy, MultiCopy copy objects bigger than 5GB by parts
+func (c *COS) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	sourceURL := fmt.Sprintf("%s/%s", c.client.BaseURL.BucketURL.Host, path.Join(c.Config.Path, srcKey))
+	_, _, err := c.client.Object.MultiCopy(ctx, path.Join(c.Config.Path, dstKey), sourceURL, nil)
+	return err
+}
+
+func (c *COS) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	return moveFileByCopy(ctx, c, srcKey, dstKey)
+}
+
+// Walk - GET Bucket return up to 1000 keys, so pages are listed with marker until response is not truncated
+func (c *COS) Walk(ctx context.Context, cosPath string, recursive bool, process func(RemoteFile) error) error {
 	// COS needs prefix ended with "/".
 	prefix := path.Join(c.Config.Path, cosPath) + "/"
+	if prefix == "//" {
+		prefix = ""
+	}
 
 	delimiter := ""
 	if !recursive {
@@ -94,50 +135,84 @@ func (c *COS) Walk(cosPath string, recursive bool, process func(RemoteFile) erro
 		//
 		delimiter = ""
 	}
-	res, _, err := c.client.Bucket.Get(context.Background(), &cos.BucketGetOptions{
+	opt := &cos.BucketGetOptions{
 		Delimiter: delimiter,
 		Prefix:    prefix,
-	})
-	if err != nil {
-		return err
+		MaxKeys:   1000,
 	}
-	// When recursive is false, only process all the backups in the CommonPrefixes part.
-	for _, dir := range res.CommonPrefixes {
-		if err := process(&cosFile{
-			name: strings.TrimPrefix(dir, prefix),
-		}); err != nil {
+	for {
+		res, _, err := c.client.Bucket.Get(ctx, opt)
+		if err != nil {
 			return err
 		}
-	}
-	if recursive {
-		for _, v := range res.Contents {
-			modifiedTime, _ := parseTime(v.LastModified)
+		// When recursive is false, only process all the backups in the CommonPrefixes part.
+		for _, dir := range res.CommonPrefixes {
 			if err := process(&cosFile{
-				name:         strings.TrimPrefix(v.Key, prefix),
-				lastModified: modifiedTime,
-				size:         int64(v.Size),
+				name: strings.TrimPrefix(dir, prefix),
 			}); err != nil {
 				return err
 			}
 		}
+		if recursive {
+			for _, v := range res.Contents {
+				modifiedTime, _ := parseTime(v.LastModified)
+				if err := process(&cosFile{
+					name:         strings.TrimPrefix(v.Key, prefix),
+					lastModified: modifiedTime,
+					size:         int64(v.Size),
+					etag:         v.ETag,
+					checksum:     md5FromETag(v.ETag),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		if !res.IsTruncated {
+			return nil
+		}
+		if opt.Marker = nextCOSMarker(res); opt.Marker == "" {
+			return fmt.Errorf("list of %s is truncated without next marker", prefix)
+		}
 	}
-	return nil
 }
 
-func (c *COS) GetFileReader(key string) (io.ReadCloser, error) {
-	resp, err := c.client.Object.Get(context.Background(), path.Join(c.Config.Path, key), nil)
+// nextCOSMarker - NextMarker is returned only with delimiter, otherwise the last key of page continue listing
+func nextCOSMarker(res *cos.BucketGetResult) string {
+	if res.NextMarker != "" {
+		return res.NextMarker
+	}
+	marker := ""
+	if len(res.Contents) > 0 {
+		marker = res.Contents[len(res.Contents)-1].Key
+	}
+	if len(res.CommonPrefixes) > 0 && res.CommonPrefixes[len(res.CommonPrefixes)-1] > marker {
+		marker = res.CommonPrefixes[len(res.CommonPrefixes)-1]
+	}
+	return marker
+}
+
+func (c *COS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.client.Object.Get(ctx, path.Join(c.Config.Path, key), nil)
 	if err != nil {
 		return nil, err
 	}
 	return resp.Body, nil
 }
 
-func (c *COS) GetFileReaderWithLocalPath(key, _ string) (io.ReadCloser, error) {
-	return c.GetFileReader(key)
+func (c *COS) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	resp, err := c.client.Object.Get(ctx, path.Join(c.Config.Path, key), &cos.ObjectGetOptions{Range: fmt.Sprintf("bytes=%d-", offset)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *COS) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return c.GetFileReader(ctx, key)
 }
 
-func (c *COS) PutFile(key string, r io.ReadCloser) error {
-	_, err := c.client.Object.Put(context.Background(), path.Join(c.Config.Path, key), r, nil)
+func (c *COS) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	_, err := c.client.Object.Put(ctx, path.Join(c.Config.Path, key), r, nil)
 	return err
 }
 
@@ -145,6 +220,8 @@ type cosFile struct {
 	size         int64
 	lastModified time.Time
 	name         string
+	etag         string
+	checksum     string
 }
 
 func (f *cosFile) Size() int64 {
@@ -159,6 +236,14 @@ func (f *cosFile) LastModified() time.Time {
 	return f.lastModified
 }
 
+func (f *cosFile) ETag() string {
+	return f.etag
+}
+
+func (f *cosFile) Checksum() string {
+	return f.checksum
+}
+
 func parseTime(text string) (t time.Time, err error) {
 	timeFormats := []string{
 		"Mon, 02 Jan 2006 15:04:05 GMT",
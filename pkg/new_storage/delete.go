@@ -0,0 +1,53 @@
+package new_storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// deleteBatchSize - maximum keys in one S3 DeleteObjects and COS DeleteMulti request, RemoveBackup also flush keys by batches during Walk
+const deleteBatchSize = 1000
+
+// deleteConcurrency - parallel DeleteFile requests of storages without batch delete API, set from `delete_concurrency`
+var deleteConcurrency = 16
+
+// deleteFilesConcurrently - delete keys one by one with bounded parallelism, all keys are tried even when some of them fail,
+// files which are already missing are skipped, so deletion could be repeated
+func deleteFilesConcurrently(ctx context.Context, keys []string, deleteFile func(ctx context.Context, key string) error, isNotFound func(err error) bool) error {
+	concurrency := deleteConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	s := semaphore.NewWeighted(int64(concurrency))
+	g := errgroup.Group{}
+	var failedLock sync.Mutex
+	var failed []string
+	for _, key := range keys {
+		if err := s.Acquire(ctx, 1); err != nil {
+			break
+		}
+		key := key
+		g.Go(func() error {
+			defer s.Release(1)
+			if err := deleteFile(ctx, key); err != nil && !isNotFound(err) {
+				failedLock.Lock()
+				failed = append(failed, key)
+				failedLock.Unlock()
+				return fmt.Errorf("can't delete %s: %w", key, err)
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil && len(failed) > 1 {
+		return fmt.Errorf("%d of %d files are not deleted, first error: %w", len(failed), len(keys), err)
+	}
+	return err
+}
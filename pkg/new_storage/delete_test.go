@@ -0,0 +1,72 @@
+package new_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchStorage - Walk list files of backup, DeleteFiles remember batches
+type batchStorage struct {
+	rangeStorage
+	files   []string
+	batches [][]string
+}
+
+func (s *batchStorage) Walk(ctx context.Context, prefix string, _ bool, fn func(RemoteFile) error) error {
+	for _, name := range s.files {
+		if err := fn(&gcsFile{name: name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *batchStorage) DeleteFiles(_ context.Context, keys []string) error {
+	s.batches = append(s.batches, append([]string{}, keys...))
+	return nil
+}
+
+func TestRemoveBackupByBatches(t *testing.T) {
+	storage := &batchStorage{}
+	for i := 0; i < deleteBatchSize+1; i++ {
+		storage.files = append(storage.files, fmt.Sprintf("shadow/db/t/part_%d.tar", i))
+	}
+	bd := &BackupDestination{RemoteStorage: storage}
+	assert.NoError(t, bd.RemoveBackup(context.Background(), Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "b"}}))
+	assert.Len(t, storage.batches, 2)
+	assert.Len(t, storage.batches[0], deleteBatchSize)
+	assert.Equal(t, []string{fmt.Sprintf("b/shadow/db/t/part_%d.tar", deleteBatchSize)}, storage.batches[1])
+}
+
+func TestDeleteFilesConcurrently(t *testing.T) {
+	var lock sync.Mutex
+	var deleted []string
+	failure := errors.New("access denied")
+	err := deleteFilesConcurrently(context.Background(), []string{"a", "missing", "b", "broken"}, func(_ context.Context, key string) error {
+		switch key {
+		case "missing":
+			return ErrNotFound
+		case "broken":
+			return failure
+		}
+		lock.Lock()
+		deleted = append(deleted, key)
+		lock.Unlock()
+		return nil
+	}, func(err error) bool { return errors.Is(err, ErrNotFound) })
+	assert.ErrorIs(t, err, failure)
+	sort.Strings(deleted)
+	assert.Equal(t, []string{"a", "b"}, deleted, "all keys are tried after failure")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = deleteFilesConcurrently(ctx, []string{"a"}, func(context.Context, string) error { return nil }, func(error) bool { return false })
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -25,7 +25,7 @@ type FTP struct {
 	dirCacheMutex sync.RWMutex
 }
 
-func (f *FTP) Connect() error {
+func (f *FTP) Connect(ctx context.Context) error {
 	timeout, err := time.ParseDuration(f.Config.Timeout)
 	if err != nil {
 		return err
@@ -58,9 +58,10 @@ func (f *FTP) Kind() string {
 }
 
 // getConnectionFromPool *ftp.ServerConn is not thread-safe, so we need implements connection pool
-func (f *FTP) getConnectionFromPool(where string) (*ftp.ServerConn, error) {
+// waiting for free connection is aborted when ctx is canceled
+func (f *FTP) getConnectionFromPool(ctx context.Context, where string) (*ftp.ServerConn, error) {
 	apexLog.Debugf("FTP::getConnectionFromPool(%s) active=%d idle=%d", where, f.clients.GetNumActive(), f.clients.GetNumIdle())
-	client, err := f.clients.BorrowObject(f.ctx)
+	client, err := f.clients.BorrowObject(ctx)
 	if err != nil {
 		apexLog.Errorf("can't BorrowObject from FTP Connection Pool: %v", err)
 		return nil, err
@@ -78,10 +79,10 @@ func (f *FTP) returnConnectionToPool(where string, client *ftp.ServerConn) {
 	}
 }
 
-func (f *FTP) StatFile(key string) (RemoteFile, error) {
+func (f *FTP) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	// cant list files, so check the dir
 	dir := path.Dir(path.Join(f.Config.Path, key))
-	client, err := f.getConnectionFromPool(fmt.Sprintf("StatFile, key=%s", key))
+	client, err := f.getConnectionFromPool(ctx, fmt.Sprintf("StatFile, key=%s", key))
 	if err != nil {
 		return nil, err
 	}
@@ -109,8 +110,8 @@ func (f *FTP) StatFile(key string) (RemoteFile, error) {
 	return nil, ErrNotFound
 }
 
-func (f *FTP) DeleteFile(key string) error {
-	client, err := f.getConnectionFromPool("DeleteFile")
+func (f *FTP) DeleteFile(ctx context.Context, key string) error {
+	client, err := f.getConnectionFromPool(ctx, "DeleteFile")
 	defer f.returnConnectionToPool("DeleteFile", client)
 	if err != nil {
 		return err
@@ -118,8 +119,20 @@ func (f *FTP) DeleteFile(key string) error {
 	return client.RemoveDirRecur(path.Join(f.Config.Path, key))
 }
 
-func (f *FTP) Walk(ftpPath string, recursive bool, process func(RemoteFile) error) error {
-	client, err := f.getConnectionFromPool("Walk")
+// DeleteFiles - each parallel delete borrows own connection from pool
+func (f *FTP) DeleteFiles(ctx context.Context, keys []string) error {
+	return deleteFilesConcurrently(ctx, keys, func(ctx context.Context, key string) error {
+		client, err := f.getConnectionFromPool(ctx, "DeleteFiles")
+		defer f.returnConnectionToPool("DeleteFiles", client)
+		if err != nil {
+			return err
+		}
+		return client.Delete(path.Join(f.Config.Path, key))
+	}, func(error) bool { return false })
+}
+
+func (f *FTP) Walk(ctx context.Context, ftpPath string, recursive bool, process func(RemoteFile) error) error {
+	client, err := f.getConnectionFromPool(ctx, "Walk")
 	defer f.returnConnectionToPool("Walk", client)
 	if err != nil {
 		return err
@@ -148,29 +161,34 @@ func (f *FTP) Walk(ftpPath string, recursive bool, process func(RemoteFile) erro
 		}
 		return nil
 	}
+	// Next return false when List fails, so error is checked after loop
 	walker := client.Walk(prefix)
 	for walker.Next() {
-		if err := walker.Err(); err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		// recursive Walk return only files the same as object storages
 		entry := walker.Stat()
-		if entry == nil {
+		if entry == nil || entry.Type == ftp.EntryTypeFolder {
 			continue
 		}
 		if err := process(&ftpFile{
 			size:         int64(entry.Size),
 			lastModified: entry.Time,
-			name:         strings.Trim(walker.Path(), prefix),
+			name:         strings.TrimPrefix(walker.Path(), prefix),
 		}); err != nil {
 			return err
 		}
 	}
+	if err := walker.Err(); err != nil && !strings.HasPrefix(err.Error(), "550") {
+		return err
+	}
 	return nil
 }
 
-func (f *FTP) GetFileReader(key string) (io.ReadCloser, error) {
+func (f *FTP) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	apexLog.Debugf("FTP::GetFileReader key=%s", key)
-	client, err := f.getConnectionFromPool("GetFileReader")
+	client, err := f.getConnectionFromPool(ctx, "GetFileReader")
 	if err != nil {
 		return nil, err
 	}
@@ -182,13 +200,27 @@ func (f *FTP) GetFileReader(key string) (io.ReadCloser, error) {
 	}, err
 }
 
-func (f *FTP) GetFileReaderWithLocalPath(key, _ string) (io.ReadCloser, error) {
-	return f.GetFileReader(key)
+func (f *FTP) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	apexLog.Debugf("FTP::GetFileReaderAt key=%s offset=%d", key, offset)
+	client, err := f.getConnectionFromPool(ctx, "GetFileReaderAt")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.RetrFrom(path.Join(f.Config.Path, key), uint64(offset))
+	return &FTPFileReader{
+		Response: resp,
+		pool:     f,
+		client:   client,
+	}, err
+}
+
+func (f *FTP) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return f.GetFileReader(ctx, key)
 }
 
-func (f *FTP) PutFile(key string, r io.ReadCloser) error {
+func (f *FTP) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 	apexLog.Debugf("FTP::PutFile key=%s", key)
-	client, err := f.getConnectionFromPool("PutFile")
+	client, err := f.getConnectionFromPool(ctx, "PutFile")
 	defer f.returnConnectionToPool("PutFile", client)
 	if err != nil {
 		return err
@@ -201,6 +233,24 @@ func (f *FTP) PutFile(key string, r io.ReadCloser) error {
 	return client.Stor(k, r)
 }
 
+// CopyFile - FTP protocol doesn't have copy, file is streamed through host
+func (f *FTP) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	return copyFileByStream(ctx, f, srcKey, dstKey)
+}
+
+func (f *FTP) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	client, err := f.getConnectionFromPool(ctx, "MoveFile")
+	defer f.returnConnectionToPool("MoveFile", client)
+	if err != nil {
+		return err
+	}
+	dstPath := path.Join(f.Config.Path, dstKey)
+	if err = f.MkdirAll(path.Dir(dstPath), client); err != nil {
+		return err
+	}
+	return client.Rename(path.Join(f.Config.Path, srcKey), dstPath)
+}
+
 type ftpFile struct {
 	size         int64
 	lastModified time.Time
@@ -215,6 +265,14 @@ func (f *ftpFile) LastModified() time.Time {
 	return f.lastModified
 }
 
+func (f *ftpFile) ETag() string {
+	return ""
+}
+
+func (f *ftpFile) Checksum() string {
+	return ""
+}
+
 func (f *ftpFile) Name() string {
 	return f.name
 }
@@ -2,6 +2,7 @@ package new_storage
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"google.golang.org/api/option/internaloption"
@@ -53,10 +54,9 @@ func (w debugGCSTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 }
 
 // Connect - connect to GCS
-func (gcs *GCS) Connect() error {
+func (gcs *GCS) Connect(ctx context.Context) error {
 	var err error
 	clientOptions := make([]option.ClientOption, 0)
-	ctx := context.Background()
 	clientOptions = append(clientOptions, option.WithTelemetryDisabled())
 	endpoint := "https://storage.googleapis.com/storage/v1/"
 	if gcs.Config.Endpoint != "" {
@@ -90,8 +90,7 @@ func (gcs *GCS) Connect() error {
 	return err
 }
 
-func (gcs *GCS) Walk(gcsPath string, recursive bool, process func(r RemoteFile) error) error {
-	ctx := context.Background()
+func (gcs *GCS) Walk(ctx context.Context, gcsPath string, recursive bool, process func(r RemoteFile) error) error {
 	rootPath := path.Join(gcs.Config.Path, gcsPath)
 	prefix := rootPath + "/"
 	if rootPath == "/" {
@@ -121,6 +120,8 @@ func (gcs *GCS) Walk(gcsPath string, recursive bool, process func(r RemoteFile)
 				size:         object.Size,
 				lastModified: object.Updated,
 				name:         strings.TrimPrefix(object.Name, rootPath),
+				etag:         object.Etag,
+				checksum:     hex.EncodeToString(object.MD5),
 			}); err != nil {
 				return err
 			}
@@ -136,8 +137,7 @@ func (gcs *GCS) Kind() string {
 	return "GCS"
 }
 
-func (gcs *GCS) GetFileReader(key string) (io.ReadCloser, error) {
-	ctx := context.Background()
+func (gcs *GCS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key))
 	reader, err := obj.NewReader(ctx)
 	if err != nil {
@@ -146,8 +146,13 @@ func (gcs *GCS) GetFileReader(key string) (io.ReadCloser, error) {
 	return reader, nil
 }
 
-func (gcs *GCS) GetFileReaderWithLocalPath(key, _ string) (io.ReadCloser, error) {
-	return gcs.GetFileReader(key)
+func (gcs *GCS) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key))
+	return obj.NewRangeReader(ctx, offset, -1)
+}
+
+func (gcs *GCS) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return gcs.GetFileReader(ctx, key)
 }
 
 func (gcs *GCS) GetFileWriter(key string) io.WriteCloser {
@@ -157,23 +162,22 @@ func (gcs *GCS) GetFileWriter(key string) io.WriteCloser {
 	return obj.NewWriter(ctx)
 }
 
-func (gcs *GCS) PutFile(key string, r io.ReadCloser) error {
-	ctx := context.Background()
+func (gcs *GCS) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 	key = path.Join(gcs.Config.Path, key)
 	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(key)
+	// upload is not finished on remote storage, when ctx is canceled before writer.Close
 	writer := obj.NewWriter(ctx)
-	defer func() {
-		if err := writer.Close(); err != nil {
-			log.Warnf("can't close writer: %+v", err)
-		}
-	}()
 	buffer := make([]byte, 4*1024*1024)
-	_, err := io.CopyBuffer(writer, r, buffer)
-	return err
+	if _, err := io.CopyBuffer(writer, r, buffer); err != nil {
+		if closeErr := writer.Close(); closeErr != nil {
+			log.Warnf("can't close writer: %+v", closeErr)
+		}
+		return err
+	}
+	return writer.Close()
 }
 
-func (gcs *GCS) StatFile(key string) (RemoteFile, error) {
-	ctx := context.Background()
+func (gcs *GCS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	objAttr, err := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key)).Attrs(ctx)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
@@ -185,20 +189,43 @@ func (gcs *GCS) StatFile(key string) (RemoteFile, error) {
 		size:         objAttr.Size,
 		lastModified: objAttr.Updated,
 		name:         objAttr.Name,
+		etag:         objAttr.Etag,
+		checksum:     hex.EncodeToString(objAttr.MD5),
 	}, nil
 }
 
-func (gcs *GCS) DeleteFile(key string) error {
-	ctx := context.Background()
+func (gcs *GCS) DeleteFile(ctx context.Context, key string) error {
 	key = path.Join(gcs.Config.Path, key)
 	object := gcs.client.Bucket(gcs.Config.Bucket).Object(key)
 	return object.Delete(ctx)
 }
 
+// DeleteFiles - cloud.google.com/go/storage doesn't implement JSON batch API, so objects are deleted with `delete_concurrency` parallel requests
+func (gcs *GCS) DeleteFiles(ctx context.Context, keys []string) error {
+	return deleteFilesConcurrently(ctx, keys, gcs.DeleteFile, func(err error) bool { return err == storage.ErrObjectNotExist })
+}
+
+// CopyFile - server-side Rewrite, Copier.Run repeat rewrite requests until big object is copied completely
+func (gcs *GCS) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	bucket := gcs.client.Bucket(gcs.Config.Bucket)
+	src := bucket.Object(path.Join(gcs.Config.Path, srcKey))
+	_, err := bucket.Object(path.Join(gcs.Config.Path, dstKey)).CopierFrom(src).Run(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (gcs *GCS) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	return moveFileByCopy(ctx, gcs, srcKey, dstKey)
+}
+
 type gcsFile struct {
 	size         int64
 	lastModified time.Time
 	name         string
+	etag         string
+	checksum     string
 }
 
 func (f *gcsFile) Size() int64 {
@@ -212,3 +239,11 @@ func (f *gcsFile) Name() string {
 func (f *gcsFile) LastModified() time.Time {
 	return f.lastModified
 }
+
+func (f *gcsFile) ETag() string {
+	return f.etag
+}
+
+func (f *gcsFile) Checksum() string {
+	return f.checksum
+}
@@ -52,51 +52,69 @@ type BackupDestination struct {
 	compressionFormat  string
 	compressionLevel   int
 	disableProgressBar bool
+	downloadRetries    int
 }
 
 var metadataCacheLock sync.RWMutex
 
-func (bd *BackupDestination) RemoveOldBackups(keep int) error {
+// RemoveOldBackups - keep newest backups, only backups with all retentionTags are counted and deleted when retentionTags is not empty
+func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int, retentionTags map[string]string) error {
 	if keep < 1 {
 		return nil
 	}
 	start := time.Now()
-	backupList, err := bd.BackupList(true, "")
+	backupList, err := bd.BackupList(ctx, true, "")
 	if err != nil {
 		return err
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, keep)
+	backupsToDelete := GetBackupsToDelete(FilterBackupsByTags(backupList, retentionTags), keep)
+	if len(retentionTags) > 0 {
+		backupsToDelete = excludeRequiredBackups(backupsToDelete, backupList)
+	}
 	apexLog.WithFields(apexLog.Fields{
 		"operation": "RemoveOldBackups",
-		"duration":  utils.HumanizeDuration(time.Since(start)),
+		"duration":  utils.LogDuration(time.Since(start)),
 	}).Info("calculate backup list for delete")
 	for _, backupToDelete := range backupsToDelete {
 		startDelete := time.Now()
-		if err := bd.RemoveBackup(backupToDelete); err != nil {
+		if err := bd.RemoveBackup(ctx, backupToDelete); err != nil {
 			apexLog.Warnf("can't delete %s return error : %v", backupToDelete, err)
 		}
 		apexLog.WithFields(apexLog.Fields{
 			"operation": "RemoveOldBackups",
 			"location":  "remote",
 			"backup":    backupToDelete.BackupName,
-			"duration":  utils.HumanizeDuration(time.Since(startDelete)),
+			"duration":  utils.LogDuration(time.Since(startDelete)),
 		}).Info("done")
 	}
-	apexLog.WithFields(apexLog.Fields{"operation": "RemoveOldBackups", "duration": utils.HumanizeDuration(time.Since(start))}).Info("done")
+	apexLog.WithFields(apexLog.Fields{"operation": "RemoveOldBackups", "duration": utils.LogDuration(time.Since(start))}).Info("done")
 	return nil
 }
 
-func (bd *BackupDestination) RemoveBackup(backup Backup) error {
+func (bd *BackupDestination) RemoveBackup(ctx context.Context, backup Backup) error {
 	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" {
-		return bd.DeleteFile(backup.BackupName)
+		return bd.DeleteFile(ctx, backup.BackupName)
 	}
 	if backup.Legacy {
 		archiveName := fmt.Sprintf("%s.%s", backup.BackupName, backup.FileExtension)
-		return bd.DeleteFile(archiveName)
+		return bd.DeleteFile(ctx, archiveName)
 	}
-	return bd.Walk(backup.BackupName+"/", true, func(f RemoteFile) error {
-		return bd.DeleteFile(path.Join(backup.BackupName, f.Name()))
-	})
+	keys := make([]string, 0, deleteBatchSize)
+	if err := bd.Walk(ctx, backup.BackupName+"/", true, func(f RemoteFile) error {
+		keys = append(keys, path.Join(backup.BackupName, f.Name()))
+		if len(keys) < deleteBatchSize {
+			return nil
+		}
+		err := bd.DeleteFiles(ctx, keys)
+		keys = keys[:0]
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return bd.DeleteFiles(ctx, keys)
 }
 
 func isLegacyBackup(backupName string) (bool, string, string) {
@@ -170,12 +188,12 @@ func (bd *BackupDestination) saveMetadataCache(listCache map[string]Backup, actu
 	_ = f.Close()
 }
 
-func (bd *BackupDestination) BackupList(parseMetadata bool, parseMetadataOnly string) ([]Backup, error) {
+func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool, parseMetadataOnly string) ([]Backup, error) {
 	result := make([]Backup, 0)
 	metadataCacheLock.Lock()
 	defer metadataCacheLock.Unlock()
 	listCache := bd.loadMetadataCache()
-	err := bd.Walk("/", false, func(o RemoteFile) error {
+	err := bd.Walk(ctx, "/", false, func(o RemoteFile) error {
 		// Legacy backup
 		if ok, backupName, fileExtension := isLegacyBackup(strings.TrimPrefix(o.Name(), "/")); ok {
 			result = append(result, Backup{
@@ -208,7 +226,7 @@ func (bd *BackupDestination) BackupList(parseMetadata bool, parseMetadataOnly st
 			result = append(result, cachedMetadata)
 			return nil
 		}
-		mf, err := bd.StatFile(path.Join(o.Name(), "metadata.json"))
+		mf, err := bd.StatFile(ctx, path.Join(o.Name(), "metadata.json"))
 		if err != nil {
 			brokenBackup := Backup{
 				metadata.BackupMetadata{
@@ -222,7 +240,7 @@ func (bd *BackupDestination) BackupList(parseMetadata bool, parseMetadataOnly st
 			result = append(result, brokenBackup)
 			return nil
 		}
-		r, err := bd.GetFileReader(path.Join(o.Name(), "metadata.json"))
+		r, err := bd.GetFileReader(ctx, path.Join(o.Name(), "metadata.json"))
 		if err != nil {
 			brokenBackup := Backup{
 				metadata.BackupMetadata{
@@ -267,6 +285,10 @@ func (bd *BackupDestination) BackupList(parseMetadata bool, parseMetadataOnly st
 			result = append(result, brokenBackup)
 			return nil
 		}
+		if err := m.CheckVersion(true); err != nil {
+			result = append(result, Backup{m, false, "", fmt.Sprintf("broken (%v)", err), mf.LastModified()})
+			return nil
+		}
 		goodBackup := Backup{
 			m, false, "", "", mf.LastModified(),
 		}
@@ -294,29 +316,56 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 		return err
 	}
 	// get this first as GetFileReader blocks the ftp control channel
-	file, err := bd.StatFile(remotePath)
+	file, err := bd.StatFile(ctx, remotePath)
 	if err != nil {
 		return err
 	}
 	filesize := file.Size()
 
-	reader, err := bd.GetFileReaderWithLocalPath(remotePath, localPath)
+	reader, err := bd.GetFileReaderWithLocalPath(ctx, remotePath, localPath)
 	if err != nil {
 		return err
 	}
+	reader = bd.newRetryReader(ctx, remotePath, 0, reader)
 	defer func() {
 		if err := reader.Close(); err != nil {
-			apexLog.Warnf("can't close GetFileReader descriptor %v", reader)
+			apexLog.Warnf("can't close GetFileReader descriptor %v: %v", reader, err)
 		}
-		switch reader.(type) {
-		case *os.File:
-			fileName := reader.(*os.File).Name()
-			if err := os.Remove(fileName); err != nil {
-				apexLog.Warnf("can't remove %s", fileName)
-			}
+	}()
+	return bd.extractArchive(ctx, reader, filesize, remotePath, localPath)
+}
+
+// ExtractArchiveFile - extract archive downloaded before into localPath, remotePath define compression format by extension
+func (bd *BackupDestination) ExtractArchiveFile(ctx context.Context, archivePath, remotePath, localPath string) error {
+	if err := os.MkdirAll(localPath, 0750); err != nil {
+		return err
+	}
+	reader, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			apexLog.Warnf("can't close %s: %v", archivePath, err)
 		}
 	}()
+	info, err := reader.Stat()
+	if err != nil {
+		return err
+	}
+	return bd.extractArchive(ctx, reader, info.Size(), remotePath, localPath)
+}
+
+// DownloadFile - download remote file as is into localFile without decompression
+func (bd *BackupDestination) DownloadFile(ctx context.Context, remotePath, localFile string) error {
+	file, err := bd.StatFile(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	return bd.downloadFile(ctx, remotePath, file.Size(), localFile, nil)
+}
 
+func (bd *BackupDestination) extractArchive(ctx context.Context, reader io.Reader, filesize int64, remotePath, localPath string) error {
 	bar := progressbar.StartNewByteBar(!bd.disableProgressBar, filesize)
 	buf := buffer.New(BufferSize)
 	defer bar.Finish()
@@ -373,8 +422,58 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 	return nil
 }
 
-func (bd *BackupDestination) UploadCompressedStream(baseLocalPath string, files []string, remotePath string) error {
-	if _, err := bd.StatFile(remotePath); err != nil {
+// ReadCompressedStream - decompress remote archive on the fly and pass each regular file to fn without writing on local disk
+func (bd *BackupDestination) ReadCompressedStream(ctx context.Context, remotePath string, fn func(name string, r io.Reader) error) error {
+	// get this first as GetFileReader blocks the ftp control channel
+	if _, err := bd.StatFile(ctx, remotePath); err != nil {
+		return err
+	}
+	reader, err := bd.GetFileReader(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			apexLog.Warnf("can't close GetFileReader descriptor %v", reader)
+		}
+	}()
+	buf := buffer.New(BufferSize)
+	bufReader := nio.NewReader(reader, buf)
+	compressionFormat := bd.compressionFormat
+	if !checkArchiveExtension(path.Ext(remotePath), compressionFormat) {
+		compressionFormat = strings.Replace(path.Ext(remotePath), ".", "", -1)
+	}
+	z, err := getArchiveReader(compressionFormat)
+	if err != nil {
+		return err
+	}
+	return z.Extract(ctx, bufReader, nil, func(ctx context.Context, file archiver.File) error {
+		header, ok := file.Header.(*tar.Header)
+		if !ok {
+			return fmt.Errorf("expected header to be *tar.Header but was %T", file.Header)
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			return nil
+		}
+		f, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("can't open %s", file.NameInArchive)
+		}
+		defer f.Close()
+		return fn(header.Name, readerWrapperForContext(func(p []byte) (int, error) {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			default:
+				return f.Read(p)
+			}
+		}))
+	})
+}
+
+// UploadCompressedStream - archive files on the fly and upload archive to remotePath, state is optional and allow resume interrupted upload
+func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLocalPath string, files []string, remotePath string, state UploadState) error {
+	if _, err := bd.StatFile(ctx, remotePath); err != nil {
 		if err != ErrNotFound && !os.IsNotExist(err) {
 			return err
 		}
@@ -393,7 +492,7 @@ func (bd *BackupDestination) UploadCompressedStream(baseLocalPath string, files
 	defer bar.Finish()
 	pipeBuffer := buffer.New(BufferSize)
 	body, w := nio.Pipe(pipeBuffer)
-	g, ctx := errgroup.WithContext(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
 
 	var writerErr, readerErr error
 	g.Go(func() error {
@@ -450,18 +549,19 @@ func (bd *BackupDestination) UploadCompressedStream(baseLocalPath string, files
 				}
 			}
 		}()
-		readerErr = bd.PutFile(remotePath, body)
+		readerErr = bd.putFile(ctx, remotePath, body, state)
 		return readerErr
 	})
 	return g.Wait()
 }
 
-func (bd *BackupDestination) DownloadPath(size int64, remotePath string, localPath string) error {
+// DownloadPath - download all files of remotePath, state is optional and allow resume interrupted download
+func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remotePath string, localPath string, state DownloadState) error {
 	var bar *progressbar.Bar
 	if !bd.disableProgressBar {
 		totalBytes := size
 		if size == 0 {
-			if err := bd.Walk(remotePath, true, func(f RemoteFile) error {
+			if err := bd.Walk(ctx, remotePath, true, func(f RemoteFile) error {
 				totalBytes += f.Size()
 				return nil
 			}); err != nil {
@@ -475,35 +575,19 @@ func (bd *BackupDestination) DownloadPath(size int64, remotePath string, localPa
 		"path":      remotePath,
 		"operation": "download",
 	})
-	return bd.Walk(remotePath, true, func(f RemoteFile) error {
-		// TODO: return err break download, think about make Walk error handle and retry
-		r, err := bd.GetFileReader(path.Join(remotePath, f.Name()))
-		if err != nil {
-			log.Error(err.Error())
-			return err
-		}
-		dstFilePath := path.Join(localPath, f.Name())
-		dstDirPath, _ := path.Split(dstFilePath)
-		if err := os.MkdirAll(dstDirPath, 0750); err != nil {
-			log.Error(err.Error())
-			return err
-		}
-		dst, err := os.Create(dstFilePath)
-		if err != nil {
-			log.Error(err.Error())
-			return err
-		}
-		if _, err := io.CopyBuffer(dst, r, nil); err != nil {
-			log.Error(err.Error())
-			return err
-		}
-		if err := dst.Close(); err != nil {
-			log.Error(err.Error())
-			return err
-		}
-		if err := r.Close(); err != nil {
-			log.Error(err.Error())
-			return err
+	return bd.Walk(ctx, remotePath, true, func(f RemoteFile) error {
+		key := path.Join(remotePath, f.Name())
+		if state == nil || !state.IsCompleted(key) {
+			dstFilePath := path.Join(localPath, f.Name())
+			dstDirPath, _ := path.Split(dstFilePath)
+			if err := os.MkdirAll(dstDirPath, 0750); err != nil {
+				log.Error(err.Error())
+				return err
+			}
+			if err := bd.downloadFile(ctx, key, f.Size(), dstFilePath, state); err != nil {
+				log.Error(err.Error())
+				return err
+			}
 		}
 		if !bd.disableProgressBar {
 			bar.Add64(f.Size())
@@ -512,7 +596,8 @@ func (bd *BackupDestination) DownloadPath(size int64, remotePath string, localPa
 	})
 }
 
-func (bd *BackupDestination) UploadPath(size int64, baseLocalPath string, files []string, remotePath string) error {
+// UploadPath - upload files as is, state is optional and allow resume interrupted upload
+func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLocalPath string, files []string, remotePath string, state UploadState) error {
 	var bar *progressbar.Bar
 	if !bd.disableProgressBar {
 		totalBytes := size
@@ -536,7 +621,7 @@ func (bd *BackupDestination) UploadPath(size int64, baseLocalPath string, files
 		if err != nil {
 			return err
 		}
-		if err := bd.PutFile(path.Join(remotePath, filename), f); err != nil {
+		if err := bd.putFile(ctx, path.Join(remotePath, filename), f, state); err != nil {
 			return err
 		}
 		fi, err := f.Stat()
@@ -554,6 +639,17 @@ func (bd *BackupDestination) UploadPath(size int64, baseLocalPath string, files
 	return nil
 }
 
+// applyPathTemplate - replace macros in remote path, static part of path template is kept in remotePath, levels of backup folders are returned,
+// remotePath shall be a field of storage config copy, so template in config is kept for next NewBackupDestination
+func applyPathTemplate(cfg *config.Config, remotePath *string) ([]string, error) {
+	rootPath, levels, err := splitPathTemplate(clickhouse.ApplyMacros(cfg, *remotePath))
+	if err != nil {
+		return nil, err
+	}
+	*remotePath = rootPath
+	return levels, nil
+}
+
 func NewBackupDestination(cfg *config.Config, calcMaxSize bool) (*BackupDestination, error) {
 	// https://github.com/mxalis/clickhouse-backup/issues/404
 	if calcMaxSize {
@@ -568,10 +664,16 @@ func NewBackupDestination(cfg *config.Config, calcMaxSize bool) (*BackupDestinat
 			cfg.General.MaxFileSize = maxFileSize
 		}
 	}
+	setBandwidthLimits(cfg)
+	deleteConcurrency = cfg.General.DeleteConcurrency
 	switch cfg.General.RemoteStorage {
 	case "azblob":
-		azblobStorage := &AzureBlob{Config: &cfg.AzureBlob}
-		azblobStorage.Config.Path = clickhouse.ApplyMacros(cfg, azblobStorage.Config.Path)
+		azblobConfig := cfg.AzureBlob
+		azblobStorage := &AzureBlob{Config: &azblobConfig}
+		levels, err := applyPathTemplate(cfg, &azblobStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
 		bufferSize := azblobStorage.Config.BufferSize
 		// https://github.com/mxalis/clickhouse-backup/issues/317
 		if bufferSize <= 0 {
@@ -583,12 +685,21 @@ func NewBackupDestination(cfg *config.Config, calcMaxSize bool) (*BackupDestinat
 				bufferSize = 10 * 1024 * 1024
 			}
 		}
-		azblobStorage.Config.BufferSize = bufferSize
+		memory := fitMemoryBudget(cfg, transferMemory{
+			partSize:        int64(bufferSize),
+			minPartSize:     minPartSize(cfg.General.MaxFileSize, int64(cfg.AzureBlob.MaxPartsCount), 2*1024*1024),
+			partConcurrency: cfg.AzureBlob.MaxBuffers,
+			fixed:           BufferSize,
+			compression:     isMultithreadedCompression(cfg.AzureBlob.CompressionFormat),
+		})
+		azblobStorage.Config.BufferSize = int(memory.partSize)
+		azblobStorage.Config.MaxBuffers = memory.partConcurrency
 		return &BackupDestination{
-			azblobStorage,
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(azblobStorage, cfg), cfg), levels),
 			cfg.AzureBlob.CompressionFormat,
 			cfg.AzureBlob.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
 		}, nil
 	case "s3":
 		partSize := cfg.S3.PartSize
@@ -601,58 +712,109 @@ func NewBackupDestination(cfg *config.Config, calcMaxSize bool) (*BackupDestinat
 				partSize = 5 * 1024 * 1024 * 1024
 			}
 		}
+		memory := fitMemoryBudget(cfg, transferMemory{
+			partSize:        partSize,
+			minPartSize:     minPartSize(cfg.General.MaxFileSize, cfg.S3.MaxPartsCount, 5*1024*1024),
+			partConcurrency: cfg.S3.Concurrency,
+			fixed:           BufferSize + 1024*1024,
+			compression:     isMultithreadedCompression(cfg.S3.CompressionFormat),
+		})
+		s3Config := cfg.S3
 		s3Storage := &S3{
-			Config:      &cfg.S3,
-			Concurrency: cfg.S3.Concurrency,
+			Config:      &s3Config,
+			Concurrency: memory.partConcurrency,
 			BufferSize:  1024 * 1024,
-			PartSize:    partSize,
+			PartSize:    memory.partSize,
+		}
+		levels, err := applyPathTemplate(cfg, &s3Storage.Config.Path)
+		if err != nil {
+			return nil, err
 		}
-		s3Storage.Config.Path = clickhouse.ApplyMacros(cfg, s3Storage.Config.Path)
 		return &BackupDestination{
-			s3Storage,
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(s3Storage, cfg), cfg), levels),
 			cfg.S3.CompressionFormat,
 			cfg.S3.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
 		}, nil
 	case "gcs":
-		googleCloudStorage := &GCS{Config: &cfg.GCS}
-		googleCloudStorage.Config.Path = clickhouse.ApplyMacros(cfg, googleCloudStorage.Config.Path)
+		gcsConfig := cfg.GCS
+		googleCloudStorage := &GCS{Config: &gcsConfig}
+		levels, err := applyPathTemplate(cfg, &googleCloudStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		fitMemoryBudget(cfg, transferMemory{fixed: BufferSize + gcsChunkSize, compression: isMultithreadedCompression(cfg.GCS.CompressionFormat)})
 		return &BackupDestination{
-			googleCloudStorage,
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(googleCloudStorage, cfg), cfg), levels),
 			cfg.GCS.CompressionFormat,
 			cfg.GCS.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
 		}, nil
 	case "cos":
-		tencentStorage := &COS{Config: &cfg.COS}
-		tencentStorage.Config.Path = clickhouse.ApplyMacros(cfg, tencentStorage.Config.Path)
+		cosConfig := cfg.COS
+		tencentStorage := &COS{Config: &cosConfig}
+		levels, err := applyPathTemplate(cfg, &tencentStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		fitMemoryBudget(cfg, transferMemory{fixed: BufferSize, compression: isMultithreadedCompression(cfg.COS.CompressionFormat)})
 		return &BackupDestination{
-			tencentStorage,
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(tencentStorage, cfg), cfg), levels),
 			cfg.COS.CompressionFormat,
 			cfg.COS.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
 		}, nil
 	case "ftp":
+		ftpConfig := cfg.FTP
 		ftpStorage := &FTP{
-			Config: &cfg.FTP,
+			Config: &ftpConfig,
+		}
+		levels, err := applyPathTemplate(cfg, &ftpStorage.Config.Path)
+		if err != nil {
+			return nil, err
 		}
-		ftpStorage.Config.Path = clickhouse.ApplyMacros(cfg, ftpStorage.Config.Path)
+		fitMemoryBudget(cfg, transferMemory{fixed: BufferSize, compression: isMultithreadedCompression(cfg.FTP.CompressionFormat)})
 		return &BackupDestination{
-			ftpStorage,
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(ftpStorage, cfg), cfg), levels),
 			cfg.FTP.CompressionFormat,
 			cfg.FTP.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
 		}, nil
 	case "sftp":
+		sftpConfig := cfg.SFTP
 		sftpStorage := &SFTP{
-			Config: &cfg.SFTP,
+			Config: &sftpConfig,
 		}
-		sftpStorage.Config.Path = clickhouse.ApplyMacros(cfg, sftpStorage.Config.Path)
+		levels, err := applyPathTemplate(cfg, &sftpStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		fitMemoryBudget(cfg, transferMemory{fixed: BufferSize, compression: isMultithreadedCompression(cfg.SFTP.CompressionFormat)})
 		return &BackupDestination{
-			sftpStorage,
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(sftpStorage, cfg), cfg), levels),
 			cfg.SFTP.CompressionFormat,
 			cfg.SFTP.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
+		}, nil
+	case "grpc":
+		grpcConfig := cfg.GRPC
+		grpcStorage := &GRPC{Config: &grpcConfig}
+		levels, err := applyPathTemplate(cfg, &grpcStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		fitMemoryBudget(cfg, transferMemory{fixed: BufferSize + 2*grpcChunkSize, compression: isMultithreadedCompression(cfg.GRPC.CompressionFormat)})
+		return &BackupDestination{
+			newLayoutStorage(newRetryStorage(newInstrumentedStorage(grpcStorage, cfg), cfg), levels),
+			cfg.GRPC.CompressionFormat,
+			cfg.GRPC.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			cfg.General.DownloadRetries,
 		}, nil
 	default:
 		return nil, fmt.Errorf("storage type '%s' is not supported", cfg.General.RemoteStorage)
@@ -0,0 +1,493 @@
+package new_storage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcServiceName = "clickhouse_backup.storage.v1.RemoteStorage"
+	// grpcChunkSize - size of data in one GetFile and PutFile message, less than default 4MiB limit of gRPC message
+	grpcChunkSize = 1024 * 1024
+)
+
+// GRPC - RemoteStorage implemented by out-of-tree plugin, see grpc_storage.proto
+type GRPC struct {
+	Config *config.GRPCConfig
+	conn   *grpc.ClientConn
+}
+
+type grpcFileInfo struct {
+	file grpcFile
+}
+
+func (f *grpcFileInfo) Size() int64             { return f.file.Size }
+func (f *grpcFileInfo) Name() string            { return f.file.Name }
+func (f *grpcFileInfo) LastModified() time.Time { return time.Unix(0, f.file.LastModified) }
+func (f *grpcFileInfo) ETag() string            { return f.file.ETag }
+func (f *grpcFileInfo) Checksum() string        { return f.file.Checksum }
+
+// grpcToken - `authorization` metadata of each request, plugin usually listen on localhost, so token is allowed without TLS
+type grpcToken string
+
+func (t grpcToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t grpcToken) RequireTransportSecurity() bool {
+	return false
+}
+
+func (g *GRPC) Kind() string {
+	return "GRPC"
+}
+
+func (g *GRPC) transportCredentials() (credentials.TransportCredentials, error) {
+	if !g.Config.TLS {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if g.Config.TLSCa != "" {
+		ca, err := ioutil.ReadFile(g.Config.TLSCa)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("can't parse certificates from grpc->tls_ca %s", g.Config.TLSCa)
+		}
+	}
+	if g.Config.TLSCert != "" || g.Config.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(g.Config.TLSCert, g.Config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (g *GRPC) Connect(ctx context.Context) error {
+	if g.conn == nil {
+		creds, err := g.transportCredentials()
+		if err != nil {
+			return err
+		}
+		options := []grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcCodec{})),
+			grpc.WithBlock(),
+		}
+		if g.Config.Token != "" {
+			options = append(options, grpc.WithPerRPCCredentials(grpcToken(g.Config.Token)))
+		}
+		timeout, err := time.ParseDuration(g.Config.Timeout)
+		if err != nil {
+			return err
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if g.conn, err = grpc.DialContext(dialCtx, g.Config.Address, options...); err != nil {
+			return fmt.Errorf("can't connect to storage plugin %s: %v", g.Config.Address, err)
+		}
+	}
+	return g.invoke(ctx, "Connect", &grpcEmpty{}, &grpcEmpty{})
+}
+
+func (g *GRPC) invoke(ctx context.Context, method string, request, response grpcMessage) error {
+	return fromGRPCError(g.conn.Invoke(ctx, "/"+grpcServiceName+"/"+method, request, response))
+}
+
+// fromGRPCError - NOT_FOUND is ErrNotFound, so missing files are handled the same as for other storages
+func fromGRPCError(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (g *GRPC) key(key string) string {
+	return strings.TrimPrefix(path.Join(g.Config.Path, key), "/")
+}
+
+func (g *GRPC) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	f := &grpcFileInfo{}
+	if err := g.invoke(ctx, "StatFile", &grpcKeyRequest{Key: g.key(key)}, &f.file); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (g *GRPC) DeleteFile(ctx context.Context, key string) error {
+	return g.invoke(ctx, "DeleteFile", &grpcKeyRequest{Key: g.key(key)}, &grpcEmpty{})
+}
+
+func (g *GRPC) DeleteFiles(ctx context.Context, keys []string) error {
+	request := &grpcKeysRequest{Keys: make([]string, len(keys))}
+	for i, key := range keys {
+		request.Keys[i] = g.key(key)
+	}
+	return g.invoke(ctx, "DeleteFiles", request, &grpcEmpty{})
+}
+
+func (g *GRPC) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	return g.invoke(ctx, "CopyFile", &grpcCopyRequest{SrcKey: g.key(srcKey), DstKey: g.key(dstKey)}, &grpcEmpty{})
+}
+
+func (g *GRPC) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	return g.invoke(ctx, "MoveFile", &grpcCopyRequest{SrcKey: g.key(srcKey), DstKey: g.key(dstKey)}, &grpcEmpty{})
+}
+
+// Walk - stream is canceled when fn return error
+func (g *GRPC) Walk(ctx context.Context, prefix string, recursive bool, fn func(RemoteFile) error) error {
+	walkPrefix := g.key(prefix)
+	if strings.HasSuffix(prefix, "/") && walkPrefix != "" {
+		walkPrefix += "/"
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+grpcServiceName+"/Walk")
+	if err != nil {
+		return fromGRPCError(err)
+	}
+	if err := stream.SendMsg(&grpcWalkRequest{Prefix: walkPrefix, Recursive: recursive}); err != nil {
+		return fromGRPCError(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fromGRPCError(err)
+	}
+	for {
+		f := &grpcFileInfo{}
+		if err := stream.RecvMsg(&f.file); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fromGRPCError(err)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcFileReader - read chunks of GetFile stream, Close cancel stream when it is not finished
+type grpcFileReader struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	chunk  []byte
+	err    error
+}
+
+func (r *grpcFileReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		msg := &grpcChunk{}
+		if err := r.stream.RecvMsg(msg); err != nil {
+			r.err = fromGRPCError(err)
+			continue
+		}
+		r.chunk = msg.Data
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *grpcFileReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+func (g *GRPC) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return g.GetFileReaderAt(ctx, key, 0)
+}
+
+func (g *GRPC) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return g.GetFileReaderAt(ctx, key, 0)
+}
+
+// GetFileReaderAt - the first chunk is received before return, so missing file is reported like by other storages
+func (g *GRPC) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+grpcServiceName+"/GetFile")
+	if err == nil {
+		err = stream.SendMsg(&grpcReadRequest{Key: g.key(key), Offset: offset})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		cancel()
+		return nil, fromGRPCError(err)
+	}
+	reader := &grpcFileReader{stream: stream, cancel: cancel}
+	first := &grpcChunk{}
+	if err := stream.RecvMsg(first); err != nil && err != io.EOF {
+		cancel()
+		return nil, fromGRPCError(err)
+	} else if err == io.EOF {
+		reader.err = io.EOF
+	}
+	reader.chunk = first.Data
+	return reader, nil
+}
+
+// PutFile - stream is canceled on read error, so plugin doesn't finish incomplete object
+func (g *GRPC) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/"+grpcServiceName+"/PutFile")
+	if err != nil {
+		return fromGRPCError(err)
+	}
+	buf := make([]byte, grpcChunkSize)
+	msg := &grpcWriteRequest{Key: g.key(key)}
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		msg.Data = buf[:n]
+		if n > 0 || msg.Key != "" {
+			if err := stream.SendMsg(msg); err == io.EOF {
+				// plugin finished stream, error is returned by RecvMsg
+				break
+			} else if err != nil {
+				return fromGRPCError(err)
+			}
+		}
+		msg.Key = ""
+		if readErr != nil {
+			break
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fromGRPCError(err)
+	}
+	return fromGRPCError(stream.RecvMsg(&grpcEmpty{}))
+}
+
+// toGRPCError - missing files are NOT_FOUND, errors retried for other storages are UNAVAILABLE, so client retry them too
+func toGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, isStatus := status.FromError(err); isStatus {
+		return err
+	}
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrFileDoesNotExist), errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, os.ErrPermission):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case isRetryableError(err):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+// NewGRPCStorageServer - gRPC server of storage plugin protocol, so plugin could be written in Go with implementation of RemoteStorage,
+// when token is not empty requests without `authorization: Bearer <token>` metadata are rejected
+func NewGRPCStorageServer(storage RemoteStorage, token string, options ...grpc.ServerOption) *grpc.Server {
+	options = append(options, grpc.ForceServerCodec(grpcCodec{}))
+	if token != "" {
+		checkToken := func(ctx context.Context) error {
+			md, _ := metadata.FromIncomingContext(ctx)
+			for _, v := range md.Get("authorization") {
+				if v == "Bearer "+token {
+					return nil
+				}
+			}
+			return status.Error(codes.Unauthenticated, "wrong authorization token")
+		}
+		options = append(options,
+			grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := checkToken(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkToken(ss.Context()); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+	server := grpc.NewServer(options...)
+	server.RegisterService(&grpcStorageServiceDesc, storage)
+	return server
+}
+
+// grpcUnaryHandler - decode request into new message, call storage and encode result, interceptors are applied
+func grpcUnaryHandler(method string, newRequest func() grpcMessage, call func(ctx context.Context, storage RemoteStorage, request grpcMessage) (grpcMessage, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			request := newRequest()
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				response, err := call(ctx, srv.(RemoteStorage), req.(grpcMessage))
+				return response, toGRPCError(err)
+			}
+			if interceptor == nil {
+				return handler(ctx, request)
+			}
+			return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcServiceName + "/" + method}, handler)
+		},
+	}
+}
+
+var grpcStorageServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*RemoteStorage)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcUnaryHandler("Connect", func() grpcMessage { return &grpcEmpty{} }, func(ctx context.Context, storage RemoteStorage, _ grpcMessage) (grpcMessage, error) {
+			return &grpcEmpty{}, storage.Connect(ctx)
+		}),
+		grpcUnaryHandler("StatFile", func() grpcMessage { return &grpcKeyRequest{} }, func(ctx context.Context, storage RemoteStorage, request grpcMessage) (grpcMessage, error) {
+			f, err := storage.StatFile(ctx, request.(*grpcKeyRequest).Key)
+			if err != nil {
+				return nil, err
+			}
+			return &grpcFile{Name: f.Name(), Size: f.Size(), LastModified: f.LastModified().UnixNano(), ETag: f.ETag(), Checksum: f.Checksum()}, nil
+		}),
+		grpcUnaryHandler("DeleteFile", func() grpcMessage { return &grpcKeyRequest{} }, func(ctx context.Context, storage RemoteStorage, request grpcMessage) (grpcMessage, error) {
+			err := storage.DeleteFile(ctx, request.(*grpcKeyRequest).Key)
+			if errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+			return &grpcEmpty{}, err
+		}),
+		grpcUnaryHandler("DeleteFiles", func() grpcMessage { return &grpcKeysRequest{} }, func(ctx context.Context, storage RemoteStorage, request grpcMessage) (grpcMessage, error) {
+			return &grpcEmpty{}, storage.DeleteFiles(ctx, request.(*grpcKeysRequest).Keys)
+		}),
+		grpcUnaryHandler("CopyFile", func() grpcMessage { return &grpcCopyRequest{} }, func(ctx context.Context, storage RemoteStorage, request grpcMessage) (grpcMessage, error) {
+			return &grpcEmpty{}, storage.CopyFile(ctx, request.(*grpcCopyRequest).SrcKey, request.(*grpcCopyRequest).DstKey)
+		}),
+		grpcUnaryHandler("MoveFile", func() grpcMessage { return &grpcCopyRequest{} }, func(ctx context.Context, storage RemoteStorage, request grpcMessage) (grpcMessage, error) {
+			return &grpcEmpty{}, storage.MoveFile(ctx, request.(*grpcCopyRequest).SrcKey, request.(*grpcCopyRequest).DstKey)
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Walk", ServerStreams: true, Handler: grpcServeWalk},
+		{StreamName: "GetFile", ServerStreams: true, Handler: grpcServeGetFile},
+		{StreamName: "PutFile", ClientStreams: true, Handler: grpcServePutFile},
+	},
+	Metadata: "grpc_storage.proto",
+}
+
+func grpcServeWalk(srv interface{}, stream grpc.ServerStream) error {
+	request := &grpcWalkRequest{}
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	return toGRPCError(srv.(RemoteStorage).Walk(stream.Context(), request.Prefix, request.Recursive, func(f RemoteFile) error {
+		return stream.SendMsg(&grpcFile{Name: f.Name(), Size: f.Size(), LastModified: f.LastModified().UnixNano(), ETag: f.ETag(), Checksum: f.Checksum()})
+	}))
+}
+
+// grpcServeGetFile - offset is skipped by reading when storage doesn't implement RangeReader
+func grpcServeGetFile(srv interface{}, stream grpc.ServerStream) error {
+	request := &grpcReadRequest{}
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	storage := srv.(RemoteStorage)
+	var reader io.ReadCloser
+	var err error
+	if rangeReader, ok := unwrapStorage(storage).(RangeReader); ok && request.Offset > 0 {
+		reader, err = rangeReader.GetFileReaderAt(stream.Context(), request.Key, request.Offset)
+	} else {
+		reader, err = storage.GetFileReader(stream.Context(), request.Key)
+		if err == nil && request.Offset > 0 {
+			_, err = io.CopyN(ioutil.Discard, reader, request.Offset)
+		}
+	}
+	if err != nil {
+		if reader != nil {
+			_ = reader.Close()
+		}
+		return toGRPCError(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		if n > 0 {
+			if err := stream.SendMsg(&grpcChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return toGRPCError(readErr)
+		}
+	}
+}
+
+// grpcServePutFile - chunks are written into pipe read by storage, pipe is closed with error when client stream is broken
+func grpcServePutFile(srv interface{}, stream grpc.ServerStream) error {
+	msg := &grpcWriteRequest{}
+	if err := stream.RecvMsg(msg); err != nil {
+		return err
+	}
+	if msg.Key == "" {
+		return status.Error(codes.InvalidArgument, "key shall be defined in the first PutFile message")
+	}
+	key := msg.Key
+	pipeReader, pipeWriter := io.Pipe()
+	putErr := make(chan error, 1)
+	go func() {
+		err := srv.(RemoteStorage).PutFile(stream.Context(), key, pipeReader)
+		_ = pipeReader.CloseWithError(err)
+		putErr <- err
+	}()
+	for {
+		if len(msg.Data) > 0 {
+			if _, err := pipeWriter.Write(msg.Data); err != nil {
+				break
+			}
+		}
+		msg = &grpcWriteRequest{}
+		if err := stream.RecvMsg(msg); err == io.EOF {
+			_ = pipeWriter.Close()
+			break
+		} else if err != nil {
+			_ = pipeWriter.CloseWithError(err)
+			break
+		}
+	}
+	if err := <-putErr; err != nil {
+		return toGRPCError(err)
+	}
+	return stream.SendMsg(&grpcEmpty{})
+}
@@ -0,0 +1,264 @@
+package new_storage
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcMessage - messages of grpc_storage.proto encoded with protobuf wire format without generated code,
+// so plugins could be written in any language with code generated from grpc_storage.proto
+type grpcMessage interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+// grpcCodec - replace default codec, which requires generated proto.Message, content-subtype is kept `proto`
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("can't marshal %T, it is not storage plugin message", v)
+	}
+	return m.marshal(), nil
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return fmt.Errorf("can't unmarshal %T, it is not storage plugin message", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+// wireValue - value of one field, varint for integer and bool fields, bytes for string, bytes and embedded message fields, bytes refer to received buffer
+type wireValue struct {
+	varint uint64
+	bytes  []byte
+}
+
+// consumeFields - call field for each known type field, repeated fields are passed one by one, unknown field types are skipped
+func consumeFields(b []byte, field func(num protowire.Number, v wireValue)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field(num, wireValue{varint: v})
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field(num, wireValue{bytes: v})
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// appendString, appendBytes and appendVarint - zero values are not written, the same as proto3 scalar fields
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+}
+
+type grpcEmpty struct{}
+
+func (m *grpcEmpty) marshal() []byte { return nil }
+func (m *grpcEmpty) unmarshal(b []byte) error {
+	return consumeFields(b, func(protowire.Number, wireValue) {})
+}
+
+type grpcKeyRequest struct {
+	Key string
+}
+
+func (m *grpcKeyRequest) marshal() []byte { return appendString(nil, 1, m.Key) }
+func (m *grpcKeyRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		if num == 1 {
+			m.Key = string(v.bytes)
+		}
+	})
+}
+
+type grpcKeysRequest struct {
+	Keys []string
+}
+
+func (m *grpcKeysRequest) marshal() []byte {
+	var b []byte
+	for _, key := range m.Keys {
+		// repeated string element is written even when empty
+		b = protowire.AppendString(protowire.AppendTag(b, 1, protowire.BytesType), key)
+	}
+	return b
+}
+func (m *grpcKeysRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		if num == 1 {
+			m.Keys = append(m.Keys, string(v.bytes))
+		}
+	})
+}
+
+type grpcWalkRequest struct {
+	Prefix    string
+	Recursive bool
+}
+
+func (m *grpcWalkRequest) marshal() []byte {
+	b := appendString(nil, 1, m.Prefix)
+	if m.Recursive {
+		b = appendVarint(b, 2, 1)
+	}
+	return b
+}
+func (m *grpcWalkRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		switch num {
+		case 1:
+			m.Prefix = string(v.bytes)
+		case 2:
+			m.Recursive = v.varint != 0
+		}
+	})
+}
+
+type grpcReadRequest struct {
+	Key    string
+	Offset int64
+}
+
+func (m *grpcReadRequest) marshal() []byte {
+	return appendVarint(appendString(nil, 1, m.Key), 2, uint64(m.Offset))
+}
+func (m *grpcReadRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		switch num {
+		case 1:
+			m.Key = string(v.bytes)
+		case 2:
+			m.Offset = int64(v.varint)
+		}
+	})
+}
+
+type grpcWriteRequest struct {
+	Key  string
+	Data []byte
+}
+
+func (m *grpcWriteRequest) marshal() []byte {
+	return appendBytes(appendString(nil, 1, m.Key), 2, m.Data)
+}
+func (m *grpcWriteRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		switch num {
+		case 1:
+			m.Key = string(v.bytes)
+		case 2:
+			m.Data = append([]byte(nil), v.bytes...)
+		}
+	})
+}
+
+type grpcCopyRequest struct {
+	SrcKey string
+	DstKey string
+}
+
+func (m *grpcCopyRequest) marshal() []byte {
+	return appendString(appendString(nil, 1, m.SrcKey), 2, m.DstKey)
+}
+func (m *grpcCopyRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		switch num {
+		case 1:
+			m.SrcKey = string(v.bytes)
+		case 2:
+			m.DstKey = string(v.bytes)
+		}
+	})
+}
+
+type grpcChunk struct {
+	Data []byte
+}
+
+func (m *grpcChunk) marshal() []byte { return appendBytes(nil, 1, m.Data) }
+func (m *grpcChunk) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		if num == 1 {
+			m.Data = append([]byte(nil), v.bytes...)
+		}
+	})
+}
+
+type grpcFile struct {
+	Name         string
+	Size         int64
+	LastModified int64
+	ETag         string
+	Checksum     string
+}
+
+func (m *grpcFile) marshal() []byte {
+	b := appendString(nil, 1, m.Name)
+	b = appendVarint(b, 2, uint64(m.Size))
+	b = appendVarint(b, 3, uint64(m.LastModified))
+	b = appendString(b, 4, m.ETag)
+	return appendString(b, 5, m.Checksum)
+}
+func (m *grpcFile) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v wireValue) {
+		switch num {
+		case 1:
+			m.Name = string(v.bytes)
+		case 2:
+			m.Size = int64(v.varint)
+		case 3:
+			m.LastModified = int64(v.varint)
+		case 4:
+			m.ETag = string(v.bytes)
+		case 5:
+			m.Checksum = string(v.bytes)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package new_storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// pluginStorage - rangeStorage with writes, listing and deletes, served by NewGRPCStorageServer in tests
+type pluginStorage struct {
+	rangeStorage
+	mu sync.Mutex
+}
+
+func (s *pluginStorage) StatFile(_ context.Context, key string) (RemoteFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if content, exists := s.files[key]; exists {
+		return &grpcFileInfo{file: grpcFile{Name: key, Size: int64(len(content)), LastModified: time.Unix(1, 0).UnixNano(), Checksum: "abc"}}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *pluginStorage) PutFile(_ context.Context, key string, r io.ReadCloser) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = content
+	return nil
+}
+
+func (s *pluginStorage) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.files[key]; !exists {
+		return nil, ErrNotFound
+	}
+	return s.rangeStorage.GetFileReaderAt(ctx, key, offset)
+}
+
+func (s *pluginStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetFileReaderAt(ctx, key, 0)
+}
+
+func (s *pluginStorage) DeleteFile(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.files[key]; !exists {
+		return ErrNotFound
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *pluginStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := s.DeleteFile(ctx, key); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pluginStorage) CopyFile(_ context.Context, srcKey, dstKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, exists := s.files[srcKey]
+	if !exists {
+		return ErrNotFound
+	}
+	s.files[dstKey] = content
+	return nil
+}
+
+func (s *pluginStorage) Walk(_ context.Context, prefix string, _ bool, fn func(RemoteFile) error) error {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.files))
+	for key := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(&grpcFileInfo{file: grpcFile{Name: strings.TrimPrefix(key, prefix), Size: int64(len(s.files[key]))}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTestGRPCStorage - plugin served over in-memory connection, client connection is created before Connect
+func newTestGRPCStorage(t *testing.T, storage RemoteStorage, serverToken, clientToken string) *GRPC {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewGRPCStorageServer(storage, serverToken)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	cfg := config.DefaultConfig()
+	cfg.GRPC.Path = "/backups"
+	cfg.GRPC.Token = clientToken
+	options := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcCodec{})),
+	}
+	if clientToken != "" {
+		options = append(options, grpc.WithPerRPCCredentials(grpcToken(clientToken)))
+	}
+	conn, err := grpc.Dial("bufnet", options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &GRPC{Config: &cfg.GRPC, conn: conn}
+}
+
+func TestGRPCStorage(t *testing.T) {
+	storage := &pluginStorage{rangeStorage: rangeStorage{files: map[string][]byte{}}}
+	g := newTestGRPCStorage(t, storage, "secret", "secret")
+	ctx := context.Background()
+	require.NoError(t, g.Connect(ctx))
+
+	content := bytes.Repeat([]byte("0123456789"), grpcChunkSize/4)
+	require.NoError(t, g.PutFile(ctx, "b/data.bin", ioutil.NopCloser(bytes.NewReader(content))))
+	require.NoError(t, g.PutFile(ctx, "b/empty.bin", ioutil.NopCloser(bytes.NewReader(nil))))
+	assert.Equal(t, content, storage.files["backups/b/data.bin"])
+	assert.Contains(t, storage.files, "backups/b/empty.bin")
+
+	f, err := g.StatFile(ctx, "b/data.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), f.Size())
+	assert.Equal(t, time.Unix(1, 0), f.LastModified())
+	assert.Equal(t, "abc", f.Checksum())
+	_, err = g.StatFile(ctx, "b/missing.bin")
+	assert.Equal(t, ErrNotFound, err)
+
+	r, err := g.GetFileReader(ctx, "b/data.bin")
+	require.NoError(t, err)
+	downloaded, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, content, downloaded)
+	r, err = g.GetFileReaderAt(ctx, "b/data.bin", int64(len(content)-5))
+	require.NoError(t, err)
+	downloaded, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "56789", string(downloaded))
+	_, err = g.GetFileReader(ctx, "b/missing.bin")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, g.CopyFile(ctx, "b/data.bin", "c/data.bin"))
+	var names []string
+	require.NoError(t, g.Walk(ctx, "/", true, func(f RemoteFile) error {
+		names = append(names, f.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"b/data.bin", "b/empty.bin", "c/data.bin"}, names)
+	stopErr := fmt.Errorf("stop")
+	calls := 0
+	err = g.Walk(ctx, "b/", true, func(f RemoteFile) error {
+		calls++
+		return stopErr
+	})
+	assert.Equal(t, stopErr, err)
+	assert.Equal(t, 1, calls)
+
+	require.NoError(t, g.DeleteFile(ctx, "c/data.bin"))
+	require.NoError(t, g.DeleteFile(ctx, "c/data.bin"), "missing file is not an error")
+	require.NoError(t, g.DeleteFiles(ctx, []string{"b/data.bin", "b/empty.bin", "b/missing.bin"}))
+	assert.Empty(t, storage.files)
+}
+
+// failedReader - PutFile body broken in the middle
+type failedReader struct{}
+
+func (failedReader) Read([]byte) (int, error) { return 0, errors.New("broken body") }
+
+func TestGRPCStoragePutFileBrokenBody(t *testing.T) {
+	storage := &pluginStorage{rangeStorage: rangeStorage{files: map[string][]byte{}}}
+	g := newTestGRPCStorage(t, storage, "", "")
+	body := io.MultiReader(bytes.NewReader(bytes.Repeat([]byte("x"), grpcChunkSize+1)), failedReader{})
+	assert.EqualError(t, g.PutFile(context.Background(), "b/data.bin", ioutil.NopCloser(body)), "broken body")
+	assert.Eventually(t, func() bool {
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+		return len(storage.files) == 0
+	}, time.Second, 10*time.Millisecond, "incomplete object shall not be written")
+}
+
+func TestGRPCStorageToken(t *testing.T) {
+	storage := &pluginStorage{rangeStorage: rangeStorage{files: map[string][]byte{}}}
+	g := newTestGRPCStorage(t, storage, "secret", "wrong")
+	err := g.Connect(context.Background())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.False(t, isRetryableError(err))
+	err = g.Walk(context.Background(), "/", true, func(RemoteFile) error { return nil })
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCErrors(t *testing.T) {
+	assert.Equal(t, codes.NotFound, status.Code(toGRPCError(ErrNotFound)))
+	assert.Equal(t, codes.Canceled, status.Code(toGRPCError(context.Canceled)))
+	assert.Equal(t, codes.Unavailable, status.Code(toGRPCError(errors.New("connection reset"))))
+	assert.True(t, isRetryableError(toGRPCError(errors.New("connection reset"))))
+	assert.False(t, isRetryableError(status.Error(codes.InvalidArgument, "bad key")))
+	assert.Equal(t, ErrNotFound, fromGRPCError(status.Error(codes.NotFound, "missing")))
+}
+
+func TestGRPCMessages(t *testing.T) {
+	file := &grpcFile{Name: "b/data.bin", Size: 100, LastModified: time.Unix(1, 2).UnixNano(), ETag: "\"e\"", Checksum: "abc"}
+	decodedFile := &grpcFile{}
+	require.NoError(t, decodedFile.unmarshal(file.marshal()))
+	assert.Equal(t, file, decodedFile)
+	keys := &grpcKeysRequest{Keys: []string{"a", "", "b"}}
+	decodedKeys := &grpcKeysRequest{}
+	require.NoError(t, decodedKeys.unmarshal(keys.marshal()))
+	assert.Equal(t, keys, decodedKeys)
+	walk := &grpcWalkRequest{Prefix: "b/", Recursive: true}
+	decodedWalk := &grpcWalkRequest{}
+	require.NoError(t, decodedWalk.unmarshal(walk.marshal()))
+	assert.Equal(t, walk, decodedWalk)
+	// unknown fields, for example added by newer protocol version, are skipped
+	withUnknown := append(appendVarint(nil, 10, 5), (&grpcKeyRequest{Key: "k"}).marshal()...)
+	decodedKey := &grpcKeyRequest{}
+	require.NoError(t, decodedKey.unmarshal(withUnknown))
+	assert.Equal(t, "k", decodedKey.Key)
+	assert.Error(t, decodedKey.unmarshal([]byte{0x0a, 0x05, 'k'}))
+}
@@ -0,0 +1,252 @@
+package new_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+// StorageLatencyBuckets - upper bounds in seconds of remote storage request duration histogram
+var StorageLatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// StorageOperationStats - totals of requests of one operation to one kind of remote storage by the current process,
+// Buckets contains count of requests not longer than each of StorageLatencyBuckets
+type StorageOperationStats struct {
+	Kind      string
+	Operation string
+	Requests  uint64
+	Errors    uint64
+	Bytes     uint64
+	Seconds   float64
+	Buckets   []uint64
+}
+
+type storageOperationKey struct {
+	kind      string
+	operation string
+}
+
+var (
+	storageStatsLock sync.Mutex
+	storageStats     = map[storageOperationKey]*StorageOperationStats{}
+)
+
+// StorageStats - copy of statistics of all operations sorted by storage kind and operation
+func StorageStats() []StorageOperationStats {
+	storageStatsLock.Lock()
+	defer storageStatsLock.Unlock()
+	result := make([]StorageOperationStats, 0, len(storageStats))
+	for _, s := range storageStats {
+		stats := *s
+		stats.Buckets = append([]uint64{}, s.Buckets...)
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Operation < result[j].Operation
+	})
+	return result
+}
+
+func operationStats(kind, operation string) *StorageOperationStats {
+	key := storageOperationKey{kind: kind, operation: operation}
+	s, exists := storageStats[key]
+	if !exists {
+		s = &StorageOperationStats{Kind: kind, Operation: operation, Buckets: make([]uint64, len(StorageLatencyBuckets))}
+		storageStats[key] = s
+	}
+	return s
+}
+
+// isStorageError - missing files are expected by StatFile checks and canceled requests are not storage problems
+func isStorageError(err error) bool {
+	return err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrFileDoesNotExist) && !errors.Is(err, context.Canceled)
+}
+
+func recordStorageRequest(kind, operation string, duration time.Duration, err error) {
+	storageStatsLock.Lock()
+	defer storageStatsLock.Unlock()
+	s := operationStats(kind, operation)
+	s.Requests++
+	s.Seconds += duration.Seconds()
+	for i, bound := range StorageLatencyBuckets {
+		if duration.Seconds() <= bound {
+			s.Buckets[i]++
+		}
+	}
+	if isStorageError(err) {
+		s.Errors++
+	}
+}
+
+func recordStorageBytes(kind, operation string, bytes int64) {
+	storageStatsLock.Lock()
+	defer storageStatsLock.Unlock()
+	operationStats(kind, operation).Bytes += uint64(bytes)
+}
+
+// instrumentedStorage - record duration, transferred bytes and errors of each request, log each request when `storage_debug` is enabled,
+// readers are counted by bytes read until Close, duration of GetFileReader is time until response is received
+type instrumentedStorage struct {
+	RemoteStorage
+	debug bool
+}
+
+func newInstrumentedStorage(storage RemoteStorage, cfg *config.Config) RemoteStorage {
+	if !cfg.General.StorageMetrics && !cfg.General.StorageDebug {
+		return storage
+	}
+	return &instrumentedStorage{RemoteStorage: storage, debug: cfg.General.StorageDebug}
+}
+
+func (s *instrumentedStorage) observe(operation, key string, start time.Time, err error) {
+	duration := time.Since(start)
+	recordStorageRequest(s.Kind(), operation, duration, err)
+	if s.debug {
+		apexLog.WithFields(apexLog.Fields{
+			"storage":   s.Kind(),
+			"operation": operation,
+			"key":       key,
+			"duration":  duration.String(),
+			"error":     err,
+		}).Info("storage request")
+	}
+}
+
+// countedReadCloser - bytes of stream are recorded once when stream is closed
+type countedReadCloser struct {
+	io.ReadCloser
+	bytes int64
+	close func(bytes int64)
+	once  sync.Once
+}
+
+func (c *countedReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.bytes, int64(n))
+	return n, err
+}
+
+func (c *countedReadCloser) Close() error {
+	c.once.Do(func() { c.close(atomic.LoadInt64(&c.bytes)) })
+	return c.ReadCloser.Close()
+}
+
+func (s *instrumentedStorage) countReader(operation, key string, r io.ReadCloser) io.ReadCloser {
+	return &countedReadCloser{ReadCloser: r, close: func(bytes int64) {
+		recordStorageBytes(s.Kind(), operation, bytes)
+		if s.debug {
+			apexLog.WithFields(apexLog.Fields{"storage": s.Kind(), "operation": operation, "key": key, "bytes": bytes}).Info("storage stream closed")
+		}
+	}}
+}
+
+func (s *instrumentedStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	start := time.Now()
+	file, err := s.RemoteStorage.StatFile(ctx, key)
+	s.observe("StatFile", key, start, err)
+	return file, err
+}
+
+func (s *instrumentedStorage) DeleteFile(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.RemoteStorage.DeleteFile(ctx, key)
+	s.observe("DeleteFile", key, start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	start := time.Now()
+	err := s.RemoteStorage.DeleteFiles(ctx, keys)
+	s.observe("DeleteFiles", fmt.Sprintf("%d files", len(keys)), start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Connect(ctx context.Context) error {
+	start := time.Now()
+	err := s.RemoteStorage.Connect(ctx)
+	s.observe("Connect", "", start, err)
+	return err
+}
+
+// Walk - duration includes processing of listed files
+func (s *instrumentedStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(RemoteFile) error) error {
+	start := time.Now()
+	err := s.RemoteStorage.Walk(ctx, prefix, recursive, fn)
+	s.observe("Walk", prefix, start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.RemoteStorage.GetFileReader(ctx, key)
+	s.observe("GetFileReader", key, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.countReader("GetFileReader", key, r), nil
+}
+
+func (s *instrumentedStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+	s.observe("GetFileReaderWithLocalPath", key, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.countReader("GetFileReaderWithLocalPath", key, r), nil
+}
+
+func (s *instrumentedStorage) PutFile(ctx context.Context, key string, body io.ReadCloser) error {
+	start := time.Now()
+	stream := &countedReadCloser{ReadCloser: body, close: func(int64) {}}
+	err := s.RemoteStorage.PutFile(ctx, key, stream)
+	recordStorageBytes(s.Kind(), "PutFile", atomic.LoadInt64(&stream.bytes))
+	s.observe("PutFile", key, start, err)
+	return err
+}
+
+func (s *instrumentedStorage) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	start := time.Now()
+	err := s.RemoteStorage.CopyFile(ctx, srcKey, dstKey)
+	s.observe("CopyFile", srcKey, start, err)
+	return err
+}
+
+func (s *instrumentedStorage) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	start := time.Now()
+	err := s.RemoteStorage.MoveFile(ctx, srcKey, dstKey)
+	s.observe("MoveFile", srcKey, start, err)
+	return err
+}
+
+// GetFileReaderAt - shall be called only when wrapped storage implements RangeReader
+func (s *instrumentedStorage) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.RemoteStorage.(RangeReader).GetFileReaderAt(ctx, key, offset)
+	s.observe("GetFileReaderAt", key, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.countReader("GetFileReaderAt", key, r), nil
+}
+
+// PutFileResumable - shall be called only when wrapped storage implements ResumableStorage
+func (s *instrumentedStorage) PutFileResumable(ctx context.Context, key string, body io.ReadCloser, state UploadState) error {
+	start := time.Now()
+	stream := &countedReadCloser{ReadCloser: body, close: func(int64) {}}
+	err := s.RemoteStorage.(ResumableStorage).PutFileResumable(ctx, key, stream, state)
+	recordStorageBytes(s.Kind(), "PutFileResumable", atomic.LoadInt64(&stream.bytes))
+	s.observe("PutFileResumable", key, start, err)
+	return err
+}
@@ -0,0 +1,64 @@
+package new_storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kindStorage - rangeStorage with own kind, so statistics of test are not mixed with other tests
+type kindStorage struct {
+	rangeStorage
+	kind string
+}
+
+func (s *kindStorage) Kind() string { return s.kind }
+
+func findStorageStats(kind, operation string) StorageOperationStats {
+	for _, s := range StorageStats() {
+		if s.Kind == kind && s.Operation == operation {
+			return s
+		}
+	}
+	return StorageOperationStats{}
+}
+
+func TestInstrumentedStorage(t *testing.T) {
+	storage := &kindStorage{rangeStorage: rangeStorage{files: map[string][]byte{"b/data.bin": []byte("0123456789")}}, kind: "instrumented"}
+	cfg := config.DefaultConfig()
+	instrumented := newInstrumentedStorage(storage, cfg)
+
+	r, err := instrumented.GetFileReader(context.Background(), "b/data.bin")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	stats := findStorageStats("instrumented", "GetFileReader")
+	assert.Equal(t, uint64(1), stats.Requests)
+	assert.Equal(t, uint64(10), stats.Bytes, "bytes are recorded once")
+	assert.Equal(t, uint64(1), stats.Buckets[len(stats.Buckets)-1])
+
+	require.NoError(t, instrumented.PutFile(context.Background(), "b/new.bin", ioutil.NopCloser(bytes.NewReader([]byte("abc")))))
+	assert.Equal(t, uint64(1), findStorageStats("instrumented", "PutFile").Requests)
+
+	_, err = instrumented.StatFile(context.Background(), "b/missing.bin")
+	assert.ErrorIs(t, err, ErrNotFound)
+	stats = findStorageStats("instrumented", "StatFile")
+	assert.Equal(t, uint64(1), stats.Requests)
+	assert.Equal(t, uint64(0), stats.Errors, "missing file is not storage error")
+
+	cfg.General.StorageMetrics = false
+	assert.Equal(t, storage, newInstrumentedStorage(storage, cfg))
+
+	retry := &retryStorage{RemoteStorage: instrumented, retries: 1, pause: time.Millisecond}
+	assert.Equal(t, storage, unwrapStorage(retry))
+	_, isRangeReader := unwrapStorage(retry).(RangeReader)
+	assert.True(t, isRangeReader)
+}
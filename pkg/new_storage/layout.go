@@ -0,0 +1,306 @@
+package new_storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pathTimePlaceholders - placeholders of remote path template resolved from backup creation time in UTC
+var pathTimePlaceholders = map[string]string{
+	"{year}":  "2006",
+	"{month}": "01",
+	"{day}":   "02",
+}
+
+func hasPathTimePlaceholder(segment string) bool {
+	for placeholder := range pathTimePlaceholders {
+		if strings.Contains(segment, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPathTemplate - remote path with `{backup}` as the last segment is split to static root, used as path of storage,
+// and levels between root and backup name, the first level is the first segment which contains time placeholder, macros shall be applied before
+func splitPathTemplate(remotePath string) (string, []string, error) {
+	if !strings.Contains(remotePath, "{backup}") {
+		if hasPathTimePlaceholder(remotePath) {
+			return "", nil, fmt.Errorf("path '%s' contains time placeholders without {backup}", remotePath)
+		}
+		return remotePath, nil, nil
+	}
+	segments := strings.Split(strings.Trim(remotePath, "/"), "/")
+	if segments[len(segments)-1] != "{backup}" || strings.Count(remotePath, "{backup}") > 1 {
+		return "", nil, fmt.Errorf("path '%s' shall contain {backup} only once as the last path element", remotePath)
+	}
+	segments = segments[:len(segments)-1]
+	root := len(segments)
+	for i, segment := range segments {
+		if hasPathTimePlaceholder(segment) {
+			root = i
+			break
+		}
+	}
+	rootPath := strings.Join(segments[:root], "/")
+	if strings.HasPrefix(remotePath, "/") && rootPath != "" {
+		rootPath = "/" + rootPath
+	}
+	return rootPath, segments[root:], nil
+}
+
+// layoutStorage - backups are placed into folders resolved from path template, for example `{year}/{month}/<backup_name>`,
+// keys and names of walked files keep `<backup_name>/...` format for other code, so layout is visible only on remote storage
+type layoutStorage struct {
+	RemoteStorage
+	levels []string
+	now    func() time.Time
+
+	mu       sync.Mutex
+	prefixes map[string]string
+	times    map[string]time.Time
+}
+
+func newLayoutStorage(storage RemoteStorage, levels []string) RemoteStorage {
+	if len(levels) == 0 {
+		return storage
+	}
+	return &layoutStorage{
+		RemoteStorage: storage,
+		levels:        levels,
+		now:           time.Now,
+		prefixes:      map[string]string{},
+		times:         map[string]time.Time{},
+	}
+}
+
+// setBackupTime - time of backup which is not uploaded yet, levels of existing backup are not changed
+func (s *layoutStorage) setBackupTime(backupName string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.times[backupName] = t
+}
+
+func (s *layoutStorage) formatLevels(t time.Time) string {
+	replaces := make([]string, 0, len(pathTimePlaceholders)*2)
+	for placeholder, layout := range pathTimePlaceholders {
+		replaces = append(replaces, placeholder, t.UTC().Format(layout))
+	}
+	return strings.NewReplacer(replaces...).Replace(strings.Join(s.levels, "/"))
+}
+
+// walkLevels - call fn with folder of each level which contains backup folders, level folders with other names than template are walked too
+func (s *layoutStorage) walkLevels(ctx context.Context, depth int, prefix string, fn func(levelPrefix string) error) error {
+	if depth == len(s.levels) {
+		return fn(prefix)
+	}
+	var folders []string
+	if err := s.RemoteStorage.Walk(ctx, prefix+"/", false, func(f RemoteFile) error {
+		if name := strings.Trim(f.Name(), "/"); name != "" {
+			folders = append(folders, path.Join(prefix, name))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		if err := s.walkLevels(ctx, depth+1, folder, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve - levels of backup are searched on remote storage once, backup which is not found is placed by its time or current time
+func (s *layoutStorage) resolve(ctx context.Context, backupName string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prefix, exists := s.prefixes[backupName]; exists {
+		return prefix, nil
+	}
+	errFound := fmt.Errorf("found")
+	err := s.walkLevels(ctx, 0, "", func(levelPrefix string) error {
+		return s.RemoteStorage.Walk(ctx, levelPrefix+"/", false, func(f RemoteFile) error {
+			if strings.Trim(f.Name(), "/") == backupName {
+				s.prefixes[backupName] = levelPrefix
+				return errFound
+			}
+			return nil
+		})
+	})
+	if err == errFound {
+		return s.prefixes[backupName], nil
+	}
+	if err != nil {
+		return "", err
+	}
+	t, exists := s.times[backupName]
+	if !exists || t.IsZero() {
+		t = s.now()
+	}
+	s.prefixes[backupName] = s.formatLevels(t)
+	return s.prefixes[backupName], nil
+}
+
+// key - the first element of key is backup name, it is moved into folder of its levels
+func (s *layoutStorage) key(ctx context.Context, key string) (string, error) {
+	trimmed := strings.TrimPrefix(key, "/")
+	backupName := strings.SplitN(trimmed, "/", 2)[0]
+	if backupName == "" {
+		return key, nil
+	}
+	prefix, err := s.resolve(ctx, backupName)
+	if err != nil {
+		return "", err
+	}
+	resolved := path.Join(prefix, trimmed)
+	if strings.HasSuffix(key, "/") {
+		resolved += "/"
+	}
+	return resolved, nil
+}
+
+// layoutFile - walked file with name relative to backups root
+type layoutFile struct {
+	RemoteFile
+	name string
+}
+
+func (f *layoutFile) Name() string {
+	return f.name
+}
+
+// Walk - root is walked level by level, so backup folders are listed as they are placed in root, names of recursive root walk are without levels
+func (s *layoutStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(RemoteFile) error) error {
+	if strings.Trim(prefix, "/") != "" {
+		resolved, err := s.key(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		return s.RemoteStorage.Walk(ctx, resolved, recursive, fn)
+	}
+	if recursive {
+		return s.RemoteStorage.Walk(ctx, prefix, true, func(f RemoteFile) error {
+			segments := strings.SplitN(strings.TrimPrefix(f.Name(), "/"), "/", len(s.levels)+1)
+			if len(segments) <= len(s.levels) {
+				return nil
+			}
+			return fn(&layoutFile{RemoteFile: f, name: segments[len(s.levels)]})
+		})
+	}
+	return s.walkLevels(ctx, 0, "", func(levelPrefix string) error {
+		return s.RemoteStorage.Walk(ctx, levelPrefix+"/", false, func(f RemoteFile) error {
+			if backupName := strings.Trim(f.Name(), "/"); backupName != "" {
+				s.mu.Lock()
+				s.prefixes[backupName] = levelPrefix
+				s.mu.Unlock()
+			}
+			return fn(f)
+		})
+	})
+}
+
+func (s *layoutStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoteStorage.StatFile(ctx, resolved)
+}
+
+func (s *layoutStorage) DeleteFile(ctx context.Context, key string) error {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return s.RemoteStorage.DeleteFile(ctx, resolved)
+}
+
+func (s *layoutStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	resolved := make([]string, len(keys))
+	for i := range keys {
+		var err error
+		if resolved[i], err = s.key(ctx, keys[i]); err != nil {
+			return err
+		}
+	}
+	return s.RemoteStorage.DeleteFiles(ctx, resolved)
+}
+
+func (s *layoutStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoteStorage.GetFileReader(ctx, resolved)
+}
+
+func (s *layoutStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoteStorage.GetFileReaderWithLocalPath(ctx, resolved, localPath)
+}
+
+func (s *layoutStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return s.RemoteStorage.PutFile(ctx, resolved, r)
+}
+
+func (s *layoutStorage) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	resolvedSrc, err := s.key(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	resolvedDst, err := s.key(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+	return s.RemoteStorage.CopyFile(ctx, resolvedSrc, resolvedDst)
+}
+
+func (s *layoutStorage) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	resolvedSrc, err := s.key(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	resolvedDst, err := s.key(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+	return s.RemoteStorage.MoveFile(ctx, resolvedSrc, resolvedDst)
+}
+
+// GetFileReaderAt - shall be called only when wrapped storage implements RangeReader
+func (s *layoutStorage) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoteStorage.(RangeReader).GetFileReaderAt(ctx, resolved, offset)
+}
+
+// PutFileResumable - shall be called only when wrapped storage implements ResumableStorage
+func (s *layoutStorage) PutFileResumable(ctx context.Context, key string, r io.ReadCloser, state UploadState) error {
+	resolved, err := s.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return s.RemoteStorage.(ResumableStorage).PutFileResumable(ctx, resolved, r, state)
+}
+
+// SetBackupTime - creation time of backup shall be set before upload when remote path contains time placeholders, current time is used by default
+func (bd *BackupDestination) SetBackupTime(backupName string, t time.Time) {
+	if layout, ok := bd.RemoteStorage.(*layoutStorage); ok {
+		layout.setBackupTime(backupName, t)
+	}
+}
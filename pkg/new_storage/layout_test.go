@@ -0,0 +1,137 @@
+package new_storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// folderStorage - pluginStorage with first level folders for not recursive Walk, the same as common prefixes of S3
+type folderStorage struct {
+	pluginStorage
+}
+
+func (s *folderStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(RemoteFile) error) error {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if recursive {
+		return s.pluginStorage.Walk(ctx, prefix, true, fn)
+	}
+	names := map[string]int64{}
+	for key, content := range s.files {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			names[name[:i+1]] = 0
+		} else {
+			names[name] = int64(len(content))
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if err := fn(&grpcFileInfo{file: grpcFile{Name: name, Size: names[name]}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSplitPathTemplate(t *testing.T) {
+	testCases := []struct {
+		path   string
+		root   string
+		levels []string
+		err    string
+	}{
+		{path: "backups/shard1", root: "backups/shard1"},
+		{path: "prod/01/{backup}", root: "prod/01", levels: []string{}},
+		{path: "prod/01/{year}/{month}/{backup}", root: "prod/01", levels: []string{"{year}", "{month}"}},
+		{path: "/prod/{year}-{month}/daily/{backup}/", root: "/prod", levels: []string{"{year}-{month}", "daily"}},
+		{path: "{year}/{backup}", root: "", levels: []string{"{year}"}},
+		{path: "prod/{year}", err: "path 'prod/{year}' contains time placeholders without {backup}"},
+		{path: "prod/{backup}/{year}", err: "path 'prod/{backup}/{year}' shall contain {backup} only once as the last path element"},
+	}
+	for _, tc := range testCases {
+		root, levels, err := splitPathTemplate(tc.path)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.path)
+			continue
+		}
+		require.NoError(t, err, tc.path)
+		assert.Equal(t, tc.root, root, tc.path)
+		assert.Equal(t, tc.levels, levels, tc.path)
+	}
+}
+
+func TestLayoutStorage(t *testing.T) {
+	storage := &folderStorage{pluginStorage{rangeStorage: rangeStorage{files: map[string][]byte{
+		"2022/12/old/metadata.json": []byte("{}"),
+		"2023/01/b1/metadata.json":  []byte("{}"),
+		"2023/01/b1/shadow/data":    []byte("data"),
+	}}}}
+	layout := newLayoutStorage(storage, []string{"{year}", "{month}"}).(*layoutStorage)
+	layout.now = func() time.Time { return time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC) }
+	bd := &BackupDestination{RemoteStorage: layout}
+	ctx := context.Background()
+
+	backups, err := bd.BackupList(ctx, false, "")
+	require.NoError(t, err)
+	names := make([]string, len(backups))
+	for i := range backups {
+		names[i] = backups[i].BackupName
+	}
+	assert.Equal(t, []string{"old", "b1"}, names)
+
+	// existing backup is found in its folder, new backup is placed by its time or current time
+	r, err := bd.GetFileReader(ctx, "b1/shadow/data")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(content))
+	bd.SetBackupTime("b2", time.Date(2023, 2, 10, 23, 0, 0, 0, time.FixedZone("UTC-3", -3*3600)))
+	require.NoError(t, bd.PutFile(ctx, "b2/metadata.json", ioutil.NopCloser(bytes.NewReader([]byte("{}")))))
+	assert.Contains(t, storage.files, "2023/02/b2/metadata.json", "time shall be in UTC")
+	require.NoError(t, bd.CopyFile(ctx, "b1/shadow/data", "b3/shadow/data"))
+	assert.Contains(t, storage.files, "2023/03/b3/shadow/data")
+
+	// resolved on remote storage by new layoutStorage, not by cached folder
+	layout = newLayoutStorage(storage, []string{"{year}", "{month}"}).(*layoutStorage)
+	f, err := layout.StatFile(ctx, "old/metadata.json")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), f.Size())
+
+	var walked []string
+	require.NoError(t, layout.Walk(ctx, "/", true, func(f RemoteFile) error {
+		walked = append(walked, f.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"old/metadata.json", "b1/metadata.json", "b1/shadow/data", "b2/metadata.json", "b3/shadow/data"}, walked)
+	walked = nil
+	require.NoError(t, layout.Walk(ctx, "b1/", true, func(f RemoteFile) error {
+		walked = append(walked, f.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"metadata.json", "shadow/data"}, walked)
+
+	require.NoError(t, layout.DeleteFiles(ctx, []string{"b1/metadata.json", "b1/shadow/data"}))
+	assert.NotContains(t, storage.files, "2023/01/b1/metadata.json")
+	assert.NotContains(t, storage.files, "2023/01/b1/shadow/data")
+}
+
+func TestLayoutStorageNotChangedWithoutLevels(t *testing.T) {
+	storage := &pluginStorage{}
+	assert.Equal(t, RemoteStorage(storage), newLayoutStorage(storage, nil))
+	assert.Equal(t, RemoteStorage(storage), newLayoutStorage(storage, []string{}))
+}
@@ -0,0 +1,105 @@
+package new_storage
+
+import (
+	"runtime"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+const (
+	// gcsChunkSize - default buffer of cloud.google.com/go/storage Writer
+	gcsChunkSize = 16 * 1024 * 1024
+	// compressionBlockSize - approximate memory of one thread of multithreaded gzip and zstd encoders
+	compressionBlockSize = 2 * 1024 * 1024
+)
+
+// singleThreadCompression - gzip and zstd encoders don't start goroutine with own buffers per CPU, enabled when `max_memory_bytes` is too low for them
+var singleThreadCompression bool
+
+// transferMemory - buffers allocated by one upload or download go-routine
+type transferMemory struct {
+	// partSize - buffer of one part of multipart upload, S3 part_size or azblob buffer_size
+	partSize    int64
+	minPartSize int64
+	// partConcurrency - parts of one file in memory at the same time, S3 concurrency or azblob buffer_count
+	partConcurrency int
+	// fixed - ring buffer between stream handlers and buffers of storage client which can't be tuned
+	fixed int64
+	// compression - compression_format is used and encoder is multithreaded
+	compression bool
+}
+
+// minPartSize - the smallest part which allow upload file with `max_file_size` in `max_parts_count` parts, not less than lowest of remote storage
+func minPartSize(maxFileSize, maxPartsCount, lowest int64) int64 {
+	if maxPartsCount > 0 && maxFileSize/maxPartsCount > lowest {
+		return maxFileSize / maxPartsCount
+	}
+	return lowest
+}
+
+func compressionMemory(multithreaded bool) int64 {
+	if multithreaded {
+		return int64(runtime.GOMAXPROCS(0)) * compressionBlockSize
+	}
+	return compressionBlockSize
+}
+
+func (m transferMemory) perTransfer() uint64 {
+	size := m.partSize*int64(m.partConcurrency) + m.fixed
+	if m.compression {
+		size += compressionMemory(!singleThreadCompression)
+	}
+	return uint64(size)
+}
+
+// transfers - `upload_concurrency` tables and `upload_concurrency` archives of each table could be transferred at the same time
+func transfers(concurrency uint8) uint64 {
+	return uint64(concurrency) * uint64(concurrency)
+}
+
+// fitMemoryBudget - reduce memory of transfers to `max_memory_bytes`, in order part concurrency, compression threads,
+// upload_concurrency and download_concurrency, part size not less than `max_file_size` / `max_parts_count`
+func fitMemoryBudget(cfg *config.Config, m transferMemory) transferMemory {
+	singleThreadCompression = false
+	limit := cfg.General.MaxMemoryBytes
+	if limit == 0 {
+		return m
+	}
+	concurrency := cfg.GetUploadConcurrency()
+	if downloadConcurrency := cfg.GetDownloadConcurrency(); downloadConcurrency > concurrency {
+		concurrency = downloadConcurrency
+	}
+	required := func() uint64 {
+		return transfers(concurrency) * m.perTransfer()
+	}
+	for required() > limit && m.partConcurrency > 1 {
+		m.partConcurrency--
+	}
+	if required() > limit && m.compression {
+		singleThreadCompression = true
+	}
+	for required() > limit && concurrency > 1 {
+		concurrency--
+	}
+	if required() > limit && m.partSize > m.minPartSize {
+		partSize := (int64(limit/transfers(concurrency)) - int64(m.perTransfer()) + m.partSize*int64(m.partConcurrency)) / int64(m.partConcurrency)
+		if partSize < m.minPartSize {
+			partSize = m.minPartSize
+		}
+		if partSize < m.partSize {
+			m.partSize = partSize
+		}
+	}
+	if cfg.General.UploadConcurrency > concurrency {
+		cfg.General.UploadConcurrency = concurrency
+	}
+	if cfg.General.DownloadConcurrency > concurrency {
+		cfg.General.DownloadConcurrency = concurrency
+	}
+	if required() > limit {
+		apexLog.Warnf("max_memory_bytes=%d is less than %d required by one transfer with minimal part size %d, please increase max_memory_bytes or decrease max_file_size", limit, required(), m.partSize)
+	}
+	apexLog.Debugf("max_memory_bytes=%d, upload_concurrency=%d, download_concurrency=%d, part_size=%d, part_concurrency=%d, single_thread_compression=%v", limit, cfg.General.UploadConcurrency, cfg.General.DownloadConcurrency, m.partSize, m.partConcurrency, singleThreadCompression)
+	return m
+}
@@ -0,0 +1,57 @@
+package new_storage
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitMemoryBudget(t *testing.T) {
+	const mb = 1024 * 1024
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	s3Memory := transferMemory{partSize: 64 * mb, minPartSize: 5 * mb, partConcurrency: 4, fixed: 2 * mb}
+	testCases := []struct {
+		name            string
+		maxMemory       uint64
+		compression     bool
+		partSize        int64
+		partConcurrency int
+		concurrency     uint8
+		singleThread    bool
+	}{
+		{name: "unlimited", maxMemory: 0, partSize: 64 * mb, partConcurrency: 4, concurrency: 4},
+		{name: "enough memory", maxMemory: 16 * 258 * mb, partSize: 64 * mb, partConcurrency: 4, concurrency: 4},
+		{name: "part concurrency reduced first", maxMemory: 16 * 66 * mb, partSize: 64 * mb, partConcurrency: 1, concurrency: 4},
+		{name: "transfers concurrency reduced", maxMemory: 4 * 66 * mb, partSize: 64 * mb, partConcurrency: 1, concurrency: 2},
+		{name: "part size reduced", maxMemory: 12 * mb, partSize: 10 * mb, partConcurrency: 1, concurrency: 1},
+		{name: "part size not less than minimal", maxMemory: mb, partSize: 5 * mb, partConcurrency: 1, concurrency: 1},
+		{name: "single thread compression before transfers concurrency", maxMemory: 16 * 66 * mb, compression: true, partSize: 64 * mb, partConcurrency: 1, concurrency: 4, singleThread: true},
+	}
+	for _, tc := range testCases {
+		cfg := config.DefaultConfig()
+		cfg.General.RemoteStorage = "s3"
+		cfg.General.UploadConcurrency = 4
+		cfg.General.DownloadConcurrency = 3
+		cfg.General.MaxMemoryBytes = tc.maxMemory
+		m := s3Memory
+		m.compression = tc.compression
+		if tc.compression {
+			m.fixed = 2*mb - compressionMemory(false)
+		}
+		m = fitMemoryBudget(cfg, m)
+		assert.Equal(t, tc.partSize, m.partSize, tc.name)
+		assert.Equal(t, tc.partConcurrency, m.partConcurrency, tc.name)
+		assert.Equal(t, tc.concurrency, cfg.GetUploadConcurrency(), tc.name)
+		assert.LessOrEqual(t, cfg.GetDownloadConcurrency(), tc.concurrency, tc.name)
+		assert.Equal(t, tc.singleThread, singleThreadCompression, tc.name)
+	}
+	singleThreadCompression = false
+}
+
+func TestMinPartSize(t *testing.T) {
+	assert.Equal(t, int64(5*1024*1024), minPartSize(1024*1024*1024, 10000, 5*1024*1024))
+	assert.Equal(t, int64(100*1024*1024), minPartSize(10000*100*1024*1024, 10000, 5*1024*1024))
+	assert.Equal(t, int64(2*1024*1024), minPartSize(1024*1024*1024, 0, 2*1024*1024))
+}
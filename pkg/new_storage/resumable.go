@@ -0,0 +1,225 @@
+package new_storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+// MultipartUpload - unfinished multipart upload, uploaded parts are listed on remote storage
+type MultipartUpload struct {
+	UploadID string `json:"upload_id"`
+	PartSize int64  `json:"part_size"`
+}
+
+// UploadState - persistent progress of upload, allow continue upload after interruption, see `upload --resume`
+type UploadState interface {
+	GetMultipartUpload(key string) *MultipartUpload
+	SaveMultipartUpload(key string, upload MultipartUpload) error
+	CompleteFile(key string, size int64) error
+}
+
+// ResumableStorage - remote storage which could continue interrupted multipart upload
+type ResumableStorage interface {
+	PutFileResumable(ctx context.Context, key string, r io.ReadCloser, state UploadState) error
+}
+
+// putFile - PutFile which store progress to state, when state is nil works the same as PutFile
+func (bd *BackupDestination) putFile(ctx context.Context, key string, r io.ReadCloser, state UploadState) error {
+	if state == nil {
+		return bd.PutFile(ctx, key, r)
+	}
+	var size uint64
+	body := countingReadCloser{ReadCloser: r, counter: &size}
+	var err error
+	if _, ok := unwrapStorage(bd.RemoteStorage).(ResumableStorage); ok {
+		err = bd.RemoteStorage.(ResumableStorage).PutFileResumable(ctx, key, newUploadReader(ctx, body), state)
+	} else {
+		err = bd.PutFile(ctx, key, body)
+	}
+	if err != nil {
+		return err
+	}
+	return state.CompleteFile(key, int64(atomic.LoadUint64(&size)))
+}
+
+// RangeReader - remote storage which could read file from offset, allow continue interrupted download
+type RangeReader interface {
+	GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+}
+
+// DownloadChunk - downloaded part of remote file, checksum allow verify local file before continue download
+type DownloadChunk struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+}
+
+// DownloadState - persistent progress of download, see `download --resume`
+type DownloadState interface {
+	IsCompleted(key string) bool
+	GetDownloadedChunks(key string, remoteSize int64) []DownloadChunk
+	SaveDownloadedChunk(key string, remoteSize int64, chunk DownloadChunk) error
+	CompleteFile(key string, size int64) error
+}
+
+// downloadChunkSize - how often progress of downloaded file is saved to DownloadState
+const downloadChunkSize = 64 * 1024 * 1024
+
+func (bd *BackupDestination) getFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	if _, ok := unwrapStorage(bd.RemoteStorage).(RangeReader); !ok {
+		if offset == 0 {
+			return bd.GetFileReader(ctx, key)
+		}
+		return nil, fmt.Errorf("%s doesn't support range requests", bd.Kind())
+	}
+	r, err := bd.RemoteStorage.(RangeReader).GetFileReaderAt(ctx, key, offset)
+	if err != nil {
+		return nil, err
+	}
+	return newDownloadReader(ctx, r), nil
+}
+
+// retryReader - after broken connection continue read from the last received byte with range request
+type retryReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	bd      *BackupDestination
+	key     string
+	offset  int64
+	retries int
+}
+
+// newRetryReader - r shall be read from offset, storages without range requests support don't retry
+func (bd *BackupDestination) newRetryReader(ctx context.Context, key string, offset int64, r io.ReadCloser) io.ReadCloser {
+	if _, ok := unwrapStorage(bd.RemoteStorage).(RangeReader); !ok || bd.downloadRetries <= 0 {
+		return r
+	}
+	return &retryReader{ReadCloser: r, ctx: ctx, bd: bd, key: key, offset: offset}
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.offset += int64(n)
+	if err == nil || err == io.EOF || r.ctx.Err() != nil || errors.Is(err, context.Canceled) || r.retries >= r.bd.downloadRetries {
+		return n, err
+	}
+	r.retries++
+	apexLog.Warnf("can't read %s, retry %d/%d from offset %d: %v", r.key, r.retries, r.bd.downloadRetries, r.offset, err)
+	if closeErr := r.ReadCloser.Close(); closeErr != nil {
+		apexLog.Debugf("can't close broken reader of %s: %v", r.key, closeErr)
+	}
+	time.Sleep(time.Duration(r.retries) * time.Second)
+	reader, openErr := r.bd.getFileReaderAt(r.ctx, r.key, r.offset)
+	if openErr != nil {
+		r.ReadCloser = ioutil.NopCloser(bytes.NewReader(nil))
+		return n, fmt.Errorf("%v, can't continue from offset %d: %v", err, r.offset, openErr)
+	}
+	r.ReadCloser = reader
+	return n, nil
+}
+
+// downloadFile - when state is not nil, progress is saved each downloadChunkSize, chunks downloaded before are verified by checksum and download continue after the last valid chunk
+func (bd *BackupDestination) downloadFile(ctx context.Context, key string, remoteSize int64, localFile string, state DownloadState) error {
+	offset := int64(0)
+	if _, ok := unwrapStorage(bd.RemoteStorage).(RangeReader); ok && state != nil {
+		offset = verifyDownloadedChunks(localFile, state.GetDownloadedChunks(key, remoteSize))
+	}
+	dst, err := os.OpenFile(localFile, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if err = dst.Truncate(offset); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if _, err = dst.Seek(offset, io.SeekStart); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if offset > 0 {
+		apexLog.Infof("continue download %s from offset %d", key, offset)
+	}
+	r, err := bd.getFileReaderAt(ctx, key, offset)
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+	r = bd.newRetryReader(ctx, key, offset, r)
+	if state == nil {
+		_, err = io.CopyBuffer(dst, r, nil)
+	} else {
+		for {
+			hash := crc32.NewIEEE()
+			var n int64
+			n, err = io.CopyN(io.MultiWriter(dst, hash), r, downloadChunkSize)
+			if n > 0 {
+				// file is not synced, chunk which was not written completely will be detected by checksum during next resume
+				if saveErr := state.SaveDownloadedChunk(key, remoteSize, DownloadChunk{Offset: offset, Size: n, CRC32: hash.Sum32()}); saveErr != nil {
+					err = saveErr
+					break
+				}
+				offset += n
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				break
+			}
+		}
+	}
+	if err != nil {
+		_ = dst.Close()
+		_ = r.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = r.Close(); err != nil {
+		return err
+	}
+	if state != nil {
+		return state.CompleteFile(key, offset)
+	}
+	return nil
+}
+
+// verifyDownloadedChunks - size of local file prefix which checksums are the same as saved chunks
+func verifyDownloadedChunks(localFile string, chunks []DownloadChunk) int64 {
+	if len(chunks) == 0 {
+		return 0
+	}
+	f, err := os.Open(localFile)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Offset < chunks[j].Offset
+	})
+	verified := int64(0)
+	for _, chunk := range chunks {
+		if chunk.Offset != verified {
+			break
+		}
+		hash := crc32.NewIEEE()
+		if n, err := io.CopyN(hash, f, chunk.Size); err != nil || n != chunk.Size || hash.Sum32() != chunk.CRC32 {
+			apexLog.Debugf("%s chunk at offset %d is incomplete or corrupted, download will continue from it", localFile, chunk.Offset)
+			break
+		}
+		verified += chunk.Size
+	}
+	return verified
+}
@@ -0,0 +1,128 @@
+package new_storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rangeStorage - in memory storage with range requests, first reader of each file break after breakAfter bytes
+type rangeStorage struct {
+	files      map[string][]byte
+	breakAfter int
+	broken     map[string]bool
+	offsets    []int64
+}
+
+func (s *rangeStorage) Kind() string { return "memory" }
+func (s *rangeStorage) StatFile(context.Context, string) (RemoteFile, error) {
+	return nil, ErrNotFound
+}
+func (s *rangeStorage) DeleteFile(context.Context, string) error    { return nil }
+func (s *rangeStorage) DeleteFiles(context.Context, []string) error { return nil }
+func (s *rangeStorage) Connect(context.Context) error               { return nil }
+func (s *rangeStorage) Walk(context.Context, string, bool, func(RemoteFile) error) error {
+	return nil
+}
+func (s *rangeStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetFileReaderAt(ctx, key, 0)
+}
+func (s *rangeStorage) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return s.GetFileReaderAt(ctx, key, 0)
+}
+func (s *rangeStorage) PutFile(context.Context, string, io.ReadCloser) error { return nil }
+func (s *rangeStorage) CopyFile(context.Context, string, string) error       { return nil }
+func (s *rangeStorage) MoveFile(context.Context, string, string) error       { return nil }
+func (s *rangeStorage) GetFileReaderAt(_ context.Context, key string, offset int64) (io.ReadCloser, error) {
+	s.offsets = append(s.offsets, offset)
+	content := s.files[key][offset:]
+	if s.breakAfter > 0 && !s.broken[key] && len(content) > s.breakAfter {
+		s.broken[key] = true
+		return ioutil.NopCloser(io.MultiReader(bytes.NewReader(content[:s.breakAfter]), errReader{})), nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection reset by peer") }
+
+// memoryDownloadState - DownloadState without persistence
+type memoryDownloadState struct {
+	chunks    []DownloadChunk
+	completed map[string]int64
+}
+
+func (s *memoryDownloadState) IsCompleted(key string) bool {
+	_, completed := s.completed[key]
+	return completed
+}
+func (s *memoryDownloadState) GetDownloadedChunks(string, int64) []DownloadChunk { return s.chunks }
+func (s *memoryDownloadState) SaveDownloadedChunk(_ string, _ int64, chunk DownloadChunk) error {
+	s.chunks = append(s.chunks, chunk)
+	return nil
+}
+func (s *memoryDownloadState) CompleteFile(key string, size int64) error {
+	s.completed[key] = size
+	return nil
+}
+
+func TestRetryReaderContinueFromOffset(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	storage := &rangeStorage{files: map[string][]byte{"b/data.bin": content}, breakAfter: 333, broken: map[string]bool{}}
+	bd := &BackupDestination{RemoteStorage: storage, downloadRetries: 1}
+	r, err := bd.getFileReaderAt(context.Background(), "b/data.bin", 0)
+	require.NoError(t, err)
+	downloaded, err := ioutil.ReadAll(bd.newRetryReader(context.Background(), "b/data.bin", 0, r))
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, []int64{0, 333}, storage.offsets)
+
+	bd.downloadRetries = 0
+	storage.broken = map[string]bool{}
+	r, err = bd.getFileReaderAt(context.Background(), "b/data.bin", 0)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(bd.newRetryReader(context.Background(), "b/data.bin", 0, r))
+	assert.Error(t, err, "retries disabled")
+}
+
+func TestRetryReaderStopOnCanceledContext(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	storage := &rangeStorage{files: map[string][]byte{"b/data.bin": content}, broken: map[string]bool{}}
+	bd := &BackupDestination{RemoteStorage: storage, downloadRetries: 3}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := bd.getFileReaderAt(ctx, "b/data.bin", 0)
+	require.NoError(t, err)
+	cancel()
+	_, err = ioutil.ReadAll(bd.newRetryReader(ctx, "b/data.bin", 0, r))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []int64{0}, storage.offsets, "canceled read is not retried")
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefgh"), 64)
+	storage := &rangeStorage{files: map[string][]byte{"b/data.bin": content}, broken: map[string]bool{}}
+	bd := &BackupDestination{RemoteStorage: storage}
+	localFile := path.Join(t.TempDir(), "data.bin")
+	// previous download write 2 chunks, second one is corrupted
+	require.NoError(t, ioutil.WriteFile(localFile, append(append([]byte{}, content[:100]...), bytes.Repeat([]byte("x"), 50)...), 0644))
+	state := &memoryDownloadState{completed: map[string]int64{}, chunks: []DownloadChunk{
+		{Offset: 0, Size: 100, CRC32: crc32.ChecksumIEEE(content[:100])},
+		{Offset: 100, Size: 50, CRC32: crc32.ChecksumIEEE(content[100:150])},
+	}}
+	assert.Equal(t, int64(100), verifyDownloadedChunks(localFile, state.chunks))
+	require.NoError(t, bd.downloadFile(context.Background(), "b/data.bin", int64(len(content)), localFile, state))
+	downloaded, err := ioutil.ReadFile(localFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, []int64{100}, storage.offsets)
+	assert.Equal(t, int64(len(content)), state.completed["b/data.bin"])
+}
@@ -0,0 +1,255 @@
+package new_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	apexLog "github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	lib_sftp "github.com/pkg/sftp"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var storageRetries uint64
+
+// StorageRetries - total retries of failed remote storage requests by the current process
+func StorageRetries() uint64 {
+	return atomic.LoadUint64(&storageRetries)
+}
+
+// retryStorage - retry failed requests of any remote storage with jittered exponential backoff,
+// streams are retried only when nothing was read from them, Walk only when nothing was processed
+type retryStorage struct {
+	RemoteStorage
+	retries  int
+	pause    time.Duration
+	maxPause time.Duration
+}
+
+func newRetryStorage(storage RemoteStorage, cfg *config.Config) RemoteStorage {
+	if cfg.General.StorageRetries <= 0 {
+		return storage
+	}
+	pause, _ := time.ParseDuration(cfg.General.StorageRetriesPause)
+	maxPause, _ := time.ParseDuration(cfg.General.StorageRetriesMaxPause)
+	if maxPause < pause {
+		maxPause = pause
+	}
+	return &retryStorage{RemoteStorage: storage, retries: cfg.General.StorageRetries, pause: pause, maxPause: maxPause}
+}
+
+// unwrapStorage - storage wrapped by retryStorage, instrumentedStorage and layoutStorage, optional interfaces RangeReader and ResumableStorage are checked on it
+func unwrapStorage(storage RemoteStorage) RemoteStorage {
+	for {
+		switch wrapper := storage.(type) {
+		case *retryStorage:
+			storage = wrapper.RemoteStorage
+		case *instrumentedStorage:
+			storage = wrapper.RemoteStorage
+		case *layoutStorage:
+			storage = wrapper.RemoteStorage
+		default:
+			return storage
+		}
+	}
+}
+
+// retryPause - exponential backoff from pause to maxPause, random half of pause is added to avoid retries of all transfers at the same moment
+func retryPause(attempt int, pause, maxPause time.Duration) time.Duration {
+	d := pause
+	for i := 1; i < attempt && d < maxPause; i++ {
+		d *= 2
+	}
+	if d > maxPause {
+		d = maxPause
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// isRetryableError - canceled requests, missing files and client errors of remote storage API are not retried, network errors are retried
+func isRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrNotFound) || errors.Is(err, ErrFileDoesNotExist) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	var awsErr awserr.RequestFailure
+	if errors.As(err, &awsErr) {
+		return isRetryableStatus(awsErr.StatusCode())
+	}
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return isRetryableStatus(gcsErr.Code)
+	}
+	var azureErr azblob.StorageError
+	if errors.As(err, &azureErr) && azureErr.Response() != nil {
+		return isRetryableStatus(azureErr.Response().StatusCode)
+	}
+	var cosErr *cos.ErrorResponse
+	if errors.As(err, &cosErr) && cosErr.Response != nil {
+		return isRetryableStatus(cosErr.Response.StatusCode)
+	}
+	// FTP 4xx replies are transient negative completion, 5xx are permanent
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) {
+		return ftpErr.Code < 500
+	}
+	var sftpErr *lib_sftp.StatusError
+	if errors.As(err, &sftpErr) {
+		switch sftpErr.FxCode() {
+		case lib_sftp.ErrSSHFxFailure, lib_sftp.ErrSSHFxNoConnection, lib_sftp.ErrSSHFxConnectionLost:
+			return true
+		}
+		return false
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		switch grpcErr.GRPCStatus().Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// retry - canRetry is checked after failure, nil means request could be always repeated
+func (r *retryStorage) retry(ctx context.Context, operation, key string, canRetry func() bool, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt >= r.retries || ctx.Err() != nil || !isRetryableError(err) || (canRetry != nil && !canRetry()) {
+			return err
+		}
+		pause := retryPause(attempt+1, r.pause, r.maxPause)
+		atomic.AddUint64(&storageRetries, 1)
+		apexLog.Warnf("%s %s %s failed, retry %d/%d after %s: %v", r.Kind(), operation, key, attempt+1, r.retries, pause, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pause):
+		}
+	}
+}
+
+// touchedReadCloser - remember that stream was read, so request can't be retried with the same stream
+type touchedReadCloser struct {
+	io.ReadCloser
+	touched bool
+}
+
+func (t *touchedReadCloser) Read(p []byte) (int, error) {
+	t.touched = true
+	return t.ReadCloser.Read(p)
+}
+
+func (t *touchedReadCloser) untouched() bool {
+	return !t.touched
+}
+
+func (r *retryStorage) StatFile(ctx context.Context, key string) (file RemoteFile, err error) {
+	err = r.retry(ctx, "StatFile", key, nil, func() error {
+		file, err = r.RemoteStorage.StatFile(ctx, key)
+		return err
+	})
+	return file, err
+}
+
+func (r *retryStorage) DeleteFile(ctx context.Context, key string) error {
+	return r.retry(ctx, "DeleteFile", key, nil, func() error {
+		return r.RemoteStorage.DeleteFile(ctx, key)
+	})
+}
+
+// DeleteFiles - missing files are not an error, so batch is repeated completely
+func (r *retryStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	return r.retry(ctx, "DeleteFiles", fmt.Sprintf("%d files", len(keys)), nil, func() error {
+		return r.RemoteStorage.DeleteFiles(ctx, keys)
+	})
+}
+
+func (r *retryStorage) Connect(ctx context.Context) error {
+	return r.retry(ctx, "Connect", "", nil, func() error {
+		return r.RemoteStorage.Connect(ctx)
+	})
+}
+
+func (r *retryStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(RemoteFile) error) error {
+	processed := false
+	return r.retry(ctx, "Walk", prefix, func() bool { return !processed }, func() error {
+		return r.RemoteStorage.Walk(ctx, prefix, recursive, func(f RemoteFile) error {
+			processed = true
+			return fn(f)
+		})
+	})
+}
+
+func (r *retryStorage) GetFileReader(ctx context.Context, key string) (reader io.ReadCloser, err error) {
+	err = r.retry(ctx, "GetFileReader", key, nil, func() error {
+		reader, err = r.RemoteStorage.GetFileReader(ctx, key)
+		return err
+	})
+	return reader, err
+}
+
+func (r *retryStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (reader io.ReadCloser, err error) {
+	err = r.retry(ctx, "GetFileReaderWithLocalPath", key, nil, func() error {
+		reader, err = r.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+		return err
+	})
+	return reader, err
+}
+
+func (r *retryStorage) PutFile(ctx context.Context, key string, body io.ReadCloser) error {
+	stream := &touchedReadCloser{ReadCloser: body}
+	return r.retry(ctx, "PutFile", key, stream.untouched, func() error {
+		return r.RemoteStorage.PutFile(ctx, key, stream)
+	})
+}
+
+func (r *retryStorage) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	return r.retry(ctx, "CopyFile", srcKey, nil, func() error {
+		return r.RemoteStorage.CopyFile(ctx, srcKey, dstKey)
+	})
+}
+
+func (r *retryStorage) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	return r.retry(ctx, "MoveFile", srcKey, nil, func() error {
+		return r.RemoteStorage.MoveFile(ctx, srcKey, dstKey)
+	})
+}
+
+// GetFileReaderAt - shall be called only when wrapped storage implements RangeReader
+func (r *retryStorage) GetFileReaderAt(ctx context.Context, key string, offset int64) (reader io.ReadCloser, err error) {
+	err = r.retry(ctx, "GetFileReaderAt", key, nil, func() error {
+		reader, err = r.RemoteStorage.(RangeReader).GetFileReaderAt(ctx, key, offset)
+		return err
+	})
+	return reader, err
+}
+
+// PutFileResumable - shall be called only when wrapped storage implements ResumableStorage
+func (r *retryStorage) PutFileResumable(ctx context.Context, key string, body io.ReadCloser, state UploadState) error {
+	stream := &touchedReadCloser{ReadCloser: body}
+	return r.retry(ctx, "PutFileResumable", key, stream.untouched, func() error {
+		return r.RemoteStorage.(ResumableStorage).PutFileResumable(ctx, key, stream, state)
+	})
+}
@@ -0,0 +1,117 @@
+package new_storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyStorage - first failures requests return err, PutFile read body before fail when readBody is true
+type flakyStorage struct {
+	rangeStorage
+	failures int
+	err      error
+	readBody bool
+	calls    int
+}
+
+func (s *flakyStorage) fail() error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyStorage) StatFile(context.Context, string) (RemoteFile, error) {
+	return nil, s.fail()
+}
+
+func (s *flakyStorage) PutFile(_ context.Context, _ string, r io.ReadCloser) error {
+	if s.readBody {
+		if _, err := ioutil.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	return s.fail()
+}
+
+func (s *flakyStorage) Walk(_ context.Context, _ string, _ bool, fn func(RemoteFile) error) error {
+	if err := fn(&gcsFile{name: "a"}); err != nil {
+		return err
+	}
+	return s.fail()
+}
+
+func TestRetryStorage(t *testing.T) {
+	networkErr := errors.New("connection reset by peer")
+	storage := &flakyStorage{failures: 2, err: networkErr}
+	retry := &retryStorage{RemoteStorage: storage, retries: 3, pause: time.Millisecond, maxPause: 2 * time.Millisecond}
+	retriesBefore := StorageRetries()
+	_, err := retry.StatFile(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, storage.calls)
+	assert.Equal(t, uint64(2), StorageRetries()-retriesBefore)
+
+	storage.calls, storage.failures = 0, 5
+	_, err = retry.StatFile(context.Background(), "a")
+	assert.ErrorIs(t, err, networkErr)
+	assert.Equal(t, 4, storage.calls, "first attempt and 3 retries")
+
+	storage.calls, storage.err = 0, ErrNotFound
+	_, err = retry.StatFile(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, storage.calls, "missing file is not retried")
+
+	storage.calls, storage.failures, storage.err = 0, 1, networkErr
+	assert.NoError(t, retry.PutFile(context.Background(), "a", ioutil.NopCloser(bytes.NewReader([]byte("data")))))
+	assert.Equal(t, 2, storage.calls, "stream was not read")
+	storage.calls, storage.readBody = 0, true
+	assert.ErrorIs(t, retry.PutFile(context.Background(), "a", ioutil.NopCloser(bytes.NewReader([]byte("data")))), networkErr)
+	assert.Equal(t, 1, storage.calls, "stream was read")
+
+	storage.calls = 0
+	processed := 0
+	err = retry.Walk(context.Background(), "/", true, func(RemoteFile) error {
+		processed++
+		return nil
+	})
+	assert.ErrorIs(t, err, networkErr)
+	assert.Equal(t, 1, processed, "processed files are not walked again")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	storage.calls, storage.failures = 0, 5
+	_, err = retry.StatFile(ctx, "a")
+	assert.Error(t, err)
+	assert.Equal(t, 1, storage.calls, "canceled context stop retries")
+}
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, isRetryableError(errors.New("connection reset by peer")))
+	assert.True(t, isRetryableError(awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, "")))
+	assert.True(t, isRetryableError(awserr.NewRequestFailure(awserr.New("RequestTimeout", "timeout", nil), http.StatusRequestTimeout, "")))
+	assert.False(t, isRetryableError(awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "")))
+	assert.True(t, isRetryableError(&textproto.Error{Code: 421, Msg: "too many connections"}))
+	assert.False(t, isRetryableError(&textproto.Error{Code: 550, Msg: "file unavailable"}))
+	assert.False(t, isRetryableError(context.Canceled))
+	assert.False(t, isRetryableError(ErrNotFound))
+}
+
+func TestRetryPause(t *testing.T) {
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		pause := retryPause(attempt, time.Second, 5*time.Second)
+		assert.GreaterOrEqual(t, pause, expected/2)
+		assert.LessOrEqual(t, pause, expected)
+	}
+	assert.Equal(t, time.Duration(0), retryPause(1, 0, 0))
+}
@@ -1,17 +1,25 @@
 package new_storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/tls"
+	"encoding/hex"
+	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/apex/log"
 	"github.com/aws/aws-sdk-go/aws"
@@ -56,7 +64,7 @@ type S3 struct {
 }
 
 // Connect - connect to s3
-func (s *S3) Connect() error {
+func (s *S3) Connect(ctx context.Context) error {
 	var err error
 
 	awsDefaults := defaults.Get()
@@ -132,12 +140,13 @@ func (s *S3) Kind() string {
 	return "S3"
 }
 
-func (s *S3) GetFileReader(key string) (io.ReadCloser, error) {
+func (s *S3) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	svc := s3.New(s.session)
 	req, resp := svc.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	})
+	req.SetContext(ctx)
 	if err := req.Send(); err != nil {
 		return nil, err
 	}
@@ -145,7 +154,21 @@ func (s *S3) GetFileReader(key string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-func (s *S3) GetFileReaderWithLocalPath(key, localPath string) (io.ReadCloser, error) {
+func (s *S3) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	svc := s3.New(s.session)
+	req, resp := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+	})
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
 	/* unfortunately, multipart download require allocate additional disk space
 	and don't allow us to decompress data directly from stream */
 	if s.Config.AllowMultipartDownload {
@@ -153,25 +176,28 @@ func (s *S3) GetFileReaderWithLocalPath(key, localPath string) (io.ReadCloser, e
 		if err != nil {
 			return nil, err
 		}
-		_, err = s.downloader.Download(writer, &s3.GetObjectInput{
+		_, err = s.downloader.DownloadWithContext(ctx, writer, &s3.GetObjectInput{
 			Bucket: aws.String(s.Config.Bucket),
 			Key:    aws.String(path.Join(s.Config.Path, key)),
 		})
 		if err != nil {
+			_ = writer.Close()
+			_ = os.Remove(writer.Name())
 			return nil, err
 		}
 		return writer, nil
 	} else {
-		return s.GetFileReader(key)
+		return s.GetFileReader(ctx, key)
 	}
 }
 
-func (s *S3) PutFile(key string, r io.ReadCloser) error {
+// PutFile - s3manager abort multipart upload on remote storage when ctx is canceled
+func (s *S3) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 	var sse *string
 	if s.Config.SSE != "" {
 		sse = aws.String(s.Config.SSE)
 	}
-	_, err := s.uploader.Upload(&s3manager.UploadInput{
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		ACL:                  aws.String(s.Config.ACL),
 		Bucket:               aws.String(s.Config.Bucket),
 		Key:                  aws.String(path.Join(s.Config.Path, key)),
@@ -182,20 +208,316 @@ func (s *S3) PutFile(key string, r io.ReadCloser) error {
 	return err
 }
 
-func (s *S3) DeleteFile(key string) error {
+// PutFileResumable - multipart upload with upload_id saved in state, parts uploaded before interruption with the same content are not uploaded again,
+// when ctx is canceled multipart upload is aborted on remote storage, so only completed files are skipped by `upload --resume`
+func (s *S3) PutFileResumable(ctx context.Context, key string, r io.ReadCloser, state UploadState) error {
+	svc := s3.New(s.session)
+	remoteKey := path.Join(s.Config.Path, key)
+	uploadedParts := map[int64]*s3.Part{}
+	upload := state.GetMultipartUpload(key)
+	if upload != nil {
+		parts, err := s.listParts(ctx, svc, remoteKey, upload.UploadID)
+		if err != nil || upload.PartSize != s.PartSize {
+			log.Warnf("can't continue multipart upload %s, will upload from the beginning, part_size=%d previous part_size=%d, error: %v", key, s.PartSize, upload.PartSize, err)
+			if err == nil {
+				s.abortMultipartUpload(ctx, svc, remoteKey, upload.UploadID)
+			}
+			upload = nil
+		} else {
+			for _, p := range parts {
+				uploadedParts[*p.PartNumber] = p
+			}
+		}
+	}
+	buf := make([]byte, s.PartSize)
+	n, readErr := io.ReadFull(r, buf)
+	if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+		// whole file fits into one part, multipart upload is not required
+		if upload != nil {
+			s.abortMultipartUpload(ctx, svc, remoteKey, upload.UploadID)
+		}
+		return s.PutFile(ctx, key, io.NopCloser(bytes.NewReader(buf[:n])))
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if upload == nil {
+		var sse *string
+		if s.Config.SSE != "" {
+			sse = aws.String(s.Config.SSE)
+		}
+		created, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			ACL:                  aws.String(s.Config.ACL),
+			Bucket:               aws.String(s.Config.Bucket),
+			Key:                  aws.String(remoteKey),
+			ServerSideEncryption: sse,
+			StorageClass:         aws.String(strings.ToUpper(s.Config.StorageClass)),
+		})
+		if err != nil {
+			return err
+		}
+		upload = &MultipartUpload{UploadID: *created.UploadId, PartSize: s.PartSize}
+		if err = state.SaveMultipartUpload(key, *upload); err != nil {
+			return err
+		}
+	}
+
+	g, partsCtx := errgroup.WithContext(ctx)
+	partsSemaphore := semaphore.NewWeighted(int64(s.Concurrency))
+	completedParts := make([]*s3.CompletedPart, 0)
+	completedMutex := sync.Mutex{}
+	skippedParts := 0
+	var uploadErr error
+	for partNumber := int64(1); ; partNumber++ {
+		part := buf[:n]
+		if p, exists := uploadedParts[partNumber]; exists && isSameS3Part(p, part) {
+			completedMutex.Lock()
+			completedParts = append(completedParts, &s3.CompletedPart{ETag: p.ETag, PartNumber: aws.Int64(partNumber)})
+			completedMutex.Unlock()
+			skippedParts++
+		} else {
+			if uploadErr = partsSemaphore.Acquire(partsCtx, 1); uploadErr != nil {
+				break
+			}
+			number := partNumber
+			g.Go(func() error {
+				defer partsSemaphore.Release(1)
+				uploaded, err := svc.UploadPartWithContext(partsCtx, &s3.UploadPartInput{
+					Bucket:        aws.String(s.Config.Bucket),
+					Key:           aws.String(remoteKey),
+					UploadId:      aws.String(upload.UploadID),
+					PartNumber:    aws.Int64(number),
+					Body:          bytes.NewReader(part),
+					ContentLength: aws.Int64(int64(len(part))),
+				})
+				if err != nil {
+					return fmt.Errorf("can't upload part %d of %s: %v", number, key, err)
+				}
+				completedMutex.Lock()
+				completedParts = append(completedParts, &s3.CompletedPart{ETag: uploaded.ETag, PartNumber: aws.Int64(number)})
+				completedMutex.Unlock()
+				return nil
+			})
+			// part buffer is owned by upload goroutine
+			buf = make([]byte, s.PartSize)
+		}
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		n, readErr = io.ReadFull(r, buf)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			uploadErr = readErr
+			break
+		}
+	}
+	if err := g.Wait(); err != nil {
+		uploadErr = err
+	}
+	if uploadErr == nil {
+		uploadErr = ctx.Err()
+	}
+	if uploadErr != nil {
+		if ctx.Err() != nil {
+			s.abortMultipartUpload(context.Background(), svc, remoteKey, upload.UploadID)
+		}
+		return uploadErr
+	}
+	sort.Slice(completedParts, func(i, j int) bool {
+		return *completedParts[i].PartNumber < *completedParts[j].PartNumber
+	})
+	if _, err := svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Config.Bucket),
+		Key:             aws.String(remoteKey),
+		UploadId:        aws.String(upload.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
+	}); err != nil {
+		return fmt.Errorf("can't complete multipart upload %s: %v", key, err)
+	}
+	if skippedParts > 0 {
+		log.Debugf("resume multipart upload %s, skip %d parts uploaded before", key, skippedParts)
+	}
+	return nil
+}
+
+func (s *S3) listParts(ctx context.Context, svc *s3.S3, remoteKey, uploadID string) ([]*s3.Part, error) {
+	parts := make([]*s3.Part, 0)
+	err := svc.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(s.Config.Bucket),
+		Key:      aws.String(remoteKey),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		parts = append(parts, page.Parts...)
+		return !lastPage
+	})
+	return parts, err
+}
+
+func (s *S3) abortMultipartUpload(ctx context.Context, svc *s3.S3, remoteKey, uploadID string) {
+	if _, err := svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.Config.Bucket),
+		Key:      aws.String(remoteKey),
+		UploadId: aws.String(uploadID),
+	}); err != nil {
+		log.Warnf("can't abort multipart upload %s of %s: %v", uploadID, remoteKey, err)
+	}
+}
+
+// isSameS3Part - ETag of part is MD5 of content, except SSE-KMS and SSE-C encryption, then part just uploaded again
+func isSameS3Part(p *s3.Part, content []byte) bool {
+	if p.Size == nil || p.ETag == nil || *p.Size != int64(len(content)) {
+		return false
+	}
+	hash := md5.Sum(content)
+	return strings.Trim(*p.ETag, "\"") == hex.EncodeToString(hash[:])
+}
+
+func (s *S3) DeleteFile(ctx context.Context, key string) error {
 	params := &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	}
-	if _, err := s3.New(s.session).DeleteObject(params); err != nil {
+	if _, err := s3.New(s.session).DeleteObjectWithContext(ctx, params); err != nil {
 		return errors.Wrapf(err, "DeleteFile, deleting object %+v", params)
 	}
 	return nil
 }
 
-func (s *S3) StatFile(key string) (RemoteFile, error) {
+// DeleteFiles - DeleteObjects by batches of 1000 keys, S3 compatible storages without DeleteObjects support delete objects one by one
+func (s *S3) DeleteFiles(ctx context.Context, keys []string) error {
 	svc := s3.New(s.session)
-	head, err := svc.HeadObject(&s3.HeadObjectInput{
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]*s3.ObjectIdentifier, end-start)
+		for i, key := range keys[start:end] {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(path.Join(s.Config.Path, key))}
+		}
+		result, err := svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Config.Bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			return deleteFilesConcurrently(ctx, keys[start:], s.DeleteFile, func(error) bool { return false })
+		}
+		if err != nil {
+			return errors.Wrapf(err, "DeleteFiles, deleting %d objects from %s", len(objects), s.Config.Bucket)
+		}
+		if len(result.Errors) > 0 {
+			e := result.Errors[0]
+			return fmt.Errorf("DeleteFiles, %d of %d objects are not deleted, %s: %s %s", len(result.Errors), len(objects), aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
+
+// s3MaxCopyObjectSize - CopyObject copy objects up to 5GiB, bigger objects are copied by UploadPartCopy
+const s3MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// s3CopySource - bucket and key of source object with escaped segments
+func s3CopySource(bucket, key string) string {
+	segments := strings.Split(path.Join(bucket, key), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// CopyFile - server-side CopyObject, objects bigger than 5GiB are copied by parts with `concurrency` parallel UploadPartCopy requests
+func (s *S3) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	svc := s3.New(s.session)
+	copySource := s3CopySource(s.Config.Bucket, path.Join(s.Config.Path, srcKey))
+	dstRemoteKey := path.Join(s.Config.Path, dstKey)
+	src, err := s.StatFile(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	var sse *string
+	if s.Config.SSE != "" {
+		sse = aws.String(s.Config.SSE)
+	}
+	if src.Size() <= s3MaxCopyObjectSize {
+		_, err = svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			ACL:                  aws.String(s.Config.ACL),
+			Bucket:               aws.String(s.Config.Bucket),
+			Key:                  aws.String(dstRemoteKey),
+			CopySource:           aws.String(copySource),
+			ServerSideEncryption: sse,
+			StorageClass:         aws.String(strings.ToUpper(s.Config.StorageClass)),
+		})
+		return err
+	}
+	created, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		ACL:                  aws.String(s.Config.ACL),
+		Bucket:               aws.String(s.Config.Bucket),
+		Key:                  aws.String(dstRemoteKey),
+		ServerSideEncryption: sse,
+		StorageClass:         aws.String(strings.ToUpper(s.Config.StorageClass)),
+	})
+	if err != nil {
+		return err
+	}
+	partSize := minPartSize(src.Size(), 10000, 512*1024*1024)
+	parts := make([]*s3.CompletedPart, (src.Size()+partSize-1)/partSize)
+	sem := semaphore.NewWeighted(int64(s.Config.Concurrency))
+	g, partsCtx := errgroup.WithContext(ctx)
+	for i := range parts {
+		if err := sem.Acquire(partsCtx, 1); err != nil {
+			break
+		}
+		partNumber := int64(i + 1)
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end >= src.Size() {
+			end = src.Size() - 1
+		}
+		i := i
+		g.Go(func() error {
+			defer sem.Release(1)
+			copied, err := svc.UploadPartCopyWithContext(partsCtx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(s.Config.Bucket),
+				Key:             aws.String(dstRemoteKey),
+				CopySource:      aws.String(copySource),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				PartNumber:      aws.Int64(partNumber),
+				UploadId:        created.UploadId,
+			})
+			if err != nil {
+				return err
+			}
+			parts[i] = &s3.CompletedPart{ETag: copied.CopyPartResult.ETag, PartNumber: aws.Int64(partNumber)}
+			return nil
+		})
+	}
+	if err = g.Wait(); err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		_, err = svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.Config.Bucket),
+			Key:             aws.String(dstRemoteKey),
+			UploadId:        created.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		s.abortMultipartUpload(context.Background(), svc, dstRemoteKey, *created.UploadId)
+		return errors.Wrapf(err, "CopyFile, copying %s to %s by parts", srcKey, dstKey)
+	}
+	return nil
+}
+
+func (s *S3) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	return moveFileByCopy(ctx, s, srcKey, dstKey)
+}
+
+func (s *S3) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	svc := s3.New(s.session)
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	})
@@ -206,42 +528,41 @@ func (s *S3) StatFile(key string) (RemoteFile, error) {
 		}
 		return nil, err
 	}
-	return &s3File{*head.ContentLength, *head.LastModified, key}, nil
+	etag := aws.StringValue(head.ETag)
+	return &s3File{size: *head.ContentLength, lastModified: *head.LastModified, name: key, etag: etag, checksum: s.etagChecksum(etag)}, nil
 }
 
-func (s *S3) Walk(s3Path string, recursive bool, process func(r RemoteFile) error) error {
-	g, _ := errgroup.WithContext(context.Background())
-	s3Files := make(chan *s3File)
-	g.Go(func() error {
-		defer close(s3Files)
-		return s.remotePager(path.Join(s.Config.Path, s3Path), recursive, func(page *s3.ListObjectsV2Output) {
-			for _, cp := range page.CommonPrefixes {
-				s3Files <- &s3File{
-					name: strings.TrimPrefix(*cp.Prefix, path.Join(s.Config.Path, s3Path)),
-				}
-			}
-			for _, c := range page.Contents {
-				s3Files <- &s3File{
-					*c.Size,
-					*c.LastModified,
-					strings.TrimPrefix(*c.Key, path.Join(s.Config.Path, s3Path)),
-				}
+// Walk - pages are listed until the last one, listing stops on the first error returned by process
+func (s *S3) Walk(ctx context.Context, s3Path string, recursive bool, process func(r RemoteFile) error) error {
+	rootPath := path.Join(s.Config.Path, s3Path)
+	var processErr error
+	err := s.remotePager(ctx, rootPath, recursive, func(page *s3.ListObjectsV2Output) bool {
+		for _, cp := range page.CommonPrefixes {
+			if processErr = process(&s3File{name: strings.TrimPrefix(*cp.Prefix, rootPath)}); processErr != nil {
+				return false
 			}
-		})
-	})
-	g.Go(func() error {
-		var err error
-		for s3File := range s3Files {
-			if err == nil {
-				err = process(s3File)
+		}
+		for _, c := range page.Contents {
+			if processErr = process(&s3File{
+				size:         *c.Size,
+				lastModified: *c.LastModified,
+				name:         strings.TrimPrefix(*c.Key, rootPath),
+				etag:         aws.StringValue(c.ETag),
+				checksum:     s.etagChecksum(aws.StringValue(c.ETag)),
+			}); processErr != nil {
+				return false
 			}
 		}
-		return err
+		return true
 	})
-	return g.Wait()
+	if processErr != nil {
+		return processErr
+	}
+	return err
 }
 
-func (s *S3) remotePager(s3Path string, recursive bool, pager func(page *s3.ListObjectsV2Output)) error {
+// remotePager - pager return false to stop listing
+func (s *S3) remotePager(ctx context.Context, s3Path string, recursive bool, pager func(page *s3.ListObjectsV2Output) bool) error {
 	prefix := s3Path + "/"
 	if s3Path == "" || s3Path == "/" {
 		prefix = ""
@@ -255,16 +576,25 @@ func (s *S3) remotePager(s3Path string, recursive bool, pager func(page *s3.List
 		params.SetDelimiter("/")
 	}
 	wrapper := func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-		pager(page)
-		return !lastPage
+		return pager(page) && !lastPage
 	}
-	return s3.New(s.session).ListObjectsV2Pages(params, wrapper)
+	return s3.New(s.session).ListObjectsV2PagesWithContext(ctx, params, wrapper)
+}
+
+// etagChecksum - ETag of SSE-KMS objects is not MD5 of content
+func (s *S3) etagChecksum(etag string) string {
+	if s.Config.SSE == "aws:kms" {
+		return ""
+	}
+	return md5FromETag(etag)
 }
 
 type s3File struct {
 	size         int64
 	lastModified time.Time
 	name         string
+	etag         string
+	checksum     string
 }
 
 func (f *s3File) Size() int64 {
@@ -278,3 +608,11 @@ func (f *s3File) Name() string {
 func (f *s3File) LastModified() time.Time {
 	return f.lastModified
 }
+
+func (f *s3File) ETag() string {
+	return f.etag
+}
+
+func (f *s3File) Checksum() string {
+	return f.checksum
+}
@@ -0,0 +1,23 @@
+package new_storage
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSameS3Part(t *testing.T) {
+	content := []byte("hello")
+	// md5 of "hello"
+	etag := "\"5d41402abc4b2a76b9719d911017c592\""
+	assert.True(t, isSameS3Part(&s3.Part{ETag: aws.String(etag), Size: aws.Int64(5)}, content))
+	assert.False(t, isSameS3Part(&s3.Part{ETag: aws.String(etag), Size: aws.Int64(4)}, content), "size mismatch")
+	assert.False(t, isSameS3Part(&s3.Part{ETag: aws.String("\"a0b1\""), Size: aws.Int64(5)}, content), "encrypted part etag")
+	assert.False(t, isSameS3Part(&s3.Part{}, content))
+}
+
+func TestS3CopySource(t *testing.T) {
+	assert.Equal(t, "bucket/backup/b%201/shadow/db/t%23/part.tar", s3CopySource("bucket", "/backup/b 1/shadow/db/t#/part.tar"))
+}
@@ -1,11 +1,13 @@
 package new_storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
 	"io"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -29,7 +31,7 @@ func (sftp *SFTP) Debug(msg string, v ...interface{}) {
 	}
 }
 
-func (sftp *SFTP) Connect() error {
+func (sftp *SFTP) Connect(ctx context.Context) error {
 	authMethods := []ssh.AuthMethod{}
 
 	if sftp.Config.Key == "" && sftp.Config.Password == "" {
@@ -86,7 +88,7 @@ func (sftp *SFTP) Kind() string {
 	return "SFTP"
 }
 
-func (sftp *SFTP) StatFile(key string) (RemoteFile, error) {
+func (sftp *SFTP) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	filePath := path.Join(sftp.Config.Path, key)
 
 	stat, err := sftp.client.Stat(filePath)
@@ -105,7 +107,7 @@ func (sftp *SFTP) StatFile(key string) (RemoteFile, error) {
 	}, nil
 }
 
-func (sftp *SFTP) DeleteFile(key string) error {
+func (sftp *SFTP) DeleteFile(ctx context.Context, key string) error {
 	sftp.Debug("[SFTP_DEBUG] Delete %s", key)
 	filePath := path.Join(sftp.Config.Path, key)
 
@@ -121,6 +123,13 @@ func (sftp *SFTP) DeleteFile(key string) error {
 	}
 }
 
+// DeleteFiles - SFTP client send parallel requests over the same connection
+func (sftp *SFTP) DeleteFiles(ctx context.Context, keys []string) error {
+	return deleteFilesConcurrently(ctx, keys, func(ctx context.Context, key string) error {
+		return sftp.client.Remove(path.Join(sftp.Config.Path, key))
+	}, func(err error) bool { return errors.Is(err, os.ErrNotExist) })
+}
+
 func (sftp *SFTP) DeleteDirectory(dirPath string) error {
 	sftp.Debug("[SFTP_DEBUG] DeleteDirectory %s", dirPath)
 	defer sftp.client.RemoveDirectory(dirPath)
@@ -142,7 +151,7 @@ func (sftp *SFTP) DeleteDirectory(dirPath string) error {
 	return nil
 }
 
-func (sftp *SFTP) Walk(remotePath string, recursive bool, process func(RemoteFile) error) error {
+func (sftp *SFTP) Walk(ctx context.Context, remotePath string, recursive bool, process func(RemoteFile) error) error {
 	dir := path.Join(sftp.Config.Path, remotePath)
 	sftp.Debug("[SFTP_DEBUG] Walk %s, recursive=%v", dir, recursive)
 
@@ -152,8 +161,12 @@ func (sftp *SFTP) Walk(remotePath string, recursive bool, process func(RemoteFil
 			if err := walker.Err(); err != nil {
 				return err
 			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// recursive Walk return only files the same as object storages
 			entry := walker.Stat()
-			if entry == nil {
+			if entry == nil || entry.IsDir() {
 				continue
 			}
 			relName, _ := filepath.Rel(dir, walker.Path())
@@ -173,6 +186,9 @@ func (sftp *SFTP) Walk(remotePath string, recursive bool, process func(RemoteFil
 			return err
 		}
 		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			err := process(&sftpFile{
 				size:         entry.Size(),
 				lastModified: entry.ModTime(),
@@ -186,17 +202,29 @@ func (sftp *SFTP) Walk(remotePath string, recursive bool, process func(RemoteFil
 	return nil
 }
 
-func (sftp *SFTP) GetFileReader(key string) (io.ReadCloser, error) {
+func (sftp *SFTP) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	filePath := path.Join(sftp.Config.Path, key)
 	sftp.client.MkdirAll(path.Dir(filePath))
 	return sftp.client.OpenFile(filePath, syscall.O_RDWR)
 }
 
-func (sftp *SFTP) GetFileReaderWithLocalPath(key, _ string) (io.ReadCloser, error) {
-	return sftp.GetFileReader(key)
+func (sftp *SFTP) GetFileReaderAt(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	f, err := sftp.client.Open(path.Join(sftp.Config.Path, key))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
 }
 
-func (sftp *SFTP) PutFile(key string, localFile io.ReadCloser) error {
+func (sftp *SFTP) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return sftp.GetFileReader(ctx, key)
+}
+
+func (sftp *SFTP) PutFile(ctx context.Context, key string, localFile io.ReadCloser) error {
 	filePath := path.Join(sftp.Config.Path, key)
 	sftp.client.MkdirAll(path.Dir(filePath))
 	remoteFile, err := sftp.client.Create(filePath)
@@ -210,6 +238,19 @@ func (sftp *SFTP) PutFile(key string, localFile io.ReadCloser) error {
 	return nil
 }
 
+// CopyFile - SFTP protocol doesn't have copy, file is streamed through host
+func (sftp *SFTP) CopyFile(ctx context.Context, srcKey, dstKey string) error {
+	return copyFileByStream(ctx, sftp, srcKey, dstKey)
+}
+
+func (sftp *SFTP) MoveFile(ctx context.Context, srcKey, dstKey string) error {
+	dstPath := path.Join(sftp.Config.Path, dstKey)
+	if err := sftp.client.MkdirAll(path.Dir(dstPath)); err != nil {
+		return err
+	}
+	return sftp.client.Rename(path.Join(sftp.Config.Path, srcKey), dstPath)
+}
+
 // Implement RemoteFile
 type sftpFile struct {
 	size         int64
@@ -225,6 +266,14 @@ func (file *sftpFile) LastModified() time.Time {
 	return file.lastModified
 }
 
+func (file *sftpFile) ETag() string {
+	return ""
+}
+
+func (file *sftpFile) Checksum() string {
+	return ""
+}
+
 func (file *sftpFile) Name() string {
 	return file.name
 }
@@ -1,6 +1,7 @@
 package new_storage
 
 import (
+	"context"
 	"errors"
 	"io"
 	"time"
@@ -17,16 +18,25 @@ type RemoteFile interface {
 	Size() int64
 	Name() string
 	LastModified() time.Time
+	// ETag - opaque version of content reported by storage, empty for SFTP, FTP and folders
+	ETag() string
+	// Checksum - hex MD5 of content when storage knows it, empty for multipart and KMS encrypted objects, GCS composite objects, SFTP and FTP
+	Checksum() string
 }
 
-// RemoteStorage -
+// RemoteStorage - each request is aborted when ctx is canceled
 type RemoteStorage interface {
 	Kind() string
-	StatFile(key string) (RemoteFile, error)
-	DeleteFile(key string) error
-	Connect() error
-	Walk(prefix string, recursive bool, fn func(RemoteFile) error) error
-	GetFileReader(key string) (io.ReadCloser, error)
-	GetFileReaderWithLocalPath(key, localPath string) (io.ReadCloser, error)
-	PutFile(key string, r io.ReadCloser) error
+	StatFile(ctx context.Context, key string) (RemoteFile, error)
+	DeleteFile(ctx context.Context, key string) error
+	// DeleteFiles - delete many files with batch API when storage has it, missing files are not an error
+	DeleteFiles(ctx context.Context, keys []string) error
+	Connect(ctx context.Context) error
+	Walk(ctx context.Context, prefix string, recursive bool, fn func(RemoteFile) error) error
+	GetFileReader(ctx context.Context, key string) (io.ReadCloser, error)
+	GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error)
+	PutFile(ctx context.Context, key string, r io.ReadCloser) error
+	// CopyFile, MoveFile - server-side copy and rename inside the same bucket or server, SFTP and FTP CopyFile stream data through host
+	CopyFile(ctx context.Context, srcKey, dstKey string) error
+	MoveFile(ctx context.Context, srcKey, dstKey string) error
 }
@@ -0,0 +1,96 @@
+package new_storage
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+// bandwidthLimiter - token bucket shared by all transfers of the same direction in process, bucket holds one second of traffic,
+// limit is applied only inside schedule windows, empty schedule means always
+type bandwidthLimiter struct {
+	bytesPerSecond float64
+	schedule       []config.TimeWindow
+	tokens         float64
+	last           time.Time
+	now            func() time.Time
+	sleep          func(time.Duration)
+	sync.Mutex
+}
+
+var (
+	uploadLimiter   = &bandwidthLimiter{now: time.Now, sleep: time.Sleep}
+	downloadLimiter = &bandwidthLimiter{now: time.Now, sleep: time.Sleep}
+)
+
+// setBandwidthLimits - apply `upload_max_bytes_per_second`, `download_max_bytes_per_second` and `throttle_schedule`, config is validated before
+func setBandwidthLimits(cfg *config.Config) {
+	schedule, _ := config.ParseThrottleSchedule(cfg.General.ThrottleSchedule)
+	uploadLimiter.setLimit(cfg.General.UploadMaxBytesPerSecond, schedule)
+	downloadLimiter.setLimit(cfg.General.DownloadMaxBytesPerSecond, schedule)
+}
+
+func (l *bandwidthLimiter) setLimit(bytesPerSecond uint64, schedule []config.TimeWindow) {
+	l.Lock()
+	defer l.Unlock()
+	if l.bytesPerSecond != float64(bytesPerSecond) {
+		l.tokens = float64(bytesPerSecond)
+		l.last = l.now()
+	}
+	l.bytesPerSecond = float64(bytesPerSecond)
+	l.schedule = schedule
+}
+
+func (l *bandwidthLimiter) active(now time.Time) bool {
+	if l.bytesPerSecond <= 0 {
+		return false
+	}
+	if len(l.schedule) == 0 {
+		return true
+	}
+	for _, window := range l.schedule {
+		if window.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait - take n bytes from bucket, when bucket is empty the debt is slept off, so concurrent transfers share the limit
+func (l *bandwidthLimiter) wait(n int) {
+	l.Lock()
+	now := l.now()
+	if !l.active(now) {
+		l.Unlock()
+		return
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.bytesPerSecond
+	if l.tokens > l.bytesPerSecond {
+		l.tokens = l.bytesPerSecond
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.bytesPerSecond * float64(time.Second))
+	}
+	l.Unlock()
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
+
+type throttledReadCloser struct {
+	io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (t throttledReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.limiter.wait(n)
+	}
+	return n, err
+}
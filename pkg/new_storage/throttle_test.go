@@ -0,0 +1,51 @@
+package new_storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.Local)
+	var slept time.Duration
+	l := &bandwidthLimiter{
+		now:   func() time.Time { return now },
+		sleep: func(d time.Duration) { slept += d; now = now.Add(d) },
+	}
+	l.wait(1 << 30)
+	assert.Zero(t, slept, "unlimited by default")
+
+	l.setLimit(1000, nil)
+	l.wait(1000)
+	assert.Zero(t, slept, "bucket holds one second of traffic")
+	l.wait(500)
+	assert.Equal(t, 500*time.Millisecond, slept)
+	l.wait(2000)
+	assert.Equal(t, 2500*time.Millisecond, slept)
+
+	slept = 0
+	schedule, err := config.ParseThrottleSchedule([]string{"08:00-11:00", "22:00-06:00"})
+	assert.NoError(t, err)
+	l.setLimit(1000, schedule)
+	l.wait(10000)
+	assert.Zero(t, slept, "12:00 is outside of schedule")
+	now = time.Date(2023, 1, 1, 23, 0, 0, 0, time.Local)
+	l.wait(1000)
+	l.wait(1000)
+	assert.Equal(t, time.Second, slept, "23:00 is inside window across midnight")
+}
+
+func TestParseThrottleSchedule(t *testing.T) {
+	windows, err := config.ParseThrottleSchedule([]string{"08:30-20:00"})
+	assert.NoError(t, err)
+	assert.Equal(t, []config.TimeWindow{{From: 8*time.Hour + 30*time.Minute, To: 20 * time.Hour}}, windows)
+	assert.True(t, windows[0].Contains(time.Date(2023, 1, 1, 8, 30, 0, 0, time.Local)))
+	assert.False(t, windows[0].Contains(time.Date(2023, 1, 1, 20, 0, 0, 0, time.Local)))
+	_, err = config.ParseThrottleSchedule([]string{"08:00"})
+	assert.Error(t, err)
+	_, err = config.ParseThrottleSchedule([]string{"08:00-25:00"})
+	assert.Error(t, err)
+}
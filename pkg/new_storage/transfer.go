@@ -0,0 +1,109 @@
+package new_storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+var (
+	uploadedBytes   uint64
+	downloadedBytes uint64
+)
+
+// UploadedBytes - total bytes sent to remote storage by the current process
+func UploadedBytes() uint64 {
+	return atomic.LoadUint64(&uploadedBytes)
+}
+
+// DownloadedBytes - total bytes received from remote storage by the current process
+func DownloadedBytes() uint64 {
+	return atomic.LoadUint64(&downloadedBytes)
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	counter *uint64
+}
+
+func (c countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		atomic.AddUint64(c.counter, uint64(n))
+	}
+	return n, err
+}
+
+// contextReadCloser - stop transfer when ctx is canceled, for storages which clients don't accept context
+type contextReadCloser struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (c contextReadCloser) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.ReadCloser.Read(p)
+}
+
+func (bd *BackupDestination) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	return bd.RemoteStorage.PutFile(ctx, key, newUploadReader(ctx, r))
+}
+
+func newUploadReader(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	return countingReadCloser{ReadCloser: throttledReadCloser{ReadCloser: contextReadCloser{ReadCloser: r, ctx: ctx}, limiter: uploadLimiter}, counter: &uploadedBytes}
+}
+
+func (bd *BackupDestination) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := bd.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return newDownloadReader(ctx, r), nil
+}
+
+// GetFileReaderWithLocalPath - S3 with allow_multipart_download return temporary local file, which is removed on Close
+func (bd *BackupDestination) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	r, err := bd.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+	if err != nil {
+		return nil, err
+	}
+	if f, isFile := r.(*os.File); isFile {
+		r = tempFileReadCloser{File: f}
+	}
+	return newDownloadReader(ctx, r), nil
+}
+
+func newDownloadReader(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	return countingReadCloser{ReadCloser: throttledReadCloser{ReadCloser: contextReadCloser{ReadCloser: r, ctx: ctx}, limiter: downloadLimiter}, counter: &downloadedBytes}
+}
+
+type tempFileReadCloser struct {
+	*os.File
+}
+
+func (f tempFileReadCloser) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Remove(f.File.Name())
+}
+
+// StorageUsage - total size and objects count stored on remote storage
+type StorageUsage struct {
+	Bytes   uint64
+	Objects uint64
+}
+
+// Usage - walk all objects on remote storage, could take a while for big buckets
+func (bd *BackupDestination) Usage(ctx context.Context) (StorageUsage, error) {
+	usage := StorageUsage{}
+	err := bd.Walk(ctx, "/", true, func(f RemoteFile) error {
+		usage.Bytes += uint64(f.Size())
+		usage.Objects++
+		return nil
+	})
+	return usage, err
+}
@@ -48,6 +48,37 @@ func GetBackupsToDelete(backups []Backup, keep int) []Backup {
 	return []Backup{}
 }
 
+// FilterBackupsByTags - backups which contain all tags, empty tags match all backups
+func FilterBackupsByTags(backups []Backup, tags map[string]string) []Backup {
+	if len(tags) == 0 {
+		return backups
+	}
+	filtered := make([]Backup, 0, len(backups))
+	for _, b := range backups {
+		if b.HasTags(tags) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+// excludeRequiredBackups - backups required by incremental backups which were filtered out before GetBackupsToDelete shall be kept too
+func excludeRequiredBackups(backupsToDelete, allBackups []Backup) []Backup {
+	required := map[string]bool{}
+	for _, b := range allBackups {
+		if b.RequiredBackup != "" {
+			required[b.RequiredBackup] = true
+		}
+	}
+	result := make([]Backup, 0, len(backupsToDelete))
+	for _, b := range backupsToDelete {
+		if !required[b.BackupName] {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
 func getArchiveWriter(format string, level int) (*archiver.CompressedArchive, error) {
 	switch format {
 	case "tar":
@@ -57,7 +88,7 @@ func getArchiveWriter(format string, level int) (*archiver.CompressedArchive, er
 	case "bzip2", "bz2":
 		return &archiver.CompressedArchive{Compression: archiver.Bz2{CompressionLevel: level}, Archival: archiver.Tar{}}, nil
 	case "gzip", "gz":
-		return &archiver.CompressedArchive{Compression: archiver.Gz{CompressionLevel: level, Multithreaded: true}, Archival: archiver.Tar{}}, nil
+		return &archiver.CompressedArchive{Compression: archiver.Gz{CompressionLevel: level, Multithreaded: !singleThreadCompression}, Archival: archiver.Tar{}}, nil
 	case "sz":
 		return &archiver.CompressedArchive{Compression: archiver.Sz{}, Archival: archiver.Tar{}}, nil
 	case "xz":
@@ -65,7 +96,11 @@ func getArchiveWriter(format string, level int) (*archiver.CompressedArchive, er
 	case "br", "brotli":
 		return &archiver.CompressedArchive{Compression: archiver.Brotli{Quality: level}, Archival: archiver.Tar{}}, nil
 	case "zstd":
-		return &archiver.CompressedArchive{Compression: archiver.Zstd{EncoderOptions: []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}}, Archival: archiver.Tar{}}, nil
+		options := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+		if singleThreadCompression {
+			options = append(options, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		}
+		return &archiver.CompressedArchive{Compression: archiver.Zstd{EncoderOptions: options}, Archival: archiver.Tar{}}, nil
 	}
 	return nil, fmt.Errorf("wrong compression_format: %s, supported: 'tar', 'lz4', 'bzip2', 'bz2', 'gzip', 'gz', 'sz', 'xz', 'br', 'brotli', 'zstd'", format)
 }
@@ -79,7 +114,7 @@ func getArchiveReader(format string) (*archiver.CompressedArchive, error) {
 	case "bzip2", "bz2":
 		return &archiver.CompressedArchive{Compression: archiver.Bz2{}, Archival: archiver.Tar{}}, nil
 	case "gzip", "gz":
-		return &archiver.CompressedArchive{Compression: archiver.Gz{Multithreaded: true}, Archival: archiver.Tar{}}, nil
+		return &archiver.CompressedArchive{Compression: archiver.Gz{Multithreaded: !singleThreadCompression}, Archival: archiver.Tar{}}, nil
 	case "sz":
 		return &archiver.CompressedArchive{Compression: archiver.Sz{}, Archival: archiver.Tar{}}, nil
 	case "xz":
@@ -87,11 +122,23 @@ func getArchiveReader(format string) (*archiver.CompressedArchive, error) {
 	case "br", "brotli":
 		return &archiver.CompressedArchive{Compression: archiver.Brotli{}, Archival: archiver.Tar{}}, nil
 	case "zstd":
+		if singleThreadCompression {
+			return &archiver.CompressedArchive{Compression: archiver.Zstd{DecoderOptions: []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)}}, Archival: archiver.Tar{}}, nil
+		}
 		return &archiver.CompressedArchive{Compression: archiver.Zstd{}, Archival: archiver.Tar{}}, nil
 	}
 	return nil, fmt.Errorf("wrong compression_format: %s, supported: 'tar', 'lz4', 'bzip2', 'bz2', 'gzip', 'gz', 'sz', 'xz', 'br', 'brotli', 'zstd'", format)
 }
 
+// isMultithreadedCompression - gzip and zstd encoders and decoders allocate buffers for each CPU
+func isMultithreadedCompression(format string) bool {
+	switch format {
+	case "gzip", "gz", "zstd":
+		return true
+	}
+	return false
+}
+
 func checkArchiveExtension(ext, format string) bool {
 	if (format == "gz" || format == "gzip") && ext != ".gz" && ext != ".gzip" {
 		return false
@@ -116,3 +163,17 @@ func checkArchiveExtension(ext, format string) bool {
 	}
 	return false
 }
+
+// md5FromETag - ETag of object uploaded with one PUT request is MD5 of content, multipart ETag contains `-<parts count>`
+func md5FromETag(etag string) string {
+	etag = strings.ToLower(strings.Trim(etag, "\""))
+	if len(etag) != 32 {
+		return ""
+	}
+	for _, c := range etag {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return ""
+		}
+	}
+	return etag
+}
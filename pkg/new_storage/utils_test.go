@@ -76,3 +76,26 @@ func TestGetBackupsToDeleteWithInvalidUploadDate(t *testing.T) {
 	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 2))
 
 }
+
+func TestFilterBackupsByTags(t *testing.T) {
+	testData := []Backup{
+		{metadata.BackupMetadata{BackupName: "1", Tags: "type=scheduled"}, false, "", "", timeParse("2022-03-03T18-08-01")},
+		{metadata.BackupMetadata{BackupName: "2", Tags: "type=manual"}, false, "", "", timeParse("2022-03-03T18-08-02")},
+		{metadata.BackupMetadata{BackupName: "3", Tags: "env=prod,type=scheduled", RequiredBackup: "2"}, false, "", "", timeParse("2022-03-03T18-08-03")},
+		{metadata.BackupMetadata{BackupName: "4"}, false, "", "", timeParse("2022-03-03T18-08-04")},
+	}
+	assert.Equal(t, testData, FilterBackupsByTags(testData, nil))
+	scheduled := FilterBackupsByTags(testData, map[string]string{"type": "scheduled"})
+	assert.Equal(t, []Backup{testData[0], testData[2]}, scheduled)
+	assert.Equal(t, []Backup{testData[2]}, FilterBackupsByTags(testData, map[string]string{"type": "scheduled", "env": "prod"}))
+
+	// manual backup required by scheduled incremental backup is kept, even when it is not counted by retention
+	assert.Equal(t, []Backup{testData[0]}, excludeRequiredBackups([]Backup{testData[0], testData[1]}, testData))
+}
+
+func TestMD5FromETag(t *testing.T) {
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", md5FromETag("\"5D41402ABC4B2A76B9719D911017C592\""))
+	assert.Equal(t, "", md5FromETag("\"5d41402abc4b2a76b9719d911017c592-3\""), "multipart upload")
+	assert.Equal(t, "", md5FromETag("W/\"0x8D9\""))
+	assert.Equal(t, "", md5FromETag(""))
+}
@@ -0,0 +1,126 @@
+package new_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// listServer - return keys by pages of two keys, continuation token and marker are index of the next key
+type listServer struct {
+	keys     []string
+	requests int
+}
+
+func (l *listServer) page(start string) (int, int, bool) {
+	from := 0
+	for i, key := range l.keys {
+		if key == start {
+			from = i + 1
+		}
+	}
+	to := from + 2
+	if to > len(l.keys) {
+		to = len(l.keys)
+	}
+	return from, to, to < len(l.keys)
+}
+
+func (l *listServer) s3(w http.ResponseWriter, r *http.Request) {
+	l.requests++
+	from, to, truncated := l.page(r.URL.Query().Get("continuation-token"))
+	body := fmt.Sprintf("<ListBucketResult><IsTruncated>%v</IsTruncated>", truncated)
+	for _, key := range l.keys[from:to] {
+		body += fmt.Sprintf("<Contents><Key>%s</Key><Size>1</Size><LastModified>2022-01-01T00:00:00.000Z</LastModified><ETag>&quot;5d41402abc4b2a76b9719d911017c592&quot;</ETag></Contents>", key)
+	}
+	if truncated {
+		body += fmt.Sprintf("<NextContinuationToken>%s</NextContinuationToken>", l.keys[to-1])
+	}
+	_, _ = w.Write([]byte(body + "</ListBucketResult>"))
+}
+
+func (l *listServer) cos(w http.ResponseWriter, r *http.Request) {
+	l.requests++
+	from, to, truncated := l.page(r.URL.Query().Get("marker"))
+	body := fmt.Sprintf("<ListBucketResult><IsTruncated>%v</IsTruncated>", truncated)
+	for _, key := range l.keys[from:to] {
+		body += fmt.Sprintf("<Contents><Key>%s</Key><Size>1</Size></Contents>", key)
+	}
+	_, _ = w.Write([]byte(body + "</ListBucketResult>"))
+}
+
+func walkNames(storage RemoteStorage, stopAt string) ([]string, error) {
+	var names []string
+	err := storage.Walk(context.Background(), "/b", true, func(f RemoteFile) error {
+		names = append(names, f.Name())
+		if f.Name() == stopAt {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	return names, err
+}
+
+func TestS3WalkPagination(t *testing.T) {
+	l := &listServer{keys: []string{"backups/b/1", "backups/b/2", "backups/b/3", "backups/b/4", "backups/b/5"}}
+	server := httptest.NewServer(http.HandlerFunc(l.s3))
+	defer server.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+	})
+	require.NoError(t, err)
+	storage := &S3{session: sess, Config: &config.S3Config{Bucket: "bucket", Path: "backups"}}
+
+	names, err := walkNames(storage, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/1", "/2", "/3", "/4", "/5"}, names)
+	assert.Equal(t, 3, l.requests)
+
+	l.requests = 0
+	names, err = walkNames(storage, "/1")
+	assert.EqualError(t, err, "stop")
+	assert.Equal(t, []string{"/1"}, names)
+	assert.Equal(t, 1, l.requests, "next pages are not listed after error")
+}
+
+func TestCOSWalkPagination(t *testing.T) {
+	l := &listServer{keys: []string{"backups/b/1", "backups/b/2", "backups/b/3"}}
+	server := httptest.NewServer(http.HandlerFunc(l.cos))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	storage := &COS{client: cos.NewClient(&cos.BaseURL{BucketURL: u}, http.DefaultClient), Config: &config.COSConfig{Path: "backups"}}
+
+	names, err := walkNames(storage, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, names)
+	assert.Equal(t, 2, l.requests)
+
+	l.requests = 0
+	names, err = walkNames(storage, "2")
+	assert.EqualError(t, err, "stop")
+	assert.Equal(t, []string{"1", "2"}, names)
+	assert.Equal(t, 1, l.requests)
+}
+
+func TestNextCOSMarker(t *testing.T) {
+	assert.Equal(t, "next", nextCOSMarker(&cos.BucketGetResult{NextMarker: "next", Contents: []cos.Object{{Key: "a"}}}))
+	assert.Equal(t, "b", nextCOSMarker(&cos.BucketGetResult{Contents: []cos.Object{{Key: "a"}, {Key: "b"}}}))
+	assert.Equal(t, "c/", nextCOSMarker(&cos.BucketGetResult{Contents: []cos.Object{{Key: "a"}}, CommonPrefixes: []string{"c/"}}))
+	assert.Equal(t, "", nextCOSMarker(&cos.BucketGetResult{}))
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mxalis/clickhouse-backup/pkg/backup"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+
+	apexLog "github.com/apex/log"
+	"github.com/gorilla/mux"
+)
+
+// httpArchiveHandler - stream tar archive with local or remote backup files, optional `table` query argument allow stream only selected tables
+func (api *APIServer) httpArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.loadConfig()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "archive", err)
+		return
+	}
+	vars := mux.Vars(r)
+	tablePattern := r.URL.Query().Get("table")
+	pr, pw := io.Pipe()
+	go func() {
+		b := backup.NewBackuper(cfg)
+		_ = pw.CloseWithError(b.WriteBackupArchive(r.Context(), pw, vars["where"], vars["name"], tablePattern))
+	}()
+	defer pr.Close()
+	// read first block to properly return error before headers will sent
+	firstBlock := make([]byte, 512)
+	n, err := io.ReadFull(pr, firstBlock)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		writeError(w, http.StatusBadRequest, "archive", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tar\"", vars["name"]))
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(firstBlock[:n]); err != nil {
+		apexLog.Warnf("can't write archive %s: %v", vars["name"], err)
+		return
+	}
+	if _, err := io.Copy(w, pr); err != nil {
+		apexLog.Errorf("archive %s interrupted: %v", vars["name"], err)
+	}
+}
+
+// httpImportHandler - register tar archive from request body as new local backup
+func (api *APIServer) httpImportHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.loadConfig()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "import", err)
+		return
+	}
+	name := mux.Vars(r)["name"]
+	fullCommand := fmt.Sprintf("import %s", name)
+	commandId, err := api.status.tryStart("import", fullCommand, api.currentConfig().API)
+	if err != nil {
+		api.metrics.Reject("import")
+		writeOperationStartError(w, "import", err)
+		return
+	}
+	start := api.metrics.Start("import")
+	b := backup.NewBackuper(cfg)
+	err = b.ImportBackupArchive(r.Context(), r.Body, name)
+	api.status.stop(commandId, err)
+	api.metrics.Finish("import", start, err)
+	if err != nil {
+		apexLog.Errorf("Import error: %+v\n", err)
+		writeError(w, http.StatusBadRequest, fullCommand, err)
+		return
+	}
+	go func() {
+		if err := api.updateBackupMetrics(true); err != nil {
+			apexLog.Errorf("updateBackupMetrics return error: %v", err)
+		}
+	}()
+	sendJSONEachRow(w, http.StatusCreated, struct {
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
+	}{
+		Status:      "success",
+		Operation:   "import",
+		OperationId: commandId,
+		BackupName:  name,
+	})
+}
+
+// httpBackupFileHandler - stream single file from local or remote backup, support `Range` header
+func (api *APIServer) httpBackupFileHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.loadConfig()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "file", err)
+		return
+	}
+	vars := mux.Vars(r)
+	b := backup.NewBackuper(cfg)
+	reader, size, modTime, err := b.OpenBackupFile(r.Context(), vars["where"], vars["name"], vars["path"])
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, new_storage.ErrNotFound) || errors.Is(err, new_storage.ErrFileDoesNotExist) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, "file", err)
+		return
+	}
+	defer reader.Close()
+	fileName := path.Base(vars["path"])
+	if rs, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, fileName, modTime, rs)
+		return
+	}
+	serveReaderWithRange(w, r, reader, size, modTime)
+}
+
+// serveReaderWithRange - like http.ServeContent but for non seekable readers, only single range is supported, multiple ranges ignored and whole content is returned
+func serveReaderWithRange(w http.ResponseWriter, r *http.Request, reader io.Reader, size int64, modTime time.Time) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	start, length, err := parseSingleRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, "file", err)
+		return
+	}
+	status := http.StatusOK
+	if length != size {
+		if _, err := io.CopyN(ioutil.Discard, reader, start); err != nil {
+			writeError(w, http.StatusInternalServerError, "file", err)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, reader, length); err != nil {
+		apexLog.Warnf("can't send file: %v", err)
+	}
+}
+
+// parseSingleRange - parse `Range: bytes=start-end` header, return start and length, empty or multiple ranges header means whole content
+func parseSingleRange(rangeHeader string, size int64) (int64, int64, error) {
+	if rangeHeader == "" || strings.Contains(rangeHeader, ",") {
+		return 0, size, nil
+	}
+	if !strings.HasPrefix(rangeHeader, "bytes=") {
+		return 0, 0, fmt.Errorf("invalid range '%s'", rangeHeader)
+	}
+	startEnd := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+	if len(startEnd) != 2 {
+		return 0, 0, fmt.Errorf("invalid range '%s'", rangeHeader)
+	}
+	startStr, endStr := strings.TrimSpace(startEnd[0]), strings.TrimSpace(startEnd[1])
+	if startStr == "" {
+		// suffix range, last N bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, fmt.Errorf("invalid range '%s'", rangeHeader)
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, nil
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, fmt.Errorf("invalid range '%s' for size %d", rangeHeader, size)
+	}
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range '%s'", rangeHeader)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSingleRange(t *testing.T) {
+	testData := []struct {
+		header         string
+		expectedStart  int64
+		expectedLength int64
+		expectedErr    bool
+	}{
+		{"", 0, 100, false},
+		{"bytes=0-9", 0, 10, false},
+		{"bytes=90-", 90, 10, false},
+		{"bytes=90-200", 90, 10, false},
+		{"bytes=-20", 80, 20, false},
+		{"bytes=-200", 0, 100, false},
+		{"bytes=0-1,5-6", 0, 100, false},
+		{"bytes=100-", 0, 0, true},
+		{"bytes=10-5", 0, 0, true},
+		{"items=0-5", 0, 0, true},
+	}
+	for _, tc := range testData {
+		start, length, err := parseSingleRange(tc.header, 100)
+		if tc.expectedErr {
+			assert.Error(t, err, tc.header)
+			continue
+		}
+		assert.NoError(t, err, tc.header)
+		assert.Equal(t, tc.expectedStart, start, tc.header)
+		assert.Equal(t, tc.expectedLength, length, tc.header)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type backupJSON struct {
+	Name           string            `json:"name"`
+	Created        string            `json:"created"`
+	Size           uint64            `json:"size,omitempty"`
+	Location       string            `json:"location"`
+	RequiredBackup string            `json:"required"`
+	Desc           string            `json:"desc"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	CreatedTime    time.Time         `json:"-"`
+}
+
+func (b backupJSON) hasTags(tags map[string]string) bool {
+	for key, value := range tags {
+		if actual, exists := b.Tags[key]; !exists || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// backupListPage - structured response for GET /backup/list?format=json
+type backupListPage struct {
+	Total       int          `json:"total"`
+	TotalLocal  int          `json:"total_local"`
+	TotalRemote int          `json:"total_remote"`
+	Offset      int          `json:"offset"`
+	Limit       int          `json:"limit,omitempty"`
+	Backups     []backupJSON `json:"backups"`
+}
+
+// backupListFilter - filter, sort and paginate backup list, zero value doesn't change list
+type backupListFilter struct {
+	Name        string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	SortBy      string
+	Desc        bool
+	Offset      int
+	Limit       int
+	Tags        map[string]string
+}
+
+var backupListTimeFormats = []string{time.RFC3339, APITimeFormat, "2006-01-02"}
+
+func parseBackupListTime(name, value string) (time.Time, error) {
+	for _, layout := range backupListTimeFormats {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse %s='%s', use RFC3339, '%s' or '2006-01-02' format", name, value, APITimeFormat)
+}
+
+func parseBackupListFilter(query url.Values) (backupListFilter, error) {
+	var err error
+	f := backupListFilter{}
+	if f.Name = query.Get("name"); f.Name != "" {
+		if _, err = filepath.Match(f.Name, ""); err != nil {
+			return f, fmt.Errorf("wrong name pattern '%s': %v", f.Name, err)
+		}
+	}
+	if v := query.Get("created_from"); v != "" {
+		if f.CreatedFrom, err = parseBackupListTime("created_from", v); err != nil {
+			return f, err
+		}
+	}
+	if v := query.Get("created_to"); v != "" {
+		if f.CreatedTo, err = parseBackupListTime("created_to", v); err != nil {
+			return f, err
+		}
+	}
+	switch f.SortBy = query.Get("sort"); f.SortBy {
+	case "", "name", "created", "size":
+	default:
+		return f, fmt.Errorf("wrong sort='%s', use `name`, `created` or `size`", f.SortBy)
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, fmt.Errorf("wrong order='%s', use `asc` or `desc`", order)
+	}
+	if v := query.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			return f, fmt.Errorf("wrong offset='%s'", v)
+		}
+	}
+	for _, tag := range query["tag"] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return f, fmt.Errorf("wrong tag='%s', use `key=value` format", tag)
+		}
+		if f.Tags == nil {
+			f.Tags = map[string]string{}
+		}
+		f.Tags[kv[0]] = kv[1]
+	}
+	if v := query.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			return f, fmt.Errorf("wrong limit='%s'", v)
+		}
+	}
+	return f, nil
+}
+
+// apply - return requested page and list of all matched backups
+func (f backupListFilter) apply(backups []backupJSON) ([]backupJSON, []backupJSON) {
+	matched := make([]backupJSON, 0, len(backups))
+	for _, b := range backups {
+		if f.Name != "" {
+			if ok, _ := filepath.Match(f.Name, b.Name); !ok {
+				continue
+			}
+		}
+		if !f.CreatedFrom.IsZero() && b.CreatedTime.Before(f.CreatedFrom) {
+			continue
+		}
+		if !f.CreatedTo.IsZero() && b.CreatedTime.After(f.CreatedTo) {
+			continue
+		}
+		if !b.hasTags(f.Tags) {
+			continue
+		}
+		matched = append(matched, b)
+	}
+	if f.SortBy != "" {
+		sort.SliceStable(matched, func(i, j int) bool {
+			a, b := matched[i], matched[j]
+			if f.Desc {
+				a, b = b, a
+			}
+			switch f.SortBy {
+			case "created":
+				return a.CreatedTime.Before(b.CreatedTime)
+			case "size":
+				return a.Size < b.Size
+			default:
+				return a.Name < b.Name
+			}
+		})
+	}
+	if f.Offset >= len(matched) {
+		return []backupJSON{}, matched
+	}
+	page := matched[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(page) {
+		page = page[:f.Limit]
+	}
+	return page, matched
+}
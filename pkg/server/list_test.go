@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupListFilter(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2022, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	backups := []backupJSON{
+		{Name: "shard1-2022-01-01", Location: "local", Size: 30, CreatedTime: day(1)},
+		{Name: "shard1-2022-01-02", Location: "remote", Size: 10, CreatedTime: day(2)},
+		{Name: "shard2-2022-01-03", Location: "remote", Size: 20, CreatedTime: day(3), Tags: map[string]string{"env": "prod", "type": "manual"}},
+		{Name: "shard1-2022-01-04", Location: "remote", Size: 40, CreatedTime: day(4)},
+	}
+	names := func(list []backupJSON) []string {
+		result := make([]string, len(list))
+		for i := range list {
+			result[i] = list[i].Name
+		}
+		return result
+	}
+
+	f, err := parseBackupListFilter(url.Values{})
+	assert.NoError(t, err)
+	page, matched := f.apply(backups)
+	assert.Equal(t, backups, page)
+	assert.Len(t, matched, 4)
+
+	f, err = parseBackupListFilter(url.Values{"name": {"shard1-*"}, "sort": {"size"}, "order": {"desc"}, "limit": {"2"}})
+	assert.NoError(t, err)
+	page, matched = f.apply(backups)
+	assert.Equal(t, []string{"shard1-2022-01-04", "shard1-2022-01-01"}, names(page))
+	assert.Len(t, matched, 3)
+
+	f, err = parseBackupListFilter(url.Values{"created_from": {"2022-01-02"}, "created_to": {"2022-01-03 00:00:00"}, "offset": {"1"}})
+	assert.NoError(t, err)
+	page, matched = f.apply(backups)
+	assert.Equal(t, []string{"shard2-2022-01-03"}, names(page))
+	assert.Len(t, matched, 2)
+
+	f, err = parseBackupListFilter(url.Values{"tag": {"env=prod", "type=manual"}})
+	assert.NoError(t, err)
+	page, _ = f.apply(backups)
+	assert.Equal(t, []string{"shard2-2022-01-03"}, names(page))
+
+	f, err = parseBackupListFilter(url.Values{"offset": {"10"}})
+	assert.NoError(t, err)
+	page, _ = f.apply(backups)
+	assert.Empty(t, page)
+
+	for _, wrong := range []url.Values{{"sort": {"unknown"}}, {"order": {"up"}}, {"limit": {"-1"}}, {"created_from": {"yesterday"}}, {"name": {"["}}, {"tag": {"env"}}} {
+		_, err = parseBackupListFilter(wrong)
+		assert.Error(t, err, wrong.Encode())
+	}
+}
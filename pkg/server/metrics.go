@@ -0,0 +1,449 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+	"github.com/mxalis/clickhouse-backup/pkg/backup"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricsCommands = []string{"create", "upload", "download", "restore", "create_remote", "restore_remote"}
+
+type Metrics struct {
+	SuccessfulCounter map[string]prometheus.Counter
+	FailedCounter     map[string]prometheus.Counter
+	LastStart         map[string]prometheus.Gauge
+	LastFinish        map[string]prometheus.Gauge
+	LastSuccess       map[string]prometheus.Gauge
+	LastDuration      map[string]prometheus.Gauge
+	LastStatus        map[string]prometheus.Gauge
+	Duration          map[string]prometheus.Histogram
+	ErrorsCounter     *prometheus.CounterVec
+
+	LastBackupSizeLocal            prometheus.Gauge
+	LastBackupSizeRemote           prometheus.Gauge
+	LastBackupCompressedSizeRemote prometheus.Gauge
+	NumberBackupsRemote            prometheus.Gauge
+	NumberBackupsLocal             prometheus.Gauge
+	NumberBackupsRemoteExpected    prometheus.Gauge
+	NumberBackupsLocalExpected     prometheus.Gauge
+	NumberBackupsRemoteByAge       *prometheus.GaugeVec
+	RemoteStorageSize              *prometheus.GaugeVec
+	RemoteStorageObjects           *prometheus.GaugeVec
+	RemoteStorageUsageLastUpdate   prometheus.Gauge
+
+	Tables *tableMetrics
+}
+
+// remoteBackupAgeBuckets - upper bounds for number_backups_remote_by_age, backups older than last bound counted with "older" label
+var remoteBackupAgeBuckets = []struct {
+	label string
+	age   time.Duration
+}{
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"90d", 90 * 24 * time.Hour},
+	{"365d", 365 * 24 * time.Hour},
+}
+
+// backupAgeBucket - return age label for number_backups_remote_by_age
+func backupAgeBucket(age time.Duration) string {
+	for _, bucket := range remoteBackupAgeBuckets {
+		if age < bucket.age {
+			return bucket.label
+		}
+	}
+	return "older"
+}
+
+// setupMetrics - resister prometheus metrics
+func setupMetrics() Metrics {
+	m := Metrics{}
+	successfulCounter := map[string]prometheus.Counter{}
+	failedCounter := map[string]prometheus.Counter{}
+	lastStart := map[string]prometheus.Gauge{}
+	lastFinish := map[string]prometheus.Gauge{}
+	lastSuccess := map[string]prometheus.Gauge{}
+	lastDuration := map[string]prometheus.Gauge{}
+	lastStatus := map[string]prometheus.Gauge{}
+	duration := map[string]prometheus.Histogram{}
+
+	for _, command := range metricsCommands {
+		successfulCounter[command] = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("successful_%ss", command),
+			Help:      fmt.Sprintf("Counter of successful %ss backup", command),
+		})
+		failedCounter[command] = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("failed_%ss", command),
+			Help:      fmt.Sprintf("Counter of failed %ss backup", command),
+		})
+		lastStart[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_start", command),
+			Help:      fmt.Sprintf("Last backup %s start timestamp", command),
+		})
+		lastFinish[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_finish", command),
+			Help:      fmt.Sprintf("Last backup %s finish timestamp", command),
+		})
+		lastSuccess[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_success", command),
+			Help:      fmt.Sprintf("Last successful backup %s finish timestamp", command),
+		})
+		lastDuration[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_duration", command),
+			Help:      fmt.Sprintf("Backup %s duration in nanoseconds", command),
+		})
+		lastStatus[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_status", command),
+			Help:      fmt.Sprintf("Last backup %s status: 0=failed, 1=success, 2=unknown", command),
+		})
+		duration[command] = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("%s_duration_seconds", command),
+			Help:      fmt.Sprintf("Histogram of backup %s duration in seconds", command),
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 18),
+		})
+	}
+
+	m.SuccessfulCounter = successfulCounter
+	m.FailedCounter = failedCounter
+	m.LastStart = lastStart
+	m.LastFinish = lastFinish
+	m.LastSuccess = lastSuccess
+	m.LastDuration = lastDuration
+	m.LastStatus = lastStatus
+	m.Duration = duration
+
+	m.ErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "errors_total",
+		Help:      "Counter of failed operations by command and error type",
+	}, []string{"command", "type"})
+
+	m.LastBackupSizeLocal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_backup_size_local",
+		Help:      "Last local backup size in bytes",
+	})
+	m.LastBackupSizeRemote = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_backup_size_remote",
+		Help:      "Last remote backup size in bytes",
+	})
+	m.LastBackupCompressedSizeRemote = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_backup_compressed_size_remote",
+		Help:      "Last remote backup compressed size in bytes",
+	})
+
+	m.NumberBackupsRemote = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "number_backups_remote",
+		Help:      "Number of stored remote backups",
+	})
+
+	m.NumberBackupsRemoteExpected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "number_backups_remote_expected",
+		Help:      "How many backups expected on remote storage",
+	})
+
+	m.NumberBackupsLocal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "number_backups_local",
+		Help:      "Number of stored local backups",
+	})
+
+	m.NumberBackupsLocalExpected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "number_backups_local_expected",
+		Help:      "How many backups expected on local storage",
+	})
+
+	m.NumberBackupsRemoteByAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "number_backups_remote_by_age",
+		Help:      "Number of stored remote backups by creation age bucket",
+	}, []string{"remote_storage", "age"})
+
+	m.RemoteStorageSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_storage_size_bytes",
+		Help:      "Total size of all objects stored on remote storage",
+	}, []string{"remote_storage"})
+
+	m.RemoteStorageObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_storage_objects",
+		Help:      "Number of objects stored on remote storage",
+	}, []string{"remote_storage"})
+
+	m.RemoteStorageUsageLastUpdate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_storage_usage_last_update",
+		Help:      "Last time when remote_storage_size_bytes and remote_storage_objects was calculated",
+	})
+
+	for _, command := range metricsCommands {
+		prometheus.MustRegister(
+			m.SuccessfulCounter[command],
+			m.FailedCounter[command],
+			m.LastStart[command],
+			m.LastFinish[command],
+			m.LastSuccess[command],
+			m.LastDuration[command],
+			m.LastStatus[command],
+			m.Duration[command],
+		)
+		m.LastStatus[command].Set(2) // 0=failed, 1=success, 2=unknown
+	}
+
+	prometheus.MustRegister(
+		m.ErrorsCounter,
+		m.LastBackupSizeLocal,
+		m.LastBackupSizeRemote,
+		m.LastBackupCompressedSizeRemote,
+		m.NumberBackupsRemote,
+		m.NumberBackupsLocal,
+		m.NumberBackupsRemoteExpected,
+		m.NumberBackupsLocalExpected,
+		m.NumberBackupsRemoteByAge,
+		m.RemoteStorageSize,
+		m.RemoteStorageObjects,
+		m.RemoteStorageUsageLastUpdate,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "uploaded_bytes_total",
+			Help:      "Total bytes uploaded to remote storage",
+		}, func() float64 { return float64(new_storage.UploadedBytes()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "downloaded_bytes_total",
+			Help:      "Total bytes downloaded from remote storage",
+		}, func() float64 { return float64(new_storage.DownloadedBytes()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "storage_retries_total",
+			Help:      "Total retries of failed remote storage requests",
+		}, func() float64 { return float64(new_storage.StorageRetries()) }),
+		newStorageCollector(),
+	)
+
+	return m
+}
+
+// storageCollector - export request statistics of remote storage operations collected by new_storage on each scrape
+type storageCollector struct {
+	requests *prometheus.Desc
+	errors   *prometheus.Desc
+	bytes    *prometheus.Desc
+	duration *prometheus.Desc
+}
+
+func newStorageCollector() *storageCollector {
+	labels := []string{"storage", "operation"}
+	return &storageCollector{
+		requests: prometheus.NewDesc("clickhouse_backup_storage_requests_total", "Total requests to remote storage by operation", labels, nil),
+		errors:   prometheus.NewDesc("clickhouse_backup_storage_request_errors_total", "Total failed requests to remote storage by operation, missing files are not counted", labels, nil),
+		bytes:    prometheus.NewDesc("clickhouse_backup_storage_bytes_total", "Total bytes transferred by remote storage operation", labels, nil),
+		duration: prometheus.NewDesc("clickhouse_backup_storage_request_duration_seconds", "Histogram of remote storage request duration, readers are measured until response is received", labels, nil),
+	}
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.errors
+	ch <- c.bytes
+	ch <- c.duration
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range new_storage.StorageStats() {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(s.Requests), s.Kind, s.Operation)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), s.Kind, s.Operation)
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(s.Bytes), s.Kind, s.Operation)
+		buckets := make(map[float64]uint64, len(s.Buckets))
+		for i, bound := range new_storage.StorageLatencyBuckets {
+			buckets[bound] = s.Buckets[i]
+		}
+		ch <- prometheus.MustNewConstHistogram(c.duration, s.Requests, s.Seconds, buckets, s.Kind, s.Operation)
+	}
+}
+
+// Start - mark command as started, returns start time which shall be passed to Finish
+func (m *Metrics) Start(command string) time.Time {
+	start := time.Now()
+	if g, exists := m.LastStart[command]; exists {
+		g.Set(float64(start.Unix()))
+	}
+	return start
+}
+
+// Finish - update duration, status and counters for finished command
+func (m *Metrics) Finish(command string, start time.Time, err error) {
+	if _, exists := m.LastStatus[command]; !exists {
+		if err != nil {
+			m.ErrorsCounter.WithLabelValues(command, classifyError(err)).Inc()
+		}
+		return
+	}
+	finish := time.Now()
+	m.LastDuration[command].Set(float64(finish.Sub(start).Nanoseconds()))
+	m.LastFinish[command].Set(float64(finish.Unix()))
+	m.Duration[command].Observe(finish.Sub(start).Seconds())
+	if err != nil {
+		m.FailedCounter[command].Inc()
+		m.LastStatus[command].Set(0)
+		m.ErrorsCounter.WithLabelValues(command, classifyError(err)).Inc()
+		return
+	}
+	m.SuccessfulCounter[command].Inc()
+	m.LastStatus[command].Set(1)
+	m.LastSuccess[command].Set(float64(finish.Unix()))
+}
+
+// Reject - count operation which was not started due to API locks or limits
+func (m *Metrics) Reject(command string) {
+	m.ErrorsCounter.WithLabelValues(command, "rejected").Inc()
+}
+
+// classifyError - map error to small fixed set of types to keep errors_total cardinality low
+func classifyError(err error) string {
+	var chException *clickhouse.Exception
+	var netErr net.Error
+	var pathErr *os.PathError
+	switch {
+	case errors.Is(err, ErrAPILocked), errors.Is(err, ErrAPIConcurrencyLimit):
+		return "rejected"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &chException):
+		return "clickhouse"
+	case errors.Is(err, new_storage.ErrNotFound):
+		return "not_found"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.As(err, &pathErr):
+		return "filesystem"
+	}
+	return "other"
+}
+
+// tableMetrics - per-table metrics, only `limit` biggest tables are exported to keep labels cardinality bounded
+type tableMetrics struct {
+	mutex          sync.Mutex
+	limit          int
+	sizes          map[metadata.TableTitle]uint64
+	Size           *prometheus.GaugeVec
+	Parts          *prometheus.GaugeVec
+	FreezeDuration *prometheus.GaugeVec
+	UploadDuration *prometheus.GaugeVec
+	UploadSize     *prometheus.GaugeVec
+	Dropped        prometheus.Counter
+}
+
+func newTableMetrics(limit int) *tableMetrics {
+	labels := []string{"database", "table"}
+	return &tableMetrics{
+		limit: limit,
+		sizes: map[metadata.TableTitle]uint64{},
+		Size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "table_size_bytes",
+			Help:      "Table data size in last created backup",
+		}, labels),
+		Parts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "table_parts",
+			Help:      "Table data parts count in last created or uploaded backup",
+		}, labels),
+		FreezeDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "table_freeze_duration_seconds",
+			Help:      "Table FREEZE duration in last created backup",
+		}, labels),
+		UploadDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "table_upload_duration_seconds",
+			Help:      "Table upload duration in last uploaded backup",
+		}, labels),
+		UploadSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "table_upload_size_bytes",
+			Help:      "Table uploaded compressed data size in last uploaded backup",
+		}, labels),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "clickhouse_backup",
+			Name:      "table_metrics_dropped_total",
+			Help:      "Counter of tables skipped or evicted from per-table metrics due to table_metrics_limit",
+		}),
+	}
+}
+
+func (tm *tableMetrics) register() {
+	prometheus.MustRegister(tm.Size, tm.Parts, tm.FreezeDuration, tm.UploadDuration, tm.UploadSize, tm.Dropped)
+}
+
+// observe - backup.TableStats handler, when limit reached the smallest table is evicted in favor of bigger one
+func (tm *tableMetrics) observe(stats backup.TableStats) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	title := metadata.TableTitle{Database: stats.Database, Table: stats.Table}
+	if _, exists := tm.sizes[title]; !exists && len(tm.sizes) >= tm.limit {
+		var smallest metadata.TableTitle
+		smallestSize := uint64(math.MaxUint64)
+		for t, size := range tm.sizes {
+			if size < smallestSize {
+				smallest, smallestSize = t, size
+			}
+		}
+		if stats.Size <= smallestSize {
+			tm.Dropped.Inc()
+			return
+		}
+		tm.delete(smallest)
+		tm.Dropped.Inc()
+	}
+	labels := prometheus.Labels{"database": stats.Database, "table": stats.Table}
+	tm.Parts.With(labels).Set(float64(stats.Parts))
+	switch stats.Operation {
+	case "create":
+		tm.sizes[title] = stats.Size
+		tm.Size.With(labels).Set(float64(stats.Size))
+		tm.FreezeDuration.With(labels).Set(stats.Duration.Seconds())
+	case "upload":
+		if _, exists := tm.sizes[title]; !exists {
+			tm.sizes[title] = stats.Size
+		}
+		tm.UploadSize.With(labels).Set(float64(stats.Size))
+		tm.UploadDuration.With(labels).Set(stats.Duration.Seconds())
+	}
+}
+
+func (tm *tableMetrics) delete(title metadata.TableTitle) {
+	delete(tm.sizes, title)
+	labels := prometheus.Labels{"database": title.Database, "table": title.Table}
+	for _, vec := range []*prometheus.GaugeVec{tm.Size, tm.Parts, tm.FreezeDuration, tm.UploadDuration, tm.UploadSize} {
+		vec.Delete(labels)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+	"github.com/mxalis/clickhouse-backup/pkg/backup"
+	"github.com/mxalis/clickhouse-backup/pkg/metadata"
+	"github.com/mxalis/clickhouse-backup/pkg/new_storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	testData := map[string]error{
+		"rejected":   fmt.Errorf("%w: upload", ErrAPIConcurrencyLimit),
+		"canceled":   context.Canceled,
+		"timeout":    fmt.Errorf("can't upload: %w", context.DeadlineExceeded),
+		"clickhouse": fmt.Errorf("can't freeze: %w", &clickhouse.Exception{Code: 60}),
+		"not_found":  new_storage.ErrNotFound,
+		"filesystem": &os.PathError{Op: "open", Path: "/nonexistent", Err: os.ErrNotExist},
+		"other":      fmt.Errorf("something went wrong"),
+	}
+	for expected, err := range testData {
+		assert.Equal(t, expected, classifyError(err))
+	}
+}
+
+func TestTableMetricsLimit(t *testing.T) {
+	tm := newTableMetrics(2)
+	tm.observe(backup.TableStats{Operation: "create", Database: "db", Table: "small", Size: 10, Parts: 1, Duration: time.Second})
+	tm.observe(backup.TableStats{Operation: "create", Database: "db", Table: "medium", Size: 100, Parts: 2, Duration: time.Second})
+	tm.observe(backup.TableStats{Operation: "create", Database: "db", Table: "tiny", Size: 1, Parts: 1, Duration: time.Second})
+	assert.Equal(t, 2, testutil.CollectAndCount(tm.Size))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tm.Dropped))
+
+	tm.observe(backup.TableStats{Operation: "create", Database: "db", Table: "giant", Size: 1000, Parts: 5, Duration: time.Minute})
+	assert.Equal(t, 2, testutil.CollectAndCount(tm.Size))
+	assert.NotContains(t, tm.sizes, metadata.TableTitle{Database: "db", Table: "small"})
+	assert.Equal(t, float64(1000), testutil.ToFloat64(tm.Size.WithLabelValues("db", "giant")))
+	assert.Equal(t, float64(60), testutil.ToFloat64(tm.FreezeDuration.WithLabelValues("db", "giant")))
+
+	tm.observe(backup.TableStats{Operation: "upload", Database: "db", Table: "giant", Size: 500, Parts: 5, Duration: 2 * time.Minute})
+	assert.Equal(t, float64(120), testutil.ToFloat64(tm.UploadDuration.WithLabelValues("db", "giant")))
+	assert.Equal(t, uint64(1000), tm.sizes[metadata.TableTitle{Database: "db", Table: "giant"}])
+}
+
+func TestBackupAgeBucket(t *testing.T) {
+	assert.Equal(t, "1d", backupAgeBucket(time.Hour))
+	assert.Equal(t, "7d", backupAgeBucket(24*time.Hour))
+	assert.Equal(t, "30d", backupAgeBucket(10*24*time.Hour))
+	assert.Equal(t, "90d", backupAgeBucket(89*24*time.Hour))
+	assert.Equal(t, "365d", backupAgeBucket(364*24*time.Hour))
+	assert.Equal(t, "older", backupAgeBucket(400*24*time.Hour))
+}
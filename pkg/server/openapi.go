@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiRouteDoc - human-readable part of route description, paths and methods are taken from mux.Router itself, so OpenAPI spec is always in sync with registered handlers
+type apiRouteDoc struct {
+	Summary     string
+	QueryParams []apiQueryParam
+	RequestBody string
+	// RequestContentType - application/json by default
+	RequestContentType string
+}
+
+type apiQueryParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+var tableQueryParam = apiQueryParam{"table", "string", "table name patterns, separated by comma, allow ? and * as wildcard, works the same as `--tables` CLI argument"}
+var partitionsQueryParam = apiQueryParam{"partitions", "string", "partition names, separated by comma, works the same as `--partitions` CLI argument"}
+var schemaQueryParam = apiQueryParam{"schema", "boolean", "schema only, works the same as `--schema` CLI argument"}
+
+var backupListQueryParams = []apiQueryParam{
+	{"name", "string", "filter backups by name glob pattern, allow ? and * as wildcard"},
+	{"created_from", "string", "show only backups created at or after this time, RFC3339, `2006-01-02 15:04:05` or `2006-01-02` format, UTC"},
+	{"created_to", "string", "show only backups created at or before this time"},
+	{"sort", "string", "sort by `name`, `created` or `size`"},
+	{"order", "string", "sort order `asc` or `desc`"},
+	{"offset", "integer", "skip first N matched backups"},
+	{"limit", "integer", "show only N matched backups"},
+	{"tag", "string", "show only backups with tag in `key=value` format, repeat argument to require several tags"},
+	{"format", "string", "`json` return single JSON object with `total`, `total_local`, `total_remote` and `backups` fields instead of JSONEachRow"},
+}
+
+var apiRouteDocs = map[string]apiRouteDoc{
+	"GET /":                  {Summary: "List all current applicable HTTP routes"},
+	"POST /":                 {Summary: "Restart HTTP server"},
+	"POST /reload":           {Summary: "Reload and validate config without closing listen socket, running operations keep previous config"},
+	"GET /backup/tables":     {Summary: "Print list of tables suitable for backup"},
+	"GET /backup/tables/all": {Summary: "Print list of all tables, including skipped"},
+	"GET /backup/list": {
+		Summary:     "Print list of local and remote backups",
+		QueryParams: backupListQueryParams,
+	},
+	"GET /backup/list/{where}": {
+		Summary:     "Print list of backups, `where` is `local` or `remote`",
+		QueryParams: backupListQueryParams,
+	},
+	"GET /backup/list/{where}/{name}/tables": {
+		Summary:     "Print databases, tables, sizes, rows and partitions of backup from its metadata without downloading data, `where` is `local` or `remote`",
+		QueryParams: []apiQueryParam{{"format", "string", "`json` return single JSON object with backup `name`, `location`, `created`, `size`, `databases` and `tables` instead of JSONEachRow of tables"}},
+	},
+	"POST /backup/create": {
+		Summary: "Create new backup, async operation",
+		QueryParams: []apiQueryParam{
+			tableQueryParam, partitionsQueryParam, schemaQueryParam,
+			{"rbac", "boolean", "backup RBAC related objects, works the same as `--rbac` CLI argument"},
+			{"configs", "boolean", "backup ClickHouse server configuration files, works the same as `--configs` CLI argument"},
+			{"name", "string", "backup name, current UTC timestamp by default"},
+			{"tag", "string", "backup tag in `key=value` format, repeat argument for several tags, works the same as `--tag` CLI argument"},
+			{"description", "string", "free-form backup description, works the same as `--description` CLI argument"},
+		},
+	},
+	"POST /backup/clean": {Summary: "Clean `shadow` folder on all available path from `system.disks` and delete incomplete local backups"},
+	"POST /backup/upload/{name}": {
+		Summary: "Upload backup to remote storage, async operation",
+		QueryParams: []apiQueryParam{
+			{"diff-from", "string", "local backup name which used to upload current backup as differential"},
+			{"diff-from-remote", "string", "remote backup name which used to upload current backup as differential"},
+			tableQueryParam, partitionsQueryParam, schemaQueryParam,
+			{"resume", "boolean", "continue interrupted upload, skip files which already exist on remote storage, works the same as `--resume` CLI argument"},
+		},
+	},
+	"POST /backup/download/{name}": {
+		Summary: "Download backup from remote storage, async operation",
+		QueryParams: []apiQueryParam{
+			tableQueryParam, partitionsQueryParam, schemaQueryParam,
+			{"resume", "boolean", "continue interrupted download, skip files which already downloaded, works the same as `--resume` CLI argument"},
+		},
+	},
+	"POST /backup/restore/{name}": {
+		Summary: "Create schema and restore data from local backup, async operation",
+		QueryParams: []apiQueryParam{
+			tableQueryParam, partitionsQueryParam, schemaQueryParam,
+			{"data", "boolean", "restore data only, works the same as `--data` CLI argument"},
+			{"drop", "boolean", "drop exists tables before restore, works the same as `--drop` CLI argument"},
+			{"rm", "boolean", "alias for `drop`"},
+			{"rbac", "boolean", "restore RBAC related objects, works the same as `--rbac` CLI argument"},
+			{"configs", "boolean", "restore ClickHouse server configuration files, works the same as `--configs` CLI argument"},
+			{"dry_run", "boolean", "print actions and check prerequisites without changes, works the same as `--dry-run` CLI argument"},
+			{"restore_database_mapping", "string", "rename databases during restore, format `src_db:dst_db,src_db2:dst_db2`, overrides `general.restore_database_mapping`"},
+			{"restore_table_mapping", "string", "rename tables during restore, format `src_db.src_table:dst_db.dst_table`, overrides `general.restore_table_mapping`"},
+			{"restore_replicated_engine", "string", "`merge_tree` or `replicated`, convert table engines during schema restore, overrides `general.restore_replicated_engine`"},
+			{"on_cluster", "string", "execute schema queries with `ON CLUSTER` clause for selected cluster, overrides `general.restore_schema_on_cluster`"},
+		},
+		RequestBody: "optional JSON object with the same fields as query parameters, `partitions` is array of strings, `restore_database_mapping` and `restore_table_mapping` are objects, response contains `operation_id`",
+	},
+	"POST /backup/delete/{where}/{name}": {Summary: "Delete specific backup, `where` is `local` or `remote`"},
+	"GET /backup/archive/{where}/{name}": {
+		Summary:     "Stream tar archive with local or remote backup files, `where` is `local` or `remote`, Range requests are not supported",
+		QueryParams: []apiQueryParam{{"table", "string", "stream only metadata and data of tables matched by patterns, separated by comma"}},
+	},
+	"PUT /backup/archive/local/{name}": {
+		Summary:            "Register uploaded tar archive of local backup as new local backup, ready for restore",
+		RequestBody:        "tar archive, optionally gzip compressed, with the same layout as `GET /backup/archive/local/{name}` returns",
+		RequestContentType: "application/x-tar",
+	},
+	"GET /backup/file/{where}/{name}/{path:.+}": {Summary: "Stream single file from local or remote backup, `path` is relative to backup root, support Range requests"},
+	"GET /backup/status":                        {Summary: "Display list of current running async operations"},
+	"GET /backup/actions": {
+		Summary: "Display list of all operations from start of API server",
+		QueryParams: []apiQueryParam{
+			{"filter", "string", "filter actions on server side by command, status or error substring"},
+			{"last", "integer", "show only last N actions"},
+			{"id", "integer", "show only action with `id`, returned as `operation_id` by async operations"},
+		},
+	},
+	"POST /backup/actions": {
+		Summary:     "Execute multiple backup actions",
+		RequestBody: "JSONEachRow rows with `command` field, for example {\"command\":\"create backup_name\"}",
+	},
+	"GET /openapi.json": {Summary: "OpenAPI v3 specification of this API"},
+	"GET /swagger":      {Summary: "Swagger UI for this API"},
+	"GET /health":       {Summary: "Health check"},
+	"GET /metrics":      {Summary: "Prometheus metrics"},
+}
+
+type openAPISpec struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+	Security   []map[string][]string                  `json:"security,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string            `json:"name"`
+	In          string            `json:"in"`
+	Required    bool              `json:"required,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Schema      map[string]string `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Description string                            `json:"description,omitempty"`
+	Content     map[string]map[string]interface{} `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string `json:"description"`
+}
+
+type openAPIComponents struct {
+	SecuritySchemes map[string]map[string]string `json:"securitySchemes,omitempty"`
+}
+
+var pathVariableRe = regexp.MustCompile(`{([^}:]+)(:[^}]+)?}`)
+var operationIDRe = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// buildOpenAPISpec - generate OpenAPI v3 document from all routes registered in router
+func buildOpenAPISpec(r *mux.Router, version string, withAuth bool) (*openAPISpec, error) {
+	spec := &openAPISpec{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:   "clickhouse-backup API",
+			Version: version,
+		},
+		Paths: map[string]map[string]openAPIOperation{},
+	}
+	if withAuth {
+		spec.Components.SecuritySchemes = map[string]map[string]string{
+			"basicAuth": {"type": "http", "scheme": "basic"},
+		}
+		spec.Security = []map[string][]string{{"basicAuth": {}}}
+	}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(pathTemplate, "/debug/pprof") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			doc := apiRouteDocs[fmt.Sprintf("%s %s", method, pathTemplate)]
+			if doc.Summary == "" {
+				doc.Summary = fmt.Sprintf("%s %s", method, pathTemplate)
+			}
+			operation := openAPIOperation{
+				Summary:     doc.Summary,
+				OperationID: strings.Trim(operationIDRe.ReplaceAllString(strings.ToLower(method+"_"+pathTemplate), "_"), "_"),
+				Responses: map[string]openAPIResponse{
+					"200":     {Description: "JSONEachRow formatted result"},
+					"default": {Description: "JSON formatted error with `status`, `operation` and `error` fields"},
+				},
+			}
+			for _, match := range pathVariableRe.FindAllStringSubmatch(pathTemplate, -1) {
+				operation.Parameters = append(operation.Parameters, openAPIParameter{
+					Name:     match[1],
+					In:       "path",
+					Required: true,
+					Schema:   map[string]string{"type": "string"},
+				})
+			}
+			for _, param := range doc.QueryParams {
+				operation.Parameters = append(operation.Parameters, openAPIParameter{
+					Name:        param.Name,
+					In:          "query",
+					Description: param.Description,
+					Schema:      map[string]string{"type": param.Type},
+				})
+			}
+			if doc.RequestBody != "" {
+				contentType, schema := "application/json", map[string]string{"type": "object"}
+				if doc.RequestContentType != "" {
+					contentType, schema = doc.RequestContentType, map[string]string{"type": "string", "format": "binary"}
+				}
+				operation.RequestBody = &openAPIRequestBody{
+					Description: doc.RequestBody,
+					Content: map[string]map[string]interface{}{
+						contentType: {"schema": schema},
+					},
+				}
+			}
+			openAPIPath := pathVariableRe.ReplaceAllString(pathTemplate, "{$1}")
+			if _, exists := spec.Paths[openAPIPath]; !exists {
+				spec.Paths[openAPIPath] = map[string]openAPIOperation{}
+			}
+			spec.Paths[openAPIPath][strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+	return spec, err
+}
+
+// httpOpenAPIHandler - display OpenAPI v3 specification
+func (api *APIServer) httpOpenAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	spec := api.currentState().openAPISpec
+	if spec == nil {
+		writeError(w, http.StatusInternalServerError, "openapi", fmt.Errorf("OpenAPI specification is not generated"))
+		return
+	}
+	sendJSONEachRow(w, http.StatusOK, spec)
+}
+
+const swaggerUITemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8"/>
+  <title>clickhouse-backup API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@4/swagger-ui.css"/>
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@4/swagger-ui-bundle.js" crossorigin></script>
+<script>
+  window.onload = function () {
+    window.ui = SwaggerUIBundle({url: %s, dom_id: "#swagger-ui"});
+  };
+</script>
+</body>
+</html>
+`
+
+// httpSwaggerHandler - display Swagger UI which load /openapi.json
+func (api *APIServer) httpSwaggerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	specURL := "/openapi.json"
+	// pass only user and pass query parameters for basic auth, JSON encoding escapes quotes and HTML special chars inside <script>
+	query := r.URL.Query()
+	authParams := url.Values{}
+	for _, name := range []string{"user", "pass"} {
+		if value, exist := query[name]; exist {
+			authParams.Set(name, value[0])
+		}
+	}
+	if len(authParams) > 0 {
+		specURL += "?" + authParams.Encode()
+	}
+	specURLJSON, err := json.Marshal(specURL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "swagger", err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, swaggerUITemplate, specURLJSON)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildOpenAPISpec(t *testing.T) {
+	r := mux.NewRouter()
+	dummy := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/backup/list/{where}", dummy).Methods("GET")
+	r.HandleFunc("/backup/upload/{name}", dummy).Methods("POST")
+	r.HandleFunc("/backup/actions", dummy).Methods("GET")
+	r.HandleFunc("/backup/actions", dummy).Methods("POST")
+	r.HandleFunc("/debug/pprof/", dummy)
+
+	spec, err := buildOpenAPISpec(r, "test", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", spec.Info.Version)
+	assert.Len(t, spec.Paths, 3)
+	assert.NotContains(t, spec.Paths, "/debug/pprof/")
+	assert.Contains(t, spec.Components.SecuritySchemes, "basicAuth")
+
+	upload := spec.Paths["/backup/upload/{name}"]["post"]
+	assert.Equal(t, "post_backup_upload_name", upload.OperationID)
+	assert.Equal(t, "name", upload.Parameters[0].Name)
+	assert.Equal(t, "path", upload.Parameters[0].In)
+	assert.True(t, upload.Parameters[0].Required)
+	assert.Equal(t, "diff-from", upload.Parameters[1].Name)
+
+	assert.Contains(t, spec.Paths["/backup/actions"], "get")
+	assert.Contains(t, spec.Paths["/backup/actions"], "post")
+	assert.NotNil(t, spec.Paths["/backup/actions"]["post"].RequestBody)
+
+	spec, err = buildOpenAPISpec(r, "test", false)
+	assert.NoError(t, err)
+	assert.Empty(t, spec.Security)
+}
+
+func TestSwaggerHandlerEscapeQuery(t *testing.T) {
+	api := &APIServer{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", `/swagger?user=admin&pass=a%22%3C%2Fscript%3E%3Cscript%3Ealert(1)%3C%2Fscript%3E&x=%22%29%3Balert(2)%2F%2F`, nil)
+	api.httpSwaggerHandler(w, r)
+	body := w.Body.String()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, body, `SwaggerUIBundle({url: "/openapi.json?pass=a%22%3C%2Fscript%3E%3Cscript%3Ealert%281%29%3C%2Fscript%3E\u0026user=admin", dom_id`)
+	assert.NotContains(t, body, "alert(2)")
+	assert.NotContains(t, body, "<script>alert")
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+)
+
+// clientRateLimiter - token bucket per client IP address, `rate` tokens added each second, up to `burst`
+type clientRateLimiter struct {
+	rate    float64
+	burst   float64
+	clients map[string]*tokenBucket
+	sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newClientRateLimiter(rate float64, burst int) *clientRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &clientRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clients: map[string]*tokenBucket{},
+	}
+}
+
+// allow - take token for client, when no tokens available return duration after which next request will allowed
+func (l *clientRateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	bucket, exists := l.clients[client]
+	if !exists {
+		// avoid unlimited growth, full buckets are the same as absent
+		if len(l.clients) >= 10000 {
+			l.cleanup(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func (l *clientRateLimiter) cleanup(now time.Time) {
+	for client, bucket := range l.clients {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// rateLimitMiddleware - return 429 Too Many Requests when client exceed `api.rate_limit` of config which router was built with
+func rateLimitMiddleware(apiConfig config.APIConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if apiConfig.RateLimit <= 0 {
+			return next
+		}
+		limiter := newClientRateLimiter(apiConfig.RateLimit, apiConfig.RateLimitBurst)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if allowed, retryAfter := limiter.allow(client, time.Now()); !allowed {
+				apexLog.Infof("rate limit exceeded for %s %s %s", client, r.Method, r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, "", fmt.Errorf("rate limit exceeded, api.rate_limit is %v requests per second", apiConfig.RateLimit))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	limiter := newClientRateLimiter(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.allow("127.0.0.1", now)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter := limiter.allow("127.0.0.1", now)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	allowed, _ = limiter.allow("127.0.0.2", now)
+	assert.True(t, allowed, "other clients shall not be affected")
+
+	allowed, _ = limiter.allow("127.0.0.1", now.Add(500*time.Millisecond))
+	assert.True(t, allowed)
+	allowed, _ = limiter.allow("127.0.0.1", now.Add(500*time.Millisecond))
+	assert.False(t, allowed)
+
+	limiter.cleanup(now.Add(time.Hour))
+	assert.Empty(t, limiter.clients)
+}
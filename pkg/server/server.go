@@ -2,10 +2,14 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/mxalis/clickhouse-backup/pkg/errtracker"
+	"github.com/mxalis/clickhouse-backup/pkg/metrics"
+	"github.com/mxalis/clickhouse-backup/pkg/tracing"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -16,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,7 +32,6 @@ import (
 	apexLog "github.com/apex/log"
 	"github.com/google/shlex"
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli"
 )
@@ -36,26 +40,40 @@ const (
 	// APITimeFormat - clickhouse compatibility time format
 	APITimeFormat  = "2006-01-02 15:04:05"
 	InProgressText = "in progress"
+	// shutdownTimeout - how long SIGTERM wait for canceled operations, which abort multipart uploads
+	shutdownTimeout = 30 * time.Second
 )
 
 type APIServer struct {
 	c                       *cli.App
 	configPath              string
-	config                  *config.Config
+	configOverrides         []string           // `--set` of `server` command, applied to each config reload and passed to async commands
+	loadOptions             config.LoadOptions // `--allow-unknown` and `--env-prefix` of `server` command, the same as configOverrides
+	state                   atomic.Value       // *apiState of current config, replaced as a whole by Restart and Reload without closing listen socket
 	server                  *http.Server
 	restart                 chan struct{}
+	reload                  chan chan error
+	ctx                     context.Context
 	status                  *AsyncStatus
 	metrics                 Metrics
-	routes                  []string
 	clickhouseBackupVersion string
 }
 
+// apiState - config and router with routes and OpenAPI specification built from it, never changed after publish, so handlers could read it concurrently with Reload
+type apiState struct {
+	config      *config.Config
+	router      http.Handler
+	routes      []string
+	openAPISpec *openAPISpec
+}
+
 type AsyncStatus struct {
 	commands []ActionRow
 	sync.RWMutex
 }
 
 type ActionRow struct {
+	ID      int    `json:"id"`
 	Command string `json:"command"`
 	Status  string `json:"status"`
 	Start   string `json:"start,omitempty"`
@@ -63,10 +81,46 @@ type ActionRow struct {
 	Error   string `json:"error,omitempty"`
 }
 
-func (status *AsyncStatus) start(command string) int {
+var clusterOperations = map[string]bool{"create_cluster": true, "restore_cluster": true}
+
+// tryStart - atomically check `allow_parallel` and `max_concurrent_operations` and register new command if allowed
+func (status *AsyncStatus) tryStart(operation, command string, apiConfig config.APIConfig) (int, error) {
+	// operation names are shared with `api.max_concurrent_operations` validation, so each of them could be limited
+	if !config.IsAPIOperation(operation) {
+		return -1, fmt.Errorf("unknown operation '%s'", operation)
+	}
 	status.Lock()
 	defer status.Unlock()
+	total, sameOperation := 0, 0
+	for _, row := range status.commands {
+		if row.Status != InProgressText {
+			continue
+		}
+		fields := strings.Fields(row.Command)
+		if len(fields) > 0 && fields[0] == operation {
+			sameOperation++
+		}
+		// create_cluster and restore_cluster only wait for operations which they start on cluster hosts, including this one
+		if len(fields) > 0 && clusterOperations[fields[0]] {
+			continue
+		}
+		total++
+	}
+	if !apiConfig.AllowParallel && total > 0 {
+		return -1, ErrAPILocked
+	}
+	if limit := apiConfig.MaxConcurrentOperations[operation]; limit > 0 && sameOperation >= limit {
+		return -1, fmt.Errorf("%w, %d `%s` operations are running, limit is %d", ErrAPIConcurrencyLimit, sameOperation, operation, limit)
+	}
+	if limit := apiConfig.MaxConcurrentOperations["all"]; limit > 0 && total >= limit {
+		return -1, fmt.Errorf("%w, %d operations are running, limit is %d", ErrAPIConcurrencyLimit, total, limit)
+	}
+	return status.appendCommand(command), nil
+}
+
+func (status *AsyncStatus) appendCommand(command string) int {
 	status.commands = append(status.commands, ActionRow{
+		ID:      len(status.commands),
 		Command: command,
 		Start:   time.Now().Format(APITimeFormat),
 		Status:  InProgressText,
@@ -76,23 +130,14 @@ func (status *AsyncStatus) start(command string) int {
 	return lastCommandId
 }
 
-func (status *AsyncStatus) inProgress() bool {
-	status.RLock()
-	defer status.RUnlock()
-	n := len(status.commands) - 1
-	if n < 0 {
-		apexLog.Debugf("api.status.inProgress -> len(status.commands)=%d, inProgress=false", len(status.commands))
-		return false
-	}
-	apexLog.Debugf("api.status.inProgress -> status.commands[n].Status == %s, inProgress=%v", status.commands[n].Status, status.commands[n].Status == InProgressText)
-	return status.commands[n].Status == InProgressText
-}
-
 func (status *AsyncStatus) stop(commandId int, err error) {
 	status.Lock()
 	defer status.Unlock()
 	s := "success"
-	if err != nil {
+	if backup.IsBackupSkipped(err) {
+		s = "skipped"
+		status.commands[commandId].Error = err.Error()
+	} else if err != nil {
 		s = "error"
 		status.commands[commandId].Error = err.Error()
 	}
@@ -101,6 +146,34 @@ func (status *AsyncStatus) stop(commandId int, err error) {
 	apexLog.Debugf("api.status.stop -> status.commands[%d] == %v", commandId, status.commands[commandId])
 }
 
+// waitInProgress - wait until all commands are finished, but not longer than timeout
+func (status *AsyncStatus) waitInProgress(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		inProgress := 0
+		status.RLock()
+		for _, row := range status.commands {
+			if row.Status == InProgressText {
+				inProgress++
+			}
+		}
+		status.RUnlock()
+		if inProgress == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (status *AsyncStatus) get(commandId int) (ActionRow, bool) {
+	status.RLock()
+	defer status.RUnlock()
+	if commandId < 0 || commandId >= len(status.commands) {
+		return ActionRow{}, false
+	}
+	return status.commands[commandId], true
+}
+
 func (status *AsyncStatus) status(current bool, filter string, last int) []ActionRow {
 	status.RLock()
 	defer status.RUnlock()
@@ -139,18 +212,19 @@ func (status *AsyncStatus) status(current bool, filter string, last int) []Actio
 }
 
 var (
-	ErrAPILocked = errors.New("another operation is currently running")
+	ErrAPILocked           = errors.New("another operation is currently running")
+	ErrAPIConcurrencyLimit = errors.New("max_concurrent_operations limit exceeded")
 )
 
 // Server - expose CLI commands as REST API
-func Server(c *cli.App, configPath string, clickhouseBackupVersion string) error {
+func Server(c *cli.App, configPath string, configOverrides []string, loadOptions config.LoadOptions, clickhouseBackupVersion string) error {
 	var (
 		cfg *config.Config
 		err error
 	)
 	apexLog.Debug("Wait for ClickHouse")
 	for {
-		cfg, err = config.LoadConfig(configPath)
+		cfg, err = config.LoadConfigWithOverrides(configPath, configOverrides, loadOptions)
 		if err != nil {
 			apexLog.Error(err.Error())
 			time.Sleep(5 * time.Second)
@@ -167,26 +241,48 @@ func Server(c *cli.App, configPath string, clickhouseBackupVersion string) error
 		_ = ch.GetConn().Close()
 		break
 	}
+	// canceled on SIGTERM, so running operations abort transfers to remote storage
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	api := APIServer{
 		c:                       c,
 		configPath:              configPath,
-		config:                  cfg,
+		configOverrides:         configOverrides,
+		loadOptions:             loadOptions,
 		restart:                 make(chan struct{}),
+		reload:                  make(chan chan error),
+		ctx:                     ctx,
 		status:                  &AsyncStatus{},
 		clickhouseBackupVersion: clickhouseBackupVersion,
 	}
+	api.state.Store(&apiState{config: cfg})
 	if cfg.API.CreateIntegrationTables {
 		if err := api.CreateIntegrationTables(); err != nil {
 			apexLog.Error(err.Error())
 		}
 	}
+	shutdownTracing, err := tracing.Init(&cfg.Tracing, clickhouseBackupVersion)
+	if err != nil {
+		apexLog.Warnf("can't setup tracing: %v", err)
+	}
+	defer shutdownTracing()
+	flushErrors, err := errtracker.Init(&cfg.Sentry, clickhouseBackupVersion)
+	if err != nil {
+		apexLog.Warnf("can't setup sentry: %v", err)
+	}
+	defer flushErrors()
 	api.metrics = setupMetrics()
+	if cfg.API.EnableMetrics && cfg.API.EnableTableMetrics {
+		api.metrics.Tables = newTableMetrics(cfg.API.TableMetricsLimit)
+		api.metrics.Tables.register()
+		backup.SetTableStatsObserver(api.metrics.Tables.observe)
+	}
 
-	apexLog.Infof("Starting API server on %s", api.config.API.ListenAddr)
+	apexLog.Infof("Starting API server on %s", cfg.API.ListenAddr)
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
 	sighup := make(chan os.Signal, 1)
-	signal.Notify(sighup, os.Interrupt, syscall.SIGHUP)
+	signal.Notify(sighup, syscall.SIGHUP)
 	if err := api.Restart(); err != nil {
 		return err
 	}
@@ -195,6 +291,7 @@ func Server(c *cli.App, configPath string, clickhouseBackupVersion string) error
 			apexLog.Errorf("updateBackupMetrics return error: %v", err)
 		}
 	}()
+	go api.watchRemoteUsage()
 
 	for {
 		select {
@@ -204,35 +301,53 @@ func Server(c *cli.App, configPath string, clickhouseBackupVersion string) error
 				continue
 			}
 			apexLog.Infof("Reloaded by HTTP")
+		case result := <-api.reload:
+			err := api.Reload()
+			if err != nil {
+				apexLog.Errorf("Failed to reload config, previous config is used: %v", err)
+			} else {
+				apexLog.Info("Config reloaded by POST /reload")
+			}
+			result <- err
 		case <-sighup:
-			if err := api.Restart(); err != nil {
-				apexLog.Errorf("Failed to restarting API server: %v", err)
+			if err := api.Reload(); err != nil {
+				apexLog.Errorf("Failed to reload config, previous config is used: %v", err)
 				continue
 			}
-			apexLog.Info("Reloaded by SIGHUP")
+			apexLog.Info("Config reloaded by SIGHUP")
 		case <-sigterm:
 			apexLog.Info("Stopping API server")
+			// running operations abort multipart uploads on remote storage before exit
+			cancel()
+			api.status.waitInProgress(shutdownTimeout)
 			return api.server.Close()
 		}
 	}
 }
 
 func (api *APIServer) Restart() error {
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
+	if err != nil {
+		return err
+	}
+	state, err := api.setupRouter(cfg)
 	if err != nil {
 		return err
 	}
 	api.metrics.NumberBackupsRemoteExpected.Set(float64(cfg.General.BackupsToKeepRemote))
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
-	api.config = cfg
-	server := api.setupAPIServer()
+	api.state.Store(state)
+	server := &http.Server{
+		Addr:    cfg.API.ListenAddr,
+		Handler: http.HandlerFunc(api.serveHTTP),
+	}
 	if api.server != nil {
 		_ = api.server.Close()
 	}
 	api.server = server
-	if api.config.API.Secure {
+	if cfg.API.Secure {
 		go func() {
-			err = api.server.ListenAndServeTLS(api.config.API.CertificateFile, api.config.API.PrivateKeyFile)
+			err = api.server.ListenAndServeTLS(cfg.API.CertificateFile, cfg.API.PrivateKeyFile)
 			if err != nil {
 				apexLog.Fatalf("ListenAndServeTLS error: %s", err.Error())
 			}
@@ -247,10 +362,50 @@ func (api *APIServer) Restart() error {
 	return nil
 }
 
-// setupAPIServer - resister API routes
-func (api *APIServer) setupAPIServer() *http.Server {
+// Reload - validate config and replace it without closing listen socket, running commands keep config they started with,
+// listen socket settings of kept socket remain until restart by POST /
+func (api *APIServer) Reload() error {
+	cfg, err := api.loadConfig()
+	if err != nil {
+		return err
+	}
+	previous := api.currentConfig()
+	if cfg.API.ListenAddr != previous.API.ListenAddr || cfg.API.Secure != previous.API.Secure ||
+		cfg.API.CertificateFile != previous.API.CertificateFile || cfg.API.PrivateKeyFile != previous.API.PrivateKeyFile {
+		apexLog.Warn("api.listen, api.secure, api.certificate_file and api.private_key_file changes are applied only after restart by POST /")
+		cfg.API.ListenAddr, cfg.API.Secure = previous.API.ListenAddr, previous.API.Secure
+		cfg.API.CertificateFile, cfg.API.PrivateKeyFile = previous.API.CertificateFile, previous.API.PrivateKeyFile
+	}
+	state, err := api.setupRouter(cfg)
+	if err != nil {
+		return err
+	}
+	api.metrics.NumberBackupsRemoteExpected.Set(float64(cfg.General.BackupsToKeepRemote))
+	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
+	api.state.Store(state)
+	return nil
+}
+
+// currentState - published config with its router, requests which already loaded previous state finish with it
+func (api *APIServer) currentState() *apiState {
+	return api.state.Load().(*apiState)
+}
+
+// currentConfig - config of current state, load it once per request or operation to use the same config for all steps
+func (api *APIServer) currentConfig() *config.Config {
+	return api.currentState().config
+}
+
+// serveHTTP - route request by router of current state
+func (api *APIServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	api.currentState().router.ServeHTTP(w, r)
+}
+
+// setupRouter - resister API routes, state is built without publish, so failed setup keeps previous state
+func (api *APIServer) setupRouter(cfg *config.Config) (*apiState, error) {
 	r := mux.NewRouter()
-	r.Use(api.basicAuthMiddleware)
+	r.Use(rateLimitMiddleware(cfg.API))
+	r.Use(basicAuthMiddleware(cfg.API))
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "", fmt.Errorf("404 Not Found"))
 	})
@@ -260,21 +415,31 @@ func (api *APIServer) setupAPIServer() *http.Server {
 
 	r.HandleFunc("/", api.httpRootHandler).Methods("GET")
 	r.HandleFunc("/", api.httpRestartHandler).Methods("POST")
+	r.HandleFunc("/reload", api.httpReloadHandler).Methods("POST")
 	r.HandleFunc("/backup/tables", api.httpTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/tables/all", api.httpTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/list", api.httpListHandler).Methods("GET")
 	r.HandleFunc("/backup/list/{where}", api.httpListHandler).Methods("GET")
+	r.HandleFunc("/backup/list/{where}/{name}/tables", api.httpListTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/create", api.httpCreateHandler).Methods("POST")
 	r.HandleFunc("/backup/clean", api.httpCleanHandler).Methods("POST")
 	r.HandleFunc("/backup/upload/{name}", api.httpUploadHandler).Methods("POST")
 	r.HandleFunc("/backup/download/{name}", api.httpDownloadHandler).Methods("POST")
 	r.HandleFunc("/backup/restore/{name}", api.httpRestoreHandler).Methods("POST")
 	r.HandleFunc("/backup/delete/{where}/{name}", api.httpDeleteHandler).Methods("POST")
+	r.HandleFunc("/backup/archive/{where}/{name}", api.httpArchiveHandler).Methods("GET")
+	r.HandleFunc("/backup/archive/local/{name}", api.httpImportHandler).Methods("PUT")
+	r.HandleFunc("/backup/file/{where}/{name}/{path:.+}", api.httpBackupFileHandler).Methods("GET")
 	r.HandleFunc("/backup/status", api.httpBackupStatusHandler).Methods("GET")
 
 	r.HandleFunc("/backup/actions", api.actionsLog).Methods("GET")
 	r.HandleFunc("/backup/actions", api.actions).Methods("POST")
 
+	r.HandleFunc("/openapi.json", api.httpOpenAPIHandler).Methods("GET")
+	if cfg.API.EnableSwagger {
+		r.HandleFunc("/swagger", api.httpSwaggerHandler).Methods("GET")
+	}
+
 	var routes []string
 	if err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		t, err := route.GetPathTemplate()
@@ -284,39 +449,60 @@ func (api *APIServer) setupAPIServer() *http.Server {
 		routes = append(routes, t)
 		return nil
 	}); err != nil {
-		apexLog.Errorf("mux.Router.Walk return error: %v", err)
-		return nil
+		return nil, fmt.Errorf("can't setup API routes, mux.Router.Walk return error: %v", err)
 	}
 
-	api.routes = routes
-	registerMetricsHandlers(r, api.config.API.EnableMetrics, api.config.API.EnablePprof)
-	srv := &http.Server{
-		Addr:    api.config.API.ListenAddr,
-		Handler: r,
+	registerMetricsHandlers(r, cfg.API.EnableMetrics, cfg.API.EnablePprof)
+	spec, err := buildOpenAPISpec(r, api.clickhouseBackupVersion, cfg.API.Username != "" || cfg.API.Password != "")
+	if err != nil {
+		apexLog.Errorf("can't generate OpenAPI specification: %v", err)
 	}
-	return srv
+	return &apiState{config: cfg, router: r, routes: routes, openAPISpec: spec}, nil
 }
 
-func (api *APIServer) basicAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, pass, _ := r.BasicAuth()
-		query := r.URL.Query()
-		if u, exist := query["user"]; exist {
-			user = u[0]
-		}
-		if p, exist := query["pass"]; exist {
-			pass = p[0]
-		}
-		if (user != api.config.API.Username) || (pass != api.config.API.Password) {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
-			w.WriteHeader(http.StatusUnauthorized)
-			if _, err := w.Write([]byte("401 Unauthorized\n")); err != nil {
-				apexLog.Errorf("RequestWriter.Write return error: %v", err)
+// basicAuthMiddleware - check `api.username` and `api.password` of config which router was built with
+func basicAuthMiddleware(apiConfig config.APIConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, _ := r.BasicAuth()
+			query := r.URL.Query()
+			if u, exist := query["user"]; exist {
+				user = u[0]
 			}
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+			if p, exist := query["pass"]; exist {
+				pass = p[0]
+			}
+			if (user != apiConfig.Username) || (pass != apiConfig.Password) {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
+				w.WriteHeader(http.StatusUnauthorized)
+				if _, err := w.Write([]byte("401 Unauthorized\n")); err != nil {
+					apexLog.Errorf("RequestWriter.Write return error: %v", err)
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// loadConfig - config file with `--set` overrides of `server` command
+func (api *APIServer) loadConfig() (*config.Config, error) {
+	return config.LoadConfigWithOverrides(api.configPath, api.configOverrides, api.loadOptions)
+}
+
+// commandArgs - arguments of async command with config file, load options and `--set` overrides of `server` command, overrides passed to command are applied last
+func (api *APIServer) commandArgs(args []string) []string {
+	commandArgs := []string{"clickhouse-backup", "-c", api.configPath}
+	if api.loadOptions.AllowUnknownKeys {
+		commandArgs = append(commandArgs, "--allow-unknown")
+	}
+	if api.loadOptions.EnvPrefix != "" {
+		commandArgs = append(commandArgs, "--env-prefix", api.loadOptions.EnvPrefix)
+	}
+	for _, override := range api.configOverrides {
+		commandArgs = append(commandArgs, "--set", override)
+	}
+	return append(commandArgs, args...)
 }
 
 // CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String) ENGINE=URL('http://127.0.0.1:7171/backup/actions?user=user&pass=pass', JSONEachRow)
@@ -347,54 +533,57 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 		}
 		command := args[0]
 		switch command {
-		case "create", "restore", "upload", "download", "create_remote", "restore_remote":
-			if !api.config.API.AllowParallel && api.status.inProgress() {
-				apexLog.Info(ErrAPILocked.Error())
-				writeError(w, http.StatusLocked, row.Command, ErrAPILocked)
+		case "create", "restore", "upload", "download", "create_remote", "restore_remote", "verify", "remote-check", "copy", "create_cluster", "restore_cluster", "migrate-metadata":
+			commandId, err := api.status.tryStart(command, row.Command, api.currentConfig().API)
+			if err != nil {
+				api.metrics.Reject(command)
+				writeOperationStartError(w, row.Command, err)
 				return
 			}
 			go func() {
-				start := time.Now()
-				api.metrics.LastStart[command].Set(float64(start.Unix()))
-				defer func() {
-					api.metrics.LastDuration[command].Set(float64(time.Since(start).Nanoseconds()))
-					api.metrics.LastFinish[command].Set(float64(time.Now().Unix()))
-				}()
-
-				commandId := api.status.start(row.Command)
-				err := api.c.Run(append([]string{"clickhouse-backup", "-c", api.configPath}, args...))
+				start := api.metrics.Start(command)
+				err := api.c.Run(api.commandArgs(args))
 				defer api.status.stop(commandId, err)
+				if backup.IsBackupSkipped(err) {
+					apexLog.Info(err.Error())
+					api.metrics.Finish(command, start, nil)
+					return
+				}
+				api.metrics.Finish(command, start, err)
 				if err != nil {
-					api.metrics.FailedCounter[command].Inc()
-					api.metrics.LastStatus[command].Set(0)
 					apexLog.Error(err.Error())
 					return
 				}
+				if command == "verify" || command == "remote-check" {
+					return
+				}
 				go func() {
 					if err := api.updateBackupMetrics(command == "create" || command == "restore"); err != nil {
 						apexLog.Errorf("updateBackupMetrics return error: %v", err)
 					}
 				}()
-				api.metrics.SuccessfulCounter[command].Inc()
-				api.metrics.LastStatus[command].Set(1)
 			}()
 			sendJSONEachRow(w, http.StatusCreated, struct {
-				Status    string `json:"status"`
-				Operation string `json:"operation"`
+				Status      string `json:"status"`
+				Operation   string `json:"operation"`
+				OperationId int    `json:"operation_id"`
 			}{
-				Status:    "acknowledged",
-				Operation: row.Command,
+				Status:      "acknowledged",
+				Operation:   row.Command,
+				OperationId: commandId,
 			})
 			return
 		case "delete":
-			if !api.config.API.AllowParallel && api.status.inProgress() {
-				apexLog.Info(ErrAPILocked.Error())
-				writeError(w, http.StatusLocked, row.Command, ErrAPILocked)
+			commandId, err := api.status.tryStart(command, row.Command, api.currentConfig().API)
+			if err != nil {
+				api.metrics.Reject(command)
+				writeOperationStartError(w, row.Command, err)
 				return
 			}
-			commandId := api.status.start(row.Command)
-			err := api.c.Run(append([]string{"clickhouse-backup", "-c", api.configPath}, args...))
+			start := api.metrics.Start(command)
+			err = api.c.Run(api.commandArgs(args))
 			api.status.stop(commandId, err)
+			api.metrics.Finish(command, start, err)
 			if err != nil {
 				writeError(w, http.StatusBadRequest, row.Command, err)
 				apexLog.Error(err.Error())
@@ -425,6 +614,20 @@ func (api *APIServer) actionsLog(w http.ResponseWriter, r *http.Request) {
 	var last int64
 	var err error
 	q := r.URL.Query()
+	if q.Get("id") != "" {
+		commandId, err := strconv.Atoi(q.Get("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "actions", err)
+			return
+		}
+		row, exists := api.status.get(commandId)
+		if !exists {
+			writeError(w, http.StatusNotFound, "actions", fmt.Errorf("operation with id=%d not found", commandId))
+			return
+		}
+		sendJSONEachRow(w, http.StatusOK, row)
+		return
+	}
 	if q.Get("last") != "" {
 		last, err = strconv.ParseInt(q.Get("last"), 10, 16)
 		if err != nil {
@@ -443,7 +646,7 @@ func (api *APIServer) httpRootHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Pragma", "no-cache")
 
 	_, _ = fmt.Fprintln(w, "Documentation: https://github.com/mxalis/clickhouse-backup#api-configuration")
-	for _, r := range api.routes {
+	for _, r := range api.currentState().routes {
 		_, _ = fmt.Fprintln(w, r)
 	}
 }
@@ -460,9 +663,26 @@ func (api *APIServer) httpRestartHandler(w http.ResponseWriter, _ *http.Request)
 	api.restart <- struct{}{}
 }
 
+// httpReloadHandler - reload config without restart, invalid config is reported and previous config is kept
+func (api *APIServer) httpReloadHandler(w http.ResponseWriter, _ *http.Request) {
+	result := make(chan error, 1)
+	api.reload <- result
+	if err := <-result; err != nil {
+		writeError(w, http.StatusBadRequest, "reload", err)
+		return
+	}
+	sendJSONEachRow(w, http.StatusOK, struct {
+		Status    string `json:"status"`
+		Operation string `json:"operation"`
+	}{
+		Status:    "success",
+		Operation: "reload",
+	})
+}
+
 // httpTablesHandler - display list of tables
 func (api *APIServer) httpTablesHandler(w http.ResponseWriter, r *http.Request) {
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "list", err)
 		return
@@ -506,20 +726,18 @@ func (api *APIServer) getTablesWithSkip(tables []clickhouse.Table) []clickhouse.
 // ??? INSERT INTO system.backup_list (name,location) VALUES ('backup_name', 'remote') - upload backup
 // ??? INSERT INTO system.backup_list (name) VALUES ('backup_name') - create backup
 func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
-	type backupJSON struct {
-		Name           string `json:"name"`
-		Created        string `json:"created"`
-		Size           uint64 `json:"size,omitempty"`
-		Location       string `json:"location"`
-		RequiredBackup string `json:"required"`
-		Desc           string `json:"desc"`
-	}
 	backupsJSON := make([]backupJSON, 0)
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "list", err)
 		return
 	}
+	query := r.URL.Query()
+	filter, err := parseBackupListFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "list", err)
+		return
+	}
 	api.metrics.NumberBackupsRemoteExpected.Set(float64(cfg.General.BackupsToKeepRemote))
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
 	vars := mux.Vars(r)
@@ -546,12 +764,15 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 				Location:       "local",
 				RequiredBackup: b.RequiredBackup,
 				Desc:           description,
+				Tags:           b.GetTags(),
+				Description:    b.Description,
+				CreatedTime:    b.CreationDate,
 			})
 		}
 		api.metrics.NumberBackupsLocal.Set(float64(len(localBackups)))
 	}
 	if cfg.General.RemoteStorage != "none" && (where == "remote" || !wherePresent) {
-		remoteBackups, err := backup.GetRemoteBackups(cfg, true)
+		remoteBackups, err := backup.GetRemoteBackups(r.Context(), cfg, true)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "list", err)
 			return
@@ -571,24 +792,66 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 				Location:       "remote",
 				RequiredBackup: b.RequiredBackup,
 				Desc:           description,
+				Tags:           b.GetTags(),
+				Description:    b.Description,
+				CreatedTime:    b.CreationDate,
 			})
 			if i == len(remoteBackups)-1 {
 				api.metrics.LastBackupSizeRemote.Set(float64(b.DataSize + b.MetadataSize + b.ConfigSize + b.RBACSize))
+				api.metrics.LastBackupCompressedSizeRemote.Set(float64(b.CompressedSize))
 			}
 		}
 		api.metrics.NumberBackupsRemote.Set(float64(len(remoteBackups)))
 	}
-	sendJSONEachRow(w, http.StatusOK, backupsJSON)
+	page, matched := filter.apply(backupsJSON)
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(matched)))
+	if query.Get("format") == "json" {
+		result := backupListPage{
+			Total:   len(matched),
+			Offset:  filter.Offset,
+			Limit:   filter.Limit,
+			Backups: page,
+		}
+		for _, b := range matched {
+			if b.Location == "local" {
+				result.TotalLocal++
+			} else {
+				result.TotalRemote++
+			}
+		}
+		sendJSONEachRow(w, http.StatusOK, result)
+		return
+	}
+	sendJSONEachRow(w, http.StatusOK, page)
 }
 
-// httpCreateHandler - create a backup
-func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && api.status.inProgress() {
-		apexLog.Info(ErrAPILocked.Error())
-		writeError(w, http.StatusLocked, "create", ErrAPILocked)
+// httpListTablesHandler - display databases, tables and partitions of one backup, only metadata is read
+func (api *APIServer) httpListTablesHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.loadConfig()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "list", err)
 		return
 	}
-	cfg, err := config.LoadConfig(api.configPath)
+	vars := mux.Vars(r)
+	if vars["where"] != "local" && vars["where"] != "remote" {
+		writeError(w, http.StatusBadRequest, "list", fmt.Errorf("backup location must be 'local' or 'remote'"))
+		return
+	}
+	contents, err := backup.GetBackupContents(r.Context(), cfg, vars["name"], vars["where"] == "remote")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "list", err)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		sendJSONEachRow(w, http.StatusOK, contents)
+		return
+	}
+	sendJSONEachRow(w, http.StatusOK, contents.Tables)
+}
+
+// httpCreateHandler - create a backup
+func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "create", err)
 		return
@@ -597,7 +860,7 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
 	tablePattern := ""
 	partitionsToBackup := make([]string, 0)
-	backupName := backup.NewBackupName()
+	backupName := ""
 	schemaOnly := false
 	rbacOnly := false
 	configsOnly := false
@@ -629,48 +892,93 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 			fullCommand = fmt.Sprintf("%s --configs", fullCommand)
 		}
 	}
+	for _, tag := range query["tag"] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			writeError(w, http.StatusBadRequest, "create", fmt.Errorf("invalid tag '%s', expected format `key=value`", tag))
+			return
+		}
+		if cfg.General.BackupTags == nil {
+			cfg.General.BackupTags = map[string]string{}
+		}
+		cfg.General.BackupTags[kv[0]] = kv[1]
+		fullCommand = fmt.Sprintf("%s --tag=\"%s\"", fullCommand, tag)
+	}
+	if description, exist := query["description"]; exist {
+		cfg.General.BackupDescription = description[0]
+		fullCommand = fmt.Sprintf("%s --description=\"%s\"", fullCommand, description[0])
+	}
+	if err := config.ValidateConfig(cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "create", err)
+		return
+	}
 	if name, exist := query["name"]; exist {
 		backupName = name[0]
-		fullCommand = fmt.Sprintf("%s %s", fullCommand, backupName)
 	}
+	if backupName, err = backup.ResolveBackupName(cfg, backupName); err != nil {
+		writeError(w, http.StatusInternalServerError, "create", err)
+		return
+	}
+	fullCommand = fmt.Sprintf("%s %s", fullCommand, backupName)
 
+	commandId, err := api.status.tryStart("create", fullCommand, api.currentConfig().API)
+	if err != nil {
+		api.metrics.Reject("create")
+		writeOperationStartError(w, "create", err)
+		return
+	}
 	go func() {
-		commandId := api.status.start(fullCommand)
-		start := time.Now()
-		api.metrics.LastStart["create"].Set(float64(start.Unix()))
-		defer func() {
-			api.metrics.LastDuration["create"].Set(float64(time.Since(start).Nanoseconds()))
-			api.metrics.LastFinish["create"].Set(float64(time.Now().Unix()))
-		}()
-		err := backup.CreateBackup(cfg, backupName, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, api.clickhouseBackupVersion)
+		start := api.metrics.Start("create")
+		run := metrics.StartCommand("create")
+		err := backup.CreateBackup(api.ctx, cfg, backupName, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, api.clickhouseBackupVersion)
 		defer api.status.stop(commandId, err)
+		if backup.IsBackupSkipped(err) {
+			apexLog.Info(err.Error())
+			api.metrics.Finish("create", start, nil)
+			if sendErr := run.Finish(cfg, nil); sendErr != nil {
+				apexLog.Warn(sendErr.Error())
+			}
+			return
+		}
+		api.metrics.Finish("create", start, err)
+		if sendErr := run.Finish(cfg, err); sendErr != nil {
+			apexLog.Warn(sendErr.Error())
+		}
+		errtracker.Capture(cfg, "create", backupName, err)
 		if err != nil {
-			api.metrics.FailedCounter["create"].Inc()
-			api.metrics.LastStatus["create"].Set(0)
 			apexLog.Errorf("CreateBackup error: %+v\n", err)
 			return
 		}
 		if err := api.updateBackupMetrics(true); err != nil {
 			apexLog.Errorf("updateBackupMetrics return error: %v", err)
 		}
-		api.metrics.SuccessfulCounter["create"].Inc()
-		api.metrics.LastStatus["create"].Set(1)
 	}()
 	sendJSONEachRow(w, http.StatusCreated, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "create",
-		BackupName: backupName,
+		Status:      "acknowledged",
+		Operation:   "create",
+		OperationId: commandId,
+		BackupName:  backupName,
 	})
 }
 
-// httpCleanHandler - clean ./shadow directory
+// httpCleanHandler - clean ./shadow directory and incomplete local backups
 func (api *APIServer) httpCleanHandler(w http.ResponseWriter, _ *http.Request) {
-	commandId := api.status.start("clean")
-	err := backup.Clean(api.config)
+	cfg := api.currentConfig()
+	commandId, err := api.status.tryStart("clean", "clean", cfg.API)
+	if err != nil {
+		api.metrics.Reject("clean")
+		writeOperationStartError(w, "clean", err)
+		return
+	}
+	err = backup.Clean(cfg)
+	if err == nil {
+		err = backup.CleanBroken(cfg)
+	}
 	api.status.stop(commandId, err)
 	if err != nil {
 		log.Printf("Clean error: %+v\n", err)
@@ -688,12 +996,7 @@ func (api *APIServer) httpCleanHandler(w http.ResponseWriter, _ *http.Request) {
 
 // httpUploadHandler - upload a backup to remote storage
 func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && api.status.inProgress() {
-		apexLog.Info(ErrAPILocked.Error())
-		writeError(w, http.StatusLocked, "upload", ErrAPILocked)
-		return
-	}
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "upload", err)
 		return
@@ -708,6 +1011,7 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 	tablePattern := ""
 	partitionsToBackup := make([]string, 0)
 	schemaOnly := false
+	resume := false
 	fullCommand := "upload"
 
 	if df, exist := query["diff-from"]; exist {
@@ -730,23 +1034,31 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		schemaOnly, _ = strconv.ParseBool(schema[0])
 		fullCommand += " --schema"
 	}
+	if _, exist := query["resume"]; exist {
+		resume = true
+		fullCommand += " --resume"
+	}
 	fullCommand = fmt.Sprint(fullCommand, " ", name)
 
+	commandId, err := api.status.tryStart("upload", fullCommand, api.currentConfig().API)
+	if err != nil {
+		api.metrics.Reject("upload")
+		writeOperationStartError(w, "upload", err)
+		return
+	}
 	go func() {
-		commandId := api.status.start(fullCommand)
-		start := time.Now()
-		api.metrics.LastStart["upload"].Set(float64(start.Unix()))
-		defer func() {
-			api.metrics.LastDuration["upload"].Set(float64(time.Since(start).Nanoseconds()))
-			api.metrics.LastFinish["upload"].Set(float64(time.Now().Unix()))
-		}()
+		start := api.metrics.Start("upload")
+		run := metrics.StartCommand("upload")
 		b := backup.NewBackuper(cfg)
-		err := b.Upload(name, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly)
+		err := b.Upload(api.ctx, name, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, resume)
 		api.status.stop(commandId, err)
+		api.metrics.Finish("upload", start, err)
+		if sendErr := run.Finish(cfg, err); sendErr != nil {
+			apexLog.Warn(sendErr.Error())
+		}
+		errtracker.Capture(cfg, "upload", name, err)
 		if err != nil {
 			apexLog.Errorf("Upload error: %+v\n", err)
-			api.metrics.FailedCounter["upload"].Inc()
-			api.metrics.LastStatus["upload"].Set(0)
 			return
 		}
 		go func() {
@@ -754,32 +1066,47 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 				apexLog.Errorf("updateBackupMetrics return error: %v", err)
 			}
 		}()
-		api.metrics.SuccessfulCounter["upload"].Inc()
-		api.metrics.LastStatus["upload"].Set(1)
 	}()
 	sendJSONEachRow(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
-		BackupFrom string `json:"backup_from,omitempty"`
-		Diff       bool   `json:"diff"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
+		BackupFrom  string `json:"backup_from,omitempty"`
+		Diff        bool   `json:"diff"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "upload",
-		BackupName: name,
-		BackupFrom: diffFrom,
-		Diff:       diffFrom != "",
+		Status:      "acknowledged",
+		Operation:   "upload",
+		OperationId: commandId,
+		BackupName:  name,
+		BackupFrom:  diffFrom,
+		Diff:        diffFrom != "",
 	})
 }
 
+// restoreBackup - replaced in tests to check config passed to restore
+var restoreBackup = backup.Restore
+
+// restoreRequest - optional JSON body for POST /backup/restore/{name}, query parameters with the same names take precedence
+type restoreRequest struct {
+	Tables                  string            `json:"table"`
+	Partitions              []string          `json:"partitions"`
+	SchemaOnly              bool              `json:"schema"`
+	DataOnly                bool              `json:"data"`
+	DropTable               bool              `json:"drop"`
+	RBACOnly                bool              `json:"rbac"`
+	ConfigsOnly             bool              `json:"configs"`
+	DryRun                  bool              `json:"dry_run"`
+	Validate                bool              `json:"validate"`
+	RestoreDatabaseMapping  map[string]string `json:"restore_database_mapping"`
+	RestoreTableMapping     map[string]string `json:"restore_table_mapping"`
+	RestoreReplicatedEngine string            `json:"restore_replicated_engine"`
+	OnCluster               string            `json:"on_cluster"`
+}
+
 // httpRestoreHandler - restore a backup from local storage
 func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && api.status.inProgress() {
-		apexLog.Info(ErrAPILocked.Error())
-		writeError(w, http.StatusLocked, "restore", ErrAPILocked)
-		return
-	}
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "restore", err)
 		return
@@ -787,90 +1114,166 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 	api.metrics.NumberBackupsRemoteExpected.Set(float64(cfg.General.BackupsToKeepRemote))
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
 	vars := mux.Vars(r)
-	tablePattern := ""
-	partitionsToBackup := make([]string, 0)
-	schemaOnly := false
-	dataOnly := false
-	dropTable := false
-	rbacOnly := false
-	configsOnly := false
-	fullCommand := "restore"
+	req := restoreRequest{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "restore", err)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "restore", fmt.Errorf("can't parse request body: %v", err))
+			return
+		}
+	}
 
 	query := r.URL.Query()
 	if tp, exist := query["table"]; exist {
-		tablePattern = tp[0]
-		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
+		req.Tables = tp[0]
 	}
 	if partitions, exist := query["partitions"]; exist {
-		partitionsToBackup = strings.Split(partitions[0], ",")
-		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, partitions)
+		req.Partitions = strings.Split(partitions[0], ",")
 	}
 	if _, exist := query["schema"]; exist {
-		schemaOnly = true
-		fullCommand += " --schema"
+		req.SchemaOnly = true
 	}
 	if _, exist := query["data"]; exist {
-		dataOnly = true
-		fullCommand += " --data"
+		req.DataOnly = true
 	}
 	if _, exist := query["drop"]; exist {
-		dropTable = true
-		fullCommand += " --drop"
+		req.DropTable = true
 	}
 	if _, exist := query["rm"]; exist {
-		dropTable = true
-		fullCommand += " --rm"
+		req.DropTable = true
 	}
 	if _, exist := query["rbac"]; exist {
-		rbacOnly = true
-		fullCommand += " --rbac"
+		req.RBACOnly = true
 	}
 	if _, exist := query["configs"]; exist {
-		configsOnly = true
+		req.ConfigsOnly = true
+	}
+	if _, exist := query["dry_run"]; exist {
+		req.DryRun = true
+	}
+	if _, exist := query["validate"]; exist {
+		req.Validate = true
+	}
+	if req.Validate {
+		cfg.General.RestoreValidate = true
+	}
+	if mapping, exist := query["restore_database_mapping"]; exist {
+		if req.RestoreDatabaseMapping, err = config.ParseMapping(mapping[0]); err != nil {
+			writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+	}
+	if mapping, exist := query["restore_table_mapping"]; exist {
+		if req.RestoreTableMapping, err = config.ParseMapping(mapping[0]); err != nil {
+			writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+	}
+	if engine, exist := query["restore_replicated_engine"]; exist {
+		req.RestoreReplicatedEngine = engine[0]
+	}
+	if req.RestoreReplicatedEngine != "" {
+		cfg.General.RestoreReplicatedEngine = req.RestoreReplicatedEngine
+	}
+	if cluster, exist := query["on_cluster"]; exist {
+		req.OnCluster = cluster[0]
+	}
+	if req.OnCluster != "" {
+		cfg.General.RestoreSchemaOnCluster = req.OnCluster
+	}
+	if len(req.RestoreDatabaseMapping) > 0 {
+		cfg.General.RestoreDatabaseMapping = req.RestoreDatabaseMapping
+	}
+	if len(req.RestoreTableMapping) > 0 {
+		cfg.General.RestoreTableMapping = req.RestoreTableMapping
+	}
+	if err := config.ValidateConfig(cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "restore", err)
+		return
+	}
+
+	fullCommand := "restore"
+	if req.Tables != "" {
+		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, req.Tables)
+	}
+	if len(req.Partitions) > 0 {
+		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, strings.Join(req.Partitions, ","))
+	}
+	if req.SchemaOnly {
+		fullCommand += " --schema"
+	}
+	if req.DataOnly {
+		fullCommand += " --data"
+	}
+	if req.DropTable {
+		fullCommand += " --drop"
+	}
+	if req.RBACOnly {
+		fullCommand += " --rbac"
+	}
+	if req.ConfigsOnly {
 		fullCommand += " --configs"
 	}
+	if req.Validate {
+		fullCommand += " --validate"
+	}
+	if len(req.RestoreDatabaseMapping) > 0 {
+		fullCommand = fmt.Sprintf("%s --restore-database-mapping=\"%s\"", fullCommand, formatMapping(req.RestoreDatabaseMapping))
+	}
+	if len(req.RestoreTableMapping) > 0 {
+		fullCommand = fmt.Sprintf("%s --restore-table-mapping=\"%s\"", fullCommand, formatMapping(req.RestoreTableMapping))
+	}
+	if req.RestoreReplicatedEngine != "" {
+		fullCommand = fmt.Sprintf("%s --restore-replicated-engine=\"%s\"", fullCommand, req.RestoreReplicatedEngine)
+	}
+	if req.OnCluster != "" {
+		fullCommand = fmt.Sprintf("%s --on-cluster=\"%s\"", fullCommand, req.OnCluster)
+	}
 
 	name := vars["name"]
 	fullCommand += fmt.Sprintf(" %s", name)
 
+	commandId, err := api.status.tryStart("restore", fullCommand, api.currentConfig().API)
+	if err != nil {
+		api.metrics.Reject("restore")
+		writeOperationStartError(w, "restore", err)
+		return
+	}
 	go func() {
-		commandId := api.status.start(fullCommand)
-		start := time.Now()
-		api.metrics.LastStart["restore"].Set(float64(start.Unix()))
-		defer func() {
-			api.metrics.LastDuration["restore"].Set(float64(time.Since(start).Nanoseconds()))
-			api.metrics.LastFinish["restore"].Set(float64(time.Now().Unix()))
-		}()
-		err := backup.Restore(cfg, name, tablePattern, partitionsToBackup, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly)
+		start := api.metrics.Start("restore")
+		run := metrics.StartCommand("restore")
+		err := restoreBackup(api.ctx, cfg, name, req.Tables, req.Partitions, req.SchemaOnly, req.DataOnly, req.DropTable, req.RBACOnly, req.ConfigsOnly, req.DryRun)
 		api.status.stop(commandId, err)
+		api.metrics.Finish("restore", start, err)
+		if sendErr := run.Finish(cfg, err); sendErr != nil {
+			apexLog.Warn(sendErr.Error())
+		}
+		errtracker.Capture(cfg, "restore", name, err)
 		if err != nil {
-			apexLog.Errorf("Download error: %+v\n", err)
-			api.metrics.FailedCounter["restore"].Inc()
-			api.metrics.LastStatus["restore"].Set(0)
+			apexLog.Errorf("Restore error: %+v\n", err)
 			return
 		}
-		api.metrics.SuccessfulCounter["restore"].Inc()
-		api.metrics.LastStatus["restore"].Set(1)
 	}()
 	sendJSONEachRow(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "restore",
-		BackupName: name,
+		Status:      "acknowledged",
+		Operation:   "restore",
+		OperationId: commandId,
+		BackupName:  name,
 	})
 }
 
 // httpDownloadHandler - download a backup from remote to local storage
 func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && api.status.inProgress() {
-		apexLog.Info(ErrAPILocked.Error())
-		writeError(w, http.StatusLocked, "download", ErrAPILocked)
-		return
-	}
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "download", err)
 		return
@@ -883,6 +1286,7 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 	tablePattern := ""
 	partitionsToBackup := make([]string, 0)
 	schemaOnly := false
+	resume := false
 	fullCommand := "download"
 
 	if tp, exist := query["table"]; exist {
@@ -897,51 +1301,53 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		schemaOnly = true
 		fullCommand += " --schema"
 	}
+	if _, exist := query["resume"]; exist {
+		resume = true
+		fullCommand += " --resume"
+	}
 	fullCommand += fmt.Sprintf(" %s", name)
 
+	commandId, err := api.status.tryStart("download", fullCommand, api.currentConfig().API)
+	if err != nil {
+		api.metrics.Reject("download")
+		writeOperationStartError(w, "download", err)
+		return
+	}
 	go func() {
-		commandId := api.status.start(fullCommand)
-		start := time.Now()
-		api.metrics.LastStart["download"].Set(float64(start.Unix()))
-		defer func() {
-			api.metrics.LastDuration["download"].Set(float64(time.Since(start).Nanoseconds()))
-			api.metrics.LastFinish["download"].Set(float64(time.Now().Unix()))
-		}()
-
+		start := api.metrics.Start("download")
+		run := metrics.StartCommand("download")
 		b := backup.NewBackuper(cfg)
-		err := b.Download(name, tablePattern, partitionsToBackup, schemaOnly)
+		err := b.Download(api.ctx, name, tablePattern, partitionsToBackup, schemaOnly, resume)
 		api.status.stop(commandId, err)
+		api.metrics.Finish("download", start, err)
+		if sendErr := run.Finish(cfg, err); sendErr != nil {
+			apexLog.Warn(sendErr.Error())
+		}
+		errtracker.Capture(cfg, "download", name, err)
 		if err != nil {
 			apexLog.Errorf("Download error: %+v\n", err)
-			api.metrics.FailedCounter["download"].Inc()
-			api.metrics.LastStatus["download"].Set(0)
 			return
 		}
 		if err := api.updateBackupMetrics(true); err != nil {
 			apexLog.Errorf("updateBackupMetrics return error: %v", err)
 		}
-		api.metrics.SuccessfulCounter["download"].Inc()
-		api.metrics.LastStatus["download"].Set(1)
 	}()
 	sendJSONEachRow(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "download",
-		BackupName: name,
+		Status:      "acknowledged",
+		Operation:   "download",
+		OperationId: commandId,
+		BackupName:  name,
 	})
 }
 
 // httpDeleteHandler - delete a backup from local or remote storage
 func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && api.status.inProgress() {
-		apexLog.Info(ErrAPILocked.Error())
-		writeError(w, http.StatusLocked, "delete", ErrAPILocked)
-		return
-	}
-	cfg, err := config.LoadConfig(api.configPath)
+	cfg, err := api.loadConfig()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "delete", err)
 		return
@@ -950,17 +1356,23 @@ func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request)
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
 	vars := mux.Vars(r)
 	fullCommand := fmt.Sprintf("delete %s %s", vars["where"], vars["name"])
-	commandId := api.status.start(fullCommand)
-
+	commandId, err := api.status.tryStart("delete", fullCommand, api.currentConfig().API)
+	if err != nil {
+		api.metrics.Reject("delete")
+		writeOperationStartError(w, "delete", err)
+		return
+	}
+	start := api.metrics.Start("delete")
 	switch vars["where"] {
 	case "local":
 		err = backup.RemoveBackupLocal(cfg, vars["name"], nil)
 	case "remote":
-		err = backup.RemoveBackupRemote(cfg, vars["name"])
+		err = backup.RemoveBackupRemote(r.Context(), cfg, vars["name"])
 	default:
 		err = fmt.Errorf("backup location must be 'local' or 'remote'")
 	}
 	api.status.stop(commandId, err)
+	api.metrics.Finish("delete", start, err)
 	if err != nil {
 		apexLog.Errorf("delete backup error: %+v\n", err)
 		writeError(w, http.StatusInternalServerError, "delete", err)
@@ -995,20 +1407,21 @@ func (api *APIServer) updateBackupMetrics(onlyLocal bool) error {
 	numberBackupsLocal := 0
 	numberBackupsRemote := 0
 
+	cfg := api.currentConfig()
 	apexLog.Infof("Update backup metrics start (onlyLocal=%v)", onlyLocal)
 	defer func() {
 		apexLog.WithFields(apexLog.Fields{
-			"duration":             utils.HumanizeDuration(time.Since(startTime)),
+			"duration":             utils.LogDuration(time.Since(startTime)),
 			"LastBackupSizeRemote": lastSizeRemote,
 			"LastBackupSizeLocal":  lastSizeLocal,
 			"NumberBackupsLocal":   numberBackupsLocal,
 			"NumberBackupsRemote":  numberBackupsRemote,
 		}).Info("Update backup metrics finish")
 	}()
-	if !api.config.API.EnableMetrics {
+	if !cfg.API.EnableMetrics {
 		return nil
 	}
-	localBackups, _, err := backup.GetLocalBackups(api.config, nil)
+	localBackups, _, err := backup.GetLocalBackups(cfg, nil)
 	if err != nil {
 		return err
 	}
@@ -1022,10 +1435,10 @@ func (api *APIServer) updateBackupMetrics(onlyLocal bool) error {
 		api.metrics.LastBackupSizeLocal.Set(0)
 		api.metrics.NumberBackupsLocal.Set(0)
 	}
-	if api.config.General.RemoteStorage == "none" || onlyLocal {
+	if cfg.General.RemoteStorage == "none" || onlyLocal {
 		return nil
 	}
-	remoteBackups, err := backup.GetRemoteBackups(api.config, false)
+	remoteBackups, err := backup.GetRemoteBackups(api.ctx, cfg, false)
 	if err != nil {
 		return err
 	}
@@ -1034,14 +1447,74 @@ func (api *APIServer) updateBackupMetrics(onlyLocal bool) error {
 		lastBackup := remoteBackups[numberBackupsRemote-1]
 		lastSizeRemote = lastBackup.DataSize + lastBackup.MetadataSize + lastBackup.ConfigSize + lastBackup.RBACSize
 		api.metrics.LastBackupSizeRemote.Set(float64(lastSizeRemote))
+		api.metrics.LastBackupCompressedSizeRemote.Set(float64(lastBackup.CompressedSize))
 		api.metrics.NumberBackupsRemote.Set(float64(numberBackupsRemote))
 	} else {
 		api.metrics.LastBackupSizeRemote.Set(0)
+		api.metrics.LastBackupCompressedSizeRemote.Set(0)
 		api.metrics.NumberBackupsRemote.Set(0)
 	}
+	byAge := map[string]int{}
+	for _, b := range remoteBackups {
+		created := b.CreationDate
+		if created.IsZero() {
+			created = b.UploadDate
+		}
+		byAge[backupAgeBucket(time.Since(created))]++
+	}
+	api.metrics.NumberBackupsRemoteByAge.Reset()
+	for _, bucket := range remoteBackupAgeBuckets {
+		api.metrics.NumberBackupsRemoteByAge.WithLabelValues(cfg.General.RemoteStorage, bucket.label).Set(float64(byAge[bucket.label]))
+	}
+	api.metrics.NumberBackupsRemoteByAge.WithLabelValues(cfg.General.RemoteStorage, "older").Set(float64(byAge["older"]))
+	return nil
+}
+
+// updateRemoteUsageMetrics - walk all objects on remote storage and update remote_storage_size_bytes, remote_storage_objects
+func (api *APIServer) updateRemoteUsageMetrics() error {
+	cfg := api.currentConfig()
+	if !cfg.API.EnableMetrics || cfg.General.RemoteStorage == "none" {
+		return nil
+	}
+	startTime := time.Now()
+	usage, err := backup.GetRemoteStorageUsage(api.ctx, cfg)
+	if err != nil {
+		return err
+	}
+	api.metrics.RemoteStorageSize.Reset()
+	api.metrics.RemoteStorageObjects.Reset()
+	api.metrics.RemoteStorageSize.WithLabelValues(cfg.General.RemoteStorage).Set(float64(usage.Bytes))
+	api.metrics.RemoteStorageObjects.WithLabelValues(cfg.General.RemoteStorage).Set(float64(usage.Objects))
+	api.metrics.RemoteStorageUsageLastUpdate.Set(float64(time.Now().Unix()))
+	apexLog.WithFields(apexLog.Fields{
+		"duration": utils.LogDuration(time.Since(startTime)),
+		"size":     utils.LogBytes(usage.Bytes),
+		"objects":  usage.Objects,
+	}).Info("Update remote storage usage metrics finish")
 	return nil
 }
 
+// watchRemoteUsage - periodically update remote storage usage and backups age metrics, api.remote_usage_interval re-read after each iteration
+func (api *APIServer) watchRemoteUsage() {
+	for first := true; ; first = false {
+		interval, err := time.ParseDuration(api.currentConfig().API.RemoteUsageInterval)
+		if err != nil || interval <= 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		if err := api.updateRemoteUsageMetrics(); err != nil {
+			apexLog.Errorf("updateRemoteUsageMetrics return error: %v", err)
+		}
+		// backup metrics already updated during server start
+		if !first {
+			if err := api.updateBackupMetrics(false); err != nil {
+				apexLog.Errorf("updateBackupMetrics return error: %v", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
 func registerMetricsHandlers(r *mux.Router, enableMetrics bool, enablePprof bool) {
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		sendJSONEachRow(w, http.StatusOK, struct {
@@ -1066,160 +1539,31 @@ func registerMetricsHandlers(r *mux.Router, enableMetrics bool, enablePprof bool
 	}
 }
 
-type Metrics struct {
-	SuccessfulCounter map[string]prometheus.Counter
-	FailedCounter     map[string]prometheus.Counter
-	LastStart         map[string]prometheus.Gauge
-	LastFinish        map[string]prometheus.Gauge
-	LastDuration      map[string]prometheus.Gauge
-	LastStatus        map[string]prometheus.Gauge
-
-	LastBackupSizeLocal         prometheus.Gauge
-	LastBackupSizeRemote        prometheus.Gauge
-	NumberBackupsRemote         prometheus.Gauge
-	NumberBackupsLocal          prometheus.Gauge
-	NumberBackupsRemoteExpected prometheus.Gauge
-	NumberBackupsLocalExpected  prometheus.Gauge
-}
-
-// setupMetrics - resister prometheus metrics
-func setupMetrics() Metrics {
-	m := Metrics{}
-	successfulCounter := map[string]prometheus.Counter{}
-	failedCounter := map[string]prometheus.Counter{}
-	lastStart := map[string]prometheus.Gauge{}
-	lastFinish := map[string]prometheus.Gauge{}
-	lastDuration := map[string]prometheus.Gauge{}
-	lastStatus := map[string]prometheus.Gauge{}
-
-	for _, command := range []string{"create", "upload", "download", "restore", "create_remote", "restore_remote"} {
-		successfulCounter[command] = prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: "clickhouse_backup",
-			Name:      fmt.Sprintf("successful_%ss", command),
-			Help:      fmt.Sprintf("Counter of successful %ss backup", command),
-		})
-		failedCounter[command] = prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: "clickhouse_backup",
-			Name:      fmt.Sprintf("failed_%ss", command),
-			Help:      fmt.Sprintf("Counter of failed %ss backup", command),
-		})
-		lastStart[command] = prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "clickhouse_backup",
-			Name:      fmt.Sprintf("last_%s_start", command),
-			Help:      fmt.Sprintf("Last backup %s start timestamp", command),
-		})
-		lastFinish[command] = prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "clickhouse_backup",
-			Name:      fmt.Sprintf("last_%s_finish", command),
-			Help:      fmt.Sprintf("Last backup %s finish timestamp", command),
-		})
-		lastDuration[command] = prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "clickhouse_backup",
-			Name:      fmt.Sprintf("last_%s_duration", command),
-			Help:      fmt.Sprintf("Backup %s duration in nanoseconds", command),
-		})
-		lastStatus[command] = prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "clickhouse_backup",
-			Name:      fmt.Sprintf("last_%s_status", command),
-			Help:      fmt.Sprintf("Last backup %s status: 0=failed, 1=success, 2=unknown", command),
-		})
-	}
-
-	m.SuccessfulCounter = successfulCounter
-	m.FailedCounter = failedCounter
-	m.LastStart = lastStart
-	m.LastFinish = lastFinish
-	m.LastDuration = lastDuration
-	m.LastStatus = lastStatus
-
-	m.LastBackupSizeLocal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "clickhouse_backup",
-		Name:      "last_backup_size_local",
-		Help:      "Last local backup size in bytes",
-	})
-	m.LastBackupSizeRemote = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "clickhouse_backup",
-		Name:      "last_backup_size_remote",
-		Help:      "Last remote backup size in bytes",
-	})
-
-	m.NumberBackupsRemote = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "clickhouse_backup",
-		Name:      "number_backups_remote",
-		Help:      "Number of stored remote backups",
-	})
-
-	m.NumberBackupsRemoteExpected = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "clickhouse_backup",
-		Name:      "number_backups_remote_expected",
-		Help:      "How many backups expected on remote storage",
-	})
-
-	m.NumberBackupsLocal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "clickhouse_backup",
-		Name:      "number_backups_local",
-		Help:      "Number of stored local backups",
-	})
-
-	m.NumberBackupsLocalExpected = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "clickhouse_backup",
-		Name:      "number_backups_local_expected",
-		Help:      "How many backups expected on local storage",
-	})
-
-	for _, command := range []string{"create", "upload", "download", "restore", "create_remote", "restore_remote"} {
-		prometheus.MustRegister(
-			m.SuccessfulCounter[command],
-			m.FailedCounter[command],
-			m.LastStart[command],
-			m.LastFinish[command],
-			m.LastDuration[command],
-			m.LastStatus[command],
-		)
-	}
-
-	prometheus.MustRegister(
-		m.LastBackupSizeLocal,
-		m.LastBackupSizeRemote,
-		m.NumberBackupsRemote,
-		m.NumberBackupsLocal,
-		m.NumberBackupsRemoteExpected,
-		m.NumberBackupsLocalExpected,
-	)
-	m.LastStatus["create"].Set(2) // 0=failed, 1=success, 2=unknown
-	m.LastStatus["upload"].Set(2)
-	m.LastStatus["download"].Set(2)
-	m.LastStatus["restore"].Set(2)
-	m.LastStatus["create_remote"].Set(2)
-	m.LastStatus["restore_remote"].Set(2)
-
-	return m
-}
-
 func (api *APIServer) CreateIntegrationTables() error {
+	cfg := api.currentConfig()
 	apexLog.Infof("Create integration tables")
 	ch := &clickhouse.ClickHouse{
-		Config: &api.config.ClickHouse,
+		Config: &cfg.ClickHouse,
 	}
 	if err := ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %w", err)
 	}
 	defer ch.Close()
-	port := strings.Split(api.config.API.ListenAddr, ":")[1]
+	port := strings.Split(cfg.API.ListenAddr, ":")[1]
 	auth := ""
-	if api.config.API.Username != "" || api.config.API.Password != "" {
+	if cfg.API.Username != "" || cfg.API.Password != "" {
 		params := url.Values{}
-		params.Add("user", api.config.API.Username)
-		params.Add("pass", api.config.API.Password)
+		params.Add("user", cfg.API.Username)
+		params.Add("pass", cfg.API.Password)
 		auth = fmt.Sprintf("?%s", params.Encode())
 	}
 	schema := "http"
-	if api.config.API.Secure {
+	if cfg.API.Secure {
 		schema = "https"
 	}
 	host := "127.0.0.1"
-	if api.config.API.IntegrationTablesHost != "" {
-		host = api.config.API.IntegrationTablesHost
+	if cfg.API.IntegrationTablesHost != "" {
+		host = cfg.API.IntegrationTablesHost
 	}
 	settings := ""
 	version, err := ch.GetVersion()
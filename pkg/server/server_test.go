@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMetrics - prometheus collectors are registered globally, so all tests share one Metrics
+var testMetrics = setupMetrics()
+
+func TestAsyncStatusTryStart(t *testing.T) {
+	status := &AsyncStatus{}
+	apiConfig := config.APIConfig{AllowParallel: false}
+	id, err := status.tryStart("create", "create backup1", apiConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, id)
+	_, err = status.tryStart("upload", "upload backup1", apiConfig)
+	assert.True(t, errors.Is(err, ErrAPILocked))
+
+	apiConfig = config.APIConfig{AllowParallel: true, MaxConcurrentOperations: map[string]int{"create": 1, "all": 2}}
+	_, err = status.tryStart("create", "create backup2", apiConfig)
+	assert.True(t, errors.Is(err, ErrAPIConcurrencyLimit))
+	id, err = status.tryStart("upload", "upload backup1", apiConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+	_, err = status.tryStart("download", "download backup3", apiConfig)
+	assert.True(t, errors.Is(err, ErrAPIConcurrencyLimit))
+
+	status.stop(0, nil)
+	id, err = status.tryStart("create", "create backup2", apiConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+
+	_, err = status.tryStart("unknown", "unknown backup2", config.APIConfig{AllowParallel: true})
+	assert.EqualError(t, err, "unknown operation 'unknown'")
+	apiConfig = config.APIConfig{AllowParallel: true, MaxConcurrentOperations: map[string]int{"verify": 1}}
+	_, err = status.tryStart("verify", "verify backup1", apiConfig)
+	assert.NoError(t, err)
+	_, err = status.tryStart("verify", "verify backup2", apiConfig)
+	assert.True(t, errors.Is(err, ErrAPIConcurrencyLimit))
+}
+
+func TestReload(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.yml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte("general:\n  backups_to_keep_remote: 3\napi:\n  listen: localhost:7272\n"), 0640))
+	api := &APIServer{configPath: configPath, metrics: testMetrics}
+	api.state.Store(&apiState{config: config.DefaultConfig()})
+	require.NoError(t, api.Reload())
+	assert.Equal(t, 3, api.currentConfig().General.BackupsToKeepRemote)
+	// listen socket is not reopened by reload
+	assert.Equal(t, "localhost:7171", api.currentConfig().API.ListenAddr)
+	state := api.currentState()
+	assert.NotNil(t, state.router)
+	assert.Contains(t, state.routes, "/reload")
+	assert.NotNil(t, state.openAPISpec)
+
+	require.NoError(t, ioutil.WriteFile(configPath, []byte("general:\n  backups_to_keep_remotee: 1\n"), 0640))
+	assert.Error(t, api.Reload())
+	assert.Same(t, state, api.currentState(), "failed reload keeps previous config, router and specification")
+}
+
+func TestReloadWhileServing(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.yml")
+	configs := [][]byte{
+		[]byte("general:\n  backups_to_keep_remote: 1\napi:\n  username: user\n  password: pass\n  enable_swagger: true\n"),
+		[]byte("general:\n  backups_to_keep_remote: 2\napi:\n  username: user\n  password: pass\n  rate_limit: 100000\n  rate_limit_burst: 100000\n"),
+	}
+	require.NoError(t, ioutil.WriteFile(configPath, configs[0], 0640))
+	api := &APIServer{configPath: configPath, status: &AsyncStatus{}, metrics: testMetrics}
+	api.state.Store(&apiState{config: config.DefaultConfig()})
+	require.NoError(t, api.Reload())
+	server := httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	defer server.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, route := range []string{"/", "/openapi.json", "/backup/status", "/swagger"} {
+		wg.Add(1)
+		go func(route string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				req, err := http.NewRequest("GET", server.URL+route, nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				req.SetBasicAuth("user", "pass")
+				resp, err := http.DefaultClient.Do(req)
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, _ = ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				// swagger is enabled only by first config
+				assert.Contains(t, []int{http.StatusOK, http.StatusNotFound}, resp.StatusCode, route)
+			}
+		}(route)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			cfg := api.currentConfig()
+			assert.Contains(t, []int{1, 2}, cfg.General.BackupsToKeepRemote)
+			assert.Equal(t, "user", cfg.API.Username)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, ioutil.WriteFile(configPath, configs[i%2], 0640))
+		require.NoError(t, api.Reload())
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestCleanHandlerRespectRunningOperations(t *testing.T) {
+	cfg := config.DefaultConfig()
+	api := &APIServer{status: &AsyncStatus{}, metrics: testMetrics}
+	api.state.Store(&apiState{config: cfg})
+	_, err := api.status.tryStart("create", "create backup1", cfg.API)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.httpCleanHandler(w, httptest.NewRequest("POST", "/backup/clean", nil))
+	assert.Equal(t, http.StatusLocked, w.Code)
+
+	cfg.API.AllowParallel = true
+	cfg.API.MaxConcurrentOperations = map[string]int{"clean": 1}
+	_, err = api.status.tryStart("clean", "clean", cfg.API)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.httpCleanHandler(w, httptest.NewRequest("POST", "/backup/clean", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Len(t, api.status.commands, 2)
+}
+
+func TestRestoreHandlerOnCluster(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.yml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte("general:\n  restore_schema_on_cluster: from_config\n"), 0640))
+	restored := make(chan *config.Config, 1)
+	defer func(previous func(context.Context, *config.Config, string, string, []string, bool, bool, bool, bool, bool, bool) error) {
+		restoreBackup = previous
+	}(restoreBackup)
+	restoreBackup = func(ctx context.Context, cfg *config.Config, backupName string, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, rbacOnly, configsOnly, dryRun bool) error {
+		restored <- cfg
+		return nil
+	}
+	testData := []struct {
+		name     string
+		url      string
+		body     string
+		expected string
+	}{
+		{"query", "/backup/restore/backup1?on_cluster=query_cluster", "", "query_cluster"},
+		{"body", "/backup/restore/backup1", `{"on_cluster": "body_cluster"}`, "body_cluster"},
+		{"query over body", "/backup/restore/backup1?on_cluster=query_cluster", `{"on_cluster": "body_cluster"}`, "query_cluster"},
+		{"config", "/backup/restore/backup1", "", "from_config"},
+	}
+	for _, tt := range testData {
+		api := &APIServer{configPath: configPath, status: &AsyncStatus{}, metrics: testMetrics, ctx: context.Background()}
+		api.state.Store(&apiState{config: config.DefaultConfig()})
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", tt.url, strings.NewReader(tt.body)), map[string]string{"name": "backup1"})
+		api.httpRestoreHandler(w, r)
+		require.Equal(t, http.StatusOK, w.Code, tt.name)
+		assert.Equal(t, tt.expected, (<-restored).General.RestoreSchemaOnCluster, tt.name)
+		if tt.expected != "from_config" {
+			assert.Contains(t, api.status.commands[0].Command, `--on-cluster="`+tt.expected+`"`, tt.name)
+		}
+	}
+}
@@ -2,9 +2,14 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
+	"strings"
+
+	apexLog "github.com/apex/log"
 )
 
 func writeError(w http.ResponseWriter, statusCode int, operation string, err error) {
@@ -24,6 +29,16 @@ func writeError(w http.ResponseWriter, statusCode int, operation string, err err
 	fmt.Fprintln(w, string(out))
 }
 
+// writeOperationStartError - 423 Locked when `allow_parallel: false`, 409 Conflict when `max_concurrent_operations` exceeded
+func writeOperationStartError(w http.ResponseWriter, operation string, err error) {
+	apexLog.Info(err.Error())
+	statusCode := http.StatusConflict
+	if errors.Is(err, ErrAPILocked) {
+		statusCode = http.StatusLocked
+	}
+	writeError(w, statusCode, operation, err)
+}
+
 func sendJSONEachRow(w http.ResponseWriter, statusCode int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
@@ -40,3 +55,13 @@ func sendJSONEachRow(w http.ResponseWriter, statusCode int, v interface{}) {
 		fmt.Fprintln(w, string(out))
 	}
 }
+
+// formatMapping - format map into `src:dst` comma separated pairs with stable order
+func formatMapping(mapping map[string]string) string {
+	pairs := make([]string, 0, len(mapping))
+	for src, dst := range mapping {
+		pairs = append(pairs, src+":"+dst)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/mxalis/clickhouse-backup/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mxalis/clickhouse-backup"
+
+var (
+	initMutex   sync.Mutex
+	initialized bool
+)
+
+// Init - setup global OpenTelemetry tracer provider when tracing.endpoint defined
+// returned function flush and stop exporter, only first successful Init in process setup provider, next calls return no-op
+func Init(cfg *config.TracingConfig, version string) (func(), error) {
+	noop := func() {}
+	if cfg.Endpoint == "" {
+		return noop, nil
+	}
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	if initialized {
+		return noop, nil
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return noop, err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cfg.Endpoint),
+		otlptracehttp.WithURLPath(cfg.URLPath),
+		otlptracehttp.WithTimeout(timeout),
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return noop, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	initialized = true
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			apexLog.Warnf("can't flush tracing spans to %s: %v", cfg.Endpoint, err)
+		}
+		initMutex.Lock()
+		initialized = false
+		initMutex.Unlock()
+	}, nil
+}
+
+// Start - create new span, child of span from ctx if exists
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End - finish span and mark it as failed when err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Table - `table` span attribute
+func Table(database, table string) attribute.KeyValue {
+	return attribute.String("table", database+"."+table)
+}
+
+// Backup - `backup` span attribute
+func Backup(backupName string) attribute.KeyValue {
+	return attribute.String("backup", backupName)
+}
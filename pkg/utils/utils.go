@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"github.com/apex/log"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -53,3 +54,21 @@ func HumanizeDuration(d time.Duration) string {
 	}
 	return b.String()
 }
+
+// LogDuration - log field value, HumanizeDuration in text logs and seconds in JSON logs
+type LogDuration time.Duration
+
+func (d LogDuration) String() string {
+	return HumanizeDuration(time.Duration(d))
+}
+
+func (d LogDuration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(time.Duration(d).Seconds(), 'f', -1, 64)), nil
+}
+
+// LogBytes - log field value, FormatBytes in text logs and bytes count in JSON logs
+type LogBytes uint64
+
+func (b LogBytes) String() string {
+	return FormatBytes(uint64(b))
+}
@@ -1297,7 +1297,7 @@ func (ch *TestClickHouse) createTestSchema(data TestDataStruct) error {
 				return err
 			}
 		} else {
-			if err := ch.chbackend.CreateDatabase(data.Database); err != nil {
+			if err := ch.chbackend.CreateDatabase(data.Database, ""); err != nil {
 				return err
 			}
 		}